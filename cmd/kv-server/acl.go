@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shubhamc1947/safemap/pkg/concurrentmap"
+)
+
+// ----------- Access Control Lists -----------
+
+// aclKeyPrefix reserves the keyspace holding ACL rules, so they persist
+// with the data like API tokens do.
+const aclKeyPrefix = "__acl/"
+
+// aclRule lets a subject perform ops on keys starting with Prefix.
+// Subject is a principal name: a registry token's name, "jwt:<sub>",
+// "auth-token", "anonymous", or "*" for anyone.
+type aclRule struct {
+	ID        string    `json:"id"`
+	Subject   string    `json:"subject"`
+	Prefix    string    `json:"prefix"`
+	Ops       []string  `json:"ops"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (r aclRule) matches(subject, key, op string) bool {
+	return (r.Subject == "*" || r.Subject == subject) &&
+		strings.HasPrefix(key, r.Prefix) &&
+		slices.Contains(r.Ops, op)
+}
+
+var (
+	errInvalidACLOps     = errors.New("ops must be read and/or write")
+	errInvalidACLSubject = errors.New("subject is required")
+)
+
+// aclRegistry stores rules in the KV store and keeps a copy in memory, so
+// checking a request does not scan the keyspace.
+type aclRegistry struct {
+	store *concurrentmap.ConcurrentMap[string, StoredValue]
+
+	mu    sync.RWMutex
+	rules []aclRule
+}
+
+// reload rebuilds the in-memory rules from the store; call it after
+// persisted data is loaded.
+func (a *aclRegistry) reload() {
+	var rules []aclRule
+	a.store.Range(func(key string, v StoredValue) bool {
+		if strings.HasPrefix(key, aclKeyPrefix) {
+			var rule aclRule
+			if json.Unmarshal(v.Data, &rule) == nil {
+				rules = append(rules, rule)
+			}
+		}
+		return true
+	})
+	slices.SortFunc(rules, func(a, b aclRule) int { return a.CreatedAt.Compare(b.CreatedAt) })
+
+	a.mu.Lock()
+	a.rules = rules
+	a.mu.Unlock()
+}
+
+func (a *aclRegistry) list() []aclRule {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return slices.Clone(a.rules)
+}
+
+// allowed reports whether some rule grants subject op on key.
+func (a *aclRegistry) allowed(subject, key, op string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for _, rule := range a.rules {
+		if rule.matches(subject, key, op) {
+			return true
+		}
+	}
+	return false
+}
+
+// create adds a rule. A trailing "*" on prefix is accepted and dropped,
+// so "app1:*" and "app1:" mean the same.
+func (a *aclRegistry) create(subject, prefix string, ops []string) (aclRule, error) {
+	if subject == "" {
+		return aclRule{}, errInvalidACLSubject
+	}
+	if len(ops) == 0 {
+		return aclRule{}, errInvalidACLOps
+	}
+	for _, op := range ops {
+		if op != scopeRead && op != scopeWrite {
+			return aclRule{}, errInvalidACLOps
+		}
+	}
+
+	var id [6]byte
+	_, _ = rand.Read(id[:])
+	rule := aclRule{
+		ID:        hex.EncodeToString(id[:]),
+		Subject:   subject,
+		Prefix:    strings.TrimSuffix(prefix, "*"),
+		Ops:       slices.Compact(slices.Sorted(slices.Values(ops))),
+		CreatedAt: time.Now().UTC(),
+	}
+	data, _ := json.Marshal(rule)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.store.Set(aclKeyPrefix+rule.ID, StoredValue{Data: data})
+	a.rules = append(a.rules, rule)
+	return rule, nil
+}
+
+func (a *aclRegistry) delete(id string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	i := slices.IndexFunc(a.rules, func(r aclRule) bool { return r.ID == id })
+	if i < 0 {
+		return false
+	}
+	_ = a.store.DeleteErr(aclKeyPrefix + id)
+	a.rules = slices.Delete(a.rules, i, i+1)
+	return true
+}
+
+// aclTarget returns the key a request operates on and the op it needs,
+// or ok=false for requests ACLs do not cover. Keys in a namespace are
+// matched as "<ns>/<key>".
+func aclTarget(r *http.Request) (key, op string, ok bool) {
+	// The data type routes name one key; HLL merges and set algebra check
+	// the other keys they read themselves.
+	for _, typedKey := range []func(string) (string, string, bool){hllPath, listPath, setPath, zsetPath} {
+		if ns, key, ok := typedKey(r.URL.Path); ok {
+			key, _ = splitKeyAction(key)
+			if isReadMethod(r.Method) {
+				return aclKey(ns, key), scopeRead, true
+			}
+			return aclKey(ns, key), scopeWrite, true
+		}
+	}
+	ns, key, ok := kvPath(r.URL.Path)
+	if !ok || key == "" || isBatchRequest(r) {
+		return "", "", false // the batch endpoint checks each operation
+	}
+	if keyQuery(r) != "" {
+		key, _ = splitKeyAction(key)
+	}
+	if isReadMethod(r.Method) {
+		return aclKey(ns, key), scopeRead, true
+	}
+	if r.Method == http.MethodPost || r.Method == http.MethodPatch {
+		key, _ = splitKeyAction(key)
+	}
+	return aclKey(ns, key), scopeWrite, true
+}
+
+// aclKey is the name rules match key in namespace ns against.
+func aclKey(ns, key string) string {
+	if ns != "" {
+		return ns + "/" + key
+	}
+	return key
+}
+
+// ACL middleware: with --acl, key operations are denied unless a rule
+// allows them. Admin principals are not restricted.
+func (s *KVServer) aclMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, op, ok := aclTarget(r)
+		if !s.aclEnforced || !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		p, _ := principalFrom(r.Context())
+		if !p.has(scopeAdmin) && !s.acl.allowed(p.Name, key, op) {
+			s.metrics.Unauthorized.Add(1)
+			writeError(w, r, http.StatusForbidden, codeForbidden, "no ACL rule allows "+op+" on this key")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Admin: GET /admin/acl lists rules.
+func (s *KVServer) handleListACL(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"enforced": s.aclEnforced, "rules": s.acl.list()})
+}
+
+// Admin: POST /admin/acl {"subject": "team-a", "prefix": "app1:*",
+// "ops": ["read", "write"]} adds a rule.
+func (s *KVServer) handleCreateACL(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Subject string   `json:"subject"`
+		Prefix  string   `json:"prefix"`
+		Ops     []string `json:"ops"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidBody, "invalid body: "+err.Error())
+		return
+	}
+	rule, err := s.acl.create(req.Subject, req.Prefix, req.Ops)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+	if !s.persist(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(rule)
+}
+
+// Admin: DELETE /admin/acl/{id} removes a rule.
+func (s *KVServer) handleDeleteACL(w http.ResponseWriter, r *http.Request) {
+	if !s.acl.delete(r.PathValue("id")) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "no such rule")
+		return
+	}
+	if !s.persist(w, r) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestACLMiddleware(t *testing.T) {
+	s := newTestServer(t)
+	s.authToken = "root"
+	s.aclEnforced = true
+	teamA, _, err := s.tokens.create("team-a", []string{scopeRead, scopeWrite}, quota{})
+	if err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+	rule, err := s.acl.create("team-a", "app1:*", []string{scopeRead})
+	if err != nil {
+		t.Fatalf("create rule: %v", err)
+	}
+	if _, err := s.acl.create("*", "public:", []string{scopeRead, scopeWrite}); err != nil {
+		t.Fatalf("create rule: %v", err)
+	}
+	h := s.authMiddleware(s.aclMiddleware(okHandler))
+
+	tests := []struct {
+		method, path, key string
+		want              int
+	}{
+		{http.MethodGet, "/kv/app1:x", teamA, http.StatusOK},
+		{http.MethodPut, "/kv/app1:x", teamA, http.StatusForbidden},
+		{http.MethodGet, "/kv/app2:x", teamA, http.StatusForbidden},
+		{http.MethodPut, "/kv/public:x", teamA, http.StatusOK},
+		// Admin principals are not restricted.
+		{http.MethodPut, "/kv/app2:x", "root", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if w := serveAs(h, tt.method, tt.path, tt.key); w.Code != tt.want {
+			t.Errorf("%s %s: expected %d, got %d", tt.method, tt.path, tt.want, w.Code)
+		}
+	}
+
+	if !s.acl.delete(rule.ID) {
+		t.Fatalf("expected the rule to be deleted")
+	}
+	if w := serveAs(h, http.MethodGet, "/kv/app1:x", teamA); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 once the rule is gone, got %d", w.Code)
+	}
+
+	s.aclEnforced = false
+	if w := serveAs(h, http.MethodGet, "/kv/app2:x", teamA); w.Code != http.StatusOK {
+		t.Fatalf("expected rules to be ignored without --acl, got %d", w.Code)
+	}
+}
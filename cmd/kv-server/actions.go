@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ----------- Key Actions -----------
+
+// keyMethods are the methods of the /kv/{key} routes. POST is only
+// accepted with an action, as in POST /kv/{key}/incr.
+const keyMethods = "GET, HEAD, PUT, DELETE, OPTIONS"
+
+// splitKeyAction splits the path of POST /kv/{key}/{action} into the key
+// and the action.
+func splitKeyAction(path string) (key, action string) {
+	i := strings.LastIndexByte(path, '/')
+	if i < 0 {
+		return path, ""
+	}
+	return path[:i], path[i+1:]
+}
+
+// keyActions maps each action of POST /kv/{key}/{action} to its
+// operation.
+var keyActions = map[string]func(s *KVServer, w http.ResponseWriter, r *http.Request, key string, ns *namespace){
+	"incr":    (*KVServer).handleIncr,
+	"decr":    (*KVServer).handleDecr,
+	"append":  (*KVServer).handleAppend,
+	"getset":  (*KVServer).handleGetSet,
+	"expire":  (*KVServer).handleExpire,
+	"persist": (*KVServer).handlePersist,
+	"restore": (*KVServer).handleRestoreVersion,
+	"setbit":  (*KVServer).handleSetBit,
+}
+
+// keyQueries maps each query of GET /kv/{key}/{query} to its operation.
+var keyQueries = map[string]func(s *KVServer, w http.ResponseWriter, r *http.Request, key string, ns *namespace){
+	"ttl":      (*KVServer).handleTTL,
+	"versions": (*KVServer).handleVersions,
+	"json":     (*KVServer).handleJSONGet,
+	"getbit":   (*KVServer).handleGetBit,
+	"bitcount": (*KVServer).handleBitCount,
+}
+
+// keyQuery returns the query r makes, as in GET /kv/{key}/ttl, or "" for
+// any other request. Only a literal slash counts, so GET /kv/a%2Fttl
+// still reads the key "a/ttl".
+func keyQuery(r *http.Request) string {
+	_, key, ok := kvPath(r.URL.Path)
+	if !ok || r.Method != http.MethodGet {
+		return ""
+	}
+	_, query := splitKeyAction(key)
+	if keyQueries[query] == nil || !strings.HasSuffix(r.URL.EscapedPath(), "/"+query) {
+		return ""
+	}
+	return query
+}
+
+// handleKeyGet routes GET /kv/{key}/{query} to the query's operation, and
+// any other GET to handleGet.
+func (s *KVServer) handleKeyGet(w http.ResponseWriter, r *http.Request) {
+	query := keyQuery(r)
+	if query == "" {
+		s.keyHandler(s.handleGet)(w, r)
+		return
+	}
+	key, _ := splitKeyAction(r.PathValue("key"))
+	r.SetPathValue("key", key)
+	op := keyQueries[query]
+	s.keyHandler(func(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+		op(s, w, r, key, ns)
+	})(w, r)
+}
+
+// handleKeyAction routes POST /kv/{key}/{action} (and its namespaced
+// form) to the action's operation.
+func (s *KVServer) handleKeyAction(w http.ResponseWriter, r *http.Request) {
+	key, action := splitKeyAction(r.PathValue("key"))
+	op, ok := keyActions[action]
+	if !ok {
+		allowMethods(keyMethods)(w, r)
+		return
+	}
+	r.SetPathValue("key", key)
+	s.keyHandler(func(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+		op(s, w, r, key, ns)
+	})(w, r)
+}
+
+var (
+	errNotInteger = errors.New("value is not an integer")
+	errOverflow   = errors.New("increment would overflow")
+)
+
+// counterMaxLen is the longest an int64 is in decimal, sign included;
+// quotas are checked as if a counter were that long.
+const counterMaxLen = 20
+
+// INCR: POST /kv/{key}/incr {"delta": 5, "initial": 100} adds delta
+// (default 1) to the integer stored at key and returns the result:
+//
+//	{"value": 106}
+//
+// A missing or expired key counts as initial (default 0). The update is a
+// single atomic step on the store, so concurrent increments never lose
+// one. The key keeps its TTL.
+func (s *KVServer) handleIncr(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+	s.incr(w, r, key, ns, false)
+}
+
+// DECR: POST /kv/{key}/decr, INCR with the delta negated.
+func (s *KVServer) handleDecr(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+	s.incr(w, r, key, ns, true)
+}
+
+func (s *KVServer) incr(w http.ResponseWriter, r *http.Request, key string, ns *namespace, negate bool) {
+	s.metrics.TotalPuts.Add(1)
+	var req struct {
+		Delta   *int64 `json:"delta"`
+		Initial int64  `json:"initial"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidBody, "invalid body: "+err.Error())
+			return
+		}
+	}
+	delta := int64(1)
+	if req.Delta != nil {
+		delta = *req.Delta
+	}
+	if negate {
+		if delta == math.MinInt64 {
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, "delta out of range")
+			return
+		}
+		delta = -delta
+	}
+
+	p, _ := principalFrom(r.Context())
+	if serr := s.quotaError(p, ns, key, counterMaxLen); serr != nil {
+		serr.write(w, r)
+		return
+	}
+	if ns != nil {
+		ns.stats.puts.Add(1)
+	}
+
+	var stored StoredValue
+	_, sp := s.tracer.start(r.Context(), "store.incr", spanKindInternal)
+	version := s.nextVersion()
+	err := s.store.ComputeErr(key, func(old StoredValue, exists bool) (StoredValue, bool, error) {
+		now := time.Now()
+		n := req.Initial
+		v := StoredValue{Owner: p.TokenID, Version: version}
+		v.stamp(now)
+		if exists && !old.isExpired(now) {
+			var err error
+			if n, err = strconv.ParseInt(string(old.plain(s.valueKeys).Data), 10, 64); err != nil {
+				return old, exists, errNotInteger
+			}
+			v.HasTTL, v.ExpiresAt, v.ContentType, v.Lease, v.Flags, v.Tags = old.HasTTL, old.ExpiresAt, old.ContentType, old.Lease, old.Flags, old.Tags
+			v.inherit(old)
+		} else {
+			s.applyTTLPolicy(ns, &v, now)
+		}
+		if delta > 0 && n > math.MaxInt64-delta || delta < 0 && n < math.MinInt64-delta {
+			return old, exists, errOverflow
+		}
+		v.Data = strconv.AppendInt(nil, n+delta, 10)
+		s.encode(&v)
+		stored = v
+		return v, true, nil
+	})
+	sp.finish()
+	switch {
+	case errors.Is(err, errNotInteger):
+		writeError(w, r, http.StatusConflict, codeNotInteger, err.Error())
+		return
+	case errors.Is(err, errOverflow):
+		writeError(w, r, http.StatusConflict, codeOverflow, err.Error())
+		return
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	if !s.persist(w, r) {
+		return
+	}
+
+	n, _ := strconv.ParseInt(string(stored.plain(s.valueKeys).Data), 10, 64)
+	w.Header().Set("ETag", stored.etag())
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]int64{"value": n})
+}
+
+var (
+	errValueTooLarge = errors.New("value too large")
+	errValueSpilled  = errors.New("the value is spilled to disk and cannot be appended to")
+)
+
+// APPEND: POST /kv/{key}/append adds the body to the end of the value at
+// key, read as for PUT (the JSON envelope's "value", or raw bytes), and
+// returns the new size:
+//
+//	{"size": 11}
+//
+// An existing key keeps its TTL and content type; a missing one is
+// created as PUT would. The read and write are one atomic step, so
+// concurrent appends all land.
+func (s *KVServer) handleAppend(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+	s.metrics.TotalPuts.Add(1)
+	add, ok := s.readValue(w, r)
+	if !ok {
+		return
+	}
+
+	p, _ := principalFrom(r.Context())
+	size := int64(len(add.Data))
+	if cur, ok := s.store.Get(key); ok && !cur.isExpired(time.Now()) {
+		size += cur.size()
+	}
+	if serr := s.quotaError(p, ns, key, size); serr != nil {
+		serr.write(w, r)
+		return
+	}
+	if ns != nil {
+		ns.stats.puts.Add(1)
+	}
+
+	var stored StoredValue
+	_, sp := s.tracer.start(r.Context(), "store.append", spanKindInternal)
+	version := s.nextVersion()
+	err := s.store.ComputeErr(key, func(old StoredValue, exists bool) (StoredValue, bool, error) {
+		now := time.Now()
+		v := add
+		v.stamp(now)
+		if exists && !old.isExpired(now) {
+			if old.Spill != "" {
+				return old, exists, errValueSpilled
+			}
+			old, _ = s.whole(key, old)
+			v.Data = slices.Concat(old.plain(s.valueKeys).Data, add.Data)
+			v.HasTTL, v.ExpiresAt, v.ContentType, v.Lease, v.Flags, v.Tags = old.HasTTL, old.ExpiresAt, old.ContentType, old.Lease, old.Flags, old.Tags
+			v.inherit(old)
+		} else {
+			s.applyTTLPolicy(ns, &v, now)
+		}
+		if s.maxValueSize > 0 && int64(len(v.Data)) > s.maxValueSize {
+			return old, exists, errValueTooLarge
+		}
+		s.encode(&v)
+		v.Owner, v.Version = p.TokenID, version
+		stored = v
+		return v, true, nil
+	})
+	sp.finish()
+	switch {
+	case errors.Is(err, errValueTooLarge):
+		writeError(w, r, http.StatusRequestEntityTooLarge, codeValueTooLarge,
+			fmt.Sprintf("the value would exceed the %d byte limit", s.maxValueSize))
+		return
+	case errors.Is(err, errValueSpilled):
+		writeError(w, r, http.StatusConflict, codeValueSpilled, err.Error())
+		return
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	if !s.persist(w, r) {
+		return
+	}
+
+	w.Header().Set("ETag", stored.etag())
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]int{"size": int(stored.size())})
+}
+
+// GETSET: POST /kv/{key}/getset stores the body as PUT would and returns
+// the value it replaced, shaped as in batch results, or null:
+//
+//	{"previous": {"value": "old", "etag": "..."}}
+//
+// The swap is one atomic step: no other write can land in between.
+func (s *KVServer) handleGetSet(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+	s.metrics.TotalPuts.Add(1)
+	stored, ok := s.readValue(w, r)
+	if !ok {
+		return
+	}
+	p, _ := principalFrom(r.Context())
+	if serr := s.prepareValue(p, key, ns, &stored); serr != nil {
+		serr.write(w, r)
+		return
+	}
+
+	var previous *valueJSON
+	_, sp := s.tracer.start(r.Context(), "store.getset", spanKindInternal)
+	s.store.Compute(key, func(old StoredValue, exists bool) (StoredValue, bool) {
+		if exists && !old.isExpired(time.Now()) {
+			old, _ = s.whole(key, old)
+			v := newValueJSON(old, s.valueKeys)
+			previous = &v
+			stored.inherit(old)
+		}
+		return stored, true
+	})
+	sp.finish()
+	if !s.persist(w, r) {
+		return
+	}
+
+	w.Header().Set("ETag", stored.etag())
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]*valueJSON{"previous": previous})
+}
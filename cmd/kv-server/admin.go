@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// ----------- Admin: Flush, Config and Stats -----------
+
+// Admin: POST /admin/flush deletes every key, of the flat keyspace and
+// of every namespace; ?namespace=ns limits it to one namespace, like
+// POST /admin/namespaces/{ns}/flush. Server state (tokens, ACL rules,
+// namespace definitions, ...) is kept. Returns {"deleted": n}.
+func (s *KVServer) handleFlush(w http.ResponseWriter, r *http.Request) {
+	p, _ := principalFrom(r.Context())
+	name := r.URL.Query().Get("namespace")
+
+	var deleted int
+	if name != "" {
+		if _, ok := s.namespaces.get(name); !ok {
+			writeError(w, r, http.StatusNotFound, codeNamespaceNotFound, "no such namespace")
+			return
+		}
+		deleted = s.namespaces.flush(name)
+	} else {
+		deleted = s.flushFlatKeyspace(p)
+		for _, ns := range s.namespaces.list() {
+			deleted += s.namespaces.flush(ns.Name)
+		}
+	}
+	slog.InfoContext(r.Context(), "admin: flushed", "namespace", name, "keys", deleted)
+	if !s.persist(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]int{"deleted": deleted})
+}
+
+// Admin: GET /admin/config reports every setting as now in effect, after
+// flags, the config file and reloads, with secrets redacted, and which
+// settings a SIGHUP reload applies.
+func (s *KVServer) handleConfig(w http.ResponseWriter, r *http.Request) {
+	reloadable := make([]string, 0, len(reloadableFlags))
+	for name := range reloadableFlags {
+		reloadable = append(reloadable, name)
+	}
+	slices.Sort(reloadable)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"settings":   s.config.settings(),
+		"reloadable": reloadable,
+	})
+}
+
+// Admin: GET /admin/stats reports uptime, how keys are spread over the
+// shards, and the expiry counters.
+func (s *KVServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	lens := s.store.BucketLens()
+	total := 0
+	for _, n := range lens {
+		total += n
+	}
+	mean := float64(total) / float64(len(lens))
+	shards := map[string]any{
+		"count": len(lens),
+		"min":   slices.Min(lens),
+		"max":   slices.Max(lens),
+		"mean":  mean,
+		"keys":  lens, // by shard index
+	}
+	if mean > 0 {
+		shards["imbalance"] = float64(slices.Max(lens)) / mean // 1 = even
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"uptime_seconds": time.Since(s.started).Seconds(),
+		"started_at":     s.started.UTC(),
+		"keys":           total,
+		"shards":         shards,
+		"expiry":         s.metrics.Expiry.report(),
+	})
+}
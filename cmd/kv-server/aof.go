@@ -0,0 +1,462 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shubhamc1947/safemap/pkg/concurrentmap"
+)
+
+// ----------- Append-only File -----------
+
+// fsyncPolicy controls when the AOF is flushed to stable storage.
+type fsyncPolicy string
+
+const (
+	fsyncAlways   fsyncPolicy = "always"   // before every write is acknowledged
+	fsyncEverySec fsyncPolicy = "everysec" // once per second; may lose ~1s on crash
+	fsyncNo       fsyncPolicy = "no"       // leave it to the OS
+)
+
+func parseFsyncPolicy(s string) (fsyncPolicy, error) {
+	switch p := fsyncPolicy(s); p {
+	case fsyncAlways, fsyncEverySec, fsyncNo:
+		return p, nil
+	default:
+		return "", fmt.Errorf("invalid fsync policy %q, want always, everysec or no", s)
+	}
+}
+
+// aofRecord is one line of the log. ExpiresAt is in Unix nanoseconds,
+// 0 for keys without a TTL; so are CreatedAt and UpdatedAt, 0 if unknown.
+// A collection header is logged with Elements set and the Edit it was
+// written with, if any, and no Value.
+type aofRecord struct {
+	Op        string `json:"op"` // "set" or "del"
+	Key       string `json:"key"`
+	Value     []byte `json:"value,omitempty"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+	Owner     string `json:"owner,omitempty"`
+
+	ContentType string   `json:"content_type,omitempty"`
+	Version     uint64   `json:"version,omitempty"`
+	CreatedAt   int64    `json:"created_at,omitempty"`
+	UpdatedAt   int64    `json:"updated_at,omitempty"`
+	Lease       string   `json:"lease,omitempty"`
+	Flags       uint32   `json:"flags,omitempty"`
+	Spill       string   `json:"spill,omitempty"` // file of a spilled value, whose length is Size
+	Size        int64    `json:"size,omitempty"`  // or the estimated length of a header's elements
+	Codec       string   `json:"codec,omitempty"` // "snappy" if Value is compressed
+	Encrypted   bool     `json:"encrypted,omitempty"`
+	SHA256      []byte   `json:"sha256,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+
+	Elements bool            `json:"elements,omitempty"`
+	Edit     *collectionEdit `json:"edit,omitempty"`
+}
+
+func setRecord(key string, v StoredValue) aofRecord {
+	rec := aofRecord{Op: "set", Key: key, Value: v.Data, Owner: v.Owner, ContentType: v.ContentType, Version: v.Version,
+		CreatedAt: toUnixNano(v.CreatedAt), UpdatedAt: toUnixNano(v.UpdatedAt), Lease: v.Lease, Flags: v.Flags,
+		Spill: v.Spill, Size: v.SpillSize, Encrypted: v.Encrypted, SHA256: v.SHA256, Tags: v.Tags}
+	if v.Compressed {
+		rec.Codec = "snappy"
+	}
+	if v.Elements {
+		rec.Elements, rec.Size = true, v.ElementsSize
+	}
+	if v.HasTTL {
+		rec.ExpiresAt = v.ExpiresAt.UnixNano()
+	}
+	return rec
+}
+
+// aofLog appends every store mutation to a JSON-lines file. It follows
+// the store through Subscribe, so writes from any code path are logged in
+// per-key commit order.
+type aofLog struct {
+	path          string
+	policy        fsyncPolicy
+	keys          *keyring // encrypts records when set
+	rewriteMinLen int64
+	rewritePct    int
+	whole         func(key string, v StoredValue) (StoredValue, bool) // see KVServer.whole
+
+	mu         sync.Mutex
+	f          *os.File
+	w          *bufio.Writer
+	size       int64
+	baseSize   int64         // size right after the last rewrite
+	rewriteBuf *bytes.Buffer // records logged while a rewrite runs
+	err        error         // first write error; the log stops accepting writes
+
+	rewriting sync.Mutex
+}
+
+func openAOF(path string, policy fsyncPolicy, keys *keyring, rewriteMinLen int64, rewritePct int) (*aofLog, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &aofLog{
+		path:          path,
+		policy:        policy,
+		keys:          keys,
+		rewriteMinLen: rewriteMinLen,
+		rewritePct:    rewritePct,
+		f:             f,
+		w:             bufio.NewWriterSize(f, 64<<10),
+	}, nil
+}
+
+// replay applies the log to store and positions the file for appending.
+// A torn final record (from a crash mid-write) is truncated away.
+func (a *aofLog) replay(store *concurrentmap.ConcurrentMap[string, StoredValue]) (int, error) {
+	if _, err := a.f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	r := bufio.NewReaderSize(a.f, 64<<10)
+	var (
+		good int64
+		n    int
+		now  = time.Now()
+	)
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) && len(line) == 0 {
+			break
+		}
+		var rec aofRecord
+		if err == nil {
+			rec, err = a.decode(line)
+		}
+		if errors.Is(err, errUnknownKey) {
+			return n, err // not corruption: refuse to drop the rest of the log
+		}
+		if err != nil {
+			slog.Warn("aof: truncating torn or corrupt record", "offset", good)
+			break
+		}
+		good += int64(len(line))
+		n++
+
+		switch rec.Op {
+		case "set":
+			v := StoredValue{Data: rec.Value, Owner: rec.Owner, ContentType: rec.ContentType, Version: rec.Version,
+				CreatedAt: fromUnixNano(rec.CreatedAt), UpdatedAt: fromUnixNano(rec.UpdatedAt), Lease: rec.Lease, Flags: rec.Flags,
+				Spill: rec.Spill, SpillSize: rec.Size, Compressed: rec.Codec == "snappy", Encrypted: rec.Encrypted,
+				SHA256: rec.SHA256, Tags: rec.Tags, Accesses: new(atomic.Int64), LastRead: new(atomic.Int64)}
+			if rec.ExpiresAt != 0 {
+				v.HasTTL = true
+				v.ExpiresAt = time.Unix(0, rec.ExpiresAt)
+			}
+			if rec.Elements {
+				v.Elements, v.ElementsSize, v.SpillSize = true, rec.Size, 0
+			}
+			if v.isExpired(now) {
+				store.Delete(rec.Key)
+				continue
+			}
+			if rec.Edit == nil {
+				store.Set(rec.Key, v)
+				continue
+			}
+			// A rewrite may log an edit the value it copied already has.
+			rec.Edit.version = rec.Version
+			v.Edit = rec.Edit
+			store.Compute(rec.Key, func(old StoredValue, exists bool) (StoredValue, bool) {
+				if exists && old.Version >= v.Version {
+					return old, true
+				}
+				return v, true
+			})
+		case "del":
+			store.Delete(rec.Key)
+		}
+	}
+
+	if err := a.f.Truncate(good); err != nil {
+		return n, err
+	}
+	if _, err := a.f.Seek(good, io.SeekStart); err != nil {
+		return n, err
+	}
+	a.size, a.baseSize = good, good
+	return n, nil
+}
+
+// aofAAD binds encrypted records to the AOF.
+var aofAAD = []byte("kv-aof")
+
+// encode returns rec as one log line: JSON, or when encryption is on,
+// '!' followed by the base64 of the sealed JSON.
+func (a *aofLog) encode(rec aofRecord) []byte {
+	line, _ := json.Marshal(rec)
+	if a.keys != nil {
+		sealed := a.keys.seal(line, aofAAD)
+		line = make([]byte, 1+base64.StdEncoding.EncodedLen(len(sealed)))
+		line[0] = '!'
+		base64.StdEncoding.Encode(line[1:], sealed)
+	}
+	return append(line, '\n')
+}
+
+// decode parses a log line in either form, so logs written before
+// encryption was turned on still replay.
+func (a *aofLog) decode(line []byte) (aofRecord, error) {
+	var rec aofRecord
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	if len(line) > 0 && line[0] == '!' {
+		if a.keys == nil {
+			return rec, fmt.Errorf("aof: %w (no keys configured)", errUnknownKey)
+		}
+		sealed, err := base64.StdEncoding.DecodeString(string(line[1:]))
+		if err != nil {
+			return rec, err
+		}
+		if line, err = a.keys.open(sealed, aofAAD); err != nil {
+			return rec, err
+		}
+	}
+	err := json.Unmarshal(line, &rec)
+	return rec, err
+}
+
+// follow logs every subsequent mutation of store.
+func (a *aofLog) follow(store *concurrentmap.ConcurrentMap[string, StoredValue]) (unsubscribe func()) {
+	return store.Subscribe(func(ev concurrentmap.Event[string, StoredValue]) {
+		switch ev.Type {
+		case concurrentmap.EventInsert, concurrentmap.EventUpdate:
+			rec := setRecord(ev.Key, ev.NewValue)
+			rec.Edit = editOf(ev)
+			a.append(rec)
+		case concurrentmap.EventDelete, concurrentmap.EventExpire:
+			a.append(aofRecord{Op: "del", Key: ev.Key})
+		}
+	})
+}
+
+// append buffers rec. It runs under the store's bucket lock, so it only
+// touches memory; flushing happens in commit or the background loop.
+func (a *aofLog) append(rec aofRecord) {
+	line := a.encode(rec)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.err != nil {
+		return
+	}
+	if _, err := a.w.Write(line); err != nil {
+		a.err = err
+		return
+	}
+	a.size += int64(len(line))
+	if a.rewriteBuf != nil {
+		a.rewriteBuf.Write(line)
+	}
+}
+
+// commit makes buffered records durable when the policy is always. The
+// server calls it after a write and before acknowledging it.
+func (a *aofLog) commit() error {
+	if a.policy != fsyncAlways {
+		return a.writeErr()
+	}
+	return a.flush(true)
+}
+
+func (a *aofLog) writeErr() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.err
+}
+
+func (a *aofLog) flush(sync bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.err != nil {
+		return a.err
+	}
+	if err := a.w.Flush(); err != nil {
+		a.err = err
+		return err
+	}
+	if sync {
+		if err := a.f.Sync(); err != nil {
+			a.err = err
+			return err
+		}
+	}
+	return nil
+}
+
+// run flushes once per second and starts a rewrite when the log has grown
+// by rewritePct percent since the last one (and is at least rewriteMinLen).
+func (a *aofLog) run(ctx context.Context, store *concurrentmap.ConcurrentMap[string, StoredValue]) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := a.flush(a.policy == fsyncEverySec); err != nil {
+			slog.Error("aof: flush failed", "err", err)
+		}
+
+		a.mu.Lock()
+		due := a.size >= a.rewriteMinLen && a.size >= a.baseSize+a.baseSize*int64(a.rewritePct)/100
+		a.mu.Unlock()
+		if due {
+			if err := a.rewrite(store); err != nil {
+				slog.Error("aof: rewrite failed", "err", err)
+			}
+		}
+	}
+}
+
+// rewrite compacts the log to one record per live key, with collections
+// written whole. The store is copied bucket by bucket without blocking
+// writers; records logged meanwhile are kept aside and appended to the new
+// file, so replaying it yields the current state (a key written during
+// the copy may appear twice, which is harmless: replay skips an edit
+// older than the value it finds).
+func (a *aofLog) rewrite(store *concurrentmap.ConcurrentMap[string, StoredValue]) error {
+	if !a.rewriting.TryLock() {
+		return nil // one at a time
+	}
+	defer a.rewriting.Unlock()
+
+	a.mu.Lock()
+	a.rewriteBuf = new(bytes.Buffer)
+	a.mu.Unlock()
+
+	abort := func(err error) error {
+		a.mu.Lock()
+		a.rewriteBuf = nil
+		a.mu.Unlock()
+		return err
+	}
+
+	tmpPath := a.path + ".rewrite"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return abort(err)
+	}
+	defer os.Remove(tmpPath) // no-op after a successful rename
+
+	// Every record is re-encoded, so a rewrite also re-encrypts the log
+	// with the currently active key.
+	w := bufio.NewWriterSize(tmp, 64<<10)
+	now := time.Now()
+	store.Range(func(key string, v StoredValue) bool {
+		// Under the bucket lock, so a header's elements match it.
+		if v, ok := a.whole(key, v); ok && !v.isExpired(now) {
+			_, err = w.Write(a.encode(setRecord(key, v)))
+		}
+		return err == nil
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		tmp.Close()
+		return abort(err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Records logged during the copy go after the snapshot.
+	pending := a.rewriteBuf
+	a.rewriteBuf = nil
+	if _, err := tmp.Write(pending.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := os.Rename(tmpPath, a.path); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	// The old buffer only holds records already in pending.
+	a.w.Reset(tmp)
+	a.f.Close()
+	a.f = tmp
+	a.size, a.baseSize = size, size
+	slog.Info("aof: rewrote log", "bytes", size)
+	return nil
+}
+
+func (a *aofLog) close() error {
+	err := a.flush(true)
+	if cerr := a.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// persist commits the AOF, if enabled, before a write is acknowledged,
+// after recording the values the write replaced in versioned namespaces.
+// It reports false after writing an error response.
+func (s *KVServer) persist(w http.ResponseWriter, r *http.Request) bool {
+	if s.commitWrites(r.Context()) != nil {
+		writeError(w, r, http.StatusInternalServerError, codePersistence, "persistence failed")
+		return false
+	}
+	return true
+}
+
+// commitWrites is persist for callers that answer other than over HTTP.
+func (s *KVServer) commitWrites(ctx context.Context) error {
+	s.history.flush()
+	s.leases.flush()
+	if s.aof == nil {
+		return nil
+	}
+	if err := s.aof.commit(); err != nil {
+		slog.ErrorContext(ctx, "aof: commit failed", "err", err)
+		return err
+	}
+	return nil
+}
+
+// Admin: POST /admin/aof/rewrite compacts the log now.
+func (s *KVServer) handleAOFRewrite(w http.ResponseWriter, r *http.Request) {
+	if s.aof == nil {
+		writeError(w, r, http.StatusNotFound, codeFeatureDisabled, "aof disabled")
+		return
+	}
+	if err := s.aof.rewrite(s.store); err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "rewrite failed: "+err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
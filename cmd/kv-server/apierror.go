@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ----------- Error Responses -----------
+
+// Error codes returned in the "code" field of error responses. Clients
+// should branch on these, not on the message.
+const (
+	codeBadRequest         = "bad_request"
+	codeMissingKey         = "missing_key"
+	codeInvalidKey         = "invalid_key"
+	codeInvalidBody        = "invalid_body"
+	codeReservedKey        = "reserved_key"
+	codeKeyNotFound        = "key_not_found"
+	codeNamespaceNotFound  = "namespace_not_found"
+	codeQuotaExceeded      = "quota_exceeded"
+	codeOutOfMemory        = "out_of_memory"
+	codeValueTooLarge      = "value_too_large"
+	codeKeyTooLong         = "key_too_long"
+	codePreconditionFailed = "precondition_failed"
+	codeKeyExists          = "key_exists"
+	codeVersionNotFound    = "version_not_found"
+	codeLockHeld           = "lock_held"
+	codeLockNotHeld        = "lock_not_held"
+	codeLockNotFound       = "lock_not_found"
+	codeLeaseNotFound      = "lease_not_found"
+	codeWatchCompacted     = "watch_compacted"
+	codeBatchTooLarge      = "batch_too_large"
+	codeNotInteger         = "not_integer"
+	codeOverflow           = "overflow"
+	codeValueSpilled       = "value_spilled"
+	codeDigestMismatch     = "digest_mismatch"
+	codeNotJSON            = "not_json"
+	codeInvalidPath        = "invalid_path"
+	codePathNotFound       = "path_not_found"
+	codePathConflict       = "path_conflict"
+	codeIndexNotFound      = "index_not_found"
+	codeNotHLL             = "not_hll"
+	codeNotList            = "not_list"
+	codeNotSet             = "not_set"
+	codeNotZSet            = "not_zset"
+	codeNotFound           = "not_found"
+	codeMethodNotAllowed   = "method_not_allowed"
+	codeUnauthorized       = "unauthorized"
+	codeForbidden          = "forbidden"
+	codeRateLimited        = "rate_limited"
+	codeLoading            = "loading"
+	codeReadOnly           = "read_only"
+	codeFeatureDisabled    = "feature_disabled"
+	codePersistence        = "persistence_failed"
+	codeUpstream           = "upstream_failed"
+	codeInternal           = "internal_error"
+)
+
+type errorBody struct {
+	Error apiError `json:"error"`
+}
+
+type apiError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// writeError sends the JSON error envelope used by every endpoint:
+//
+//	{"error": {"code": "key_not_found", "message": "...", "request_id": "..."}}
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	h := w.Header()
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(errorBody{Error: apiError{
+		Code:      code,
+		Message:   message,
+		RequestID: requestIDFrom(r.Context()),
+	}})
+}
+
+// statusError is an error response that has not been written yet, for
+// checks shared by the single-key handlers and the batch endpoint.
+type statusError struct {
+	status        int
+	code, message string
+}
+
+func (e *statusError) write(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, e.status, e.code, e.message)
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+}
+
+// allowMethods is the catch-all for a route whose methods are registered
+// separately: it answers OPTIONS with the Allow header, and any other
+// method with 405 and the same header.
+func allowMethods(allow string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", allow)
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		methodNotAllowed(w, r)
+	}
+}
+
+// handleNotFound answers requests no route matches.
+func handleNotFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusNotFound, codeNotFound, "no such endpoint")
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// ----------- Authentication / Authorization -----------
+
+// Scopes a credential can hold.
+const (
+	scopeRead  = "read"  // GET/HEAD on keys, /metrics
+	scopeWrite = "write" // other methods on keys
+	scopeAdmin = "admin" // /admin/ and /debug/
+)
+
+func validScope(s string) bool {
+	return s == scopeRead || s == scopeWrite || s == scopeAdmin
+}
+
+// principal is the authenticated caller.
+type principal struct {
+	Name       string
+	Scopes     []string
+	Namespaces []string // from JWT claims; nil = unrestricted
+	TokenID    string   // registry token, for quotas
+	Quota      quota
+}
+
+func (p principal) has(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+type principalKey struct{}
+
+// principalFrom returns the caller attached by authMiddleware.
+func principalFrom(ctx context.Context) (principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(principal)
+	return p, ok
+}
+
+// requiredScope maps a request to the scope it needs. Batch requests
+// need read; their writes are checked one by one.
+func requiredScope(r *http.Request) string {
+	switch {
+	case isAdminPath(r.URL.Path):
+		return scopeAdmin
+	case (isKVPath(r.URL.Path) || isKeysPath(r.URL.Path) || isTagsPath(r.URL.Path) || isHLLPath(r.URL.Path) || isListPath(r.URL.Path) || isSetPath(r.URL.Path) || isZSetPath(r.URL.Path) || isLocksPath(r.URL.Path) || isLeasesPath(r.URL.Path) || isPubSubPath(r.URL.Path)) && !isReadMethod(r.Method) && !isBatchRequest(r):
+		return scopeWrite
+	default:
+		return scopeRead
+	}
+}
+
+func isReadMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func isAdminPath(path string) bool {
+	return strings.HasPrefix(path, "/admin/") || strings.HasPrefix(path, "/debug/")
+}
+
+// authenticate resolves the request's credential:
+//
+//   - --auth-token holds every scope;
+//   - --admin-token holds admin;
+//   - registry tokens (/admin/tokens) hold their own scopes;
+//   - JWTs, when configured, hold the scopes in their claims.
+//
+// Without a credential, callers get read and write unless --auth-token or
+// JWT auth is set, and admin too unless a static token is set.
+func (s *KVServer) authenticate(r *http.Request) (principal, bool) {
+	return s.principalFor(r.Context(), apiKeyFromRequest(r))
+}
+
+// principalFor is authenticate for a credential presented other than in
+// HTTP headers; "" is anonymous.
+func (s *KVServer) principalFor(ctx context.Context, secret string) (principal, bool) {
+	switch {
+	case secret == "":
+	case s.jwt != nil && looksLikeJWT(secret):
+		claims, err := s.jwt.verify(ctx, secret)
+		if err != nil {
+			slog.DebugContext(ctx, "jwt rejected", "err", err)
+			return principal{}, false
+		}
+		return s.jwt.principal(claims), true
+	case s.authToken != "" && secret == s.authToken:
+		return principal{Name: "auth-token", Scopes: []string{scopeRead, scopeWrite, scopeAdmin}}, true
+	case s.adminToken != "" && secret == s.adminToken:
+		return principal{Name: "admin-token", Scopes: []string{scopeAdmin}}, true
+	default:
+		if rec, ok := s.tokens.lookup(secret); ok {
+			return principal{Name: rec.Name, Scopes: rec.Scopes, TokenID: rec.ID, Quota: rec.quota}, true
+		}
+		if s.authToken != "" || s.adminToken != "" || s.jwt != nil {
+			return principal{}, false // a wrong credential is never anonymous
+		}
+	}
+
+	anon := principal{Name: "anonymous"}
+	if s.authToken == "" && s.jwt == nil {
+		anon.Scopes = append(anon.Scopes, scopeRead, scopeWrite)
+		if s.adminToken == "" {
+			anon.Scopes = append(anon.Scopes, scopeAdmin)
+		}
+	}
+	return anon, true
+}
+
+// Auth middleware: authenticates the caller, checks the scope the route
+// needs, and attaches the principal to the request context.
+func (s *KVServer) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Skip health probes for easier monitoring
+		if isProbePath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		p, ok := s.authenticate(r)
+		if !ok {
+			s.metrics.Unauthorized.Add(1)
+			writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "unauthorized")
+			return
+		}
+		if scope := requiredScope(r); !p.has(scope) {
+			s.metrics.Unauthorized.Add(1)
+			if p.Name == "anonymous" {
+				writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "unauthorized")
+			} else {
+				writeError(w, r, http.StatusForbidden, codeForbidden, "token lacks the "+scope+" scope")
+			}
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/shubhamc1947/safemap/pkg/concurrentmap"
+)
+
+// newTestServer returns a server over an empty store with the state the
+// middlewares rely on, built as main builds it.
+func newTestServer(t *testing.T) *KVServer {
+	t.Helper()
+	store := concurrentmap.NewStringMap[StoredValue](16)
+	return &KVServer{
+		store:   store,
+		metrics: &Metrics{},
+		tokens:  &tokenRegistry{store: store},
+		acl:     &aclRegistry{store: store},
+	}
+}
+
+// okHandler answers 200, naming the principal it was called for.
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if p, ok := principalFrom(r.Context()); ok {
+		w.Header().Set("X-Principal", p.Name)
+	}
+})
+
+func serveAs(h http.Handler, method, path, apiKey string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	if apiKey != "" {
+		r.Header.Set("X-API-Key", apiKey)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestAuthScopes(t *testing.T) {
+	s := newTestServer(t)
+	s.authToken = "root"
+	reader, _, err := s.tokens.create("reader", []string{scopeRead}, quota{})
+	if err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+	h := s.authMiddleware(okHandler)
+
+	tests := []struct {
+		method, path, key string
+		want              int
+	}{
+		{http.MethodGet, "/kv/a", "", http.StatusUnauthorized},
+		{http.MethodGet, "/kv/a", "wrong", http.StatusUnauthorized},
+		{http.MethodGet, "/kv/a", reader, http.StatusOK},
+		{http.MethodPut, "/kv/a", reader, http.StatusForbidden},
+		{http.MethodGet, "/admin/runtime", reader, http.StatusForbidden},
+		{http.MethodPut, "/kv/a", "root", http.StatusOK},
+		{http.MethodGet, "/admin/runtime", "root", http.StatusOK},
+		{http.MethodGet, "/healthz", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if w := serveAs(h, tt.method, tt.path, tt.key); w.Code != tt.want {
+			t.Errorf("%s %s with %q: expected %d, got %d", tt.method, tt.path, tt.key, tt.want, w.Code)
+		}
+	}
+
+	if w := serveAs(h, http.MethodGet, "/kv/a", reader); w.Header().Get("X-Principal") != "reader" {
+		t.Fatalf("expected the handler to see the reader token, got %q", w.Header().Get("X-Principal"))
+	}
+}
+
+func TestAuthAnonymous(t *testing.T) {
+	s := newTestServer(t)
+	h := s.authMiddleware(okHandler)
+
+	// Without a static token, anonymous callers hold every scope, and an
+	// unknown key is treated as none.
+	for _, key := range []string{"", "unknown"} {
+		w := serveAs(h, http.MethodGet, "/admin/runtime", key)
+		if w.Code != http.StatusOK || w.Header().Get("X-Principal") != "anonymous" {
+			t.Fatalf("with %q: expected anonymous admin access, got %d as %q", key, w.Code, w.Header().Get("X-Principal"))
+		}
+	}
+
+	s.adminToken = "boss"
+	tests := []struct {
+		method, path, key string
+		want              int
+	}{
+		{http.MethodPut, "/kv/a", "", http.StatusOK},
+		{http.MethodGet, "/admin/runtime", "", http.StatusUnauthorized},
+		{http.MethodGet, "/admin/runtime", "boss", http.StatusOK},
+		{http.MethodPut, "/kv/a", "boss", http.StatusForbidden},
+		{http.MethodGet, "/kv/a", "unknown", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if w := serveAs(h, tt.method, tt.path, tt.key); w.Code != tt.want {
+			t.Errorf("%s %s with %q: expected %d, got %d", tt.method, tt.path, tt.key, tt.want, w.Code)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shubhamc1947/safemap/pkg/concurrentmap"
+)
+
+// ----------- Backups -----------
+
+// backupTarget is an object store holding snapshot backups.
+type backupTarget interface {
+	Put(ctx context.Context, name string, data []byte) error
+	Get(ctx context.Context, name string) ([]byte, error)
+	Delete(ctx context.Context, name string) error
+	List(ctx context.Context) ([]string, error)
+	String() string
+}
+
+// parseBackupURL returns the target for s3://bucket/prefix,
+// gs://bucket/prefix or file:///dir.
+func parseBackupURL(raw string) (backupTarget, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.TrimPrefix(u.Path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	switch u.Scheme {
+	case "s3":
+		return newS3Target(u.Host, prefix)
+	case "gs":
+		return newGCSTarget(u.Host, prefix)
+	case "file":
+		if err := os.MkdirAll(u.Path, 0o755); err != nil {
+			return nil, err
+		}
+		return fileTarget(u.Path), nil
+	default:
+		return nil, fmt.Errorf("unsupported backup URL %q, want s3://, gs:// or file://", raw)
+	}
+}
+
+// fileTarget keeps backups in a local (or mounted) directory.
+type fileTarget string
+
+func (d fileTarget) String() string { return "file://" + string(d) }
+
+func (d fileTarget) Put(_ context.Context, name string, data []byte) error {
+	tmp := filepath.Join(string(d), name+".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(string(d), name))
+}
+
+func (d fileTarget) Get(_ context.Context, name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(string(d), filepath.Base(name)))
+}
+
+func (d fileTarget) Delete(_ context.Context, name string) error {
+	return os.Remove(filepath.Join(string(d), filepath.Base(name)))
+}
+
+func (d fileTarget) List(_ context.Context) ([]string, error) {
+	entries, err := os.ReadDir(string(d))
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && !strings.HasSuffix(e.Name(), ".tmp") {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+const backupSuffix = ".kvsnap"
+
+// backups uploads snapshots to a target and keeps the newest keep of them.
+// Names embed a UTC timestamp, so lexical order is age order.
+type backups struct {
+	target backupTarget
+	keep   int
+	mu     sync.Mutex // one upload + retention pass at a time
+
+	pending sync.WaitGroup // uploads in flight, awaited on shutdown
+}
+
+func backupName(t time.Time) string {
+	return "snapshot-" + t.UTC().Format("20060102T150405.000Z") + backupSuffix
+}
+
+// list returns backup names, oldest first.
+func (b *backups) list(ctx context.Context) ([]string, error) {
+	all, err := b.target.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, n := range all {
+		if strings.HasPrefix(n, "snapshot-") && strings.HasSuffix(n, backupSuffix) {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (b *backups) upload(data []byte, at time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	name := backupName(at)
+	if err := b.target.Put(ctx, name, data); err != nil {
+		slog.Error("backup: upload failed", "name", name, "err", err)
+		return
+	}
+	slog.Info("backup: uploaded", "name", name, "bytes", len(data), "target", b.target.String())
+
+	names, err := b.list(ctx)
+	if err != nil {
+		slog.Error("backup: list for retention failed", "err", err)
+		return
+	}
+	for len(names) > b.keep {
+		if err := b.target.Delete(ctx, names[0]); err != nil {
+			slog.Error("backup: delete failed", "name", names[0], "err", err)
+		}
+		names = names[1:]
+	}
+}
+
+// fetch downloads a backup by name; "latest" picks the newest.
+func (b *backups) fetch(ctx context.Context, name string) (string, []byte, error) {
+	if name == "latest" {
+		names, err := b.list(ctx)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(names) == 0 {
+			return "", nil, errors.New("no backups")
+		}
+		name = names[len(names)-1]
+	}
+	data, err := b.target.Get(ctx, name)
+	return name, data, err
+}
+
+// restoreSnapshot replaces the store's contents with a snapshot image.
+// The image is fully verified before the store is touched.
+func restoreSnapshot(data []byte, keys *keyring, store *concurrentmap.ConcurrentMap[string, StoredValue]) (int, error) {
+	entries, err := parseSnapshot(data, keys)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, e := range store.Snapshot() {
+		if _, keep := entries[e.Key]; !keep && !isReservedKey(e.Key) {
+			store.Delete(e.Key)
+		}
+	}
+	return storeSnapshotEntries(entries, store), nil
+}
+
+// Admin: GET /admin/backups lists backups; POST /admin/restore?from=NAME
+// (or from=latest) replaces the store with a backup.
+func (s *KVServer) handleBackups(w http.ResponseWriter, r *http.Request) {
+	if s.backups == nil {
+		writeError(w, r, http.StatusNotFound, codeFeatureDisabled, "backups disabled")
+		return
+	}
+	names, err := s.backups.list(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusBadGateway, codeUpstream, "list failed: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"target": s.backups.target.String(), "backups": names})
+}
+
+func (s *KVServer) handleRestore(w http.ResponseWriter, r *http.Request) {
+	if s.backups == nil {
+		writeError(w, r, http.StatusNotFound, codeFeatureDisabled, "backups disabled")
+		return
+	}
+	from := r.URL.Query().Get("from")
+	if from == "" {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "missing from")
+		return
+	}
+
+	name, data, err := s.backups.fetch(r.Context(), from)
+	if err != nil {
+		writeError(w, r, http.StatusBadGateway, codeUpstream, "fetch failed: "+err.Error())
+		return
+	}
+	n, err := restoreSnapshot(data, s.snapshots.keys, s.store)
+	if err != nil {
+		writeError(w, r, http.StatusUnprocessableEntity, codeInvalidBody, "restore failed: "+err.Error())
+		return
+	}
+	s.raiseVersions()
+	slog.InfoContext(r.Context(), "backup: restored", "keys", n, "name", name)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"restored_from": name, "keys": n})
+}
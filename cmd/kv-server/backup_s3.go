@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ----------- S3-compatible Object Storage -----------
+
+// s3Target stores backups in an S3-compatible bucket using path-style
+// requests signed with AWS Signature Version 4. Google Cloud Storage is
+// reached through its S3-compatible XML API with HMAC keys.
+type s3Target struct {
+	endpoint string // scheme://host, no trailing slash
+	region   string
+	bucket   string
+	prefix   string // "" or ends in "/"
+
+	accessKey    string
+	secretKey    string
+	sessionToken string
+
+	client *http.Client
+}
+
+// newS3Target configures an s3:// target from the standard AWS_*
+// environment variables. AWS_ENDPOINT_URL selects an S3-compatible
+// service such as MinIO.
+func newS3Target(bucket, prefix string) (*s3Target, error) {
+	region := firstEnv("AWS_REGION", "AWS_DEFAULT_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := os.Getenv("AWS_ENDPOINT_URL")
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	t := &s3Target{
+		endpoint:     strings.TrimSuffix(endpoint, "/"),
+		region:       region,
+		bucket:       bucket,
+		prefix:       prefix,
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		client:       &http.Client{Timeout: 5 * time.Minute},
+	}
+	if t.accessKey == "" || t.secretKey == "" {
+		return nil, fmt.Errorf("s3 backups need AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return t, nil
+}
+
+// newGCSTarget configures a gs:// target using GCS HMAC keys from
+// GCS_HMAC_ACCESS_ID and GCS_HMAC_SECRET.
+func newGCSTarget(bucket, prefix string) (*s3Target, error) {
+	t := &s3Target{
+		endpoint:  "https://storage.googleapis.com",
+		region:    "auto",
+		bucket:    bucket,
+		prefix:    prefix,
+		accessKey: os.Getenv("GCS_HMAC_ACCESS_ID"),
+		secretKey: os.Getenv("GCS_HMAC_SECRET"),
+		client:    &http.Client{Timeout: 5 * time.Minute},
+	}
+	if t.accessKey == "" || t.secretKey == "" {
+		return nil, fmt.Errorf("gs backups need GCS_HMAC_ACCESS_ID and GCS_HMAC_SECRET")
+	}
+	return t, nil
+}
+
+func firstEnv(names ...string) string {
+	for _, n := range names {
+		if v := os.Getenv(n); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func (t *s3Target) String() string {
+	return t.endpoint + "/" + t.bucket + "/" + t.prefix
+}
+
+func (t *s3Target) Put(ctx context.Context, name string, data []byte) error {
+	_, err := t.do(ctx, http.MethodPut, t.prefix+name, nil, data)
+	return err
+}
+
+func (t *s3Target) Get(ctx context.Context, name string) ([]byte, error) {
+	return t.do(ctx, http.MethodGet, t.prefix+name, nil, nil)
+}
+
+func (t *s3Target) Delete(ctx context.Context, name string) error {
+	_, err := t.do(ctx, http.MethodDelete, t.prefix+name, nil, nil)
+	return err
+}
+
+// List returns the names (without prefix) of all objects under prefix.
+func (t *s3Target) List(ctx context.Context) ([]string, error) {
+	var (
+		names []string
+		token string
+	)
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {t.prefix}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		body, err := t.do(ctx, http.MethodGet, "", q, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var page struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		if err := xml.Unmarshal(body, &page); err != nil {
+			return nil, fmt.Errorf("list %s: %w", t, err)
+		}
+		for _, c := range page.Contents {
+			names = append(names, strings.TrimPrefix(c.Key, t.prefix))
+		}
+		if !page.IsTruncated {
+			return names, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// do sends a signed request for key (the bucket itself when key is empty)
+// and returns the response body.
+func (t *s3Target) do(ctx context.Context, method, key string, query url.Values, body []byte) ([]byte, error) {
+	path := "/" + t.bucket
+	if key != "" {
+		path += "/" + key
+	}
+	u := t.endpoint + awsEscape(path, true)
+	if len(query) > 0 {
+		u += "?" + canonicalQuery(query)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	t.sign(req, path, query, body, time.Now().UTC())
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(data))
+	}
+	return data, nil
+}
+
+// sign adds SigV4 headers to req.
+func (t *s3Target) sign(req *http.Request, path string, query url.Values, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := amzDate[:8]
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if t.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", t.sessionToken)
+	}
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           amzDate,
+	}
+	if t.sessionToken != "" {
+		headers["x-amz-security-token"] = t.sessionToken
+	}
+	names := make([]string, 0, len(headers))
+	for n := range headers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var canonHeaders strings.Builder
+	for _, n := range names {
+		canonHeaders.WriteString(n + ":" + headers[n] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		awsEscape(path, true),
+		canonicalQuery(query),
+		canonHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + t.region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+
+	key := hmacSHA256([]byte("AWS4"+t.secretKey), date)
+	key = hmacSHA256(key, t.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+t.accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range q[k] {
+			parts = append(parts, awsEscape(k, false)+"="+awsEscape(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsEscape percent-encodes everything but unreserved characters (and
+// '/' when keepSlash is set), as SigV4 requires.
+func awsEscape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
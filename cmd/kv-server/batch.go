@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ----------- Batch Operations -----------
+
+// batchKey is the key segment of the batch endpoint, POST /kv/_batch or
+// /v1/{ns}/kv/_batch. Other methods still address a key named "_batch".
+const batchKey = "_batch"
+
+// maxBatchBody caps the size of a batch request body.
+const maxBatchBody = 32 << 20
+
+// batchOp is one operation of a batch request.
+type batchOp struct {
+	Op         string `json:"op"` // "get", "set" or "delete"
+	Key        string `json:"key"`
+	Value      string `json:"value,omitempty"`
+	TTLSeconds int64  `json:"ttl_seconds,omitempty"`
+}
+
+// valueJSON is a value in a bulk response: JSON-envelope values as
+// "value", raw values base64-encoded alongside their content type.
+type valueJSON struct {
+	Value       *string    `json:"value,omitempty"`
+	ValueBase64 []byte     `json:"value_base64,omitempty"`
+	ContentType string     `json:"content_type,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	ETag        string     `json:"etag,omitempty"`
+
+	// spill names the file of a spilled value, left out until
+	// loadSpilledResults reads it.
+	spill     string
+	spillSize int64
+}
+
+func newValueJSON(v StoredValue, keys *keyring) valueJSON {
+	v = v.plain(keys)
+	out := valueJSON{ETag: v.etag()}
+	if v.Spill != "" {
+		out.ContentType, out.spill, out.spillSize = v.ContentType, v.Spill, v.SpillSize
+	} else if v.Elements {
+		out.ContentType = v.ContentType // see KVServer.whole
+	} else if v.ContentType != "" {
+		out.ValueBase64, out.ContentType = v.Data, v.ContentType
+	} else {
+		data := string(v.Data)
+		out.Value = &data
+	}
+	if v.HasTTL {
+		out.ExpiresAt = &v.ExpiresAt
+	}
+	return out
+}
+
+// batchResult is one operation's outcome. Status is what the single-key
+// endpoint would have answered.
+type batchResult struct {
+	Status int `json:"status"`
+	valueJSON
+	Error *apiError `json:"error,omitempty"`
+}
+
+func failed(serr *statusError) batchResult {
+	return batchResult{Status: serr.status, Error: &apiError{Code: serr.code, Message: serr.message}}
+}
+
+// isBatchRequest reports whether r is a call to the batch endpoint.
+func isBatchRequest(r *http.Request) bool {
+	_, key, ok := kvPath(r.URL.Path)
+	return ok && key == batchKey && r.Method == http.MethodPost
+}
+
+// Batch: POST /kv/_batch with a JSON array of operations,
+//
+//	[{"op": "set", "key": "a", "value": "1", "ttl_seconds": 60},
+//	 {"op": "get", "key": "a"}, {"op": "delete", "key": "b"}]
+//
+// runs them in order and answers with an array of results in the same
+// order. Operations are independent: one failing does not stop or undo
+// the others. Each is checked like its single-key request, including the
+// scope it needs, so the endpoint itself only requires read.
+func (s *KVServer) handleBatch(w http.ResponseWriter, r *http.Request) {
+	s.metrics.TotalRequests.Add(1)
+
+	var ops []batchOp
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBody)).Decode(&ops); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, r, http.StatusRequestEntityTooLarge, codeBatchTooLarge,
+				fmt.Sprintf("body exceeds the %d byte limit", tooLarge.Limit))
+			return
+		}
+		writeError(w, r, http.StatusBadRequest, codeInvalidBody, "invalid body: "+err.Error())
+		return
+	}
+	if s.maxBatchOps > 0 && len(ops) > s.maxBatchOps {
+		writeError(w, r, http.StatusRequestEntityTooLarge, codeBatchTooLarge,
+			fmt.Sprintf("batch has %d operations, the limit is %d", len(ops), s.maxBatchOps))
+		return
+	}
+
+	p, _ := principalFrom(r.Context())
+	ctx, sp := s.tracer.start(r.Context(), "store.batch", spanKindInternal)
+	sp.setAttr("ops", len(ops))
+	results := make([]batchResult, len(ops))
+	for i, op := range ops {
+		results[i] = s.batchOne(ctx, p, r.PathValue("ns"), op)
+	}
+	sp.finish()
+	if !s.persist(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(results)
+}
+
+// batchOne runs op in namespace nsName ("" for the flat keyspace). The
+// caller persists.
+func (s *KVServer) batchOne(ctx context.Context, p principal, nsName string, op batchOp) batchResult {
+	key, ns, serr := s.resolveBatchOp(p, nsName, op)
+	if serr != nil {
+		return failed(serr)
+	}
+
+	switch op.Op {
+	case "get":
+		s.metrics.TotalGets.Add(1)
+		value, ok := s.fetch(ctx, key, ns)
+		if !ok {
+			return failed(&statusError{http.StatusNotFound, codeKeyNotFound, "key not found"})
+		}
+		return batchResult{Status: http.StatusOK, valueJSON: newValueJSON(value, s.valueKeys)}
+
+	case "set":
+		s.metrics.TotalPuts.Add(1)
+		stored, serr := s.batchValue(op)
+		if serr != nil {
+			return failed(serr)
+		}
+		if serr := s.putValue(ctx, p, key, ns, &stored, writeCond{}); serr != nil {
+			return failed(serr)
+		}
+		return setResult(stored)
+
+	default:
+		s.metrics.TotalDeletes.Add(1)
+		if serr := s.deleteValue(ctx, key, ns, ""); serr != nil {
+			return failed(serr)
+		}
+		return batchResult{Status: http.StatusNoContent}
+	}
+}
+
+// resolveBatchOp checks that p may run op in namespace nsName, as its
+// single-key request would be checked, and returns op's key in the store.
+func (s *KVServer) resolveBatchOp(p principal, nsName string, op batchOp) (string, *namespace, *statusError) {
+	need := scopeWrite
+	switch op.Op {
+	case "get":
+		need = scopeRead
+	case "set", "delete":
+	default:
+		return "", nil, &statusError{http.StatusBadRequest, codeBadRequest, `op must be "get", "set" or "delete"`}
+	}
+	if !p.has(need) {
+		return "", nil, &statusError{http.StatusForbidden, codeForbidden, "token lacks the " + need + " scope"}
+	}
+	if need == scopeWrite && s.readOnly.Load() {
+		return "", nil, readOnlyError()
+	}
+
+	key, ns, serr := s.resolveKey(p, nsName, op.Key)
+	if serr != nil {
+		return "", nil, serr
+	}
+	if s.aclEnforced && !p.has(scopeAdmin) && !s.acl.allowed(p.Name, aclKey(nsName, op.Key), need) {
+		s.metrics.Unauthorized.Add(1)
+		return "", nil, &statusError{http.StatusForbidden, codeForbidden, "no ACL rule allows " + need + " on this key"}
+	}
+	return key, ns, nil
+}
+
+// batchValue builds the value a set operation stores.
+func (s *KVServer) batchValue(op batchOp) (StoredValue, *statusError) {
+	if s.maxValueSize > 0 && int64(len(op.Value)) > s.maxValueSize {
+		return StoredValue{}, &statusError{http.StatusRequestEntityTooLarge, codeValueTooLarge,
+			fmt.Sprintf("value exceeds the %d byte limit", s.maxValueSize)}
+	}
+	ttl, ok := ttlDuration(op.TTLSeconds)
+	if !ok {
+		return StoredValue{}, &statusError{http.StatusBadRequest, codeBadRequest, ttlRangeMessage("ttl_seconds")}
+	}
+	stored := StoredValue{Data: []byte(op.Value)}
+	if ttl > 0 {
+		stored.HasTTL = true
+		stored.ExpiresAt = time.Now().Add(ttl)
+	}
+	return stored, nil
+}
+
+// setResult is the result of a set operation that stored v.
+func setResult(v StoredValue) batchResult {
+	res := batchResult{Status: http.StatusCreated}
+	res.ETag = v.etag()
+	if v.HasTTL {
+		res.ExpiresAt = &v.ExpiresAt
+	}
+	return res
+}
+
+// isMultiGetPath reports whether path is /kv or /v1/{ns}/kv.
+func isMultiGetPath(path string) bool {
+	_, rest, ok := nsPath(path)
+	return path == "/kv" || ok && rest == "/kv"
+}
+
+// Multi-get: GET /kv?keys=a,b,c (or /v1/{ns}/kv?keys=...) reads many keys
+// at once, locking each shard of the store once:
+//
+//	{"values": {"a": {"value": "1", "etag": "..."}}, "missing": ["b", "c"]}
+//
+// keys may also be repeated; keys containing commas need the batch
+// endpoint. Every key is checked as for GET /kv/{key}, and any failure
+// fails the whole request.
+func (s *KVServer) handleMultiGet(w http.ResponseWriter, r *http.Request) {
+	s.metrics.TotalRequests.Add(1)
+
+	var keys []string
+	seen := make(map[string]bool)
+	for _, param := range r.URL.Query()["keys"] {
+		for _, key := range strings.Split(param, ",") {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	if len(keys) == 0 {
+		writeError(w, r, http.StatusBadRequest, codeMissingKey, "keys query parameter is required")
+		return
+	}
+	if s.maxBatchOps > 0 && len(keys) > s.maxBatchOps {
+		writeError(w, r, http.StatusRequestEntityTooLarge, codeBatchTooLarge,
+			fmt.Sprintf("%d keys requested, the limit is %d", len(keys), s.maxBatchOps))
+		return
+	}
+
+	p, _ := principalFrom(r.Context())
+	nsName := r.PathValue("ns")
+	var ns *namespace
+	storeKeys := make([]string, len(keys))
+	for i, key := range keys {
+		storeKey, kns, serr := s.resolveKey(p, nsName, key)
+		if serr != nil {
+			switch serr.code {
+			case codeMissingKey, codeInvalidKey, codeKeyTooLong, codeReservedKey:
+				serr.message = fmt.Sprintf("key %q: %s", key, serr.message)
+			}
+			serr.write(w, r)
+			return
+		}
+		if s.aclEnforced && !p.has(scopeAdmin) && !s.acl.allowed(p.Name, aclKey(nsName, key), scopeRead) {
+			s.metrics.Unauthorized.Add(1)
+			writeError(w, r, http.StatusForbidden, codeForbidden, fmt.Sprintf("no ACL rule allows read on key %q", key))
+			return
+		}
+		storeKeys[i], ns = storeKey, kns
+	}
+
+	_, sp := s.tracer.start(r.Context(), "store.get_many", spanKindInternal)
+	found := s.store.GetMany(storeKeys)
+	sp.setAttr("keys", len(keys))
+	sp.setAttr("found", len(found))
+	sp.finish()
+
+	values := make(map[string]valueJSON, len(found))
+	missing := []string{}
+	now := time.Now()
+	for i, key := range keys {
+		s.metrics.TotalGets.Add(1)
+		value, ok := found[storeKeys[i]]
+		if ok && value.isExpired(now) {
+			if s.store.ExpireIf(storeKeys[i], func(v StoredValue) bool { return v.isExpired(now) }) {
+				s.metrics.Expiry.LazyExpired.Add(1)
+			}
+			ok = false
+		}
+		if ns != nil {
+			ns.stats.gets.Add(1)
+			if ok {
+				ns.stats.hits.Add(1)
+			} else {
+				ns.stats.misses.Add(1)
+			}
+		}
+		if !ok {
+			s.metrics.NotFound.Add(1)
+			missing = append(missing, key)
+			continue
+		}
+		value.countAccess()
+		if value.Spill != "" {
+			loaded, err := s.loadSpilled(value)
+			if err != nil {
+				// Replaced since it was read, or unreadable.
+				missing = append(missing, key)
+				continue
+			}
+			value = loaded
+		}
+		values[key] = newValueJSON(value, s.valueKeys)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"values": values, "missing": missing})
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+	"unicode/utf8"
+)
+
+// ----------- Binary Protocol -----------
+
+// With --binary-addr the server also speaks a compact binary protocol
+// over TCP, skipping HTTP framing and JSON for clients after raw
+// throughput. Every message, each way, is a frame:
+//
+//	u32 length of the rest | u8 op or status | u32 opaque | payload
+//
+// in big-endian. The opaque is echoed in the response. Requests may be
+// pipelined: responses come back in order, and go out together once the
+// requests read so far are answered and their writes committed.
+//
+// Requests and their payloads:
+//
+//	0x00 PING    -
+//	0x01 GET     key                          → u64 version | i64 expires (Unix ms, 0 = none) | value
+//	0x02 SET     u32 ttl_seconds | u16 key length | key | value  → u64 version
+//	0x03 DELETE  key
+//	0x04 AUTH    API key or JWT
+//	0x05 USE     namespace ("" for the flat keyspace)
+//
+// Responses have status 0x00 OK, 0x01 NOT_FOUND, or 0x02 ERROR with
+// u8 code length | code | message, the code being the HTTP API's.
+// Connections start anonymous, like HTTP requests without a credential.
+const (
+	binOpPing   = 0x00
+	binOpGet    = 0x01
+	binOpSet    = 0x02
+	binOpDelete = 0x03
+	binOpAuth   = 0x04
+	binOpUse    = 0x05
+)
+
+const (
+	binOK       = 0x00
+	binNotFound = 0x01
+	binError    = 0x02
+)
+
+const (
+	binHeader   = 5        // op or status and opaque
+	binMaxFrame = 64 << 20 // longest frame accepted
+	binFlushAt  = 64 << 10 // pending response bytes that force a flush
+)
+
+var errBinFrame = errors.New("frame too large")
+
+// binaryServer serves binary protocol connections.
+type binaryServer struct {
+	*connServer
+	s *KVServer
+}
+
+func (s *KVServer) listenBinary(addr string) (*binaryServer, error) {
+	b := &binaryServer{s: s}
+	cs, err := listenConns(addr, b.handle)
+	if err != nil {
+		return nil, err
+	}
+	b.connServer = cs
+	return b, nil
+}
+
+// binConn is one client connection.
+type binConn struct {
+	s     *KVServer
+	p     principal
+	ns    string
+	out   bytes.Buffer // responses not sent yet
+	dirty bool         // out acknowledges writes not committed yet
+}
+
+func (b *binaryServer) handle(conn net.Conn) {
+	ctx := context.Background()
+	c := &binConn{s: b.s}
+	c.p, _ = b.s.principalFor(ctx, "")
+	br := bufio.NewReaderSize(conn, 64<<10)
+	var hdr [4]byte
+	for {
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			return
+		}
+		n := binary.BigEndian.Uint32(hdr[:])
+		if n < binHeader || n > binMaxFrame {
+			c.fail(0, &statusError{http.StatusBadRequest, codeBadRequest, errBinFrame.Error()})
+			_ = c.flush(ctx, conn)
+			return
+		}
+		frame := make([]byte, n)
+		if _, err := io.ReadFull(br, frame); err != nil {
+			return
+		}
+		c.dispatch(ctx, frame[0], binary.BigEndian.Uint32(frame[1:binHeader]), frame[binHeader:])
+
+		// Responses to pipelined requests go out together.
+		if br.Buffered() == 0 || c.out.Len() >= binFlushAt {
+			if c.flush(ctx, conn) != nil {
+				return
+			}
+		}
+	}
+}
+
+// flush commits the writes pending responses acknowledge, then sends the
+// responses. If the commit fails, the connection is dropped instead, so
+// no write is acknowledged that was not persisted.
+func (c *binConn) flush(ctx context.Context, conn net.Conn) error {
+	if c.dirty {
+		if err := c.s.commitWrites(ctx); err != nil {
+			return err
+		}
+		c.dirty = false
+	}
+	_, err := c.out.WriteTo(conn)
+	return err
+}
+
+// respond queues a response.
+func (c *binConn) respond(status byte, opaque uint32, payload ...[]byte) {
+	n := binHeader
+	for _, p := range payload {
+		n += len(p)
+	}
+	var hdr [4 + binHeader]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(n))
+	hdr[4] = status
+	binary.BigEndian.PutUint32(hdr[5:], opaque)
+	c.out.Write(hdr[:])
+	for _, p := range payload {
+		c.out.Write(p)
+	}
+}
+
+// fail queues an ERROR response for serr.
+func (c *binConn) fail(opaque uint32, serr *statusError) {
+	code := serr.code[:min(len(serr.code), 255)]
+	c.respond(binError, opaque, []byte{byte(len(code))}, []byte(code), []byte(serr.message))
+}
+
+func (c *binConn) dispatch(ctx context.Context, op byte, opaque uint32, payload []byte) {
+	c.s.metrics.TotalRequests.Add(1)
+	if !c.s.loaded.Load() && op != binOpPing {
+		c.fail(opaque, &statusError{http.StatusServiceUnavailable, codeLoading, "loading persisted data"})
+		return
+	}
+	switch op {
+	case binOpPing:
+		c.respond(binOK, opaque)
+	case binOpGet:
+		c.get(ctx, opaque, string(payload))
+	case binOpSet:
+		c.set(ctx, opaque, payload)
+	case binOpDelete:
+		c.delete(ctx, opaque, string(payload))
+	case binOpAuth:
+		p, ok := c.s.principalFor(ctx, string(payload))
+		if !ok {
+			c.s.metrics.Unauthorized.Add(1)
+			c.fail(opaque, &statusError{http.StatusUnauthorized, codeUnauthorized, "unauthorized"})
+			return
+		}
+		c.p = p
+		c.respond(binOK, opaque)
+	case binOpUse:
+		if _, serr := c.s.resolveNamespace(c.p, string(payload)); serr != nil {
+			c.fail(opaque, serr)
+			return
+		}
+		c.ns = string(payload)
+		c.respond(binOK, opaque)
+	default:
+		c.fail(opaque, &statusError{http.StatusBadRequest, codeBadRequest, fmt.Sprintf("unknown op %#x", op)})
+	}
+}
+
+func (c *binConn) get(ctx context.Context, opaque uint32, key string) {
+	s := c.s
+	s.metrics.TotalGets.Add(1)
+	storeKey, ns, serr := s.resolveBatchOp(c.p, c.ns, batchOp{Op: "get", Key: key})
+	if serr != nil {
+		c.fail(opaque, serr)
+		return
+	}
+	v, ok := s.fetch(ctx, storeKey, ns)
+	if !ok {
+		c.respond(binNotFound, opaque)
+		return
+	}
+	var meta [16]byte
+	binary.BigEndian.PutUint64(meta[:8], v.Version)
+	if v.HasTTL {
+		binary.BigEndian.PutUint64(meta[8:], uint64(v.ExpiresAt.UnixMilli()))
+	}
+	c.respond(binOK, opaque, meta[:], v.Data)
+}
+
+func (c *binConn) set(ctx context.Context, opaque uint32, payload []byte) {
+	s := c.s
+	s.metrics.TotalPuts.Add(1)
+	if len(payload) < 6 || len(payload) < 6+int(binary.BigEndian.Uint16(payload[4:6])) {
+		c.fail(opaque, &statusError{http.StatusBadRequest, codeBadRequest, "malformed SET"})
+		return
+	}
+	ttl, ok := ttlDuration(int64(binary.BigEndian.Uint32(payload[:4])))
+	if !ok {
+		c.fail(opaque, &statusError{http.StatusBadRequest, codeBadRequest, ttlRangeMessage("ttl")})
+		return
+	}
+	keyLen := int(binary.BigEndian.Uint16(payload[4:6]))
+	key, data := string(payload[6:6+keyLen]), payload[6+keyLen:]
+
+	storeKey, ns, serr := s.resolveBatchOp(c.p, c.ns, batchOp{Op: "set", Key: key})
+	if serr != nil {
+		c.fail(opaque, serr)
+		return
+	}
+	if s.maxValueSize > 0 && int64(len(data)) > s.maxValueSize {
+		c.fail(opaque, &statusError{http.StatusRequestEntityTooLarge, codeValueTooLarge,
+			fmt.Sprintf("value exceeds the %d byte limit", s.maxValueSize)})
+		return
+	}
+	// Values that are not text are kept raw, as over memcached.
+	v := StoredValue{Data: data}
+	if !utf8.Valid(data) {
+		v.ContentType = "application/octet-stream"
+	}
+	if ttl > 0 {
+		v.HasTTL = true
+		v.ExpiresAt = time.Now().Add(ttl)
+	}
+	if serr := s.putValue(ctx, c.p, storeKey, ns, &v, writeCond{}); serr != nil {
+		c.fail(opaque, serr)
+		return
+	}
+	c.dirty = true
+	var version [8]byte
+	binary.BigEndian.PutUint64(version[:], v.Version)
+	c.respond(binOK, opaque, version[:])
+}
+
+func (c *binConn) delete(ctx context.Context, opaque uint32, key string) {
+	s := c.s
+	s.metrics.TotalDeletes.Add(1)
+	storeKey, ns, serr := s.resolveBatchOp(c.p, c.ns, batchOp{Op: "delete", Key: key})
+	if serr != nil {
+		c.fail(opaque, serr)
+		return
+	}
+	// "*" matches any live value, so a missing key is told apart.
+	if serr := s.deleteValue(ctx, storeKey, ns, "*"); serr != nil {
+		s.metrics.NotFound.Add(1)
+		c.respond(binNotFound, opaque)
+		return
+	}
+	c.dirty = true
+	c.respond(binOK, opaque)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/bits"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// ----------- Bitmaps -----------
+
+// Any value can be used as a bitmap, as in Redis: bit 0 is the most
+// significant bit of the first byte. A missing key reads as all zeros,
+// and setting a bit past the end grows the value with zero bytes.
+//
+//	POST /kv/{key}/setbit {"offset": 7, "value": 1}   -> {"previous": 0}
+//	GET  /kv/{key}/getbit?offset=7                    -> {"bit": 1}
+//	GET  /kv/{key}/bitcount?start=0&end=-1            -> {"count": 1}
+
+// maxBitOffset caps bit offsets, so one SETBIT cannot allocate more than
+// 512 MiB; --max-value-size caps them further.
+const maxBitOffset = 1<<32 - 1
+
+var errBitOffset = fmt.Errorf("offset must be 0-%d", int64(maxBitOffset))
+
+// bitAt returns the bit at offset of data, 0 past its end.
+func bitAt(data []byte, offset int64) int {
+	i := offset / 8
+	if i >= int64(len(data)) {
+		return 0
+	}
+	return int(data[i]>>(7-offset%8)) & 1
+}
+
+var errBitmapSpilled = errors.New("the value is spilled to disk and cannot be edited")
+
+// SETBIT: POST /kv/{key}/setbit {"offset": 7, "value": 1} sets or clears
+// one bit of the value at key and returns its previous state:
+//
+//	{"previous": 0}
+//
+// The read and write are one atomic step, so concurrent SETBITs on the
+// same key all land. An existing key keeps its TTL and content type; a
+// missing one is created as application/octet-stream.
+func (s *KVServer) handleSetBit(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+	s.metrics.TotalPuts.Add(1)
+	var req struct {
+		Offset *int64 `json:"offset"`
+		Value  int    `json:"value"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidBody, "invalid body: "+err.Error())
+		return
+	}
+	if req.Offset == nil || *req.Offset < 0 || *req.Offset > maxBitOffset {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, errBitOffset.Error())
+		return
+	}
+	offset := *req.Offset
+	if req.Value != 0 && req.Value != 1 {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "value must be 0 or 1")
+		return
+	}
+	need := offset/8 + 1
+	if s.maxValueSize > 0 && need > s.maxValueSize {
+		writeError(w, r, http.StatusRequestEntityTooLarge, codeValueTooLarge,
+			fmt.Sprintf("offset %d is past the %d byte limit", offset, s.maxValueSize))
+		return
+	}
+
+	p, _ := principalFrom(r.Context())
+	size := need
+	if cur, ok := s.store.Get(key); ok && !cur.isExpired(time.Now()) {
+		size = max(size, cur.size())
+	}
+	if serr := s.quotaError(p, ns, key, size); serr != nil {
+		serr.write(w, r)
+		return
+	}
+	if ns != nil {
+		ns.stats.puts.Add(1)
+	}
+
+	var stored StoredValue
+	previous := 0
+	_, sp := s.tracer.start(r.Context(), "store.setbit", spanKindInternal)
+	version := s.nextVersion()
+	err := s.store.ComputeErr(key, func(old StoredValue, exists bool) (StoredValue, bool, error) {
+		now := time.Now()
+		v := StoredValue{ContentType: "application/octet-stream", Owner: p.TokenID, Version: version}
+		v.stamp(now)
+		var data []byte
+		if exists && !old.isExpired(now) {
+			if old.Spill != "" {
+				return old, exists, errBitmapSpilled
+			}
+			old, _ = s.whole(key, old)
+			data = old.plain(s.valueKeys).Data
+			v.HasTTL, v.ExpiresAt, v.ContentType, v.Lease, v.Flags, v.Tags = old.HasTTL, old.ExpiresAt, old.ContentType, old.Lease, old.Flags, old.Tags
+			v.inherit(old)
+		} else {
+			s.applyTTLPolicy(ns, &v, now)
+		}
+		// Copy: data may be shared with readers of the old value.
+		v.Data = slices.Clone(data)
+		if grow := need - int64(len(v.Data)); grow > 0 {
+			v.Data = append(v.Data, make([]byte, grow)...)
+		}
+		previous = bitAt(v.Data, offset)
+		mask := byte(1) << (7 - offset%8)
+		if req.Value == 1 {
+			v.Data[offset/8] |= mask
+		} else {
+			v.Data[offset/8] &^= mask
+		}
+		s.encode(&v)
+		stored = v
+		return v, true, nil
+	})
+	sp.finish()
+	switch {
+	case errors.Is(err, errBitmapSpilled):
+		writeError(w, r, http.StatusConflict, codeValueSpilled, err.Error())
+		return
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	if !s.persist(w, r) {
+		return
+	}
+
+	w.Header().Set("ETag", stored.etag())
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]int{"previous": previous})
+}
+
+// GETBIT: GET /kv/{key}/getbit?offset=7 returns one bit of the value at
+// key, 0 past its end or for a missing key:
+//
+//	{"bit": 1}
+func (s *KVServer) handleGetBit(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+	s.metrics.TotalGets.Add(1)
+	offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil || offset < 0 || offset > maxBitOffset {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, errBitOffset.Error())
+		return
+	}
+	bit := 0
+	if value, ok := s.fetch(r.Context(), key, ns); ok {
+		bit = bitAt(value.Data, offset)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]int{"bit": bit})
+}
+
+// BITCOUNT: GET /kv/{key}/bitcount?start=0&end=-1 counts the set bits of
+// the value at key, 0 for a missing key:
+//
+//	{"count": 26}
+//
+// start and end (both inclusive, default the whole value) are byte
+// offsets, or bit offsets with unit=bit; negative ones count back from
+// the end, as in Redis.
+func (s *KVServer) handleBitCount(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+	s.metrics.TotalGets.Add(1)
+	q := r.URL.Query()
+	start, end := int64(0), int64(-1)
+	for _, param := range []struct {
+		name string
+		dst  *int64
+	}{{"start", &start}, {"end", &end}} {
+		if v := q.Get(param.name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				writeError(w, r, http.StatusBadRequest, codeBadRequest, "invalid "+param.name)
+				return
+			}
+			*param.dst = n
+		}
+	}
+	inBits := false
+	switch q.Get("unit") {
+	case "", "byte":
+	case "bit":
+		inBits = true
+	default:
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "unit must be byte or bit")
+		return
+	}
+
+	var data []byte
+	if value, ok := s.fetch(r.Context(), key, ns); ok {
+		data = value.Data
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]int64{"count": bitCount(data, start, end, inBits)})
+}
+
+// bitCount counts the set bits of data from start to end inclusive, byte
+// offsets or, if inBits, bit offsets; negative offsets count from the end.
+func bitCount(data []byte, start, end int64, inBits bool) int64 {
+	n := int64(len(data))
+	if inBits {
+		n *= 8
+	}
+	if start < 0 {
+		start = max(start+n, 0)
+	}
+	if end < 0 {
+		end += n
+	}
+	end = min(end, n-1)
+	if start > end {
+		return 0
+	}
+	var count int64
+	if !inBits {
+		for _, b := range data[start : end+1] {
+			count += int64(bits.OnesCount8(b))
+		}
+		return count
+	}
+	for i := start; i <= end; {
+		if i%8 == 0 && i+7 <= end {
+			count += int64(bits.OnesCount8(data[i/8]))
+			i += 8
+			continue
+		}
+		count += int64(bitAt(data, i))
+		i++
+	}
+	return count
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/shubhamc1947/safemap/pkg/concurrentmap"
+)
+
+// ----------- Collection Values -----------
+
+// A list, set or sorted set keeps its elements in its index, a ListMap,
+// SetMap or SortedSetMap by store key, rather than in the value's Data,
+// so an edit costs what it changes instead of the size of the
+// collection. The store holds its header: the content type, TTL,
+// version, lease and tags, with Elements set.
+//
+// An edit writes a new header carrying a collectionEdit. The index
+// follows the store's events like every mirror and applies the edit in
+// place under the bucket lock, and the AOF logs the edit rather than the
+// value. A whole value (a PUT of the content type, a restore, a snapshot
+// or a rewritten AOF) replaces the elements. What needs the value as
+// bytes (GET /kv/{key}, exports, snapshots, AOF rewrites) encodes it
+// from the index with whole. Like spilled values, headers are not kept
+// in a versioned namespace's history, and watchers get their content
+// type but not their elements.
+
+// collectionEdit is a change to the elements of a collection, written
+// with the header it produced and logged in the AOF as it is. Its
+// version is taken under the bucket lock, so a key's edits are numbered
+// in the order they apply, which AOF replay relies on.
+type collectionEdit struct {
+	// Reset empties the elements first: the key held no live value.
+	Reset bool `json:"reset,omitempty"`
+
+	// Scores sets the scores of sorted set members, adding the missing.
+	Scores map[string]float64 `json:"scores,omitempty"`
+	// Add adds set members.
+	Add []string `json:"add,omitempty"`
+	// Remove removes set or sorted set members.
+	Remove []string `json:"remove,omitempty"`
+
+	// Pop removes list elements from the head if Front, else the tail;
+	// then Push pushes elements there, one by one.
+	Pop   int               `json:"pop,omitempty"`
+	Push  []json.RawMessage `json:"push,omitempty"`
+	Front bool              `json:"front,omitempty"`
+
+	version uint64 // of the header written with it
+}
+
+// editOf returns the edit an insert or update wrote, nil if it wrote
+// none: a whole value, a header whose TTL alone changed, or one that
+// carried an older edit along.
+func editOf(ev concurrentmap.Event[string, StoredValue]) *collectionEdit {
+	v := ev.NewValue
+	if v.Edit == nil || v.Edit.version != v.Version || ev.Type == concurrentmap.EventUpdate && ev.OldValue.Version == v.Version {
+		return nil
+	}
+	return v.Edit
+}
+
+// newHeader returns the header an edit writes to a collection of
+// contentType, replacing old, live if it was: it keeps the TTL, lease and
+// tags of a live value, or gets the namespace's TTL policy. size
+// estimates the elements' length after the edit.
+func (s *KVServer) newHeader(p principal, ns *namespace, contentType string, old StoredValue, live bool, edit *collectionEdit, size int64, now time.Time) StoredValue {
+	v := StoredValue{ContentType: contentType, Owner: p.TokenID, Version: edit.version,
+		Elements: true, ElementsSize: max(size, 0), Edit: edit}
+	v.stamp(now)
+	if live {
+		v.HasTTL, v.ExpiresAt, v.Lease, v.Tags = old.HasTTL, old.ExpiresAt, old.Lease, old.Tags
+		v.inherit(old)
+	} else {
+		s.applyTTLPolicy(ns, &v, now)
+	}
+	return v
+}
+
+// whole returns v, the value at key, with a header's elements encoded
+// into Data as the value would be stored whole, and whether it has any:
+// none means the key was deleted or rewritten since v was read. Other
+// values are returned as they are.
+func (s *KVServer) whole(key string, v StoredValue) (StoredValue, bool) {
+	if !v.Elements {
+		return v, true
+	}
+	found := false
+	switch v.ContentType {
+	case listContentType:
+		elems := s.lists.elems.RangeList(key, 0, -1)
+		v.Data, _ = json.Marshal(elems)
+		found = len(elems) > 0
+	case setContentType:
+		members := s.sets.members.Members(key)
+		slices.Sort(members)
+		v.Data, _ = json.Marshal(members)
+		found = len(members) > 0
+	case zsetContentType:
+		members := s.zsets.sets.ZRange(key, 0, -1)
+		scores := make(map[string]float64, len(members))
+		for _, m := range members {
+			scores[m.Member] = m.Score
+		}
+		v.Data, _ = json.Marshal(scores)
+		found = len(members) > 0
+	}
+	v.Elements, v.ElementsSize, v.Edit = false, 0, nil
+	return v, found
+}
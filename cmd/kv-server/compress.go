@@ -0,0 +1,188 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ----------- Response Compression -----------
+
+// With --compress-min-size, responses of at least that many bytes are
+// gzipped for clients that accept it. Smaller ones, streams of events,
+// already-encoded bodies and media types that are compressed anyway go
+// out as they are. A response flushed before it reaches the threshold,
+// such as an export, is compressed from then on.
+
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+
+// acceptsGzip reports whether the Accept-Encoding header allows gzip.
+func acceptsGzip(header string) bool {
+	accepted := false
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, _ = strconv.ParseFloat(v, 64)
+		}
+		if coding == "gzip" {
+			return q > 0 // an explicit gzip entry wins over *
+		}
+		accepted = q > 0
+	}
+	return accepted
+}
+
+// compressible reports whether a response with these headers and status
+// may be gzipped.
+func compressible(h http.Header, status int) bool {
+	if status < 200 || status == http.StatusNoContent || status == http.StatusPartialContent || status == http.StatusNotModified || h.Get("Content-Encoding") != "" {
+		return false
+	}
+	ct := h.Get("Content-Type")
+	for _, prefix := range []string{"text/event-stream", "image/", "video/", "audio/",
+		"application/gzip", "application/zip", "application/zstd", "application/x-gzip"} {
+		if strings.HasPrefix(ct, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// Compression middleware: gzips responses as described above.
+func (s *KVServer) compressMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.compressMinSize <= 0 || r.Method == http.MethodHead || isWebSocketPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w, minSize: s.compressMinSize}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// gzipResponseWriter holds the start of a response back until it knows
+// whether to compress it: once minSize bytes are written, on Flush, or
+// when the handler returns.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	minSize int
+
+	status  int // held back until decided; 0 if none yet
+	buf     []byte
+	decided bool
+	gz      *gzip.Writer // nil unless compressing
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	switch {
+	case w.decided || code < 200:
+		w.ResponseWriter.WriteHeader(code)
+	case w.status == 0:
+		w.status = code
+	}
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if w.decided {
+		if w.gz != nil {
+			return w.gz.Write(p)
+		}
+		return w.ResponseWriter.Write(p)
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if !compressible(w.Header(), w.status) {
+		if err := w.decide(false); err != nil {
+			return 0, err
+		}
+		return w.ResponseWriter.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.minSize {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// decide sends the held-back header and bytes, compressed or not.
+func (w *gzipResponseWriter) decide(compress bool) error {
+	w.decided = true
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if compress && compressible(w.Header(), w.status) {
+		h := w.Header()
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+		w.gz = gzipWriters.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.gz != nil {
+		_, err = w.gz.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// FlushError sends what is written so far; a response that has not been
+// decided yet is compressed, as a stream.
+func (w *gzipResponseWriter) FlushError() error {
+	if !w.decided {
+		if err := w.decide(true); err != nil {
+			return err
+		}
+	}
+	if w.gz != nil {
+		if err := w.gz.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *gzipResponseWriter) Flush() {
+	_ = w.FlushError()
+}
+
+// Unwrap lets http.ResponseController reach the connection.
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// close finishes the response once the handler returns. A response that
+// was never started is left alone: the connection may have been hijacked.
+func (w *gzipResponseWriter) close() {
+	if !w.decided && (w.status != 0 || len(w.buf) > 0) {
+		_ = w.decide(false)
+	}
+	if w.gz != nil {
+		_ = w.gz.Close()
+		w.gz.Reset(nil)
+		gzipWriters.Put(w.gz)
+		w.gz = nil
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shubhamc1947/safemap/pkg/concurrentmap"
+)
+
+// ----------- Versions and Conditional Requests -----------
+
+// Every write through the key API stamps the value with the next version
+// from a server-wide counter. Versions are persisted with the value, so
+// ETags survive restarts, and never repeat, so a key that is deleted and
+// recreated does not match ETags from its previous life.
+
+var errPreconditionFailed = errors.New("precondition failed")
+
+// nextVersion returns a version for a new write.
+func (s *KVServer) nextVersion() uint64 {
+	return s.versions.Add(1)
+}
+
+// raiseVersions moves the version counter past every version in the store
+// and past the current time in microseconds. The clock covers versions of
+// keys deleted before a restart, which the store no longer shows, as long
+// as writes never outpaced one version per microsecond. Call it once
+// persisted data is loaded, and after a restore.
+func (s *KVServer) raiseVersions() {
+	highest := uint64(time.Now().UnixMicro())
+	s.store.Range(func(_ string, v StoredValue) bool {
+		highest = max(highest, v.Version)
+		return true
+	})
+	for {
+		cur := s.versions.Load()
+		if cur >= highest || s.versions.CompareAndSwap(cur, highest) {
+			return
+		}
+	}
+}
+
+// etag identifies a value for caches and conditional requests: its
+// version, or for values written before versions existed, a hash of its
+// content.
+func (v StoredValue) etag() string {
+	if v.Version != 0 {
+		return `"` + strconv.FormatUint(v.Version, 10) + `"`
+	}
+	return `"h` + strconv.FormatUint(concurrentmap.XXHash64(v.ContentType+"\x00"+string(v.Data)), 16) + `"`
+}
+
+// etagMatches evaluates an If-Match header against the current value
+// (live is false when there is none). Comparison is strong: weak tags
+// never match.
+func etagMatches(header string, v StoredValue, live bool) bool {
+	if !live {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	tag := v.etag()
+	for _, t := range strings.Split(header, ",") {
+		if strings.TrimSpace(t) == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// etagNoneMatch evaluates an If-None-Match header against a live value.
+// GETs compare weakly, so W/"1" matches "1".
+func etagNoneMatch(header string, v StoredValue) bool {
+	if strings.TrimSpace(header) == "*" {
+		return false
+	}
+	tag := v.etag()
+	for _, t := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(t), "W/") == tag {
+			return false
+		}
+	}
+	return true
+}
+
+// notModified answers a GET or HEAD with 304 when If-None-Match matches
+// value. The caller has already set the value's headers.
+func notModified(w http.ResponseWriter, r *http.Request, value StoredValue) bool {
+	cond := r.Header.Get("If-None-Match")
+	if cond == "" || etagNoneMatch(cond, value) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// setCacheHeaders lets HTTP caches keep a value until it expires. Values
+// without a TTL can change at any time, so caches must revalidate them,
+// which If-None-Match makes cheap. Responses to authenticated requests
+// are private: shared caches do not know that X-API-Key varies them.
+func setCacheHeaders(h http.Header, r *http.Request, value StoredValue) {
+	scope := "public"
+	if apiKeyFromRequest(r) != "" {
+		scope = "private"
+	}
+	if !value.HasTTL {
+		h.Set("Cache-Control", scope+", no-cache")
+		return
+	}
+	left := max(0, int64(time.Until(value.ExpiresAt)/time.Second))
+	h.Set("Cache-Control", scope+", max-age="+strconv.FormatInt(left, 10))
+	h.Set("Expires", value.ExpiresAt.UTC().Format(http.TimeFormat))
+}
+
+// compareAndSwap stores v at key (or deletes key when v is nil) only if
+// the current value matches the If-Match header. It reports false, with
+// nothing changed, on a mismatch.
+func (s *KVServer) compareAndSwap(key, ifMatch string, v *StoredValue) bool {
+	err := s.store.ComputeErr(key, func(old StoredValue, exists bool) (StoredValue, bool, error) {
+		if !etagMatches(ifMatch, old, exists && !old.isExpired(time.Now())) {
+			return old, exists, errPreconditionFailed
+		}
+		if v == nil {
+			return old, false, nil
+		}
+		v.inherit(old)
+		return *v, true, nil
+	})
+	return err == nil
+}
+
+// writeCond is the precondition of a conditional PUT.
+type writeCond struct {
+	ifMatch    string // If-Match header
+	createOnly bool   // If-None-Match: * or ?nx=1
+}
+
+// holds reports whether c holds for the value now at key. A write checks
+// it before making room for its value, so one bound to fail evicts
+// nothing; the store checks it again when the write commits.
+func (c writeCond) holds(store *concurrentmap.ConcurrentMap[string, StoredValue], key string) bool {
+	old, exists := store.Get(key)
+	live := exists && !old.isExpired(time.Now())
+	switch {
+	case c.ifMatch != "":
+		return etagMatches(c.ifMatch, old, live)
+	case c.createOnly:
+		return !live
+	}
+	return true
+}
+
+// failed returns the error for a write whose precondition c does not hold.
+func (c writeCond) failed() *statusError {
+	if c.ifMatch != "" {
+		return &statusError{http.StatusPreconditionFailed, codePreconditionFailed, "If-Match does not match the current value"}
+	}
+	return &statusError{http.StatusConflict, codeKeyExists, "key already exists"}
+}
+
+// putCond reads a PUT's precondition, writing the error response if it
+// is invalid. Only "*" is accepted in If-None-Match on writes.
+func putCond(w http.ResponseWriter, r *http.Request) (writeCond, bool) {
+	cond := writeCond{ifMatch: r.Header.Get("If-Match")}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if strings.TrimSpace(inm) != "*" {
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, "PUT supports only If-None-Match: *")
+			return cond, false
+		}
+		cond.createOnly = true
+	}
+	if nx := r.URL.Query().Get("nx"); nx != "" {
+		createOnly, err := strconv.ParseBool(nx)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, "invalid nx")
+			return cond, false
+		}
+		cond.createOnly = cond.createOnly || createOnly
+	}
+	if cond.createOnly && cond.ifMatch != "" {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "If-Match cannot be combined with create-only")
+		return cond, false
+	}
+	return cond, true
+}
+
+// createOnly stores v at key only if the key has no live value, like
+// Redis SETNX, and reports whether it did. An expired value still in the
+// store counts as absent: it is expired and the store tried again.
+func (s *KVServer) createOnly(key string, v StoredValue) bool {
+	for {
+		cur, loaded := s.store.LoadOrStore(key, v)
+		if !loaded {
+			return true
+		}
+		now := time.Now()
+		if !cur.isExpired(now) {
+			return false
+		}
+		if s.store.ExpireIf(key, func(v StoredValue) bool { return v.isExpired(now) }) {
+			s.metrics.Expiry.LazyExpired.Add(1)
+		}
+	}
+}
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ----------- Server Config -----------
+
+// serverConfig holds every server setting. Each field is a flag, and can
+// also be set from the --config file.
+type serverConfig struct {
+	ConfigPath        string
+	Port              int
+	Buckets           int
+	AuthToken         string
+	AdminToken        string
+	Pprof             bool
+	ACL               bool
+	JWTSecret         string
+	JWTJWKSURL        string
+	JWTIssuer         string
+	JWTAudience       string
+	JWTScopeClaim     string
+	JWTNamespaceClaim string
+	RateLimit         int
+	RateWindow        time.Duration
+	RateAlgorithm     string
+	ReadRateLimit     int
+	WriteRateLimit    int
+	RateLimitBackend  string
+	KeyRateLimits     string
+	TrustedProxies    string
+	TTLScanInterval   time.Duration
+	TTLSlowScan       time.Duration
+	DefaultTTL        time.Duration
+	MaxTTL            time.Duration
+	AOFPath           string
+	AOFFsync          string
+	AOFRewriteMinSize int64
+	AOFRewritePercent int
+	SnapshotPath      string
+	SnapshotInterval  time.Duration
+	BackupURL         string
+	BackupKeep        int
+	EncryptionKeyFile string
+	EncryptValues     bool
+	PreloadPath       string
+	TLSCert           string
+	TLSKey            string
+	TLSClientCA       string
+	Listen            listenFlag
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	H2C               bool
+	CompressMinSize   int
+	MaxHeaderBytes    int
+	MaxConns          int
+	MaxValueSize      int64
+	ReadOnly          bool
+	MaxKeys           int64
+	MaxMemory         int64
+	EvictionPolicy    string
+	SpillDir          string
+	SpillThreshold    int64
+	ValueCompressMin  int
+	MaxKeyLength      int
+	MaxBatchOps       int
+	WatchHistory      int
+	WebhookTimeout    time.Duration
+	MemcachedAddr     string
+	MemcachedInsecure bool
+	GRPCAddr          string
+	BinaryAddr        string
+	WebhookRetries    int
+	ShutdownTimeout   time.Duration
+	LogLevel          string
+	LogFormat         string
+	OTLPEndpoint      string
+	StatsdAddr        string
+	StatsdPrefix      string
+	StatsdTags        string
+	TraceSampleRatio  float64
+}
+
+func (c *serverConfig) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	fs.StringVar(&c.ConfigPath, "config", "", "TOML config file; command-line flags override it and SIGHUP reloads it")
+	fs.IntVar(&c.Port, "port", 8080, "Port to listen on")
+	fs.IntVar(&c.Buckets, "buckets", 64, "Number of shards/buckets")
+	fs.StringVar(&c.AuthToken, "auth-token", "", "Optional static auth token (X-API-Key / Authorization)")
+	fs.StringVar(&c.AdminToken, "admin-token", "", "Separate token required for /admin/ and /debug/ routes (default: --auth-token)")
+	fs.BoolVar(&c.ACL, "acl", false, "Deny key operations unless an /admin/acl rule allows them (admin tokens are exempt)")
+	fs.StringVar(&c.JWTSecret, "jwt-secret", os.Getenv("KV_JWT_SECRET"), "Accept HS256/384/512 JWTs signed with this secret (default $KV_JWT_SECRET)")
+	fs.StringVar(&c.JWTJWKSURL, "jwt-jwks-url", "", "Accept RS*/ES* JWTs signed by keys published at this JWKS URL")
+	fs.StringVar(&c.JWTIssuer, "jwt-issuer", "", "Required JWT iss claim")
+	fs.StringVar(&c.JWTAudience, "jwt-audience", "", "Required JWT aud claim")
+	fs.StringVar(&c.JWTScopeClaim, "jwt-scope-claim", "scope", "JWT claim holding scopes (read, write, admin; optional kv: prefix)")
+	fs.StringVar(&c.JWTNamespaceClaim, "jwt-namespace-claim", "namespaces", "JWT claim listing the namespaces the caller may use")
+	fs.BoolVar(&c.Pprof, "pprof", false, "Serve /debug/pprof/ (requires --auth-token or --admin-token)")
+	fs.IntVar(&c.RateLimit, "rate-limit", 0, "Max requests per client per window (0 = disabled)")
+	fs.DurationVar(&c.RateWindow, "rate-window", time.Minute, "Rate limit window duration")
+	fs.StringVar(&c.RateAlgorithm, "rate-algorithm", "fixed", "Rate limit algorithm: fixed, sliding, token-bucket or gcra")
+	fs.IntVar(&c.ReadRateLimit, "read-rate-limit", 0, "Max GET/HEAD requests per client per window (0 = disabled)")
+	fs.IntVar(&c.WriteRateLimit, "write-rate-limit", 0, "Max write requests per client per window (0 = disabled)")
+	fs.StringVar(&c.RateLimitBackend, "rate-limit-backend", "memory", "Where rate-limit counters live: memory, or store (a ConcurrentMap of their own, shared once replicated)")
+	fs.StringVar(&c.KeyRateLimits, "key-rate-limits", "", "Per-API-key limits per window, e.g. key1=1000,key2=50")
+	fs.StringVar(&c.TrustedProxies, "trusted-proxies", "", "Comma-separated proxy CIDRs whose X-Forwarded-For / X-Real-IP headers are trusted")
+	fs.DurationVar(&c.TTLScanInterval, "ttl-scan-interval", 100*time.Millisecond, "How often active expiry samples each shard for expired keys")
+	fs.DurationVar(&c.TTLSlowScan, "ttl-slow-scan", time.Second, "Log a warning when an expiry scan takes longer than this (0 = never)")
+	fs.DurationVar(&c.DefaultTTL, "default-ttl", 0, "TTL for writes that set none and have no namespace default (0 = none)")
+	fs.DurationVar(&c.MaxTTL, "max-ttl", 0, "Cap on every key's TTL; keys written without one get it too (0 = no cap)")
+	fs.StringVar(&c.AOFPath, "aof-path", "", "Append-only file for persistence (empty = disabled)")
+	fs.StringVar(&c.AOFFsync, "aof-fsync", "everysec", "AOF fsync policy: always, everysec or no")
+	fs.Int64Var(&c.AOFRewriteMinSize, "aof-rewrite-min-size", 64<<20, "Minimum AOF size in bytes before automatic rewrite")
+	fs.IntVar(&c.AOFRewritePercent, "aof-rewrite-percent", 100, "Rewrite the AOF when it grows by this percent since the last rewrite")
+	fs.StringVar(&c.SnapshotPath, "snapshot-path", "", "Snapshot file, loaded on startup when no AOF is used (empty = disabled)")
+	fs.DurationVar(&c.SnapshotInterval, "snapshot-interval", 0, "Save a snapshot this often (0 = only on demand)")
+	fs.StringVar(&c.BackupURL, "backup-url", "", "Upload snapshots to s3://bucket/prefix, gs://bucket/prefix or file:///dir")
+	fs.IntVar(&c.BackupKeep, "backup-keep", 7, "Number of most recent backups to keep")
+	fs.StringVar(&c.EncryptionKeyFile, "encryption-key-file", "", "File of AES-256 keys (hex or base64, one per line, active first) for encrypting the AOF and snapshots; defaults to $"+encryptionKeysEnv)
+	fs.BoolVar(&c.EncryptValues, "encrypt-values", false, "Encrypt values with the active --encryption-key-file key before they are stored, in memory and on disk")
+	fs.StringVar(&c.PreloadPath, "preload-file", "", "JSON-lines file of keys to load at startup")
+	fs.StringVar(&c.TLSCert, "tls-cert", "", "TLS certificate file (PEM); enables HTTPS together with --tls-key")
+	fs.StringVar(&c.TLSKey, "tls-key", "", "TLS private key file (PEM)")
+	fs.StringVar(&c.TLSClientCA, "tls-client-ca", "", "CA bundle (PEM) for verifying client certificates; enables mutual TLS")
+	fs.Var(&c.Listen, "listen", "Address to serve on, repeatable: host:port, tls://host:port, unix:///path, systemd[+tls] (default :<port>)")
+	fs.DurationVar(&c.ReadTimeout, "read-timeout", 30*time.Second, "Max time to read a request including its body (0 = none)")
+	fs.DurationVar(&c.ReadHeaderTimeout, "read-header-timeout", 5*time.Second, "Max time to read request headers (0 = use --read-timeout)")
+	fs.DurationVar(&c.WriteTimeout, "write-timeout", 30*time.Second, "Max time to write a response (0 = none)")
+	fs.DurationVar(&c.IdleTimeout, "idle-timeout", 2*time.Minute, "Max keep-alive idle time (0 = use --read-timeout)")
+	fs.BoolVar(&c.H2C, "h2c", false, "Also accept HTTP/2 without TLS (prior knowledge) on plain listeners")
+	fs.IntVar(&c.CompressMinSize, "compress-min-size", 0, "Gzip responses of at least this many bytes for clients that accept it (0 = disabled)")
+	fs.IntVar(&c.MaxHeaderBytes, "max-header-bytes", http.DefaultMaxHeaderBytes, "Max size of request headers in bytes")
+	fs.IntVar(&c.MaxConns, "max-conns", 0, "Max concurrent connections across all listeners (0 = unlimited)")
+	fs.Int64Var(&c.MaxValueSize, "max-value-size", 1<<20, "Max PUT body size in bytes (0 = unlimited)")
+	fs.BoolVar(&c.ReadOnly, "read-only", false, "Start read-only: writes get 503 until POST /admin/readonly turns it off")
+	fs.Int64Var(&c.MaxKeys, "max-keys", 0, "Max keys in the store; writes over it evict per --eviction-policy (0 = unlimited)")
+	fs.Int64Var(&c.MaxMemory, "max-memory", 0, "Max estimated bytes of keys and values in the store; writes over it evict per --eviction-policy (0 = unlimited)")
+	fs.StringVar(&c.EvictionPolicy, "eviction-policy", "noeviction", "What to evict at --max-keys or --max-memory: noeviction, allkeys-lru, volatile-lru or volatile-ttl")
+	fs.StringVar(&c.SpillDir, "spill-dir", "", "Directory for raw PUT bodies over --spill-threshold, streamed to disk instead of memory (empty = disabled)")
+	fs.Int64Var(&c.SpillThreshold, "spill-threshold", 8<<20, "Longest raw PUT body in bytes kept in memory when --spill-dir is set")
+	fs.IntVar(&c.ValueCompressMin, "value-compression-min-size", 0, "Keep values of at least this many bytes Snappy-compressed in memory and on disk (0 = disabled)")
+	fs.IntVar(&c.MaxKeyLength, "max-key-length", 1024, "Max key length in bytes (0 = unlimited)")
+	fs.IntVar(&c.MaxBatchOps, "max-batch-ops", 1000, "Max operations in one POST /kv/_batch (0 = unlimited)")
+	fs.IntVar(&c.WatchHistory, "watch-history", 10000, "Recent changes kept for watchers resuming with Last-Event-ID (0 = no resuming)")
+	fs.DurationVar(&c.WebhookTimeout, "webhook-timeout", 5*time.Second, "Timeout for each webhook delivery attempt")
+	fs.IntVar(&c.WebhookRetries, "webhook-retries", 3, "Retries of a failed webhook delivery, with exponential backoff from 500ms, before it is a dead letter")
+	fs.StringVar(&c.MemcachedAddr, "memcached-addr", "", "Also serve the memcached text protocol on this host:port, unauthenticated (empty = disabled)")
+	fs.BoolVar(&c.MemcachedInsecure, "memcached-insecure", false, "Serve --memcached-addr even though --auth-token or JWT auth is set and --acl is off: its clients get read and write without credentials")
+	fs.StringVar(&c.GRPCAddr, "grpc-addr", "", "Also serve the gRPC API on this host:port, over TLS when --tls-cert is set (empty = disabled)")
+	fs.StringVar(&c.BinaryAddr, "binary-addr", "", "Also serve the compact binary protocol on this host:port (empty = disabled)")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "How long to wait for in-flight requests on shutdown")
+	fs.StringVar(&c.LogLevel, "log-level", "info", "Minimum log level: debug, info, warn or error")
+	fs.StringVar(&c.LogFormat, "log-format", "text", "Log output format: text or json")
+	fs.StringVar(&c.OTLPEndpoint, "otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "OTLP/HTTP collector URL for traces and metrics, e.g. http://localhost:4318 (empty = disabled)")
+	fs.Float64Var(&c.TraceSampleRatio, "trace-sample-ratio", 1, "Fraction of new traces to sample; incoming traceparent decisions are kept")
+	fs.StringVar(&c.StatsdAddr, "statsd-addr", "", "StatsD/DogStatsD agent host:port (UDP) to push metrics to (empty = disabled)")
+	fs.StringVar(&c.StatsdPrefix, "statsd-prefix", "kv.", "Prefix for StatsD metric names")
+	fs.StringVar(&c.StatsdTags, "statsd-tags", "", "Tags added to every StatsD metric, e.g. env:prod,service:kv")
+	return fs
+}
+
+// loadConfig parses args and then the --config file, if any. A flag set
+// on the command line wins over the file.
+func loadConfig(args []string) (*serverConfig, *flag.FlagSet, error) {
+	cfg := new(serverConfig)
+	fs := cfg.flagSet()
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	if cfg.ConfigPath == "" {
+		return cfg, fs, nil
+	}
+
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	values, err := parseConfigFile(cfg.ConfigPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := applyConfig(fs, values, explicit); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", cfg.ConfigPath, err)
+	}
+	return cfg, fs, nil
+}
+
+// rateLimitConfig returns the rate limit settings, with counters in
+// counters when --rate-limit-backend=store.
+func (c *serverConfig) rateLimitConfig(counters *rateCounters) (rateLimitConfig, error) {
+	rl := rateLimitConfig{
+		Algorithm:  c.RateAlgorithm,
+		Window:     c.RateWindow,
+		Limit:      c.RateLimit,
+		ReadLimit:  c.ReadRateLimit,
+		WriteLimit: c.WriteRateLimit,
+		KeyLimits:  c.KeyRateLimits,
+	}
+	switch c.RateLimitBackend {
+	case "memory":
+	case "store":
+		rl.Counters = counters
+	default:
+		return rl, fmt.Errorf("invalid --rate-limit-backend %q, want memory or store", c.RateLimitBackend)
+	}
+	return rl, nil
+}
+
+// ----------- Config File -----------
+
+// A config file sets flags by name, in a subset of TOML:
+//
+//	# comments, blank lines
+//	port = 8080
+//	auth-token = "secret"
+//	listen = ["tcp://:8080", "unix:///run/kv.sock"]
+//
+//	[aof]            # keys below are prefixed: aof-path, aof-fsync
+//	path = "/var/lib/kv/data.aof"
+//	fsync = "everysec"
+//
+// Underscores in keys are read as dashes.
+
+// configValue is one setting; lists hold one entry per element.
+type configValue struct {
+	name   string
+	values []string
+	line   int
+}
+
+// parseConfigFile reads the settings in path, in file order.
+func parseConfigFile(path string) ([]configValue, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		out     []configValue
+		section string
+		sc      = bufio.NewScanner(f)
+	)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(stripComment(sc.Text()))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("%s:%d: malformed section header", path, n)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+
+		key, raw, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: want key = value", path, n)
+		}
+		key = strings.ReplaceAll(strings.TrimSpace(key), "_", "-")
+		if section != "" {
+			key = strings.ReplaceAll(section, "_", "-") + "-" + key
+		}
+		values, err := parseConfigValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s: %w", path, n, key, err)
+		}
+		out = append(out, configValue{name: key, values: values, line: n})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// stripComment removes a trailing # comment outside of quotes.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// parseConfigValue parses a scalar or a single-line array of scalars.
+func parseConfigValue(raw string) ([]string, error) {
+	if !strings.HasPrefix(raw, "[") {
+		v, err := parseConfigScalar(raw)
+		if err != nil {
+			return nil, err
+		}
+		return []string{v}, nil
+	}
+	if !strings.HasSuffix(raw, "]") {
+		return nil, fmt.Errorf("arrays must be on one line")
+	}
+
+	var out []string
+	rest := strings.TrimSpace(raw[1 : len(raw)-1])
+	for rest != "" {
+		end := scalarEnd(rest)
+		v, err := parseConfigScalar(strings.TrimSpace(rest[:end]))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+		rest = strings.TrimSpace(rest[end:])
+		rest = strings.TrimSpace(strings.TrimPrefix(rest, ","))
+	}
+	return out, nil
+}
+
+// scalarEnd returns the length of the first array element in s.
+func scalarEnd(s string) int {
+	if s[0] == '"' || s[0] == '\'' {
+		for i := 1; i < len(s); i++ {
+			if s[i] == '\\' && s[0] == '"' {
+				i++
+			} else if s[i] == s[0] {
+				return i + 1
+			}
+		}
+		return len(s)
+	}
+	if i := strings.IndexByte(s, ','); i >= 0 {
+		return i
+	}
+	return len(s)
+}
+
+// parseConfigScalar unquotes strings; bare values (numbers, booleans,
+// durations) are passed through for the flag to parse.
+func parseConfigScalar(s string) (string, error) {
+	switch {
+	case s == "":
+		return "", fmt.Errorf("missing value")
+	case s[0] == '"':
+		return strconv.Unquote(s)
+	case s[0] == '\'':
+		if len(s) < 2 || s[len(s)-1] != '\'' {
+			return "", fmt.Errorf("unterminated string")
+		}
+		return s[1 : len(s)-1], nil
+	default:
+		return s, nil
+	}
+}
+
+// applyConfig sets each flag in fs from values, except those in skip.
+func applyConfig(fs *flag.FlagSet, values []configValue, skip map[string]bool) error {
+	for _, cv := range values {
+		if fs.Lookup(cv.name) == nil || cv.name == "config" {
+			return fmt.Errorf("line %d: unknown setting %q", cv.line, cv.name)
+		}
+		if skip[cv.name] {
+			continue
+		}
+		for _, v := range cv.values {
+			if err := fs.Set(cv.name, v); err != nil {
+				return fmt.Errorf("line %d: %w", cv.line, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ----------- Hot Reload -----------
+
+// reloadableFlags are applied by SIGHUP while the server runs. A change
+// to any other setting is logged and waits for a restart.
+var reloadableFlags = map[string]bool{
+	"rate-limit":       true,
+	"rate-window":      true,
+	"rate-algorithm":   true,
+	"read-rate-limit":  true,
+	"write-rate-limit": true,
+	"key-rate-limits":  true,
+	"log-level":        true,
+}
+
+// configReloader re-reads the config file and TLS certificates on SIGHUP.
+type configReloader struct {
+	args   []string // command line, re-parsed so it keeps precedence
+	fs     *flag.FlagSet
+	mu     sync.RWMutex // guards fs's values, set on reload
+	server *KVServer
+	certs  *certReloader // nil without TLS
+}
+
+// secretFlags are redacted when the configuration is shown.
+var secretFlags = map[string]bool{
+	"auth-token":      true,
+	"admin-token":     true,
+	"jwt-secret":      true,
+	"key-rate-limits": true, // keys API keys
+}
+
+// settings returns every setting as now in effect, secrets redacted.
+func (c *configReloader) settings() map[string]string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	out := make(map[string]string)
+	c.fs.VisitAll(func(f *flag.Flag) {
+		v := f.Value.String()
+		if secretFlags[f.Name] && v != "" {
+			v = "[redacted]"
+		}
+		out[f.Name] = v
+	})
+	return out
+}
+
+func (c *configReloader) handleSIGHUP(hup <-chan os.Signal) {
+	for range hup {
+		if c.fs.Lookup("config").Value.String() != "" {
+			if err := c.reloadConfig(); err != nil {
+				slog.Error("config: reload failed, keeping previous settings", "err", err)
+			}
+		}
+		if c.certs != nil {
+			if err := c.certs.reload(); err != nil {
+				slog.Error("tls: reload failed, keeping previous certificate", "err", err)
+			} else {
+				slog.Info("tls: reloaded certificate", "path", c.certs.certPath)
+			}
+		}
+	}
+}
+
+// reloadConfig applies the reloadable settings. Everything is validated
+// first, so a bad file leaves the running config untouched. Rate limits
+// are rebuilt, which resets in-memory counters.
+func (c *configReloader) reloadConfig() error {
+	next, fs, err := loadConfig(c.args)
+	if err != nil {
+		return err
+	}
+
+	var changed []string
+	c.fs.VisitAll(func(f *flag.Flag) {
+		if fs.Lookup(f.Name).Value.String() == f.Value.String() {
+			return
+		}
+		if reloadableFlags[f.Name] {
+			changed = append(changed, f.Name)
+		} else {
+			slog.Warn("config: setting changed; restart to apply", "setting", f.Name)
+		}
+	})
+	if len(changed) == 0 {
+		slog.Info("config: reloaded, nothing to apply")
+		return nil
+	}
+
+	// Build everything before applying anything.
+	var rl *rateLimits
+	rateChanged := slices.ContainsFunc(changed, func(name string) bool { return name != "log-level" })
+	if rateChanged {
+		rlConfig, err := next.rateLimitConfig(c.server.rateCounters)
+		if err != nil {
+			return err
+		}
+		if rl, err = newRateLimits(rlConfig); err != nil {
+			return err
+		}
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(next.LogLevel)); err != nil {
+		return fmt.Errorf("invalid log-level %q", next.LogLevel)
+	}
+
+	if rateChanged {
+		c.server.rateLimits.Store(rl)
+	}
+	logLevel.Set(level)
+	c.mu.Lock()
+	for _, name := range changed {
+		_ = c.fs.Set(name, fs.Lookup(name).Value.String())
+	}
+	c.mu.Unlock()
+	slog.Info("config: applied", "settings", strings.Join(changed, ","))
+	return nil
+}
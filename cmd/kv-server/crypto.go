@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ----------- Encryption at Rest -----------
+
+// keyring holds the AES-256-GCM keys for persisted files. The first key
+// is active and encrypts everything written; the others can still
+// decrypt, so a key is rotated by putting the new one first and keeping
+// the old one until the next AOF rewrite and snapshot have re-encrypted
+// the data.
+//
+// Each sealed blob is keyID(4) | nonce(12) | ciphertext+tag, where the key
+// ID is the start of the key's SHA-256, so keys need no explicit names.
+type keyring struct {
+	active *sealKey
+	byID   map[[4]byte]*sealKey
+}
+
+type sealKey struct {
+	id   [4]byte
+	aead cipher.AEAD
+}
+
+const encryptionKeysEnv = "KV_ENCRYPTION_KEYS"
+
+var errUnknownKey = errors.New("encrypted with a key that is not configured")
+
+// loadKeyring reads keys from path (one per line) or, if path is empty,
+// from the KV_ENCRYPTION_KEYS environment variable (comma-separated).
+// Keys are 32 bytes, hex or base64 encoded. It returns nil when neither
+// is set.
+func loadKeyring(path string) (*keyring, error) {
+	var raw []string
+	switch {
+	case path != "":
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		raw = strings.Split(string(data), "\n")
+	case os.Getenv(encryptionKeysEnv) != "":
+		raw = strings.Split(os.Getenv(encryptionKeysEnv), ",")
+	default:
+		return nil, nil
+	}
+
+	kr := &keyring{byID: make(map[[4]byte]*sealKey)}
+	for _, line := range raw {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, err := decodeKey(line)
+		if err != nil {
+			return nil, err
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		sum := sha256.Sum256(key)
+		sk := &sealKey{aead: aead}
+		copy(sk.id[:], sum[:4])
+		kr.byID[sk.id] = sk
+		if kr.active == nil {
+			kr.active = sk
+		}
+	}
+	if kr.active == nil {
+		return nil, errors.New("no encryption keys found")
+	}
+	return kr, nil
+}
+
+func decodeKey(s string) ([]byte, error) {
+	if key, err := hex.DecodeString(s); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, fmt.Errorf("encryption keys must be 32 bytes, hex or base64 encoded")
+}
+
+// seal encrypts plain with the active key. aad binds the blob to its use
+// (e.g. "aof" or "snapshot") so blobs cannot be swapped between files.
+func (kr *keyring) seal(plain, aad []byte) []byte {
+	k := kr.active
+	out := make([]byte, 4+k.aead.NonceSize(), 4+k.aead.NonceSize()+len(plain)+k.aead.Overhead())
+	copy(out, k.id[:])
+	if _, err := rand.Read(out[4:]); err != nil {
+		panic("crypto/rand failed: " + err.Error())
+	}
+	return k.aead.Seal(out, out[4:], plain, aad)
+}
+
+func (kr *keyring) open(sealed, aad []byte) ([]byte, error) {
+	if len(sealed) < 4 {
+		return nil, errors.New("encrypted blob too short")
+	}
+	var id [4]byte
+	copy(id[:], sealed)
+	k, ok := kr.byID[id]
+	if !ok {
+		return nil, fmt.Errorf("%w (key id %x)", errUnknownKey, id)
+	}
+	ns := k.aead.NonceSize()
+	if len(sealed) < 4+ns {
+		return nil, errors.New("encrypted blob too short")
+	}
+	return k.aead.Open(nil, sealed[4:4+ns], sealed[4+ns:], aad)
+}
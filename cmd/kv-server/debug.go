@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+// ----------- Debug / Runtime -----------
+
+// registerPprof serves the runtime profiles under /debug/pprof/. The
+// routes sit behind the admin token like the rest of the admin API.
+func registerPprof(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
+
+// Admin: GET /admin/runtime reports process health: goroutines, heap,
+// recent GC pauses and uptime.
+func (s *KVServer) handleRuntime(w http.ResponseWriter, r *http.Request) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	// PauseNs is a circular buffer; the most recent pause is at
+	// (NumGC+255)%256.
+	pauses := make([]float64, 0, 10)
+	for i := uint32(0); i < min(ms.NumGC, 10); i++ {
+		ns := ms.PauseNs[(ms.NumGC-1-i)%uint32(len(ms.PauseNs))]
+		pauses = append(pauses, float64(ns)/1e6)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"uptime_seconds": time.Since(s.started).Seconds(),
+		"started_at":     s.started.UTC(),
+		"go_version":     runtime.Version(),
+		"num_cpu":        runtime.NumCPU(),
+		"gomaxprocs":     runtime.GOMAXPROCS(0),
+		"goroutines":     runtime.NumGoroutine(),
+		"heap": map[string]uint64{
+			"alloc_bytes":    ms.HeapAlloc,
+			"sys_bytes":      ms.HeapSys,
+			"idle_bytes":     ms.HeapIdle,
+			"released_bytes": ms.HeapReleased,
+			"objects":        ms.HeapObjects,
+		},
+		"gc": map[string]any{
+			"num_gc":           ms.NumGC,
+			"pause_total_ms":   float64(ms.PauseTotalNs) / 1e6,
+			"recent_pauses_ms": pauses, // newest first
+			"next_gc_bytes":    ms.NextGC,
+			"gc_cpu_fraction":  ms.GCCPUFraction,
+		},
+		"keys": s.store.Len(),
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ----------- Content Digests -----------
+
+// Every value written through the key API has its SHA-256 computed and
+// kept with it (StoredValue.SHA256), and persisted. GET, HEAD and PUT
+// return it as
+//
+//	Digest: sha-256=<base64>
+//
+// always the digest of the value itself, whether it is served raw or in
+// the JSON envelope, so clients can tell whether a value changed without
+// fetching it. Values loaded from before digests were kept get theirs
+// computed when read.
+//
+// A client can send a digest of its request body in Content-Digest or
+// Repr-Digest (sha-256=:<base64>:, RFC 9530) or Digest (RFC 3230); a
+// body that does not match is rejected with 400 digest_mismatch and not
+// stored. Only sha-256 is checked; other algorithms are ignored.
+
+var (
+	errDigestMismatch = errors.New("the body does not match its sha-256 digest")
+	errInvalidDigest  = errors.New("invalid sha-256 digest header")
+)
+
+// digest returns the SHA-256 of v, computing it (decrypting with keys) if
+// it was not kept. It returns nil for a spilled value without one, and a
+// collection header.
+func (v StoredValue) digest(keys *keyring) []byte {
+	if v.SHA256 != nil {
+		return v.SHA256
+	}
+	if v.Spill != "" || v.Elements {
+		return nil
+	}
+	sum := sha256.Sum256(v.plain(keys).Data)
+	return sum[:]
+}
+
+// setDigestHeader sets the Digest header from v's SHA-256.
+func setDigestHeader(h http.Header, v StoredValue, keys *keyring) {
+	if sum := v.digest(keys); sum != nil {
+		h.Set("Digest", "sha-256="+base64.StdEncoding.EncodeToString(sum))
+	}
+}
+
+// requestDigest returns the sha-256 digest the client sent for its body,
+// or nil if it sent none.
+func requestDigest(h http.Header) ([]byte, error) {
+	for _, name := range []string{"Content-Digest", "Repr-Digest", "Digest"} {
+		for _, part := range strings.Split(h.Get(name), ",") {
+			alg, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if !strings.EqualFold(alg, "sha-256") {
+				continue
+			}
+			// Structured fields wrap the bytes in colons; RFC 3230 does not.
+			value = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(value), ":"), ":")
+			sum, err := base64.StdEncoding.DecodeString(value)
+			if err != nil || len(sum) != sha256.Size {
+				return nil, errInvalidDigest
+			}
+			return sum, nil
+		}
+	}
+	return nil, nil
+}
+
+// wantDigest returns the digest the client sent for the body of r, nil
+// if none, writing the error response if it is invalid.
+func wantDigest(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	want, err := requestDigest(r.Header)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
+		return nil, false
+	}
+	return want, true
+}
+
+// checkDigest reports whether a body whose SHA-256 is got matches want,
+// writing the error response if not. A nil want matches anything.
+func checkDigest(w http.ResponseWriter, r *http.Request, want, got []byte) bool {
+	if want == nil || bytes.Equal(want, got) {
+		return true
+	}
+	writeError(w, r, http.StatusBadRequest, codeDigestMismatch, errDigestMismatch.Error())
+	return false
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/shubhamc1947/safemap/pkg/concurrentmap"
+)
+
+// ----------- Memory Limit and Eviction -----------
+
+// --max-keys and --max-memory cap the whole store. A write that would
+// exceed either first evicts keys by --eviction-policy:
+//
+//	noeviction    the write fails with 507 out_of_memory
+//	allkeys-lru   evict the least recently used key
+//	volatile-lru  evict the least recently used key with a TTL
+//	volatile-ttl  evict the key with a TTL closest to expiring
+//
+// Like Redis, it approximates: each eviction samples a few keys and
+// takes the best of them. Server-internal keys (tokens, ACL
+// rules, locks, ...) count towards the limits but are never evicted.
+// Memory is estimated as the length of keys plus values, so the process
+// uses more than --max-memory; concurrent writes may briefly overshoot.
+
+type evictionPolicy string
+
+const (
+	evictNone        evictionPolicy = "noeviction"
+	evictAllKeysLRU  evictionPolicy = "allkeys-lru"
+	evictVolatileLRU evictionPolicy = "volatile-lru"
+	evictVolatileTTL evictionPolicy = "volatile-ttl"
+)
+
+func parseEvictionPolicy(s string) (evictionPolicy, error) {
+	switch p := evictionPolicy(s); p {
+	case evictNone, evictAllKeysLRU, evictVolatileLRU, evictVolatileTTL:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid --eviction-policy %q, want noeviction, allkeys-lru, volatile-lru or volatile-ttl", s)
+}
+
+const (
+	// evictionSamples are the candidates compared to choose each eviction.
+	evictionSamples = 5
+	// evictionAttempts bounds the samples taken for one eviction while
+	// the keys chosen keep being written before they can be evicted.
+	evictionAttempts = 3
+)
+
+// memoryLimit tracks the whole store's size from its events and enforces
+// --max-keys and --max-memory. The zero quota is unlimited.
+type memoryLimit struct {
+	quota
+	policy  evictionPolicy
+	store   *concurrentmap.ConcurrentMap[string, StoredValue]
+	used    usage
+	evicted atomic.Int64
+}
+
+func newMemoryLimit(store *concurrentmap.ConcurrentMap[string, StoredValue], limit quota, policy evictionPolicy) *memoryLimit {
+	m := &memoryLimit{quota: limit, policy: policy, store: store}
+	store.Subscribe(m.track)
+	return m
+}
+
+// footprint estimates the memory held by key and its value v.
+func footprint(key string, v StoredValue) int64 {
+	return int64(len(key)) + v.size()
+}
+
+// track runs under the store's bucket lock, so it only touches counters.
+func (m *memoryLimit) track(ev concurrentmap.Event[string, StoredValue]) {
+	if ev.Type != concurrentmap.EventInsert {
+		m.used.add(-1, -footprint(ev.Key, ev.OldValue))
+	}
+	if ev.Type == concurrentmap.EventInsert || ev.Type == concurrentmap.EventUpdate {
+		m.used.add(1, footprint(ev.Key, ev.NewValue))
+	}
+}
+
+// makeRoom readies the store for a value of size bytes at key, evicting
+// other keys as the policy allows, and returns the error if there is no
+// room for it.
+func (m *memoryLimit) makeRoom(key string, size int64) *statusError {
+	if m.MaxKeys == 0 && m.MaxBytes == 0 {
+		return nil
+	}
+	keys, bytes := int64(1), int64(len(key))+size
+	if old, exists := m.store.Get(key); exists {
+		keys, bytes = 0, size-old.size()
+	}
+	for !m.allows(&m.used, keys, bytes) {
+		if m.policy == evictNone || !m.evictOne(key) {
+			return &statusError{http.StatusInsufficientStorage, codeOutOfMemory, "the store is full and nothing may be evicted (--eviction-policy " + string(m.policy) + ")"}
+		}
+	}
+	return nil
+}
+
+// evictOne deletes the best candidate for eviction among a sample of the
+// keys the policy allows evicting, never keep, and reports whether it
+// found one. The sample is taken shard by shard from a random one, so
+// candidates are found however few there are.
+func (m *memoryLimit) evictOne(keep string) bool {
+	candidate := func(k string, v StoredValue) bool { return k != keep && m.evictable(k, v) }
+	buckets := m.store.Buckets()
+	for range evictionAttempts {
+		var (
+			victim StoredValue
+			key    string
+			seen   int
+		)
+		start := rand.IntN(buckets)
+		for i := 0; i < buckets && seen < evictionSamples; i++ {
+			for _, e := range m.store.SampleBucket((start+i)%buckets, evictionSamples-seen, candidate) {
+				seen++
+				if key == "" || m.before(e.Value, victim) {
+					key, victim = e.Key, e.Value
+				}
+			}
+		}
+		if key == "" {
+			return false
+		}
+
+		// Skip the key if it was written since it was sampled: it is no
+		// longer the one the sample chose.
+		evicted := false
+		_ = m.store.ComputeErr(key, func(old StoredValue, exists bool) (StoredValue, bool, error) {
+			if !exists || old.Version != victim.Version || !old.UpdatedAt.Equal(victim.UpdatedAt) {
+				return old, exists, nil
+			}
+			evicted = true
+			return old, false, nil
+		})
+		if evicted {
+			m.evicted.Add(1)
+			return true
+		}
+	}
+	return false
+}
+
+// evictable reports whether the policy allows evicting key.
+func (m *memoryLimit) evictable(key string, v StoredValue) bool {
+	if isReservedKey(key) {
+		return false
+	}
+	return m.policy == evictAllKeysLRU || v.HasTTL
+}
+
+// before reports whether a should be evicted before b.
+func (m *memoryLimit) before(a, b StoredValue) bool {
+	if m.policy == evictVolatileTTL {
+		return a.ExpiresAt.Before(b.ExpiresAt)
+	}
+	return lastUsed(a).Before(lastUsed(b))
+}
+
+// lastUsed is when the key holding v was last read or written.
+func lastUsed(v StoredValue) time.Time {
+	used := v.UpdatedAt
+	if v.LastRead != nil {
+		if read := v.LastRead.Load(); read != 0 && (used.IsZero() || read > used.UnixNano()) {
+			used = time.Unix(0, read)
+		}
+	}
+	return used
+}
+
+func (m *memoryLimit) report() map[string]any {
+	return map[string]any{
+		"keys":      m.used.keys.Load(),
+		"bytes":     m.used.bytes.Load(),
+		"max_keys":  m.MaxKeys,
+		"max_bytes": m.MaxBytes,
+		"policy":    m.policy,
+		"evicted":   m.evicted.Load(),
+	}
+}
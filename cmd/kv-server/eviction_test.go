@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestEvictionSparesFailedConditionalWrites(t *testing.T) {
+	s := newTestServer(t)
+	s.memory = newMemoryLimit(s.store, quota{MaxKeys: 2}, evictAllKeysLRU)
+	put := func(key string, cond writeCond) *statusError {
+		v := StoredValue{Data: []byte("v")}
+		return s.putValue(context.Background(), principal{}, key, nil, &v, cond)
+	}
+	for _, key := range []string{"a", "b"} {
+		if serr := put(key, writeCond{}); serr != nil {
+			t.Fatalf("put %s: %v", key, serr.message)
+		}
+	}
+
+	// The store is full, but a write whose precondition fails must not
+	// evict anything to make room for a value it never stores.
+	if serr := put("c", writeCond{ifMatch: `"nope"`}); serr == nil || serr.status != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for a stale If-Match, got %+v", serr)
+	}
+	if serr := put("a", writeCond{createOnly: true}); serr == nil || serr.status != http.StatusConflict {
+		t.Fatalf("expected 409 for create-only on a live key, got %+v", serr)
+	}
+	for _, key := range []string{"a", "b"} {
+		if _, ok := s.store.Get(key); !ok {
+			t.Fatalf("expected %s to survive failed conditional writes", key)
+		}
+	}
+
+	if serr := put("c", writeCond{}); serr != nil {
+		t.Fatalf("put c: %v", serr.message)
+	}
+	if n := s.store.Len(); n != 2 {
+		t.Fatalf("expected a write to evict down to 2 keys, got %d", n)
+	}
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/shubhamc1947/safemap/pkg/kvpb"
+)
+
+// ----------- gRPC -----------
+
+// With --grpc-addr the server also serves the safemap.kv.v1.KV gRPC
+// service (proto/safemap/kv/v1/kv.proto) on its own listener: HTTP/2
+// without TLS, or over TLS when --tls-cert is set. Calls authenticate
+// like HTTP requests, from the authorization or x-api-key metadata, and
+// are checked and counted as their HTTP counterparts; a failed call
+// carries the HTTP API's error code in the safemap-error-code trailer.
+//
+// Messages are encoded by pkg/kvpb, which is written against the .proto
+// so the server needs no gRPC dependency.
+
+// grpcMethods lists the service's methods, and whether rate limits count
+// a call as a read.
+var grpcMethods = map[string]bool{
+	kvpb.MethodGet:      true,
+	kvpb.MethodPut:      false,
+	kvpb.MethodDelete:   false,
+	kvpb.MethodBatchOps: false,
+	kvpb.MethodTxn:      false,
+	kvpb.MethodWatch:    true,
+}
+
+// listenGRPC opens the gRPC listener and its server, which shares the
+// HTTP server's timeouts and TLS configuration.
+func (s *KVServer) listenGRPC(addr string, base *http.Server) (*http.Server, boundListener, error) {
+	spec := listenSpec{network: "tcp", addr: addr, tls: base.TLSConfig != nil}
+	ln, err := net.Listen(spec.network, spec.addr)
+	if err != nil {
+		return nil, boundListener{}, err
+	}
+	var protocols http.Protocols
+	if spec.tls {
+		protocols.SetHTTP2(true)
+	} else {
+		protocols.SetUnencryptedHTTP2(true)
+	}
+	srv := &http.Server{
+		Handler:           requestIDMiddleware(s.loggingMiddleware(http.HandlerFunc(s.serveGRPC))),
+		ReadTimeout:       base.ReadTimeout,
+		ReadHeaderTimeout: base.ReadHeaderTimeout,
+		WriteTimeout:      base.WriteTimeout,
+		IdleTimeout:       base.IdleTimeout,
+		MaxHeaderBytes:    base.MaxHeaderBytes,
+		TLSConfig:         base.TLSConfig,
+		Protocols:         &protocols,
+	}
+	return srv, boundListener{Listener: ln, spec: spec}, nil
+}
+
+// serveGRPC answers one call.
+func (s *KVServer) serveGRPC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), kvpb.ContentType) {
+		http.Error(w, "this listener serves gRPC only", http.StatusUnsupportedMediaType)
+		return
+	}
+	s.metrics.TotalRequests.Add(1)
+	w.Header().Set("Content-Type", kvpb.ContentType)
+
+	read, ok := grpcMethods[r.URL.Path]
+	if !ok {
+		writeGRPCStatus(w, &kvpb.Status{Code: kvpb.Unimplemented, Message: "unknown method " + r.URL.Path})
+		return
+	}
+	p, st := s.admitGRPC(r, read)
+	if st != nil {
+		writeGRPCStatus(w, st)
+		return
+	}
+	body, err := kvpb.ReadFrame(r.Body, maxBatchBody)
+	switch {
+	case errors.Is(err, kvpb.ErrCompressed):
+		st = &kvpb.Status{Code: kvpb.Unimplemented, Message: err.Error()}
+	case err != nil:
+		st = &kvpb.Status{Code: kvpb.InvalidArgument, Message: "reading request: " + err.Error(), ErrorCode: codeInvalidBody}
+	case r.URL.Path == kvpb.MethodWatch:
+		st = s.grpcWatch(w, r, p, body)
+	default:
+		var resp kvpb.Message
+		var serr *statusError
+		switch r.URL.Path {
+		case kvpb.MethodGet:
+			resp, serr = s.grpcGet(r.Context(), p, body)
+		case kvpb.MethodPut:
+			resp, serr = s.grpcPut(r.Context(), p, body)
+		case kvpb.MethodDelete:
+			resp, serr = s.grpcDelete(r.Context(), p, body)
+		case kvpb.MethodBatchOps:
+			resp, serr = s.grpcBatch(r.Context(), p, body)
+		case kvpb.MethodTxn:
+			resp, serr = s.grpcTxn(r.Context(), p, body)
+		}
+		if serr != nil {
+			st = grpcStatus(serr)
+		} else {
+			_, _ = w.Write(kvpb.AppendFrame(nil, resp.Marshal()))
+		}
+	}
+	writeGRPCStatus(w, st)
+}
+
+// admitGRPC applies what the HTTP middleware does before a handler runs:
+// the loading check, authentication and rate limits.
+func (s *KVServer) admitGRPC(r *http.Request, read bool) (principal, *kvpb.Status) {
+	if !s.loaded.Load() {
+		return principal{}, &kvpb.Status{Code: kvpb.Unavailable, Message: "loading persisted data", ErrorCode: codeLoading}
+	}
+	p, ok := s.authenticate(r)
+	if !ok {
+		s.metrics.Unauthorized.Add(1)
+		return principal{}, &kvpb.Status{Code: kvpb.Unauthenticated, Message: "unauthorized", ErrorCode: codeUnauthorized}
+	}
+	if rl := s.rateLimits.Load(); rl != nil {
+		counted := *r
+		if read {
+			counted.Method = http.MethodGet
+		}
+		if res, applied := rl.check(&counted, rateLimitKey(r, p), s.clientIP(r)); applied && !res.Allowed {
+			s.metrics.RateLimited.Add(1)
+			return principal{}, &kvpb.Status{Code: kvpb.ResourceExhausted, Message: "rate limit exceeded", ErrorCode: codeRateLimited}
+		}
+	}
+	return p, nil
+}
+
+// writeGRPCStatus ends a call with st, or OK when it is nil.
+func writeGRPCStatus(w http.ResponseWriter, st *kvpb.Status) {
+	h := w.Header()
+	if st == nil {
+		h.Set(http.TrailerPrefix+"Grpc-Status", "0")
+		return
+	}
+	h.Set(http.TrailerPrefix+"Grpc-Status", strconv.FormatUint(uint64(st.Code), 10))
+	h.Set(http.TrailerPrefix+"Grpc-Message", kvpb.EncodeMessage(st.Message))
+	if st.ErrorCode != "" {
+		h.Set(http.TrailerPrefix+kvpb.ErrorCodeTrailer, st.ErrorCode)
+	}
+}
+
+// grpcStatus maps the HTTP status of serr to a gRPC one.
+func grpcStatus(serr *statusError) *kvpb.Status {
+	code := kvpb.Unknown
+	switch serr.status {
+	case http.StatusBadRequest:
+		code = kvpb.InvalidArgument
+	case http.StatusUnauthorized:
+		code = kvpb.Unauthenticated
+	case http.StatusForbidden:
+		code = kvpb.PermissionDenied
+	case http.StatusNotFound:
+		code = kvpb.NotFound
+	case http.StatusConflict:
+		code = kvpb.FailedPrecondition
+		if serr.code == codeKeyExists {
+			code = kvpb.AlreadyExists
+		}
+	case http.StatusPreconditionFailed:
+		code = kvpb.FailedPrecondition
+	case http.StatusGone:
+		code = kvpb.OutOfRange
+	case http.StatusRequestEntityTooLarge, http.StatusTooManyRequests, http.StatusInsufficientStorage:
+		code = kvpb.ResourceExhausted
+	case http.StatusServiceUnavailable:
+		code = kvpb.Unavailable
+	case http.StatusInternalServerError:
+		code = kvpb.Internal
+	}
+	return &kvpb.Status{Code: code, Message: serr.message, ErrorCode: serr.code}
+}
+
+// decodeGRPC unmarshals a request message.
+func decodeGRPC(body []byte, m kvpb.Message) *statusError {
+	if err := m.Unmarshal(body); err != nil {
+		return &statusError{http.StatusBadRequest, codeInvalidBody, "invalid request: " + err.Error()}
+	}
+	return nil
+}
+
+// versionETag is the ETag of version v, for conditional writes.
+func versionETag(v uint64) string {
+	return `"` + strconv.FormatUint(v, 10) + `"`
+}
+
+func pbValue(v StoredValue, keys *keyring) *kvpb.Value {
+	v = v.plain(keys)
+	out := &kvpb.Value{Data: v.Data, ContentType: v.ContentType, Version: v.Version}
+	if v.HasTTL {
+		out.ExpiresAt = v.ExpiresAt.UnixMilli()
+	}
+	return out
+}
+
+// pbResult converts the result of a batch or transaction operation.
+func pbResult(res batchResult) kvpb.OpResult {
+	out := kvpb.OpResult{Status: int32(res.Status)}
+	switch {
+	case res.Error != nil:
+		out.Error = &kvpb.Error{Code: res.Error.Code, Message: res.Error.Message}
+	case res.Status != http.StatusNoContent:
+		v := &kvpb.Value{Data: res.ValueBase64, ContentType: res.ContentType}
+		if res.Value != nil {
+			v.Data = []byte(*res.Value)
+		}
+		v.Version, _ = strconv.ParseUint(strings.Trim(res.ETag, `"`), 10, 64)
+		if res.ExpiresAt != nil {
+			v.ExpiresAt = res.ExpiresAt.UnixMilli()
+		}
+		out.Value = v
+	}
+	return out
+}
+
+// pbOp converts a batch or transaction operation.
+func pbOp(op kvpb.Op) batchOp {
+	return batchOp{Op: op.Type.String(), Key: op.Key, Value: string(op.Value), TTLSeconds: op.TTLSeconds}
+}
+
+// commitGRPC is persist for gRPC calls.
+func (s *KVServer) commitGRPC(ctx context.Context) *statusError {
+	if s.commitWrites(ctx) != nil {
+		return &statusError{http.StatusInternalServerError, codePersistence, "persistence failed"}
+	}
+	return nil
+}
+
+func (s *KVServer) grpcGet(ctx context.Context, p principal, body []byte) (kvpb.Message, *statusError) {
+	var req kvpb.GetRequest
+	if serr := decodeGRPC(body, &req); serr != nil {
+		return nil, serr
+	}
+	s.metrics.TotalGets.Add(1)
+	key, ns, serr := s.resolveBatchOp(p, req.Namespace, batchOp{Op: "get", Key: req.Key})
+	if serr != nil {
+		return nil, serr
+	}
+	v, ok := s.fetch(ctx, key, ns)
+	if !ok {
+		return nil, &statusError{http.StatusNotFound, codeKeyNotFound, "key not found"}
+	}
+	return &kvpb.GetResponse{Value: pbValue(v, s.valueKeys)}, nil
+}
+
+func (s *KVServer) grpcPut(ctx context.Context, p principal, body []byte) (kvpb.Message, *statusError) {
+	var req kvpb.PutRequest
+	if serr := decodeGRPC(body, &req); serr != nil {
+		return nil, serr
+	}
+	s.metrics.TotalPuts.Add(1)
+	key, ns, serr := s.resolveBatchOp(p, req.Namespace, batchOp{Op: "set", Key: req.Key})
+	if serr != nil {
+		return nil, serr
+	}
+	ttl, ttlOK := ttlDuration(req.TTLSeconds)
+	switch {
+	case s.maxValueSize > 0 && int64(len(req.Value)) > s.maxValueSize:
+		return nil, &statusError{http.StatusRequestEntityTooLarge, codeValueTooLarge,
+			fmt.Sprintf("value exceeds the %d byte limit", s.maxValueSize)}
+	case !ttlOK:
+		return nil, &statusError{http.StatusBadRequest, codeBadRequest, ttlRangeMessage("ttl_seconds")}
+	case req.IfVersion != nil && req.CreateOnly:
+		return nil, &statusError{http.StatusBadRequest, codeBadRequest, "pass if_version or create_only, not both"}
+	}
+
+	v := StoredValue{Data: req.Value, ContentType: req.ContentType}
+	if v.ContentType == "" && !utf8.Valid(v.Data) {
+		v.ContentType = "application/octet-stream"
+	}
+	if ttl > 0 {
+		v.HasTTL = true
+		v.ExpiresAt = time.Now().Add(ttl)
+	}
+	cond := writeCond{createOnly: req.CreateOnly}
+	if req.IfVersion != nil {
+		cond.ifMatch = versionETag(*req.IfVersion)
+	}
+	if serr := s.putValue(ctx, p, key, ns, &v, cond); serr != nil {
+		return nil, serr
+	}
+	if serr := s.commitGRPC(ctx); serr != nil {
+		return nil, serr
+	}
+	resp := &kvpb.PutResponse{Version: v.Version}
+	if v.HasTTL {
+		resp.ExpiresAt = v.ExpiresAt.UnixMilli()
+	}
+	return resp, nil
+}
+
+func (s *KVServer) grpcDelete(ctx context.Context, p principal, body []byte) (kvpb.Message, *statusError) {
+	var req kvpb.DeleteRequest
+	if serr := decodeGRPC(body, &req); serr != nil {
+		return nil, serr
+	}
+	s.metrics.TotalDeletes.Add(1)
+	key, ns, serr := s.resolveBatchOp(p, req.Namespace, batchOp{Op: "delete", Key: req.Key})
+	if serr != nil {
+		return nil, serr
+	}
+	var ifMatch string
+	if req.IfVersion != nil {
+		ifMatch = versionETag(*req.IfVersion)
+	}
+	if serr := s.deleteValue(ctx, key, ns, ifMatch); serr != nil {
+		return nil, serr
+	}
+	if serr := s.commitGRPC(ctx); serr != nil {
+		return nil, serr
+	}
+	return &kvpb.DeleteResponse{}, nil
+}
+
+func (s *KVServer) grpcBatch(ctx context.Context, p principal, body []byte) (kvpb.Message, *statusError) {
+	var req kvpb.BatchRequest
+	if serr := decodeGRPC(body, &req); serr != nil {
+		return nil, serr
+	}
+	if s.maxBatchOps > 0 && len(req.Ops) > s.maxBatchOps {
+		return nil, &statusError{http.StatusRequestEntityTooLarge, codeBatchTooLarge,
+			fmt.Sprintf("batch has %d operations, the limit is %d", len(req.Ops), s.maxBatchOps)}
+	}
+
+	ctx, sp := s.tracer.start(ctx, "store.batch", spanKindInternal)
+	sp.setAttr("ops", len(req.Ops))
+	resp := &kvpb.BatchResponse{Results: make([]kvpb.OpResult, len(req.Ops))}
+	for i, op := range req.Ops {
+		resp.Results[i] = pbResult(s.batchOne(ctx, p, req.Namespace, pbOp(op)))
+	}
+	sp.finish()
+	if serr := s.commitGRPC(ctx); serr != nil {
+		return nil, serr
+	}
+	return resp, nil
+}
+
+func (s *KVServer) grpcTxn(ctx context.Context, p principal, body []byte) (kvpb.Message, *statusError) {
+	var req kvpb.TxnRequest
+	if serr := decodeGRPC(body, &req); serr != nil {
+		return nil, serr
+	}
+	txn := txnRequest{Compare: make([]txnCompare, len(req.Compare))}
+	for i, c := range req.Compare {
+		txn.Compare[i] = txnCompare{Key: c.Key, Value: c.Value, Version: c.Version}
+	}
+	for _, op := range req.Success {
+		txn.Success = append(txn.Success, pbOp(op))
+	}
+	for _, op := range req.Failure {
+		txn.Failure = append(txn.Failure, pbOp(op))
+	}
+
+	res, serr := s.runTxn(ctx, p, req.Namespace, txn)
+	if serr != nil {
+		return nil, serr
+	}
+	if serr := s.commitGRPC(ctx); serr != nil {
+		return nil, serr
+	}
+	resp := &kvpb.TxnResponse{Succeeded: res.Succeeded, Results: make([]kvpb.OpResult, len(res.Results))}
+	for i, r := range res.Results {
+		resp.Results[i] = pbResult(r)
+	}
+	return resp, nil
+}
+
+// grpcWatch streams events as GET /watch does, and returns the status the
+// stream ends with. A watcher too slow to keep up gets UNAVAILABLE, and
+// can resume with after_id.
+func (s *KVServer) grpcWatch(w http.ResponseWriter, r *http.Request, p principal, body []byte) *kvpb.Status {
+	var req kvpb.WatchRequest
+	if serr := decodeGRPC(body, &req); serr != nil {
+		return grpcStatus(serr)
+	}
+	if !p.has(scopeRead) {
+		return &kvpb.Status{Code: kvpb.PermissionDenied, Message: "token lacks the read scope", ErrorCode: codeForbidden}
+	}
+	if req.Key != "" && req.Prefix != "" {
+		return &kvpb.Status{Code: kvpb.InvalidArgument, Message: "pass key or prefix, not both", ErrorCode: codeBadRequest}
+	}
+	t, serr := s.newWatchTarget(p, req.Namespace, req.Key, req.Key != "", req.Prefix)
+	if serr != nil {
+		return grpcStatus(serr)
+	}
+	var after uint64
+	if req.AfterID != nil {
+		after = *req.AfterID
+	}
+	sub, backlog, err := s.events.subscribe(t.matches, after, req.AfterID != nil)
+	if err != nil {
+		return &kvpb.Status{Code: kvpb.OutOfRange, Message: err.Error(), ErrorCode: codeWatchCompacted}
+	}
+	defer s.events.unsubscribe(sub)
+
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{}) // streams outlive --write-timeout
+	w.WriteHeader(http.StatusOK)
+
+	send := func(we watchEvent) error {
+		if !s.watchAllowed(p, t, we.Key) {
+			return nil
+		}
+		ev := kvpb.WatchEvent{ID: we.ID, Key: t.clientKey(we.Key)}
+		switch we.Type {
+		case "set":
+			ev.Type, ev.Value = kvpb.EventSet, pbValue(we.Value, s.valueKeys)
+		case "delete":
+			ev.Type = kvpb.EventDelete
+		case "expire":
+			ev.Type = kvpb.EventExpire
+		}
+		_, err := w.Write(kvpb.AppendFrame(nil, ev.Marshal()))
+		return err
+	}
+	for _, we := range backlog {
+		if send(we) != nil {
+			return nil
+		}
+	}
+	if rc.Flush() != nil {
+		return nil
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return &kvpb.Status{Code: kvpb.Canceled, Message: "watch canceled"}
+		case <-s.events.done:
+			return &kvpb.Status{Code: kvpb.Unavailable, Message: "server shutting down"}
+		case we, ok := <-sub.ch:
+			if !ok {
+				return &kvpb.Status{Code: kvpb.Unavailable, Message: "watcher fell behind; resume with after_id"}
+			}
+			if send(we) != nil {
+				return nil
+			}
+		}
+		if rc.Flush() != nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/shubhamc1947/safemap/pkg/probabilistic"
+)
+
+// ----------- HyperLogLog -----------
+
+// A key can hold a HyperLogLog sketch (pkg/probabilistic), which counts
+// distinct members approximately, within about 0.8%, in at most 16 KiB
+// however many are added:
+//
+//	POST /hll/{key}/add   {"members": ["u1", "u2"]}   -> {"changed": true}
+//	GET  /hll/{key}/count                             -> {"count": 2}
+//	POST /hll/{key}/merge {"sources": ["a", "b"]}     -> {"count": 5}
+//
+// /v1/{ns}/hll/{key}/... works inside a namespace. The sketch is stored
+// as an ordinary value of type application/x-hyperloglog, so it expires,
+// persists and replicates like any other; GET /kv/{key} returns its
+// encoding, and a PUT of those bytes restores it.
+
+const hllContentType = "application/x-hyperloglog"
+
+// hllMaxSize bounds a sketch's encoding, for quota checks.
+const hllMaxSize = 1<<probabilistic.DefaultHLLPrecision + 16
+
+// maxHLLSources caps the sources of one merge.
+const maxHLLSources = 64
+
+var (
+	errNotHLL       = errors.New("the value is not a HyperLogLog")
+	errHLLPrecision = errors.New("the sketches have different precisions")
+)
+
+// typedPath splits a route of a data type's endpoints, /{kind}/{key}/{op}
+// or /v1/{ns}/{kind}/{key}/{op}, into its namespace ("" for the flat
+// keyspace) and key, the op included.
+func typedPath(path, kind string) (ns, key string, ok bool) {
+	if key, ok = strings.CutPrefix(path, "/"+kind+"/"); ok {
+		return "", key, true
+	}
+	rest, ok := strings.CutPrefix(path, "/v1/")
+	if !ok {
+		return "", "", false
+	}
+	ns, key, ok = strings.Cut(rest, "/"+kind+"/")
+	if !ok || ns == "" || strings.Contains(ns, "/") {
+		return "", "", false
+	}
+	return ns, key, true
+}
+
+// hllPath splits a sketch route, /hll/{key}/{op} or /v1/{ns}/hll/{key}/{op}.
+func hllPath(path string) (ns, key string, ok bool) {
+	return typedPath(path, "hll")
+}
+
+func isHLLPath(path string) bool {
+	_, _, ok := hllPath(path)
+	return ok
+}
+
+// decodeHLL returns the sketch stored in v, decrypted with keys, or a new
+// one if v is not live.
+func decodeHLL(v StoredValue, live bool, keys *keyring) (*probabilistic.HyperLogLog, error) {
+	if !live {
+		return probabilistic.NewHyperLogLog(probabilistic.DefaultHLLPrecision), nil
+	}
+	if v.Spill != "" {
+		return nil, errNotHLL
+	}
+	hll := new(probabilistic.HyperLogLog)
+	if err := hll.UnmarshalBinary(v.plain(keys).Data); err != nil {
+		return nil, errNotHLL
+	}
+	return hll, nil
+}
+
+// handleHLL routes the /hll/{key}/{op} routes (and their namespaced
+// form) to the op.
+func (s *KVServer) handleHLL(w http.ResponseWriter, r *http.Request) {
+	key, op := splitKeyAction(r.PathValue("key"))
+	var handler func(w http.ResponseWriter, r *http.Request, key string, ns *namespace)
+	switch {
+	case r.Method == http.MethodPost && op == "add":
+		handler = s.handleHLLAdd
+	case r.Method == http.MethodPost && op == "merge":
+		handler = s.handleHLLMerge
+	case (r.Method == http.MethodGet || r.Method == http.MethodHead) && op == "count":
+		handler = s.handleHLLCount
+	default:
+		writeError(w, r, http.StatusNotFound, codeNotFound, "use /hll/{key}/add, /count or /merge")
+		return
+	}
+	r.SetPathValue("key", key)
+	s.keyHandler(handler)(w, r)
+}
+
+// writeHLL stores hll at key in place of old, live if it was, keeping the
+// old value's TTL, lease and tags.
+func (s *KVServer) writeHLL(p principal, ns *namespace, hll *probabilistic.HyperLogLog, old StoredValue, live bool, version uint64, now time.Time) StoredValue {
+	v := StoredValue{ContentType: hllContentType, Owner: p.TokenID, Version: version}
+	v.stamp(now)
+	if live {
+		v.HasTTL, v.ExpiresAt, v.Lease, v.Tags = old.HasTTL, old.ExpiresAt, old.Lease, old.Tags
+		v.inherit(old)
+	} else {
+		s.applyTTLPolicy(ns, &v, now)
+	}
+	v.Data, _ = hll.MarshalBinary()
+	s.encode(&v)
+	return v
+}
+
+// HLL ADD: POST /hll/{key}/add {"members": ["u1", "u2"]} adds members to
+// the sketch at key, creating it if missing, and reports whether the
+// estimate may have changed. The update is one atomic step.
+func (s *KVServer) handleHLLAdd(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+	s.metrics.TotalPuts.Add(1)
+	var req struct {
+		Members []string `json:"members"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidBody, "invalid body: "+err.Error())
+		return
+	}
+
+	p, _ := principalFrom(r.Context())
+	if serr := s.quotaError(p, ns, key, hllMaxSize); serr != nil {
+		serr.write(w, r)
+		return
+	}
+	if ns != nil {
+		ns.stats.puts.Add(1)
+	}
+
+	changed := false
+	_, sp := s.tracer.start(r.Context(), "store.hll_add", spanKindInternal)
+	version := s.nextVersion()
+	err := s.store.ComputeErr(key, func(old StoredValue, exists bool) (StoredValue, bool, error) {
+		now := time.Now()
+		live := exists && !old.isExpired(now)
+		hll, err := decodeHLL(old, live, s.valueKeys)
+		if err != nil {
+			return old, exists, err
+		}
+		changed = false
+		for _, m := range req.Members {
+			if hll.Add(m) {
+				changed = true
+			}
+		}
+		if live && !changed {
+			return old, exists, nil
+		}
+		return s.writeHLL(p, ns, hll, old, live, version, now), true, nil
+	})
+	sp.finish()
+	switch {
+	case errors.Is(err, errNotHLL):
+		writeError(w, r, http.StatusConflict, codeNotHLL, err.Error())
+		return
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	if !s.persist(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]bool{"changed": changed})
+}
+
+// HLL COUNT: GET /hll/{key}/count returns the estimated number of distinct
+// members added to the sketch at key, 0 if it is missing.
+func (s *KVServer) handleHLLCount(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+	s.metrics.TotalGets.Add(1)
+	value, ok := s.fetch(r.Context(), key, ns)
+	hll, err := decodeHLL(value, ok, s.valueKeys)
+	if err != nil {
+		writeError(w, r, http.StatusConflict, codeNotHLL, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]uint64{"count": hll.Count()})
+}
+
+// HLL MERGE: POST /hll/{key}/merge {"sources": ["a", "b"]} merges the
+// sketches at the source keys, of the same namespace, into the one at key,
+// which then counts the union, and returns its estimate. Missing sources
+// count as empty. The merge is one atomic step across all the keys.
+func (s *KVServer) handleHLLMerge(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+	s.metrics.TotalPuts.Add(1)
+	var req struct {
+		Sources []string `json:"sources"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidBody, "invalid body: "+err.Error())
+		return
+	}
+	if len(req.Sources) == 0 || len(req.Sources) > maxHLLSources {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("sources must list 1-%d keys", maxHLLSources))
+		return
+	}
+
+	p, _ := principalFrom(r.Context())
+	nsName := r.PathValue("ns")
+	keys := []string{key}
+	for _, src := range req.Sources {
+		storeKey, _, serr := s.resolveKey(p, nsName, src)
+		if serr == nil && s.aclEnforced && !p.has(scopeAdmin) && !s.acl.allowed(p.Name, aclKey(nsName, src), scopeRead) {
+			serr = &statusError{http.StatusForbidden, codeForbidden, "no ACL rule allows read on " + src}
+		}
+		if serr != nil {
+			serr.write(w, r)
+			return
+		}
+		keys = append(keys, storeKey)
+	}
+	if serr := s.quotaError(p, ns, key, hllMaxSize); serr != nil {
+		serr.write(w, r)
+		return
+	}
+	if ns != nil {
+		ns.stats.puts.Add(1)
+	}
+
+	var count uint64
+	_, sp := s.tracer.start(r.Context(), "store.hll_merge", spanKindInternal)
+	version := s.nextVersion()
+	err := s.store.ComputeMany(keys, func(current map[string]StoredValue) (map[string]StoredValue, []string, error) {
+		now := time.Now()
+		old, exists := current[key]
+		live := exists && !old.isExpired(now)
+		dst, err := decodeHLL(old, live, s.valueKeys)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, src := range keys[1:] {
+			v, ok := current[src]
+			hll, err := decodeHLL(v, ok && !v.isExpired(now), s.valueKeys)
+			if err != nil {
+				return nil, nil, err
+			}
+			if err := dst.Merge(hll); err != nil {
+				return nil, nil, errHLLPrecision
+			}
+		}
+		count = dst.Count()
+		return map[string]StoredValue{key: s.writeHLL(p, ns, dst, old, live, version, now)}, nil, nil
+	})
+	sp.finish()
+	switch {
+	case errors.Is(err, errNotHLL), errors.Is(err, errHLLPrecision):
+		writeError(w, r, http.StatusConflict, codeNotHLL, err.Error())
+		return
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	if !s.persist(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]uint64{"count": count})
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"github.com/shubhamc1947/safemap/pkg/concurrentmap"
+	"github.com/shubhamc1947/safemap/pkg/jsondoc"
+)
+
+// ----------- Secondary Indexes -----------
+
+// A namespace defined with "indexes": ["status", "user.role"] indexes
+// those fields of its JSON values, and GET /v1/{ns}/query finds the keys
+// whose field has a given value. A field is a path of pkg/jsondoc, with
+// the leading "$." optional. Strings, numbers, booleans and null are
+// indexed as text (as they are written in JSON, strings unquoted), and an
+// array is indexed under each of its scalar elements. Values that are not
+// JSON, lack the field or are spilled to disk are left out.
+//
+// Indexes live in memory only. They follow the store's events, so they
+// stay exact across writes, expiry, replay and restore, and are rebuilt
+// from the store when a namespace's indexes change or data is loaded.
+
+const maxIndexes = 16
+
+// indexField is an indexed field of a namespace: its name as declared,
+// and the path it stands for.
+type indexField struct {
+	name string
+	path jsondoc.Path
+}
+
+// parseIndexes checks the declared indexes of a namespace.
+func parseIndexes(names []string) ([]indexField, error) {
+	if len(names) > maxIndexes {
+		return nil, fmt.Errorf("a namespace may have at most %d indexes", maxIndexes)
+	}
+	fields := make([]indexField, 0, len(names))
+	for _, name := range names {
+		if strings.ContainsFunc(name, unicode.IsControl) {
+			return nil, fmt.Errorf("index %q: names must not contain control characters", name)
+		}
+		expr := name
+		if !strings.HasPrefix(expr, "$") {
+			expr = "$." + expr
+		}
+		path, err := jsondoc.ParsePath(expr)
+		if err != nil || len(path) == 0 {
+			return nil, fmt.Errorf("index %q is not a field path", name)
+		}
+		if slices.ContainsFunc(fields, func(f indexField) bool { return f.name == name }) {
+			return nil, fmt.Errorf("index %q is declared twice", name)
+		}
+		fields = append(fields, indexField{name, path})
+	}
+	return fields, nil
+}
+
+// indexRegistry holds the indexes of every namespace. Entries are
+// "<field>\x00<value>"; keys are those inside their namespace.
+type indexRegistry struct {
+	store *concurrentmap.ConcurrentMap[string, StoredValue]
+	keys  *keyring // decrypts values to index; nil = no keyring
+
+	// fields maps namespaces to their indexes. It is read from store
+	// hooks, so it is swapped whole rather than locked.
+	fields atomic.Pointer[map[string][]indexField]
+	mu     sync.Mutex // serializes define
+
+	byValue *concurrentmap.SetMap[string, string] // "<ns>\x00<entry>" -> keys
+	byKey   *concurrentmap.SetMap[string, string] // store key -> its entries
+}
+
+func newIndexRegistry(store *concurrentmap.ConcurrentMap[string, StoredValue], keys *keyring) *indexRegistry {
+	ix := &indexRegistry{
+		store:   store,
+		keys:    keys,
+		byValue: concurrentmap.NewStringSetMap[string](64),
+		byKey:   concurrentmap.NewStringSetMap[string](64),
+	}
+	ix.fields.Store(&map[string][]indexField{})
+	store.Subscribe(ix.track)
+	return ix
+}
+
+// track keeps the indexes current. It runs under the store's bucket lock,
+// so it only touches the index sets, which have locks of their own.
+func (ix *indexRegistry) track(ev concurrentmap.Event[string, StoredValue]) {
+	ns, key, ok := splitStoreKey(ev.Key)
+	if !ok {
+		return
+	}
+	var entries []string
+	if ev.Type == concurrentmap.EventInsert || ev.Type == concurrentmap.EventUpdate {
+		entries = ix.entries(ns, ev.NewValue)
+	}
+	ix.reindex(ns, key, ev.Key, entries)
+}
+
+// reindex replaces the entries of a key with entries.
+func (ix *indexRegistry) reindex(ns, key, storeKey string, entries []string) {
+	for _, e := range ix.byKey.Members(storeKey) {
+		if !slices.Contains(entries, e) {
+			ix.byValue.Remove(ns+"\x00"+e, key)
+			ix.byKey.Remove(storeKey, e)
+		}
+	}
+	if len(entries) == 0 {
+		return
+	}
+	for _, e := range entries {
+		ix.byValue.Add(ns+"\x00"+e, key)
+	}
+	ix.byKey.Add(storeKey, entries...)
+}
+
+// entries returns the index entries of v, a value in namespace ns.
+func (ix *indexRegistry) entries(ns string, v StoredValue) []string {
+	fields := (*ix.fields.Load())[ns]
+	if len(fields) == 0 || v.Spill != "" {
+		return nil
+	}
+	doc, err := decodeJSON(v.plain(ix.keys).Data)
+	if err != nil {
+		return nil
+	}
+	var entries []string
+	add := func(name string, node any) {
+		if text, ok := indexText(node); ok {
+			if e := name + "\x00" + text; !slices.Contains(entries, e) {
+				entries = append(entries, e)
+			}
+		}
+	}
+	for _, f := range fields {
+		node, err := jsondoc.Get(doc, f.path)
+		if err != nil {
+			continue
+		}
+		if arr, ok := node.([]any); ok {
+			for _, elem := range arr {
+				add(f.name, elem)
+			}
+			continue
+		}
+		add(f.name, node)
+	}
+	return entries
+}
+
+// indexText returns the text a scalar is indexed under.
+func indexText(node any) (string, bool) {
+	switch v := node.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case nil:
+		return "null", true
+	}
+	return "", false
+}
+
+// define sets the indexes of namespace ns, nil for none, and rebuilds
+// them from the store if they changed.
+func (ix *indexRegistry) define(ns string, fields []indexField) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	cur := *ix.fields.Load()
+	if slices.EqualFunc(cur[ns], fields, func(a, b indexField) bool { return a.name == b.name }) {
+		return
+	}
+	next := maps.Clone(cur)
+	if len(fields) == 0 {
+		delete(next, ns)
+	} else {
+		next[ns] = fields
+	}
+	ix.fields.Store(&next)
+
+	// Writes from now on are indexed by track. Range holds each bucket's
+	// lock while it reindexes the keys in it, so it cannot interleave
+	// with track for the same key.
+	prefix := nsKeyPrefix + ns + "/"
+	start := time.Now()
+	n := 0
+	ix.store.Range(func(storeKey string, v StoredValue) bool {
+		if key, ok := strings.CutPrefix(storeKey, prefix); ok {
+			ix.reindex(ns, key, storeKey, ix.entries(ns, v))
+			n++
+		}
+		return true
+	})
+	slog.Info("indexes rebuilt", "namespace", ns, "indexes", len(fields), "keys", n, "took", time.Since(start))
+}
+
+// lookup returns the keys of namespace ns whose field has the value text,
+// sorted.
+func (ix *indexRegistry) lookup(ns, field, text string) []string {
+	keys := ix.byValue.Members(ns + "\x00" + field + "\x00" + text)
+	slices.Sort(keys)
+	return keys
+}
+
+// defineIndexes applies ns's declared indexes to the registry.
+func (s *KVServer) defineIndexes(ns *namespace) {
+	fields, _ := parseIndexes(ns.Indexes) // checked when defined
+	s.indexes.define(ns.Name, fields)
+}
+
+// Query: GET /v1/{ns}/query?index=status&value=active lists the keys of
+// the namespace whose indexed field has that value, in key order, a page
+// at a time:
+//
+//	{"keys": ["order-1", "order-7"], "cursor": ""}
+//
+// As in key listings, limit sets the page size and a non-empty cursor is
+// passed back for the next page. With --acl, keys the caller may not read
+// are left out.
+func (s *KVServer) handleQuery(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := defaultListLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxListLimit {
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, "limit must be 1-"+strconv.Itoa(maxListLimit))
+			return
+		}
+		limit = n
+	}
+	after, err := base64.RawURLEncoding.DecodeString(q.Get("cursor"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "invalid cursor")
+		return
+	}
+	if !q.Has("value") {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "missing value")
+		return
+	}
+
+	p, _ := principalFrom(r.Context())
+	ns, serr := s.resolveNamespace(p, r.PathValue("ns"))
+	if serr != nil {
+		serr.write(w, r)
+		return
+	}
+	field := q.Get("index")
+	if !slices.Contains(ns.Indexes, field) {
+		writeError(w, r, http.StatusNotFound, codeIndexNotFound, fmt.Sprintf("namespace %s has no index %q", ns.Name, field))
+		return
+	}
+
+	_, sp := s.tracer.start(r.Context(), "index.lookup", spanKindInternal)
+	matches := s.indexes.lookup(ns.Name, field, q.Get("value"))
+	sp.setAttr("keys", len(matches))
+	sp.finish()
+
+	now := time.Now()
+	keys := []string{}
+	cursor := ""
+	start, found := slices.BinarySearch(matches, string(after))
+	if found {
+		start++
+	}
+	for _, key := range matches[start:] {
+		if len(keys) == limit {
+			cursor = base64.RawURLEncoding.EncodeToString([]byte(keys[len(keys)-1]))
+			break
+		}
+		if v, ok := s.store.Get(ns.storeKey(key)); !ok || v.isExpired(now) {
+			continue
+		}
+		if s.aclEnforced && !p.has(scopeAdmin) && !s.acl.allowed(p.Name, aclKey(ns.Name, key), scopeRead) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"keys": keys, "cursor": cursor})
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/shubhamc1947/safemap/pkg/jsondoc"
+)
+
+// ----------- JSON Documents -----------
+
+// A value whose bytes are a JSON document, raw or inside the envelope's
+// "value" string, can be read and edited in part:
+//
+//	GET   /kv/{key}/json?path=$.user.name
+//	PATCH /kv/{key}/json?path=$.user.name   (body: the JSON to store there)
+//	PATCH /kv/{key}/json                    (Content-Type: application/merge-patch+json)
+//
+// Paths are those of pkg/jsondoc; no path means the whole document. A
+// PATCH reads, edits and writes the value in one step under the key's
+// lock, so concurrent edits of different fields all land. Documents are
+// written back re-encoded: object members come out sorted by name.
+
+const mergePatchType = "application/merge-patch+json"
+
+var errNotJSON = errors.New("the value is not a JSON document")
+
+// jsonPathParam parses the path query parameter, writing the error
+// response if it is invalid.
+func jsonPathParam(w http.ResponseWriter, r *http.Request) (jsondoc.Path, bool) {
+	path, err := jsondoc.ParsePath(r.URL.Query().Get("path"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidPath, err.Error())
+		return nil, false
+	}
+	return path, true
+}
+
+// decodeJSON decodes a whole document, keeping numbers as written.
+func decodeJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("data after the document")
+	}
+	return doc, nil
+}
+
+// encodeJSON encodes doc compactly, leaving <, > and & as they are.
+func encodeJSON(doc any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// JSON GET: GET /kv/{key}/json?path=<path> returns the node at path of
+// the document stored at key.
+func (s *KVServer) handleJSONGet(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+	s.metrics.TotalGets.Add(1)
+	path, ok := jsonPathParam(w, r)
+	if !ok {
+		return
+	}
+	value, ok := s.fetch(r.Context(), key, ns)
+	if !ok {
+		writeError(w, r, http.StatusNotFound, codeKeyNotFound, "key not found")
+		return
+	}
+	doc, err := decodeJSON(value.Data)
+	if err != nil {
+		writeError(w, r, http.StatusConflict, codeNotJSON, errNotJSON.Error())
+		return
+	}
+	node, err := jsondoc.Get(doc, path)
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, codePathNotFound, err.Error())
+		return
+	}
+	out, err := encodeJSON(node)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	w.Header().Set("ETag", value.etag())
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(append(out, '\n'))
+}
+
+// handleKeyPatch routes PATCH /kv/{key}/json (and its namespaced form);
+// PATCH is not accepted on anything else.
+func (s *KVServer) handleKeyPatch(w http.ResponseWriter, r *http.Request) {
+	key, action := splitKeyAction(r.PathValue("key"))
+	if action != "json" {
+		allowMethods(keyMethods)(w, r)
+		return
+	}
+	r.SetPathValue("key", key)
+	s.keyHandler(s.handleJSONPatch)(w, r)
+}
+
+// JSON PATCH: PATCH /kv/{key}/json?path=<path> edits the document stored
+// at key and returns the whole new document. With Content-Type
+// application/merge-patch+json the body is merged into the node at path
+// (RFC 7396); otherwise it replaces that node, creating the members
+// leading to it. A missing key starts out as null, and is created as a
+// raw application/json value. If-Match makes the edit conditional.
+func (s *KVServer) handleJSONPatch(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+	s.metrics.TotalPuts.Add(1)
+	path, ok := jsonPathParam(w, r)
+	if !ok {
+		return
+	}
+	merge := strings.HasPrefix(r.Header.Get("Content-Type"), mergePatchType)
+	body, ok := s.readBody(w, r)
+	if !ok {
+		return
+	}
+	patch, err := decodeJSON(body)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidBody, "invalid JSON: "+err.Error())
+		return
+	}
+
+	p, _ := principalFrom(r.Context())
+	size := int64(len(body))
+	if cur, ok := s.store.Get(key); ok && !cur.isExpired(time.Now()) {
+		size += cur.size()
+	}
+	if serr := s.quotaError(p, ns, key, size); serr != nil {
+		serr.write(w, r)
+		return
+	}
+	if ns != nil {
+		ns.stats.puts.Add(1)
+	}
+
+	ifMatch := r.Header.Get("If-Match")
+	var stored StoredValue
+	var doc any
+	_, sp := s.tracer.start(r.Context(), "store.json_patch", spanKindInternal)
+	version := s.nextVersion()
+	err = s.store.ComputeErr(key, func(old StoredValue, exists bool) (StoredValue, bool, error) {
+		now := time.Now()
+		live := exists && !old.isExpired(now)
+		if ifMatch != "" && !etagMatches(ifMatch, old, live) {
+			return old, exists, errPreconditionFailed
+		}
+		v := StoredValue{ContentType: "application/json"}
+		v.stamp(now)
+		var err error
+		if live {
+			if old.Spill != "" {
+				return old, exists, errValueSpilled
+			}
+			old, _ = s.whole(key, old)
+			if doc, err = decodeJSON(old.plain(s.valueKeys).Data); err != nil {
+				return old, exists, errNotJSON
+			}
+			v.HasTTL, v.ExpiresAt, v.ContentType, v.Lease, v.Flags, v.Tags = old.HasTTL, old.ExpiresAt, old.ContentType, old.Lease, old.Flags, old.Tags
+			v.inherit(old)
+		} else {
+			s.applyTTLPolicy(ns, &v, now)
+		}
+
+		node := patch
+		if merge {
+			cur, _ := jsondoc.Get(doc, path)
+			node = jsondoc.MergePatch(cur, patch)
+		}
+		if doc, err = jsondoc.Set(doc, path, node); err != nil {
+			return old, exists, err
+		}
+		if v.Data, err = encodeJSON(doc); err != nil {
+			return old, exists, err
+		}
+		if s.maxValueSize > 0 && int64(len(v.Data)) > s.maxValueSize {
+			return old, exists, errValueTooLarge
+		}
+		s.encode(&v)
+		v.Owner, v.Version = p.TokenID, version
+		stored = v
+		return v, true, nil
+	})
+	sp.finish()
+	switch {
+	case errors.Is(err, errPreconditionFailed):
+		writeError(w, r, http.StatusPreconditionFailed, codePreconditionFailed, "If-Match does not match the current value")
+		return
+	case errors.Is(err, errNotJSON):
+		writeError(w, r, http.StatusConflict, codeNotJSON, err.Error())
+		return
+	case errors.Is(err, jsondoc.ErrConflict):
+		writeError(w, r, http.StatusConflict, codePathConflict, err.Error())
+		return
+	case errors.Is(err, errValueSpilled):
+		writeError(w, r, http.StatusConflict, codeValueSpilled, "the value is spilled to disk and cannot be edited")
+		return
+	case errors.Is(err, errValueTooLarge):
+		writeError(w, r, http.StatusRequestEntityTooLarge, codeValueTooLarge,
+			fmt.Sprintf("the document would exceed the %d byte limit", s.maxValueSize))
+		return
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	if !s.persist(w, r) {
+		return
+	}
+
+	out, _ := encodeJSON(doc)
+	w.Header().Set("ETag", stored.etag())
+	setDigestHeader(w.Header(), stored, s.valueKeys)
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(append(out, '\n'))
+}
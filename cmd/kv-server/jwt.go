@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ----------- JWT Bearer Tokens -----------
+
+// jwtVerifier validates JWTs signed with a shared HMAC secret (HS256/384/
+// 512) or with keys published at a JWKS URL (RS256/384/512, ES256/384).
+// Scopes and namespaces are read from configurable claims.
+type jwtVerifier struct {
+	secret   []byte     // HMAC key, or nil
+	jwks     *jwksCache // or nil
+	issuer   string     // required "iss" when set
+	audience string     // required in "aud" when set
+
+	scopeClaim     string
+	namespaceClaim string
+}
+
+// jwtLeeway tolerates clock skew when checking exp and nbf.
+const jwtLeeway = time.Minute
+
+var errJWT = errors.New("invalid token")
+
+func looksLikeJWT(s string) bool {
+	return strings.Count(s, ".") == 2
+}
+
+// verify checks token's signature and registered claims and returns its
+// claims.
+func (v *jwtVerifier) verify(ctx context.Context, token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errJWT
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature encoding", errJWT)
+	}
+	if err := v.checkSignature(ctx, header.Alg, header.Kid, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]any
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if exp, ok := claims["exp"].(float64); ok && now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return nil, fmt.Errorf("%w: expired", errJWT)
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, fmt.Errorf("%w: not yet valid", errJWT)
+	}
+	if v.issuer != "" && claims["iss"] != v.issuer {
+		return nil, fmt.Errorf("%w: issuer", errJWT)
+	}
+	if v.audience != "" && !containsClaim(claims["aud"], v.audience) {
+		return nil, fmt.Errorf("%w: audience", errJWT)
+	}
+	return claims, nil
+}
+
+func decodeJWTPart(part string, dst any) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return fmt.Errorf("%w: encoding", errJWT)
+	}
+	if err := json.Unmarshal(b, dst); err != nil {
+		return fmt.Errorf("%w: %v", errJWT, err)
+	}
+	return nil
+}
+
+func (v *jwtVerifier) checkSignature(ctx context.Context, alg, kid, signed string, sig []byte) error {
+	var h func() hash.Hash
+	var ch crypto.Hash
+	switch alg[min(2, len(alg)):] {
+	case "256":
+		h, ch = sha256.New, crypto.SHA256
+	case "384":
+		h, ch = sha512.New384, crypto.SHA384
+	case "512":
+		h, ch = sha512.New, crypto.SHA512
+	default:
+		return fmt.Errorf("%w: unsupported alg %q", errJWT, alg)
+	}
+
+	if strings.HasPrefix(alg, "HS") {
+		if v.secret == nil {
+			return fmt.Errorf("%w: HMAC tokens not accepted", errJWT)
+		}
+		mac := hmac.New(h, v.secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return fmt.Errorf("%w: signature", errJWT)
+		}
+		return nil
+	}
+
+	if v.jwks == nil {
+		return fmt.Errorf("%w: alg %q not accepted", errJWT, alg)
+	}
+	key, err := v.jwks.key(ctx, kid)
+	if err != nil {
+		return err
+	}
+	digest := h()
+	digest.Write([]byte(signed))
+	sum := digest.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") || rsa.VerifyPKCS1v15(k, ch, sum, sig) != nil {
+			return fmt.Errorf("%w: signature", errJWT)
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(alg, "ES") || len(sig) != 2*size {
+			return fmt.Errorf("%w: signature", errJWT)
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, sum, r, s) {
+			return fmt.Errorf("%w: signature", errJWT)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported key type", errJWT)
+	}
+	return nil
+}
+
+// principal maps verified claims to a caller. The scope claim may be a
+// space-separated string (OAuth 2 "scope") or an array; values may carry
+// a "kv:" prefix. Unknown scopes are ignored.
+func (v *jwtVerifier) principal(claims map[string]any) principal {
+	p := principal{Name: "jwt"}
+	if sub, ok := claims["sub"].(string); ok {
+		p.Name = "jwt:" + sub
+	}
+	for _, sc := range claimStrings(claims[v.scopeClaim]) {
+		if sc = strings.TrimPrefix(sc, "kv:"); validScope(sc) && !p.has(sc) {
+			p.Scopes = append(p.Scopes, sc)
+		}
+	}
+	p.Namespaces = claimStrings(claims[v.namespaceClaim])
+	return p
+}
+
+func claimStrings(c any) []string {
+	switch c := c.(type) {
+	case string:
+		return strings.Fields(c)
+	case []any:
+		out := make([]string, 0, len(c))
+		for _, e := range c {
+			if s, ok := e.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func containsClaim(c any, want string) bool {
+	for _, s := range claimStrings(c) {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
+
+// ----------- JWKS -----------
+
+// jwksCache holds the keys published at a JWKS URL. Keys are refetched
+// every jwksRefresh, and early when a token names an unknown key ID (at
+// most once per jwksMinRefetch, so bad tokens cannot hammer the issuer).
+type jwksCache struct {
+	url    string
+	client *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+const (
+	jwksRefresh    = 10 * time.Minute
+	jwksMinRefetch = 30 * time.Second
+)
+
+func newJWKSCache(url string) *jwksCache {
+	return &jwksCache{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (c *jwksCache) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	k, ok := c.keys[kid]
+	age := time.Since(c.fetched)
+	if (!ok && age >= jwksMinRefetch) || age >= jwksRefresh {
+		if err := c.fetch(ctx); err != nil {
+			slog.WarnContext(ctx, "jwt: fetching JWKS failed", "url", c.url, "err", err)
+		} else {
+			k, ok = c.keys[kid]
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key %q", errJWT, kid)
+	}
+	return k, nil
+}
+
+// fetch replaces the key set. The caller holds c.mu.
+func (c *jwksCache) fetch(ctx context.Context) error {
+	c.fetched = time.Now() // also on failure, to pace retries
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", c.url, resp.Status)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty, Kid, Use string
+			N, E          string
+			Crv, X, Y     string
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jk := range set.Keys {
+		if jk.Use != "" && jk.Use != "sig" {
+			continue
+		}
+		switch jk.Kty {
+		case "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(jk.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(jk.E)
+			if err1 != nil || err2 != nil || len(e) > 4 {
+				continue
+			}
+			keys[jk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch jk.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			default:
+				continue
+			}
+			x, err1 := base64.RawURLEncoding.DecodeString(jk.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(jk.Y)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[jk.Kid] = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	c.keys = keys
+	return nil
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shubhamc1947/safemap/pkg/concurrentmap"
+)
+
+// ----------- Key Listing -----------
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// keyInfo describes a key in a listing with metadata.
+type keyInfo struct {
+	Key         string     `json:"key"`
+	Size        int        `json:"size"`
+	ContentType string     `json:"content_type,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	ETag        string     `json:"etag"`
+}
+
+// keyFilter matches the live keys a listing in ns (nil for the flat
+// keyspace) covers: those starting with prefix, a store key. The flat
+// keyspace leaves out reserved and namespaced keys.
+func keyFilter(ns *namespace, prefix string, now time.Time) func(string, StoredValue) bool {
+	return func(key string, v StoredValue) bool {
+		return strings.HasPrefix(key, prefix) && !v.isExpired(now) &&
+			(ns != nil || !isReservedKey(key) && !strings.HasPrefix(key, nsKeyPrefix))
+	}
+}
+
+// isKeysPath reports whether path is /keys or /v1/{ns}/keys.
+func isKeysPath(path string) bool {
+	_, rest, ok := nsPath(path)
+	return path == "/keys" || ok && rest == "/keys"
+}
+
+// encodeCursor makes a scan cursor opaque to clients: the bucket, then
+// the last key returned from it in unpadded URL-safe base64. The end of
+// a scan is "".
+func encodeCursor(c concurrentmap.Cursor[string]) string {
+	if c == (concurrentmap.Cursor[string]{}) {
+		return ""
+	}
+	s := strconv.Itoa(c.Bucket)
+	if c.HasLast {
+		s += "." + base64.RawURLEncoding.EncodeToString([]byte(c.Last))
+	}
+	return s
+}
+
+func decodeCursor(s string) (concurrentmap.Cursor[string], bool) {
+	var c concurrentmap.Cursor[string]
+	if s == "" {
+		return c, true
+	}
+	bucket, last, hasLast := strings.Cut(s, ".")
+	n, err := strconv.Atoi(bucket)
+	if err != nil || n < 0 {
+		return c, false
+	}
+	c.Bucket = n
+	if hasLast {
+		key, err := base64.RawURLEncoding.DecodeString(last)
+		if err != nil {
+			return c, false
+		}
+		c.Last, c.HasLast = string(key), true
+	}
+	return c, true
+}
+
+// Keys: GET /keys?prefix=app:&cursor=...&limit=100 lists keys in pages,
+// like Redis SCAN. Pass the returned cursor back for the next page; an
+// empty cursor means the listing is complete. Each page read-locks one
+// shard of the store at a time. With meta=true, keys come back as
+// "entries" with their size, type, expiry and ETag.
+//
+// Pages may be short, even empty, before the end: with --acl, keys the
+// caller may not read are dropped after the page is taken.
+func (s *KVServer) handleListKeys(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := defaultListLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxListLimit {
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, "limit must be 1-"+strconv.Itoa(maxListLimit))
+			return
+		}
+		limit = n
+	}
+	cur, ok := decodeCursor(q.Get("cursor"))
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "invalid cursor")
+		return
+	}
+	withMeta, _ := strconv.ParseBool(q.Get("meta"))
+
+	p, _ := principalFrom(r.Context())
+	nsName := r.PathValue("ns")
+	ns, serr := s.resolveNamespace(p, nsName)
+	if serr != nil {
+		serr.write(w, r)
+		return
+	}
+	prefix := q.Get("prefix")
+	if ns != nil {
+		prefix = ns.storeKey(prefix)
+	}
+
+	_, sp := s.tracer.start(r.Context(), "store.scan", spanKindInternal)
+	page, next := concurrentmap.Scan(s.store, cur, limit, keyFilter(ns, prefix, time.Now()))
+	sp.setAttr("keys", len(page))
+	sp.finish()
+
+	keys := make([]string, 0, len(page))
+	entries := []keyInfo{}
+	for _, e := range page {
+		key := e.Key
+		if ns != nil {
+			key = strings.TrimPrefix(key, ns.storeKey(""))
+		}
+		if s.aclEnforced && !p.has(scopeAdmin) && !s.acl.allowed(p.Name, aclKey(nsName, key), scopeRead) {
+			continue
+		}
+		if !withMeta {
+			keys = append(keys, key)
+			continue
+		}
+		info := keyInfo{Key: key, Size: int(e.Value.size()), ContentType: e.Value.ContentType, ETag: e.Value.etag()}
+		if e.Value.HasTTL {
+			info.ExpiresAt = &e.Value.ExpiresAt
+		}
+		entries = append(entries, info)
+	}
+
+	out := map[string]any{"cursor": encodeCursor(next)}
+	if withMeta {
+		out["entries"] = entries
+	} else {
+		out["keys"] = keys
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+// Keys: DELETE /keys?prefix=session: deletes every key with the prefix,
+// and with pattern=..., only those also matching the glob (see
+// globMatch). At least one of the two is required. The store is walked
+// with the listing's cursor, so no more than one shard is locked at a
+// time. With --acl, keys the caller may not write are kept.
+//
+//	{"deleted": 42}
+func (s *KVServer) handleDeleteKeys(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	prefix, pattern := q.Get("prefix"), q.Get("pattern")
+	if prefix == "" && pattern == "" {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "prefix or pattern is required")
+		return
+	}
+
+	p, _ := principalFrom(r.Context())
+	nsName := r.PathValue("ns")
+	ns, serr := s.resolveNamespace(p, nsName)
+	if serr != nil {
+		serr.write(w, r)
+		return
+	}
+	storePrefix := prefix
+	if ns != nil {
+		storePrefix = ns.storeKey(prefix)
+	}
+
+	_, sp := s.tracer.start(r.Context(), "store.delete_keys", spanKindInternal)
+	deleted := 0
+	for cur := (concurrentmap.Cursor[string]{}); ; {
+		var page []concurrentmap.Entry[string, StoredValue]
+		page, cur = concurrentmap.Scan(s.store, cur, maxListLimit, keyFilter(ns, storePrefix, time.Now()))
+		for _, e := range page {
+			key := e.Key
+			if ns != nil {
+				key = strings.TrimPrefix(key, ns.storeKey(""))
+			}
+			if pattern != "" && !globMatch(pattern, key) {
+				continue
+			}
+			if s.aclEnforced && !p.has(scopeAdmin) && !s.acl.allowed(p.Name, aclKey(nsName, key), scopeWrite) {
+				continue
+			}
+			if s.store.DeleteErr(e.Key) == nil {
+				deleted++
+			}
+		}
+		if cur == (concurrentmap.Cursor[string]{}) {
+			break
+		}
+	}
+	sp.setAttr("deleted", deleted)
+	sp.finish()
+
+	s.metrics.TotalDeletes.Add(int64(deleted))
+	if ns != nil {
+		ns.stats.deletes.Add(int64(deleted))
+	}
+	if !s.persist(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]int{"deleted": deleted})
+}
+
+// globMatch reports whether name matches pattern in Redis's glob syntax:
+// * matches any run of characters, ? any one, [abc] and [a-z] one of a
+// set ([^...] negates it), and \ escapes the next character.
+func globMatch(pattern, name string) bool {
+	p, s := []rune(pattern), []rune(name)
+	px, sx := 0, 0
+	starPx, starSx := -1, 0 // where to resume after the last *
+	for px < len(p) || sx < len(s) {
+		if px < len(p) {
+			switch p[px] {
+			case '*':
+				starPx, starSx = px, sx
+				px++
+				continue
+			case '?':
+				if sx < len(s) {
+					px, sx = px+1, sx+1
+					continue
+				}
+			case '[':
+				if sx < len(s) {
+					if end, ok := matchClass(p, px, s[sx]); ok {
+						px, sx = end, sx+1
+						continue
+					}
+				}
+			case '\\':
+				if px+1 < len(p) {
+					px++
+				}
+				fallthrough
+			default:
+				if sx < len(s) && p[px] == s[sx] {
+					px, sx = px+1, sx+1
+					continue
+				}
+			}
+		}
+		// Mismatch: let the last * swallow one more character.
+		if starPx >= 0 && starSx < len(s) {
+			starSx++
+			px, sx = starPx+1, starSx
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// matchClass matches c against the [...] set starting at p[px] and
+// returns the index just past it. An unclosed set ends with the pattern.
+func matchClass(p []rune, px int, c rune) (int, bool) {
+	i := px + 1
+	negate := i < len(p) && p[i] == '^'
+	if negate {
+		i++
+	}
+	matched := false
+	for ; i < len(p) && p[i] != ']'; i++ {
+		switch {
+		case p[i] == '\\' && i+1 < len(p):
+			i++
+			matched = matched || p[i] == c
+		case i+2 < len(p) && p[i+1] == '-' && p[i+2] != ']':
+			lo, hi := min(p[i], p[i+2]), max(p[i], p[i+2])
+			matched = matched || lo <= c && c <= hi
+			i += 2
+		default:
+			matched = matched || p[i] == c
+		}
+	}
+	return min(i+1, len(p)), matched != negate
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shubhamc1947/safemap/pkg/concurrentmap"
+)
+
+// ----------- Leases -----------
+
+// Leases work as in etcd: a lease has a TTL, keys written with ?lease=<id>
+// are attached to it, and when the lease runs out or is revoked its keys
+// are deleted. Clients keep a lease, and so their keys, alive with
+// keepalives; it is how a service registers itself or tracks presence.
+//
+// A lease lives in the store under leaseKeyPrefix+<hashed ID>, with its
+// TTL as the value's, so leases are persisted with the data. Its value
+// lists the keys attached to it; each attached key carries the hashed ID
+// in StoredValue.Lease, which is what decides whether the key goes with
+// the lease: overwriting a key without ?lease detaches it.
+const (
+	leaseKeyPrefix   = "__lease/"
+	leaseRequestBody = 64 << 10
+)
+
+var errLeaseMissing = errors.New("lease not found")
+
+// leaseRecord is a lease as stored. Keys are store keys.
+type leaseRecord struct {
+	TTLSeconds int64    `json:"ttl_seconds"`
+	Keys       []string `json:"keys,omitempty"`
+}
+
+// ttl is the lease's TTL; readLeaseTTL checked TTLSeconds.
+func (rec leaseRecord) ttl() time.Duration {
+	ttl, _ := ttlDuration(rec.TTLSeconds)
+	return ttl
+}
+
+// leaseKeyJSON names an attached key as clients see it.
+type leaseKeyJSON struct {
+	Namespace string `json:"namespace,omitempty"`
+	Key       string `json:"key"`
+}
+
+// leaseJSON describes a lease in responses.
+type leaseJSON struct {
+	ID           string         `json:"id"`
+	TTLSeconds   int64          `json:"ttl_seconds"`
+	RemainingTTL int            `json:"remaining_ttl_seconds"`
+	ExpiresAt    time.Time      `json:"expires_at"`
+	Keys         []leaseKeyJSON `json:"keys,omitempty"`
+}
+
+func newLeaseJSON(id string, v StoredValue) leaseJSON {
+	var rec leaseRecord
+	_ = json.Unmarshal(v.Data, &rec)
+	return leaseJSON{
+		ID:           id,
+		TTLSeconds:   rec.TTLSeconds,
+		RemainingTTL: max(0, ceilSeconds(time.Until(v.ExpiresAt))),
+		ExpiresAt:    v.ExpiresAt,
+	}
+}
+
+// leaseRegistry deletes the keys of leases that end. Its store hook only
+// queues them, since it runs under a bucket lock and the keys are under
+// others; flush deletes the queue. It also tracks when each lease runs
+// out, so lapsed leases are revoked on time rather than whenever active
+// expiry happens to sample them.
+type leaseRegistry struct {
+	store *concurrentmap.ConcurrentMap[string, StoredValue]
+
+	mu      sync.Mutex
+	expires map[string]time.Time // store key -> lease expiry
+	pending []concurrentmap.Entry[string, StoredValue]
+	flushMu sync.Mutex
+}
+
+func newLeaseRegistry(store *concurrentmap.ConcurrentMap[string, StoredValue]) *leaseRegistry {
+	l := &leaseRegistry{store: store, expires: make(map[string]time.Time)}
+	store.Subscribe(l.track)
+	return l
+}
+
+// track follows lease records: their expiry, and the keys of those that
+// are deleted or expire.
+func (l *leaseRegistry) track(ev concurrentmap.Event[string, StoredValue]) {
+	if !strings.HasPrefix(ev.Key, leaseKeyPrefix) {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch ev.Type {
+	case concurrentmap.EventInsert, concurrentmap.EventUpdate:
+		l.expires[ev.Key] = ev.NewValue.ExpiresAt
+	case concurrentmap.EventDelete, concurrentmap.EventExpire:
+		delete(l.expires, ev.Key)
+		l.pending = append(l.pending, concurrentmap.Entry[string, StoredValue]{Key: ev.Key, Value: ev.OldValue})
+	}
+}
+
+// flush deletes the keys still attached to the leases that ended.
+func (l *leaseRegistry) flush() {
+	l.flushMu.Lock()
+	defer l.flushMu.Unlock()
+	l.mu.Lock()
+	pending := l.pending
+	l.pending = nil
+	l.mu.Unlock()
+
+	for _, e := range pending {
+		var rec leaseRecord
+		_ = json.Unmarshal(e.Value.Data, &rec)
+		l.detachAll(strings.TrimPrefix(e.Key, leaseKeyPrefix), rec.Keys)
+	}
+}
+
+// detachAll deletes those of keys still attached to lease.
+func (l *leaseRegistry) detachAll(lease string, keys []string) {
+	for _, key := range keys {
+		l.store.Compute(key, func(v StoredValue, exists bool) (StoredValue, bool) {
+			return v, exists && v.Lease != lease
+		})
+	}
+}
+
+// expire revokes the leases that ran out by now.
+func (l *leaseRegistry) expire(now time.Time) int {
+	l.mu.Lock()
+	var lapsed []string
+	for key, at := range l.expires {
+		if now.After(at) {
+			lapsed = append(lapsed, key)
+		}
+	}
+	l.mu.Unlock()
+
+	n := 0
+	for _, key := range lapsed {
+		if l.store.ExpireIf(key, func(v StoredValue) bool { return v.isExpired(now) }) {
+			n++
+		}
+	}
+	l.flush()
+	return n
+}
+
+// reload deletes keys whose lease is gone, which happens when a lease
+// expired while the server was down; call it after persisted data is
+// loaded.
+func (l *leaseRegistry) reload() {
+	now := time.Now()
+	live := make(map[string]bool)
+	orphans := make(map[string][]string)
+	l.store.Range(func(key string, v StoredValue) bool {
+		if id, ok := strings.CutPrefix(key, leaseKeyPrefix); ok {
+			live[id] = !v.isExpired(now)
+		} else if v.Lease != "" {
+			orphans[v.Lease] = append(orphans[v.Lease], key)
+		}
+		return true
+	})
+	for lease, keys := range orphans {
+		if !live[lease] {
+			l.detachAll(lease, keys)
+		}
+	}
+	l.expire(now)
+}
+
+// attach records key as attached to lease, the hashed ID. The caller
+// then writes the key with Lease set.
+func (l *leaseRegistry) attach(lease, key string) error {
+	return l.store.ComputeErr(leaseKeyPrefix+lease, func(old StoredValue, exists bool) (StoredValue, bool, error) {
+		if !exists || old.isExpired(time.Now()) {
+			return old, exists, errLeaseMissing
+		}
+		var rec leaseRecord
+		_ = json.Unmarshal(old.Data, &rec)
+		if slices.Contains(rec.Keys, key) {
+			return old, true, nil
+		}
+		rec.Keys = append(rec.Keys, key)
+		old.Data, _ = json.Marshal(rec)
+		return old, true, nil
+	})
+}
+
+// alive reports whether lease, the hashed ID, has not ended.
+func (l *leaseRegistry) alive(lease string) bool {
+	v, ok := l.store.Get(leaseKeyPrefix + lease)
+	return ok && !v.isExpired(time.Now())
+}
+
+// leaseParam returns the hashed ID of the lease named by ?lease=, "" if
+// there is none, writing the error response if it does not exist.
+func (s *KVServer) leaseParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := r.URL.Query().Get("lease")
+	if id == "" {
+		return "", true
+	}
+	lease := hashToken(id)
+	if !s.leases.alive(lease) {
+		writeError(w, r, http.StatusNotFound, codeLeaseNotFound, errLeaseMissing.Error())
+		return "", false
+	}
+	return lease, true
+}
+
+// attachLease attaches key, written with Lease set, to its lease. If the
+// lease ended while the key was being written, the key is deleted again
+// and the error response written.
+func (s *KVServer) attachLease(w http.ResponseWriter, r *http.Request, key, lease string) bool {
+	if lease == "" {
+		return true
+	}
+	if s.leases.attach(lease, key) == nil && s.leases.alive(lease) {
+		return true
+	}
+	s.leases.detachAll(lease, []string{key})
+	writeError(w, r, http.StatusNotFound, codeLeaseNotFound, errLeaseMissing.Error())
+	return false
+}
+
+// isLeasesPath reports whether path is /leases or under it.
+func isLeasesPath(path string) bool {
+	return path == "/leases" || strings.HasPrefix(path, "/leases/")
+}
+
+// readLeaseTTL decodes a grant or keepalive body, {"ttl_seconds": 60},
+// and returns its TTL; 0 if the body leaves it out.
+func readLeaseTTL(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	var req struct {
+		TTLSeconds int64 `json:"ttl_seconds"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, leaseRequestBody)).Decode(&req); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidBody, "invalid body: "+err.Error())
+			return 0, false
+		}
+	}
+	if _, ok := ttlDuration(req.TTLSeconds); !ok {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, ttlRangeMessage("ttl_seconds"))
+		return 0, false
+	}
+	return req.TTLSeconds, true
+}
+
+// Leases: POST /leases {"ttl_seconds": 60} grants a lease:
+//
+//	{"id": "...", "ttl_seconds": 60, "remaining_ttl_seconds": 60, "expires_at": "..."}
+//
+// Attach keys with PUT /kv/{key}?lease=<id>.
+func (s *KVServer) handleLeaseGrant(w http.ResponseWriter, r *http.Request) {
+	s.metrics.TotalRequests.Add(1)
+	ttl, ok := readLeaseTTL(w, r)
+	if !ok {
+		return
+	}
+	if ttl == 0 {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "ttl_seconds is required")
+		return
+	}
+	var raw [16]byte
+	_, _ = rand.Read(raw[:])
+	id := hex.EncodeToString(raw[:])
+
+	rec := leaseRecord{TTLSeconds: ttl}
+	data, _ := json.Marshal(rec)
+	v := StoredValue{Data: data, HasTTL: true, ExpiresAt: time.Now().Add(rec.ttl())}
+	s.store.Set(leaseKeyPrefix+hashToken(id), v)
+	if !s.persist(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(newLeaseJSON(id, v))
+}
+
+// Leases: GET /leases/{id} describes a lease and the keys still attached
+// to it.
+func (s *KVServer) handleLeaseGet(w http.ResponseWriter, r *http.Request) {
+	s.metrics.TotalRequests.Add(1)
+	id := r.PathValue("id")
+	lease := hashToken(id)
+	v, ok := s.store.Get(leaseKeyPrefix + lease)
+	if !ok || v.isExpired(time.Now()) {
+		writeError(w, r, http.StatusNotFound, codeLeaseNotFound, errLeaseMissing.Error())
+		return
+	}
+	var rec leaseRecord
+	_ = json.Unmarshal(v.Data, &rec)
+	out := newLeaseJSON(id, v)
+	out.Keys = []leaseKeyJSON{}
+	for _, key := range rec.Keys {
+		if cur, ok := s.store.Get(key); !ok || cur.Lease != lease || cur.isExpired(time.Now()) {
+			continue
+		}
+		if ns, name, ok := splitStoreKey(key); ok {
+			out.Keys = append(out.Keys, leaseKeyJSON{Namespace: ns, Key: name})
+		} else {
+			out.Keys = append(out.Keys, leaseKeyJSON{Key: key})
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+// Leases: POST /leases/{id}/keepalive restarts the lease's TTL from now.
+// A body of {"ttl_seconds": N} also changes the TTL for this and later
+// keepalives.
+func (s *KVServer) handleLeaseKeepAlive(w http.ResponseWriter, r *http.Request) {
+	s.metrics.TotalRequests.Add(1)
+	ttl, ok := readLeaseTTL(w, r)
+	if !ok {
+		return
+	}
+	id := r.PathValue("id")
+	var renewed StoredValue
+	err := s.store.ComputeErr(leaseKeyPrefix+hashToken(id), func(old StoredValue, exists bool) (StoredValue, bool, error) {
+		now := time.Now()
+		if !exists || old.isExpired(now) {
+			return old, exists, errLeaseMissing
+		}
+		var rec leaseRecord
+		_ = json.Unmarshal(old.Data, &rec)
+		if ttl > 0 {
+			rec.TTLSeconds = ttl
+			old.Data, _ = json.Marshal(rec)
+		}
+		old.ExpiresAt = now.Add(rec.ttl())
+		renewed = old
+		return old, true, nil
+	})
+	switch {
+	case errors.Is(err, errLeaseMissing):
+		writeError(w, r, http.StatusNotFound, codeLeaseNotFound, err.Error())
+		return
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	if !s.persist(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(newLeaseJSON(id, renewed))
+}
+
+// Leases: DELETE /leases/{id} revokes the lease now, deleting its keys.
+func (s *KVServer) handleLeaseRevoke(w http.ResponseWriter, r *http.Request) {
+	s.metrics.TotalRequests.Add(1)
+	err := s.store.ComputeErr(leaseKeyPrefix+hashToken(r.PathValue("id")), func(old StoredValue, exists bool) (StoredValue, bool, error) {
+		if !exists || old.isExpired(time.Now()) {
+			return old, exists, errLeaseMissing
+		}
+		return old, false, nil
+	})
+	if errors.Is(err, errLeaseMissing) {
+		writeError(w, r, http.StatusNotFound, codeLeaseNotFound, err.Error())
+		return
+	}
+	if !s.persist(w, r) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ----------- Listeners -----------
+
+// listenFlag collects repeated --listen values.
+type listenFlag []string
+
+func (f *listenFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *listenFlag) Set(v string) error {
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*f = append(*f, s)
+		}
+	}
+	return nil
+}
+
+// listenSpec is one parsed --listen address:
+//
+//	tcp://host:port or host:port   plain HTTP over TCP
+//	tls://host:port or tcp+tls://  HTTPS over TCP
+//	unix:///path/to/sock           plain HTTP over a unix socket
+//	systemd                        sockets passed by systemd (LISTEN_FDS)
+//	systemd+tls                    the same, serving HTTPS
+type listenSpec struct {
+	network string // "tcp", "unix" or "systemd"
+	addr    string
+	tls     bool
+}
+
+func (l listenSpec) String() string {
+	scheme := l.network
+	if l.tls {
+		scheme += "+tls"
+	}
+	if l.network == "systemd" {
+		return scheme
+	}
+	return scheme + "://" + l.addr
+}
+
+func parseListenSpec(s string) (listenSpec, error) {
+	scheme, addr, found := strings.Cut(s, "://")
+	if !found {
+		switch s {
+		case "systemd":
+			return listenSpec{network: "systemd"}, nil
+		case "systemd+tls":
+			return listenSpec{network: "systemd", tls: true}, nil
+		}
+		return listenSpec{network: "tcp", addr: s}, nil
+	}
+
+	switch scheme {
+	case "tcp":
+		return listenSpec{network: "tcp", addr: addr}, nil
+	case "tls", "tcp+tls", "https":
+		return listenSpec{network: "tcp", addr: addr, tls: true}, nil
+	case "unix":
+		if addr == "" {
+			return listenSpec{}, fmt.Errorf("listen %q: missing socket path", s)
+		}
+		return listenSpec{network: "unix", addr: addr}, nil
+	case "unix+tls":
+		return listenSpec{network: "unix", addr: addr, tls: true}, nil
+	default:
+		return listenSpec{}, fmt.Errorf("listen %q: unknown scheme %q", s, scheme)
+	}
+}
+
+type boundListener struct {
+	net.Listener
+	spec listenSpec
+}
+
+// openListeners binds every spec. On error, listeners already opened are
+// closed.
+func openListeners(specs []listenSpec) ([]boundListener, error) {
+	var out []boundListener
+	fail := func(err error) ([]boundListener, error) {
+		for _, l := range out {
+			l.Close()
+		}
+		return nil, err
+	}
+
+	for _, spec := range specs {
+		switch spec.network {
+		case "systemd":
+			lns, err := systemdListeners()
+			if err != nil {
+				return fail(err)
+			}
+			for _, ln := range lns {
+				out = append(out, boundListener{Listener: ln, spec: spec})
+			}
+		case "unix":
+			// A socket file left behind by a crash would make Listen fail.
+			if fi, err := os.Stat(spec.addr); err == nil && fi.Mode()&os.ModeSocket != 0 {
+				os.Remove(spec.addr)
+			}
+			ln, err := net.Listen("unix", spec.addr)
+			if err != nil {
+				return fail(err)
+			}
+			out = append(out, boundListener{Listener: ln, spec: spec})
+		default:
+			ln, err := net.Listen(spec.network, spec.addr)
+			if err != nil {
+				return fail(err)
+			}
+			out = append(out, boundListener{Listener: ln, spec: spec})
+		}
+	}
+	return out, nil
+}
+
+// systemdListeners returns the sockets passed by systemd socket
+// activation (file descriptors 3.. per the sd_listen_fds protocol).
+func systemdListeners() ([]net.Listener, error) {
+	if pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID")); pid != os.Getpid() {
+		return nil, errors.New("systemd: no sockets passed (LISTEN_PID does not match)")
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, errors.New("systemd: no sockets passed (LISTEN_FDS)")
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	// Children must not inherit the activation environment.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	const firstFD = 3
+	lns := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		name := "systemd-" + strconv.Itoa(i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(firstFD+i), name)
+		ln, err := net.FileListener(f)
+		f.Close() // FileListener dups the descriptor
+		if err != nil {
+			return nil, fmt.Errorf("systemd: fd %d: %w", firstFD+i, err)
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
+
+// serve runs srv on ln, terminating TLS for TLS listeners. It returns
+// when the listener fails or the server shuts down.
+func serve(srv *http.Server, ln boundListener) error {
+	var err error
+	if ln.spec.tls {
+		err = srv.ServeTLS(ln, "", "")
+	} else {
+		err = srv.Serve(ln)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return fmt.Errorf("%s: %w", ln.spec, err)
+}
+
+// requireTLSConfig reports specs that need TLS when none is configured.
+func requireTLSConfig(specs []listenSpec, cfg *tls.Config) error {
+	for _, s := range specs {
+		if s.tls && cfg == nil {
+			return fmt.Errorf("listen %s needs --tls-cert and --tls-key", s)
+		}
+	}
+	return nil
+}
+
+// ----------- Connection Limit -----------
+
+// connLimiter caps open connections across all listeners. Once the cap is
+// reached, Accept waits for a connection to close, so excess clients
+// queue in the kernel backlog instead of exhausting file descriptors.
+type connLimiter struct {
+	sem chan struct{}
+}
+
+func newConnLimiter(n int) *connLimiter {
+	return &connLimiter{sem: make(chan struct{}, n)}
+}
+
+func (c *connLimiter) wrap(ln boundListener) boundListener {
+	ln.Listener = &limitListener{Listener: ln.Listener, limiter: c, done: make(chan struct{})}
+	return ln
+}
+
+type limitListener struct {
+	net.Listener
+	limiter   *connLimiter
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	select {
+	case l.limiter.sem <- struct{}{}:
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+	c, err := l.Listener.Accept()
+	if err != nil {
+		<-l.limiter.sem
+		return nil, err
+	}
+	return &limitConn{Conn: c, release: func() { <-l.limiter.sem }}, nil
+}
+
+func (l *limitListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+type limitConn struct {
+	net.Conn
+	releaseOnce sync.Once
+	release     func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.releaseOnce.Do(c.release)
+	return err
+}
+
+// ----------- Protocol Listeners -----------
+
+// connServer accepts the connections of a protocol served beside HTTP,
+// such as memcached, running handle on each, and drains them on
+// shutdown. handle owns the connection until it returns, when it is
+// closed.
+type connServer struct {
+	ln     net.Listener
+	handle func(net.Conn)
+	conns  sync.WaitGroup
+
+	mu      sync.Mutex
+	open    map[net.Conn]struct{}
+	closing bool
+}
+
+func listenConns(addr string, handle func(net.Conn)) (*connServer, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &connServer{ln: ln, handle: handle, open: make(map[net.Conn]struct{})}, nil
+}
+
+// serve accepts connections until shutdown.
+func (cs *connServer) serve() error {
+	for {
+		conn, err := cs.ln.Accept()
+		if err != nil {
+			cs.mu.Lock()
+			closing := cs.closing
+			cs.mu.Unlock()
+			if closing {
+				return nil
+			}
+			return err
+		}
+		cs.mu.Lock()
+		if cs.closing {
+			cs.mu.Unlock()
+			conn.Close()
+			continue
+		}
+		cs.open[conn] = struct{}{}
+		cs.conns.Add(1)
+		cs.mu.Unlock()
+		go func() {
+			defer func() {
+				cs.mu.Lock()
+				delete(cs.open, conn)
+				cs.mu.Unlock()
+				conn.Close()
+				cs.conns.Done()
+			}()
+			cs.handle(conn)
+		}()
+	}
+}
+
+// shutdown stops accepting and lets each connection finish its current
+// request, closing those still busy when ctx ends.
+func (cs *connServer) shutdown(ctx context.Context) {
+	cs.mu.Lock()
+	cs.closing = true
+	cs.ln.Close()
+	for conn := range cs.open {
+		_ = conn.SetReadDeadline(time.Now()) // ends the wait for the next request
+	}
+	cs.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		cs.conns.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		cs.mu.Lock()
+		for conn := range cs.open {
+			conn.Close()
+		}
+		cs.mu.Unlock()
+	}
+}
@@ -0,0 +1,547 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/shubhamc1947/safemap/pkg/concurrentmap"
+)
+
+// ----------- Lists -----------
+
+// A key can hold a list of JSON values, pushed and popped at either end,
+// which makes the server a simple work queue:
+//
+//	POST /lists/{key}/rpush {"values": [{"job": 1}, "x"]}   -> {"length": 2}
+//	POST /lists/{key}/lpop?timeout=30                       -> {"value": {"job": 1}}
+//	GET  /lists/{key}/len                                   -> {"length": 1}
+//	GET  /lists/{key}/range?start=0&stop=-1                 -> {"values": ["x"]}
+//
+// /v1/{ns}/lists/{key}/... works inside a namespace. A list is a value
+// of type application/x-list+json, read and written whole as a JSON
+// array, so it expires, persists and replicates like any other. As in
+// Redis, popping the last element deletes the key. A JSON array stored
+// as another type can be pushed and popped too; its first edit makes it
+// a list.
+//
+// Its elements live in a ListMap, following the store's events, and the
+// store keeps a header (see collections.go): a push or pop changes the
+// ends of the list in place and the AOF logs only the elements pushed,
+// so both cost O(1) however long the list grows.
+//
+// A pop with a timeout blocks until an element is pushed, the timeout
+// passes or the client goes away. Blocked pops on a key are queued in a
+// ListMap and served in arrival order, one per pushed element.
+
+const listContentType = "application/x-list+json"
+
+// maxListTimeout caps how long a pop may block.
+const maxListTimeout = 5 * time.Minute
+
+var (
+	errNotList   = errors.New("the value is not a list")
+	errListEmpty = errors.New("the list is empty")
+)
+
+// listPath splits a list route, /lists/{key}/{op} or
+// /v1/{ns}/lists/{key}/{op}.
+func listPath(path string) (ns, key string, ok bool) {
+	return typedPath(path, "lists")
+}
+
+func isListPath(path string) bool {
+	_, _, ok := listPath(path)
+	return ok
+}
+
+// decodeList returns the elements of the list stored whole in v,
+// decrypted with keys, none if v is not live.
+func decodeList(v StoredValue, live bool, keys *keyring) ([]json.RawMessage, error) {
+	if !live {
+		return nil, nil
+	}
+	if v.Elements || v.Spill != "" {
+		return nil, errNotList
+	}
+	data := v.plain(keys).Data
+	var elems []json.RawMessage
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) || json.Unmarshal(data, &elems) != nil {
+		return nil, errNotList
+	}
+	return elems, nil
+}
+
+// listElemSize estimates the length of an element in the list.
+func listElemSize(elem json.RawMessage) int64 {
+	return int64(len(elem)) + 1
+}
+
+// listWaiter is a pop blocked on an empty list. wake signals it on ch.
+type listWaiter struct {
+	ch chan struct{}
+}
+
+// listIndex holds the elements of each list, by store key, and the pops
+// blocked on each key, oldest first.
+type listIndex struct {
+	elems   *concurrentmap.ListMap[string, json.RawMessage]
+	waiters *concurrentmap.ListMap[string, *listWaiter]
+	done    chan struct{}
+	keys    *keyring // decrypts whole lists; nil = no keyring
+}
+
+func newListIndex(store *concurrentmap.ConcurrentMap[string, StoredValue], keys *keyring) *listIndex {
+	l := &listIndex{
+		elems:   concurrentmap.NewStringListMap[json.RawMessage](64),
+		waiters: concurrentmap.NewStringListMap[*listWaiter](64),
+		done:    make(chan struct{}),
+		keys:    keys,
+	}
+	store.Subscribe(l.track)
+	return l
+}
+
+// track keeps the index current: it applies a header's edit, or replaces
+// the elements with those of a whole list. It runs under the store's
+// bucket lock, so it only touches the index, which has locks of its own.
+func (l *listIndex) track(ev concurrentmap.Event[string, StoredValue]) {
+	if ev.Type == concurrentmap.EventInsert || ev.Type == concurrentmap.EventUpdate {
+		if v := ev.NewValue; v.ContentType == listContentType {
+			if v.Elements {
+				if edit := editOf(ev); edit != nil {
+					l.apply(ev.Key, edit)
+				}
+				return
+			}
+			if elems, err := decodeList(v, true, l.keys); err == nil {
+				l.elems.Replace(ev.Key, elems...)
+				return
+			}
+		}
+	}
+	if ev.Type != concurrentmap.EventInsert && ev.OldValue.ContentType == listContentType {
+		l.elems.Delete(ev.Key)
+	}
+}
+
+func (l *listIndex) apply(key string, edit *collectionEdit) {
+	if edit.Reset {
+		l.elems.Delete(key)
+	}
+	for range edit.Pop {
+		if edit.Front {
+			l.elems.LPop(key)
+		} else {
+			l.elems.RPop(key)
+		}
+	}
+	if edit.Front {
+		l.elems.LPush(key, edit.Push...)
+	} else {
+		l.elems.RPush(key, edit.Push...)
+	}
+}
+
+// wake hands the oldest pop blocked on key a chance to pop.
+func (l *listIndex) wake(key string) {
+	if w, ok := l.waiters.LPop(key); ok {
+		select {
+		case w.ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// leave dequeues w, which stopped waiting. If a wake already dequeued it,
+// the wake is passed on, so it is not lost.
+func (l *listIndex) leave(key string, w *listWaiter) {
+	if l.waiters.RemoveFunc(key, func(x *listWaiter) bool { return x == w }) == 0 {
+		l.wake(key)
+	}
+}
+
+// close ends every blocked pop, on shutdown.
+func (l *listIndex) close() {
+	close(l.done)
+}
+
+// handleList routes the /lists/{key}/{op} routes (and their namespaced
+// form) to the op.
+func (s *KVServer) handleList(w http.ResponseWriter, r *http.Request) {
+	key, op := splitKeyAction(r.PathValue("key"))
+	var handler func(w http.ResponseWriter, r *http.Request, key string, ns *namespace)
+	switch {
+	case r.Method == http.MethodPost && (op == "lpush" || op == "rpush"):
+		handler = func(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+			s.handleListPush(w, r, key, ns, op == "lpush")
+		}
+	case r.Method == http.MethodPost && (op == "lpop" || op == "rpop"):
+		handler = func(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+			s.handleListPop(w, r, key, ns, op == "lpop")
+		}
+	case (r.Method == http.MethodGet || r.Method == http.MethodHead) && op == "len":
+		handler = s.handleListLen
+	case (r.Method == http.MethodGet || r.Method == http.MethodHead) && op == "range":
+		handler = s.handleListRange
+	default:
+		writeError(w, r, http.StatusNotFound, codeNotFound, "use /lists/{key}/lpush, /rpush, /lpop, /rpop, /len or /range")
+		return
+	}
+	r.SetPathValue("key", key)
+	s.keyHandler(handler)(w, r)
+}
+
+// editList starts the edit of a list replacing old, live if it was. If
+// old is a JSON array stored as another type, it also returns its
+// elements: they are not in the index, so the edit resets the list and
+// pushes what it makes of them.
+func (s *KVServer) editList(old StoredValue, live bool) (*collectionEdit, []json.RawMessage, error) {
+	var whole []json.RawMessage
+	switch {
+	case !live:
+	case old.Elements:
+		if old.ContentType != listContentType {
+			return nil, nil, errNotList
+		}
+	default:
+		elems, err := decodeList(old, true, s.valueKeys)
+		if err != nil {
+			return nil, nil, err
+		}
+		if old.ContentType != listContentType {
+			whole = elems
+		}
+	}
+	return &collectionEdit{Reset: !live || whole != nil, version: s.nextVersion()}, whole, nil
+}
+
+// LPUSH / RPUSH: POST /lists/{key}/lpush {"values": [...]} adds values to
+// the head (lpush, one by one, so the last ends up first) or tail (rpush)
+// of the list at key, creating it if missing, and returns its length. The
+// push is one atomic step, and wakes a pop blocked on the key. An
+// existing list keeps its TTL, lease and tags. Lists are not held to
+// --max-value-size; quotas and --max-memory apply.
+func (s *KVServer) handleListPush(w http.ResponseWriter, r *http.Request, key string, ns *namespace, front bool) {
+	s.metrics.TotalPuts.Add(1)
+	var req struct {
+		Values []json.RawMessage `json:"values"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidBody, "invalid body: "+err.Error())
+		return
+	}
+	if len(req.Values) == 0 {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "values must not be empty")
+		return
+	}
+
+	p, _ := principalFrom(r.Context())
+	size := int64(0)
+	for _, v := range req.Values {
+		size += listElemSize(v)
+	}
+	if cur, ok := s.store.Get(key); ok && !cur.isExpired(time.Now()) {
+		size += cur.size()
+	}
+	if serr := s.quotaError(p, ns, key, size); serr != nil {
+		serr.write(w, r)
+		return
+	}
+	if ns != nil {
+		ns.stats.puts.Add(1)
+	}
+
+	length := 0
+	_, sp := s.tracer.start(r.Context(), "store.list_push", spanKindInternal)
+	err := s.store.ComputeErr(key, func(old StoredValue, exists bool) (StoredValue, bool, error) {
+		now := time.Now()
+		live := exists && !old.isExpired(now)
+		edit, whole, err := s.editList(old, live)
+		if err != nil {
+			return old, exists, err
+		}
+		size := int64(0)
+		length = len(whole)
+		if live && whole == nil {
+			size, length = old.size(), s.lists.elems.Len(key)
+		}
+		for _, v := range req.Values {
+			size += listElemSize(v)
+		}
+		length += len(req.Values)
+		switch {
+		case whole == nil:
+			edit.Push, edit.Front = req.Values, front
+		case front:
+			edit.Push = make([]json.RawMessage, 0, length)
+			for i := len(req.Values) - 1; i >= 0; i-- {
+				edit.Push = append(edit.Push, req.Values[i])
+			}
+			edit.Push = append(edit.Push, whole...)
+		default:
+			edit.Push = append(whole, req.Values...)
+		}
+		for _, v := range whole {
+			size += listElemSize(v)
+		}
+		return s.newHeader(p, ns, listContentType, old, live, edit, size, now), true, nil
+	})
+	sp.setAttr("length", length)
+	sp.finish()
+	switch {
+	case errors.Is(err, errNotList):
+		writeError(w, r, http.StatusConflict, codeNotList, err.Error())
+		return
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	if !s.persist(w, r) {
+		return
+	}
+	s.lists.wake(key)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]int{"length": length})
+}
+
+// popList removes the head (front) or tail of the list at key and returns
+// it, and whether elements remain.
+func (s *KVServer) popList(ctx context.Context, p principal, ns *namespace, key string, front bool) (json.RawMessage, bool, error) {
+	var (
+		elem json.RawMessage
+		more bool
+	)
+	_, sp := s.tracer.start(ctx, "store.list_pop", spanKindInternal)
+	defer sp.finish()
+	err := s.store.ComputeErr(key, func(old StoredValue, exists bool) (StoredValue, bool, error) {
+		now := time.Now()
+		live := exists && !old.isExpired(now)
+		edit, whole, err := s.editList(old, live)
+		if err != nil {
+			return old, exists, err
+		}
+		n := len(whole)
+		if whole == nil && live {
+			n = s.lists.elems.Len(key)
+		}
+		if n == 0 {
+			return old, exists, errListEmpty
+		}
+		i := n - 1
+		if front {
+			i = 0
+		}
+		if whole != nil {
+			elem = whole[i]
+		} else {
+			elem = s.lists.elems.RangeList(key, i, i)[0]
+		}
+		if more = n > 1; !more {
+			return old, false, nil
+		}
+		size := old.size() - listElemSize(elem)
+		if whole == nil {
+			edit.Pop, edit.Front = 1, front
+		} else {
+			edit.Push, size = slices.Delete(whole, i, i+1), 0
+			for _, v := range edit.Push {
+				size += listElemSize(v)
+			}
+		}
+		return s.newHeader(p, ns, listContentType, old, live, edit, size, now), true, nil
+	})
+	return elem, more, err
+}
+
+// LPOP / RPOP: POST /lists/{key}/lpop?timeout=30 removes and returns the
+// head (lpop) or tail (rpop) of the list at key:
+//
+//	{"value": {"job": 1}}
+//
+// If the list is empty or missing, the pop waits up to timeout seconds
+// (0, the default, not at all, at most 300) for a push, and answers 204
+// No Content if none comes.
+func (s *KVServer) handleListPop(w http.ResponseWriter, r *http.Request, key string, ns *namespace, front bool) {
+	s.metrics.TotalPuts.Add(1)
+	var timeout time.Duration
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > int(maxListTimeout/time.Second) {
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("timeout must be 0-%d seconds", int(maxListTimeout.Seconds())))
+			return
+		}
+		timeout = time.Duration(n) * time.Second
+	}
+	if ns != nil {
+		ns.stats.puts.Add(1)
+	}
+	p, _ := principalFrom(r.Context())
+
+	var (
+		waiter *listWaiter
+		expiry <-chan time.Time
+	)
+	if timeout > 0 {
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Time{}) // blocked pops may outlive --write-timeout
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expiry = timer.C
+	}
+	for {
+		elem, more, err := s.popList(r.Context(), p, ns, key, front)
+		if !errors.Is(err, errListEmpty) {
+			if waiter != nil {
+				s.lists.leave(key, waiter)
+			}
+			switch {
+			case errors.Is(err, errNotList):
+				writeError(w, r, http.StatusConflict, codeNotList, err.Error())
+				return
+			case err != nil:
+				writeError(w, r, http.StatusInternalServerError, codeInternal, err.Error())
+				return
+			}
+			if more {
+				s.lists.wake(key)
+			}
+			if !s.persist(w, r) {
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]json.RawMessage{"value": elem})
+			return
+		}
+		if timeout == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if waiter == nil {
+			// Queue up, then look again: a push may have landed meanwhile.
+			waiter = &listWaiter{ch: make(chan struct{}, 1)}
+			s.lists.waiters.RPush(key, waiter)
+			continue
+		}
+		select {
+		case <-waiter.ch:
+			// Woken, and dequeued: requeue first in line, in case another
+			// pop takes the element.
+			s.lists.waiters.LPush(key, waiter)
+		case <-expiry:
+			s.lists.leave(key, waiter)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case <-r.Context().Done():
+			s.lists.leave(key, waiter)
+			return
+		case <-s.lists.done:
+			s.lists.leave(key, waiter)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+}
+
+// readList returns the length of the list at key, 0 if it is missing,
+// and a function returning its elements from start to stop, both
+// inclusive; negative indexes count back from the tail.
+func (s *KVServer) readList(ctx context.Context, key string, ns *namespace) (int, func(start, stop int) []json.RawMessage, error) {
+	value, ok := s.fetchValue(ctx, key, ns, false)
+	switch {
+	case !ok:
+		return 0, func(int, int) []json.RawMessage { return nil }, nil
+	case value.Elements:
+		if value.ContentType != listContentType {
+			return 0, nil, errNotList
+		}
+		return s.lists.elems.Len(key), func(start, stop int) []json.RawMessage {
+			return s.lists.elems.RangeList(key, start, stop)
+		}, nil
+	}
+	value, err := s.loadSpilled(value)
+	if err != nil {
+		return 0, nil, err
+	}
+	elems, err := decodeList(value, true, s.valueKeys)
+	if err != nil {
+		return 0, nil, err
+	}
+	n := len(elems)
+	return n, func(start, stop int) []json.RawMessage {
+		if start < 0 {
+			start = max(start+n, 0)
+		}
+		if stop < 0 {
+			stop += n
+		}
+		if stop = min(stop, n-1); start > stop {
+			return nil
+		}
+		return elems[start : stop+1]
+	}, nil
+}
+
+// writeListError writes the response to err, from readList.
+func writeListError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errNotList) {
+		writeError(w, r, http.StatusConflict, codeNotList, err.Error())
+		return
+	}
+	writeError(w, r, http.StatusInternalServerError, codeInternal, err.Error())
+}
+
+// LEN: GET /lists/{key}/len returns the length of the list at key, 0 if
+// it is missing.
+func (s *KVServer) handleListLen(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+	s.metrics.TotalGets.Add(1)
+	n, _, err := s.readList(r.Context(), key, ns)
+	if err != nil {
+		writeListError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]int{"length": n})
+}
+
+// RANGE: GET /lists/{key}/range?start=0&stop=-1 returns the elements of
+// the list at key from start to stop, both inclusive (default the whole
+// list); negative indexes count back from the tail, as in Redis.
+//
+//	{"values": [{"job": 1}, "x"]}
+func (s *KVServer) handleListRange(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+	s.metrics.TotalGets.Add(1)
+	q := r.URL.Query()
+	start, stop := 0, -1
+	for _, param := range []struct {
+		name string
+		dst  *int
+	}{{"start", &start}, {"stop", &stop}} {
+		if v := q.Get(param.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				writeError(w, r, http.StatusBadRequest, codeBadRequest, "invalid "+param.name)
+				return
+			}
+			*param.dst = n
+		}
+	}
+
+	_, elems, err := s.readList(r.Context(), key, ns)
+	if err != nil {
+		writeListError(w, r, err)
+		return
+	}
+	values := elems(start, stop)
+	if values == nil {
+		values = []json.RawMessage{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"values": values})
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ----------- Distributed Locks -----------
+
+// Locks live in the store under lockKeyPrefix+<name>, with the lease as
+// the value's TTL, so an abandoned lock expires like any key and locks are
+// persisted with the data. The fencing token is the value's version: it
+// grows with every acquisition, across restarts too.
+const (
+	lockKeyPrefix   = "__lock/"
+	defaultLockTTL  = 30 * time.Second
+	lockRequestBody = 64 << 10
+)
+
+var (
+	errLockHeld    = errors.New("lock is held")
+	errLockMissing = errors.New("lock is not held")
+	errLeaseWrong  = errors.New("lease does not hold the lock")
+)
+
+// lockRecord is a held lock as stored. The lease ID is kept hashed, like
+// API tokens.
+type lockRecord struct {
+	LeaseHash string `json:"lease_hash"`
+	Owner     string `json:"owner,omitempty"`
+}
+
+// lockJSON describes a lock in responses. LeaseID is only returned to the
+// caller that acquired it.
+type lockJSON struct {
+	Name         string    `json:"name"`
+	LeaseID      string    `json:"lease_id,omitempty"`
+	Owner        string    `json:"owner,omitempty"`
+	FencingToken uint64    `json:"fencing_token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+func newLockJSON(name string, v StoredValue) lockJSON {
+	var rec lockRecord
+	_ = json.Unmarshal(v.Data, &rec)
+	return lockJSON{Name: name, Owner: rec.Owner, FencingToken: v.Version, ExpiresAt: v.ExpiresAt}
+}
+
+// lockRequest is the body of acquire and renew.
+type lockRequest struct {
+	TTLSeconds int64  `json:"ttl_seconds"`
+	LeaseID    string `json:"lease_id"`
+	Owner      string `json:"owner"`
+}
+
+// isLocksPath reports whether path is under /locks/.
+func isLocksPath(path string) bool {
+	return strings.HasPrefix(path, "/locks/")
+}
+
+// lockHandler adapts a lock operation to the /locks/{name} routes: it
+// checks the caller may use the flat keyspace, which locks share, and
+// validates the name.
+func (s *KVServer) lockHandler(op func(w http.ResponseWriter, r *http.Request, name string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.metrics.TotalRequests.Add(1)
+		if p, _ := principalFrom(r.Context()); !p.mayUse("") {
+			writeError(w, r, http.StatusForbidden, codeForbidden, "token is limited to its namespaces")
+			return
+		}
+		name := r.PathValue("name")
+		switch {
+		case !utf8.ValidString(name) || strings.ContainsFunc(name, unicode.IsControl):
+			writeError(w, r, http.StatusBadRequest, codeInvalidKey, "lock names must be UTF-8 without control characters")
+			return
+		case s.maxKeyLength > 0 && len(name) > s.maxKeyLength:
+			writeError(w, r, http.StatusRequestEntityTooLarge, codeKeyTooLong,
+				fmt.Sprintf("lock name is %d bytes, the limit is %d", len(name), s.maxKeyLength))
+			return
+		}
+		op(w, r, name)
+	}
+}
+
+// readLockRequest decodes an optional acquire or renew body and returns
+// the lease it asks for.
+func readLockRequest(w http.ResponseWriter, r *http.Request) (lockRequest, time.Duration, bool) {
+	var req lockRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, lockRequestBody)).Decode(&req); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidBody, "invalid body: "+err.Error())
+			return req, 0, false
+		}
+	}
+	ttl, ok := ttlDuration(req.TTLSeconds)
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, ttlRangeMessage("ttl_seconds"))
+		return req, 0, false
+	}
+	if ttl == 0 {
+		ttl = defaultLockTTL
+	}
+	return req, ttl, true
+}
+
+// Locks: POST /locks/{name} {"ttl_seconds": 30, "owner": "worker-7"}
+// acquires the lock for a lease of ttl_seconds (default 30):
+//
+//	{"name": "jobs", "lease_id": "...", "fencing_token": 1729048273000012, "expires_at": "..."}
+//
+// Keep lease_id to renew or release the lock. Pass fencing_token to the
+// resources the lock guards: it is larger for every new holder, so they
+// can reject writes from a holder whose lease has lapsed. A held lock gets
+// 409 lock_held, with Retry-After set to when its lease runs out.
+func (s *KVServer) handleLockAcquire(w http.ResponseWriter, r *http.Request, name string) {
+	req, ttl, ok := readLockRequest(w, r)
+	if !ok {
+		return
+	}
+	var lease [16]byte
+	_, _ = rand.Read(lease[:])
+	leaseID := hex.EncodeToString(lease[:])
+
+	data, _ := json.Marshal(lockRecord{LeaseHash: hashToken(leaseID), Owner: req.Owner})
+	v := StoredValue{Data: data, HasTTL: true, ExpiresAt: time.Now().Add(ttl), Version: s.nextVersion()}
+	key := lockKeyPrefix + name
+	for {
+		cur, loaded := s.store.LoadOrStore(key, v)
+		if !loaded {
+			break
+		}
+		now := time.Now()
+		if !cur.isExpired(now) {
+			w.Header().Set("Retry-After", strconv.Itoa(max(1, ceilSeconds(cur.ExpiresAt.Sub(now)))))
+			writeError(w, r, http.StatusConflict, codeLockHeld, errLockHeld.Error())
+			return
+		}
+		s.store.ExpireIf(key, func(v StoredValue) bool { return v.isExpired(now) })
+	}
+	if !s.persist(w, r) {
+		return
+	}
+
+	out := newLockJSON(name, v)
+	out.LeaseID = leaseID
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+// Locks: GET /locks/{name} describes a held lock, without its lease ID.
+func (s *KVServer) handleLockGet(w http.ResponseWriter, r *http.Request, name string) {
+	v, ok := s.store.Get(lockKeyPrefix + name)
+	if !ok || v.isExpired(time.Now()) {
+		writeError(w, r, http.StatusNotFound, codeLockNotFound, errLockMissing.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(newLockJSON(name, v))
+}
+
+// Locks: POST /locks/{name}/renew {"lease_id": "...", "ttl_seconds": 30}
+// extends the lease to ttl_seconds from now. The fencing token stays the
+// same.
+func (s *KVServer) handleLockRenew(w http.ResponseWriter, r *http.Request, name string) {
+	req, ttl, ok := readLockRequest(w, r)
+	if !ok {
+		return
+	}
+	var renewed StoredValue
+	err := s.updateLock(name, req.LeaseID, func(v StoredValue) (StoredValue, bool) {
+		v.ExpiresAt = time.Now().Add(ttl)
+		renewed = v
+		return v, true
+	})
+	if !s.lockResult(w, r, err) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(newLockJSON(name, renewed))
+}
+
+// Locks: DELETE /locks/{name}?lease_id=... releases the lock, if the
+// lease still holds it.
+func (s *KVServer) handleLockRelease(w http.ResponseWriter, r *http.Request, name string) {
+	err := s.updateLock(name, r.URL.Query().Get("lease_id"), func(v StoredValue) (StoredValue, bool) {
+		return v, false
+	})
+	if !s.lockResult(w, r, err) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// updateLock applies change to lock name if leaseID holds it, in one
+// atomic step.
+func (s *KVServer) updateLock(name, leaseID string, change func(v StoredValue) (StoredValue, bool)) error {
+	if leaseID == "" {
+		return errLeaseWrong
+	}
+	return s.store.ComputeErr(lockKeyPrefix+name, func(old StoredValue, exists bool) (StoredValue, bool, error) {
+		if !exists || old.isExpired(time.Now()) {
+			return old, exists, errLockMissing
+		}
+		var rec lockRecord
+		if json.Unmarshal(old.Data, &rec) != nil || rec.LeaseHash != hashToken(leaseID) {
+			return old, exists, errLeaseWrong
+		}
+		v, keep := change(old)
+		return v, keep, nil
+	})
+}
+
+// lockResult answers a failed renew or release, or persists a successful
+// one. It reports whether the caller should write its response.
+func (s *KVServer) lockResult(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case errors.Is(err, errLockMissing):
+		writeError(w, r, http.StatusNotFound, codeLockNotFound, err.Error())
+		return false
+	case errors.Is(err, errLeaseWrong):
+		writeError(w, r, http.StatusConflict, codeLockNotHeld, err.Error())
+		return false
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, codeInternal, err.Error())
+		return false
+	}
+	return s.persist(w, r)
+}
@@ -1,18 +1,32 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
 	"encoding/json"
-	"flag"
+	"errors"
 	"fmt"
 	"io"
-	"log"
-	"net"
+	"io/fs"
+	"log/slog"
+	"math/rand/v2"
+	"mime"
 	"net/http"
+	"net/netip"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/shubhamc1947/safemap/pkg/concurrentmap"
+	"github.com/shubhamc1947/safemap/pkg/snappy"
 )
 
 // ----------- Stored Value with TTL -----------
@@ -21,12 +35,90 @@ type StoredValue struct {
 	Data      []byte
 	HasTTL    bool
 	ExpiresAt time.Time
+	Owner     string // ID of the API token that wrote it, for quotas
+
+	// ContentType is set for raw values, stored verbatim and served with
+	// this type; "" means the value came in the JSON envelope.
+	ContentType string
+
+	Version uint64 // see nextVersion; 0 for values written without one
+
+	// CreatedAt is when the key was first written, UpdatedAt when its value
+	// last was; zero for values loaded from before they were tracked.
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	// Lease is the hashed ID of the lease the key is attached to, ""
+	// for none; see leases.go.
+	Lease string
+
+	// Flags are the client flags of a value written over the memcached
+	// protocol, returned to memcached clients as they were set.
+	Flags uint32
+
+	// Tags label the key for listing and deleting by tag; see tags.go.
+	// They are set by PUT and shared by copies, so never modified.
+	Tags []string
+
+	// Spill names the file in --spill-dir holding a value too large to
+	// keep in memory, "" for one in Data; SpillSize is its length.
+	Spill     string
+	SpillSize int64
+
+	// Compressed is set when Data is Snappy-compressed; see
+	// valuecompress.go. Encrypted is set when it is then sealed; see
+	// valuecrypt.go.
+	Compressed bool
+	Encrypted  bool
+
+	// Elements marks the header of a list, set or sorted set, whose
+	// elements are kept in its index rather than in Data; ElementsSize
+	// estimates their length. Edit is the change to them the header was
+	// written with. See collections.go.
+	Elements     bool
+	ElementsSize int64
+	Edit         *collectionEdit
+
+	// SHA256 is the digest of the value, taken when it is written; nil for
+	// values loaded from before digests were kept. See digest.go.
+	SHA256 []byte
+
+	// Accesses counts reads of the key. Every copy of the value shares
+	// it, so a read counts without a store write. It is not persisted:
+	// counting restarts when the key is loaded. Nil counts nothing.
+	Accesses *atomic.Int64
+
+	// LastRead is when the key was last read, in Unix nanoseconds, 0 if
+	// never; shared and not persisted like Accesses. See eviction.go.
+	LastRead *atomic.Int64
+}
+
+func (v StoredValue) isExpired(now time.Time) bool {
+	return v.HasTTL && now.After(v.ExpiresAt)
+}
+
+// size is the length of the value, in memory or spilled, before any
+// compression or encryption; for a collection header, an estimate.
+func (v StoredValue) size() int64 {
+	switch {
+	case v.Spill != "":
+		return v.SpillSize
+	case v.Elements:
+		return v.ElementsSize
+	case v.Encrypted:
+		return sealedSize(v.Data)
+	case v.Compressed:
+		n, _ := snappy.DecodedLen(v.Data)
+		return int64(n)
+	}
+	return int64(len(v.Data))
 }
 
 // JSON request/response format
 type KVRequest struct {
-	Value      string `json:"value"`
-	TTLSeconds int64  `json:"ttl_seconds,omitempty"`
+	Value      string   `json:"value"`
+	TTLSeconds int64    `json:"ttl_seconds,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
 }
 
 type KVResponse struct {
@@ -34,6 +126,24 @@ type KVResponse struct {
 	ExpiresAt *time.Time `json:"expires_at,omitempty"`
 }
 
+// rawPutResponse acknowledges a raw value without echoing it back.
+type rawPutResponse struct {
+	ContentType string     `json:"content_type"`
+	Size        int        `json:"size"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+// isEnvelopeType reports whether a PUT body with Content-Type ct is read
+// as the JSON envelope. Form-encoded counts too: it is what curl -d sends
+// by default.
+func isEnvelopeType(ct string) bool {
+	if ct == "" {
+		return true
+	}
+	mt, _, err := mime.ParseMediaType(ct)
+	return err == nil && (mt == "application/json" || mt == "application/x-www-form-urlencoded")
+}
+
 // ----------- Metrics -----------
 
 type Metrics struct {
@@ -45,76 +155,61 @@ type Metrics struct {
 	Unauthorized       atomic.Int64
 	NotFound           atomic.Int64
 	CurrentlyStoredKey atomic.Int64 // approximate, not strict
-}
-
-// ----------- Rate Limiter -----------
-
-type clientState struct {
-	windowStart time.Time
-	count       int
-}
 
-type RateLimiter struct {
-	mu      sync.Mutex
-	clients map[string]*clientState
-	limit   int
-	window  time.Duration
+	Routes map[string]*routeMetrics // fixed set, see metricRoutes
+	Expiry expiryMetrics
 }
 
-func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
-	return &RateLimiter{
-		clients: make(map[string]*clientState),
-		limit:   limit,
-		window:  window,
-	}
+// expiryMetrics describes the TTL subsystem. Totals count since startup;
+// Last* describe the most recent scan.
+type expiryMetrics struct {
+	Scans        atomic.Int64
+	KeysScanned  atomic.Int64
+	KeysExpired  atomic.Int64 // by the scanner
+	LazyExpired  atomic.Int64 // on read
+	StaleServed  atomic.Int64 // expired values read with ?stale=true
+	LastScanned  atomic.Int64
+	LastExpired  atomic.Int64
+	LastDuration atomic.Int64 // nanoseconds
+	LastRun      atomic.Int64 // Unix nanoseconds, 0 before the first scan
 }
 
-// Allow returns true if the request from this client is allowed.
-func (rl *RateLimiter) Allow(clientID string) bool {
-	now := time.Now()
-
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	st, ok := rl.clients[clientID]
-	if !ok || now.Sub(st.windowStart) > rl.window {
-		rl.clients[clientID] = &clientState{
-			windowStart: now,
-			count:       1,
-		}
-		return true
+func (m *expiryMetrics) report() map[string]any {
+	resp := map[string]any{
+		"scans":            m.Scans.Load(),
+		"keys_scanned":     m.KeysScanned.Load(),
+		"keys_expired":     m.KeysExpired.Load(),
+		"lazy_expired":     m.LazyExpired.Load(),
+		"stale_served":     m.StaleServed.Load(),
+		"last_scanned":     m.LastScanned.Load(),
+		"last_expired":     m.LastExpired.Load(),
+		"last_duration_ms": float64(m.LastDuration.Load()) / 1e6,
 	}
-
-	if st.count >= rl.limit {
-		return false
+	if last := m.LastRun.Load(); last != 0 {
+		resp["last_run"] = time.Unix(0, last).UTC()
 	}
-
-	st.count++
-	return true
+	return resp
 }
 
-// ----------- Logging Middleware Helpers -----------
-
-type statusRecorder struct {
-	http.ResponseWriter
-	status int
-}
-
-func (r *statusRecorder) WriteHeader(code int) {
-	r.status = code
-	r.ResponseWriter.WriteHeader(code)
+func newMetrics() *Metrics {
+	m := &Metrics{Routes: make(map[string]*routeMetrics, len(metricRoutes))}
+	for _, name := range metricRoutes {
+		m.Routes[name] = new(routeMetrics)
+	}
+	return m
 }
 
-func loggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
-
-		next.ServeHTTP(rec, r)
-
-		duration := time.Since(start)
-		log.Printf("%s %s => %d (%s)", r.Method, r.URL.Path, rec.status, duration)
-	})
+// counters returns the current value of each counter by name.
+func (m *Metrics) counters() map[string]int64 {
+	return map[string]int64{
+		"total_requests": m.TotalRequests.Load(),
+		"total_gets":     m.TotalGets.Load(),
+		"total_puts":     m.TotalPuts.Load(),
+		"total_deletes":  m.TotalDeletes.Load(),
+		"rate_limited":   m.RateLimited.Load(),
+		"unauthorized":   m.Unauthorized.Load(),
+		"not_found":      m.NotFound.Load(),
+	}
 }
 
 // ----------- KV Server -----------
@@ -123,241 +218,1196 @@ type KVServer struct {
 	store           *concurrentmap.ConcurrentMap[string, StoredValue]
 	metrics         *Metrics
 	authToken       string
-	rateLimiter     *RateLimiter
+	adminToken      string // for /admin/ and /debug/; "" = authToken
+	started         time.Time
+	rateLimits      atomic.Pointer[rateLimits] // nil = unlimited; swapped on reload
+	rateCounters    *rateCounters              // of --rate-limit-backend=store
+	trustedProxies  []netip.Prefix
 	ttlScanInterval time.Duration
+	ttlSlowScan     time.Duration // log scans slower than this; 0 = never
+	defaultTTL      time.Duration // for writes without a TTL; 0 = none
+	maxTTL          time.Duration // cap on every TTL; 0 = none
+	aof             *aofLog
+	snapshots       *snapshotter
+	backups         *backups
+	tokens          *tokenRegistry
+	acl             *aclRegistry
+	aclEnforced     bool // deny key operations no ACL rule allows
+	namespaces      *namespaceRegistry
+	indexes         *indexRegistry   // JSON fields of namespaced values
+	tags            *tagIndex        // keys by tag
+	history         *versionLog      // previous values in versioned namespaces
+	leases          *leaseRegistry   // attached keys, deleted when their lease ends
+	events          *eventFeed       // changes, for watchers
+	pubsub          *pubSub          // channel subscribers
+	lists           *listIndex       // list values, and pops blocked on empty ones
+	sets            *setIndex        // members of set values
+	zsets           *zsetIndex       // sorted set values, in score order
+	webhooks        *webhookRegistry // change notifications to external URLs
+	usage           *ownerUsage      // storage per API token
+	memory          *memoryLimit     // --max-keys and --max-memory
+	config          *configReloader  // settings in effect, for /admin/config
+	spill           *spillStore      // nil = values always in memory
+	compression     *valueCompressor // nil = values stored as written
+	encryption      *valueCipher     // nil = values stored in the clear
+	valueKeys       *keyring         // decrypts values; nil = no keyring
+	versions        atomic.Uint64    // last version handed out; see nextVersion
+	maxValueSize    int64            // PUT body cap; 0 = unlimited
+	maxKeyLength    int              // 0 = unlimited
+	maxBatchOps     int              // 0 = unlimited
+	compressMinSize int              // 0 = no response compression
+	jwt             *jwtVerifier     // nil = JWTs not accepted
+	tracer          *tracer          // nil = tracing off
+	statsd          *statsdSink      // nil = no StatsD push
+
+	loaded      atomic.Bool // persisted data applied; see loadingMiddleware
+	draining    atomic.Bool // shutdown started
+	maintenance atomic.Bool // out of rotation, set via /admin/maintenance
+	readOnly    atomic.Bool // writes rejected; see readonly.go
 }
 
-// Middleware chain: auth -> rate limit -> handler
+// Middleware chain: request ID -> tracing -> metrics -> logging -> compression -> auth -> rate limit -> loading -> read-only -> ACL -> handler
 func (s *KVServer) withMiddlewares(next http.Handler) http.Handler {
 	h := http.Handler(next)
 
+	// Per-key-prefix permissions; needs the rules loaded
+	h = s.aclMiddleware(h)
+
+	// Reject writes in read-only mode
+	h = s.readOnlyMiddleware(h)
+
+	// Reject traffic until persisted data is loaded
+	h = s.loadingMiddleware(h)
+
 	// Rate limiting
 	h = s.rateLimitMiddleware(h)
 
 	// Auth
 	h = s.authMiddleware(h)
 
+	// Response compression, inside logging so it logs the status sent
+	h = s.compressMiddleware(h)
+
 	// Logging
-	h = loggingMiddleware(h)
+	h = s.loggingMiddleware(h)
+
+	// Per-route metrics
+	h = s.metricsMiddleware(h)
+
+	// Tracing, so the access log and handlers see the span
+	h = s.tracingMiddleware(h)
+
+	// Request ID, first so every log line and response carries it
+	h = requestIDMiddleware(h)
 
 	return h
 }
 
-// Auth middleware: checks X-API-Key if authToken is set
-func (s *KVServer) authMiddleware(next http.Handler) http.Handler {
-	if s.authToken == "" {
-		// No auth required
-		return next
+// isReservedKey reports whether key belongs to server-internal state,
+// which clients may not access directly.
+func isReservedKey(key string) bool {
+	return strings.HasPrefix(key, tokenKeyPrefix) || strings.HasPrefix(key, aclKeyPrefix) ||
+		strings.HasPrefix(key, nsMetaPrefix) || strings.HasPrefix(key, historyKeyPrefix) ||
+		strings.HasPrefix(key, lockKeyPrefix) || strings.HasPrefix(key, leaseKeyPrefix) ||
+		strings.HasPrefix(key, webhookKeyPrefix)
+}
+
+// apiKeyFromRequest returns the key from X-API-Key, or from
+// Authorization with an optional "Bearer " prefix. WebSocket upgrades may
+// pass it as ?access_token= instead, since browsers cannot set headers
+// on them.
+func apiKeyFromRequest(r *http.Request) string {
+	if token := r.Header.Get("X-API-Key"); token != "" {
+		return token
 	}
+	if token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); token != "" || !isWebSocketPath(r.URL.Path) {
+		return token
+	}
+	return r.URL.Query().Get("access_token")
+}
 
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Skip health & metrics for easier monitoring if you want
-		if r.URL.Path == "/healthz" {
-			next.ServeHTTP(w, r)
-			return
-		}
+// ----------- main -----------
 
-		token := r.Header.Get("X-API-Key")
-		if token == "" {
-			token = r.Header.Get("Authorization") // optional secondary header
-		}
+func main() {
+	cfg, flags, err := loadConfig(os.Args[1:])
+	if err != nil {
+		fatal("config", "err", err)
+	}
+	if err := setupLogging(cfg.LogLevel, cfg.LogFormat); err != nil {
+		fatal("logging", "err", err)
+	}
 
-		if token != s.authToken && token != "Bearer "+s.authToken {
-			s.metrics.Unauthorized.Add(1)
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
-			return
-		}
+	// SIGINT/SIGTERM cancel ctx, which stops the background workers and
+	// starts a graceful shutdown.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var workers sync.WaitGroup
+	startWorker := func(run func(ctx context.Context)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			run(ctx)
+		}()
+	}
 
-		next.ServeHTTP(w, r)
-	})
-}
+	store := concurrentmap.NewStringMap[StoredValue](cfg.Buckets)
+	metrics := newMetrics()
+	counters := newRateCounters(cfg.Buckets)
+	rlConfig, err := cfg.rateLimitConfig(counters)
+	if err != nil {
+		fatal("invalid rate limit config", "err", err)
+	}
+	rl, err := newRateLimits(rlConfig)
+	if err != nil {
+		fatal("invalid rate limit config", "err", err)
+	}
+	proxies, err := parseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		fatal("invalid --trusted-proxies", "err", err)
+	}
+	if cfg.DefaultTTL < 0 || cfg.MaxTTL < 0 {
+		fatal("--default-ttl and --max-ttl must not be negative")
+	}
+	if cfg.MaxTTL > 0 && cfg.DefaultTTL > cfg.MaxTTL {
+		fatal("--default-ttl must not exceed --max-ttl")
+	}
+	storeLimit := quota{MaxKeys: cfg.MaxKeys, MaxBytes: cfg.MaxMemory}
+	if err := storeLimit.validate(); err != nil {
+		fatal("--max-keys and --max-memory must not be negative")
+	}
+	eviction, err := parseEvictionPolicy(cfg.EvictionPolicy)
+	if err != nil {
+		fatal("config", "err", err)
+	}
 
-// Rate limit middleware: simple per-IP limiter
-func (s *KVServer) rateLimitMiddleware(next http.Handler) http.Handler {
-	if s.rateLimiter == nil {
-		return next
+	keys, err := loadKeyring(cfg.EncryptionKeyFile)
+	if err != nil {
+		fatal("load encryption keys", "err", err)
+	}
+	if keys != nil {
+		slog.Info("encryption at rest enabled", "keys", len(keys.byID))
+	}
+	if cfg.EncryptValues && keys == nil {
+		fatal("--encrypt-values requires --encryption-key-file or $" + encryptionKeysEnv)
+	}
+	if cfg.EncryptValues && cfg.SpillDir != "" {
+		fatal("--encrypt-values cannot be combined with --spill-dir: spilled values would be stored in the clear")
 	}
 
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Skip health
-		if r.URL.Path == "/healthz" {
-			next.ServeHTTP(w, r)
-			return
+	var bk *backups
+	if cfg.BackupURL != "" {
+		if cfg.SnapshotPath == "" {
+			fatal("--backup-url requires --snapshot-path")
 		}
-
-		clientIP := clientIDFromRequest(r)
-		if !s.rateLimiter.Allow(clientIP) {
-			s.metrics.RateLimited.Add(1)
-			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
-			return
+		target, err := parseBackupURL(cfg.BackupURL)
+		if err != nil {
+			fatal("invalid --backup-url", "err", err)
 		}
-
-		next.ServeHTTP(w, r)
-	})
-}
-
-// Client identifier: use remote IP
-func clientIDFromRequest(r *http.Request) string {
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
+		bk = &backups{target: target, keep: max(1, cfg.BackupKeep)}
+		slog.Info("backups enabled", "target", target.String(), "keep", bk.keep)
 	}
-	return host
-}
 
-// ----------- main -----------
+	var snapshots *snapshotter
+	if cfg.SnapshotPath != "" {
+		snapshots = &snapshotter{path: cfg.SnapshotPath, keys: keys, backups: bk}
+	}
 
-func main() {
-	// CLI flags
-	port := flag.Int("port", 8080, "Port to listen on")
-	buckets := flag.Int("buckets", 64, "Number of shards/buckets")
-	authToken := flag.String("auth-token", "", "Optional static auth token (X-API-Key / Authorization)")
-	rateLimit := flag.Int("rate-limit", 0, "Max requests per client per window (0 = disabled)")
-	rateWindow := flag.Duration("rate-window", time.Minute, "Rate limit window duration")
-	ttlScanInterval := flag.Duration("ttl-scan-interval", 5*time.Second, "TTL expiry scan interval")
-	flag.Parse()
-
-	store := concurrentmap.NewStringMap[StoredValue](*buckets)
-	metrics := &Metrics{}
-	var rl *RateLimiter
-	if *rateLimit > 0 {
-		rl = NewRateLimiter(*rateLimit, *rateWindow)
+	var aof *aofLog
+	if cfg.AOFPath != "" {
+		policy, err := parseFsyncPolicy(cfg.AOFFsync)
+		if err != nil {
+			fatal("invalid --aof-fsync", "err", err)
+		}
+		aof, err = openAOF(cfg.AOFPath, policy, keys, cfg.AOFRewriteMinSize, cfg.AOFRewritePercent)
+		if err != nil {
+			fatal("open aof", "err", err)
+		}
 	}
 
 	server := &KVServer{
 		store:           store,
 		metrics:         metrics,
-		authToken:       *authToken,
-		rateLimiter:     rl,
-		ttlScanInterval: *ttlScanInterval,
+		authToken:       cfg.AuthToken,
+		adminToken:      cfg.AdminToken,
+		started:         time.Now(),
+		tokens:          &tokenRegistry{store: store},
+		acl:             &aclRegistry{store: store},
+		namespaces:      newNamespaceRegistry(store),
+		indexes:         newIndexRegistry(store, keys),
+		tags:            newTagIndex(store),
+		lists:           newListIndex(store, keys),
+		sets:            newSetIndex(store, keys),
+		zsets:           newZSetIndex(store, keys),
+		usage:           newOwnerUsage(store),
+		memory:          newMemoryLimit(store, storeLimit, eviction),
+		maxValueSize:    cfg.MaxValueSize,
+		maxKeyLength:    cfg.MaxKeyLength,
+		maxBatchOps:     cfg.MaxBatchOps,
+		compressMinSize: cfg.CompressMinSize,
+		aclEnforced:     cfg.ACL,
+		trustedProxies:  proxies,
+		ttlScanInterval: cfg.TTLScanInterval,
+		ttlSlowScan:     cfg.TTLSlowScan,
+		defaultTTL:      cfg.DefaultTTL,
+		maxTTL:          cfg.MaxTTL,
+		aof:             aof,
+		snapshots:       snapshots,
+		backups:         bk,
+		rateCounters:    counters,
+	}
+	// Values sealed before a restart stay readable with --encrypt-values
+	// off, so the keyring decrypts whenever there is one.
+	server.valueKeys = keys
+	server.rateLimits.Store(rl)
+	server.readOnly.Store(cfg.ReadOnly)
+	if aof != nil {
+		aof.whole = server.whole
+	}
+	if snapshots != nil {
+		snapshots.whole = server.whole
+	}
+	server.history = newVersionLog(store, server.namespaces.versionsKept)
+	server.leases = newLeaseRegistry(store)
+	if cfg.WatchHistory < 0 {
+		fatal("--watch-history must not be negative")
+	}
+	server.events = newEventFeed(store, cfg.WatchHistory)
+	server.pubsub = newPubSub()
+	if cfg.WebhookRetries < 0 || cfg.WebhookTimeout <= 0 {
+		fatal("--webhook-retries must not be negative and --webhook-timeout must be positive")
+	}
+	server.webhooks = newWebhookRegistry(store, cfg.WebhookTimeout, cfg.WebhookRetries)
+	if cfg.ValueCompressMin > 0 {
+		server.compression = newValueCompressor(store, cfg.ValueCompressMin)
+	}
+	if cfg.EncryptValues {
+		server.encryption = newValueCipher(store, keys)
+	}
+	if cfg.SpillDir != "" {
+		if cfg.SpillThreshold < 0 {
+			fatal("--spill-threshold must not be negative")
+		}
+		if server.spill, err = newSpillStore(store, cfg.SpillDir, cfg.SpillThreshold); err != nil {
+			fatal("open --spill-dir", "err", err)
+		}
+	}
+
+	// The exporter outlives the workers so spans from draining requests
+	// are still sent; it stops after the HTTP server has shut down.
+	exportDone := make(chan struct{})
+	stopExport := func() {}
+	if cfg.OTLPEndpoint != "" {
+		exporter, err := newOTLPExporter(cfg.OTLPEndpoint)
+		if err != nil {
+			fatal("invalid --otlp-endpoint", "err", err)
+		}
+		server.tracer = &tracer{sampleRatio: cfg.TraceSampleRatio, exporter: exporter}
+		var exportCtx context.Context
+		exportCtx, stopExport = context.WithCancel(context.Background())
+		go func() {
+			defer close(exportDone)
+			exporter.run(exportCtx, metrics.counters)
+		}()
+		slog.Info("OpenTelemetry export enabled", "endpoint", cfg.OTLPEndpoint, "sample_ratio", cfg.TraceSampleRatio)
+	} else {
+		close(exportDone)
+	}
+
+	if cfg.JWTSecret != "" || cfg.JWTJWKSURL != "" {
+		server.jwt = &jwtVerifier{
+			issuer:         cfg.JWTIssuer,
+			audience:       cfg.JWTAudience,
+			scopeClaim:     cfg.JWTScopeClaim,
+			namespaceClaim: cfg.JWTNamespaceClaim,
+		}
+		if cfg.JWTSecret != "" {
+			server.jwt.secret = []byte(cfg.JWTSecret)
+		}
+		if cfg.JWTJWKSURL != "" {
+			server.jwt.jwks = newJWKSCache(cfg.JWTJWKSURL)
+		}
+		slog.Info("JWT auth enabled", "hmac", cfg.JWTSecret != "", "jwks", cfg.JWTJWKSURL, "issuer", cfg.JWTIssuer)
+	}
+
+	if cfg.StatsdAddr != "" {
+		sink, err := newStatsdSink(cfg.StatsdAddr, cfg.StatsdPrefix, cfg.StatsdTags)
+		if err != nil {
+			fatal("invalid --statsd-addr", "err", err)
+		}
+		server.statsd = sink
+		startWorker(func(ctx context.Context) { sink.run(ctx, server.statsdGauges) })
+		slog.Info("StatsD metrics enabled", "addr", cfg.StatsdAddr, "prefix", sink.prefix)
 	}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/kv/", server.handleKV)
+	mux.HandleFunc("/", handleNotFound)
+	for _, route := range []string{"/kv/{key...}", "/v1/{ns}/kv/{key...}"} {
+		mux.HandleFunc("GET "+route, server.handleKeyGet)
+		mux.HandleFunc("HEAD "+route, server.keyHandler(server.handleHead))
+		mux.HandleFunc("PUT "+route, server.keyHandler(server.handlePut))
+		mux.HandleFunc("DELETE "+route, server.keyHandler(server.handleDelete))
+		mux.HandleFunc("POST "+route, server.handleKeyAction)
+		mux.HandleFunc("PATCH "+route, server.handleKeyPatch)
+		mux.HandleFunc(route, allowMethods(keyMethods))
+	}
+	mux.HandleFunc("GET /kv", server.handleMultiGet)
+	mux.HandleFunc("GET /v1/{ns}/kv", server.handleMultiGet)
+	mux.HandleFunc("/kv", allowMethods("GET, HEAD, OPTIONS"))
+	mux.HandleFunc("/v1/{ns}/kv", allowMethods("GET, HEAD, OPTIONS"))
+	mux.HandleFunc("GET /keys", server.handleListKeys)
+	mux.HandleFunc("GET /v1/{ns}/keys", server.handleListKeys)
+	mux.HandleFunc("DELETE /keys", server.handleDeleteKeys)
+	mux.HandleFunc("DELETE /v1/{ns}/keys", server.handleDeleteKeys)
+	mux.HandleFunc("/keys", allowMethods("GET, HEAD, DELETE, OPTIONS"))
+	mux.HandleFunc("/v1/{ns}/keys", allowMethods("GET, HEAD, DELETE, OPTIONS"))
+	mux.HandleFunc("POST /locks/{name}", server.lockHandler(server.handleLockAcquire))
+	mux.HandleFunc("GET /locks/{name}", server.lockHandler(server.handleLockGet))
+	mux.HandleFunc("DELETE /locks/{name}", server.lockHandler(server.handleLockRelease))
+	mux.HandleFunc("/locks/{name}", allowMethods("GET, HEAD, POST, DELETE, OPTIONS"))
+	mux.HandleFunc("POST /locks/{name}/renew", server.lockHandler(server.handleLockRenew))
+	mux.HandleFunc("/locks/{name}/renew", allowMethods("POST, OPTIONS"))
+	mux.HandleFunc("POST /txn", server.handleTxn)
+	mux.HandleFunc("POST /v1/{ns}/txn", server.handleTxn)
+	mux.HandleFunc("/txn", allowMethods("POST, OPTIONS"))
+	mux.HandleFunc("/v1/{ns}/txn", allowMethods("POST, OPTIONS"))
+	mux.HandleFunc("GET /watch", server.handleWatch)
+	mux.HandleFunc("GET /v1/{ns}/watch", server.handleWatch)
+	mux.HandleFunc("/watch", allowMethods("GET, HEAD, OPTIONS"))
+	mux.HandleFunc("/v1/{ns}/watch", allowMethods("GET, HEAD, OPTIONS"))
+	mux.HandleFunc("GET /ws", server.handleWebSocket)
+	mux.HandleFunc("/ws", allowMethods("GET, OPTIONS"))
+	mux.HandleFunc("POST /publish/{channel}", server.handlePublish)
+	mux.HandleFunc("/publish/{channel}", allowMethods("POST, OPTIONS"))
+	mux.HandleFunc("GET /subscribe/{channel}", server.handleSubscribe)
+	mux.HandleFunc("/subscribe/{channel}", allowMethods("GET, HEAD, OPTIONS"))
+	mux.HandleFunc("POST /leases", server.handleLeaseGrant)
+	mux.HandleFunc("/leases", allowMethods("POST, OPTIONS"))
+	mux.HandleFunc("GET /leases/{id}", server.handleLeaseGet)
+	mux.HandleFunc("DELETE /leases/{id}", server.handleLeaseRevoke)
+	mux.HandleFunc("/leases/{id}", allowMethods("GET, HEAD, DELETE, OPTIONS"))
+	mux.HandleFunc("POST /leases/{id}/keepalive", server.handleLeaseKeepAlive)
+	mux.HandleFunc("/leases/{id}/keepalive", allowMethods("POST, OPTIONS"))
+	mux.HandleFunc("POST /kv/"+batchKey, server.handleBatch)
+	mux.HandleFunc("POST /v1/{ns}/kv/"+batchKey, server.handleBatch)
+	mux.HandleFunc("GET /v1/{ns}/usage", server.handleNamespaceUsage)
+	mux.HandleFunc("/v1/{ns}/usage", allowMethods("GET, HEAD, OPTIONS"))
+	for _, route := range []string{"/zsets/{key...}", "/v1/{ns}/zsets/{key...}"} {
+		mux.HandleFunc("GET "+route, server.handleZSet)
+		mux.HandleFunc("POST "+route, server.handleZSet)
+		mux.HandleFunc(route, allowMethods("GET, HEAD, POST, OPTIONS"))
+	}
+	for _, route := range []string{"/sets/{key...}", "/v1/{ns}/sets/{key...}"} {
+		mux.HandleFunc("GET "+route, server.handleSet)
+		mux.HandleFunc("POST "+route, server.handleSet)
+		mux.HandleFunc(route, allowMethods("GET, HEAD, POST, OPTIONS"))
+	}
+	for _, route := range []string{"/lists/{key...}", "/v1/{ns}/lists/{key...}"} {
+		mux.HandleFunc("GET "+route, server.handleList)
+		mux.HandleFunc("POST "+route, server.handleList)
+		mux.HandleFunc(route, allowMethods("GET, HEAD, POST, OPTIONS"))
+	}
+	for _, route := range []string{"/hll/{key...}", "/v1/{ns}/hll/{key...}"} {
+		mux.HandleFunc("GET "+route, server.handleHLL)
+		mux.HandleFunc("POST "+route, server.handleHLL)
+		mux.HandleFunc(route, allowMethods("GET, HEAD, POST, OPTIONS"))
+	}
+	for _, route := range []string{"/tags/{tag...}", "/v1/{ns}/tags/{tag...}"} {
+		mux.HandleFunc("GET "+route, server.handleListTag)
+		mux.HandleFunc("DELETE "+route, server.handleDeleteTag)
+		mux.HandleFunc(route, allowMethods("GET, HEAD, DELETE, OPTIONS"))
+	}
+	mux.HandleFunc("GET /v1/{ns}/query", server.handleQuery)
+	mux.HandleFunc("/v1/{ns}/query", allowMethods("GET, HEAD, OPTIONS"))
+	mux.HandleFunc("GET /usage", server.handleUsage)
+	mux.HandleFunc("/usage", allowMethods("GET, HEAD, OPTIONS"))
 	mux.HandleFunc("/healthz", handleHealth)
+	mux.HandleFunc("/livez", handleLive)
+	mux.HandleFunc("/readyz", server.handleReady)
 	mux.HandleFunc("/metrics", server.handleMetrics)
+	mux.HandleFunc("POST /admin/aof/rewrite", server.handleAOFRewrite)
+	mux.HandleFunc("/admin/aof/rewrite", allowMethods("POST, OPTIONS"))
+	mux.HandleFunc("POST /admin/snapshot", server.handleSnapshot)
+	mux.HandleFunc("/admin/snapshot", allowMethods("POST, OPTIONS"))
+	mux.HandleFunc("GET /admin/export", server.handleExport)
+	mux.HandleFunc("/admin/export", allowMethods("GET, HEAD, OPTIONS"))
+	mux.HandleFunc("GET /admin/backups", server.handleBackups)
+	mux.HandleFunc("/admin/backups", allowMethods("GET, HEAD, OPTIONS"))
+	mux.HandleFunc("POST /admin/restore", server.handleRestore)
+	mux.HandleFunc("/admin/restore", allowMethods("POST, OPTIONS"))
+	// Like pprof, runtime internals are only served behind a token.
+	if cfg.AuthToken != "" || cfg.AdminToken != "" {
+		mux.HandleFunc("GET /admin/runtime", server.handleRuntime)
+		mux.HandleFunc("/admin/runtime", allowMethods("GET, HEAD, OPTIONS"))
+		mux.HandleFunc("GET /admin/memory", server.handleMemory)
+		mux.HandleFunc("/admin/memory", allowMethods("GET, HEAD, OPTIONS"))
+	}
+	mux.HandleFunc("POST /admin/flush", server.handleFlush)
+	mux.HandleFunc("/admin/flush", allowMethods("POST, OPTIONS"))
+	mux.HandleFunc("GET /admin/config", server.handleConfig)
+	mux.HandleFunc("/admin/config", allowMethods("GET, HEAD, OPTIONS"))
+	mux.HandleFunc("GET /admin/stats", server.handleStats)
+	mux.HandleFunc("/admin/stats", allowMethods("GET, HEAD, OPTIONS"))
+	mux.HandleFunc("GET /admin/maintenance", server.handleMaintenance)
+	mux.HandleFunc("PUT /admin/maintenance", server.handleMaintenance)
+	mux.HandleFunc("DELETE /admin/maintenance", server.handleMaintenance)
+	mux.HandleFunc("/admin/maintenance", allowMethods("GET, HEAD, PUT, DELETE, OPTIONS"))
+	mux.HandleFunc("GET /admin/readonly", server.handleReadOnly)
+	mux.HandleFunc("POST /admin/readonly", server.handleReadOnly)
+	mux.HandleFunc("/admin/readonly", allowMethods("GET, HEAD, POST, OPTIONS"))
+	mux.HandleFunc("GET /admin/tokens", server.handleListTokens)
+	mux.HandleFunc("POST /admin/tokens", server.handleCreateToken)
+	mux.HandleFunc("/admin/tokens", allowMethods("GET, HEAD, POST, OPTIONS"))
+	mux.HandleFunc("PATCH /admin/tokens/{id}", server.handleSetTokenQuota)
+	mux.HandleFunc("DELETE /admin/tokens/{id}", server.handleRevokeToken)
+	mux.HandleFunc("/admin/tokens/{id}", allowMethods("PATCH, DELETE, OPTIONS"))
+	mux.HandleFunc("GET /admin/acl", server.handleListACL)
+	mux.HandleFunc("POST /admin/acl", server.handleCreateACL)
+	mux.HandleFunc("/admin/acl", allowMethods("GET, HEAD, POST, OPTIONS"))
+	mux.HandleFunc("DELETE /admin/acl/{id}", server.handleDeleteACL)
+	mux.HandleFunc("/admin/acl/{id}", allowMethods("DELETE, OPTIONS"))
+	mux.HandleFunc("GET /admin/webhooks", server.handleListWebhooks)
+	mux.HandleFunc("POST /admin/webhooks", server.handleCreateWebhook)
+	mux.HandleFunc("/admin/webhooks", allowMethods("GET, HEAD, POST, OPTIONS"))
+	mux.HandleFunc("DELETE /admin/webhooks/{id}", server.handleDeleteWebhook)
+	mux.HandleFunc("/admin/webhooks/{id}", allowMethods("DELETE, OPTIONS"))
+	mux.HandleFunc("GET /admin/namespaces", server.handleListNamespaces)
+	mux.HandleFunc("/admin/namespaces", allowMethods("GET, HEAD, OPTIONS"))
+	mux.HandleFunc("GET /admin/namespaces/{ns}", server.handleGetNamespace)
+	mux.HandleFunc("PUT /admin/namespaces/{ns}", server.handlePutNamespace)
+	mux.HandleFunc("DELETE /admin/namespaces/{ns}", server.handleDeleteNamespace)
+	mux.HandleFunc("/admin/namespaces/{ns}", allowMethods("GET, HEAD, PUT, DELETE, OPTIONS"))
+	mux.HandleFunc("POST /admin/namespaces/{ns}/flush", server.handleFlushNamespace)
+	mux.HandleFunc("/admin/namespaces/{ns}/flush", allowMethods("POST, OPTIONS"))
+	if cfg.Pprof {
+		if cfg.AuthToken == "" && cfg.AdminToken == "" {
+			fatal("--pprof requires --auth-token or --admin-token")
+		}
+		registerPprof(mux)
+		slog.Info("pprof enabled at /debug/pprof/")
+	}
 
 	handler := server.withMiddlewares(mux)
 
 	// Start TTL expiry worker
-	go server.startExpiryWorker()
+	startWorker(server.startExpiryWorker)
+	startWorker(server.webhooks.run)
+
+	reloader := &configReloader{args: os.Args[1:], fs: flags, server: server}
+	server.config = reloader
+
+	srv := &http.Server{
+		Handler:           handler,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+	if cfg.H2C {
+		// HTTP/2 over TLS is on by default; h2c adds it to plain listeners.
+		srv.Protocols = new(http.Protocols)
+		srv.Protocols.SetHTTP1(true)
+		srv.Protocols.SetHTTP2(true)
+		srv.Protocols.SetUnencryptedHTTP2(true)
+	}
+	srv.RegisterOnShutdown(server.events.close) // watches would hold up draining
+	srv.RegisterOnShutdown(server.pubsub.close)
+	srv.RegisterOnShutdown(server.lists.close) // as would blocked pops
+	if cfg.TLSCert != "" || cfg.TLSKey != "" {
+		if cfg.TLSCert == "" || cfg.TLSKey == "" {
+			fatal("--tls-cert and --tls-key must be set together")
+		}
+		certs, err := newCertReloader(cfg.TLSCert, cfg.TLSKey, cfg.TLSClientCA)
+		if err != nil {
+			fatal("tls", "err", err)
+		}
+		srv.TLSConfig = certs.config()
+		reloader.certs = certs
+		slog.Info("TLS enabled", "client_certs", cfg.TLSClientCA != "")
+	} else if cfg.TLSClientCA != "" {
+		fatal("--tls-client-ca requires --tls-cert and --tls-key")
+	}
+
+	// Without --listen, serve on --port, over TLS when it is configured.
+	listen := cfg.Listen
+	if len(listen) == 0 {
+		scheme := "tcp://"
+		if srv.TLSConfig != nil {
+			scheme = "tls://"
+		}
+		listen = listenFlag{fmt.Sprintf("%s:%d", scheme, cfg.Port)}
+	}
+	specs := make([]listenSpec, 0, len(listen))
+	for _, l := range listen {
+		spec, err := parseListenSpec(l)
+		if err != nil {
+			fatal("invalid --listen", "err", err)
+		}
+		specs = append(specs, spec)
+	}
+	if err := requireTLSConfig(specs, srv.TLSConfig); err != nil {
+		fatal("invalid --listen", "err", err)
+	}
+	listeners, err := openListeners(specs)
+	if err != nil {
+		fatal("listen", "err", err)
+	}
+	if cfg.MaxConns > 0 {
+		limiter := newConnLimiter(cfg.MaxConns)
+		for i := range listeners {
+			listeners[i] = limiter.wrap(listeners[i])
+		}
+		slog.Info("connection limit", "max", cfg.MaxConns)
+	}
 
-	addr := fmt.Sprintf(":%d", *port)
-	log.Printf("Starting KV server on %s with %d buckets\n", addr, *buckets)
+	for _, ln := range listeners {
+		slog.Info("starting KV server", "listen", ln.spec.String(), "addr", ln.Addr().String(), "buckets", cfg.Buckets)
+	}
 	if server.authToken != "" {
-		log.Printf("Auth token enabled (X-API-Key / Authorization)\n")
+		slog.Info("auth token enabled")
 	}
 	if rl != nil {
-		log.Printf("Rate limiting enabled: %d req / %s per client\n", *rateLimit, *rateWindow)
+		slog.Info("rate limiting enabled", "limit", cfg.RateLimit, "window", cfg.RateWindow,
+			"read_limit", cfg.ReadRateLimit, "write_limit", cfg.WriteRateLimit, "algorithm", cfg.RateAlgorithm)
+	}
+	if len(proxies) > 0 {
+		slog.Info("trusting forwarded client IPs", "proxies", cfg.TrustedProxies)
+	}
+	slog.Info("TTL expiry enabled", "scan_interval", server.ttlScanInterval)
+
+	// SIGHUP reloads the config file and TLS certificates.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go reloader.handleSIGHUP(hup)
+
+	errc := make(chan error, len(listeners)+3)
+	for _, ln := range listeners {
+		go func() {
+			if err := serve(srv, ln); err != nil {
+				errc <- err
+			}
+		}()
+	}
+	var memcached *memcachedServer
+	if cfg.MemcachedAddr != "" {
+		if memcached, err = server.listenMemcached(cfg.MemcachedAddr, cfg.MemcachedInsecure); err != nil {
+			fatal("listen memcached", "err", err)
+		}
+		slog.Info("memcached protocol enabled", "addr", memcached.ln.Addr().String())
+		go func() {
+			if err := memcached.serve(); err != nil {
+				errc <- err
+			}
+		}()
+	}
+	var binSrv *binaryServer
+	if cfg.BinaryAddr != "" {
+		if binSrv, err = server.listenBinary(cfg.BinaryAddr); err != nil {
+			fatal("listen binary", "err", err)
+		}
+		slog.Info("binary protocol enabled", "addr", binSrv.ln.Addr().String())
+		go func() {
+			if err := binSrv.serve(); err != nil {
+				errc <- err
+			}
+		}()
+	}
+	var grpcSrv *http.Server
+	if cfg.GRPCAddr != "" {
+		var ln boundListener
+		if grpcSrv, ln, err = server.listenGRPC(cfg.GRPCAddr, srv); err != nil {
+			fatal("listen grpc", "err", err)
+		}
+		slog.Info("gRPC API enabled", "listen", ln.spec.String(), "addr", ln.Addr().String())
+		go func() {
+			if err := serve(grpcSrv, ln); err != nil {
+				errc <- err
+			}
+		}()
 	}
-	log.Printf("TTL scan interval: %s\n", server.ttlScanInterval)
 
-	if err := http.ListenAndServe(addr, handler); err != nil {
-		log.Fatalf("server failed: %v", err)
+	// Load persisted data while already serving, so probes answer and
+	// /readyz reports "loading" until it is done.
+	loadDone := make(chan struct{})
+	go func() {
+		defer close(loadDone)
+		if snapshots != nil && aof == nil {
+			n, err := snapshots.load(store)
+			if err != nil {
+				fatal("load snapshot", "err", err)
+			}
+			slog.Info("snapshot loaded", "path", cfg.SnapshotPath, "keys", n)
+		}
+		if aof != nil {
+			n, err := aof.replay(store)
+			if err != nil {
+				fatal("replay aof", "err", err)
+			}
+			slog.Info("aof replayed", "path", cfg.AOFPath, "records", n, "keys", store.Len(), "fsync", string(aof.policy))
+			aof.follow(store)
+			startWorker(func(ctx context.Context) { aof.run(ctx, store) })
+		}
+
+		// After the AOF is following the store, so preloaded keys are logged.
+		if cfg.PreloadPath != "" {
+			n, err := preloadFile(cfg.PreloadPath, store, server.encode)
+			if err != nil {
+				fatal("preload", "path", cfg.PreloadPath, "err", err)
+			}
+			slog.Info("preloaded", "path", cfg.PreloadPath, "keys", n)
+		}
+
+		if snapshots != nil && cfg.SnapshotInterval > 0 {
+			startWorker(func(ctx context.Context) { snapshots.run(ctx, store, cfg.SnapshotInterval) })
+		}
+		if server.spill != nil {
+			server.spill.sweep(store)
+		}
+		server.acl.reload()
+		server.namespaces.reload()
+		for _, ns := range server.namespaces.list() {
+			server.defineIndexes(ns)
+		}
+		server.leases.reload()
+		server.webhooks.reload()
+		server.raiseVersions()
+		server.history.active.Store(true)
+		server.events.active.Store(true)
+		server.webhooks.active.Store(true)
+		server.loaded.Store(true)
+		slog.Info("ready")
+	}()
+
+	select {
+	case err := <-errc:
+		fatal("server failed", "err", err)
+	case <-ctx.Done():
+	}
+	stop() // a second signal kills the process immediately
+	server.draining.Store(true)
+
+	slog.Info("shutting down: draining connections", "timeout", cfg.ShutdownTimeout)
+	drainCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(drainCtx); err != nil {
+		slog.Warn("drain incomplete", "err", err)
+	}
+	if memcached != nil {
+		memcached.shutdown(drainCtx)
+	}
+	if binSrv != nil {
+		binSrv.shutdown(drainCtx)
+	}
+	if grpcSrv != nil {
+		if err := grpcSrv.Shutdown(drainCtx); err != nil {
+			slog.Warn("gRPC drain incomplete", "err", err)
+		}
 	}
+
+	// A final snapshot of a half-loaded store would lose data.
+	<-loadDone
+	workers.Wait()
+	server.flushPersistence()
+	stopExport()
+	<-exportDone
+	slog.Info("shutdown complete")
 }
 
 // ----------- TTL Expiry Worker -----------
 
-func (s *KVServer) startExpiryWorker() {
+func (s *KVServer) startExpiryWorker(ctx context.Context) {
 	ticker := time.NewTicker(s.ttlScanInterval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		now := time.Now()
-		var toDelete []string
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 
-		// Scan all keys and collect expired ones
-		s.store.Range(func(key string, value StoredValue) bool {
-			if value.HasTTL && now.After(value.ExpiresAt) {
-				toDelete = append(toDelete, key)
-			}
-			return true
-		})
+		s.expireScan()
+		if n := s.leases.expire(time.Now()); n > 0 {
+			slog.Debug("ttl: leases expired", "leases", n)
+		}
+		if n := expireRateCounters(s.rateCounters, time.Now()); n > 0 {
+			slog.Debug("ttl: rate-limit counters expired", "counters", n)
+		}
+	}
+}
+
+// Active expiry works as in Redis: rather than walk the whole store, each
+// tick samples a few keys with a TTL from every shard and removes the
+// expired ones, sampling the shard again while many of them were. Pause
+// time stays bounded however large the store grows, and lazy expiry on
+// read catches whatever the sampling has not reached yet.
+const (
+	expireSampleSize    = 20 // keys with a TTL sampled per shard per round
+	expireRepeatPercent = 10 // sample again while more than this share had expired
+	expireMaxRounds     = 16 // rounds per shard per tick
+)
 
-		// Delete outside of Range to avoid locking issues
-		for _, k := range toDelete {
-			s.store.Delete(k)
+// expireScan runs one active expiry cycle and records what it did. A
+// cycle stops early once it has used a quarter of the tick; it starts at
+// a random shard, so no shard is always the one left out.
+func (s *KVServer) expireScan() {
+	now := time.Now()
+	var (
+		scanned int64
+		expired int64
+	)
+	hasTTL := func(_ string, v StoredValue) bool { return v.HasTTL }
+	// ExpireIf re-checks under the lock so a fresh PUT isn't removed.
+	isExpired := func(v StoredValue) bool { return v.isExpired(now) }
+
+	shards := s.store.Buckets()
+	start := rand.IntN(shards)
+	for i := 0; i < shards; i++ {
+		shard := (start + i) % shards
+		for round := 0; round < expireMaxRounds; round++ {
+			sample := s.store.SampleBucket(shard, expireSampleSize, hasTTL)
+			removed := 0
+			for _, e := range sample {
+				if e.Value.isExpired(now) && s.store.ExpireIf(e.Key, isExpired) {
+					removed++
+				}
+			}
+			scanned += int64(len(sample))
+			expired += int64(removed)
+			if removed*100 <= len(sample)*expireRepeatPercent {
+				break
+			}
+		}
+		if time.Since(now) > s.ttlScanInterval/4 {
+			break
 		}
 	}
+
+	took := time.Since(now)
+	m := &s.metrics.Expiry
+	m.Scans.Add(1)
+	m.KeysScanned.Add(scanned)
+	m.KeysExpired.Add(expired)
+	m.LastScanned.Store(scanned)
+	m.LastExpired.Store(expired)
+	m.LastDuration.Store(int64(took))
+	m.LastRun.Store(now.UnixNano())
+	if s.statsd != nil {
+		s.statsd.count("ttl.scanned", scanned)
+		s.statsd.count("ttl.expired", expired)
+		s.statsd.timing("ttl.scan_duration", took)
+	}
+
+	if s.ttlSlowScan > 0 && took > s.ttlSlowScan {
+		slog.Warn("ttl: slow expiry scan", "duration", took, "scanned", scanned, "expired", expired)
+	} else if expired > 0 {
+		slog.Debug("ttl: expiry scan", "duration", took, "scanned", scanned, "expired", expired)
+	}
+}
+
+// statsdGauges reports point-in-time store gauges on each StatsD flush.
+func (s *KVServer) statsdGauges(sink *statsdSink) {
+	sink.gauge("store.keys", int64(s.store.Len()))
+	sink.gauge("runtime.goroutines", int64(runtime.NumGoroutine()))
 }
 
 // ----------- Handlers -----------
 
-func (s *KVServer) handleKV(w http.ResponseWriter, r *http.Request) {
-	s.metrics.TotalRequests.Add(1)
+// keyHandler adapts a key operation to the /kv/{key...} and
+// /v1/{ns}/kv/{key...} routes: it resolves the namespace, validates the
+// key, and calls op with the key's name in the store (ns is nil for the
+// flat keyspace).
+func (s *KVServer) keyHandler(op func(w http.ResponseWriter, r *http.Request, key string, ns *namespace)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.metrics.TotalRequests.Add(1)
+
+		p, _ := principalFrom(r.Context())
+		key, ns, serr := s.resolveKey(p, r.PathValue("ns"), r.PathValue("key"))
+		if serr != nil {
+			serr.write(w, r)
+			return
+		}
+		op(w, r, key, ns)
+	}
+}
+
+// resolveKey checks that p may use key in namespace nsName ("" for the
+// flat keyspace) and that the key is valid, and returns its name in the
+// store.
+func (s *KVServer) resolveKey(p principal, nsName, key string) (string, *namespace, *statusError) {
+	ns, serr := s.resolveNamespace(p, nsName)
+	if serr != nil {
+		return "", nil, serr
+	}
+	if ns == nil && (isReservedKey(key) || strings.HasPrefix(key, nsKeyPrefix)) {
+		return "", nil, &statusError{http.StatusForbidden, codeReservedKey, "reserved key"}
+	}
 
-	key := r.URL.Path[len("/kv/"):]
-	if key == "" {
-		http.Error(w, "missing key", http.StatusBadRequest)
-		return
+	switch {
+	case key == "":
+		return "", nil, &statusError{http.StatusBadRequest, codeMissingKey, "missing key"}
+	case !utf8.ValidString(key) || strings.ContainsFunc(key, unicode.IsControl):
+		return "", nil, &statusError{http.StatusBadRequest, codeInvalidKey, "keys must be UTF-8 without control characters"}
+	case s.maxKeyLength > 0 && len(key) > s.maxKeyLength:
+		return "", nil, &statusError{http.StatusRequestEntityTooLarge, codeKeyTooLong,
+			fmt.Sprintf("key is %d bytes, the limit is %d", len(key), s.maxKeyLength)}
 	}
 
-	switch r.Method {
-	case http.MethodPut:
-		s.metrics.TotalPuts.Add(1)
-		s.handlePutJSON(w, r, key)
-	case http.MethodGet:
-		s.metrics.TotalGets.Add(1)
-		s.handleGetJSON(w, r, key)
-	case http.MethodDelete:
-		s.metrics.TotalDeletes.Add(1)
-		s.handleDelete(w, r, key)
-	default:
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	if ns != nil {
+		key = ns.storeKey(key)
 	}
+	return key, ns, nil
 }
 
-// PUT JSON: { "value": "...", "ttl_seconds": 60 }
-func (s *KVServer) handlePutJSON(w http.ResponseWriter, r *http.Request, key string) {
-	defer r.Body.Close()
+// PUT JSON: { "value": "...", "ttl_seconds": 60, "tags": ["session"] }
+//
+// A body of any other Content-Type is stored verbatim along with its type;
+// its TTL comes from the X-TTL-Seconds header, its tags from X-Tags. If-None-Match: * or ?nx=1
+// only creates the key, answering 409 if it exists. ?lease=<id> attaches
+// the key to that lease; a PUT without it detaches the key.
+func (s *KVServer) handlePut(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+	s.metrics.TotalPuts.Add(1)
 
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "invalid body", http.StatusBadRequest)
+	cond, ok := putCond(w, r)
+	if !ok {
+		return
+	}
+	lease, ok := s.leaseParam(w, r)
+	if !ok {
+		return
+	}
+	stored, ok := s.readPutValue(w, r)
+	if !ok {
+		return
+	}
+	stored.Lease = lease
+
+	p, _ := principalFrom(r.Context())
+	if serr := s.putValue(r.Context(), p, key, ns, &stored, cond); serr != nil {
+		s.discard(stored)
+		serr.write(w, r)
+		return
+	}
+	if !s.attachLease(w, r, key, lease) || !s.persist(w, r) {
+		return
+	}
+	w.Header().Set("ETag", stored.etag())
+	setDigestHeader(w.Header(), stored, s.valueKeys)
+
+	var expiresAt *time.Time
+	if stored.HasTTL {
+		expiresAt = &stored.ExpiresAt
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	if stored.ContentType != "" {
+		_ = json.NewEncoder(w).Encode(rawPutResponse{stored.ContentType, int(stored.size()), expiresAt})
 		return
 	}
+	_ = json.NewEncoder(w).Encode(KVResponse{Value: string(stored.plain(s.valueKeys).Data), ExpiresAt: expiresAt})
+}
+
+// readValue reads a value from a PUT-style request body, writing the
+// error response if it is invalid: the JSON envelope, or a raw body kept
+// with its Content-Type and a TTL from X-TTL-Seconds. A body that does not
+// match the digest the client sent is rejected; see digest.go.
+func (s *KVServer) readValue(w http.ResponseWriter, r *http.Request) (StoredValue, bool) {
+	body, ok := s.readBody(w, r)
+	if !ok {
+		return StoredValue{}, false
+	}
 
 	var req KVRequest
 	var stored StoredValue
 
-	if json.Unmarshal(body, &req) == nil && req.Value != "" {
+	if ct := r.Header.Get("Content-Type"); !isEnvelopeType(ct) {
+		stored.Data = body
+		stored.ContentType = ct
+		if !ttlHeader(w, r, &stored) || !tagsHeader(w, r, &stored) {
+			return StoredValue{}, false
+		}
+	} else if json.Unmarshal(body, &req) == nil && req.Value != "" {
 		stored.Data = []byte(req.Value)
-		if req.TTLSeconds > 0 {
+		ttl, ok := ttlDuration(req.TTLSeconds)
+		if !ok {
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, ttlRangeMessage("ttl_seconds"))
+			return StoredValue{}, false
+		}
+		if ttl > 0 {
 			stored.HasTTL = true
-			stored.ExpiresAt = time.Now().Add(time.Duration(req.TTLSeconds) * time.Second)
+			stored.ExpiresAt = time.Now().Add(ttl)
+		}
+		var err error
+		if stored.Tags, err = checkTags(req.Tags); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
+			return StoredValue{}, false
 		}
 	} else {
 		// Fallback: treat raw body as value
 		stored.Data = body
 	}
+	return stored, true
+}
 
-	s.store.Set(key, stored)
+// readBody reads a write's request body, up to --max-value-size, writing
+// the error response if it is too large or does not match its digest.
+func (s *KVServer) readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	defer r.Body.Close()
+	want, ok := wantDigest(w, r)
+	if !ok {
+		return nil, false
+	}
+	if s.maxValueSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxValueSize)
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, r, http.StatusRequestEntityTooLarge, codeValueTooLarge,
+				fmt.Sprintf("body exceeds the %d byte limit", tooLarge.Limit))
+			return nil, false
+		}
+		writeError(w, r, http.StatusBadRequest, codeInvalidBody, "invalid body")
+		return nil, false
+	}
+	if want != nil {
+		if sum := sha256.Sum256(body); !checkDigest(w, r, want, sum[:]) {
+			return nil, false
+		}
+	}
+	return body, true
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
+// ttlHeader sets the TTL of a raw value from X-TTL-Seconds, writing the
+// error response if it is invalid.
+func ttlHeader(w http.ResponseWriter, r *http.Request, v *StoredValue) bool {
+	h := r.Header.Get("X-TTL-Seconds")
+	if h == "" {
+		return true
+	}
+	n, err := strconv.ParseInt(h, 10, 64)
+	ttl, ok := ttlDuration(n)
+	if err != nil || !ok {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, ttlRangeMessage("X-TTL-Seconds"))
+		return false
+	}
+	if ttl > 0 {
+		v.HasTTL = true
+		v.ExpiresAt = time.Now().Add(ttl)
+	}
+	return true
+}
 
-	resp := KVResponse{Value: string(stored.Data)}
-	if stored.HasTTL {
-		resp.ExpiresAt = &stored.ExpiresAt
+// prepareValue readies v to be written at key through the key API: it
+// applies the namespace's default TTL, records the owner, enforces quotas
+// and stamps a new version.
+func (s *KVServer) prepareValue(p principal, key string, ns *namespace, v *StoredValue) *statusError {
+	if ns != nil {
+		ns.stats.puts.Add(1)
 	}
-	_ = json.NewEncoder(w).Encode(resp)
+	s.applyTTLPolicy(ns, v, time.Now())
+
+	v.Owner = p.TokenID
+	if serr := s.quotaError(p, ns, key, v.size()); serr != nil {
+		return serr
+	}
+	v.Version = s.nextVersion()
+	v.stamp(time.Now())
+	s.encode(v)
+	return nil
 }
 
-// GET JSON: { "value": "...", "expires_at": "...optional..." }
-func (s *KVServer) handleGetJSON(w http.ResponseWriter, r *http.Request, key string) {
+// putValue prepares v and stores it at key, if cond holds. The caller
+// persists.
+func (s *KVServer) putValue(ctx context.Context, p principal, key string, ns *namespace, v *StoredValue, cond writeCond) *statusError {
+	if !cond.holds(s.store, key) {
+		return cond.failed()
+	}
+	if serr := s.prepareValue(p, key, ns, v); serr != nil {
+		return serr
+	}
+	_, sp := s.tracer.start(ctx, "store.set", spanKindInternal)
+	defer sp.finish()
+	switch {
+	case cond.ifMatch != "":
+		if !s.compareAndSwap(key, cond.ifMatch, v) {
+			return cond.failed()
+		}
+		return nil
+	case cond.createOnly:
+		if !s.createOnly(key, *v) {
+			return cond.failed()
+		}
+		return nil
+	}
+	s.store.Compute(key, func(old StoredValue, exists bool) (StoredValue, bool) {
+		if exists && !old.isExpired(time.Now()) {
+			v.inherit(old)
+		}
+		return *v, true
+	})
+	return nil
+}
+
+// deleteValue deletes key, only if ifMatch matches when it is set. The
+// caller persists.
+func (s *KVServer) deleteValue(ctx context.Context, key string, ns *namespace, ifMatch string) *statusError {
+	if ns != nil {
+		ns.stats.deletes.Add(1)
+	}
+	_, sp := s.tracer.start(ctx, "store.delete", spanKindInternal)
+	defer sp.finish()
+	if ifMatch != "" {
+		if !s.compareAndSwap(key, ifMatch, nil) {
+			return &statusError{http.StatusPreconditionFailed, codePreconditionFailed, "If-Match does not match the current value"}
+		}
+		return nil
+	}
+	s.store.Delete(key)
+	return nil
+}
+
+// lookup fetches a live value for GET or HEAD, writing the 404 if there
+// is none. With ?stale=true, an expired value not purged yet is returned
+// too, marked stale in the response headers; ?version=<id> fetches that
+// version instead (see lookupVersion).
+func (s *KVServer) lookup(w http.ResponseWriter, r *http.Request, key string, ns *namespace) (StoredValue, bool) {
+	if v := r.URL.Query().Get("version"); v != "" {
+		return s.lookupVersion(w, r, key, v)
+	}
+	stale, _ := strconv.ParseBool(r.URL.Query().Get("stale"))
+	value, ok := s.fetchValue(r.Context(), key, ns, stale)
+	if ok {
+		value, ok = s.whole(key, value)
+	}
+	if !ok {
+		writeError(w, r, http.StatusNotFound, codeKeyNotFound, "key not found")
+		return value, false
+	}
+	if now := time.Now(); value.isExpired(now) {
+		s.metrics.Expiry.StaleServed.Add(1)
+		w.Header().Set("Warning", `110 - "Response is Stale"`)
+		w.Header().Set("X-Stale-Seconds", strconv.Itoa(ceilSeconds(now.Sub(value.ExpiresAt))))
+	}
+	return value, true
+}
+
+// fetch returns key's live value, expiring it lazily, and counts the
+// read. A spilled value is read into Data, and a collection's elements
+// are encoded into it.
+func (s *KVServer) fetch(ctx context.Context, key string, ns *namespace) (StoredValue, bool) {
+	for retried := false; ; retried = true {
+		value, ok := s.fetchValue(ctx, key, ns, false)
+		if ok && value.Elements {
+			return s.whole(key, value)
+		}
+		if !ok || value.Spill == "" {
+			return value, ok
+		}
+		loaded, err := s.loadSpilled(value)
+		if err == nil {
+			return loaded, true
+		}
+		// A file that is gone belongs to a value replaced meanwhile.
+		if retried || !errors.Is(err, fs.ErrNotExist) {
+			slog.ErrorContext(ctx, "spill: read value", "key", key, "err", err)
+			return StoredValue{}, false
+		}
+	}
+}
+
+// fetchValue is fetch, except that with stale an expired value is
+// returned, and left in place, rather than expired.
+func (s *KVServer) fetchValue(ctx context.Context, key string, ns *namespace, stale bool) (StoredValue, bool) {
+	_, sp := s.tracer.start(ctx, "store.get", spanKindInternal)
 	value, ok := s.store.Get(key)
+	sp.setAttr("found", ok)
+	sp.finish()
+	if ns != nil {
+		ns.stats.gets.Add(1)
+		if ok && !value.isExpired(time.Now()) {
+			ns.stats.hits.Add(1)
+		} else {
+			ns.stats.misses.Add(1)
+		}
+	}
 	if !ok {
 		s.metrics.NotFound.Add(1)
-		http.Error(w, "key not found", http.StatusNotFound)
-		return
+		return StoredValue{}, false
 	}
 
 	// Check TTL (lazy expiration)
-	if value.HasTTL && time.Now().After(value.ExpiresAt) {
-		s.store.Delete(key)
+	if now := time.Now(); value.isExpired(now) && !stale {
+		if s.store.ExpireIf(key, func(v StoredValue) bool { return v.isExpired(now) }) {
+			s.metrics.Expiry.LazyExpired.Add(1)
+		}
 		s.metrics.NotFound.Add(1)
-		http.Error(w, "key not found", http.StatusNotFound)
+		return StoredValue{}, false
+	}
+	value.countAccess()
+	return value.plain(s.valueKeys), true
+}
+
+// setValueHeaders describes value in headers shared by GET and HEAD:
+// ETag, Digest, X-Value-Length (the stored size), for values with a TTL,
+// X-Expires-At and the whole seconds left in X-TTL-Seconds, and the
+// key's metadata (see setMetaHeaders).
+func setValueHeaders(h http.Header, value StoredValue, keys *keyring) {
+	setMetaHeaders(h, value)
+	h.Set("ETag", value.etag())
+	setDigestHeader(h, value, keys)
+	h.Set("X-Value-Length", strconv.FormatInt(value.size(), 10))
+	if value.HasTTL {
+		h.Set("X-Expires-At", value.ExpiresAt.UTC().Format(time.RFC3339Nano))
+		h.Set("X-TTL-Seconds", strconv.Itoa(max(0, ceilSeconds(time.Until(value.ExpiresAt)))))
+	}
+}
+
+// GET JSON: { "value": "...", "expires_at": "...optional..." }
+//
+// Raw values are returned verbatim with their Content-Type. A matching
+// If-None-Match gets 304 Not Modified without a body. With meta=true,
+// the value comes back as in bulk responses alongside its size and the
+// key's created_at, updated_at and access_count.
+func (s *KVServer) handleGet(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+	s.metrics.TotalGets.Add(1)
+	value, ok := s.lookup(w, r, key, ns)
+	if !ok {
+		return
+	}
+	var spilled *os.File
+	if value.Spill != "" {
+		if value, spilled, ok = s.openSpilled(w, r, key, ns, value); !ok {
+			return
+		}
+	}
+	if spilled != nil {
+		defer spilled.Close()
+	}
+	setValueHeaders(w.Header(), value, s.valueKeys)
+	setCacheHeaders(w.Header(), r, value)
+	if notModified(w, r, value) {
+		return
+	}
+	if withMeta, _ := strconv.ParseBool(r.URL.Query().Get("meta")); withMeta {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(newMetaJSON(value, s.valueKeys))
+		return
+	}
+	if spilled != nil {
+		// Streamed from disk, with Range support.
+		w.Header().Set("Content-Type", value.ContentType)
+		http.ServeContent(w, r, "", time.Time{}, spilled)
+		return
+	}
+	if value.ContentType != "" {
+		w.Header().Set("Content-Type", value.ContentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(value.Data)))
+		_, _ = w.Write(value.Data)
 		return
 	}
 
@@ -372,8 +1422,40 @@ func (s *KVServer) handleGetJSON(w http.ResponseWriter, r *http.Request, key str
 	_ = json.NewEncoder(w).Encode(resp)
 }
 
-func (s *KVServer) handleDelete(w http.ResponseWriter, r *http.Request, key string) {
-	s.store.Delete(key)
+// HEAD: the headers of a GET without encoding or sending the value. For
+// JSON-envelope values, X-Value-Length is the value's size; Content-Length
+// is left out, since the envelope is not built.
+func (s *KVServer) handleHead(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+	value, ok := s.lookup(w, r, key, ns)
+	if !ok {
+		return
+	}
+	setValueHeaders(w.Header(), value, s.valueKeys)
+	setCacheHeaders(w.Header(), r, value)
+	if notModified(w, r, value) {
+		return
+	}
+	if value.ContentType != "" {
+		w.Header().Set("Content-Type", value.ContentType)
+		w.Header().Set("Content-Length", strconv.FormatInt(value.size(), 10))
+		if value.Spill != "" {
+			w.Header().Set("Accept-Ranges", "bytes")
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *KVServer) handleDelete(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+	s.metrics.TotalDeletes.Add(1)
+	if serr := s.deleteValue(r.Context(), key, ns, r.Header.Get("If-Match")); serr != nil {
+		serr.write(w, r)
+		return
+	}
+	if !s.persist(w, r) {
+		return
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -387,15 +1469,25 @@ func (s *KVServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	resp := map[string]any{
-		"total_requests":     s.metrics.TotalRequests.Load(),
-		"total_gets":         s.metrics.TotalGets.Load(),
-		"total_puts":         s.metrics.TotalPuts.Load(),
-		"total_deletes":      s.metrics.TotalDeletes.Load(),
-		"rate_limited":       s.metrics.RateLimited.Load(),
-		"unauthorized":       s.metrics.Unauthorized.Load(),
-		"not_found":          s.metrics.NotFound.Load(),
 		"approx_keys_stored": "use Len() if you want exact per-scan",
 	}
+	for name, v := range s.metrics.counters() {
+		resp[name] = v
+	}
+	routes := make(map[string]any, len(s.metrics.Routes))
+	for name, m := range s.metrics.Routes {
+		routes[name] = m.report()
+	}
+	resp["routes"] = routes
+	resp["expiry"] = s.metrics.Expiry.report()
+	resp["memory"] = s.memory.report()
+	resp["webhooks"] = s.webhooks.metrics.report()
+	if s.compression != nil {
+		resp["value_compression"] = s.compression.report()
+	}
+	if s.encryption != nil {
+		resp["value_encryption"] = s.encryption.report()
+	}
 
 	_ = json.NewEncoder(w).Encode(resp)
 }
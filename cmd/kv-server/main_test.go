@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestKeyRoutes(t *testing.T) {
+	s := newTestServer(t)
+	s.maxKeyLength = 8
+
+	var got string
+	op := func(w http.ResponseWriter, r *http.Request, key string, ns *namespace) { got = key }
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", handleNotFound)
+	mux.HandleFunc("GET /kv/{key...}", s.keyHandler(op))
+	mux.HandleFunc("/kv/{key...}", allowMethods("GET, HEAD, OPTIONS"))
+
+	tests := []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodGet, "/kv/", http.StatusBadRequest},
+		{http.MethodGet, "/kv/a%01", http.StatusBadRequest},
+		{http.MethodGet, "/kv/123456789", http.StatusRequestEntityTooLarge},
+		{http.MethodGet, "/kv/" + tokenKeyPrefix + "x", http.StatusForbidden},
+		{http.MethodPost, "/kv/a", http.StatusMethodNotAllowed},
+		{http.MethodOptions, "/kv/a", http.StatusNoContent},
+		{http.MethodGet, "/nope", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := serveAs(mux, tt.method, tt.path, "")
+		if w.Code != tt.want {
+			t.Errorf("%s %s: expected %d, got %d", tt.method, tt.path, tt.want, w.Code)
+		}
+		if tt.method != http.MethodGet && w.Header().Get("Allow") != "GET, HEAD, OPTIONS" {
+			t.Errorf("%s %s: expected the Allow header, got %q", tt.method, tt.path, w.Header().Get("Allow"))
+		}
+	}
+	if got != "" {
+		t.Fatalf("expected no request to reach the handler, got key %q", got)
+	}
+
+	if w := serveAs(mux, http.MethodGet, "/kv/a/b", ""); w.Code != http.StatusOK || got != "a/b" {
+		t.Fatalf("expected key a/b to be served, got %d with key %q", w.Code, got)
+	}
+}
@@ -0,0 +1,571 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/shubhamc1947/safemap/pkg/concurrentmap"
+)
+
+// ----------- Memcached -----------
+
+// With --memcached-addr the server also speaks the memcached text
+// protocol, so apps written for memcached can use it unchanged. It serves
+// the flat keyspace: get, gets, set, add, replace, cas, delete, incr,
+// decr, touch, flush_all, stats, version, verbosity and quit.
+//
+// The protocol has no authentication. Connections act as the "memcached"
+// principal, with the read and write scopes; --acl rules can narrow it.
+// With --auth-token or JWT auth the listener is refused unless --acl is
+// on or --memcached-insecure accepts the risk. flush_all needs the admin
+// scope, which the principal only holds on a server with no tokens at
+// all, as anonymous HTTP callers do.
+const (
+	mcMaxLine     = 2048              // longest command line
+	mcMaxKey      = 250               // memcached's key length limit
+	mcMaxRelative = 30 * 24 * 60 * 60 // larger exptimes are Unix times
+)
+
+var errMCInsecure = errors.New("--memcached-addr serves clients without authentication; with --auth-token or JWT auth set, enable --acl and grant the memcached principal rules, or pass --memcached-insecure")
+
+var (
+	errMCBadFormat = errors.New("bad command line format")
+	errMCNotFound  = errors.New("not found")
+)
+
+// memcachedServer serves memcached connections.
+type memcachedServer struct {
+	*connServer
+	s *KVServer
+	p principal // what every connection acts as
+
+	connections atomic.Int64
+	gets, sets  atomic.Int64
+	hits        atomic.Int64
+}
+
+// listenMemcached serves memcached on addr; insecure allows it although
+// the HTTP API requires authentication.
+func (s *KVServer) listenMemcached(addr string, insecure bool) (*memcachedServer, error) {
+	open := s.authToken == "" && s.jwt == nil
+	if !open && !s.aclEnforced && !insecure {
+		return nil, errMCInsecure
+	}
+	m := &memcachedServer{s: s, p: principal{Name: "memcached", Scopes: []string{scopeRead, scopeWrite}}}
+	if open && s.adminToken == "" {
+		m.p.Scopes = append(m.p.Scopes, scopeAdmin)
+	}
+	cs, err := listenConns(addr, m.handle)
+	if err != nil {
+		return nil, err
+	}
+	m.connServer = cs
+	return m, nil
+}
+
+// mcConn is one client connection.
+type mcConn struct {
+	m  *memcachedServer
+	s  *KVServer
+	br *bufio.Reader
+	bw *bufio.Writer
+}
+
+func (m *memcachedServer) handle(conn net.Conn) {
+	m.connections.Add(1)
+	defer m.connections.Add(-1)
+
+	c := &mcConn{m: m, s: m.s, br: bufio.NewReaderSize(conn, mcMaxLine), bw: bufio.NewWriter(conn)}
+	for {
+		line, err := c.br.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			c.bw.WriteString("CLIENT_ERROR line too long\r\n")
+			c.bw.Flush()
+			return
+		}
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(string(line))
+		if len(fields) == 0 {
+			c.bw.WriteString("ERROR\r\n")
+			continue
+		}
+		quit, err := c.dispatch(fields)
+		if err != nil {
+			return // the connection failed mid-command
+		}
+		// Replies to pipelined commands go out together.
+		if quit || c.br.Buffered() == 0 {
+			if c.bw.Flush() != nil || quit {
+				return
+			}
+		}
+	}
+}
+
+// dispatch runs one command. It reports quit for "quit", and an error
+// when the connection failed.
+func (c *mcConn) dispatch(fields []string) (quit bool, err error) {
+	c.s.metrics.TotalRequests.Add(1)
+	cmd, args := fields[0], fields[1:]
+	if !c.s.loaded.Load() && cmd != "version" && cmd != "quit" {
+		c.bw.WriteString("SERVER_ERROR loading\r\n")
+		return false, nil
+	}
+	switch cmd {
+	case "get", "gets":
+		c.get(args, cmd == "gets")
+	case "set", "add", "replace", "cas":
+		return false, c.store(cmd, args)
+	case "delete":
+		c.delete(args)
+	case "incr", "decr":
+		c.incr(args, cmd == "decr")
+	case "touch":
+		c.touch(args)
+	case "flush_all":
+		c.flushAll(args)
+	case "stats":
+		c.stats(args)
+	case "version":
+		c.bw.WriteString("VERSION safemap\r\n")
+	case "verbosity":
+		c.reply(noreply(args), "OK")
+	case "quit":
+		return true, nil
+	default:
+		c.bw.WriteString("ERROR\r\n")
+	}
+	return false, nil
+}
+
+// noreply reports whether args end in "noreply", asking for no answer.
+func noreply(args []string) bool {
+	return len(args) > 0 && args[len(args)-1] == "noreply"
+}
+
+func (c *mcConn) reply(quiet bool, msg string) {
+	if !quiet {
+		c.bw.WriteString(msg + "\r\n")
+	}
+}
+
+func (c *mcConn) clientError(msg string) {
+	c.bw.WriteString("CLIENT_ERROR " + msg + "\r\n")
+}
+
+// checkKey validates key and checks that the connection may op on it.
+func (c *mcConn) checkKey(key, op string) error {
+	if len(key) > mcMaxKey {
+		return errors.New("key too long")
+	}
+	if _, _, serr := c.s.resolveKey(c.m.p, "", key); serr != nil {
+		return errors.New(serr.message)
+	}
+	if c.s.aclEnforced && !c.s.acl.allowed(c.m.p.Name, key, op) {
+		return errors.New("no ACL rule allows " + op + " on this key")
+	}
+	if op == scopeWrite && c.s.readOnly.Load() {
+		return errors.New(readOnlyError().message)
+	}
+	return nil
+}
+
+// mcExpiry converts an exptime: 0 for none, seconds from now up to 30
+// days, a Unix time beyond that, and negative for already expired.
+func mcExpiry(exptime int64, now time.Time) (hasTTL bool, expiresAt time.Time) {
+	switch {
+	case exptime == 0:
+		return false, time.Time{}
+	case exptime < 0:
+		return true, now.Add(-time.Second)
+	case exptime <= mcMaxRelative:
+		return true, now.Add(time.Duration(exptime) * time.Second)
+	default:
+		return true, time.Unix(exptime, 0)
+	}
+}
+
+// get answers get/gets key...: a VALUE line and data block per live key
+// (with its version as the cas unique for gets), then END.
+func (c *mcConn) get(keys []string, withCAS bool) {
+	if len(keys) == 0 {
+		c.bw.WriteString("ERROR\r\n")
+		return
+	}
+	for _, key := range keys {
+		if err := c.checkKey(key, scopeRead); err != nil {
+			c.clientError(err.Error())
+			return
+		}
+	}
+	for _, key := range keys {
+		c.s.metrics.TotalGets.Add(1)
+		c.m.gets.Add(1)
+		v, ok := c.s.fetch(context.Background(), key, nil)
+		if !ok {
+			continue
+		}
+		c.m.hits.Add(1)
+		if withCAS {
+			fmt.Fprintf(c.bw, "VALUE %s %d %d %d\r\n", key, v.Flags, len(v.Data), v.Version)
+		} else {
+			fmt.Fprintf(c.bw, "VALUE %s %d %d\r\n", key, v.Flags, len(v.Data))
+		}
+		c.bw.Write(v.Data)
+		c.bw.WriteString("\r\n")
+	}
+	c.bw.WriteString("END\r\n")
+}
+
+// store runs set, add, replace and cas:
+//
+//	<cmd> <key> <flags> <exptime> <bytes> [<cas unique>] [noreply]
+//
+// followed by the data block. It returns an error only when the data
+// block cannot be read.
+func (c *mcConn) store(cmd string, args []string) error {
+	quiet := noreply(args)
+	if quiet {
+		args = args[:len(args)-1]
+	}
+	want := 4
+	if cmd == "cas" {
+		want = 5
+	}
+	if len(args) != want {
+		c.bw.WriteString("ERROR\r\n")
+		return nil
+	}
+	key := args[0]
+	flags, ferr := strconv.ParseUint(args[1], 10, 32)
+	exptime, eerr := strconv.ParseInt(args[2], 10, 64)
+	size, serr := strconv.ParseInt(args[3], 10, 32)
+	var casUnique uint64
+	var cerr error
+	if cmd == "cas" {
+		casUnique, cerr = strconv.ParseUint(args[4], 10, 64)
+	}
+	if ferr != nil || eerr != nil || serr != nil || cerr != nil || size < 0 {
+		c.clientError(errMCBadFormat.Error())
+		return nil
+	}
+
+	c.s.metrics.TotalPuts.Add(1)
+	c.m.sets.Add(1)
+	if c.s.maxValueSize > 0 && size > c.s.maxValueSize {
+		if _, err := c.br.Discard(int(size) + 2); err != nil {
+			return err
+		}
+		c.reply(quiet, "SERVER_ERROR object too large for cache")
+		return nil
+	}
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(c.br, data); err != nil {
+		return err
+	}
+	if string(data[size:]) != "\r\n" {
+		c.clientError("bad data chunk")
+		return nil
+	}
+	data = data[:size]
+	if err := c.checkKey(key, scopeWrite); err != nil {
+		c.clientError(err.Error())
+		return nil
+	}
+
+	// Values that are not text are kept raw, so GET /kv/{key} serves them
+	// as bytes rather than in the JSON envelope.
+	v := StoredValue{Data: data, Flags: uint32(flags)}
+	if !utf8.Valid(data) {
+		v.ContentType = "application/octet-stream"
+	}
+	v.HasTTL, v.ExpiresAt = mcExpiry(exptime, time.Now())
+	var cond writeCond
+	switch cmd {
+	case "add":
+		cond.createOnly = true
+	case "replace":
+		cond.ifMatch = "*"
+	case "cas":
+		cond.ifMatch = `"` + strconv.FormatUint(casUnique, 10) + `"`
+	}
+	ctx := context.Background()
+	if serr := c.s.putValue(ctx, c.m.p, key, nil, &v, cond); serr != nil {
+		switch {
+		case serr.code == codeKeyExists, serr.code == codePreconditionFailed && cmd == "replace":
+			c.reply(quiet, "NOT_STORED")
+		case serr.code == codePreconditionFailed:
+			if _, ok := c.s.store.Get(key); ok {
+				c.reply(quiet, "EXISTS")
+			} else {
+				c.reply(quiet, "NOT_FOUND")
+			}
+		default:
+			c.reply(quiet, "SERVER_ERROR "+serr.message)
+		}
+		return nil
+	}
+	if c.s.commitWrites(ctx) != nil {
+		c.reply(quiet, "SERVER_ERROR persistence failed")
+		return nil
+	}
+	c.reply(quiet, "STORED")
+	return nil
+}
+
+// delete answers delete <key> [0] [noreply].
+func (c *mcConn) delete(args []string) {
+	quiet := noreply(args)
+	if quiet {
+		args = args[:len(args)-1]
+	}
+	if len(args) == 2 && args[1] == "0" {
+		args = args[:1] // a legacy zero hold time
+	}
+	if len(args) != 1 {
+		c.clientError(errMCBadFormat.Error())
+		return
+	}
+	if err := c.checkKey(args[0], scopeWrite); err != nil {
+		c.clientError(err.Error())
+		return
+	}
+	c.s.metrics.TotalDeletes.Add(1)
+	if !c.s.compareAndSwap(args[0], "*", nil) {
+		c.s.metrics.NotFound.Add(1)
+		c.reply(quiet, "NOT_FOUND")
+		return
+	}
+	if c.s.commitWrites(context.Background()) != nil {
+		c.reply(quiet, "SERVER_ERROR persistence failed")
+		return
+	}
+	c.reply(quiet, "DELETED")
+}
+
+// incr answers incr/decr <key> <value> [noreply]. As in memcached, the
+// key must hold a decimal unsigned 64-bit integer; incr wraps around and
+// decr stops at 0.
+func (c *mcConn) incr(args []string, decr bool) {
+	quiet := noreply(args)
+	if quiet {
+		args = args[:len(args)-1]
+	}
+	if len(args) != 2 {
+		c.bw.WriteString("ERROR\r\n")
+		return
+	}
+	key := args[0]
+	delta, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		c.clientError("invalid numeric delta argument")
+		return
+	}
+	if err := c.checkKey(key, scopeWrite); err != nil {
+		c.clientError(err.Error())
+		return
+	}
+	c.s.metrics.TotalPuts.Add(1)
+	if serr := c.s.quotaError(c.m.p, nil, key, counterMaxLen); serr != nil {
+		c.reply(quiet, "SERVER_ERROR "+serr.message)
+		return
+	}
+
+	var result uint64
+	version := c.s.nextVersion()
+	err = c.s.store.ComputeErr(key, func(old StoredValue, exists bool) (StoredValue, bool, error) {
+		now := time.Now()
+		if !exists || old.isExpired(now) {
+			return old, exists, errMCNotFound
+		}
+		cur := old.plain(c.s.valueKeys)
+		n, err := strconv.ParseUint(string(cur.Data), 10, 64)
+		if err != nil {
+			return old, exists, errNotInteger
+		}
+		switch {
+		case !decr:
+			n += delta
+		case delta > n:
+			n = 0
+		default:
+			n -= delta
+		}
+		v := cur
+		v.Data, v.Version = strconv.AppendUint(nil, n, 10), version
+		v.stamp(now)
+		v.inherit(old)
+		c.s.encode(&v)
+		result = n
+		return v, true, nil
+	})
+	switch {
+	case errors.Is(err, errMCNotFound):
+		c.s.metrics.NotFound.Add(1)
+		c.reply(quiet, "NOT_FOUND")
+		return
+	case errors.Is(err, errNotInteger):
+		c.clientError("cannot increment or decrement non-numeric value")
+		return
+	case err != nil:
+		c.reply(quiet, "SERVER_ERROR "+err.Error())
+		return
+	}
+	if c.s.commitWrites(context.Background()) != nil {
+		c.reply(quiet, "SERVER_ERROR persistence failed")
+		return
+	}
+	c.reply(quiet, strconv.FormatUint(result, 10))
+}
+
+// touch answers touch <key> <exptime> [noreply], changing only the TTL.
+func (c *mcConn) touch(args []string) {
+	quiet := noreply(args)
+	if quiet {
+		args = args[:len(args)-1]
+	}
+	if len(args) != 2 {
+		c.bw.WriteString("ERROR\r\n")
+		return
+	}
+	exptime, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		c.clientError("invalid exptime argument")
+		return
+	}
+	if err := c.checkKey(args[0], scopeWrite); err != nil {
+		c.clientError(err.Error())
+		return
+	}
+	err = c.s.store.ComputeErr(args[0], func(old StoredValue, exists bool) (StoredValue, bool, error) {
+		now := time.Now()
+		if !exists || old.isExpired(now) {
+			return old, exists, errMCNotFound
+		}
+		v := old
+		v.HasTTL, v.ExpiresAt = mcExpiry(exptime, now)
+		c.s.capTTL(&v, now)
+		return v, true, nil
+	})
+	switch {
+	case errors.Is(err, errMCNotFound):
+		c.s.metrics.NotFound.Add(1)
+		c.reply(quiet, "NOT_FOUND")
+		return
+	case err != nil:
+		c.reply(quiet, "SERVER_ERROR "+err.Error())
+		return
+	}
+	if c.s.commitWrites(context.Background()) != nil {
+		c.reply(quiet, "SERVER_ERROR persistence failed")
+		return
+	}
+	c.reply(quiet, "TOUCHED")
+}
+
+// flushAll answers flush_all [delay] [noreply]: it deletes every key of
+// the flat keyspace the connection may write, now or after delay seconds
+// (up to 30 days). Namespaces are left alone. It needs the admin scope.
+func (c *mcConn) flushAll(args []string) {
+	quiet := noreply(args)
+	if quiet {
+		args = args[:len(args)-1]
+	}
+	var delay int64
+	if len(args) > 1 {
+		c.bw.WriteString("ERROR\r\n")
+		return
+	}
+	if len(args) == 1 {
+		var err error
+		if delay, err = strconv.ParseInt(args[0], 10, 64); err != nil || delay < 0 || delay > mcMaxRelative {
+			c.clientError(errMCBadFormat.Error())
+			return
+		}
+	}
+	if !c.m.p.has(scopeAdmin) {
+		c.clientError("flush_all needs the admin scope")
+		return
+	}
+	if c.s.readOnly.Load() {
+		c.reply(quiet, "SERVER_ERROR "+readOnlyError().message)
+		return
+	}
+	if delay > 0 {
+		time.AfterFunc(time.Duration(delay)*time.Second, func() {
+			n := c.s.flushFlatKeyspace(c.m.p)
+			_ = c.s.commitWrites(context.Background())
+			slog.Info("memcached: flush_all", "keys", n)
+		})
+		c.reply(quiet, "OK")
+		return
+	}
+	n := c.s.flushFlatKeyspace(c.m.p)
+	slog.Info("memcached: flush_all", "keys", n)
+	if c.s.commitWrites(context.Background()) != nil {
+		c.reply(quiet, "SERVER_ERROR persistence failed")
+		return
+	}
+	c.reply(quiet, "OK")
+}
+
+// flushFlatKeyspace deletes the live keys outside namespaces that p may
+// write, and returns how many.
+func (s *KVServer) flushFlatKeyspace(p principal) int {
+	deleted := 0
+	for cur := (concurrentmap.Cursor[string]{}); ; {
+		var page []concurrentmap.Entry[string, StoredValue]
+		page, cur = concurrentmap.Scan(s.store, cur, maxListLimit, keyFilter(nil, "", time.Now()))
+		for _, e := range page {
+			if s.aclEnforced && !p.has(scopeAdmin) && !s.acl.allowed(p.Name, e.Key, scopeWrite) {
+				continue
+			}
+			if s.store.DeleteErr(e.Key) == nil {
+				deleted++
+			}
+		}
+		if cur == (concurrentmap.Cursor[string]{}) {
+			return deleted
+		}
+	}
+}
+
+// stats answers the general stats; no sub-statistics are kept.
+func (c *mcConn) stats(args []string) {
+	if len(args) > 0 {
+		c.bw.WriteString("END\r\n")
+		return
+	}
+	now := time.Now()
+	for _, st := range []struct {
+		name  string
+		value any
+	}{
+		{"pid", os.Getpid()},
+		{"uptime", int64(now.Sub(c.s.started).Seconds())},
+		{"time", now.Unix()},
+		{"version", "safemap"},
+		{"curr_connections", c.m.connections.Load()},
+		{"curr_items", c.s.store.Len()},
+		{"cmd_get", c.m.gets.Load()},
+		{"cmd_set", c.m.sets.Load()},
+		{"get_hits", c.m.hits.Load()},
+		{"get_misses", c.m.gets.Load() - c.m.hits.Load()},
+	} {
+		fmt.Fprintf(c.bw, "STAT %s %v\r\n", st.name, st.value)
+	}
+	c.bw.WriteString("END\r\n")
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"slices"
+	"strconv"
+)
+
+// ----------- Memory Usage -----------
+
+const (
+	defaultMemoryTop = 10
+	maxMemoryTop     = 100
+)
+
+// keyFootprint is one of the largest keys in GET /admin/memory.
+type keyFootprint struct {
+	Key       string `json:"key"`
+	Namespace string `json:"namespace,omitempty"`
+	Internal  bool   `json:"internal,omitempty"` // server state, not client data
+	Bytes     int64  `json:"bytes"`
+}
+
+// memoryUsage adds up the estimated size of keys and values (see
+// footprint) by keyspace, keeping the top largest keys.
+type memoryUsage struct {
+	keyspace   map[string]int64 // keys and bytes of the flat keyspace
+	internal   map[string]int64 // of server-internal keys
+	namespaces map[string]map[string]int64
+	largest    []keyFootprint // largest first
+	top        int
+}
+
+func newMemoryUsage(top int) *memoryUsage {
+	return &memoryUsage{
+		keyspace:   map[string]int64{"keys": 0, "bytes": 0},
+		internal:   map[string]int64{"keys": 0, "bytes": 0},
+		namespaces: map[string]map[string]int64{},
+		largest:    make([]keyFootprint, 0, top),
+		top:        top,
+	}
+}
+
+func (u *memoryUsage) add(storeKey string, v StoredValue) {
+	kf := keyFootprint{Key: storeKey, Bytes: footprint(storeKey, v)}
+	totals := u.keyspace
+	switch ns, key, ok := splitStoreKey(storeKey); {
+	case ok:
+		kf.Namespace, kf.Key = ns, key
+		if totals = u.namespaces[ns]; totals == nil {
+			totals = map[string]int64{"keys": 0, "bytes": 0}
+			u.namespaces[ns] = totals
+		}
+	case isReservedKey(storeKey):
+		kf.Internal, totals = true, u.internal
+	}
+	totals["keys"]++
+	totals["bytes"] += kf.Bytes
+
+	if len(u.largest) == u.top && kf.Bytes <= u.largest[u.top-1].Bytes {
+		return
+	}
+	i, _ := slices.BinarySearchFunc(u.largest, kf.Bytes, func(e keyFootprint, b int64) int {
+		// Descending, and after equal sizes so earlier keys stay ahead.
+		if e.Bytes >= b {
+			return -1
+		}
+		return 1
+	})
+	u.largest = slices.Insert(u.largest, i, kf)
+	if len(u.largest) > u.top {
+		u.largest = u.largest[:u.top]
+	}
+}
+
+// Admin: GET /admin/memory?top=10 estimates the memory held by keys and
+// values, in total and by keyspace, lists the largest keys and reports
+// the Go heap. Sizes are key plus value lengths, as for --max-memory; the
+// heap shows what the process really uses. It walks the whole store.
+func (s *KVServer) handleMemory(w http.ResponseWriter, r *http.Request) {
+	top := defaultMemoryTop
+	if v := r.URL.Query().Get("top"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxMemoryTop {
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, "top must be 1-"+strconv.Itoa(maxMemoryTop))
+			return
+		}
+		top = n
+	}
+
+	u := newMemoryUsage(top)
+	s.store.Range(func(key string, v StoredValue) bool {
+		u.add(key, v)
+		return true
+	})
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"store":      s.memory.report(),
+		"keyspace":   u.keyspace,
+		"internal":   u.internal,
+		"namespaces": u.namespaces,
+		"largest":    u.largest,
+		"heap": map[string]uint64{
+			"alloc_bytes":    ms.HeapAlloc,
+			"inuse_bytes":    ms.HeapInuse,
+			"sys_bytes":      ms.HeapSys,
+			"idle_bytes":     ms.HeapIdle,
+			"released_bytes": ms.HeapReleased,
+			"objects":        ms.HeapObjects,
+			"next_gc_bytes":  ms.NextGC,
+		},
+		"process_sys_bytes": ms.Sys,
+	})
+}
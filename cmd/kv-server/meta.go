@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ----------- Key Metadata -----------
+
+// stamp marks v as a new key's first value, written at now.
+func (v *StoredValue) stamp(now time.Time) {
+	v.CreatedAt, v.UpdatedAt = now, now
+	v.Accesses, v.LastRead = new(atomic.Int64), new(atomic.Int64)
+}
+
+// inherit carries the creation time and access history of old, the live
+// value v replaces, over to v: both describe the key, not one value.
+func (v *StoredValue) inherit(old StoredValue) {
+	v.CreatedAt, v.Accesses, v.LastRead = old.CreatedAt, old.Accesses, old.LastRead
+	if v.Accesses == nil {
+		v.Accesses = new(atomic.Int64)
+	}
+	if v.LastRead == nil {
+		v.LastRead = new(atomic.Int64)
+	}
+}
+
+// countAccess records a read of v.
+func (v StoredValue) countAccess() {
+	if v.Accesses != nil {
+		v.Accesses.Add(1)
+	}
+	if v.LastRead != nil {
+		v.LastRead.Store(time.Now().UnixNano())
+	}
+}
+
+// accessCount returns the reads of v's key since it was created or loaded.
+func (v StoredValue) accessCount() int64 {
+	if v.Accesses == nil {
+		return 0
+	}
+	return v.Accesses.Load()
+}
+
+// setMetaHeaders describes the key's history in GET and HEAD headers:
+// X-Created-At and X-Updated-At, when known, and X-Access-Count.
+func setMetaHeaders(h http.Header, v StoredValue) {
+	if !v.CreatedAt.IsZero() {
+		h.Set("X-Created-At", v.CreatedAt.UTC().Format(time.RFC3339Nano))
+	}
+	if !v.UpdatedAt.IsZero() {
+		h.Set("X-Updated-At", v.UpdatedAt.UTC().Format(time.RFC3339Nano))
+	}
+	h.Set("X-Access-Count", strconv.FormatInt(v.accessCount(), 10))
+}
+
+// metaJSON is the body of GET /kv/{key}?meta=true: the value as in bulk
+// responses, plus its size, its SHA-256 in hex and the key's history.
+type metaJSON struct {
+	valueJSON
+	Size        int        `json:"size"`
+	SHA256      string     `json:"sha256,omitempty"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+	AccessCount int64      `json:"access_count"`
+	Tags        []string   `json:"tags,omitempty"`
+}
+
+func newMetaJSON(v StoredValue, keys *keyring) metaJSON {
+	out := metaJSON{valueJSON: newValueJSON(v, keys), Size: int(v.size()), SHA256: hex.EncodeToString(v.digest(keys)), AccessCount: v.accessCount(), Tags: v.Tags}
+	if !v.CreatedAt.IsZero() {
+		out.CreatedAt = &v.CreatedAt
+	}
+	if !v.UpdatedAt.IsZero() {
+		out.UpdatedAt = &v.UpdatedAt
+	}
+	return out
+}
+
+// toUnixNano and fromUnixNano persist metadata times, 0 standing for the
+// zero time.
+func toUnixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ----------- Per-route Metrics -----------
+
+// metricRoutes are the route names requests are counted under.
+var metricRoutes = []string{
+	"kv_get", "kv_head", "kv_put", "kv_delete", "kv_batch", "kv_mget", "kv_keys", "kv_other", "lists", "txn", "watch", "pubsub", "locks", "leases",
+	"metrics", "health", "admin", "other",
+}
+
+// routeName classifies r for metrics.
+func routeName(r *http.Request) string {
+	switch p := r.URL.Path; {
+	case isBatchRequest(r):
+		return "kv_batch"
+	case isMultiGetPath(p):
+		return "kv_mget"
+	case isKeysPath(p) || isTagsPath(p):
+		return "kv_keys"
+	case isKVPath(p):
+		switch r.Method {
+		case http.MethodGet:
+			return "kv_get"
+		case http.MethodHead:
+			return "kv_head"
+		case http.MethodPut:
+			return "kv_put"
+		case http.MethodDelete:
+			return "kv_delete"
+		}
+		return "kv_other"
+	case isHLLPath(p) || isSetPath(p) || isZSetPath(p):
+		return "kv_other"
+	case isListPath(p):
+		return "lists" // blocked pops would skew kv_other
+	case isTxnPath(p):
+		return "txn"
+	case isWatchPath(p) || isWebSocketPath(p):
+		return "watch"
+	case isPubSubPath(p):
+		return "pubsub"
+	case isLocksPath(p):
+		return "locks"
+	case isLeasesPath(p):
+		return "leases"
+	case p == "/metrics":
+		return "metrics"
+	case isProbePath(p):
+		return "health"
+	case strings.HasPrefix(p, "/admin/"):
+		return "admin"
+	}
+	return "other"
+}
+
+// routeMetrics counts one route's requests by status class and keeps a
+// latency histogram. All fields are updated atomically.
+type routeMetrics struct {
+	count    atomic.Int64
+	statuses [5]atomic.Int64 // 1xx..5xx
+	latency  latencyHistogram
+}
+
+func (m *routeMetrics) observe(status int, d time.Duration) {
+	m.count.Add(1)
+	if class := status/100 - 1; class >= 0 && class < len(m.statuses) {
+		m.statuses[class].Add(1)
+	}
+	m.latency.observe(d)
+}
+
+func (m *routeMetrics) report() map[string]any {
+	statuses := make(map[string]int64, len(m.statuses))
+	for i := range m.statuses {
+		if n := m.statuses[i].Load(); n > 0 {
+			statuses[string(rune('1'+i))+"xx"] = n
+		}
+	}
+	return map[string]any{
+		"count":  m.count.Load(),
+		"status": statuses,
+		"latency_ms": map[string]float64{
+			"p50": m.latency.quantile(0.50),
+			"p90": m.latency.quantile(0.90),
+			"p99": m.latency.quantile(0.99),
+		},
+	}
+}
+
+// latencyHistogram buckets durations in microseconds: exact below 4µs,
+// then four linear sub-buckets per power of two, so a quantile is within
+// 25% of the true value. It covers up to about 2^40µs.
+type latencyHistogram struct {
+	buckets [histBuckets]atomic.Int64
+}
+
+const histBuckets = 4 * 40
+
+func histIndex(d time.Duration) int {
+	us := uint64(max(d.Microseconds(), 0))
+	if us < 4 {
+		return int(us)
+	}
+	e := bits.Len64(us) - 1 // us is in [2^e, 2^(e+1))
+	idx := 4*(e-1) + int(us>>(e-2)&3)
+	return min(idx, histBuckets-1)
+}
+
+// histUpper returns the exclusive upper bound of bucket i in µs.
+func histUpper(i int) uint64 {
+	if i < 4 {
+		return uint64(i + 1)
+	}
+	e, sub := i/4+1, uint64(i%4)
+	return (5 + sub) << (e - 2)
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	h.buckets[histIndex(d)].Add(1)
+}
+
+// quantile returns the upper bound, in milliseconds, of the bucket that
+// holds the q-quantile, or 0 with no observations.
+func (h *latencyHistogram) quantile(q float64) float64 {
+	var counts [histBuckets]int64
+	var total int64
+	for i := range h.buckets {
+		counts[i] = h.buckets[i].Load()
+		total += counts[i]
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := int64(q*float64(total-1)) + 1
+	var seen int64
+	for i, n := range counts {
+		if seen += n; seen >= rank {
+			return float64(histUpper(i)) / 1000
+		}
+	}
+	return float64(histUpper(histBuckets-1)) / 1000
+}
+
+// metricsMiddleware records every request under its route, including
+// those rejected by auth or rate limiting.
+func (s *KVServer) metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rec, r)
+
+		route, took := routeName(r), time.Since(start)
+		s.metrics.Routes[route].observe(rec.status, took)
+		if s.statsd != nil {
+			tags := []string{statsdTag("route", route), statsdTag("status_class", strconv.Itoa(rec.status/100)+"xx")}
+			s.statsd.count("requests", 1, tags...)
+			s.statsd.timing("request.latency", took, tags[0])
+		}
+	})
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shubhamc1947/safemap/pkg/concurrentmap"
+)
+
+// ----------- Namespaces -----------
+
+// Namespaced keys live in the store as nsKeyPrefix+"<ns>/<key>", so they
+// are persisted, exported and restored like any other data. Namespace
+// definitions are server state under the reserved nsMetaPrefix.
+const (
+	nsKeyPrefix  = "__ns/"
+	nsMetaPrefix = "__nsmeta/"
+)
+
+var nsNameRE = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+var errInvalidNamespace = errors.New("namespace names are 1-64 characters of a-z, 0-9, _ and -, starting with a letter or digit")
+
+// namespaceConfig is a namespace's definition, as stored and as accepted
+// by PUT /admin/namespaces/{ns}. Zero means "no default" / "unlimited".
+type namespaceConfig struct {
+	Name              string `json:"name"`
+	DefaultTTLSeconds int64  `json:"default_ttl_seconds,omitempty"`
+	Versions          int    `json:"versions,omitempty"` // previous values kept per key
+	quota
+	Indexes   []string  `json:"indexes,omitempty"` // JSON fields indexed; see indexes.go
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// namespaceStats are a namespace's counters. keys and bytes follow the
+// store's events, so they stay exact across expiry, replay and restore.
+type namespaceStats struct {
+	usage
+	gets, hits, misses, puts, deletes atomic.Int64
+}
+
+func (st *namespaceStats) report() map[string]int64 {
+	return map[string]int64{
+		"keys":    st.keys.Load(),
+		"bytes":   st.bytes.Load(),
+		"gets":    st.gets.Load(),
+		"hits":    st.hits.Load(),
+		"misses":  st.misses.Load(),
+		"puts":    st.puts.Load(),
+		"deletes": st.deletes.Load(),
+	}
+}
+
+// namespace is a defined namespace and its live counters.
+type namespace struct {
+	namespaceConfig
+	stats *namespaceStats
+}
+
+// storeKey maps a key inside ns to its key in the store.
+func (ns *namespace) storeKey(key string) string {
+	return nsKeyPrefix + ns.Name + "/" + key
+}
+
+// splitStoreKey is the inverse of storeKey: it splits a namespaced store
+// key into the namespace and the key inside it.
+func splitStoreKey(storeKey string) (ns, key string, ok bool) {
+	rest, ok := strings.CutPrefix(storeKey, nsKeyPrefix)
+	if !ok {
+		return "", "", false
+	}
+	return strings.Cut(rest, "/")
+}
+
+type namespaceRegistry struct {
+	store *concurrentmap.ConcurrentMap[string, StoredValue]
+	stats sync.Map // name -> *namespaceStats; outlives redefinition
+
+	mu   sync.RWMutex
+	defs map[string]*namespace
+
+	// kept maps versioned namespaces to how many versions they keep. It is
+	// read from store hooks, which must not take mu.
+	kept atomic.Pointer[map[string]int]
+}
+
+func newNamespaceRegistry(store *concurrentmap.ConcurrentMap[string, StoredValue]) *namespaceRegistry {
+	reg := &namespaceRegistry{store: store, defs: make(map[string]*namespace)}
+	store.Subscribe(reg.track)
+	return reg
+}
+
+func (n *namespaceRegistry) statsFor(name string) *namespaceStats {
+	if st, ok := n.stats.Load(name); ok {
+		return st.(*namespaceStats)
+	}
+	st, _ := n.stats.LoadOrStore(name, new(namespaceStats))
+	return st.(*namespaceStats)
+}
+
+// track keeps key and byte counts. It runs under the store's bucket lock,
+// so it only touches the counters.
+func (n *namespaceRegistry) track(ev concurrentmap.Event[string, StoredValue]) {
+	name, _, ok := splitStoreKey(ev.Key)
+	if !ok {
+		return
+	}
+	st := n.statsFor(name)
+	switch ev.Type {
+	case concurrentmap.EventInsert:
+		st.add(1, ev.NewValue.size())
+	case concurrentmap.EventUpdate:
+		st.add(0, ev.NewValue.size()-ev.OldValue.size())
+	case concurrentmap.EventDelete, concurrentmap.EventExpire:
+		st.add(-1, -ev.OldValue.size())
+	}
+}
+
+// reload rebuilds the definitions from the store; call it after persisted
+// data is loaded.
+func (n *namespaceRegistry) reload() {
+	defs := make(map[string]*namespace)
+	n.store.Range(func(key string, v StoredValue) bool {
+		if strings.HasPrefix(key, nsMetaPrefix) {
+			var cfg namespaceConfig
+			if json.Unmarshal(v.Data, &cfg) == nil {
+				defs[cfg.Name] = &namespace{cfg, n.statsFor(cfg.Name)}
+			}
+		}
+		return true
+	})
+
+	n.mu.Lock()
+	n.defs = defs
+	n.publishKept()
+	n.mu.Unlock()
+}
+
+// publishKept rebuilds kept from defs; callers hold mu.
+func (n *namespaceRegistry) publishKept() {
+	kept := make(map[string]int)
+	for name, ns := range n.defs {
+		if ns.Versions > 0 {
+			kept[name] = ns.Versions
+		}
+	}
+	n.kept.Store(&kept)
+}
+
+// versionsKept returns how many previous values namespace name keeps per
+// key, 0 when it is not versioned. It takes no locks.
+func (n *namespaceRegistry) versionsKept(name string) int {
+	if kept := n.kept.Load(); kept != nil {
+		return (*kept)[name]
+	}
+	return 0
+}
+
+func (n *namespaceRegistry) get(name string) (*namespace, bool) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	ns, ok := n.defs[name]
+	return ns, ok
+}
+
+func (n *namespaceRegistry) list() []*namespace {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	out := make([]*namespace, 0, len(n.defs))
+	for _, ns := range n.defs {
+		out = append(out, ns)
+	}
+	slices.SortFunc(out, func(a, b *namespace) int { return strings.Compare(a.Name, b.Name) })
+	return out
+}
+
+// put creates or redefines a namespace, keeping its data and creation
+// time. It reports whether the namespace is new.
+func (n *namespaceRegistry) put(cfg namespaceConfig) (*namespace, bool, error) {
+	if !nsNameRE.MatchString(cfg.Name) {
+		return nil, false, errInvalidNamespace
+	}
+	if _, ok := ttlDuration(cfg.DefaultTTLSeconds); !ok {
+		return nil, false, errors.New(ttlRangeMessage("default_ttl_seconds"))
+	}
+	if cfg.Versions < 0 || cfg.Versions > maxKeptVersions {
+		return nil, false, fmt.Errorf("versions must be 0-%d", maxKeptVersions)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, false, err
+	}
+	if _, err := parseIndexes(cfg.Indexes); err != nil {
+		return nil, false, err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	old, exists := n.defs[cfg.Name]
+	cfg.CreatedAt = time.Now().UTC()
+	if exists {
+		cfg.CreatedAt = old.CreatedAt
+	}
+	data, _ := json.Marshal(cfg)
+	n.store.Set(nsMetaPrefix+cfg.Name, StoredValue{Data: data})
+	ns := &namespace{cfg, n.statsFor(cfg.Name)}
+	n.defs[cfg.Name] = ns
+	n.publishKept()
+	return ns, !exists, nil
+}
+
+// flush deletes every key in the namespace, and the keys' version
+// histories, and returns how many keys.
+func (n *namespaceRegistry) flush(name string) int {
+	prefix := nsKeyPrefix + name + "/"
+	var keys, histories []string
+	n.store.Range(func(key string, _ StoredValue) bool {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		} else if strings.HasPrefix(key, historyKeyPrefix+prefix) {
+			histories = append(histories, key)
+		}
+		return true
+	})
+	for _, key := range slices.Concat(keys, histories) {
+		n.store.Delete(key)
+	}
+	return len(keys)
+}
+
+// remove deletes a namespace and its keys.
+func (n *namespaceRegistry) remove(name string) bool {
+	n.mu.Lock()
+	_, ok := n.defs[name]
+	if ok {
+		delete(n.defs, name)
+		n.publishKept()
+		n.store.Delete(nsMetaPrefix + name)
+	}
+	n.mu.Unlock()
+	if ok {
+		n.flush(name)
+	}
+	return ok
+}
+
+// resolveNamespace checks that p may use namespace name and returns it,
+// or nil for the flat keyspace when name is "".
+func (s *KVServer) resolveNamespace(p principal, name string) (*namespace, *statusError) {
+	if name == "" {
+		if !p.mayUse("") {
+			return nil, &statusError{http.StatusForbidden, codeForbidden, "token is limited to its namespaces"}
+		}
+		return nil, nil
+	}
+	if !p.mayUse(name) {
+		return nil, &statusError{http.StatusForbidden, codeForbidden, "token may not use namespace " + name}
+	}
+	ns, ok := s.namespaces.get(name)
+	if !ok {
+		return nil, &statusError{http.StatusNotFound, codeNamespaceNotFound, "no such namespace"}
+	}
+	return ns, nil
+}
+
+// nsPath splits a namespaced route, /v1/{ns}/..., into the namespace and
+// the rest of the path, starting with "/".
+func nsPath(path string) (ns, rest string, ok bool) {
+	rest, ok = strings.CutPrefix(path, "/v1/")
+	if !ok {
+		return "", "", false
+	}
+	i := strings.IndexByte(rest, '/')
+	if i <= 0 {
+		return "", "", false
+	}
+	return rest[:i], rest[i:], true
+}
+
+// kvPath splits a key route, /kv/{key} or /v1/{ns}/kv/{key}, into its
+// namespace ("" for the flat keyspace) and key.
+func kvPath(path string) (ns, key string, ok bool) {
+	if key, ok = strings.CutPrefix(path, "/kv/"); ok {
+		return "", key, true
+	}
+	rest, ok := strings.CutPrefix(path, "/v1/")
+	if !ok {
+		return "", "", false
+	}
+	ns, key, ok = strings.Cut(rest, "/kv/")
+	if !ok || ns == "" || strings.Contains(ns, "/") {
+		return "", "", false
+	}
+	return ns, key, true
+}
+
+func isKVPath(path string) bool {
+	_, _, ok := kvPath(path)
+	return ok
+}
+
+// mayUse reports whether p may access namespace ns ("" for the flat
+// keyspace). Callers restricted by a JWT namespace claim are held to the
+// namespaces listed; admins are not restricted.
+func (p principal) mayUse(ns string) bool {
+	return p.Namespaces == nil || p.has(scopeAdmin) || (ns != "" && slices.Contains(p.Namespaces, ns))
+}
+
+type namespaceInfo struct {
+	namespaceConfig
+	Stats map[string]int64 `json:"stats"`
+}
+
+func (ns *namespace) info() namespaceInfo {
+	return namespaceInfo{ns.namespaceConfig, ns.stats.report()}
+}
+
+// Admin: GET /admin/namespaces lists namespaces with their stats.
+func (s *KVServer) handleListNamespaces(w http.ResponseWriter, r *http.Request) {
+	var out []namespaceInfo
+	for _, ns := range s.namespaces.list() {
+		out = append(out, ns.info())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"namespaces": out})
+}
+
+// Admin: GET /admin/namespaces/{ns} returns one namespace.
+func (s *KVServer) handleGetNamespace(w http.ResponseWriter, r *http.Request) {
+	ns, ok := s.namespaces.get(r.PathValue("ns"))
+	if !ok {
+		writeError(w, r, http.StatusNotFound, codeNamespaceNotFound, "no such namespace")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(ns.info())
+}
+
+// Admin: PUT /admin/namespaces/{ns} {"default_ttl_seconds": 60,
+// "max_keys": 1000, "max_bytes": 1048576, "indexes": ["status"]} creates
+// or updates a namespace.
+func (s *KVServer) handlePutNamespace(w http.ResponseWriter, r *http.Request) {
+	var cfg namespaceConfig
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&cfg); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidBody, "invalid body: "+err.Error())
+			return
+		}
+	}
+	cfg.Name = r.PathValue("ns")
+	ns, created, err := s.namespaces.put(cfg)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+	s.defineIndexes(ns)
+	if !s.persist(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	}
+	_ = json.NewEncoder(w).Encode(ns.info())
+}
+
+// Admin: DELETE /admin/namespaces/{ns} deletes a namespace and its keys.
+func (s *KVServer) handleDeleteNamespace(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("ns")
+	if !s.namespaces.remove(name) {
+		writeError(w, r, http.StatusNotFound, codeNamespaceNotFound, "no such namespace")
+		return
+	}
+	s.indexes.define(name, nil)
+	if !s.persist(w, r) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Admin: POST /admin/namespaces/{ns}/flush deletes a namespace's keys.
+func (s *KVServer) handleFlushNamespace(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("ns")
+	if _, ok := s.namespaces.get(name); !ok {
+		writeError(w, r, http.StatusNotFound, codeNamespaceNotFound, "no such namespace")
+		return
+	}
+	n := s.namespaces.flush(name)
+	if !s.persist(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]int{"deleted": n})
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/shubhamc1947/safemap/pkg/concurrentmap"
+)
+
+// ----------- Pub/Sub -----------
+
+// Channels carry messages from publishers to whoever is subscribed at
+// the moment. Nothing is stored: a message published to a channel with
+// no subscribers is gone, and there is nothing to resume from.
+const channelBuffer = 256 // messages queued per subscriber before it is dropped
+
+// channelMessage is a message published to a channel.
+type channelMessage struct {
+	Channel string
+	Data    []byte
+}
+
+// channelMessageJSON is a message as sent to subscribers: text as data,
+// anything else base64-encoded as data_base64.
+type channelMessageJSON struct {
+	Channel    string  `json:"channel"`
+	Data       *string `json:"data,omitempty"`
+	DataBase64 []byte  `json:"data_base64,omitempty"`
+}
+
+func newChannelMessageJSON(m channelMessage) channelMessageJSON {
+	out := channelMessageJSON{Channel: m.Channel}
+	if utf8.Valid(m.Data) {
+		data := string(m.Data)
+		out.Data = &data
+	} else {
+		out.DataBase64 = m.Data
+	}
+	return out
+}
+
+// channelSub is one subscriber, to one or more channels. ch is closed
+// when it unsubscribes or falls channelBuffer messages behind.
+type channelSub struct {
+	ch chan channelMessage
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// deliver queues m without blocking, and reports whether it did. A
+// subscriber whose queue is full is closed.
+func (c *channelSub) deliver(m channelMessage) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return false
+	}
+	select {
+	case c.ch <- m:
+		return true
+	default:
+		c.closed = true
+		close(c.ch)
+		return false
+	}
+}
+
+func (c *channelSub) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.ch)
+	}
+}
+
+// pubSub holds each channel's subscribers in a SetMap, so publishing to
+// or subscribing to one channel never contends with another.
+type pubSub struct {
+	subs *concurrentmap.SetMap[string, *channelSub]
+	done chan struct{}
+}
+
+func newPubSub() *pubSub {
+	return &pubSub{
+		subs: concurrentmap.NewStringSetMap[*channelSub](64),
+		done: make(chan struct{}),
+	}
+}
+
+// subscribe starts delivering messages published to channels.
+func (ps *pubSub) subscribe(channels ...string) *channelSub {
+	sub := &channelSub{ch: make(chan channelMessage, channelBuffer)}
+	for _, c := range channels {
+		ps.subs.Add(c, sub)
+	}
+	return sub
+}
+
+// unsubscribe stops deliveries from channels and closes sub.
+func (ps *pubSub) unsubscribe(sub *channelSub, channels ...string) {
+	for _, c := range channels {
+		ps.subs.Remove(c, sub)
+	}
+	sub.close()
+}
+
+// publish delivers data to the channel's subscribers, dropping those
+// that have fallen behind, and returns how many it reached.
+func (ps *pubSub) publish(channel string, data []byte) int {
+	m := channelMessage{Channel: channel, Data: data}
+	n := 0
+	for _, sub := range ps.subs.Members(channel) {
+		if sub.deliver(m) {
+			n++
+		} else {
+			ps.subs.Remove(channel, sub)
+		}
+	}
+	return n
+}
+
+// close ends every subscription, on shutdown.
+func (ps *pubSub) close() {
+	close(ps.done)
+}
+
+// isPubSubPath reports whether path is under /publish/ or /subscribe/.
+func isPubSubPath(path string) bool {
+	return strings.HasPrefix(path, "/publish/") || strings.HasPrefix(path, "/subscribe/")
+}
+
+// checkChannel validates a channel name.
+func (s *KVServer) checkChannel(channel string) *statusError {
+	if s.maxKeyLength > 0 && len(channel) > s.maxKeyLength {
+		return &statusError{http.StatusBadRequest, codeKeyTooLong,
+			fmt.Sprintf("channel name exceeds the %d byte limit", s.maxKeyLength)}
+	}
+	return nil
+}
+
+type publishResponse struct {
+	Receivers int `json:"receivers"`
+}
+
+// Publish: POST /publish/{channel} sends the body to the channel's
+// current subscribers and answers how many received it:
+//
+//	{"receivers": 3}
+//
+// Nothing is stored; with no subscribers the message is dropped.
+func (s *KVServer) handlePublish(w http.ResponseWriter, r *http.Request) {
+	s.metrics.TotalRequests.Add(1)
+	channel := r.PathValue("channel")
+	if serr := s.checkChannel(channel); serr != nil {
+		serr.write(w, r)
+		return
+	}
+	body := io.Reader(r.Body)
+	if s.maxValueSize > 0 {
+		body = http.MaxBytesReader(w, r.Body, s.maxValueSize)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, r, http.StatusRequestEntityTooLarge, codeValueTooLarge,
+				fmt.Sprintf("message exceeds the %d byte limit", tooLarge.Limit))
+			return
+		}
+		writeError(w, r, http.StatusBadRequest, codeInvalidBody, "failed to read body")
+		return
+	}
+
+	n := s.pubsub.publish(channel, data)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(publishResponse{Receivers: n})
+}
+
+// Subscribe: GET /subscribe/{channel} streams the channel's messages as
+// Server-Sent Events:
+//
+//	event: message
+//	data: {"channel": "invalidate", "data": "user:42"}
+//
+// A comment line is sent every 15s while idle. Only messages published
+// while connected are received; a subscriber too slow to keep up is
+// disconnected.
+func (s *KVServer) handleSubscribe(w http.ResponseWriter, r *http.Request) {
+	s.metrics.TotalRequests.Add(1)
+	channel := r.PathValue("channel")
+	if serr := s.checkChannel(channel); serr != nil {
+		serr.write(w, r)
+		return
+	}
+	sub := s.pubsub.subscribe(channel)
+	defer s.pubsub.unsubscribe(sub, channel)
+
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{}) // streams outlive --write-timeout
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if rc.Flush() != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(watchHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.pubsub.done:
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case m, ok := <-sub.ch:
+			if !ok {
+				return
+			}
+			data, _ := json.Marshal(newChannelMessageJSON(m))
+			if _, err := fmt.Fprintf(w, "event: message\ndata: %s\n\n", data); err != nil {
+				return
+			}
+		}
+		if rc.Flush() != nil {
+			return
+		}
+	}
+}
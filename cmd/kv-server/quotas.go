@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/shubhamc1947/safemap/pkg/concurrentmap"
+)
+
+// ----------- Storage Quotas -----------
+
+// quota caps how much a namespace or an API token may store. Bytes count
+// value sizes. Zero means unlimited.
+type quota struct {
+	MaxKeys  int64 `json:"max_keys,omitempty"`
+	MaxBytes int64 `json:"max_bytes,omitempty"`
+}
+
+var errNegativeQuota = errors.New("max_keys and max_bytes must not be negative")
+
+func (q quota) validate() error {
+	if q.MaxKeys < 0 || q.MaxBytes < 0 {
+		return errNegativeQuota
+	}
+	return nil
+}
+
+// usage is what a namespace or token currently stores.
+type usage struct {
+	keys, bytes atomic.Int64
+}
+
+func (u *usage) add(keys, bytes int64) {
+	u.keys.Add(keys)
+	u.bytes.Add(bytes)
+}
+
+// allows reports whether adding keys and bytes keeps u within q. Writes
+// that shrink usage are always allowed, so a tenant over a lowered quota
+// can still overwrite and delete.
+func (q quota) allows(u *usage, keys, bytes int64) bool {
+	return (q.MaxKeys == 0 || keys <= 0 || u.keys.Load()+keys <= q.MaxKeys) &&
+		(q.MaxBytes == 0 || bytes <= 0 || u.bytes.Load()+bytes <= q.MaxBytes)
+}
+
+func (q quota) report(u *usage) map[string]int64 {
+	return map[string]int64{
+		"keys":      u.keys.Load(),
+		"bytes":     u.bytes.Load(),
+		"max_keys":  q.MaxKeys,
+		"max_bytes": q.MaxBytes,
+	}
+}
+
+// ownerUsage tracks storage per owning token from the store's events, so
+// counts follow expiry, replay and restore.
+type ownerUsage struct {
+	owners sync.Map // token ID -> *usage
+}
+
+func newOwnerUsage(store *concurrentmap.ConcurrentMap[string, StoredValue]) *ownerUsage {
+	o := new(ownerUsage)
+	store.Subscribe(o.track)
+	return o
+}
+
+func (o *ownerUsage) of(id string) *usage {
+	if u, ok := o.owners.Load(id); ok {
+		return u.(*usage)
+	}
+	u, _ := o.owners.LoadOrStore(id, new(usage))
+	return u.(*usage)
+}
+
+// track runs under the store's bucket lock, so it only touches counters.
+func (o *ownerUsage) track(ev concurrentmap.Event[string, StoredValue]) {
+	if ev.Type != concurrentmap.EventInsert && ev.OldValue.Owner != "" {
+		o.of(ev.OldValue.Owner).add(-1, -ev.OldValue.size())
+	}
+	if (ev.Type == concurrentmap.EventInsert || ev.Type == concurrentmap.EventUpdate) && ev.NewValue.Owner != "" {
+		o.of(ev.NewValue.Owner).add(1, ev.NewValue.size())
+	}
+}
+
+// quotaError decides whether p may store a value of size bytes at key
+// (the store key, in ns or in the flat keyspace when ns is nil), returning
+// the error if not. A full store evicts per --eviction-policy (see
+// eviction.go) or is out of storage (507), as is a full namespace; a token
+// over its own quota is throttled (429).
+func (s *KVServer) quotaError(p principal, ns *namespace, key string, size int64) *statusError {
+	if ns == nil && p.TokenID == "" {
+		return s.memory.makeRoom(key, size)
+	}
+	old, exists := s.store.Get(key)
+
+	if ns != nil {
+		keys, bytes := int64(1), size
+		if exists {
+			keys, bytes = 0, size-old.size()
+		}
+		if !ns.allows(&ns.stats.usage, keys, bytes) {
+			return &statusError{http.StatusInsufficientStorage, codeQuotaExceeded, "namespace " + ns.Name + " is over its storage quota"}
+		}
+	}
+
+	if p.TokenID != "" {
+		keys, bytes := int64(1), size
+		if exists && old.Owner == p.TokenID {
+			keys, bytes = 0, size-old.size()
+		}
+		if !p.Quota.allows(s.usage.of(p.TokenID), keys, bytes) {
+			return &statusError{http.StatusTooManyRequests, codeQuotaExceeded, "token is over its storage quota"}
+		}
+	}
+
+	// Evict last, so a write refused above costs no other key.
+	return s.memory.makeRoom(key, size)
+}
+
+// Usage: GET /usage reports the caller's token quota and usage, plus those
+// of the namespaces a JWT limits the caller to. GET /v1/{ns}/usage
+// reports one namespace.
+func (s *KVServer) handleUsage(w http.ResponseWriter, r *http.Request) {
+	p, _ := principalFrom(r.Context())
+
+	out := map[string]any{}
+	if p.TokenID != "" {
+		out["token"] = p.Quota.report(s.usage.of(p.TokenID))
+	}
+	if p.Namespaces != nil {
+		nss := map[string]any{}
+		for _, name := range p.Namespaces {
+			if ns, ok := s.namespaces.get(name); ok {
+				nss[name] = ns.quota.report(&ns.stats.usage)
+			}
+		}
+		out["namespaces"] = nss
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+func (s *KVServer) handleNamespaceUsage(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("ns")
+	if p, _ := principalFrom(r.Context()); !p.mayUse(name) {
+		writeError(w, r, http.StatusForbidden, codeForbidden, "token may not use namespace "+name)
+		return
+	}
+	ns, ok := s.namespaces.get(name)
+	if !ok {
+		writeError(w, r, http.StatusNotFound, codeNamespaceNotFound, "no such namespace")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(ns.quota.report(&ns.stats.usage))
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shubhamc1947/safemap/pkg/concurrentmap"
+	"github.com/shubhamc1947/safemap/pkg/ratelimit"
+)
+
+// ----------- Rate Limit Policy -----------
+
+// rateLimits combines the limits a request is subject to. Clients are
+// identified by the API key they authenticated with, otherwise by IP.
+//
+//   - client: per-client limit (--rate-limit); an API key listed in
+//     perKey gets its own limit instead.
+//   - reads/writes: per-client limits by route class, applied on top.
+type rateLimits struct {
+	client ratelimit.Limiter
+	perKey map[string]ratelimit.Limiter
+	reads  ratelimit.Limiter
+	writes ratelimit.Limiter
+}
+
+type rateLimitConfig struct {
+	Algorithm  string
+	Counters   *rateCounters // nil = in-memory counters
+	Window     time.Duration
+	Limit      int    // per client, 0 = unlimited
+	ReadLimit  int    // per client for GET/HEAD, 0 = unlimited
+	WriteLimit int    // per client for other methods, 0 = unlimited
+	KeyLimits  string // "key=limit,key2=limit2"
+}
+
+// newRateLimits builds the policy, or returns nil if no limit is set.
+func newRateLimits(cfg rateLimitConfig) (*rateLimits, error) {
+	alg := ratelimit.Algorithm(cfg.Algorithm)
+	build := func(name string, limit int) (ratelimit.Limiter, error) {
+		if limit <= 0 {
+			return nil, nil
+		}
+		if cfg.Counters != nil {
+			counters := storeCounters{counters: cfg.Counters, prefix: name + "/"}
+			return ratelimit.NewWithStore(alg, counters, limit, cfg.Window)
+		}
+		return ratelimit.New(alg, limit, cfg.Window)
+	}
+
+	rl := &rateLimits{perKey: make(map[string]ratelimit.Limiter)}
+	var err error
+	if rl.client, err = build("client", cfg.Limit); err != nil {
+		return nil, err
+	}
+	if rl.reads, err = build("read", cfg.ReadLimit); err != nil {
+		return nil, err
+	}
+	if rl.writes, err = build("write", cfg.WriteLimit); err != nil {
+		return nil, err
+	}
+
+	for _, pair := range strings.Split(cfg.KeyLimits, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, limit, ok := strings.Cut(pair, "=")
+		n, convErr := strconv.Atoi(limit)
+		if !ok || key == "" || convErr != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid API key rate limit %q, want key=limit", pair)
+		}
+		if rl.perKey[key], err = build("key-"+hashAPIKey(key), n); err != nil {
+			return nil, err
+		}
+	}
+
+	if rl.client == nil && rl.reads == nil && rl.writes == nil && len(rl.perKey) == 0 {
+		return nil, nil
+	}
+	return rl, nil
+}
+
+// check runs every applicable limiter for a request from clientIP and
+// returns the most restrictive result. It stops at the first denial.
+// apiKey is the key the caller authenticated with, or "" to identify it
+// by IP.
+func (rl *rateLimits) check(r *http.Request, apiKey, clientIP string) (ratelimit.Result, bool) {
+	id := "ip:" + clientIP
+	if apiKey != "" {
+		id = "key:" + hashAPIKey(apiKey)
+	}
+
+	limiters := make([]ratelimit.Limiter, 0, 2)
+	if l, ok := rl.perKey[apiKey]; ok && apiKey != "" {
+		limiters = append(limiters, l)
+	} else if rl.client != nil {
+		limiters = append(limiters, rl.client)
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		if rl.reads != nil {
+			limiters = append(limiters, rl.reads)
+		}
+	} else if rl.writes != nil {
+		limiters = append(limiters, rl.writes)
+	}
+
+	var (
+		tightest ratelimit.Result
+		applied  bool
+	)
+	for _, l := range limiters {
+		res := l.Allow(id)
+		if !applied || !res.Allowed || res.Remaining < tightest.Remaining {
+			tightest, applied = res, true
+		}
+		if !res.Allowed {
+			break
+		}
+	}
+	return tightest, applied
+}
+
+// hashAPIKey identifies an API key in limiter state without keeping the
+// secret itself, which may end up in the counter store.
+func hashAPIKey(key string) string {
+	return strconv.FormatUint(concurrentmap.XXHash64(key), 16)
+}
+
+// setRateLimitHeaders writes the X-RateLimit-* headers and, on denial,
+// Retry-After. Durations are rounded up to whole seconds.
+func setRateLimitHeaders(h http.Header, res ratelimit.Result) {
+	h.Set("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(res.ResetAfter)))
+	if !res.Allowed {
+		h.Set("Retry-After", strconv.Itoa(max(1, ceilSeconds(res.RetryAfter))))
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// Rate limit middleware: per-client, per-API-key and per-route limits
+func (s *KVServer) rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Skip health probes
+		rl := s.rateLimits.Load()
+		if rl == nil || isProbePath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var apiKey string
+		if p, ok := principalFrom(r.Context()); ok {
+			apiKey = rateLimitKey(r, p)
+		}
+		res, applied := rl.check(r, apiKey, s.clientIP(r))
+		if applied {
+			setRateLimitHeaders(w.Header(), res)
+		}
+		if applied && !res.Allowed {
+			s.metrics.RateLimited.Add(1)
+			writeError(w, r, http.StatusTooManyRequests, codeRateLimited, "rate limit exceeded")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitKey returns the API key that authenticated p, or "" for an
+// anonymous caller. A key that authenticated no one is the caller's to
+// choose, and a fresh one per request would dodge the limit of its IP.
+func rateLimitKey(r *http.Request, p principal) string {
+	if p.Name == "anonymous" {
+		return ""
+	}
+	return apiKeyFromRequest(r)
+}
+
+// ----------- Store-backed Counters -----------
+
+// rateCounters holds the counters of --rate-limit-backend=store in a
+// ConcurrentMap of their own, updated atomically with Compute. They stay
+// out of the data keyspace: they are not written to the AOF or snapshots,
+// do not count towards --max-keys or --max-memory, and are not seen by
+// watchers or webhooks. The map outlives reloads, so counters survive one.
+type rateCounters = concurrentmap.ConcurrentMap[string, rateCounter]
+
+func newRateCounters(buckets int) *rateCounters {
+	return concurrentmap.NewStringMap[rateCounter](buckets)
+}
+
+// rateCounter is one window's count, dropped once its window is over.
+type rateCounter struct {
+	n         int64
+	expiresAt time.Time
+}
+
+func (c rateCounter) expired(now time.Time) bool {
+	return !now.Before(c.expiresAt)
+}
+
+// storeCounters is a ratelimit.CounterStore over rateCounters, with
+// its keys under prefix.
+type storeCounters struct {
+	counters *rateCounters
+	prefix   string
+}
+
+func (c storeCounters) Add(key string, delta int64, expiresAt time.Time) int64 {
+	now := time.Now()
+	var n int64
+	c.counters.Compute(c.prefix+key, func(old rateCounter, exists bool) (rateCounter, bool) {
+		n = delta
+		if exists && !old.expired(now) {
+			n += old.n
+		}
+		return rateCounter{n: n, expiresAt: expiresAt}, true
+	})
+	return n
+}
+
+func (c storeCounters) Load(key string) int64 {
+	v, ok := c.counters.Get(c.prefix + key)
+	if !ok || v.expired(time.Now()) {
+		return 0
+	}
+	return v.n
+}
+
+// expireRateCounters removes the counters whose window is over and
+// returns how many. The expiry worker runs it each tick.
+func expireRateCounters(counters *rateCounters, now time.Time) int {
+	var keys []string
+	counters.Range(func(key string, c rateCounter) bool {
+		if c.expired(now) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	removed := 0
+	isExpired := func(c rateCounter) bool { return c.expired(now) }
+	for _, key := range keys {
+		if counters.ExpireIf(key, isExpired) {
+			removed++
+		}
+	}
+	return removed
+}
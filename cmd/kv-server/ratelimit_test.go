@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// rateLimitedHandler serves 200s behind the auth and rate limit
+// middlewares, as withMiddlewares orders them.
+func rateLimitedHandler(t *testing.T, authToken string, cfg rateLimitConfig) http.Handler {
+	t.Helper()
+	cfg.Algorithm, cfg.Window = "fixed", time.Minute
+	rl, err := newRateLimits(cfg)
+	if err != nil {
+		t.Fatalf("newRateLimits: %v", err)
+	}
+	s := newTestServer(t)
+	s.authToken = authToken
+	s.rateLimits.Store(rl)
+	ok := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+	return s.authMiddleware(s.rateLimitMiddleware(ok))
+}
+
+func serveFrom(h http.Handler, method, ip, apiKey string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/kv/a", nil)
+	r.RemoteAddr = ip + ":1234"
+	if apiKey != "" {
+		r.Header.Set("X-API-Key", apiKey)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestRateLimitByAuthenticatedKey(t *testing.T) {
+	h := rateLimitedHandler(t, "secret", rateLimitConfig{Limit: 1})
+
+	w := serveFrom(h, http.MethodGet, "10.0.0.1", "secret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if got := w.Header().Get("X-RateLimit-Remaining"); got != "0" {
+		t.Fatalf("expected X-RateLimit-Remaining 0, got %q", got)
+	}
+
+	// The key is the client, whichever address it calls from.
+	w = serveFrom(h, http.MethodGet, "10.0.0.2", "secret")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 for the same key from another IP, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected Retry-After on a 429")
+	}
+}
+
+func TestRateLimitIgnoresUncheckedKeys(t *testing.T) {
+	h := rateLimitedHandler(t, "", rateLimitConfig{Limit: 1})
+
+	if w := serveFrom(h, http.MethodGet, "10.0.0.1", "a"); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	// Without --auth-token nothing vouches for the key, so a new one must
+	// not buy a new bucket.
+	if w := serveFrom(h, http.MethodGet, "10.0.0.1", "b"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 for a fresh key from the same IP, got %d", w.Code)
+	}
+	if w := serveFrom(h, http.MethodGet, "10.0.0.2", "b"); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 from another IP, got %d", w.Code)
+	}
+}
+
+func TestRateLimitPerKeyAndRoute(t *testing.T) {
+	h := rateLimitedHandler(t, "secret", rateLimitConfig{Limit: 1, WriteLimit: 2, KeyLimits: "secret=5"})
+
+	// The key's own limit replaces --rate-limit; the write limit still
+	// applies on top.
+	for i := 0; i < 2; i++ {
+		if w := serveFrom(h, http.MethodPut, "10.0.0.1", "secret"); w.Code != http.StatusOK {
+			t.Fatalf("write %d: expected 200, got %d", i, w.Code)
+		}
+	}
+	if w := serveFrom(h, http.MethodPut, "10.0.0.1", "secret"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the write limit to deny, got %d", w.Code)
+	}
+	if w := serveFrom(h, http.MethodGet, "10.0.0.1", "secret"); w.Code != http.StatusOK {
+		t.Fatalf("expected a read within the key's limit, got %d", w.Code)
+	}
+}
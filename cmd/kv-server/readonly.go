@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// ----------- Read-only Mode -----------
+
+// In read-only mode, set by --read-only or POST /admin/readonly, writes
+// get 503 read_only on every protocol while reads go on, for migrations,
+// backup restores and replica promotion. Admin routes are not affected,
+// so a restore can still load data, and keys still expire.
+
+func readOnlyError() *statusError {
+	return &statusError{http.StatusServiceUnavailable, codeReadOnly, "the server is read-only"}
+}
+
+// isWriteRequest reports whether r writes keys: a request needing the
+// write scope other than a publish. Batches and transactions are checked
+// per operation; see resolveBatchOp.
+func isWriteRequest(r *http.Request) bool {
+	return requiredScope(r) == scopeWrite && !isPubSubPath(r.URL.Path)
+}
+
+// readOnlyMiddleware rejects writes while the server is read-only.
+func (s *KVServer) readOnlyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.readOnly.Load() && isWriteRequest(r) {
+			readOnlyError().write(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Admin: POST /admin/readonly {"read_only": true} stops accepting writes;
+// false accepts them again. GET reports the mode.
+func (s *KVServer) handleReadOnly(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		var req struct {
+			ReadOnly *bool `json:"read_only"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&req); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidBody, "invalid body: "+err.Error())
+			return
+		}
+		if req.ReadOnly == nil {
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, "read_only is required")
+			return
+		}
+		if s.readOnly.Swap(*req.ReadOnly) != *req.ReadOnly {
+			slog.InfoContext(r.Context(), "admin: read-only mode changed", "read_only", *req.ReadOnly)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]bool{"read_only": s.readOnly.Load()})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadOnlyMiddleware(t *testing.T) {
+	s := newTestServer(t)
+	s.readOnly.Store(true)
+	h := s.readOnlyMiddleware(okHandler)
+
+	tests := []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodPut, "/kv/a", http.StatusServiceUnavailable},
+		{http.MethodDelete, "/kv/a", http.StatusServiceUnavailable},
+		{http.MethodPost, "/lists/a/rpush", http.StatusServiceUnavailable},
+		{http.MethodGet, "/kv/a", http.StatusOK},
+		{http.MethodPost, "/publish/news", http.StatusOK},
+		{http.MethodPost, "/admin/readonly", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if w := serveAs(h, tt.method, tt.path, ""); w.Code != tt.want {
+			t.Errorf("%s %s: expected %d, got %d", tt.method, tt.path, tt.want, w.Code)
+		}
+	}
+
+	s.readOnly.Store(false)
+	if w := serveAs(h, http.MethodPut, "/kv/a", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected writes once read-only is off, got %d", w.Code)
+	}
+}
+
+func TestHandleReadOnly(t *testing.T) {
+	s := newTestServer(t)
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.handleReadOnly(w, httptest.NewRequest(http.MethodPost, "/admin/readonly", strings.NewReader(body)))
+		return w
+	}
+
+	w := post(`{"read_only": true}`)
+	if w.Code != http.StatusOK || !s.readOnly.Load() {
+		t.Fatalf("expected read-only on, got %d, read_only=%v", w.Code, s.readOnly.Load())
+	}
+	if got := strings.TrimSpace(w.Body.String()); got != `{"read_only":true}` {
+		t.Fatalf("unexpected body %s", got)
+	}
+	for _, body := range []string{`{}`, `not json`} {
+		if w := post(body); w.Code != http.StatusBadRequest || !s.readOnly.Load() {
+			t.Fatalf("with %s: expected 400 and no change, got %d, read_only=%v", body, w.Code, s.readOnly.Load())
+		}
+	}
+	if w := post(`{"read_only": false}`); w.Code != http.StatusOK || s.readOnly.Load() {
+		t.Fatalf("expected read-only off, got %d, read_only=%v", w.Code, s.readOnly.Load())
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/shubhamc1947/safemap/pkg/concurrentmap"
+)
+
+// ----------- Sets -----------
+
+// A key can hold a set of strings, edited and queried member by member,
+// and combined with other sets on the server:
+//
+//	POST /sets/{key}/add    {"members": ["a", "b"]}   -> {"added": 2}
+//	POST /sets/{key}/remove {"members": ["a"]}        -> {"removed": 1}
+//	GET  /sets/{key}/members                          -> {"members": ["b"]}
+//	GET  /sets/{key}/ismember?member=b                -> {"member": true}
+//	GET  /sets/{key}/card                             -> {"cardinality": 1}
+//	GET  /sets/{key}/inter?with=k2&with=k3            -> {"members": [...]}
+//
+// union and diff work as inter does. /v1/{ns}/sets/{key}/... works inside
+// a namespace. A set is a value of type application/x-set+json, read and
+// written whole as a sorted JSON array of its members, so it expires,
+// persists and replicates like any other. As in Redis, removing the last
+// member deletes the key.
+//
+// Its members live in a SetMap, following the store's events, and the
+// store keeps a header (see collections.go): an edit adds or removes the
+// members it names in place and the AOF logs only those, so edits cost
+// what they change however large the set grows.
+
+const setContentType = "application/x-set+json"
+
+// maxSetKeys caps the keys one inter, union or diff combines.
+const maxSetKeys = 64
+
+var errNotSet = errors.New("the value is not a set")
+
+// setPath splits a set route, /sets/{key}/{op} or /v1/{ns}/sets/{key}/{op}.
+func setPath(path string) (ns, key string, ok bool) {
+	return typedPath(path, "sets")
+}
+
+func isSetPath(path string) bool {
+	_, _, ok := setPath(path)
+	return ok
+}
+
+// decodeSet returns the members of the set stored whole in v, decrypted
+// with keys, sorted and without duplicates, none if v is not live.
+func decodeSet(v StoredValue, live bool, keys *keyring) ([]string, error) {
+	if !live {
+		return nil, nil
+	}
+	if v.ContentType != setContentType || v.Elements || v.Spill != "" {
+		return nil, errNotSet
+	}
+	var members []string
+	if err := json.Unmarshal(v.plain(keys).Data, &members); err != nil {
+		return nil, errNotSet
+	}
+	slices.Sort(members)
+	return slices.Compact(members), nil
+}
+
+// setMemberSize estimates the length of member in the set.
+func setMemberSize(member string) int64 {
+	return int64(len(member)) + 3
+}
+
+// setIndex holds the members of each set, by store key.
+type setIndex struct {
+	members *concurrentmap.SetMap[string, string]
+	keys    *keyring // decrypts whole sets; nil = no keyring
+}
+
+func newSetIndex(store *concurrentmap.ConcurrentMap[string, StoredValue], keys *keyring) *setIndex {
+	ix := &setIndex{members: concurrentmap.NewStringSetMap[string](64), keys: keys}
+	store.Subscribe(ix.track)
+	return ix
+}
+
+// track keeps the index current: it applies a header's edit, or replaces
+// the members with those of a whole value. It runs under the store's
+// bucket lock, so it only touches the index, which has locks of its own.
+func (ix *setIndex) track(ev concurrentmap.Event[string, StoredValue]) {
+	if ev.Type == concurrentmap.EventInsert || ev.Type == concurrentmap.EventUpdate {
+		if v := ev.NewValue; v.Elements && v.ContentType == setContentType {
+			if edit := editOf(ev); edit != nil {
+				ix.apply(ev.Key, edit)
+			}
+			return
+		}
+		if members, err := decodeSet(ev.NewValue, true, ix.keys); err == nil {
+			ix.members.Replace(ev.Key, members...)
+			return
+		}
+	}
+	if ev.Type != concurrentmap.EventInsert && ev.OldValue.ContentType == setContentType {
+		ix.members.Delete(ev.Key)
+	}
+}
+
+func (ix *setIndex) apply(key string, edit *collectionEdit) {
+	if edit.Reset {
+		ix.members.Delete(key)
+	}
+	ix.members.Add(key, edit.Add...)
+	ix.members.Remove(key, edit.Remove...)
+}
+
+// handleSet routes the /sets/{key}/{op} routes (and their namespaced
+// form) to the op.
+func (s *KVServer) handleSet(w http.ResponseWriter, r *http.Request) {
+	key, op := splitKeyAction(r.PathValue("key"))
+	read := r.Method == http.MethodGet || r.Method == http.MethodHead
+	var handler func(w http.ResponseWriter, r *http.Request, key string, ns *namespace)
+	switch {
+	case r.Method == http.MethodPost && (op == "add" || op == "remove"):
+		handler = func(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+			s.handleSetEdit(w, r, key, ns, op == "add")
+		}
+	case read && op == "members":
+		handler = s.handleSetMembers
+	case read && op == "ismember":
+		handler = s.handleSetIsMember
+	case read && op == "card":
+		handler = s.handleSetCard
+	case read && (op == "inter" || op == "union" || op == "diff"):
+		handler = func(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+			s.handleSetAlgebra(w, r, key, ns, op)
+		}
+	default:
+		writeError(w, r, http.StatusNotFound, codeNotFound, "use /sets/{key}/add, /remove, /members, /ismember, /card, /inter, /union or /diff")
+		return
+	}
+	r.SetPathValue("key", key)
+	s.keyHandler(handler)(w, r)
+}
+
+// SADD / SREM: POST /sets/{key}/add {"members": ["a", "b"]} adds members
+// to the set at key, creating it if missing, and returns how many were
+// new; /remove removes them and returns how many were there. The edit is
+// one atomic step. An existing set keeps its TTL, lease and tags. Sets
+// are not held to --max-value-size; quotas and --max-memory apply.
+func (s *KVServer) handleSetEdit(w http.ResponseWriter, r *http.Request, key string, ns *namespace, add bool) {
+	s.metrics.TotalPuts.Add(1)
+	var req struct {
+		Members []string `json:"members"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidBody, "invalid body: "+err.Error())
+		return
+	}
+
+	p, _ := principalFrom(r.Context())
+	if add {
+		size := int64(0)
+		for _, m := range req.Members {
+			size += setMemberSize(m)
+		}
+		if cur, ok := s.store.Get(key); ok && !cur.isExpired(time.Now()) {
+			size += cur.size()
+		}
+		if serr := s.quotaError(p, ns, key, size); serr != nil {
+			serr.write(w, r)
+			return
+		}
+	}
+	if ns != nil {
+		ns.stats.puts.Add(1)
+	}
+
+	changed := 0
+	_, sp := s.tracer.start(r.Context(), "store.set_edit", spanKindInternal)
+	err := s.store.ComputeErr(key, func(old StoredValue, exists bool) (StoredValue, bool, error) {
+		now := time.Now()
+		live := exists && !old.isExpired(now)
+		switch {
+		case !live:
+		case old.ContentType != setContentType:
+			return old, exists, errNotSet
+		case !old.Elements:
+			// Stored whole: its members are in the index if it decodes.
+			if _, err := decodeSet(old, true, s.valueKeys); err != nil {
+				return old, exists, err
+			}
+		}
+		edit := &collectionEdit{Reset: !live, version: s.nextVersion()}
+		size := int64(0)
+		if live {
+			size = old.size()
+		}
+		seen := make(map[string]bool, len(req.Members))
+		for _, m := range req.Members {
+			if seen[m] || add == (live && s.sets.members.Contains(key, m)) {
+				continue
+			}
+			seen[m] = true
+			if add {
+				edit.Add = append(edit.Add, m)
+				size += setMemberSize(m)
+			} else {
+				edit.Remove = append(edit.Remove, m)
+				size -= setMemberSize(m)
+			}
+		}
+		changed = len(seen)
+		switch {
+		case changed == 0:
+			return old, exists, nil
+		case !add && changed == s.sets.members.Cardinality(key):
+			return old, false, nil
+		}
+		return s.newHeader(p, ns, setContentType, old, live, edit, size, now), true, nil
+	})
+	sp.setAttr("changed", changed)
+	sp.finish()
+	switch {
+	case errors.Is(err, errNotSet):
+		writeError(w, r, http.StatusConflict, codeNotSet, err.Error())
+		return
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	if !s.persist(w, r) {
+		return
+	}
+	field := "removed"
+	if add {
+		field = "added"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]int{field: changed})
+}
+
+// liveOfType reports whether key holds a live value of contentType,
+// writing wrong if it holds something else. The read counts as one of
+// the value, though its members are then read from a mirror.
+func (s *KVServer) liveOfType(w http.ResponseWriter, r *http.Request, key string, ns *namespace, contentType string, wrong statusError) (live, ok bool) {
+	v, exists := s.fetchValue(r.Context(), key, ns, false)
+	switch {
+	case !exists:
+		return false, true
+	case v.ContentType != contentType:
+		wrong.write(w, r)
+		return false, false
+	}
+	return true, true
+}
+
+// liveSet is liveOfType for sets.
+func (s *KVServer) liveSet(w http.ResponseWriter, r *http.Request, key string, ns *namespace) (live, ok bool) {
+	return s.liveOfType(w, r, key, ns, setContentType, statusError{http.StatusConflict, codeNotSet, errNotSet.Error()})
+}
+
+// SMEMBERS: GET /sets/{key}/members returns the members of the set at
+// key, sorted, none if it is missing.
+func (s *KVServer) handleSetMembers(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+	s.metrics.TotalGets.Add(1)
+	live, ok := s.liveSet(w, r, key, ns)
+	if !ok {
+		return
+	}
+	members := []string{}
+	if live {
+		members = append(members, s.sets.members.Members(key)...)
+		slices.Sort(members)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string][]string{"members": members})
+}
+
+// SISMEMBER: GET /sets/{key}/ismember?member=b reports whether member is
+// in the set at key.
+func (s *KVServer) handleSetIsMember(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+	s.metrics.TotalGets.Add(1)
+	if !r.URL.Query().Has("member") {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "missing member")
+		return
+	}
+	live, ok := s.liveSet(w, r, key, ns)
+	if !ok {
+		return
+	}
+	found := live && s.sets.members.Contains(key, r.URL.Query().Get("member"))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]bool{"member": found})
+}
+
+// SCARD: GET /sets/{key}/card returns the number of members of the set at
+// key, 0 if it is missing.
+func (s *KVServer) handleSetCard(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+	s.metrics.TotalGets.Add(1)
+	live, ok := s.liveSet(w, r, key, ns)
+	if !ok {
+		return
+	}
+	n := 0
+	if live {
+		n = s.sets.members.Cardinality(key)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]int{"cardinality": n})
+}
+
+// SINTER / SUNION / SDIFF: GET /sets/{key}/inter?with=k2&with=k3 returns
+// the members common to the set at key and those at the with keys, of the
+// same namespace, sorted; union returns the members of any, and diff
+// those of the set at key in none of the others. Missing keys count as
+// empty sets. Each set is read atomically, but not all of them together.
+func (s *KVServer) handleSetAlgebra(w http.ResponseWriter, r *http.Request, key string, ns *namespace, op string) {
+	s.metrics.TotalGets.Add(1)
+	with := r.URL.Query()["with"]
+	if len(with) == 0 || len(with) >= maxSetKeys {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("with must list 1-%d keys", maxSetKeys-1))
+		return
+	}
+
+	p, _ := principalFrom(r.Context())
+	nsName := r.PathValue("ns")
+	keys := []string{key}
+	for _, k := range with {
+		storeKey, _, serr := s.resolveKey(p, nsName, k)
+		if serr == nil && s.aclEnforced && !p.has(scopeAdmin) && !s.acl.allowed(p.Name, aclKey(nsName, k), scopeRead) {
+			serr = &statusError{http.StatusForbidden, codeForbidden, "no ACL rule allows read on " + k}
+		}
+		if serr != nil {
+			serr.write(w, r)
+			return
+		}
+		keys = append(keys, storeKey)
+	}
+
+	// Leave out missing keys, which are empty sets, except where they
+	// make the result empty.
+	liveKeys := keys[:0:0]
+	empty := false
+	for i, k := range keys {
+		live, ok := s.liveSet(w, r, k, ns)
+		if !ok {
+			return
+		}
+		switch {
+		case live:
+			liveKeys = append(liveKeys, k)
+		case op == "inter" || op == "diff" && i == 0:
+			empty = true
+		}
+	}
+
+	members := []string{}
+	if !empty {
+		_, sp := s.tracer.start(r.Context(), "sets."+op, spanKindInternal)
+		switch op {
+		case "inter":
+			members = append(members, s.sets.members.Inter(liveKeys...)...)
+		case "union":
+			members = append(members, s.sets.members.Union(liveKeys...)...)
+		case "diff":
+			members = append(members, s.sets.members.Diff(liveKeys...)...)
+		}
+		sp.setAttr("members", len(members))
+		sp.finish()
+		slices.Sort(members)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string][]string{"members": members})
+}
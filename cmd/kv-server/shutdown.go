@@ -0,0 +1,27 @@
+package main
+
+import "log/slog"
+
+// ----------- Shutdown -----------
+
+// flushPersistence runs once the HTTP server has drained and the
+// background workers have stopped, so no more writes can arrive: it saves
+// a final snapshot, waits for backup uploads, and flushes and fsyncs the
+// AOF regardless of its fsync policy.
+func (s *KVServer) flushPersistence() {
+	if s.snapshots != nil {
+		if n, err := s.snapshots.save(s.store); err != nil {
+			slog.Error("shutdown: final snapshot failed", "err", err)
+		} else {
+			slog.Info("shutdown: saved final snapshot", "keys", n)
+		}
+	}
+	if s.backups != nil {
+		s.backups.pending.Wait()
+	}
+	if s.aof != nil {
+		if err := s.aof.close(); err != nil {
+			slog.Error("shutdown: closing aof failed", "err", err)
+		}
+	}
+}
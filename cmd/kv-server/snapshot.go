@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shubhamc1947/safemap/pkg/concurrentmap"
+)
+
+// ----------- Snapshots -----------
+
+// Snapshot file layout:
+//
+//	magic "KVSNAP2\n"
+//	per entry: uvarint len(key), key, uvarint len(value), value,
+//	           varint expires_at (Unix nanoseconds, 0 = no TTL),
+//	           uvarint number of metadata fields, then per field:
+//	           uvarint tag, uvarint len(field), field
+//	uvarint 0xFFFFFFFF end marker (no key is that long)
+//	4-byte big-endian CRC-32 (IEEE) of everything before it
+//
+// Unknown metadata tags are skipped. "KVSNAP1\n" files, whose entries have
+// no metadata, are still read. An encrypted snapshot is "KVENC1\n"
+// followed by the sealed image (see keyring).
+const (
+	snapshotMagic    = "KVSNAP2\n"
+	snapshotMagicV1  = "KVSNAP1\n"
+	snapshotEncMagic = "KVENC1\n"
+	snapshotEOF      = 0xFFFFFFFF
+)
+
+// Snapshot metadata tags.
+const (
+	snapshotTagOwner       = 1  // owning token ID, for quotas
+	snapshotTagContentType = 2  // raw value's Content-Type
+	snapshotTagVersion     = 3  // value version, decimal
+	snapshotTagCreatedAt   = 4  // key creation time, decimal Unix nanoseconds
+	snapshotTagUpdatedAt   = 5  // last write time, decimal Unix nanoseconds
+	snapshotTagLease       = 6  // hashed ID of the key's lease
+	snapshotTagFlags       = 7  // memcached client flags, decimal
+	snapshotTagSpill       = 8  // file of a spilled value, whose value is empty
+	snapshotTagSpillSize   = 9  // length of the spilled value, decimal
+	snapshotTagCodec       = 10 // "snappy" if the value is compressed
+	snapshotTagEncrypted   = 11 // "1" if the value is encrypted
+	snapshotTagSHA256      = 12 // SHA-256 of the value, hex
+	snapshotTagTags        = 13 // the key's tags, newline-separated
+)
+
+// snapshotAAD binds encrypted snapshots to their format.
+var snapshotAAD = []byte("kv-snapshot")
+
+var errBadSnapshot = errors.New("snapshot: corrupt file")
+
+type snapshotter struct {
+	path    string
+	keys    *keyring                                            // encrypts snapshots when set
+	backups *backups                                            // optional upload target
+	whole   func(key string, v StoredValue) (StoredValue, bool) // see KVServer.whole
+	mu      sync.Mutex                                          // one save at a time
+}
+
+// save writes a consistent snapshot of store to path, atomically
+// replacing the previous one. The store is only locked while it is copied
+// in memory; encoding and fsync happen afterwards. Collections are written
+// whole, with their elements as they are when encoded.
+func (sn *snapshotter) save(store *concurrentmap.ConcurrentMap[string, StoredValue]) (int, error) {
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+
+	start := time.Now()
+	entries := store.Snapshot()
+	kept := entries[:0]
+	for _, e := range entries {
+		if v, ok := sn.whole(e.Key, e.Value); ok {
+			kept = append(kept, concurrentmap.Entry[string, StoredValue]{Key: e.Key, Value: v})
+		}
+	}
+	entries = kept
+
+	tmpPath := sn.path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmpPath) // no-op after a successful rename
+
+	var n int
+	if sn.keys == nil {
+		n, err = writeSnapshot(f, entries, start)
+	} else {
+		// Sealed in one piece, always with the active key, so rotating
+		// keys takes effect at the next save.
+		var image bytes.Buffer
+		if n, err = writeSnapshot(&image, entries, start); err == nil {
+			_, err = f.Write(append([]byte(snapshotEncMagic), sn.keys.seal(image.Bytes(), snapshotAAD)...))
+		}
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmpPath, sn.path); err != nil {
+		return 0, err
+	}
+
+	if sn.backups != nil {
+		data, err := os.ReadFile(sn.path)
+		if err != nil {
+			return n, fmt.Errorf("read snapshot for backup: %w", err)
+		}
+		sn.backups.pending.Add(1)
+		go func() {
+			defer sn.backups.pending.Done()
+			sn.backups.upload(data, start)
+		}()
+	}
+	return n, nil
+}
+
+func writeSnapshot(w io.Writer, entries []concurrentmap.Entry[string, StoredValue], now time.Time) (int, error) {
+	crc := crc32.NewIEEE()
+	bw := bufio.NewWriterSize(io.MultiWriter(w, crc), 64<<10)
+
+	var buf [binary.MaxVarintLen64]byte
+	putUvarint := func(x uint64) {
+		bw.Write(buf[:binary.PutUvarint(buf[:], x)])
+	}
+
+	bw.WriteString(snapshotMagic)
+	n := 0
+	for _, e := range entries {
+		if e.Value.isExpired(now) {
+			continue
+		}
+		putUvarint(uint64(len(e.Key)))
+		bw.WriteString(e.Key)
+		putUvarint(uint64(len(e.Value.Data)))
+		bw.Write(e.Value.Data)
+
+		var expires int64
+		if e.Value.HasTTL {
+			expires = e.Value.ExpiresAt.UnixNano()
+		}
+		bw.Write(buf[:binary.PutVarint(buf[:], expires)])
+
+		meta := [...]struct {
+			tag   uint64
+			value string
+		}{
+			{snapshotTagOwner, e.Value.Owner},
+			{snapshotTagContentType, e.Value.ContentType},
+			{snapshotTagVersion, ""},
+			{snapshotTagCreatedAt, ""},
+			{snapshotTagUpdatedAt, ""},
+			{snapshotTagLease, e.Value.Lease},
+			{snapshotTagFlags, ""},
+			{snapshotTagSpill, e.Value.Spill},
+			{snapshotTagSpillSize, ""},
+			{snapshotTagCodec, ""},
+			{snapshotTagEncrypted, ""},
+			{snapshotTagSHA256, hex.EncodeToString(e.Value.SHA256)},
+			{snapshotTagTags, strings.Join(e.Value.Tags, "\n")},
+		}
+		if e.Value.Version != 0 {
+			meta[2].value = strconv.FormatUint(e.Value.Version, 10)
+		}
+		if !e.Value.CreatedAt.IsZero() {
+			meta[3].value = strconv.FormatInt(e.Value.CreatedAt.UnixNano(), 10)
+		}
+		if !e.Value.UpdatedAt.IsZero() {
+			meta[4].value = strconv.FormatInt(e.Value.UpdatedAt.UnixNano(), 10)
+		}
+		if e.Value.Flags != 0 {
+			meta[6].value = strconv.FormatUint(uint64(e.Value.Flags), 10)
+		}
+		if e.Value.Spill != "" {
+			meta[8].value = strconv.FormatInt(e.Value.SpillSize, 10)
+		}
+		if e.Value.Compressed {
+			meta[9].value = "snappy"
+		}
+		if e.Value.Encrypted {
+			meta[10].value = "1"
+		}
+		fields := 0
+		for _, m := range meta {
+			if m.value != "" {
+				fields++
+			}
+		}
+		putUvarint(uint64(fields))
+		for _, m := range meta {
+			if m.value != "" {
+				putUvarint(m.tag)
+				putUvarint(uint64(len(m.value)))
+				bw.WriteString(m.value)
+			}
+		}
+		n++
+	}
+	putUvarint(snapshotEOF)
+	if err := bw.Flush(); err != nil {
+		return 0, err
+	}
+
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	_, err := w.Write(sum[:])
+	return n, err
+}
+
+// load reads the snapshot at path into store. A missing file is not an
+// error. The checksum is verified before anything is stored, so a corrupt
+// file leaves the store untouched.
+func (sn *snapshotter) load(store *concurrentmap.ConcurrentMap[string, StoredValue]) (int, error) {
+	data, err := os.ReadFile(sn.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	entries, err := parseSnapshot(data, sn.keys)
+	if err != nil {
+		return 0, err
+	}
+	return storeSnapshotEntries(entries, store), nil
+}
+
+// parseSnapshot verifies and decodes a whole snapshot image, decrypting
+// it first if needed.
+func parseSnapshot(data []byte, keys *keyring) (map[string]StoredValue, error) {
+	if bytes.HasPrefix(data, []byte(snapshotEncMagic)) {
+		if keys == nil {
+			return nil, fmt.Errorf("snapshot is encrypted but no keys are configured")
+		}
+		plain, err := keys.open(data[len(snapshotEncMagic):], snapshotAAD)
+		if err != nil {
+			return nil, fmt.Errorf("decrypt snapshot: %w", err)
+		}
+		data = plain
+	}
+	if len(data) < len(snapshotMagic)+4 {
+		return nil, errBadSnapshot
+	}
+	magic := string(data[:len(snapshotMagic)])
+	if magic != snapshotMagic && magic != snapshotMagicV1 {
+		return nil, errBadSnapshot
+	}
+	body, sum := data[:len(data)-4], data[len(data)-4:]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(sum) {
+		return nil, fmt.Errorf("%w: checksum mismatch", errBadSnapshot)
+	}
+	return decodeSnapshot(body[len(snapshotMagic):], magic == snapshotMagic)
+}
+
+// storeSnapshotEntries stores the entries that have not expired yet.
+func storeSnapshotEntries(entries map[string]StoredValue, store *concurrentmap.ConcurrentMap[string, StoredValue]) int {
+	now := time.Now()
+	n := 0
+	for key, v := range entries {
+		if v.isExpired(now) {
+			continue
+		}
+		v.Accesses, v.LastRead = new(atomic.Int64), new(atomic.Int64)
+		store.Set(key, v)
+		n++
+	}
+	return n
+}
+
+func decodeSnapshot(b []byte, withMeta bool) (map[string]StoredValue, error) {
+	entries := make(map[string]StoredValue)
+	uvarint := func() (uint64, bool) {
+		x, n := binary.Uvarint(b)
+		if n <= 0 {
+			return 0, false
+		}
+		b = b[n:]
+		return x, true
+	}
+	bytesN := func(n uint64) ([]byte, bool) {
+		if n > uint64(len(b)) {
+			return nil, false
+		}
+		out := b[:n:n]
+		b = b[n:]
+		return out, true
+	}
+
+	for {
+		klen, ok := uvarint()
+		if !ok {
+			return nil, errBadSnapshot
+		}
+		if klen == snapshotEOF {
+			break
+		}
+		key, ok := bytesN(klen)
+		if !ok {
+			return nil, errBadSnapshot
+		}
+		vlen, ok := uvarint()
+		if !ok {
+			return nil, errBadSnapshot
+		}
+		val, ok := bytesN(vlen)
+		if !ok {
+			return nil, errBadSnapshot
+		}
+		expires, n := binary.Varint(b)
+		if n <= 0 {
+			return nil, errBadSnapshot
+		}
+		b = b[n:]
+
+		v := StoredValue{Data: val}
+		if expires != 0 {
+			v.HasTTL = true
+			v.ExpiresAt = time.Unix(0, expires)
+		}
+
+		var fields uint64
+		if withMeta {
+			if fields, ok = uvarint(); !ok {
+				return nil, errBadSnapshot
+			}
+		}
+		for ; fields > 0; fields-- {
+			tag, ok := uvarint()
+			if !ok {
+				return nil, errBadSnapshot
+			}
+			flen, ok := uvarint()
+			if !ok {
+				return nil, errBadSnapshot
+			}
+			field, ok := bytesN(flen)
+			if !ok {
+				return nil, errBadSnapshot
+			}
+			switch tag {
+			case snapshotTagOwner:
+				v.Owner = string(field)
+			case snapshotTagContentType:
+				v.ContentType = string(field)
+			case snapshotTagVersion:
+				v.Version, _ = strconv.ParseUint(string(field), 10, 64)
+			case snapshotTagCreatedAt:
+				n, _ := strconv.ParseInt(string(field), 10, 64)
+				v.CreatedAt = fromUnixNano(n)
+			case snapshotTagUpdatedAt:
+				n, _ := strconv.ParseInt(string(field), 10, 64)
+				v.UpdatedAt = fromUnixNano(n)
+			case snapshotTagLease:
+				v.Lease = string(field)
+			case snapshotTagFlags:
+				n, _ := strconv.ParseUint(string(field), 10, 32)
+				v.Flags = uint32(n)
+			case snapshotTagSpill:
+				v.Spill = string(field)
+			case snapshotTagSpillSize:
+				v.SpillSize, _ = strconv.ParseInt(string(field), 10, 64)
+			case snapshotTagCodec:
+				v.Compressed = string(field) == "snappy"
+			case snapshotTagEncrypted:
+				v.Encrypted = string(field) == "1"
+			case snapshotTagSHA256:
+				v.SHA256, _ = hex.DecodeString(string(field))
+			case snapshotTagTags:
+				v.Tags = strings.Split(string(field), "\n")
+			}
+		}
+		entries[string(key)] = v
+	}
+	if len(b) != 0 {
+		return nil, fmt.Errorf("%w: trailing data", errBadSnapshot)
+	}
+	return entries, nil
+}
+
+// run saves a snapshot every interval.
+func (sn *snapshotter) run(ctx context.Context, store *concurrentmap.ConcurrentMap[string, StoredValue], interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n, err := sn.save(store)
+		if err != nil {
+			slog.Error("snapshot: save failed", "err", err)
+			continue
+		}
+		slog.Info("snapshot: saved", "keys", n, "path", sn.path)
+	}
+}
+
+// Admin: POST /admin/snapshot saves a snapshot now.
+func (s *KVServer) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	if s.snapshots == nil {
+		writeError(w, r, http.StatusNotFound, codeFeatureDisabled, "snapshots disabled")
+		return
+	}
+
+	start := time.Now()
+	n, err := s.snapshots.save(s.store)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "snapshot failed: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"path":        s.snapshots.path,
+		"keys":        n,
+		"duration_ms": time.Since(start).Milliseconds(),
+	})
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/shubhamc1947/safemap/pkg/concurrentmap"
+)
+
+// ----------- Spilled Values -----------
+
+// With --spill-dir, a raw PUT body longer than --spill-threshold is
+// streamed to a file there instead of being read into memory, and GET
+// streams it back. The store keeps only the file's name (see
+// StoredValue.Spill), and so do the AOF and snapshots: back the directory
+// up with them. Files are written once and never changed; one is removed
+// when its value is replaced, deleted or expires.
+//
+// Other ways of reading a key (batches, transactions, the memcached,
+// binary and gRPC protocols, exports) read a spilled value into memory.
+// Watch events leave its content out.
+
+const spillPrefix = "v-"
+
+var errSpillDisabled = errors.New("value is spilled to disk, but --spill-dir is not set")
+
+type spillStore struct {
+	dir       string
+	threshold int64 // longest body kept in memory
+}
+
+func newSpillStore(store *concurrentmap.ConcurrentMap[string, StoredValue], dir string, threshold int64) (*spillStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	sp := &spillStore{dir: dir, threshold: threshold}
+	store.Subscribe(sp.track)
+	return sp, nil
+}
+
+// track removes the file of a value that is gone. It runs under the
+// store's bucket lock, so the removal happens in the background; a reader
+// that still has the file open keeps reading it.
+func (sp *spillStore) track(ev concurrentmap.Event[string, StoredValue]) {
+	if old := ev.OldValue.Spill; old != "" && old != ev.NewValue.Spill {
+		go sp.remove(old)
+	}
+}
+
+func (sp *spillStore) path(name string) (string, error) {
+	if name != filepath.Base(name) || !strings.HasPrefix(name, spillPrefix) {
+		return "", fmt.Errorf("invalid spill file name %q", name)
+	}
+	return filepath.Join(sp.dir, name), nil
+}
+
+// write stores head followed by the rest of r in a new file, synced to
+// disk, and returns its name and length.
+func (sp *spillStore) write(head []byte, r io.Reader) (string, int64, error) {
+	f, err := os.CreateTemp(sp.dir, spillPrefix+"*")
+	if err != nil {
+		return "", 0, err
+	}
+	n, err := f.Write(head)
+	if err == nil {
+		var rest int64
+		rest, err = io.Copy(f, r)
+		n += int(rest)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", 0, err
+	}
+	return filepath.Base(f.Name()), int64(n), nil
+}
+
+func (sp *spillStore) open(name string) (*os.File, error) {
+	path, err := sp.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (sp *spillStore) remove(name string) {
+	path, err := sp.path(name)
+	if err != nil {
+		return
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Warn("spill: remove file", "file", name, "err", err)
+	}
+}
+
+// sweep reconciles the directory with the store after persisted data is
+// loaded: keys whose file is gone (written before a crash, or restored
+// from elsewhere) are dropped, and files no key refers to, such as
+// uploads cut short, are removed.
+func (sp *spillStore) sweep(store *concurrentmap.ConcurrentMap[string, StoredValue]) {
+	used := make(map[string]bool)
+	for _, e := range store.Snapshot() {
+		if e.Value.Spill == "" {
+			continue
+		}
+		path, err := sp.path(e.Value.Spill)
+		if err == nil {
+			_, err = os.Stat(path)
+		}
+		if err != nil {
+			slog.Warn("spill: dropping key whose file is missing", "key", e.Key, "err", err)
+			store.Delete(e.Key)
+			continue
+		}
+		used[e.Value.Spill] = true
+	}
+
+	entries, err := os.ReadDir(sp.dir)
+	if err != nil {
+		slog.Warn("spill: read directory", "dir", sp.dir, "err", err)
+		return
+	}
+	removed := 0
+	for _, de := range entries {
+		if name := de.Name(); strings.HasPrefix(name, spillPrefix) && !used[name] {
+			sp.remove(name)
+			removed++
+		}
+	}
+	slog.Info("spill directory ready", "dir", sp.dir, "files", len(used), "orphans_removed", removed)
+}
+
+// discard removes the file of v, a spilled value that was never stored.
+func (s *KVServer) discard(v StoredValue) {
+	if v.Spill != "" && s.spill != nil {
+		s.spill.remove(v.Spill)
+	}
+}
+
+func (s *KVServer) openSpill(name string) (*os.File, error) {
+	if s.spill == nil {
+		return nil, errSpillDisabled
+	}
+	return s.spill.open(name)
+}
+
+// loadSpilled returns v with a spilled value read into Data.
+func (s *KVServer) loadSpilled(v StoredValue) (StoredValue, error) {
+	if v.Spill == "" {
+		return v, nil
+	}
+	f, err := s.openSpill(v.Spill)
+	if err != nil {
+		return v, err
+	}
+	defer f.Close()
+	data := make([]byte, v.SpillSize)
+	if _, err := io.ReadFull(f, data); err != nil {
+		return v, err
+	}
+	v.Data, v.Spill, v.SpillSize = data, "", 0
+	return v, nil
+}
+
+// loadSpilledResults fills in the spilled values of bulk results, which
+// are built under the store's locks without them.
+func (s *KVServer) loadSpilledResults(results []batchResult) {
+	for i := range results {
+		res := &results[i]
+		if res.spill == "" {
+			continue
+		}
+		v, err := s.loadSpilled(StoredValue{Spill: res.spill, SpillSize: res.spillSize})
+		if err != nil {
+			*res = failed(&statusError{http.StatusInternalServerError, codeInternal, "read spilled value: " + err.Error()})
+			continue
+		}
+		res.ValueBase64, res.spill = v.Data, ""
+	}
+}
+
+// readPutValue is readValue for PUT. With --spill-dir, a raw body longer
+// than the threshold is streamed to a file there instead.
+func (s *KVServer) readPutValue(w http.ResponseWriter, r *http.Request) (StoredValue, bool) {
+	if s.spill == nil || isEnvelopeType(r.Header.Get("Content-Type")) {
+		return s.readValue(w, r)
+	}
+	defer r.Body.Close()
+	stored := StoredValue{ContentType: r.Header.Get("Content-Type")}
+	want, ok := wantDigest(w, r)
+	if !ok || !ttlHeader(w, r, &stored) || !tagsHeader(w, r, &stored) {
+		return StoredValue{}, false
+	}
+
+	var body io.Reader = r.Body
+	if s.maxValueSize > 0 {
+		body = http.MaxBytesReader(w, r.Body, s.maxValueSize)
+	}
+	h := sha256.New()
+	body = io.TeeReader(body, h)
+	head, err := io.ReadAll(io.LimitReader(body, s.spill.threshold+1))
+	if err == nil && int64(len(head)) > s.spill.threshold {
+		stored.Spill, stored.SpillSize, err = s.spill.write(head, body)
+	} else {
+		stored.Data = head
+	}
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		var pathErr *fs.PathError
+		switch {
+		case errors.As(err, &tooLarge):
+			writeError(w, r, http.StatusRequestEntityTooLarge, codeValueTooLarge,
+				fmt.Sprintf("body exceeds the %d byte limit", tooLarge.Limit))
+		case errors.As(err, &pathErr):
+			slog.ErrorContext(r.Context(), "spill: write value", "err", err)
+			writeError(w, r, http.StatusInternalServerError, codeInternal, "could not store the value")
+		default:
+			writeError(w, r, http.StatusBadRequest, codeInvalidBody, "invalid body")
+		}
+		return StoredValue{}, false
+	}
+	sum := h.Sum(nil)
+	if !checkDigest(w, r, want, sum) {
+		s.discard(stored)
+		return StoredValue{}, false
+	}
+	if stored.Spill != "" {
+		stored.SHA256 = sum
+	}
+	return stored, true
+}
+
+// openSpilled opens the file of value, spilled at key, for GET. If the
+// value was replaced since it was looked up, the current one is looked up
+// again; it is returned with a nil file if it is not spilled. On failure
+// the error response is written.
+func (s *KVServer) openSpilled(w http.ResponseWriter, r *http.Request, key string, ns *namespace, value StoredValue) (StoredValue, *os.File, bool) {
+	f, err := s.openSpill(value.Spill)
+	if errors.Is(err, fs.ErrNotExist) {
+		var ok bool
+		if value, ok = s.lookup(w, r, key, ns); !ok {
+			return value, nil, false
+		}
+		if value.Spill == "" {
+			return value, nil, true
+		}
+		f, err = s.openSpill(value.Spill)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "spill: open value", "key", key, "err", err)
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "could not read the value")
+		return value, nil, false
+	}
+	return value, f, true
+}
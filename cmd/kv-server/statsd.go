@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ----------- StatsD Sink -----------
+
+// statsdSink pushes metrics over UDP in the StatsD line protocol, with
+// DogStatsD tags (|#k:v,...) understood by Datadog and Telegraf. Lines
+// are queued and packed into datagrams by run; when the queue is full
+// they are dropped so metrics never slow requests down.
+type statsdSink struct {
+	conn   net.Conn
+	prefix string
+	tags   string // "k:v,k2:v2" applied to every metric
+	lines  chan string
+
+	dropped atomic.Int64
+}
+
+const (
+	statsdMaxPacket     = 1432 // fits a typical MTU
+	statsdFlushInterval = time.Second
+)
+
+func newStatsdSink(addr, prefix, tags string) (*statsdSink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	return &statsdSink{
+		conn:   conn,
+		prefix: prefix,
+		tags:   strings.Trim(tags, ", "),
+		lines:  make(chan string, 4096),
+	}, nil
+}
+
+func (s *statsdSink) count(name string, n int64, tags ...string) {
+	s.send(name, strconv.FormatInt(n, 10), "c", tags)
+}
+
+func (s *statsdSink) gauge(name string, v int64, tags ...string) {
+	s.send(name, strconv.FormatInt(v, 10), "g", tags)
+}
+
+func (s *statsdSink) timing(name string, d time.Duration, tags ...string) {
+	s.send(name, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64), "ms", tags)
+}
+
+func (s *statsdSink) send(name, value, typ string, tags []string) {
+	line := s.prefix + name + ":" + value + "|" + typ
+	if s.tags != "" || len(tags) > 0 {
+		all := tags
+		if s.tags != "" {
+			all = append([]string{s.tags}, tags...)
+		}
+		line += "|#" + strings.Join(all, ",")
+	}
+	select {
+	case s.lines <- line:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// run packs queued lines into datagrams, flushing when one is full and
+// once per statsdFlushInterval, and reports gauges from gauges each time.
+func (s *statsdSink) run(ctx context.Context, gauges func(s *statsdSink)) {
+	ticker := time.NewTicker(statsdFlushInterval)
+	defer ticker.Stop()
+	defer s.conn.Close()
+
+	var buf bytes.Buffer
+	flush := func() {
+		if buf.Len() == 0 {
+			return
+		}
+		// Best effort: a missing agent shows up as ECONNREFUSED here.
+		if _, err := s.conn.Write(buf.Bytes()); err != nil {
+			slog.Debug("statsd: write failed", "err", err)
+		}
+		buf.Reset()
+	}
+	add := func(line string) {
+		if buf.Len() > 0 && buf.Len()+1+len(line) > statsdMaxPacket {
+			flush()
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(line)
+	}
+
+	for {
+		select {
+		case line := <-s.lines:
+			add(line)
+		case <-ticker.C:
+			gauges(s)
+			if n := s.dropped.Swap(0); n > 0 {
+				s.count("statsd.dropped", n)
+			}
+		drain:
+			for {
+				select {
+				case line := <-s.lines:
+					add(line)
+				default:
+					break drain
+				}
+			}
+			flush()
+		case <-ctx.Done():
+			for len(s.lines) > 0 {
+				add(<-s.lines)
+			}
+			flush()
+			return
+		}
+	}
+}
+
+// statsdTag formats a DogStatsD tag.
+func statsdTag(k string, v any) string {
+	return fmt.Sprintf("%s:%v", k, v)
+}
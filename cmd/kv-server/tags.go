@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/shubhamc1947/safemap/pkg/concurrentmap"
+)
+
+// ----------- Key Tags -----------
+
+// A PUT can label its key with tags, "tags": ["session", "user:42"] in the
+// envelope or X-Tags: session, user:42 for a raw body, and later list or
+// delete every key with a tag:
+//
+//	GET    /tags/{tag}    (or /v1/{ns}/tags/{tag})
+//	DELETE /tags/{tag}
+//
+// Tags belong to the value: a PUT replaces them, one without tags clears
+// them, and edits in place (append, incr, JSON patches) keep them. They
+// are persisted with the value; the index from tags to keys is kept in
+// memory, following the store's events.
+
+const (
+	maxKeyTags   = 32
+	maxTagLength = 256
+)
+
+var errInvalidTag = fmt.Errorf("tags must be 1-%d bytes of UTF-8 without control characters", maxTagLength)
+
+// checkTags validates the tags of a PUT and returns them without
+// duplicates, nil for none.
+func checkTags(tags []string) ([]string, error) {
+	if len(tags) > maxKeyTags {
+		return nil, fmt.Errorf("a key may have at most %d tags", maxKeyTags)
+	}
+	var out []string
+	for _, tag := range tags {
+		if tag == "" || len(tag) > maxTagLength || !utf8.ValidString(tag) || strings.ContainsFunc(tag, unicode.IsControl) {
+			return nil, errInvalidTag
+		}
+		if !slices.Contains(out, tag) {
+			out = append(out, tag)
+		}
+	}
+	return out, nil
+}
+
+// tagsHeader sets the tags of a raw value from X-Tags, a comma-separated
+// list, writing the error response if it is invalid.
+func tagsHeader(w http.ResponseWriter, r *http.Request, v *StoredValue) bool {
+	h := r.Header.Get("X-Tags")
+	if h == "" {
+		return true
+	}
+	tags := strings.Split(h, ",")
+	for i := range tags {
+		tags[i] = strings.TrimSpace(tags[i])
+	}
+	var err error
+	if v.Tags, err = checkTags(tags); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "invalid X-Tags: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// tagIndex maps "<ns>\x00<tag>" to the keys of namespace ns ("" for the
+// flat keyspace) with that tag.
+type tagIndex struct {
+	keys *concurrentmap.SetMap[string, string]
+}
+
+func newTagIndex(store *concurrentmap.ConcurrentMap[string, StoredValue]) *tagIndex {
+	t := &tagIndex{keys: concurrentmap.NewStringSetMap[string](64)}
+	store.Subscribe(t.track)
+	return t
+}
+
+// track keeps the index current. It runs under the store's bucket lock,
+// so it only touches the index, which has locks of its own.
+func (t *tagIndex) track(ev concurrentmap.Event[string, StoredValue]) {
+	ns, key, ok := splitStoreKey(ev.Key)
+	if !ok {
+		ns, key = "", ev.Key
+	}
+	var kept []string
+	if ev.Type == concurrentmap.EventInsert || ev.Type == concurrentmap.EventUpdate {
+		kept = ev.NewValue.Tags
+	}
+	if ev.Type != concurrentmap.EventInsert {
+		for _, tag := range ev.OldValue.Tags {
+			if !slices.Contains(kept, tag) {
+				t.keys.Remove(ns+"\x00"+tag, key)
+			}
+		}
+	}
+	for _, tag := range kept {
+		t.keys.Add(ns+"\x00"+tag, key)
+	}
+}
+
+// lookup returns the keys of namespace ns with tag, sorted.
+func (t *tagIndex) lookup(ns, tag string) []string {
+	keys := t.keys.Members(ns + "\x00" + tag)
+	slices.Sort(keys)
+	return keys
+}
+
+// isTagsPath reports whether path is under /tags/ or /v1/{ns}/tags/.
+func isTagsPath(path string) bool {
+	_, rest, ok := nsPath(path)
+	return strings.HasPrefix(path, "/tags/") || ok && strings.HasPrefix(rest, "/tags/")
+}
+
+// Tags: GET /tags/{tag}?limit=100&cursor=... lists the live keys with the
+// tag, in key order, a page at a time:
+//
+//	{"keys": ["s:1", "s:2"], "cursor": ""}
+//
+// Pass a non-empty cursor back for the next page. With --acl, keys the
+// caller may not read are left out.
+func (s *KVServer) handleListTag(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := defaultListLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxListLimit {
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, "limit must be 1-"+strconv.Itoa(maxListLimit))
+			return
+		}
+		limit = n
+	}
+	after, err := base64.RawURLEncoding.DecodeString(q.Get("cursor"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "invalid cursor")
+		return
+	}
+
+	p, _ := principalFrom(r.Context())
+	nsName := r.PathValue("ns")
+	ns, serr := s.resolveNamespace(p, nsName)
+	if serr != nil {
+		serr.write(w, r)
+		return
+	}
+
+	_, sp := s.tracer.start(r.Context(), "tags.lookup", spanKindInternal)
+	matches := s.tags.lookup(nsName, r.PathValue("tag"))
+	sp.setAttr("keys", len(matches))
+	sp.finish()
+
+	now := time.Now()
+	keys := []string{}
+	cursor := ""
+	start, found := slices.BinarySearch(matches, string(after))
+	if found {
+		start++
+	}
+	for _, key := range matches[start:] {
+		if len(keys) == limit {
+			cursor = base64.RawURLEncoding.EncodeToString([]byte(keys[len(keys)-1]))
+			break
+		}
+		storeKey := key
+		if ns != nil {
+			storeKey = ns.storeKey(key)
+		}
+		if v, ok := s.store.Get(storeKey); !ok || v.isExpired(now) {
+			continue
+		}
+		if s.aclEnforced && !p.has(scopeAdmin) && !s.acl.allowed(p.Name, aclKey(nsName, key), scopeRead) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"keys": keys, "cursor": cursor})
+}
+
+// errTagRemoved stops a tag delete of a key that lost the tag, or
+// expired, meanwhile.
+var errTagRemoved = errors.New("tag removed")
+
+// Tags: DELETE /tags/{tag} deletes every key with the tag. A key retagged
+// while the delete runs is kept. With --acl, keys the caller may not
+// write are kept too.
+//
+//	{"deleted": 42}
+func (s *KVServer) handleDeleteTag(w http.ResponseWriter, r *http.Request) {
+	p, _ := principalFrom(r.Context())
+	nsName := r.PathValue("ns")
+	ns, serr := s.resolveNamespace(p, nsName)
+	if serr != nil {
+		serr.write(w, r)
+		return
+	}
+	tag := r.PathValue("tag")
+
+	_, sp := s.tracer.start(r.Context(), "store.delete_tag", spanKindInternal)
+	deleted := 0
+	for _, key := range s.tags.lookup(nsName, tag) {
+		if s.aclEnforced && !p.has(scopeAdmin) && !s.acl.allowed(p.Name, aclKey(nsName, key), scopeWrite) {
+			continue
+		}
+		storeKey := key
+		if ns != nil {
+			storeKey = ns.storeKey(key)
+		}
+		err := s.store.ComputeErr(storeKey, func(old StoredValue, exists bool) (StoredValue, bool, error) {
+			if !exists || old.isExpired(time.Now()) || !slices.Contains(old.Tags, tag) {
+				return old, exists, errTagRemoved
+			}
+			return old, false, nil
+		})
+		if err == nil {
+			deleted++
+		}
+	}
+	sp.setAttr("deleted", deleted)
+	sp.finish()
+
+	s.metrics.TotalDeletes.Add(int64(deleted))
+	if ns != nil {
+		ns.stats.deletes.Add(int64(deleted))
+	}
+	if !s.persist(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]int{"deleted": deleted})
+}
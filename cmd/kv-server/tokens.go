@@ -0,0 +1,233 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/shubhamc1947/safemap/pkg/concurrentmap"
+)
+
+// ----------- API Token Registry -----------
+
+// tokenKeyPrefix reserves the keyspace holding API tokens. Tokens live in
+// the store, so they are persisted and restored with the data.
+const tokenKeyPrefix = "__tokens/"
+
+// tokenRecord describes an API token. Only a SHA-256 hash of the secret
+// is kept; the secret itself is shown once, when the token is created.
+type tokenRecord struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	quota
+	CreatedAt time.Time  `json:"created_at"`
+	LastUsed  *time.Time `json:"last_used,omitempty"`
+}
+
+// tokenTouchInterval limits how often last-used times are written back,
+// so authenticating does not turn every read into a store write.
+const tokenTouchInterval = time.Minute
+
+var errInvalidScope = errors.New("scopes must be read, write or admin")
+
+type tokenRegistry struct {
+	store *concurrentmap.ConcurrentMap[string, StoredValue]
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// create registers a new token and returns its secret.
+func (t *tokenRegistry) create(name string, scopes []string, q quota) (string, tokenRecord, error) {
+	if err := q.validate(); err != nil {
+		return "", tokenRecord{}, err
+	}
+	for _, sc := range scopes {
+		if !validScope(sc) {
+			return "", tokenRecord{}, errInvalidScope
+		}
+	}
+	if len(scopes) == 0 {
+		return "", tokenRecord{}, errInvalidScope
+	}
+
+	var id [6]byte
+	var secret [32]byte
+	_, _ = rand.Read(id[:])
+	_, _ = rand.Read(secret[:])
+	rec := tokenRecord{
+		ID:        hex.EncodeToString(id[:]),
+		Name:      name,
+		Scopes:    slices.Compact(slices.Sorted(slices.Values(scopes))),
+		quota:     q,
+		CreatedAt: time.Now().UTC(),
+	}
+	plain := "kv_" + base64.RawURLEncoding.EncodeToString(secret[:])
+
+	data, _ := json.Marshal(rec)
+	t.store.Set(tokenKeyPrefix+hashToken(plain), StoredValue{Data: data})
+	return plain, rec, nil
+}
+
+// lookup returns the token for secret and records that it was used.
+func (t *tokenRegistry) lookup(secret string) (tokenRecord, bool) {
+	key := tokenKeyPrefix + hashToken(secret)
+	v, ok := t.store.Get(key)
+	if !ok {
+		return tokenRecord{}, false
+	}
+	var rec tokenRecord
+	if json.Unmarshal(v.Data, &rec) != nil {
+		return tokenRecord{}, false
+	}
+
+	now := time.Now().UTC()
+	if rec.LastUsed == nil || now.Sub(*rec.LastUsed) >= tokenTouchInterval {
+		t.store.Compute(key, func(old StoredValue, exists bool) (StoredValue, bool) {
+			var cur tokenRecord
+			if !exists || json.Unmarshal(old.Data, &cur) != nil {
+				return old, exists // revoked meanwhile
+			}
+			cur.LastUsed = &now
+			data, _ := json.Marshal(cur)
+			return StoredValue{Data: data}, true
+		})
+	}
+	return rec, true
+}
+
+func (t *tokenRegistry) list() []tokenRecord {
+	var out []tokenRecord
+	t.store.Range(func(key string, v StoredValue) bool {
+		if strings.HasPrefix(key, tokenKeyPrefix) {
+			var rec tokenRecord
+			if json.Unmarshal(v.Data, &rec) == nil {
+				out = append(out, rec)
+			}
+		}
+		return true
+	})
+	slices.SortFunc(out, func(a, b tokenRecord) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return out
+}
+
+// find returns the store key of the token with the given ID.
+func (t *tokenRegistry) find(id string) (string, bool) {
+	var key string
+	t.store.Range(func(k string, v StoredValue) bool {
+		var rec tokenRecord
+		if strings.HasPrefix(k, tokenKeyPrefix) && json.Unmarshal(v.Data, &rec) == nil && rec.ID == id {
+			key = k
+			return false
+		}
+		return true
+	})
+	return key, key != ""
+}
+
+// revoke deletes the token with the given ID.
+func (t *tokenRegistry) revoke(id string) bool {
+	key, ok := t.find(id)
+	return ok && t.store.DeleteErr(key) == nil
+}
+
+// setQuota changes a token's quota. It applies from the token's next
+// request.
+func (t *tokenRegistry) setQuota(id string, q quota) (tokenRecord, bool) {
+	key, ok := t.find(id)
+	if !ok {
+		return tokenRecord{}, false
+	}
+	var rec tokenRecord
+	t.store.Compute(key, func(old StoredValue, exists bool) (StoredValue, bool) {
+		if !exists || json.Unmarshal(old.Data, &rec) != nil {
+			ok = false
+			return old, exists // revoked meanwhile
+		}
+		rec.quota = q
+		data, _ := json.Marshal(rec)
+		return StoredValue{Data: data}, true
+	})
+	return rec, ok
+}
+
+// Admin: GET /admin/tokens lists tokens (never their secrets).
+func (s *KVServer) handleListTokens(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"tokens": s.tokens.list()})
+}
+
+// Admin: POST /admin/tokens {"name": "...", "scopes": ["read", "write"],
+// "max_keys": 1000, "max_bytes": 1048576} creates a token. The response
+// holds the secret, which cannot be retrieved again.
+func (s *KVServer) handleCreateToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+		quota
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidBody, "invalid body: "+err.Error())
+		return
+	}
+	secret, rec, err := s.tokens.create(req.Name, req.Scopes, req.quota)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+	if !s.persist(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(struct {
+		Token string `json:"token"`
+		tokenRecord
+	}{secret, rec})
+}
+
+// Admin: PATCH /admin/tokens/{id} {"max_keys": ..., "max_bytes": ...}
+// replaces a token's quota.
+func (s *KVServer) handleSetTokenQuota(w http.ResponseWriter, r *http.Request) {
+	var q quota
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&q); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidBody, "invalid body: "+err.Error())
+		return
+	}
+	if err := q.validate(); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+	rec, ok := s.tokens.setQuota(r.PathValue("id"), q)
+	if !ok {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "no such token")
+		return
+	}
+	if !s.persist(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(rec)
+}
+
+// Admin: DELETE /admin/tokens/{id} revokes a token.
+func (s *KVServer) handleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	if !s.tokens.revoke(r.PathValue("id")) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "no such token")
+		return
+	}
+	if !s.persist(w, r) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ----------- Tracing -----------
+
+// A small OpenTelemetry-compatible tracer: W3C traceparent propagation,
+// spans for HTTP requests and store operations, and export to an OTLP
+// collector over HTTP/JSON. It stays within the standard library, like
+// the rest of the server.
+
+// spanContext identifies a span; it travels in the request context and
+// in traceparent headers.
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+}
+
+type spanContextKey struct{}
+
+func spanContextFrom(ctx context.Context) (spanContext, bool) {
+	sc, ok := ctx.Value(spanContextKey{}).(spanContext)
+	return sc, ok
+}
+
+// parseTraceparent parses a W3C traceparent header:
+// version-traceid-parentid-flags, e.g. 00-<32 hex>-<16 hex>-01.
+func parseTraceparent(h string) (spanContext, bool) {
+	var sc spanContext
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.traceID[:], []byte(parts[1])); err != nil || sc.traceID == [16]byte{} {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.spanID[:], []byte(parts[2])); err != nil || sc.spanID == [8]byte{} {
+		return sc, false
+	}
+	sc.sampled = flags[0]&1 == 1
+	return sc, true
+}
+
+// OTLP span kinds.
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+)
+
+type span struct {
+	tracer *tracer
+	sc     spanContext
+	parent [8]byte
+	name   string
+	kind   int
+	start  time.Time
+	end    time.Time
+	attrs  []otlpKeyValue
+	errMsg string
+}
+
+// tracer creates spans. A nil *tracer is valid and traces nothing, so
+// call sites need no checks when tracing is off.
+type tracer struct {
+	sampleRatio float64
+	exporter    *otlpExporter
+}
+
+// start begins a span as a child of the span in ctx, or a new trace.
+// Root spans are sampled at sampleRatio; children follow their parent.
+func (t *tracer) start(ctx context.Context, name string, kind int) (context.Context, *span) {
+	if t == nil {
+		return ctx, nil
+	}
+	sp := &span{tracer: t, name: name, kind: kind, start: time.Now()}
+	if parent, ok := spanContextFrom(ctx); ok {
+		sp.sc.traceID, sp.parent, sp.sc.sampled = parent.traceID, parent.spanID, parent.sampled
+	} else {
+		_, _ = rand.Read(sp.sc.traceID[:])
+		sp.sc.sampled = t.sampleRatio >= 1 ||
+			float64(binary.BigEndian.Uint64(sp.sc.traceID[8:])) < t.sampleRatio*math.MaxUint64
+	}
+	_, _ = rand.Read(sp.sc.spanID[:])
+	return context.WithValue(ctx, spanContextKey{}, sp.sc), sp
+}
+
+func (sp *span) setAttr(key string, value any) {
+	if sp == nil {
+		return
+	}
+	sp.attrs = append(sp.attrs, otlpAttr(key, value))
+}
+
+func (sp *span) setError(msg string) {
+	if sp != nil {
+		sp.errMsg = msg
+	}
+}
+
+// finish ends the span and queues it for export if it is sampled.
+func (sp *span) finish() {
+	if sp == nil || !sp.sc.sampled {
+		return
+	}
+	sp.end = time.Now()
+	sp.tracer.exporter.enqueue(sp)
+}
+
+// tracingMiddleware starts a server span per request, continuing the
+// caller's trace when a valid traceparent header is present.
+func (s *KVServer) tracingMiddleware(next http.Handler) http.Handler {
+	if s.tracer == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if parent, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+			ctx = context.WithValue(ctx, spanContextKey{}, parent)
+		}
+		route := httpRoute(r)
+		ctx, sp := s.tracer.start(ctx, r.Method+" "+route, spanKindServer)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		sp.setAttr("http.request.method", r.Method)
+		sp.setAttr("http.route", route)
+		sp.setAttr("url.path", r.URL.Path)
+		sp.setAttr("http.response.status_code", rec.status)
+		sp.setAttr("client.address", s.clientIP(r))
+		if id := requestIDFrom(ctx); id != "" {
+			sp.setAttr("http.request.id", id)
+		}
+		if rec.status >= 500 {
+			sp.setError(http.StatusText(rec.status))
+		}
+		sp.finish()
+	})
+}
+
+// httpRoute returns the route template for r, keeping key names out of
+// span names.
+func httpRoute(r *http.Request) string {
+	if ns, key, ok := kvPath(r.URL.Path); ok {
+		route := "/kv/{key}"
+		if isBatchRequest(r) {
+			route = "/kv/" + batchKey
+		} else if _, action := splitKeyAction(key); r.Method == http.MethodPost && keyActions[action] != nil {
+			route += "/" + action
+		} else if r.Method == http.MethodPatch && action == "json" {
+			route += "/json"
+		} else if query := keyQuery(r); query != "" {
+			route += "/" + query
+		}
+		if ns != "" {
+			return "/v1/{namespace}" + route
+		}
+		return route
+	}
+	for _, kind := range []string{"hll", "lists", "sets", "zsets"} {
+		if ns, key, ok := typedPath(r.URL.Path, kind); ok {
+			_, op := splitKeyAction(key)
+			if ns != "" {
+				return "/v1/{namespace}/" + kind + "/{key}/" + op
+			}
+			return "/" + kind + "/{key}/" + op
+		}
+	}
+	if isTagsPath(r.URL.Path) {
+		if strings.HasPrefix(r.URL.Path, "/v1/") {
+			return "/v1/{namespace}/tags/{tag}"
+		}
+		return "/tags/{tag}"
+	}
+	if _, rest, ok := nsPath(r.URL.Path); ok {
+		return "/v1/{namespace}" + rest
+	}
+	if isLocksPath(r.URL.Path) {
+		if strings.HasSuffix(r.URL.Path, "/renew") {
+			return "/locks/{name}/renew"
+		}
+		return "/locks/{name}"
+	}
+	if isPubSubPath(r.URL.Path) {
+		if strings.HasPrefix(r.URL.Path, "/publish/") {
+			return "/publish/{channel}"
+		}
+		return "/subscribe/{channel}"
+	}
+	if strings.HasPrefix(r.URL.Path, "/leases/") {
+		if strings.HasSuffix(r.URL.Path, "/keepalive") {
+			return "/leases/{id}/keepalive"
+		}
+		return "/leases/{id}"
+	}
+	return r.URL.Path
+}
+
+// ----------- OTLP Export -----------
+
+// otlpExporter sends finished spans, and the server counters, to an OTLP
+// collector's HTTP endpoint (/v1/traces and /v1/metrics) as JSON.
+type otlpExporter struct {
+	endpoint string // base URL, e.g. http://collector:4318
+	headers  map[string]string
+	resource otlpResource
+	client   *http.Client
+
+	spans   chan *span
+	dropped atomic.Int64
+	started time.Time
+}
+
+const (
+	otlpBatchSize      = 512
+	otlpTraceInterval  = 5 * time.Second
+	otlpMetricInterval = 30 * time.Second
+)
+
+// newOTLPExporter configures an exporter for endpoint. Extra request
+// headers come from OTEL_EXPORTER_OTLP_HEADERS (k=v,k2=v2) and the
+// service name from OTEL_SERVICE_NAME.
+func newOTLPExporter(endpoint string) (*otlpExporter, error) {
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		return nil, fmt.Errorf("otlp endpoint %q must be an http:// or https:// URL", endpoint)
+	}
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		if k, v, ok := strings.Cut(pair, "="); ok {
+			headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	service := os.Getenv("OTEL_SERVICE_NAME")
+	if service == "" {
+		service = "kv-server"
+	}
+	return &otlpExporter{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		headers:  headers,
+		resource: otlpResource{Attributes: []otlpKeyValue{otlpAttr("service.name", service)}},
+		client:   &http.Client{Timeout: 10 * time.Second},
+		spans:    make(chan *span, 8*otlpBatchSize),
+		started:  time.Now(),
+	}, nil
+}
+
+// enqueue hands sp to the export loop. Spans are dropped rather than
+// slowing requests down when the collector falls behind.
+func (e *otlpExporter) enqueue(sp *span) {
+	select {
+	case e.spans <- sp:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+// run exports spans in batches and metrics periodically until ctx is
+// done, then flushes what is left.
+func (e *otlpExporter) run(ctx context.Context, metrics func() map[string]int64) {
+	traceTicker := time.NewTicker(otlpTraceInterval)
+	defer traceTicker.Stop()
+	metricTicker := time.NewTicker(otlpMetricInterval)
+	defer metricTicker.Stop()
+
+	batch := make([]*span, 0, otlpBatchSize)
+	flush := func(ctx context.Context) {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.exportSpans(ctx, batch); err != nil {
+			slog.Warn("otlp: export spans failed", "spans", len(batch), "err", err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case sp := <-e.spans:
+			if batch = append(batch, sp); len(batch) == otlpBatchSize {
+				flush(ctx)
+			}
+		case <-traceTicker.C:
+			flush(ctx)
+		case <-metricTicker.C:
+			if err := e.exportMetrics(ctx, metrics()); err != nil {
+				slog.Warn("otlp: export metrics failed", "err", err)
+			}
+		case <-ctx.Done():
+			final, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			for len(e.spans) > 0 {
+				if batch = append(batch, <-e.spans); len(batch) == otlpBatchSize {
+					flush(final)
+				}
+			}
+			flush(final)
+			_ = e.exportMetrics(final, metrics())
+			if n := e.dropped.Load(); n > 0 {
+				slog.Warn("otlp: spans dropped because the queue was full", "spans", n)
+			}
+			return
+		}
+	}
+}
+
+// OTLP/JSON payloads. IDs are hex and 64-bit integers are decimal
+// strings, as the OTLP JSON encoding specifies.
+type (
+	otlpKeyValue struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string `json:"stringValue,omitempty"`
+		IntValue    *string `json:"intValue,omitempty"`
+		BoolValue   *bool   `json:"boolValue,omitempty"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            *otlpStatus    `json:"status,omitempty"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"` // 2 = error
+		Message string `json:"message,omitempty"`
+	}
+)
+
+func otlpAttr(key string, value any) otlpKeyValue {
+	kv := otlpKeyValue{Key: key}
+	switch v := value.(type) {
+	case int:
+		s := strconv.Itoa(v)
+		kv.Value.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		kv.Value.IntValue = &s
+	case bool:
+		kv.Value.BoolValue = &v
+	default:
+		s := fmt.Sprint(v)
+		kv.Value.StringValue = &s
+	}
+	return kv
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func (e *otlpExporter) exportSpans(ctx context.Context, spans []*span) error {
+	out := make([]otlpSpan, len(spans))
+	for i, sp := range spans {
+		out[i] = otlpSpan{
+			TraceID:           hex.EncodeToString(sp.sc.traceID[:]),
+			SpanID:            hex.EncodeToString(sp.sc.spanID[:]),
+			Name:              sp.name,
+			Kind:              sp.kind,
+			StartTimeUnixNano: unixNano(sp.start),
+			EndTimeUnixNano:   unixNano(sp.end),
+			Attributes:        sp.attrs,
+		}
+		if sp.parent != [8]byte{} {
+			out[i].ParentSpanID = hex.EncodeToString(sp.parent[:])
+		}
+		if sp.errMsg != "" {
+			out[i].Status = &otlpStatus{Code: 2, Message: sp.errMsg}
+		}
+	}
+	return e.post(ctx, "/v1/traces", map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": e.resource,
+			"scopeSpans": []any{map[string]any{
+				"scope": otlpScope{Name: "kv-server"},
+				"spans": out,
+			}},
+		}},
+	})
+}
+
+// exportMetrics sends counters as cumulative monotonic sums.
+func (e *otlpExporter) exportMetrics(ctx context.Context, counters map[string]int64) error {
+	now, start := unixNano(time.Now()), unixNano(e.started)
+	metrics := make([]any, 0, len(counters))
+	for name, v := range counters {
+		metrics = append(metrics, map[string]any{
+			"name": "kv." + name,
+			"sum": map[string]any{
+				"aggregationTemporality": 2, // cumulative
+				"isMonotonic":            true,
+				"dataPoints": []any{map[string]any{
+					"asInt":             strconv.FormatInt(v, 10),
+					"startTimeUnixNano": start,
+					"timeUnixNano":      now,
+				}},
+			},
+		})
+	}
+	return e.post(ctx, "/v1/metrics", map[string]any{
+		"resourceMetrics": []any{map[string]any{
+			"resource": e.resource,
+			"scopeMetrics": []any{map[string]any{
+				"scope":   otlpScope{Name: "kv-server"},
+				"metrics": metrics,
+			}},
+		}},
+	})
+}
+
+func (e *otlpExporter) post(ctx context.Context, path string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s: %s", path, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ----------- TTL Management -----------
+
+// maxTTLSeconds caps a TTL given in seconds, at about 100 years: a
+// time.Duration of seconds wraps around past about 292 years, which
+// would set an expiry in the past.
+const maxTTLSeconds = 100 * 365 * 24 * 60 * 60
+
+// ttlDuration returns a TTL of n seconds, as every protocol takes them,
+// and whether it is valid: 0 (none) to maxTTLSeconds.
+func ttlDuration(n int64) (time.Duration, bool) {
+	if n < 0 || n > maxTTLSeconds {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// ttlRangeMessage is the error message for a TTL field that ttlDuration
+// rejects.
+func ttlRangeMessage(field string) string {
+	return fmt.Sprintf("%s must be 0-%d", field, maxTTLSeconds)
+}
+
+// ttlResponse reports a key's TTL. TTLSeconds is -1 for keys that do not
+// expire, as in Redis.
+type ttlResponse struct {
+	TTLSeconds int        `json:"ttl_seconds"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+func newTTLResponse(v StoredValue) ttlResponse {
+	if !v.HasTTL {
+		return ttlResponse{TTLSeconds: -1}
+	}
+	return ttlResponse{TTLSeconds: max(0, ceilSeconds(time.Until(v.ExpiresAt))), ExpiresAt: &v.ExpiresAt}
+}
+
+// applyTTLPolicy gives a new value without a TTL the namespace's default
+// TTL, or else --default-ttl, then caps its TTL at --max-ttl. Under
+// --max-ttl no value is left without one.
+func (s *KVServer) applyTTLPolicy(ns *namespace, v *StoredValue, now time.Time) {
+	if !v.HasTTL {
+		switch {
+		case ns != nil && ns.DefaultTTLSeconds > 0:
+			ttl, _ := ttlDuration(ns.DefaultTTLSeconds)
+			v.HasTTL, v.ExpiresAt = true, now.Add(ttl)
+		case s.defaultTTL > 0:
+			v.HasTTL, v.ExpiresAt = true, now.Add(s.defaultTTL)
+		}
+	}
+	s.capTTL(v, now)
+}
+
+// capTTL enforces --max-ttl on v.
+func (s *KVServer) capTTL(v *StoredValue, now time.Time) {
+	if limit := now.Add(s.maxTTL); s.maxTTL > 0 && (!v.HasTTL || v.ExpiresAt.After(limit)) {
+		v.HasTTL, v.ExpiresAt = true, limit
+	}
+}
+
+// TTL: GET /kv/{key}/ttl returns the whole seconds left before the key
+// expires: {"ttl_seconds": 42, "expires_at": "..."}, or -1 without a TTL.
+func (s *KVServer) handleTTL(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+	value, ok := s.lookup(w, r, key, ns)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(newTTLResponse(value))
+}
+
+// EXPIRE: POST /kv/{key}/expire {"ttl_seconds": 60} makes an existing
+// key expire ttl_seconds from now, replacing any TTL it had.
+func (s *KVServer) handleExpire(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+	var req struct {
+		TTLSeconds int64 `json:"ttl_seconds"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidBody, "invalid body: "+err.Error())
+		return
+	}
+	ttl, ok := ttlDuration(req.TTLSeconds)
+	if !ok || ttl == 0 {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("ttl_seconds must be 1-%d", maxTTLSeconds))
+		return
+	}
+	expiresAt := time.Now().Add(ttl)
+	s.setTTL(w, r, key, func(v *StoredValue) {
+		v.HasTTL, v.ExpiresAt = true, expiresAt
+	})
+}
+
+// PERSIST: POST /kv/{key}/persist removes a key's TTL, so it never
+// expires. Under --max-ttl it expires at the cap instead.
+func (s *KVServer) handlePersist(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+	s.setTTL(w, r, key, func(v *StoredValue) {
+		v.HasTTL, v.ExpiresAt = false, time.Time{}
+	})
+}
+
+// setTTL applies change to the live value at key in one atomic step and
+// answers with the new TTL. The value and its version, hence its ETag,
+// stay the same.
+func (s *KVServer) setTTL(w http.ResponseWriter, r *http.Request, key string, change func(v *StoredValue)) {
+	var updated StoredValue
+	_, sp := s.tracer.start(r.Context(), "store.set_ttl", spanKindInternal)
+	err := s.store.ComputeErr(key, func(old StoredValue, exists bool) (StoredValue, bool, error) {
+		if !exists || old.isExpired(time.Now()) {
+			return old, exists, errKeyMissing
+		}
+		updated = old
+		change(&updated)
+		s.capTTL(&updated, time.Now())
+		return updated, true, nil
+	})
+	sp.finish()
+	switch {
+	case errors.Is(err, errKeyMissing):
+		s.metrics.NotFound.Add(1)
+		writeError(w, r, http.StatusNotFound, codeKeyNotFound, "key not found")
+		return
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	if !s.persist(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(newTTLResponse(updated))
+}
+
+var errKeyMissing = errors.New("key not found")
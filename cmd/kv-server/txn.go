@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ----------- Transactions -----------
+
+// txnCompare is a condition of a transaction: the key's value equals
+// Value, its version equals Version, or both. Version 0 stands for a key
+// that does not exist.
+type txnCompare struct {
+	Key     string  `json:"key"`
+	Value   *string `json:"value,omitempty"`
+	Version *uint64 `json:"version,omitempty"`
+}
+
+// holds reports whether c holds for v, the key's live value if exists,
+// decrypted with keys. A value compare never holds for a spilled value,
+// which is not read under the store's locks, or for a collection header.
+func (c txnCompare) holds(v StoredValue, exists bool, keys *keyring) bool {
+	if c.Version != nil {
+		if !exists && *c.Version != 0 || exists && v.Version != *c.Version {
+			return false
+		}
+	}
+	if c.Value != nil && (!exists || v.Spill != "" || v.Elements || string(v.plain(keys).Data) != *c.Value) {
+		return false
+	}
+	return true
+}
+
+type txnRequest struct {
+	Compare []txnCompare `json:"compare"`
+	Success []batchOp    `json:"success"`
+	Failure []batchOp    `json:"failure"`
+}
+
+type txnResponse struct {
+	Succeeded bool          `json:"succeeded"`
+	Results   []batchResult `json:"results"`
+}
+
+// txnOp is a resolved operation of a transaction branch.
+type txnOp struct {
+	batchOp
+	key string // in the store
+	ns  *namespace
+}
+
+// errTxnRetry aborts a transaction whose conditions changed between
+// choosing a branch and locking its keys.
+var errTxnRetry = errors.New("transaction conditions changed")
+
+// isTxnPath reports whether path is /txn or /v1/{ns}/txn.
+func isTxnPath(path string) bool {
+	_, rest, ok := nsPath(path)
+	return path == "/txn" || ok && rest == "/txn"
+}
+
+// Transactions: POST /txn (or /v1/{ns}/txn) runs one of two lists of
+// operations depending on conditions, etcd style, as one atomic step:
+//
+//	{"compare": [{"key": "leader", "version": 0}],
+//	 "success": [{"op": "set", "key": "leader", "value": "node-1"}],
+//	 "failure": [{"op": "get", "key": "leader"}]}
+//
+// If every compare holds, the success operations run, otherwise the
+// failure ones; no other write can land on the keys involved in
+// between. Operations are those of the batch endpoint, and see the
+// effects of earlier ones. The answer reports which branch ran and each
+// operation's result:
+//
+//	{"succeeded": true, "results": [{"status": 201, "etag": "\"42\""}]}
+//
+// Every compare and operation of both branches is checked before
+// anything runs, and any failure fails the whole request.
+func (s *KVServer) handleTxn(w http.ResponseWriter, r *http.Request) {
+	s.metrics.TotalRequests.Add(1)
+
+	var req txnRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBody)).Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, r, http.StatusRequestEntityTooLarge, codeBatchTooLarge,
+				fmt.Sprintf("body exceeds the %d byte limit", tooLarge.Limit))
+			return
+		}
+		writeError(w, r, http.StatusBadRequest, codeInvalidBody, "invalid body: "+err.Error())
+		return
+	}
+
+	p, _ := principalFrom(r.Context())
+	resp, serr := s.runTxn(r.Context(), p, r.PathValue("ns"), req)
+	if serr != nil {
+		serr.write(w, r)
+		return
+	}
+	if !s.persist(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// runTxn checks and runs req for p in namespace nsName ("" for the flat
+// keyspace). The caller persists.
+func (s *KVServer) runTxn(ctx context.Context, p principal, nsName string, req txnRequest) (txnResponse, *statusError) {
+	if n := len(req.Compare) + len(req.Success) + len(req.Failure); s.maxBatchOps > 0 && n > s.maxBatchOps {
+		return txnResponse{}, &statusError{http.StatusRequestEntityTooLarge, codeBatchTooLarge,
+			fmt.Sprintf("transaction has %d compares and operations, the limit is %d", n, s.maxBatchOps)}
+	}
+
+	var keys []string
+	compareKeys := make([]string, len(req.Compare))
+	for i, c := range req.Compare {
+		key, serr := s.resolveCompare(p, nsName, c)
+		if serr != nil {
+			serr.message = fmt.Sprintf("compare[%d]: %s", i, serr.message)
+			return txnResponse{}, serr
+		}
+		compareKeys[i] = key
+		keys = append(keys, key)
+	}
+	var branches [2][]txnOp // failure, success
+	for b, ops := range [2][]batchOp{req.Failure, req.Success} {
+		name := [2]string{"failure", "success"}[b]
+		for i, op := range ops {
+			key, ns, serr := s.resolveBatchOp(p, nsName, op)
+			if serr == nil && op.Op == "set" {
+				_, serr = s.batchValue(op)
+			}
+			if serr != nil {
+				serr.message = fmt.Sprintf("%s[%d]: %s", name, i, serr.message)
+				return txnResponse{}, serr
+			}
+			branches[b] = append(branches[b], txnOp{op, key, ns})
+			keys = append(keys, key)
+		}
+	}
+	holds := func(current map[string]StoredValue, now time.Time) bool {
+		for i, c := range req.Compare {
+			v, ok := current[compareKeys[i]]
+			if !c.holds(v, ok && !v.isExpired(now), s.valueKeys) {
+				return false
+			}
+		}
+		return true
+	}
+
+	// The branch's values are prepared, with quotas checked, before the
+	// keys are locked, for the branch the conditions pick at the time. If
+	// they change before the lock is taken, the other branch is prepared
+	// too and the transaction retried, which then cannot fail again.
+	_, sp := s.tracer.start(ctx, "store.txn", spanKindInternal)
+	defer sp.finish()
+	var (
+		prepared  [2][]StoredValue
+		ready     [2]bool
+		succeeded bool
+		results   []batchResult
+	)
+	for {
+		guess := 0
+		if holds(s.store.GetMany(compareKeys), time.Now()) {
+			guess = 1
+		}
+		if !ready[guess] {
+			prepared[guess] = make([]StoredValue, len(branches[guess]))
+			for i, op := range branches[guess] {
+				if op.Op != "set" {
+					continue
+				}
+				v, _ := s.batchValue(op.batchOp)
+				if serr := s.prepareValue(p, op.key, op.ns, &v); serr != nil {
+					return txnResponse{}, serr
+				}
+				prepared[guess][i] = v
+			}
+			ready[guess] = true
+		}
+
+		err := s.store.ComputeMany(keys, func(current map[string]StoredValue) (map[string]StoredValue, []string, error) {
+			now := time.Now()
+			b := 0
+			if holds(current, now) {
+				b = 1
+			}
+			if !ready[b] {
+				return nil, nil, errTxnRetry
+			}
+			succeeded = b == 1
+			set, del := s.runTxnOps(branches[b], prepared[b], current, now, &results)
+			return set, del, nil
+		})
+		if errors.Is(err, errTxnRetry) {
+			continue
+		}
+		if err != nil {
+			return txnResponse{}, &statusError{http.StatusInternalServerError, codeInternal, err.Error()}
+		}
+		break
+	}
+	sp.setAttr("succeeded", succeeded)
+	s.loadSpilledResults(results)
+
+	ran := branches[0]
+	if succeeded {
+		ran = branches[1]
+	}
+	for i, op := range ran {
+		switch op.Op {
+		case "get":
+			s.metrics.TotalGets.Add(1)
+			if results[i].Status == http.StatusNotFound {
+				s.metrics.NotFound.Add(1)
+			}
+		case "set":
+			s.metrics.TotalPuts.Add(1)
+		default:
+			s.metrics.TotalDeletes.Add(1)
+			if op.ns != nil {
+				op.ns.stats.deletes.Add(1)
+			}
+		}
+	}
+	return txnResponse{Succeeded: succeeded, Results: results}, nil
+}
+
+// resolveCompare checks that p may read c's key in namespace nsName, and
+// returns the key in the store.
+func (s *KVServer) resolveCompare(p principal, nsName string, c txnCompare) (string, *statusError) {
+	if c.Value == nil && c.Version == nil {
+		return "", &statusError{http.StatusBadRequest, codeBadRequest, "compare needs a value or a version"}
+	}
+	key, _, serr := s.resolveBatchOp(p, nsName, batchOp{Op: "get", Key: c.Key})
+	return key, serr
+}
+
+// runTxnOps applies ops, with their prepared values, to the live values
+// among current in order, storing each result in results. It returns the
+// keys to set and delete.
+func (s *KVServer) runTxnOps(ops []txnOp, prepared []StoredValue, current map[string]StoredValue, now time.Time, results *[]batchResult) (map[string]StoredValue, []string) {
+	state := make(map[string]StoredValue, len(current))
+	for k, v := range current {
+		if !v.isExpired(now) {
+			state[k] = v
+		}
+	}
+	changed := make(map[string]bool)
+	*results = make([]batchResult, len(ops))
+	for i, op := range ops {
+		switch op.Op {
+		case "get":
+			v, ok := state[op.key]
+			if op.ns != nil {
+				op.ns.stats.gets.Add(1)
+				if ok {
+					op.ns.stats.hits.Add(1)
+				} else {
+					op.ns.stats.misses.Add(1)
+				}
+			}
+			if !ok {
+				(*results)[i] = failed(&statusError{http.StatusNotFound, codeKeyNotFound, "key not found"})
+				continue
+			}
+			v.countAccess()
+			(*results)[i] = batchResult{Status: http.StatusOK, valueJSON: newValueJSON(v, s.valueKeys)}
+		case "set":
+			v := prepared[i]
+			if old, ok := state[op.key]; ok {
+				v.inherit(old)
+			}
+			state[op.key] = v
+			changed[op.key] = true
+			(*results)[i] = setResult(v)
+		default:
+			delete(state, op.key)
+			changed[op.key] = true
+			(*results)[i] = batchResult{Status: http.StatusNoContent}
+		}
+	}
+
+	set := make(map[string]StoredValue, len(changed))
+	var del []string
+	for key := range changed {
+		if v, ok := state[key]; ok {
+			set[key] = v
+		} else {
+			del = append(del, key)
+		}
+	}
+	return set, del
+}
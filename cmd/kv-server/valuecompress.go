@@ -0,0 +1,120 @@
+package main
+
+import (
+	"log/slog"
+	"sync/atomic"
+
+	"github.com/shubhamc1947/safemap/pkg/concurrentmap"
+	"github.com/shubhamc1947/safemap/pkg/snappy"
+)
+
+// ----------- Value Compression -----------
+
+// With --value-compression-min-size, values written through the key API
+// that are at least that long are kept Snappy-compressed in the store,
+// and in the AOF and snapshots. Reads decompress them, so clients never
+// see the difference. A value that would not shrink by an eighth is kept
+// as it is, and spilled values are never compressed.
+//
+// Snappy is chosen over zstd for speed: compressing happens on every
+// write and decompressing on every read. It is implemented in pkg/snappy,
+// as zstd would need a dependency outside the standard library.
+
+type valueCompressor struct {
+	minSize int
+
+	// Writes: values compressed, values left alone as incompressible,
+	// and bytes before and after compression.
+	compressed     atomic.Int64
+	incompressible atomic.Int64
+	bytesIn        atomic.Int64
+	bytesOut       atomic.Int64
+
+	// Compressed values in the store now, and their sizes.
+	storedValues atomic.Int64
+	storedRaw    atomic.Int64
+	storedBytes  atomic.Int64
+}
+
+func newValueCompressor(store *concurrentmap.ConcurrentMap[string, StoredValue], minSize int) *valueCompressor {
+	c := &valueCompressor{minSize: minSize}
+	store.Subscribe(c.track)
+	return c
+}
+
+// track keeps the stored totals. It runs under the store's bucket lock,
+// so it only touches the counters.
+func (c *valueCompressor) track(ev concurrentmap.Event[string, StoredValue]) {
+	if ev.Type != concurrentmap.EventInsert && ev.OldValue.Compressed {
+		c.count(ev.OldValue, -1)
+	}
+	if (ev.Type == concurrentmap.EventInsert || ev.Type == concurrentmap.EventUpdate) && ev.NewValue.Compressed {
+		c.count(ev.NewValue, 1)
+	}
+}
+
+func (c *valueCompressor) count(v StoredValue, sign int64) {
+	c.storedValues.Add(sign)
+	c.storedRaw.Add(sign * v.size())
+	c.storedBytes.Add(sign * int64(len(v.Data)))
+}
+
+// compress compresses v's Data in place if it is long enough and shrinks
+// enough. It does nothing when compression is off (c is nil).
+func (c *valueCompressor) compress(v *StoredValue) {
+	if c == nil || v.Compressed || v.Spill != "" || len(v.Data) < c.minSize {
+		return
+	}
+	enc := snappy.Encode(nil, v.Data)
+	if len(enc) > len(v.Data)-len(v.Data)/8 {
+		c.incompressible.Add(1)
+		return
+	}
+	c.compressed.Add(1)
+	c.bytesIn.Add(int64(len(v.Data)))
+	c.bytesOut.Add(int64(len(enc)))
+	v.Data, v.Compressed = enc, true
+}
+
+func (c *valueCompressor) report() map[string]any {
+	resp := map[string]any{
+		"compressed":       c.compressed.Load(),
+		"incompressible":   c.incompressible.Load(),
+		"bytes_in":         c.bytesIn.Load(),
+		"bytes_out":        c.bytesOut.Load(),
+		"stored_values":    c.storedValues.Load(),
+		"stored_raw_bytes": c.storedRaw.Load(),
+		"stored_bytes":     c.storedBytes.Load(),
+		"write_ratio":      0.0,
+		"stored_ratio":     0.0,
+	}
+	// Ratios are uncompressed over compressed size: 3 means a third.
+	if out := c.bytesOut.Load(); out > 0 {
+		resp["write_ratio"] = float64(c.bytesIn.Load()) / float64(out)
+	}
+	if stored := c.storedBytes.Load(); stored > 0 {
+		resp["stored_ratio"] = float64(c.storedRaw.Load()) / float64(stored)
+	}
+	return resp
+}
+
+// plain returns v with its Data decrypted with keys and decompressed.
+func (v StoredValue) plain(keys *keyring) StoredValue {
+	if v.Encrypted {
+		data, err := unseal(keys, v.Data)
+		if err != nil {
+			slog.Error("value encryption: cannot decrypt value", "err", err)
+			v.Compressed = false
+		}
+		v.Data, v.Encrypted = data, false
+	}
+	if !v.Compressed {
+		return v
+	}
+	data, err := snappy.Decode(nil, v.Data)
+	if err != nil {
+		slog.Error("value compression: corrupt value", "err", err)
+	}
+	v.Data, v.Compressed = data, false
+	return v
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/shubhamc1947/safemap/pkg/concurrentmap"
+)
+
+// ----------- Value Encryption -----------
+
+// With --encrypt-values, values written through the key API are sealed
+// with the active key of the keyring (--encryption-key-file or
+// KV_ENCRYPTION_KEYS) before they enter the store, so neither a memory
+// dump nor the AOF and snapshots hold them in the clear. Reads decrypt
+// them; keys, TTLs and other metadata stay readable.
+//
+// There is no KMS client: a KMS agent or init container hands the keys
+// over by writing the key file or setting the environment variable.
+//
+// Values are compressed first, if compression is on. A sealed value is
+// uvarint(plain length) | keyring blob, so its size is known without
+// decrypting it. Values stay sealed with the key that was active when
+// they were written; the value_encryption metrics count them by key ID,
+// so an old key can be dropped once none are left. Spilled values would
+// be on disk in the clear, so --spill-dir cannot be combined with it.
+
+var valueAAD = []byte("value")
+
+var errNoValueKeys = errors.New("value is encrypted, but no encryption keys are configured")
+
+type valueCipher struct {
+	keys *keyring
+
+	sealed atomic.Int64 // values encrypted on write
+
+	// Encrypted values in the store now, by key ID.
+	mu    sync.Mutex
+	byKey map[[4]byte]int64
+}
+
+func newValueCipher(store *concurrentmap.ConcurrentMap[string, StoredValue], keys *keyring) *valueCipher {
+	c := &valueCipher{keys: keys, byKey: make(map[[4]byte]int64)}
+	store.Subscribe(c.track)
+	return c
+}
+
+// track keeps the stored counts. It runs under the store's bucket lock,
+// so it only takes c.mu.
+func (c *valueCipher) track(ev concurrentmap.Event[string, StoredValue]) {
+	if ev.Type != concurrentmap.EventInsert && ev.OldValue.Encrypted {
+		c.count(ev.OldValue, -1)
+	}
+	if (ev.Type == concurrentmap.EventInsert || ev.Type == concurrentmap.EventUpdate) && ev.NewValue.Encrypted {
+		c.count(ev.NewValue, 1)
+	}
+}
+
+func (c *valueCipher) count(v StoredValue, delta int64) {
+	id, ok := sealedKeyID(v.Data)
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.byKey[id] += delta
+	if c.byKey[id] == 0 {
+		delete(c.byKey, id)
+	}
+}
+
+// seal encrypts v's Data in place. It does nothing when encryption is
+// off (c is nil), and to spilled or already encrypted values.
+func (c *valueCipher) seal(v *StoredValue) {
+	if c == nil || v.Encrypted || v.Spill != "" {
+		return
+	}
+	data := binary.AppendUvarint(nil, uint64(v.size()))
+	v.Data, v.Encrypted = append(data, c.keys.seal(v.Data, valueAAD)...), true
+	c.sealed.Add(1)
+}
+
+func (c *valueCipher) report() map[string]any {
+	c.mu.Lock()
+	byKey := make(map[string]int64, len(c.byKey))
+	var stored int64
+	for id, n := range c.byKey {
+		byKey[hex.EncodeToString(id[:])] = n
+		stored += n
+	}
+	c.mu.Unlock()
+	return map[string]any{
+		"active_key":    hex.EncodeToString(c.keys.active.id[:]),
+		"sealed":        c.sealed.Load(),
+		"stored_values": stored,
+		"stored_by_key": byKey,
+	}
+}
+
+// encode readies v's Data for the store: its digest is taken, then it is
+// compressed and encrypted, as configured.
+func (s *KVServer) encode(v *StoredValue) {
+	if v.Spill == "" && !v.Compressed && !v.Encrypted {
+		sum := sha256.Sum256(v.Data)
+		v.SHA256 = sum[:]
+	}
+	s.compression.compress(v)
+	s.encryption.seal(v)
+}
+
+// sealedKeyID returns the ID of the key a sealed value was encrypted with.
+func sealedKeyID(data []byte) ([4]byte, bool) {
+	var id [4]byte
+	_, n := binary.Uvarint(data)
+	if n <= 0 || len(data) < n+len(id) {
+		return id, false
+	}
+	copy(id[:], data[n:])
+	return id, true
+}
+
+// sealedSize returns the plain length of a sealed value.
+func sealedSize(data []byte) int64 {
+	n, _ := binary.Uvarint(data)
+	return int64(n)
+}
+
+// unseal decrypts a sealed value's Data with keys.
+func unseal(keys *keyring, data []byte) ([]byte, error) {
+	if keys == nil {
+		return nil, errNoValueKeys
+	}
+	_, n := binary.Uvarint(data)
+	if n <= 0 {
+		return nil, errors.New("encrypted value has no length")
+	}
+	return keys.open(data[n:], valueAAD)
+}
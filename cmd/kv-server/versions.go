@@ -0,0 +1,255 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shubhamc1947/safemap/pkg/concurrentmap"
+)
+
+// ----------- Object Versioning -----------
+
+// A namespace defined with "versions": N keeps the last N values each of
+// its keys had before being overwritten or deleted. They live in the store
+// as JSON under historyKeyPrefix+<store key>, so they are persisted and
+// restored with everything else.
+const (
+	historyKeyPrefix = "__hist/"
+	maxKeptVersions  = 100
+)
+
+// versionRecord is a previous value of a key, with Data as it was
+// stored: possibly compressed or encrypted.
+type versionRecord struct {
+	Version     uint64    `json:"version"`
+	Data        []byte    `json:"data"`
+	ContentType string    `json:"content_type,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Compressed  bool      `json:"compressed,omitempty"`
+	Encrypted   bool      `json:"encrypted,omitempty"`
+	SHA256      []byte    `json:"sha256,omitempty"`
+}
+
+func (rec versionRecord) storedValue() StoredValue {
+	return StoredValue{Data: rec.Data, ContentType: rec.ContentType, Version: rec.Version, UpdatedAt: rec.UpdatedAt,
+		Compressed: rec.Compressed, Encrypted: rec.Encrypted, SHA256: rec.SHA256}
+}
+
+// versionLog records replaced values of versioned namespaces. The store
+// hook only queues them, since it runs under a bucket lock and history is
+// kept under another key; flush writes the queue out before the write is
+// acknowledged.
+type versionLog struct {
+	store *concurrentmap.ConcurrentMap[string, StoredValue]
+	kept  func(ns string) int // versions namespace ns keeps; 0 = off
+
+	active atomic.Bool // set once persisted data is loaded, so replay adds nothing
+
+	mu      sync.Mutex
+	pending []concurrentmap.Entry[string, StoredValue]
+	flushMu sync.Mutex // serializes flushes, so a flush returns with its writes applied
+}
+
+func newVersionLog(store *concurrentmap.ConcurrentMap[string, StoredValue], kept func(ns string) int) *versionLog {
+	l := &versionLog{store: store, kept: kept}
+	store.Subscribe(l.track)
+	return l
+}
+
+// track queues the value an update or delete replaced. Changing only a
+// key's TTL keeps its version and is not recorded, and neither is a
+// spilled value, whose file goes with it, or a collection header, whose
+// elements are edited in place.
+func (l *versionLog) track(ev concurrentmap.Event[string, StoredValue]) {
+	if !l.active.Load() || ev.Type != concurrentmap.EventUpdate && ev.Type != concurrentmap.EventDelete {
+		return
+	}
+	old := ev.OldValue
+	if ev.Type == concurrentmap.EventUpdate && ev.NewValue.Version == old.Version || old.isExpired(time.Now()) || old.Spill != "" || old.Elements {
+		return
+	}
+	if name, _, ok := splitStoreKey(ev.Key); !ok || l.kept(name) == 0 {
+		return
+	}
+	l.mu.Lock()
+	l.pending = append(l.pending, concurrentmap.Entry[string, StoredValue]{Key: ev.Key, Value: old})
+	l.mu.Unlock()
+}
+
+// flush adds the queued values to their keys' histories.
+func (l *versionLog) flush() {
+	l.flushMu.Lock()
+	defer l.flushMu.Unlock()
+	l.mu.Lock()
+	pending := l.pending
+	l.pending = nil
+	l.mu.Unlock()
+
+	for _, e := range pending {
+		l.push(e.Key, e.Value)
+	}
+}
+
+// push adds old to key's history, which stays sorted by version however
+// concurrent writers' flushes interleave, and drops the oldest entries
+// beyond what the namespace keeps.
+func (l *versionLog) push(key string, old StoredValue) {
+	name, _, _ := splitStoreKey(key)
+	keep := l.kept(name)
+	if keep == 0 {
+		return
+	}
+	l.store.Compute(historyKeyPrefix+key, func(cur StoredValue, exists bool) (StoredValue, bool) {
+		var recs []versionRecord
+		if exists {
+			_ = json.Unmarshal(cur.Data, &recs)
+		}
+		i, found := slices.BinarySearchFunc(recs, old.Version, func(rec versionRecord, v uint64) int {
+			return cmp.Compare(rec.Version, v)
+		})
+		if !found {
+			recs = slices.Insert(recs, i, versionRecord{
+				Version: old.Version, Data: old.Data, ContentType: old.ContentType, UpdatedAt: old.UpdatedAt,
+				Compressed: old.Compressed, Encrypted: old.Encrypted, SHA256: old.SHA256,
+			})
+		}
+		recs = recs[max(0, len(recs)-keep):]
+		data, _ := json.Marshal(recs)
+		return StoredValue{Data: data}, true
+	})
+}
+
+// history returns key's previous values, oldest first.
+func (l *versionLog) history(key string) []versionRecord {
+	var recs []versionRecord
+	if v, ok := l.store.Get(historyKeyPrefix + key); ok {
+		_ = json.Unmarshal(v.Data, &recs)
+	}
+	return recs
+}
+
+// versionOf returns version id of key: its live value, or one from its
+// history.
+func (s *KVServer) versionOf(key string, id uint64) (StoredValue, bool) {
+	if v, ok := s.store.Get(key); ok && v.Version == id && !v.isExpired(time.Now()) {
+		if v, ok = s.whole(key, v); ok {
+			return v.plain(s.valueKeys), true
+		}
+	}
+	for _, rec := range s.history.history(key) {
+		if rec.Version == id {
+			return rec.storedValue().plain(s.valueKeys), true
+		}
+	}
+	return StoredValue{}, false
+}
+
+// versionParam parses the version query parameter: a version id, which is
+// a value's ETag without the quotes.
+func versionParam(s string) (uint64, bool) {
+	id, err := strconv.ParseUint(s, 10, 64)
+	return id, err == nil && id != 0
+}
+
+// lookupVersion is lookup for GET /kv/{key}?version=<id>: the key's live
+// value if it has that version, or else the version from its history.
+func (s *KVServer) lookupVersion(w http.ResponseWriter, r *http.Request, key, param string) (StoredValue, bool) {
+	id, ok := versionParam(param)
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "version must be a version id")
+		return StoredValue{}, false
+	}
+	value, ok := s.versionOf(key, id)
+	if !ok {
+		writeError(w, r, http.StatusNotFound, codeVersionNotFound, "no such version")
+	}
+	return value, ok
+}
+
+// versionInfo describes a version in a listing.
+type versionInfo struct {
+	Version   uint64     `json:"version"`
+	ETag      string     `json:"etag"`
+	Size      int        `json:"size"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	Current   bool       `json:"current,omitempty"`
+}
+
+func newVersionInfo(v StoredValue) versionInfo {
+	info := versionInfo{Version: v.Version, ETag: v.etag(), Size: int(v.size())}
+	if !v.UpdatedAt.IsZero() {
+		info.UpdatedAt = &v.UpdatedAt
+	}
+	return info
+}
+
+// Versions: GET /kv/{key}/versions lists the key's live value and the
+// previous ones its namespace keeps, newest first:
+//
+//	{"versions": [{"version": 7, "etag": "\"7\"", "size": 3, "updated_at": "...", "current": true}, ...]}
+//
+// A deleted key still lists its history. Read one with GET
+// /kv/{key}?version=<id>.
+func (s *KVServer) handleVersions(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+	versions := []versionInfo{}
+	if v, ok := s.store.Get(key); ok && !v.isExpired(time.Now()) {
+		info := newVersionInfo(v)
+		info.Current = true
+		versions = append(versions, info)
+	}
+	recs := s.history.history(key)
+	for i := len(recs) - 1; i >= 0; i-- {
+		versions = append(versions, newVersionInfo(recs[i].storedValue()))
+	}
+	if len(versions) == 0 {
+		writeError(w, r, http.StatusNotFound, codeKeyNotFound, "key not found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string][]versionInfo{"versions": versions})
+}
+
+// RESTORE: POST /kv/{key}/restore {"version": 5} writes version 5 back as
+// the key's value, as a PUT of it would: it gets a new version, and the
+// value it replaces joins the history. If-Match is honored.
+//
+//	{"version": 9, "restored_from": 5}
+func (s *KVServer) handleRestoreVersion(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+	s.metrics.TotalPuts.Add(1)
+	var req struct {
+		Version uint64 `json:"version"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidBody, "invalid body: "+err.Error())
+		return
+	}
+	if req.Version == 0 {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "version is required")
+		return
+	}
+	old, ok := s.versionOf(key, req.Version)
+	if !ok {
+		writeError(w, r, http.StatusNotFound, codeVersionNotFound, "no such version")
+		return
+	}
+
+	p, _ := principalFrom(r.Context())
+	stored := StoredValue{Data: old.Data, ContentType: old.ContentType}
+	if serr := s.putValue(r.Context(), p, key, ns, &stored, writeCond{ifMatch: r.Header.Get("If-Match")}); serr != nil {
+		serr.write(w, r)
+		return
+	}
+	if !s.persist(w, r) {
+		return
+	}
+
+	w.Header().Set("ETag", stored.etag())
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]uint64{"version": stored.Version, "restored_from": req.Version})
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shubhamc1947/safemap/pkg/concurrentmap"
+)
+
+// ----------- Watch -----------
+
+// Watchers follow the eventFeed, which numbers every mutation of a
+// client-visible key and keeps the most recent ones, so a client that
+// reconnects can resume after the last event it saw.
+const (
+	watchHeartbeat = 15 * time.Second
+	watchBuffer    = 256 // events queued per watcher before it is dropped
+)
+
+var errWatchCompacted = errors.New("events since that ID are no longer kept")
+
+// watchEvent is a mutation of a key: "set", "delete" or "expire". Key is
+// the store key; Value is the new value of a set.
+type watchEvent struct {
+	ID    uint64
+	Type  string
+	Key   string
+	Value StoredValue
+}
+
+// feedSub is one watcher's subscription. The feed closes ch when the
+// watcher falls watchBuffer events behind.
+type feedSub struct {
+	match func(key string) bool
+	ch    chan watchEvent
+}
+
+// eventFeed fans store events out to watchers. Its store hook assigns
+// IDs and delivers under one lock, so every watcher sees events in ID
+// order, and never blocks: a watcher that cannot keep up is dropped and
+// resumes from the history when it reconnects.
+type eventFeed struct {
+	active atomic.Bool // set once persisted data is loaded, so replay is not fed
+	done   chan struct{}
+
+	mu      sync.Mutex
+	seq     uint64       // ID of the last event
+	history []watchEvent // ring of the last len(history) events
+	kept    int          // events in history
+	subs    map[*feedSub]struct{}
+}
+
+// newEventFeed keeps the last keep events for resuming. IDs start from
+// the clock in microseconds, so IDs from before a restart are never
+// mistaken for new ones.
+func newEventFeed(store *concurrentmap.ConcurrentMap[string, StoredValue], keep int) *eventFeed {
+	f := &eventFeed{
+		done:    make(chan struct{}),
+		seq:     uint64(time.Now().UnixMicro()),
+		history: make([]watchEvent, keep),
+		subs:    make(map[*feedSub]struct{}),
+	}
+	store.Subscribe(f.track)
+	return f
+}
+
+// track numbers, records and delivers a mutation. It runs under the
+// store's bucket lock, so it only touches the feed.
+func (f *eventFeed) track(ev concurrentmap.Event[string, StoredValue]) {
+	if !f.active.Load() || isReservedKey(ev.Key) {
+		return
+	}
+	we := watchEvent{Key: ev.Key}
+	switch ev.Type {
+	case concurrentmap.EventInsert, concurrentmap.EventUpdate:
+		we.Type, we.Value = "set", ev.NewValue
+	case concurrentmap.EventDelete:
+		we.Type = "delete"
+	case concurrentmap.EventExpire:
+		we.Type = "expire"
+	default:
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.seq++
+	we.ID = f.seq
+	if len(f.history) > 0 {
+		f.history[we.ID%uint64(len(f.history))] = we
+		f.kept = min(f.kept+1, len(f.history))
+	}
+	for sub := range f.subs {
+		if !sub.match(we.Key) {
+			continue
+		}
+		select {
+		case sub.ch <- we:
+		default:
+			delete(f.subs, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// subscribe starts delivering events for keys match accepts. With resume,
+// it also returns the kept events after ID after, or errWatchCompacted
+// if some of them are no longer kept.
+func (f *eventFeed) subscribe(match func(key string) bool, after uint64, resume bool) (*feedSub, []watchEvent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var backlog []watchEvent
+	if resume {
+		if after > f.seq || f.seq-after > uint64(f.kept) {
+			return nil, nil, errWatchCompacted
+		}
+		for id := after + 1; id <= f.seq; id++ {
+			if we := f.history[id%uint64(len(f.history))]; match(we.Key) {
+				backlog = append(backlog, we)
+			}
+		}
+	}
+	sub := &feedSub{match: match, ch: make(chan watchEvent, watchBuffer)}
+	f.subs[sub] = struct{}{}
+	return sub, backlog, nil
+}
+
+func (f *eventFeed) unsubscribe(sub *feedSub) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.subs[sub]; ok {
+		delete(f.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// close ends every watch, on shutdown.
+func (f *eventFeed) close() {
+	close(f.done)
+}
+
+// watchEventJSON is an event as sent to clients, with the value of sets.
+type watchEventJSON struct {
+	ID   uint64 `json:"id"`
+	Type string `json:"type"`
+	Key  string `json:"key"`
+	*valueJSON
+}
+
+// watchTarget is what a watch request follows: key, or keys starting
+// with prefix, in ns (nil for the flat keyspace).
+type watchTarget struct {
+	ns     *namespace
+	nsName string
+	key    string
+	prefix string
+	exact  bool
+}
+
+// storePrefix is the store key, or store key prefix, the target follows.
+func (t watchTarget) storePrefix() string {
+	name := t.prefix
+	if t.exact {
+		name = t.key
+	}
+	if t.ns != nil {
+		return t.ns.storeKey(name)
+	}
+	return name
+}
+
+// matches reports whether store key key is followed.
+func (t watchTarget) matches(key string) bool {
+	if t.ns == nil && strings.HasPrefix(key, nsKeyPrefix) {
+		return false
+	}
+	if t.exact {
+		return key == t.storePrefix()
+	}
+	return strings.HasPrefix(key, t.storePrefix())
+}
+
+// clientKey is key as the watcher names it, without its namespace.
+func (t watchTarget) clientKey(key string) string {
+	if t.ns != nil {
+		_, key, _ = splitStoreKey(key)
+	}
+	return key
+}
+
+// event renders we for the watcher, decrypting its value with keys.
+func (t watchTarget) event(we watchEvent, keys *keyring) watchEventJSON {
+	out := watchEventJSON{ID: we.ID, Type: we.Type, Key: t.clientKey(we.Key)}
+	if we.Type == "set" {
+		v := newValueJSON(we.Value, keys)
+		out.valueJSON = &v
+	}
+	return out
+}
+
+// watchAllowed reports whether p may see events on store key key: ACLs
+// apply per key, as for GET.
+func (s *KVServer) watchAllowed(p principal, t watchTarget, key string) bool {
+	return !s.aclEnforced || p.has(scopeAdmin) || s.acl.allowed(p.Name, aclKey(t.nsName, t.clientKey(key)), scopeRead)
+}
+
+// isWatchPath reports whether path is /watch or /v1/{ns}/watch.
+func isWatchPath(path string) bool {
+	_, rest, ok := nsPath(path)
+	return path == "/watch" || ok && rest == "/watch"
+}
+
+// Watch: GET /watch?prefix=jobs: (or ?key=k; /v1/{ns}/watch in a
+// namespace) streams changes as Server-Sent Events, one per mutation:
+//
+//	id: 1729048273000042
+//	event: set
+//	data: {"id": 1729048273000042, "type": "set", "key": "jobs:1", "value": "...", "etag": "..."}
+//
+// Types are set, delete and expire. A comment line is sent every 15s
+// while idle. To resume, reconnect with Last-Event-ID (or
+// ?last_event_id=): the kept events after it are sent first. 410
+// watch_compacted means some are no longer kept; reread the keys and
+// watch afresh. A watcher too slow to keep up is disconnected and can
+// resume the same way.
+func (s *KVServer) handleWatch(w http.ResponseWriter, r *http.Request) {
+	s.metrics.TotalRequests.Add(1)
+	p, _ := principalFrom(r.Context())
+	t, serr := s.resolveWatch(p, r)
+	if serr != nil {
+		serr.write(w, r)
+		return
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	var after uint64
+	if lastID != "" {
+		var err error
+		if after, err = strconv.ParseUint(lastID, 10, 64); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, "invalid Last-Event-ID")
+			return
+		}
+	}
+	sub, backlog, err := s.events.subscribe(t.matches, after, lastID != "")
+	if err != nil {
+		writeError(w, r, http.StatusGone, codeWatchCompacted, err.Error())
+		return
+	}
+	defer s.events.unsubscribe(sub)
+
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{}) // streams outlive --write-timeout
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(we watchEvent) error {
+		if !s.watchAllowed(p, t, we.Key) {
+			return nil
+		}
+		data, _ := json.Marshal(t.event(we, s.valueKeys))
+		_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", we.ID, we.Type, data)
+		return err
+	}
+	for _, we := range backlog {
+		if send(we) != nil {
+			return
+		}
+	}
+	if rc.Flush() != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(watchHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.events.done:
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case we, ok := <-sub.ch:
+			if !ok {
+				return
+			}
+			if send(we) != nil {
+				return
+			}
+		}
+		if rc.Flush() != nil {
+			return
+		}
+	}
+}
+
+// resolveWatch reads what a watch request follows: ?key= or ?prefix=
+// ("" for every key) in the request's namespace.
+func (s *KVServer) resolveWatch(p principal, r *http.Request) (watchTarget, *statusError) {
+	q := r.URL.Query()
+	if q.Has("key") && q.Has("prefix") {
+		return watchTarget{}, &statusError{http.StatusBadRequest, codeBadRequest, "pass key or prefix, not both"}
+	}
+	return s.newWatchTarget(p, r.PathValue("ns"), q.Get("key"), q.Has("key"), q.Get("prefix"))
+}
+
+// newWatchTarget checks that p may watch key (when exact) or prefix in
+// namespace nsName ("" for the flat keyspace).
+func (s *KVServer) newWatchTarget(p principal, nsName, key string, exact bool, prefix string) (watchTarget, *statusError) {
+	t := watchTarget{nsName: nsName, key: key, exact: exact, prefix: prefix}
+	ns, serr := s.resolveNamespace(p, nsName)
+	if serr != nil {
+		return t, serr
+	}
+	t.ns = ns
+	if exact {
+		if _, _, serr := s.resolveKey(p, nsName, key); serr != nil {
+			return t, serr
+		}
+	}
+	return t, nil
+}
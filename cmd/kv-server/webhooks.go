@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shubhamc1947/safemap/pkg/concurrentmap"
+)
+
+// ----------- Webhooks -----------
+
+// webhookKeyPrefix reserves the keyspace holding webhook definitions, so
+// they persist with the data like ACL rules do.
+const webhookKeyPrefix = "__webhook/"
+
+const (
+	webhookQueue   = 10000 // deliveries waiting for a sender
+	webhookSenders = 4
+)
+
+// webhook POSTs an event to URL for every change to a key matching
+// Pattern (Redis glob syntax) in Namespace ("" for the flat keyspace, "*"
+// for any), limited to Ops when set.
+type webhook struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Pattern   string    `json:"pattern"`
+	Namespace string    `json:"namespace,omitempty"`
+	Ops       []string  `json:"ops,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (h webhook) matches(ns, key, op string) bool {
+	return (h.Namespace == "*" || h.Namespace == ns) &&
+		globMatch(h.Pattern, key) &&
+		(len(h.Ops) == 0 || slices.Contains(h.Ops, op))
+}
+
+var (
+	errInvalidWebhookURL = errors.New("url must be an absolute http or https URL")
+	errInvalidWebhookOps = errors.New("ops must be set, delete and/or expire")
+)
+
+// webhookEvent is the body of a delivery.
+type webhookEvent struct {
+	Webhook   string    `json:"webhook"`
+	Op        string    `json:"op"`
+	Namespace string    `json:"namespace,omitempty"`
+	Key       string    `json:"key"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type webhookDelivery struct {
+	url   string
+	event webhookEvent
+}
+
+// webhookMetrics counts deliveries since startup. A dead letter is an
+// event given up on: its retries ran out, or the queue was full.
+type webhookMetrics struct {
+	Delivered   atomic.Int64
+	Retries     atomic.Int64
+	DeadLetters atomic.Int64
+}
+
+func (m *webhookMetrics) report() map[string]any {
+	return map[string]any{
+		"delivered":    m.Delivered.Load(),
+		"retries":      m.Retries.Load(),
+		"dead_letters": m.DeadLetters.Load(),
+	}
+}
+
+// webhookRegistry stores webhooks in the KV store and keeps a copy in
+// memory. Its store hook queues a delivery per matching webhook and
+// change; senders POST them, retrying failures with backoff.
+type webhookRegistry struct {
+	store   *concurrentmap.ConcurrentMap[string, StoredValue]
+	active  atomic.Bool // set once persisted data is loaded, so replay is not sent
+	queue   chan webhookDelivery
+	client  *http.Client
+	retries int
+	metrics webhookMetrics
+
+	mu    sync.RWMutex
+	hooks []webhook
+}
+
+func newWebhookRegistry(store *concurrentmap.ConcurrentMap[string, StoredValue], timeout time.Duration, retries int) *webhookRegistry {
+	reg := &webhookRegistry{
+		store:   store,
+		queue:   make(chan webhookDelivery, webhookQueue),
+		client:  &http.Client{Timeout: timeout},
+		retries: retries,
+	}
+	store.Subscribe(reg.track)
+	return reg
+}
+
+// track queues deliveries for a change. It runs under the store's bucket
+// lock, so it never blocks: with the queue full, the event is a dead
+// letter.
+func (reg *webhookRegistry) track(ev concurrentmap.Event[string, StoredValue]) {
+	if !reg.active.Load() || isReservedKey(ev.Key) {
+		return
+	}
+	var op string
+	switch ev.Type {
+	case concurrentmap.EventInsert, concurrentmap.EventUpdate:
+		op = "set"
+	case concurrentmap.EventDelete:
+		op = "delete"
+	case concurrentmap.EventExpire:
+		op = "expire"
+	default:
+		return
+	}
+	ns, key := "", ev.Key
+	if n, k, ok := splitStoreKey(ev.Key); ok {
+		ns, key = n, k
+	}
+
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	now := time.Now().UTC()
+	for _, h := range reg.hooks {
+		if !h.matches(ns, key, op) {
+			continue
+		}
+		d := webhookDelivery{url: h.URL, event: webhookEvent{Webhook: h.ID, Op: op, Namespace: ns, Key: key, Timestamp: now}}
+		select {
+		case reg.queue <- d:
+		default:
+			reg.metrics.DeadLetters.Add(1)
+		}
+	}
+}
+
+// run sends queued deliveries until ctx is done; pending ones are then
+// dropped.
+func (reg *webhookRegistry) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for range webhookSenders {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case d := <-reg.queue:
+					reg.deliver(ctx, d)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// deliver POSTs d, retrying with exponential backoff from 500ms until
+// the endpoint answers 2xx or the retries run out.
+func (reg *webhookRegistry) deliver(ctx context.Context, d webhookDelivery) {
+	body, _ := json.Marshal(d.event)
+	backoff := 500 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		err := reg.post(ctx, d.url, body)
+		if err == nil {
+			reg.metrics.Delivered.Add(1)
+			return
+		}
+		if attempt >= reg.retries || ctx.Err() != nil {
+			reg.metrics.DeadLetters.Add(1)
+			slog.Warn("webhook: delivery failed", "webhook", d.event.Webhook, "key", d.event.Key, "attempts", attempt+1, "err", err)
+			return
+		}
+		reg.metrics.Retries.Add(1)
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (reg *webhookRegistry) post(ctx context.Context, target string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := reg.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("endpoint answered %s", resp.Status)
+	}
+	return nil
+}
+
+// reload rebuilds the in-memory webhooks from the store; call it after
+// persisted data is loaded.
+func (reg *webhookRegistry) reload() {
+	var hooks []webhook
+	reg.store.Range(func(key string, v StoredValue) bool {
+		if strings.HasPrefix(key, webhookKeyPrefix) {
+			var h webhook
+			if json.Unmarshal(v.Data, &h) == nil {
+				hooks = append(hooks, h)
+			}
+		}
+		return true
+	})
+	slices.SortFunc(hooks, func(a, b webhook) int { return a.CreatedAt.Compare(b.CreatedAt) })
+
+	reg.mu.Lock()
+	reg.hooks = hooks
+	reg.mu.Unlock()
+}
+
+func (reg *webhookRegistry) list() []webhook {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	return slices.Clone(reg.hooks)
+}
+
+// create adds a webhook. An empty pattern matches every key.
+func (reg *webhookRegistry) create(rawURL, pattern, namespace string, ops []string) (webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" || u.Scheme != "http" && u.Scheme != "https" {
+		return webhook{}, errInvalidWebhookURL
+	}
+	for _, op := range ops {
+		if op != "set" && op != "delete" && op != "expire" {
+			return webhook{}, errInvalidWebhookOps
+		}
+	}
+	if pattern == "" {
+		pattern = "*"
+	}
+
+	var id [6]byte
+	_, _ = rand.Read(id[:])
+	h := webhook{
+		ID:        hex.EncodeToString(id[:]),
+		URL:       rawURL,
+		Pattern:   pattern,
+		Namespace: namespace,
+		Ops:       slices.Compact(slices.Sorted(slices.Values(ops))),
+		CreatedAt: time.Now().UTC(),
+	}
+	data, _ := json.Marshal(h)
+
+	// The store is written outside mu: track takes mu under a bucket lock.
+	reg.store.Set(webhookKeyPrefix+h.ID, StoredValue{Data: data})
+	reg.mu.Lock()
+	reg.hooks = append(reg.hooks, h)
+	reg.mu.Unlock()
+	return h, nil
+}
+
+func (reg *webhookRegistry) delete(id string) bool {
+	reg.mu.Lock()
+	i := slices.IndexFunc(reg.hooks, func(h webhook) bool { return h.ID == id })
+	if i >= 0 {
+		reg.hooks = slices.Delete(reg.hooks, i, i+1)
+	}
+	reg.mu.Unlock()
+	if i < 0 {
+		return false
+	}
+	_ = reg.store.DeleteErr(webhookKeyPrefix + id)
+	return true
+}
+
+// Admin: GET /admin/webhooks lists webhooks.
+func (s *KVServer) handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"webhooks": s.webhooks.list()})
+}
+
+// Admin: POST /admin/webhooks {"url": "https://example.com/hook",
+// "pattern": "user:*", "namespace": "team-a", "ops": ["set", "delete"]}
+// adds a webhook.
+func (s *KVServer) handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URL       string   `json:"url"`
+		Pattern   string   `json:"pattern"`
+		Namespace string   `json:"namespace"`
+		Ops       []string `json:"ops"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidBody, "invalid body: "+err.Error())
+		return
+	}
+	h, err := s.webhooks.create(req.URL, req.Pattern, req.Namespace, req.Ops)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+	if !s.persist(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(h)
+}
+
+// Admin: DELETE /admin/webhooks/{id} removes a webhook.
+func (s *KVServer) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if !s.webhooks.delete(r.PathValue("id")) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "no such webhook")
+		return
+	}
+	if !s.persist(w, r) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
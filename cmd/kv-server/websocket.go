@@ -0,0 +1,502 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ----------- WebSocket -----------
+
+// The subscribe API speaks just enough of RFC 6455 for JSON messages:
+// text frames, fragmentation, ping/pong and close. Extensions and
+// subprotocols are not negotiated.
+const (
+	wsGUID       = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsMaxMessage = 64 << 10
+	wsOutBuffer  = 256 // frames queued for the writer
+
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+var (
+	errWSProtocol = errors.New("websocket: protocol error")
+	errWSTooLarge = errors.New("websocket: message too large")
+)
+
+// isWebSocketPath reports whether path is the subscribe endpoint.
+func isWebSocketPath(path string) bool {
+	return path == "/ws"
+}
+
+// wsConn is a server-side WebSocket connection. Reads happen on one
+// goroutine; writes may come from any, and are serialized.
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	wmu sync.Mutex
+}
+
+// upgradeWebSocket completes the opening handshake and takes over the
+// connection, or writes the error response and returns nil.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) *wsConn {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") || key == "" {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "expected a WebSocket upgrade")
+		return nil
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		writeError(w, r, http.StatusUpgradeRequired, codeBadRequest, "unsupported WebSocket version")
+		return nil
+	}
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "connection cannot be upgraded")
+		return nil
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(sum[:]))
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return nil
+	}
+	return &wsConn{conn: conn, br: brw.Reader}
+}
+
+// headerHasToken reports whether the comma-separated header name
+// contains token, case-insensitively.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeFrame sends one unfragmented frame.
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	hdr := make([]byte, 2, 10)
+	hdr[0] = 0x80 | op
+	switch n := len(payload); {
+	case n < 126:
+		hdr[1] = byte(n)
+	case n <= 0xFFFF:
+		hdr[1] = 126
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(n))
+	default:
+		hdr[1] = 127
+		hdr = binary.BigEndian.AppendUint64(hdr, uint64(n))
+	}
+	_ = c.conn.SetWriteDeadline(time.Now().Add(watchHeartbeat))
+	_, err := c.conn.Write(append(hdr, payload...))
+	return err
+}
+
+// writeClose sends a close frame with code and reason.
+func (c *wsConn) writeClose(code uint16, reason string) error {
+	return c.writeFrame(wsOpClose, append(binary.BigEndian.AppendUint16(nil, code), reason...))
+}
+
+// readMessage returns the next text message, answering pings and
+// skipping pongs on the way. It returns io.EOF once the client closes.
+func (c *wsConn) readMessage() ([]byte, error) {
+	var msg []byte
+	inMessage := false
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			_ = c.writeFrame(wsOpClose, payload[:min(len(payload), 2)])
+			return nil, io.EOF
+		case wsOpText, wsOpBinary:
+			if inMessage {
+				return nil, errWSProtocol
+			}
+			if op == wsOpBinary {
+				return nil, fmt.Errorf("%w: binary messages are not supported", errWSProtocol)
+			}
+			inMessage = true
+		case wsOpContinuation:
+			if !inMessage {
+				return nil, errWSProtocol
+			}
+		default:
+			return nil, errWSProtocol
+		}
+		if len(msg)+len(payload) > wsMaxMessage {
+			return nil, errWSTooLarge
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+// readFrame reads and unmasks one frame. Clients must mask; control
+// frames may not be fragmented or exceed 125 bytes. A client silent for
+// two heartbeats, not even answering pings, times out.
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	_ = c.conn.SetReadDeadline(time.Now().Add(2 * watchHeartbeat))
+	var hdr [2]byte
+	if _, err = io.ReadFull(c.br, hdr[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, op = hdr[0]&0x80 != 0, hdr[0]&0x0F
+	if hdr[0]&0x70 != 0 || hdr[1]&0x80 == 0 {
+		return false, 0, nil, errWSProtocol // reserved bits, or unmasked
+	}
+	n := uint64(hdr[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if op >= wsOpClose && (!fin || n > 125) {
+		return false, 0, nil, errWSProtocol
+	}
+	if n > wsMaxMessage {
+		return false, 0, nil, errWSTooLarge
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// ----------- Subscribe API -----------
+
+// wsRequest is a client message: subscribe or unsubscribe. A
+// subscription follows keys, or a Pub/Sub channel.
+type wsRequest struct {
+	Op           string  `json:"op"`
+	Subscription string  `json:"subscription"`
+	Channel      string  `json:"channel,omitempty"`
+	Namespace    string  `json:"namespace,omitempty"`
+	Key          *string `json:"key,omitempty"`
+	Prefix       string  `json:"prefix,omitempty"`
+	After        *uint64 `json:"after,omitempty"` // resume after this event ID
+}
+
+// wsMessage is a server message. Op is "subscribed", "unsubscribed",
+// "event", "message" (from a channel), "dropped" (the subscription fell
+// behind and ended) or "error".
+type wsMessage struct {
+	Op           string              `json:"op"`
+	Subscription string              `json:"subscription,omitempty"`
+	Event        *watchEventJSON     `json:"event,omitempty"`
+	Message      *channelMessageJSON `json:"message,omitempty"`
+	Error        *apiError           `json:"error,omitempty"`
+}
+
+// wsSub is a live subscription; stop ends it, after which its pump
+// reports it unsubscribed.
+type wsSub struct {
+	stop func()
+}
+
+// wsSession is one connection's subscriptions.
+type wsSession struct {
+	s    *KVServer
+	p    principal
+	conn *wsConn
+	out  chan wsMessage
+	done chan struct{} // closed when the session ends
+
+	mu   sync.Mutex
+	subs map[string]*wsSub
+}
+
+// WebSocket: GET /ws upgrades to a WebSocket over which clients
+// subscribe to keys and prefixes, and unsubscribe, at will:
+//
+//	-> {"op": "subscribe", "subscription": "jobs", "prefix": "jobs:"}
+//	<- {"op": "subscribed", "subscription": "jobs"}
+//	<- {"op": "event", "subscription": "jobs", "event": {"id": 1729048273000042, "type": "set", "key": "jobs:1", ...}}
+//	-> {"op": "unsubscribe", "subscription": "jobs"}
+//
+// Events are those of GET /watch. A subscription takes "key" or "prefix",
+// an optional "namespace", and "after", an event ID to resume after. One
+// that falls behind gets "dropped" and can be resubscribed with "after".
+// A subscription with "channel" instead receives that Pub/Sub channel's
+// messages, as {"op": "message", ..., "message": {"channel": ..., "data": ...}}.
+// Browsers, which cannot set headers on WebSockets, may pass the API key
+// as ?access_token=.
+func (s *KVServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	s.metrics.TotalRequests.Add(1)
+	conn := upgradeWebSocket(w, r)
+	if conn == nil {
+		return
+	}
+	defer conn.conn.Close()
+
+	p, _ := principalFrom(r.Context())
+	sess := &wsSession{
+		s:    s,
+		p:    p,
+		conn: conn,
+		out:  make(chan wsMessage, wsOutBuffer),
+		done: make(chan struct{}),
+		subs: make(map[string]*wsSub),
+	}
+	go sess.writeLoop()
+	defer func() {
+		close(sess.done)
+		sess.unsubscribeAll()
+	}()
+
+	for {
+		msg, err := conn.readMessage()
+		if err != nil {
+			switch {
+			case errors.Is(err, errWSTooLarge):
+				_ = conn.writeClose(1009, err.Error())
+			case errors.Is(err, errWSProtocol):
+				_ = conn.writeClose(1002, err.Error())
+			}
+			return
+		}
+		var req wsRequest
+		if err := json.Unmarshal(msg, &req); err != nil {
+			sess.send(wsMessage{Op: "error", Error: &apiError{Code: codeInvalidBody, Message: "invalid message: " + err.Error()}})
+			continue
+		}
+		sess.handle(req)
+	}
+}
+
+// handle runs one client request.
+func (sess *wsSession) handle(req wsRequest) {
+	fail := func(serr *statusError) {
+		sess.send(wsMessage{Op: "error", Subscription: req.Subscription, Error: &apiError{Code: serr.code, Message: serr.message}})
+	}
+	if req.Subscription == "" {
+		fail(&statusError{http.StatusBadRequest, codeBadRequest, "subscription is required"})
+		return
+	}
+	switch req.Op {
+	case "subscribe":
+		if req.Channel != "" {
+			if req.Key != nil || req.Prefix != "" || req.Namespace != "" || req.After != nil {
+				fail(&statusError{http.StatusBadRequest, codeBadRequest, "a channel subscription takes no key, prefix, namespace or after"})
+				return
+			}
+			if serr := sess.s.checkChannel(req.Channel); serr != nil {
+				fail(serr)
+				return
+			}
+			if !sess.claim(req.Subscription) {
+				fail(&statusError{http.StatusConflict, codeBadRequest, "subscription " + req.Subscription + " already exists"})
+				return
+			}
+			sub := sess.s.pubsub.subscribe(req.Channel)
+			ws := &wsSub{stop: func() { sess.s.pubsub.unsubscribe(sub, req.Channel) }}
+			sess.start(req.Subscription, ws)
+			go sess.pumpChannel(req.Subscription, ws, sub)
+			return
+		}
+		if req.Key != nil && req.Prefix != "" {
+			fail(&statusError{http.StatusBadRequest, codeBadRequest, "pass key or prefix, not both"})
+			return
+		}
+		var key string
+		if req.Key != nil {
+			key = *req.Key
+		}
+		t, serr := sess.s.newWatchTarget(sess.p, req.Namespace, key, req.Key != nil, req.Prefix)
+		if serr != nil {
+			fail(serr)
+			return
+		}
+		if !sess.claim(req.Subscription) {
+			fail(&statusError{http.StatusConflict, codeBadRequest, "subscription " + req.Subscription + " already exists"})
+			return
+		}
+		var after uint64
+		if req.After != nil {
+			after = *req.After
+		}
+		sub, backlog, err := sess.s.events.subscribe(t.matches, after, req.After != nil)
+		if err != nil {
+			fail(&statusError{http.StatusGone, codeWatchCompacted, err.Error()})
+			return
+		}
+		ws := &wsSub{stop: func() { sess.s.events.unsubscribe(sub) }}
+		sess.start(req.Subscription, ws)
+		go sess.pump(req.Subscription, ws, t, sub, backlog)
+	case "unsubscribe":
+		sess.mu.Lock()
+		sub, ok := sess.subs[req.Subscription]
+		delete(sess.subs, req.Subscription)
+		sess.mu.Unlock()
+		if !ok {
+			fail(&statusError{http.StatusNotFound, codeNotFound, "no subscription " + req.Subscription})
+			return
+		}
+		sub.stop()
+	default:
+		fail(&statusError{http.StatusBadRequest, codeBadRequest, `op must be "subscribe" or "unsubscribe"`})
+	}
+}
+
+// claim reserves name for a subscription about to start, unless it is
+// taken. Requests are handled one at a time, so start follows before
+// anything else can claim it.
+func (sess *wsSession) claim(name string) bool {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	_, taken := sess.subs[name]
+	return !taken
+}
+
+// start records ws under name and confirms it.
+func (sess *wsSession) start(name string, ws *wsSub) {
+	sess.mu.Lock()
+	sess.subs[name] = ws
+	sess.mu.Unlock()
+	sess.send(wsMessage{Op: "subscribed", Subscription: name})
+}
+
+// pump forwards a key subscription's events until it ends: unsubscribed,
+// dropped by the feed, or the session closing.
+func (sess *wsSession) pump(name string, ws *wsSub, t watchTarget, sub *feedSub, backlog []watchEvent) {
+	forward := func(we watchEvent) {
+		if sess.s.watchAllowed(sess.p, t, we.Key) {
+			ev := t.event(we, sess.s.valueKeys)
+			sess.send(wsMessage{Op: "event", Subscription: name, Event: &ev})
+		}
+	}
+	for _, we := range backlog {
+		forward(we)
+	}
+	for we := range sub.ch {
+		forward(we)
+	}
+	sess.ended(name, ws)
+}
+
+// pumpChannel forwards a channel subscription's messages until it ends.
+func (sess *wsSession) pumpChannel(name string, ws *wsSub, sub *channelSub) {
+	for m := range sub.ch {
+		msg := newChannelMessageJSON(m)
+		sess.send(wsMessage{Op: "message", Subscription: name, Message: &msg})
+	}
+	sess.ended(name, ws)
+}
+
+// ended reports the end of subscription ws: "unsubscribed" if the client
+// or session ended it, "dropped" if it fell behind.
+func (sess *wsSession) ended(name string, ws *wsSub) {
+	sess.mu.Lock()
+	current := sess.subs[name] == ws
+	if current {
+		delete(sess.subs, name)
+	}
+	sess.mu.Unlock()
+	if current {
+		ws.stop() // already closed; unregisters a channel subscription
+		sess.send(wsMessage{Op: "dropped", Subscription: name})
+	} else {
+		sess.send(wsMessage{Op: "unsubscribed", Subscription: name})
+	}
+}
+
+// send queues msg for the writer, unless the session has ended.
+func (sess *wsSession) send(msg wsMessage) {
+	select {
+	case sess.out <- msg:
+	case <-sess.done:
+	}
+}
+
+// writeLoop writes queued messages and pings the client while idle. It
+// closes the connection when the session or the server ends, which also
+// stops the read loop.
+func (sess *wsSession) writeLoop() {
+	ping := time.NewTicker(watchHeartbeat)
+	defer ping.Stop()
+	for {
+		var err error
+		select {
+		case msg := <-sess.out:
+			data, _ := json.Marshal(msg)
+			err = sess.conn.writeFrame(wsOpText, data)
+		case <-ping.C:
+			err = sess.conn.writeFrame(wsOpPing, nil)
+		case <-sess.s.events.done:
+			_ = sess.conn.writeClose(1001, "server shutting down")
+			sess.conn.conn.Close()
+			return
+		case <-sess.done:
+			return
+		}
+		if err != nil {
+			slog.Debug("websocket: write failed", "err", err)
+			sess.conn.conn.Close()
+			return
+		}
+	}
+}
+
+func (sess *wsSession) unsubscribeAll() {
+	sess.mu.Lock()
+	subs := sess.subs
+	sess.subs = make(map[string]*wsSub)
+	sess.mu.Unlock()
+	for _, ws := range subs {
+		ws.stop()
+	}
+}
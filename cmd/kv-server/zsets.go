@@ -0,0 +1,503 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/shubhamc1947/safemap/pkg/concurrentmap"
+)
+
+// ----------- Sorted Sets -----------
+
+// A key can hold a sorted set: members with scores, kept in score order,
+// for leaderboards and rankings:
+//
+//	POST /zsets/{key}/add    {"members": {"ann": 120, "bob": 95}}   -> {"added": 2}
+//	POST /zsets/{key}/incrby {"member": "bob", "delta": 30}         -> {"score": 125}
+//	POST /zsets/{key}/remove {"members": ["ann"]}                   -> {"removed": 1}
+//	GET  /zsets/{key}/score?member=bob                              -> {"score": 125}
+//	GET  /zsets/{key}/rank?member=bob&rev=true                      -> {"rank": 0}
+//	GET  /zsets/{key}/range?start=0&stop=9&rev=true                 -> {"members": [{"member": "bob", "score": 125}]}
+//	GET  /zsets/{key}/rangebyscore?min=100&max=+inf                 -> {"members": [...]}
+//	GET  /zsets/{key}/card                                          -> {"cardinality": 1}
+//
+// /v1/{ns}/zsets/{key}/... works inside a namespace. Members with equal
+// scores are ordered by member. A sorted set is a value of type
+// application/x-zset+json, read and written whole as a JSON object from
+// members to scores, so it expires, persists and replicates like any
+// other. As in Redis, removing the last member deletes the key.
+//
+// Its members live in a SortedSetMap, following the store's events, and
+// the store keeps a header (see collections.go): an edit updates the
+// members it names in place and the AOF logs only those, so edits and
+// ranks cost O(log n) however large the set grows.
+
+const zsetContentType = "application/x-zset+json"
+
+var (
+	errNotZSet    = errors.New("the value is not a sorted set")
+	errScoreRange = errors.New("the score would not be a finite number")
+)
+
+// zsetPath splits a sorted set route, /zsets/{key}/{op} or
+// /v1/{ns}/zsets/{key}/{op}.
+func zsetPath(path string) (ns, key string, ok bool) {
+	return typedPath(path, "zsets")
+}
+
+func isZSetPath(path string) bool {
+	_, _, ok := zsetPath(path)
+	return ok
+}
+
+// decodeZSet returns the scores of the sorted set stored whole in v,
+// decrypted with keys, none if v is not live.
+func decodeZSet(v StoredValue, live bool, keys *keyring) (map[string]float64, error) {
+	if !live {
+		return map[string]float64{}, nil
+	}
+	if v.ContentType != zsetContentType || v.Spill != "" {
+		return nil, errNotZSet
+	}
+	var scores map[string]float64
+	if err := json.Unmarshal(v.plain(keys).Data, &scores); err != nil {
+		return nil, errNotZSet
+	}
+	if scores == nil {
+		scores = map[string]float64{}
+	}
+	return scores, nil
+}
+
+// zsetMemberSize estimates the length of member and its score.
+func zsetMemberSize(member string) int64 {
+	return int64(len(member)) + 32
+}
+
+// zsetIndex holds the members of each sorted set, by store key.
+type zsetIndex struct {
+	sets *concurrentmap.SortedSetMap[string]
+	keys *keyring // decrypts whole sorted sets; nil = no keyring
+}
+
+func newZSetIndex(store *concurrentmap.ConcurrentMap[string, StoredValue], keys *keyring) *zsetIndex {
+	ix := &zsetIndex{sets: concurrentmap.NewStringSortedSetMap(64), keys: keys}
+	store.Subscribe(ix.track)
+	return ix
+}
+
+// track keeps the index current: it applies a header's edit, or replaces
+// the members with those of a whole value. It runs under the store's
+// bucket lock, so it only touches the index, which has locks of its own.
+// Scores are never NaN (JSON has no NaN, and incrby rejects sums that
+// are not finite), so the index cannot refuse them.
+func (ix *zsetIndex) track(ev concurrentmap.Event[string, StoredValue]) {
+	if ev.Type == concurrentmap.EventInsert || ev.Type == concurrentmap.EventUpdate {
+		if v := ev.NewValue; v.Elements && v.ContentType == zsetContentType {
+			if edit := editOf(ev); edit != nil {
+				ix.apply(ev.Key, edit)
+			}
+			return
+		}
+		if scores, err := decodeZSet(ev.NewValue, true, ix.keys); err == nil {
+			members := make([]concurrentmap.ScoredMember, 0, len(scores))
+			for m, score := range scores {
+				members = append(members, concurrentmap.ScoredMember{Member: m, Score: score})
+			}
+			_ = ix.sets.Replace(ev.Key, members...)
+			return
+		}
+	}
+	if ev.Type != concurrentmap.EventInsert && ev.OldValue.ContentType == zsetContentType {
+		ix.sets.Delete(ev.Key)
+	}
+}
+
+func (ix *zsetIndex) apply(key string, edit *collectionEdit) {
+	if edit.Reset {
+		ix.sets.Delete(key)
+	}
+	for m, score := range edit.Scores {
+		_, _ = ix.sets.ZAdd(key, m, score)
+	}
+	for _, m := range edit.Remove {
+		ix.sets.ZRem(key, m)
+	}
+}
+
+// zsetMemberJSON is a member and its score in responses.
+type zsetMemberJSON struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+func newZSetMembersJSON(members []concurrentmap.ScoredMember) []zsetMemberJSON {
+	out := make([]zsetMemberJSON, 0, len(members))
+	for _, m := range members {
+		out = append(out, zsetMemberJSON{m.Member, m.Score})
+	}
+	return out
+}
+
+// handleZSet routes the /zsets/{key}/{op} routes (and their namespaced
+// form) to the op.
+func (s *KVServer) handleZSet(w http.ResponseWriter, r *http.Request) {
+	key, op := splitKeyAction(r.PathValue("key"))
+	read := r.Method == http.MethodGet || r.Method == http.MethodHead
+	var handler func(w http.ResponseWriter, r *http.Request, key string, ns *namespace)
+	switch {
+	case r.Method == http.MethodPost && (op == "add" || op == "incrby" || op == "remove"):
+		handler = func(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+			s.handleZSetEdit(w, r, key, ns, op)
+		}
+	case read && op == "score":
+		handler = s.handleZSetScore
+	case read && op == "rank":
+		handler = s.handleZSetRank
+	case read && (op == "range" || op == "rangebyscore"):
+		handler = func(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+			s.handleZSetRange(w, r, key, ns, op == "rangebyscore")
+		}
+	case read && op == "card":
+		handler = s.handleZSetCard
+	default:
+		writeError(w, r, http.StatusNotFound, codeNotFound,
+			"use /zsets/{key}/add, /incrby, /remove, /score, /rank, /range, /rangebyscore or /card")
+		return
+	}
+	r.SetPathValue("key", key)
+	s.keyHandler(handler)(w, r)
+}
+
+// zsetEdit is the body of add, incrby and remove; each uses its own
+// fields.
+type zsetEdit struct {
+	Members json.RawMessage `json:"members"`
+	Member  string          `json:"member"`
+	Delta   float64         `json:"delta"`
+}
+
+// ZADD / ZINCRBY / ZREM: POST /zsets/{key}/add {"members": {"ann": 120}}
+// sets the scores of members of the sorted set at key, creating it if
+// missing, and returns how many were new; /incrby {"member": "ann",
+// "delta": 5} adds to one member's score (from 0 if it is new) and
+// returns the new score; /remove {"members": ["ann"]} removes members and
+// returns how many were there. Each edit is one atomic step. An existing
+// sorted set keeps its TTL, lease and tags. Sorted sets are not held to
+// --max-value-size; quotas and --max-memory apply.
+func (s *KVServer) handleZSetEdit(w http.ResponseWriter, r *http.Request, key string, ns *namespace, op string) {
+	s.metrics.TotalPuts.Add(1)
+	var req zsetEdit
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidBody, "invalid body: "+err.Error())
+		return
+	}
+	var (
+		add    map[string]float64
+		remove []string
+	)
+	switch op {
+	case "add":
+		if err := json.Unmarshal(req.Members, &add); err != nil || len(add) == 0 {
+			writeError(w, r, http.StatusBadRequest, codeInvalidBody, `members must map members to scores, e.g. {"ann": 120}`)
+			return
+		}
+	case "remove":
+		if err := json.Unmarshal(req.Members, &remove); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidBody, `members must list members, e.g. ["ann"]`)
+			return
+		}
+	}
+
+	p, _ := principalFrom(r.Context())
+	if op != "remove" {
+		size := zsetMemberSize(req.Member)
+		for m := range add {
+			size += zsetMemberSize(m)
+		}
+		if cur, ok := s.store.Get(key); ok && !cur.isExpired(time.Now()) {
+			size += cur.size()
+		}
+		if serr := s.quotaError(p, ns, key, size); serr != nil {
+			serr.write(w, r)
+			return
+		}
+	}
+	if ns != nil {
+		ns.stats.puts.Add(1)
+	}
+
+	var (
+		changed int
+		score   float64
+	)
+	_, sp := s.tracer.start(r.Context(), "store.zset_"+op, spanKindInternal)
+	err := s.store.ComputeErr(key, func(old StoredValue, exists bool) (StoredValue, bool, error) {
+		now := time.Now()
+		live := exists && !old.isExpired(now)
+		switch {
+		case !live:
+		case old.ContentType != zsetContentType:
+			return old, exists, errNotZSet
+		case !old.Elements:
+			// Stored whole: its members are in the index if it decodes.
+			if _, err := decodeZSet(old, true, s.valueKeys); err != nil {
+				return old, exists, err
+			}
+		}
+		scoreOf := func(member string) (float64, bool) {
+			if !live {
+				return 0, false
+			}
+			return s.zsets.sets.ZScore(key, member)
+		}
+		edit := &collectionEdit{Reset: !live, version: s.nextVersion()}
+		size := int64(0)
+		if live {
+			size = old.size()
+		}
+		changed = 0
+		switch op {
+		case "add":
+			for m, sc := range add {
+				prev, ok := scoreOf(m)
+				if !ok {
+					changed++
+					size += zsetMemberSize(m)
+				}
+				if !ok || prev != sc {
+					if edit.Scores == nil {
+						edit.Scores = make(map[string]float64, len(add))
+					}
+					edit.Scores[m] = sc
+				}
+			}
+			if edit.Scores == nil {
+				return old, exists, nil
+			}
+		case "incrby":
+			prev, ok := scoreOf(req.Member)
+			score = prev + req.Delta
+			if math.IsInf(score, 0) || math.IsNaN(score) {
+				return old, exists, errScoreRange
+			}
+			if !ok {
+				size += zsetMemberSize(req.Member)
+			}
+			edit.Scores = map[string]float64{req.Member: score}
+		case "remove":
+			seen := make(map[string]bool, len(remove))
+			for _, m := range remove {
+				if _, ok := scoreOf(m); ok && !seen[m] {
+					seen[m] = true
+					edit.Remove = append(edit.Remove, m)
+					size -= zsetMemberSize(m)
+				}
+			}
+			changed = len(edit.Remove)
+			if changed == 0 {
+				return old, exists, nil
+			}
+			if changed == s.zsets.sets.ZCard(key) {
+				return old, false, nil
+			}
+		}
+		return s.newHeader(p, ns, zsetContentType, old, live, edit, size, now), true, nil
+	})
+	sp.finish()
+	switch {
+	case errors.Is(err, errNotZSet):
+		writeError(w, r, http.StatusConflict, codeNotZSet, err.Error())
+		return
+	case errors.Is(err, errScoreRange):
+		writeError(w, r, http.StatusConflict, codeOverflow, err.Error())
+		return
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	if !s.persist(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	switch op {
+	case "add":
+		_ = json.NewEncoder(w).Encode(map[string]int{"added": changed})
+	case "incrby":
+		_ = json.NewEncoder(w).Encode(map[string]float64{"score": score})
+	case "remove":
+		_ = json.NewEncoder(w).Encode(map[string]int{"removed": changed})
+	}
+}
+
+// liveZSet is liveOfType for sorted sets.
+func (s *KVServer) liveZSet(w http.ResponseWriter, r *http.Request, key string, ns *namespace) (live, ok bool) {
+	return s.liveOfType(w, r, key, ns, zsetContentType, statusError{http.StatusConflict, codeNotZSet, errNotZSet.Error()})
+}
+
+// zsetMember returns the member query parameter, writing the error
+// response if it is missing.
+func zsetMember(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if !r.URL.Query().Has("member") {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "missing member")
+		return "", false
+	}
+	return r.URL.Query().Get("member"), true
+}
+
+// zsetRev parses the rev query parameter.
+func zsetRev(w http.ResponseWriter, r *http.Request) (rev, ok bool) {
+	v := r.URL.Query().Get("rev")
+	if v == "" {
+		return false, true
+	}
+	rev, err := strconv.ParseBool(v)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "rev must be true or false")
+		return false, false
+	}
+	return rev, true
+}
+
+// ZSCORE: GET /zsets/{key}/score?member=bob returns the member's score,
+// null if it is not in the sorted set.
+func (s *KVServer) handleZSetScore(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+	s.metrics.TotalGets.Add(1)
+	member, ok := zsetMember(w, r)
+	if !ok {
+		return
+	}
+	live, ok := s.liveZSet(w, r, key, ns)
+	if !ok {
+		return
+	}
+	var score *float64
+	if live {
+		if sc, found := s.zsets.sets.ZScore(key, member); found {
+			score = &sc
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]*float64{"score": score})
+}
+
+// ZRANK: GET /zsets/{key}/rank?member=bob returns the member's 0-based
+// rank by ascending score, or with rev=true by descending score, null if
+// it is not in the sorted set.
+func (s *KVServer) handleZSetRank(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+	s.metrics.TotalGets.Add(1)
+	member, ok := zsetMember(w, r)
+	if !ok {
+		return
+	}
+	rev, ok := zsetRev(w, r)
+	if !ok {
+		return
+	}
+	live, ok := s.liveZSet(w, r, key, ns)
+	if !ok {
+		return
+	}
+	var rank *int
+	if live {
+		rankOf := s.zsets.sets.ZRank
+		if rev {
+			rankOf = s.zsets.sets.ZRevRank
+		}
+		if n, found := rankOf(key, member); found {
+			rank = &n
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]*int{"rank": rank})
+}
+
+// ZRANGE: GET /zsets/{key}/range?start=0&stop=9 returns the members from
+// rank start to stop, both inclusive (default all), by ascending score,
+// or with rev=true by descending score; negative ranks count back from
+// the end. /rangebyscore?min=100&max=200 returns the members with scores
+// in [min, max] (default all; -inf and +inf are accepted), by ascending
+// score.
+//
+//	{"members": [{"member": "bob", "score": 125}]}
+func (s *KVServer) handleZSetRange(w http.ResponseWriter, r *http.Request, key string, ns *namespace, byScore bool) {
+	s.metrics.TotalGets.Add(1)
+	q := r.URL.Query()
+	start, stop := 0, -1
+	lo, hi := math.Inf(-1), math.Inf(1)
+	if byScore {
+		for _, param := range []struct {
+			name string
+			dst  *float64
+		}{{"min", &lo}, {"max", &hi}} {
+			if v := q.Get(param.name); v != "" {
+				f, err := strconv.ParseFloat(v, 64)
+				if err != nil || math.IsNaN(f) {
+					writeError(w, r, http.StatusBadRequest, codeBadRequest, "invalid "+param.name)
+					return
+				}
+				*param.dst = f
+			}
+		}
+	} else {
+		for _, param := range []struct {
+			name string
+			dst  *int
+		}{{"start", &start}, {"stop", &stop}} {
+			if v := q.Get(param.name); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil {
+					writeError(w, r, http.StatusBadRequest, codeBadRequest, "invalid "+param.name)
+					return
+				}
+				*param.dst = n
+			}
+		}
+	}
+	rev, ok := zsetRev(w, r)
+	if !ok {
+		return
+	}
+	if rev && byScore {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "rev applies to range, not rangebyscore")
+		return
+	}
+	live, ok := s.liveZSet(w, r, key, ns)
+	if !ok {
+		return
+	}
+
+	var members []concurrentmap.ScoredMember
+	if live {
+		switch {
+		case byScore:
+			members = s.zsets.sets.ZRangeByScore(key, lo, hi)
+		case rev:
+			members = s.zsets.sets.ZRevRange(key, start, stop)
+		default:
+			members = s.zsets.sets.ZRange(key, start, stop)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string][]zsetMemberJSON{"members": newZSetMembersJSON(members)})
+}
+
+// ZCARD: GET /zsets/{key}/card returns the number of members of the
+// sorted set at key, 0 if it is missing.
+func (s *KVServer) handleZSetCard(w http.ResponseWriter, r *http.Request, key string, ns *namespace) {
+	s.metrics.TotalGets.Add(1)
+	live, ok := s.liveZSet(w, r, key, ns)
+	if !ok {
+		return
+	}
+	n := 0
+	if live {
+		n = s.zsets.sets.ZCard(key)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]int{"cardinality": n})
+}
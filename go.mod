@@ -1,3 +1,5 @@
 module github.com/shubhamc1947/safemap
 
-go 1.23.3
+go 1.24
+
+require google.golang.org/protobuf v1.36.11
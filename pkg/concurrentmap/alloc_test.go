@@ -0,0 +1,51 @@
+package concurrentmap
+
+import "testing"
+
+// The hot path (string keys, existing entries, no hooks configured) is
+// documented as allocation-free; these tests keep it that way.
+
+func TestHotPathAllocations(t *testing.T) {
+	m := NewStringMap[int](16)
+	m.Set("key", 1)
+	c := NewStringCounterMap(16)
+	c.Inc("hits", 1)
+	var structKey any = struct{ A, B int }{1, 2}
+
+	cases := []struct {
+		name string
+		fn   func()
+	}{
+		{"Set", func() { m.Set("key", 2) }},
+		{"Get", func() { m.Get("key") }},
+		{"Delete", func() { m.Delete("missing") }},
+		{"LoadOrStore", func() { m.LoadOrStore("key", 3) }},
+		{"Compute", func() {
+			m.Compute("key", func(old int, _ bool) (int, bool) { return old + 1, true })
+		}},
+		{"CounterMap.Inc", func() { c.Inc("hits", 1) }},
+		{"FNV64a", func() { FNV64a("some/longer/key:12345") }},
+		{"XXHash64", func() { XXHash64("some/longer/key:12345") }},
+		{"hashAny", func() { hashAny(structKey) }},
+	}
+
+	for _, tc := range cases {
+		if n := testing.AllocsPerRun(1000, tc.fn); n != 0 {
+			t.Errorf("%s: expected 0 allocations, got %v", tc.name, n)
+		}
+	}
+}
+
+func TestXXHash64KnownValues(t *testing.T) {
+	cases := map[string]uint64{
+		"":    0xef46db3751d8e999,
+		"a":   0xd24ec4f1a98c6e5b,
+		"abc": 0x44bc2cf5ad770999,
+		"Nobody inspects the spammish repetition": 0xfbcea83c8a378bf1,
+	}
+	for in, want := range cases {
+		if got := XXHash64(in); got != want {
+			t.Errorf("XXHash64(%q) = %#x, want %#x", in, got, want)
+		}
+	}
+}
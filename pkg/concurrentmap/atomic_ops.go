@@ -1,9 +1,15 @@
 package concurrentmap
 
+import (
+	"maps"
+	"slices"
+)
+
 // LoadOrStore returns the existing value if present.
 // Otherwise, it stores the new value and returns it.
 // loaded = true → value already existed
 // loaded = false → value was inserted
+// If a Writer rejects v, nothing is stored and the zero value is returned.
 func (cm *ConcurrentMap[K, V]) LoadOrStore(k K, v V) (actual V, loaded bool) {
 	idx := cm.bucketIndexForKey(k)
 	b := &cm.buckets[idx]
@@ -11,11 +17,17 @@ func (cm *ConcurrentMap[K, V]) LoadOrStore(k K, v V) (actual V, loaded bool) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	if existing, ok := b.m[k]; ok {
+	existing, ok := b.m.Get(k)
+	if ok {
 		return existing, true
 	}
 
-	b.m[k] = v
+	if err := cm.writeThrough(k, v); err != nil {
+		return existing, false
+	}
+
+	b.m.Set(k, v)
+	cm.emit(EventInsert, k, existing, v)
 	return v, false
 }
 
@@ -23,20 +35,134 @@ func (cm *ConcurrentMap[K, V]) LoadOrStore(k K, v V) (actual V, loaded bool) {
 // fn(oldValue, exists) returns (newValue, keep)
 // If keep = false → key is deleted
 // If keep = true  → key is updated to newValue
+// With a Writer configured, a failed write-through discards the update.
 func (cm *ConcurrentMap[K, V]) Compute(k K, fn func(old V, exists bool) (newV V, keep bool)) {
+	computeWith(cm, k, fn, callCompute[V])
+}
+
+func callCompute[V any](old V, exists bool, fn func(V, bool) (V, bool)) (V, bool) {
+	return fn(old, exists)
+}
+
+// computeWith is Compute with an explicit argument for fn and the
+// resulting value returned. Passing state through arg instead of
+// capturing it lets hot callers use a static function, so no closure
+// is allocated per call.
+func computeWith[K comparable, V, A any](cm *ConcurrentMap[K, V], k K, arg A, fn func(old V, exists bool, arg A) (V, bool)) (V, bool) {
 	idx := cm.bucketIndexForKey(k)
 	b := &cm.buckets[idx]
 
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	old, exists := b.m[k]
-	newVal, keep := fn(old, exists)
+	old, exists := b.m.Get(k)
+	newVal, keep := fn(old, exists, arg)
 
 	if !keep {
-		delete(b.m, k)
-		return
+		if exists {
+			if err := cm.deleteThrough(k); err != nil {
+				return old, true
+			}
+			b.m.Delete(k)
+			cm.emit(EventDelete, k, old, old)
+		}
+		return newVal, false
+	}
+
+	if err := cm.writeThrough(k, newVal); err != nil {
+		return old, false
+	}
+
+	b.m.Set(k, newVal)
+	if exists {
+		cm.emit(EventUpdate, k, old, newVal)
+	} else {
+		cm.emit(EventInsert, k, old, newVal)
+	}
+	return newVal, true
+}
+
+// ComputeMany applies fn to several keys as one atomic step. It
+// write-locks every bucket holding one of keys, always in ascending
+// bucket order so concurrent calls cannot deadlock, and passes fn the
+// values of those keys that are present. fn returns the values to set
+// and the keys to delete, a key in both being set. Both may only name
+// keys from keys, or the call fails with ErrNotLocked. If fn fails,
+// nothing is committed and its error is returned unchanged.
+//
+// With a Writer or Deleter configured, every set and delete is passed
+// through before any is applied; a failure discards them all, though the
+// backing store may already hold the changes passed before it.
+func (cm *ConcurrentMap[K, V]) ComputeMany(keys []K, fn func(current map[K]V) (set map[K]V, del []K, err error)) error {
+	idx := make([]int, 0, len(keys))
+	locked := make(map[K]bool, len(keys))
+	for _, k := range keys {
+		idx = append(idx, cm.bucketIndexForKey(k))
+		locked[k] = true
+	}
+	slices.Sort(idx)
+	idx = slices.Compact(idx)
+	for _, i := range idx {
+		cm.buckets[i].mu.Lock()
+	}
+	defer func() {
+		for _, i := range idx {
+			cm.buckets[i].mu.Unlock()
+		}
+	}()
+
+	current := make(map[K]V, len(keys))
+	for k := range locked {
+		if v, ok := cm.buckets[cm.bucketIndexForKey(k)].m.Get(k); ok {
+			current[k] = v
+		}
+	}
+	set, del, err := fn(maps.Clone(current))
+	if err != nil {
+		return err
+	}
+	for k := range set {
+		if !locked[k] {
+			return keyErr("compute_many", k, ErrNotLocked)
+		}
+	}
+	for _, k := range del {
+		if !locked[k] {
+			return keyErr("compute_many", k, ErrNotLocked)
+		}
+	}
+	for k, v := range set {
+		if err := cm.writeThrough(k, v); err != nil {
+			return err
+		}
+	}
+	for _, k := range del {
+		if _, overwritten := set[k]; overwritten {
+			continue
+		}
+		if _, exists := current[k]; exists {
+			if err := cm.deleteThrough(k); err != nil {
+				return err
+			}
+		}
 	}
 
-	b.m[k] = newVal
+	for _, k := range del {
+		old, exists := current[k]
+		if _, overwritten := set[k]; !exists || overwritten {
+			continue
+		}
+		cm.buckets[cm.bucketIndexForKey(k)].m.Delete(k)
+		cm.emit(EventDelete, k, old, old)
+		delete(current, k)
+	}
+	for k, v := range set {
+		cm.buckets[cm.bucketIndexForKey(k)].m.Set(k, v)
+		if old, exists := current[k]; exists {
+			cm.emit(EventUpdate, k, old, v)
+		} else {
+			cm.emit(EventInsert, k, old, v)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,115 @@
+package concurrentmap
+
+import "sync"
+
+// Loader fetches a value from a backing store on a cache miss.
+type Loader[K comparable, V any] func(k K) (V, error)
+
+// Writer propagates a write to a backing store.
+type Writer[K comparable, V any] func(k K, v V) error
+
+// Deleter propagates a removal to a backing store.
+type Deleter[K comparable] func(k K) error
+
+// WithLoader makes Get fall through to load on a miss (read-through).
+// Concurrent misses for the same key share a single load call.
+func WithLoader[K comparable, V any](load Loader[K, V]) Option[K, V] {
+	return func(cm *ConcurrentMap[K, V]) {
+		cm.loader = load
+	}
+}
+
+// WithWriter makes every write call write first (write-through).
+// The writer runs under the key's bucket lock so the backing store sees
+// writes to a key in the same order as the map; if it fails, the map
+// is not updated.
+func WithWriter[K comparable, V any](write Writer[K, V]) Option[K, V] {
+	return func(cm *ConcurrentMap[K, V]) {
+		cm.writer = write
+	}
+}
+
+// WithDeleter makes every removal call del first: Delete, Compute and
+// ComputeMany dropping a key, PopRandom and ExpireIf. Like the Writer it
+// runs under the key's bucket lock, and if it fails the key is kept.
+// Pair it with WithLoader, or a Get after a Delete loads the key again.
+func WithDeleter[K comparable, V any](del Deleter[K]) Option[K, V] {
+	return func(cm *ConcurrentMap[K, V]) {
+		cm.deleter = del
+	}
+}
+
+func (cm *ConcurrentMap[K, V]) deleteThrough(k K) error {
+	if cm.deleter == nil {
+		return nil
+	}
+	return cm.deleter(k)
+}
+
+func (cm *ConcurrentMap[K, V]) writeThrough(k K, v V) error {
+	if cm.writer == nil {
+		return nil
+	}
+	return cm.writer(k, v)
+}
+
+// load runs the loader for k (deduplicated across goroutines) and caches
+// the result unless another writer stored a value in the meantime.
+func (cm *ConcurrentMap[K, V]) load(k K) (V, error) {
+	v, err := cm.loads.do(k, cm.loader)
+	if err != nil {
+		return v, err
+	}
+
+	idx := cm.bucketIndexForKey(k)
+	b := &cm.buckets[idx]
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	existing, ok := b.m.Get(k)
+	if ok {
+		return existing, nil
+	}
+	b.m.Set(k, v)
+	cm.emit(EventInsert, k, existing, v)
+	return v, nil
+}
+
+// ----------- Load Deduplication -----------
+
+type loadCall[V any] struct {
+	wg  sync.WaitGroup
+	v   V
+	err error
+}
+
+type loadGroup[K comparable, V any] struct {
+	mu    sync.Mutex
+	calls map[K]*loadCall[V]
+}
+
+func (g *loadGroup[K, V]) do(k K, fn Loader[K, V]) (V, error) {
+	g.mu.Lock()
+	if c, ok := g.calls[k]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.v, c.err
+	}
+	if g.calls == nil {
+		g.calls = make(map[K]*loadCall[V])
+	}
+	c := &loadCall[V]{}
+	c.wg.Add(1)
+	g.calls[k] = c
+	g.mu.Unlock()
+
+	c.v, c.err = fn(k)
+	c.wg.Done()
+
+	g.mu.Lock()
+	delete(g.calls, k)
+	g.mu.Unlock()
+
+	return c.v, c.err
+}
@@ -0,0 +1,269 @@
+package concurrentmap
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReadThroughLoader(t *testing.T) {
+	var calls atomic.Int32
+	db := map[string]int{"a": 1}
+
+	m := NewStringMap[int](16, WithLoader(func(k string) (int, error) {
+		calls.Add(1)
+		time.Sleep(10 * time.Millisecond) // let concurrent misses pile up
+		if v, ok := db[k]; ok {
+			return v, nil
+		}
+		return 0, errors.New("not found")
+	}))
+
+	var wg sync.WaitGroup
+	wg.Add(10)
+	for i := 0; i < 10; i++ {
+		go func() {
+			defer wg.Done()
+			if v, ok := m.Get("a"); !ok || v != 1 {
+				t.Errorf("expected a=1 via loader, got %v, ok=%v", v, ok)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Fatalf("expected concurrent misses to share one load, got %d", calls.Load())
+	}
+	if _, ok := m.Get("missing"); ok {
+		t.Fatalf("expected loader error to be reported as a miss")
+	}
+	if m.Len() != 1 {
+		t.Fatalf("expected only the loaded key to be cached, Len=%d", m.Len())
+	}
+}
+
+func TestWriteThroughWriter(t *testing.T) {
+	db := make(map[string]int)
+	errReadOnly := errors.New("read-only")
+
+	m := NewStringMap[int](16, WithWriter(func(k string, v int) error {
+		if k == "ro" {
+			return errReadOnly
+		}
+		db[k] = v
+		return nil
+	}))
+
+	m.Set("a", 1)
+	m.Compute("a", func(old int, _ bool) (int, bool) { return old + 1, true })
+	if db["a"] != 2 {
+		t.Fatalf("expected writes to reach the store, got %d", db["a"])
+	}
+
+	if err := m.TrySet("ro", 1); !errors.Is(err, errReadOnly) {
+		t.Fatalf("expected writer error, got %v", err)
+	}
+	if err := m.Insert("ro", 1); !errors.Is(err, errReadOnly) {
+		t.Fatalf("expected writer error from Insert, got %v", err)
+	}
+	if _, ok := m.Get("ro"); ok {
+		t.Fatalf("failed write-through must not update the map")
+	}
+}
+
+func TestDeleteThroughDeleter(t *testing.T) {
+	db := map[string]int{"a": 1, "b": 2, "c": 3, "d": 4, "e": 5}
+	errLocked := errors.New("locked")
+
+	m := NewStringMap[int](16,
+		WithLoader(func(k string) (int, error) {
+			if v, ok := db[k]; ok {
+				return v, nil
+			}
+			return 0, errors.New("not found")
+		}),
+		WithWriter(func(k string, v int) error {
+			db[k] = v
+			return nil
+		}),
+		WithDeleter[string, int](func(k string) error {
+			if k == "locked" {
+				return errLocked
+			}
+			delete(db, k)
+			return nil
+		}),
+	)
+
+	// Delete then Get must not load the deleted value back.
+	if v, ok := m.Get("a"); !ok || v != 1 {
+		t.Fatalf("expected a=1 via loader, got %v, ok=%v", v, ok)
+	}
+	m.Delete("a")
+	if v, ok := m.Get("a"); ok {
+		t.Fatalf("Get after Delete loaded the value again: %v", v)
+	}
+
+	m.Get("b")
+	m.Compute("b", func(int, bool) (int, bool) { return 0, false })
+	m.Get("c")
+	if err := m.ComputeErr("c", func(int, bool) (int, bool, error) { return 0, false, nil }); err != nil {
+		t.Fatal(err)
+	}
+	m.Get("d")
+	if err := m.ComputeMany([]string{"d"}, func(map[string]int) (map[string]int, []string, error) {
+		return nil, []string{"d"}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	m.Get("e")
+	if !m.ExpireIf("e", func(int) bool { return true }) {
+		t.Fatal("ExpireIf did not remove e")
+	}
+	m.Set("f", 6)
+	if _, _, ok := m.PopRandom(); !ok {
+		t.Fatal("PopRandom found nothing")
+	}
+	for _, k := range []string{"b", "c", "d", "e", "f"} {
+		if v, ok := db[k]; ok {
+			t.Fatalf("removal of %s did not reach the store (%d)", k, v)
+		}
+		if _, ok := m.Get(k); ok {
+			t.Fatalf("Get(%s) after removal loaded it again", k)
+		}
+	}
+
+	m.Set("locked", 7)
+	if err := m.DeleteErr("locked"); !errors.Is(err, errLocked) {
+		t.Fatalf("expected deleter error, got %v", err)
+	}
+	m.Delete("locked")
+	if v, ok := m.Get("locked"); !ok || v != 7 {
+		t.Fatalf("failed delete-through must keep the key, got %v, ok=%v", v, ok)
+	}
+}
+
+func TestWriteBehindCoalescesAndDrains(t *testing.T) {
+	m := NewStringMap[int](16)
+
+	var (
+		mu      sync.Mutex
+		store   = make(map[string]int)
+		batches int
+		fail    = true
+	)
+	sink := func(batch []Change[string, int]) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			fail = false
+			return errors.New("transient")
+		}
+		batches++
+		for _, c := range batch {
+			if c.Deleted {
+				delete(store, c.Key)
+			} else {
+				store[c.Key] = c.Value
+			}
+		}
+		return nil
+	}
+
+	wb := NewWriteBehind(m, sink, WriteBehindConfig{
+		FlushInterval: time.Hour, // only Drain flushes in this test
+		RetryBackoff:  time.Millisecond,
+	})
+
+	for i := 0; i < 100; i++ {
+		m.Set("counter", i)
+	}
+	m.Set("gone", 1)
+	m.Delete("gone")
+
+	if wb.Pending() != 2 {
+		t.Fatalf("expected 2 coalesced dirty keys, got %d", wb.Pending())
+	}
+
+	if err := wb.Drain(context.Background()); err != nil {
+		t.Fatalf("drain failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if batches != 1 || store["counter"] != 99 {
+		t.Fatalf("expected one retried batch with counter=99, got %d batches, %v", batches, store)
+	}
+	if _, ok := store["gone"]; ok {
+		t.Fatalf("expected deleted key to be absent from the sink")
+	}
+
+	m.Set("after", 1)
+	if wb.Pending() != 0 {
+		t.Fatalf("expected drained write-behind to stop tracking")
+	}
+}
+
+func TestWriteBehindConcurrentFlushes(t *testing.T) {
+	m := NewStringMap[int](16)
+
+	var (
+		inFlight atomic.Int32
+		mu       sync.Mutex
+		store    = make(map[string]int)
+	)
+	sink := func(batch []Change[string, int]) error {
+		if inFlight.Add(1) != 1 {
+			t.Error("sink called by two flushes at once")
+		}
+		defer inFlight.Add(-1)
+		time.Sleep(100 * time.Microsecond) // widen the window for overlap
+
+		mu.Lock()
+		defer mu.Unlock()
+		for _, c := range batch {
+			if prev, ok := store[c.Key]; ok && c.Value < prev {
+				t.Errorf("key %s went back from %d to %d", c.Key, prev, c.Value)
+			}
+			store[c.Key] = c.Value
+		}
+		return nil
+	}
+	wb := NewWriteBehind(m, sink, WriteBehindConfig{FlushInterval: time.Millisecond, MaxBatch: 8})
+
+	keys := []string{"a", "b", "c", "d"}
+	var wg sync.WaitGroup
+	for _, k := range keys {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 500 {
+				m.Set(k, i)
+			}
+		}()
+	}
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				_ = wb.Flush()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := wb.Drain(context.Background()); err != nil {
+		t.Fatalf("drain failed: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, k := range keys {
+		if store[k] != 499 {
+			t.Fatalf("expected %s=499 in the sink, got %d", k, store[k])
+		}
+	}
+}
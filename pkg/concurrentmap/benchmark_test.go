@@ -1,6 +1,7 @@
 package concurrentmap
 
 import (
+	"runtime"
 	"strconv"
 	"sync"
 	"testing"
@@ -40,6 +41,23 @@ func BenchmarkConcurrentMapGet(b *testing.B) {
 	})
 }
 
+func BenchmarkConcurrentMapGetCopyOnWrite(b *testing.B) {
+	m := NewStringMap[int](16, WithCopyOnWrite[string, int]())
+
+	for i := 0; i < 1000; i++ {
+		m.Set("k"+strconv.Itoa(i), i)
+	}
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			key := "k" + strconv.Itoa(i%1000)
+			m.Get(key)
+			i++
+		}
+	})
+}
+
 // ---------------------
 // Benchmark: sync.Map
 // ---------------------
@@ -113,3 +131,76 @@ func BenchmarkMutexMapGet(b *testing.B) {
 		}
 	})
 }
+
+// ------------------------------
+// Benchmark: string hashers
+// ------------------------------
+
+var hashKeys = []string{"k42", "user:1234567", "tenant/acme/sessions/6f1c2d9e-4b7a-4c1e-9f0a-2f6d8b1e7c3a"}
+
+func BenchmarkFNV64a(b *testing.B) {
+	for _, k := range hashKeys {
+		b.Run(strconv.Itoa(len(k)), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				FNV64a(k)
+			}
+		})
+	}
+}
+
+func BenchmarkXXHash64(b *testing.B) {
+	for _, k := range hashKeys {
+		b.Run(strconv.Itoa(len(k)), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				XXHash64(k)
+			}
+		})
+	}
+}
+
+// ------------------------------
+// Benchmark: bucket storage (Go map vs swiss table)
+// ------------------------------
+
+// Run with -bench Storage -benchtime=1x to compare memory (B/entry) and
+// lookup latency; the 10M case is skipped with -short.
+var storageSizes = []int{1 << 16, 1 << 20, 10_000_000}
+
+func intHasher(k int) uint64 { return uint64(k) }
+
+func benchmarkStorage(b *testing.B, opts ...Option[int, int]) {
+	for _, n := range storageSizes {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			if n > 1<<20 && testing.Short() {
+				b.Skip("large dataset skipped in -short mode")
+			}
+
+			var before, after runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+
+			m := New[int, int](64, intHasher, opts...)
+			for i := 0; i < n; i++ {
+				m.Set(i, i)
+			}
+
+			runtime.GC()
+			runtime.ReadMemStats(&after)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				m.Get(i % n)
+			}
+			b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/float64(n), "B/entry")
+			runtime.KeepAlive(m)
+		})
+	}
+}
+
+func BenchmarkStorageGoMap(b *testing.B) {
+	benchmarkStorage(b)
+}
+
+func BenchmarkStorageSwissTable(b *testing.B) {
+	benchmarkStorage(b, WithSwissTable[int, int]())
+}
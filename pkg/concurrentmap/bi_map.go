@@ -0,0 +1,90 @@
+package concurrentmap
+
+import "sync"
+
+// BiMap is a one-to-one map that can be queried in both directions.
+// Writers are serialized by a single lock so the two indexes are always
+// updated as a pair and a key is never left pointing at a value owned by
+// another key. Lookups share that lock, so they never see a pair half
+// written or half removed.
+type BiMap[K, V comparable] struct {
+	mu      sync.RWMutex // writers hold it exclusively, lookups shared
+	forward *ConcurrentMap[K, V]
+	inverse *ConcurrentMap[V, K]
+}
+
+// NewBiMap creates a BiMap with hashers for both directions.
+func NewBiMap[K, V comparable](numBuckets int, keyHasher Hasher[K], valueHasher Hasher[V]) *BiMap[K, V] {
+	return &BiMap[K, V]{
+		forward: New[K, V](numBuckets, keyHasher),
+		inverse: New[V, K](numBuckets, valueHasher),
+	}
+}
+
+// NewStringBiMap creates a BiMap with string keys and values.
+func NewStringBiMap(numBuckets int) *BiMap[string, string] {
+	return NewBiMap[string, string](numBuckets, fnv64a, fnv64a)
+}
+
+// Set pairs k with v. Any existing pairing of k, or of v, is removed
+// first so the mapping stays one-to-one.
+func (bm *BiMap[K, V]) Set(k K, v V) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	if oldV, ok := bm.forward.Get(k); ok {
+		bm.inverse.Delete(oldV)
+	}
+	if oldK, ok := bm.inverse.Get(v); ok {
+		bm.forward.Delete(oldK)
+	}
+
+	bm.forward.Set(k, v)
+	bm.inverse.Set(v, k)
+}
+
+// GetByKey returns the value paired with k.
+func (bm *BiMap[K, V]) GetByKey(k K) (V, bool) {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+
+	return bm.forward.Get(k)
+}
+
+// GetByValue returns the key paired with v.
+func (bm *BiMap[K, V]) GetByValue(v V) (K, bool) {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+
+	return bm.inverse.Get(v)
+}
+
+// DeleteByKey removes k and its paired value.
+func (bm *BiMap[K, V]) DeleteByKey(k K) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	if v, ok := bm.forward.Get(k); ok {
+		bm.forward.Delete(k)
+		bm.inverse.Delete(v)
+	}
+}
+
+// DeleteByValue removes v and its paired key.
+func (bm *BiMap[K, V]) DeleteByValue(v V) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	if k, ok := bm.inverse.Get(v); ok {
+		bm.inverse.Delete(v)
+		bm.forward.Delete(k)
+	}
+}
+
+// Len returns the number of pairs.
+func (bm *BiMap[K, V]) Len() int {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+
+	return bm.forward.Len()
+}
@@ -0,0 +1,114 @@
+package concurrentmap
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestBiMap(t *testing.T) {
+	bm := NewStringBiMap(16)
+
+	bm.Set("en", "hello")
+	bm.Set("fr", "bonjour")
+
+	if v, ok := bm.GetByKey("en"); !ok || v != "hello" {
+		t.Fatalf("expected en=hello, got %v, ok=%v", v, ok)
+	}
+	if k, ok := bm.GetByValue("bonjour"); !ok || k != "fr" {
+		t.Fatalf("expected bonjour→fr, got %v, ok=%v", k, ok)
+	}
+
+	// Re-pairing a value must drop the key that previously owned it.
+	bm.Set("de", "hello")
+	if _, ok := bm.GetByKey("en"); ok {
+		t.Fatalf("expected en to be unpaired")
+	}
+	if bm.Len() != 2 {
+		t.Fatalf("expected Len=2, got %d", bm.Len())
+	}
+
+	bm.DeleteByValue("bonjour")
+	if _, ok := bm.GetByKey("fr"); ok {
+		t.Fatalf("expected fr to be removed with its value")
+	}
+}
+
+func TestBiMapConcurrentStaysOneToOne(t *testing.T) {
+	bm := NewStringBiMap(8)
+
+	var wg sync.WaitGroup
+	wg.Add(16)
+	for g := 0; g < 16; g++ {
+		go func(id int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				bm.Set("k"+strconv.Itoa((id+i)%10), "v"+strconv.Itoa(i%7))
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if bm.forward.Len() != bm.inverse.Len() {
+		t.Fatalf("index sizes diverged: %d vs %d", bm.forward.Len(), bm.inverse.Len())
+	}
+	bm.forward.Range(func(k, v string) bool {
+		if back, ok := bm.GetByValue(v); !ok || back != k {
+			t.Fatalf("inverse of %s=%s is %s", k, v, back)
+		}
+		return true
+	})
+}
+
+func TestBiMapConcurrentReaders(t *testing.T) {
+	bm := NewStringBiMap(8)
+
+	var (
+		writers sync.WaitGroup
+		readers sync.WaitGroup
+		done    = make(chan struct{})
+	)
+	writers.Add(4)
+	for g := 0; g < 4; g++ {
+		go func(id int) {
+			defer writers.Done()
+			for i := 0; i < 500; i++ {
+				k := "k" + strconv.Itoa((id+i)%10)
+				if i%5 == 0 {
+					bm.DeleteByKey(k)
+					continue
+				}
+				bm.Set(k, "v"+strconv.Itoa(i%7))
+			}
+		}(g)
+	}
+
+	readers.Add(4)
+	for g := 0; g < 4; g++ {
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				for i := 0; i < 10; i++ {
+					if v, ok := bm.GetByKey("k" + strconv.Itoa(i)); ok && v == "" {
+						t.Errorf("k%d is paired with an empty value", i)
+					}
+					if k, ok := bm.GetByValue("v" + strconv.Itoa(i%7)); ok && k == "" {
+						t.Errorf("v%d is paired with an empty key", i%7)
+					}
+				}
+				if n := bm.Len(); n > 7 {
+					t.Errorf("%d pairs for 7 values", n)
+				}
+			}
+		}()
+	}
+
+	writers.Wait()
+	close(done)
+	readers.Wait()
+}
@@ -0,0 +1,129 @@
+package concurrentmap
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// FromMap builds a ConcurrentMap holding a copy of src. Entries are
+// grouped by bucket first, buckets are pre-sized when using the default
+// storage, and each bucket is filled under a single lock acquisition.
+// The bulk load does not go through Writer hooks or emit events.
+func FromMap[K comparable, V any](src map[K]V, numBuckets int, hasher Hasher[K], opts ...Option[K, V]) *ConcurrentMap[K, V] {
+	cm := New[K, V](numBuckets, hasher, opts...)
+
+	groups := make([][]Entry[K, V], numBuckets)
+	for k, v := range src {
+		idx := cm.bucketIndexForKey(k)
+		groups[idx] = append(groups[idx], Entry[K, V]{Key: k, Value: v})
+	}
+	cm.bulkLoad(groups)
+
+	return cm
+}
+
+// FromJSON builds a string-keyed ConcurrentMap from a JSON object,
+// e.g. {"a": 1, "b": 2}, streaming the decode straight into shard groups.
+func FromJSON[V any](r io.Reader, numBuckets int, opts ...Option[string, V]) (*ConcurrentMap[string, V], error) {
+	cm := New[string, V](numBuckets, fnv64a, opts...)
+	groups := make([][]Entry[string, V], numBuckets)
+
+	dec := json.NewDecoder(r)
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '{' {
+		return nil, fmt.Errorf("concurrentmap: FromJSON expects a JSON object, got %v", tok)
+	}
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key := tok.(string) // object keys are always strings
+
+		var v V
+		if err := dec.Decode(&v); err != nil {
+			return nil, fmt.Errorf("concurrentmap: decoding value for %q: %w", key, err)
+		}
+
+		idx := cm.bucketIndexForKey(key)
+		groups[idx] = append(groups[idx], Entry[string, V]{Key: key, Value: v})
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+
+	cm.bulkLoad(groups)
+	return cm, nil
+}
+
+// bulkLoad stores pre-grouped entries, one lock per bucket.
+func (cm *ConcurrentMap[K, V]) bulkLoad(groups [][]Entry[K, V]) {
+	for i, entries := range groups {
+		if len(entries) == 0 {
+			continue
+		}
+		b := &cm.buckets[i]
+
+		b.mu.Lock()
+		if ms, ok := b.m.(mapStorage[K, V]); ok && ms.Len() == 0 {
+			b.m = make(mapStorage[K, V], len(entries))
+		}
+		for _, e := range entries {
+			b.m.Set(e.Key, e.Value)
+		}
+		b.mu.Unlock()
+	}
+}
+
+// GetMany returns the values of those keys that are present; absent keys
+// are left out of the result. Keys are grouped by bucket first, so each
+// bucket is read-locked once however many of the keys it holds. Misses
+// fall through to a configured Loader, as in Get.
+func (cm *ConcurrentMap[K, V]) GetMany(keys []K) map[K]V {
+	type slot struct{ bucket, key int }
+	slots := make([]slot, len(keys))
+	for i, k := range keys {
+		slots[i] = slot{cm.bucketIndexForKey(k), i}
+	}
+	slices.SortFunc(slots, func(a, b slot) int { return cmp.Compare(a.bucket, b.bucket) })
+
+	found := make(map[K]V, len(keys))
+	var misses []K
+	for start := 0; start < len(slots); {
+		end := start + 1
+		for end < len(slots) && slots[end].bucket == slots[start].bucket {
+			end++
+		}
+
+		b := &cm.buckets[slots[start].bucket]
+		if !cm.lockFreeReads {
+			b.mu.RLock()
+		}
+		for _, s := range slots[start:end] {
+			k := keys[s.key]
+			if v, ok := b.m.Get(k); ok {
+				found[k] = v
+			} else if cm.loader != nil {
+				misses = append(misses, k)
+			}
+		}
+		if !cm.lockFreeReads {
+			b.mu.RUnlock()
+		}
+		start = end
+	}
+
+	for _, k := range misses {
+		if v, err := cm.load(k); err == nil {
+			found[k] = v
+		}
+	}
+	return found
+}
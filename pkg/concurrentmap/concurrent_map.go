@@ -6,21 +6,33 @@ import "sync"
 type Hasher[K comparable] func(K) uint64
 
 // bucket represents one shard of the map.
-// It contains a standard Go map protected by an RWMutex.
+// It contains a Storage (a standard Go map by default) protected by an RWMutex.
 type bucket[K comparable, V any] struct {
 	mu sync.RWMutex
-	m  map[K]V
+	m  Storage[K, V]
 }
 
 // ConcurrentMap is a sharded, thread-safe map.
 // Keys are distributed across buckets using the hasher function.
 type ConcurrentMap[K comparable, V any] struct {
-	buckets []bucket[K, V]
-	hasher  Hasher[K]
+	buckets  []bucket[K, V]
+	hasher   Hasher[K]
+	watchers watchHub[K, V]
+
+	loader  Loader[K, V]
+	writer  Writer[K, V]
+	deleter Deleter[K]
+	loads   loadGroup[K, V]
+
+	newStorage    func() Storage[K, V]
+	lockFreeReads bool // storage Get is safe without the bucket lock
+
+	debug *debugConfig // non-nil in paranoid mode
 }
 
 // New creates a ConcurrentMap with numBuckets shards and a custom hasher.
-func New[K comparable, V any](numBuckets int, hasher Hasher[K]) *ConcurrentMap[K, V] {
+// Optional behaviour (backing-store hooks, ...) is enabled through opts.
+func New[K comparable, V any](numBuckets int, hasher Hasher[K], opts ...Option[K, V]) *ConcurrentMap[K, V] {
 	if numBuckets <= 0 {
 		panic("numBuckets must be > 0")
 	}
@@ -28,59 +40,144 @@ func New[K comparable, V any](numBuckets int, hasher Hasher[K]) *ConcurrentMap[K
 		panic("hasher must not be nil")
 	}
 
-	buckets := make([]bucket[K, V], numBuckets)
-	for i := range buckets {
-		buckets[i].m = make(map[K]V)
+	cm := &ConcurrentMap[K, V]{
+		buckets:    make([]bucket[K, V], numBuckets),
+		hasher:     hasher,
+		newStorage: newMapStorage[K, V],
+	}
+	for _, opt := range opts {
+		opt(cm)
 	}
 
-	return &ConcurrentMap[K, V]{
-		buckets: buckets,
-		hasher:  hasher,
+	for i := range cm.buckets {
+		cm.buckets[i].m = cm.newStorage()
 	}
+	return cm
 }
 
 // NewStringMap returns a ConcurrentMap specialized for string keys.
 // Uses a built-in FNV-1a hasher.
-func NewStringMap[V any](numBuckets int) *ConcurrentMap[string, V] {
-	return New[string, V](numBuckets, fnv64a)
+func NewStringMap[V any](numBuckets int, opts ...Option[string, V]) *ConcurrentMap[string, V] {
+	return New[string, V](numBuckets, fnv64a, opts...)
 }
 
 // ----------- Core Map Operations -----------
 
 func (cm *ConcurrentMap[K, V]) bucketIndexForKey(k K) int {
 	h := cm.hasher(k)
-	return int(h % uint64(len(cm.buckets)))
+	idx := int(h % uint64(len(cm.buckets)))
+	if cm.debug != nil {
+		cm.checkKey(k, idx)
+	}
+	return idx
 }
 
+// Set stores v at k. Like Get and Delete it does not allocate for
+// string keys and avoids defer on the hot path.
 func (cm *ConcurrentMap[K, V]) Set(k K, v V) {
+	if cm.writer != nil {
+		_ = cm.TrySet(k, v)
+		return
+	}
+
+	idx := cm.bucketIndexForKey(k)
+	b := &cm.buckets[idx]
+
+	b.mu.Lock()
+	old, existed := b.m.Get(k)
+	b.m.Set(k, v)
+
+	if existed {
+		cm.emit(EventUpdate, k, old, v)
+	} else {
+		cm.emit(EventInsert, k, old, v)
+	}
+	b.mu.Unlock()
+}
+
+// TrySet is Set that reports write-through failures. If a Writer is
+// configured and returns an error, the map is left unchanged.
+func (cm *ConcurrentMap[K, V]) TrySet(k K, v V) error {
 	idx := cm.bucketIndexForKey(k)
 	b := &cm.buckets[idx]
 
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	b.m[k] = v
+	if err := cm.writeThrough(k, v); err != nil {
+		return err
+	}
+
+	old, existed := b.m.Get(k)
+	b.m.Set(k, v)
+
+	if existed {
+		cm.emit(EventUpdate, k, old, v)
+	} else {
+		cm.emit(EventInsert, k, old, v)
+	}
+	return nil
 }
 
+// Get returns the value for k. On a miss, a configured Loader is consulted
+// and a successful load is cached; loader errors are reported as a miss.
 func (cm *ConcurrentMap[K, V]) Get(k K) (V, bool) {
 	idx := cm.bucketIndexForKey(k)
 	b := &cm.buckets[idx]
 
-	b.mu.RLock()
-	defer b.mu.RUnlock()
+	var (
+		v  V
+		ok bool
+	)
+	if cm.lockFreeReads {
+		v, ok = b.m.Get(k)
+	} else {
+		b.mu.RLock()
+		v, ok = b.m.Get(k)
+		b.mu.RUnlock()
+	}
+
+	if ok || cm.loader == nil {
+		return v, ok
+	}
 
-	v, ok := b.m[k]
-	return v, ok
+	v, err := cm.load(k)
+	return v, err == nil
 }
 
+// Delete removes k. If a Deleter is configured and fails, k is kept; use
+// DeleteErr to see the error.
 func (cm *ConcurrentMap[K, V]) Delete(k K) {
 	idx := cm.bucketIndexForKey(k)
 	b := &cm.buckets[idx]
 
 	b.mu.Lock()
-	defer b.mu.Unlock()
+	old, existed := b.m.Get(k)
+	if existed && cm.deleteThrough(k) == nil {
+		b.m.Delete(k)
+		cm.emit(EventDelete, k, old, old)
+	}
+	b.mu.Unlock()
+}
+
+// view runs fn with the value for k while holding the bucket's read lock.
+// It lets wrapper types inspect mutable values (maps, slices) without
+// copying them out of the lock first.
+func (cm *ConcurrentMap[K, V]) view(k K, fn func(v V, exists bool)) {
+	idx := cm.bucketIndexForKey(k)
+	b := &cm.buckets[idx]
 
-	delete(b.m, k)
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	v, ok := b.m.Get(k)
+	fn(v, ok)
+}
+
+// Buckets returns the number of shards, the valid range of bucket
+// indexes for SampleBucket.
+func (cm *ConcurrentMap[K, V]) Buckets() int {
+	return len(cm.buckets)
 }
 
 func (cm *ConcurrentMap[K, V]) Len() int {
@@ -89,12 +186,27 @@ func (cm *ConcurrentMap[K, V]) Len() int {
 		b := &cm.buckets[i]
 
 		b.mu.RLock()
-		total += len(b.m)
+		total += b.m.Len()
 		b.mu.RUnlock()
 	}
 	return total
 }
 
+// BucketLens returns the number of entries in each shard, indexed like
+// SampleBucket's buckets, to show how evenly keys are spread. Each shard
+// is counted under its own lock, so the result is not one snapshot.
+func (cm *ConcurrentMap[K, V]) BucketLens() []int {
+	lens := make([]int, len(cm.buckets))
+	for i := range cm.buckets {
+		b := &cm.buckets[i]
+
+		b.mu.RLock()
+		lens[i] = b.m.Len()
+		b.mu.RUnlock()
+	}
+	return lens
+}
+
 // ----------- FNV-1a String Hasher -----------
 
 func fnv64a(s string) uint64 {
@@ -1,9 +1,16 @@
 package concurrentmap
 
 import (
+	"context"
+	"errors"
+	"maps"
+	"math"
+	"math/rand/v2"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestBasicOperations(t *testing.T) {
@@ -45,6 +52,28 @@ func TestLen(t *testing.T) {
 	}
 }
 
+func TestBucketLens(t *testing.T) {
+	m := NewStringMap[int](8)
+	for i := 0; i < 100; i++ {
+		m.Set("k"+strconv.Itoa(i), i)
+	}
+
+	lens := m.BucketLens()
+	if len(lens) != m.Buckets() {
+		t.Fatalf("got %d bucket lengths, want %d", len(lens), m.Buckets())
+	}
+	total := 0
+	for i, n := range lens {
+		if got := len(m.SampleBucket(i, 1000, nil)); got != n {
+			t.Fatalf("bucket %d: length %d, but holds %d entries", i, n, got)
+		}
+		total += n
+	}
+	if total != 100 {
+		t.Fatalf("bucket lengths add up to %d, want 100", total)
+	}
+}
+
 func TestConcurrentAccess(t *testing.T) {
 	m := NewStringMap[int](16) // AGAIN include [int]
 
@@ -68,3 +97,656 @@ func TestConcurrentAccess(t *testing.T) {
 		t.Fatalf("len should never be negative")
 	}
 }
+
+func TestVersionedMap(t *testing.T) {
+	vm := NewStringVersionedMap[string](16)
+
+	if v := vm.Set("cfg", "a"); v != 1 {
+		t.Fatalf("expected version 1, got %d", v)
+	}
+	if _, ok := vm.SetIfVersion("cfg", "b", 0); ok {
+		t.Fatalf("create-only write should fail on existing key")
+	}
+	if v, ok := vm.SetIfVersion("cfg", "b", 1); !ok || v != 2 {
+		t.Fatalf("expected CAS to version 2, got %d, ok=%v", v, ok)
+	}
+	if cur, ok := vm.SetIfVersion("cfg", "c", 1); ok || cur != 2 {
+		t.Fatalf("stale CAS should fail and report version 2, got %d, ok=%v", cur, ok)
+	}
+
+	if val, ver, ok := vm.Get("cfg"); !ok || val != "b" || ver != 2 {
+		t.Fatalf("expected b@2, got %v@%d, ok=%v", val, ver, ok)
+	}
+
+	if vm.DeleteIfVersion("cfg", 1) {
+		t.Fatalf("delete with stale version should fail")
+	}
+	if !vm.DeleteIfVersion("cfg", 2) || vm.Len() != 0 {
+		t.Fatalf("expected delete with current version to succeed")
+	}
+}
+
+// sliceStorage is a deliberately naive Storage used to check that the
+// map only talks to buckets through the interface.
+type sliceStorage struct {
+	keys []string
+	vals []int
+}
+
+func (s *sliceStorage) find(k string) int {
+	for i, key := range s.keys {
+		if key == k {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *sliceStorage) Get(k string) (int, bool) {
+	if i := s.find(k); i >= 0 {
+		return s.vals[i], true
+	}
+	return 0, false
+}
+
+func (s *sliceStorage) Set(k string, v int) {
+	if i := s.find(k); i >= 0 {
+		s.vals[i] = v
+		return
+	}
+	s.keys = append(s.keys, k)
+	s.vals = append(s.vals, v)
+}
+
+func (s *sliceStorage) Delete(k string) {
+	if i := s.find(k); i >= 0 {
+		s.keys = append(s.keys[:i], s.keys[i+1:]...)
+		s.vals = append(s.vals[:i], s.vals[i+1:]...)
+	}
+}
+
+func (s *sliceStorage) Len() int { return len(s.keys) }
+
+func (s *sliceStorage) Range(f func(string, int) bool) {
+	for i := range s.keys {
+		if !f(s.keys[i], s.vals[i]) {
+			return
+		}
+	}
+}
+
+func TestCustomStorage(t *testing.T) {
+	m := NewStringMap[int](4, WithStorage(func() Storage[string, int] {
+		return &sliceStorage{}
+	}))
+
+	for i := 0; i < 20; i++ {
+		m.Set("k"+strconv.Itoa(i), i)
+	}
+	m.Compute("k3", func(old int, _ bool) (int, bool) { return old * 10, true })
+	m.Delete("k4")
+
+	if v, ok := m.Get("k3"); !ok || v != 30 {
+		t.Fatalf("expected k3=30, got %v, ok=%v", v, ok)
+	}
+	if m.Len() != 19 {
+		t.Fatalf("expected Len=19, got %d", m.Len())
+	}
+
+	seen := 0
+	m.Range(func(string, int) bool {
+		seen++
+		return seen < 5
+	})
+	if seen != 5 {
+		t.Fatalf("expected Range to stop after 5 entries, got %d", seen)
+	}
+}
+
+func TestCopyOnWriteConcurrentAccess(t *testing.T) {
+	m := NewStringMap[int](4, WithCopyOnWrite[string, int]())
+
+	var wg sync.WaitGroup
+	wg.Add(20)
+	for g := 0; g < 20; g++ {
+		go func(id int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				key := "key-" + strconv.Itoa(i%10)
+				if id%4 == 0 {
+					m.Set(key, i)
+				} else {
+					m.Get(key)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if m.Len() != 10 {
+		t.Fatalf("expected Len=10, got %d", m.Len())
+	}
+	m.Delete("key-0")
+	if _, ok := m.Get("key-0"); ok {
+		t.Fatalf("expected key-0 to be deleted")
+	}
+}
+
+func TestSyncMapAdapter(t *testing.T) {
+	var m SyncMap // zero value must be usable, like sync.Map
+
+	m.Store("a", 1)
+	m.Store(42, "answer")
+
+	if v, ok := m.Load("a"); !ok || v != 1 {
+		t.Fatalf("expected a=1, got %v, ok=%v", v, ok)
+	}
+	if v, loaded := m.LoadOrStore(42, "other"); !loaded || v != "answer" {
+		t.Fatalf("expected existing 42=answer, got %v, loaded=%v", v, loaded)
+	}
+	if !m.CompareAndSwap("a", 1, 2) || m.CompareAndSwap("a", 1, 3) {
+		t.Fatalf("unexpected CompareAndSwap results")
+	}
+	if prev, loaded := m.Swap("a", 4); !loaded || prev != 2 {
+		t.Fatalf("expected Swap to return 2, got %v", prev)
+	}
+	if v, loaded := m.LoadAndDelete(42); !loaded || v != "answer" {
+		t.Fatalf("expected LoadAndDelete to return answer, got %v", v)
+	}
+
+	// Range callbacks may mutate the map, as with sync.Map.
+	m.Range(func(k, _ any) bool {
+		m.Delete(k)
+		return true
+	})
+	if _, ok := m.Load("a"); ok {
+		t.Fatalf("expected map to be empty after Range-delete")
+	}
+}
+
+func TestSyncMapKeysFollowEquality(t *testing.T) {
+	var m SyncMap
+
+	// +0 and -0 are equal, so they are one key.
+	negZero := math.Copysign(0, -1)
+	m.Store(0.0, "zero")
+	if v, ok := m.Load(negZero); !ok || v != "zero" {
+		t.Fatalf("expected -0 to find +0's value, got %v, ok=%v", v, ok)
+	}
+
+	// Pointers are keys by address: equal pointees are distinct keys, and
+	// a key is still found after its pointee changes.
+	type point struct{ X, Y int }
+	p, q := &point{1, 2}, &point{1, 2}
+	m.Store(p, "p")
+	m.Store(q, "q")
+	p.X = 5
+	if v, ok := m.Load(p); !ok || v != "p" {
+		t.Fatalf("expected p after changing its pointee, got %v, ok=%v", v, ok)
+	}
+	if v, ok := m.Load(q); !ok || v != "q" {
+		t.Fatalf("expected q, got %v, ok=%v", v, ok)
+	}
+
+	// Values of different types that print alike are distinct keys.
+	type name string
+	m.Store(name("a"), 1)
+	m.Store(point{1, 2}, 2)
+	if v, ok := m.Load(point{1, 2}); !ok || v != 2 {
+		t.Fatalf("expected struct key, got %v, ok=%v", v, ok)
+	}
+	if _, ok := m.Load("a"); ok {
+		t.Fatalf("expected string a not to find name(a)")
+	}
+}
+
+func TestCounterMapOnMapInterface(t *testing.T) {
+	var backing Map[string, int64] = NewStringMap[int64](4)
+	c := NewCounterMapWith(backing)
+
+	c.Inc("hits", 2)
+	c.Inc("hits", 3)
+
+	if v, ok := backing.Get("hits"); !ok || v != 5 {
+		t.Fatalf("expected hits=5 in backing map, got %v, ok=%v", v, ok)
+	}
+}
+
+func TestSwissTableMatchesBuiltinMap(t *testing.T) {
+	m := New[int, int](4, func(k int) uint64 { return uint64(k) }, WithSwissTable[int, int]())
+	ref := make(map[int]int)
+
+	rnd := rand.New(rand.NewPCG(1, 2))
+	for i := 0; i < 50000; i++ {
+		k := rnd.IntN(5000)
+		switch rnd.IntN(3) {
+		case 0:
+			m.Delete(k)
+			delete(ref, k)
+		default:
+			m.Set(k, i)
+			ref[k] = i
+		}
+	}
+
+	if m.Len() != len(ref) {
+		t.Fatalf("expected Len=%d, got %d", len(ref), m.Len())
+	}
+	for k, want := range ref {
+		if got, ok := m.Get(k); !ok || got != want {
+			t.Fatalf("key %d: expected %d, got %d, ok=%v", k, want, got, ok)
+		}
+	}
+	seen := 0
+	m.Range(func(k, v int) bool {
+		if ref[k] != v {
+			t.Fatalf("Range yielded %d=%d, expected %d", k, v, ref[k])
+		}
+		seen++
+		return true
+	})
+	if seen != len(ref) {
+		t.Fatalf("Range visited %d entries, expected %d", seen, len(ref))
+	}
+}
+
+func TestParanoidChecksCatchBadHasher(t *testing.T) {
+	var calls int
+	flaky := func(k string) uint64 {
+		calls++
+		return uint64(calls) // different bucket every time
+	}
+
+	var violations []*InvariantError
+	m := New[string, int](8, flaky, WithParanoidChecks[string, int](func(err *InvariantError) {
+		violations = append(violations, err)
+	}))
+
+	m.Set("a", 1)
+	if len(violations) == 0 {
+		t.Fatalf("expected non-deterministic hasher to be reported")
+	}
+	if !strings.Contains(violations[0].Dump, "8 buckets") {
+		t.Fatalf("expected shard dump in violation, got %q", violations[0].Dump)
+	}
+	if err := m.CheckInvariants(); err == nil {
+		t.Fatalf("expected CheckInvariants to find the misplaced key")
+	}
+}
+
+func TestRangeOrdered(t *testing.T) {
+	m := NewStringMap[int](16)
+	for _, k := range []string{"d", "a", "c", "b", "e"} {
+		m.Set(k, 0)
+	}
+
+	var got []string
+	RangeOrdered(m, func(k string, _ int) bool {
+		got = append(got, k)
+		return len(got) < 4
+	})
+
+	if strings.Join(got, "") != "abcd" {
+		t.Fatalf("expected keys a..d in order, got %v", got)
+	}
+}
+
+func TestScanPagesThroughEveryKeyOnce(t *testing.T) {
+	m := NewStringMap[int](4)
+	for i := 0; i < 100; i++ {
+		m.Set("k"+strconv.Itoa(i), i)
+	}
+
+	seen := make(map[string]bool)
+	var cur Cursor[string]
+	for pages := 0; ; pages++ {
+		if pages > 100 {
+			t.Fatalf("scan did not finish")
+		}
+		var page []Entry[string, int]
+		page, cur = Scan(m, cur, 7, func(_ string, v int) bool { return v >= 0 })
+		if len(page) > 7 {
+			t.Fatalf("page of %d entries, want at most 7", len(page))
+		}
+		for _, e := range page {
+			if seen[e.Key] {
+				t.Fatalf("key %q returned twice", e.Key)
+			}
+			seen[e.Key] = true
+			// Writes between pages must not disturb the scan.
+			m.Delete(e.Key)
+			m.Set("new-"+e.Key, -1)
+		}
+		if cur == (Cursor[string]{}) {
+			break
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if !seen["k"+strconv.Itoa(i)] {
+			t.Fatalf("key k%d was never returned", i)
+		}
+	}
+
+	all, cur := Scan(m, Cursor[string]{}, 1000, nil)
+	if cur != (Cursor[string]{}) || len(all) != 100 {
+		t.Fatalf("scan with room to spare: %d entries, cursor %+v; want 100 and done", len(all), cur)
+	}
+}
+
+func TestFromMapAndFromJSON(t *testing.T) {
+	src := map[string]int{"a": 1, "b": 2, "c": 3}
+	m := FromMap(src, 4, FNV64a)
+	if m.Len() != 3 {
+		t.Fatalf("expected Len=3, got %d", m.Len())
+	}
+	if v, ok := m.Get("b"); !ok || v != 2 {
+		t.Fatalf("expected b=2, got %v, ok=%v", v, ok)
+	}
+	if err := m.CheckInvariants(); err != nil {
+		t.Fatalf("bulk-loaded keys landed in wrong buckets: %v", err)
+	}
+
+	j, err := FromJSON[int](strings.NewReader(`{"x": 10, "y": 20}`), 4)
+	if err != nil {
+		t.Fatalf("FromJSON failed: %v", err)
+	}
+	if v, ok := j.Get("y"); !ok || v != 20 {
+		t.Fatalf("expected y=20, got %v, ok=%v", v, ok)
+	}
+
+	if _, err := FromJSON[int](strings.NewReader(`[1, 2]`), 4); err == nil {
+		t.Fatalf("expected error for non-object JSON")
+	}
+	if _, err := FromJSON[int](strings.NewReader(`{"x": "nope"}`), 4); err == nil {
+		t.Fatalf("expected error for mistyped value")
+	}
+}
+
+func TestGetMany(t *testing.T) {
+	m := NewStringMap[int](4)
+	for i := 0; i < 20; i++ {
+		m.Set("k"+strconv.Itoa(i), i)
+	}
+
+	got := m.GetMany([]string{"k3", "k17", "missing", "k3", "k0"})
+	want := map[string]int{"k3": 3, "k17": 17, "k0": 0}
+	if !maps.Equal(got, want) {
+		t.Fatalf("GetMany = %v, want %v", got, want)
+	}
+	if got := m.GetMany(nil); len(got) != 0 {
+		t.Fatalf("GetMany(nil) = %v, want empty", got)
+	}
+
+	loaded := NewStringMap[int](4, WithLoader(func(k string) (int, error) {
+		if k == "db" {
+			return 42, nil
+		}
+		return 0, errors.New("not found")
+	}))
+	loaded.Set("mem", 1)
+	got = loaded.GetMany([]string{"mem", "db", "nowhere"})
+	if want := map[string]int{"mem": 1, "db": 42}; !maps.Equal(got, want) {
+		t.Fatalf("GetMany with loader = %v, want %v", got, want)
+	}
+}
+
+func TestComputeMany(t *testing.T) {
+	m := NewStringMap[int](8)
+	m.Set("from", 10)
+	m.Set("to", 5)
+
+	var events []EventType
+	m.Subscribe(func(ev Event[string, int]) { events = append(events, ev.Type) })
+
+	err := m.ComputeMany([]string{"from", "to", "log", "from"}, func(cur map[string]int) (map[string]int, []string, error) {
+		if _, ok := cur["log"]; ok || len(cur) != 2 {
+			t.Fatalf("current = %v, want from and to only", cur)
+		}
+		return map[string]int{"to": cur["to"] + cur["from"], "log": 1}, []string{"from"}, nil
+	})
+	if err != nil {
+		t.Fatalf("ComputeMany: %v", err)
+	}
+	got := m.GetMany([]string{"from", "to", "log"})
+	if want := map[string]int{"to": 15, "log": 1}; !maps.Equal(got, want) {
+		t.Fatalf("after ComputeMany = %v, want %v", got, want)
+	}
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3", len(events))
+	}
+
+	boom := errors.New("boom")
+	if err := m.ComputeMany([]string{"to"}, func(map[string]int) (map[string]int, []string, error) {
+		return map[string]int{"to": 0}, nil, boom
+	}); !errors.Is(err, boom) {
+		t.Fatalf("err = %v, want boom", err)
+	}
+	if err := m.ComputeMany([]string{"to"}, func(map[string]int) (map[string]int, []string, error) {
+		return map[string]int{"to": 0, "other": 1}, nil, nil
+	}); !errors.Is(err, ErrNotLocked) {
+		t.Fatalf("err = %v, want ErrNotLocked", err)
+	}
+	if v, _ := m.Get("to"); v != 15 {
+		t.Fatalf("failed ComputeMany changed to: %d", v)
+	}
+}
+
+func TestComputeManyConcurrentTransfers(t *testing.T) {
+	m := NewStringMap[int](4)
+	accounts := []string{"a", "b", "c", "d", "e"}
+	for _, k := range accounts {
+		m.Set(k, 100)
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				from, to := accounts[rand.IntN(len(accounts))], accounts[rand.IntN(len(accounts))]
+				if from == to {
+					continue
+				}
+				_ = m.ComputeMany([]string{from, to}, func(cur map[string]int) (map[string]int, []string, error) {
+					return map[string]int{from: cur[from] - 1, to: cur[to] + 1}, nil, nil
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	total := 0
+	for _, k := range accounts {
+		v, _ := m.Get(k)
+		total += v
+	}
+	if total != 500 {
+		t.Fatalf("total = %d after transfers, want 500", total)
+	}
+}
+
+func TestSampleAndPopRandom(t *testing.T) {
+	m := NewStringMap[int](8)
+	for i := 0; i < 100; i++ {
+		m.Set("k"+strconv.Itoa(i), i)
+	}
+
+	sample := m.Sample(10)
+	if len(sample) != 10 {
+		t.Fatalf("expected 10 samples, got %d", len(sample))
+	}
+	seen := make(map[string]bool)
+	for _, e := range sample {
+		if seen[e.Key] {
+			t.Fatalf("duplicate key %s in sample", e.Key)
+		}
+		seen[e.Key] = true
+		if v, _ := m.Get(e.Key); v != e.Value {
+			t.Fatalf("sampled %s=%d, map has %d", e.Key, e.Value, v)
+		}
+	}
+	if len(m.Sample(1000)) != 100 {
+		t.Fatalf("expected oversized sample to return every entry")
+	}
+
+	for i := 0; i < 100; i++ {
+		if _, _, ok := m.PopRandom(); !ok {
+			t.Fatalf("PopRandom failed with %d entries left", m.Len())
+		}
+	}
+	if _, _, ok := m.PopRandom(); ok || m.Len() != 0 {
+		t.Fatalf("expected empty map after popping everything")
+	}
+}
+
+func TestSampleBucket(t *testing.T) {
+	m := NewStringMap[int](4)
+	for i := 0; i < 400; i++ {
+		m.Set("k"+strconv.Itoa(i), i)
+	}
+	if m.Buckets() != 4 {
+		t.Fatalf("Buckets = %d, want 4", m.Buckets())
+	}
+
+	total := 0
+	for i := 0; i < m.Buckets(); i++ {
+		even := m.SampleBucket(i, 5, func(_ string, v int) bool { return v%2 == 0 })
+		if len(even) != 5 {
+			t.Fatalf("bucket %d: got %d samples, want 5", i, len(even))
+		}
+		for _, e := range even {
+			if e.Value%2 != 0 {
+				t.Fatalf("bucket %d: sampled %s=%d, which does not match", i, e.Key, e.Value)
+			}
+		}
+		total += len(m.SampleBucket(i, 1000, nil))
+	}
+	if total != 400 {
+		t.Fatalf("oversized samples cover %d entries, want 400", total)
+	}
+
+	// A match that is rare gives up after a bounded number of visits
+	// rather than walking the whole bucket.
+	big := NewStringMap[int](1)
+	for i := 0; i < 1000; i++ {
+		big.Set("k"+strconv.Itoa(i), i)
+	}
+	visited := 0
+	big.SampleBucket(0, 2, func(string, int) bool { visited++; return false })
+	if visited != 2*sampleVisitFactor {
+		t.Fatalf("visited %d entries, want %d", visited, 2*sampleVisitFactor)
+	}
+}
+
+func TestErrorReturningVariants(t *testing.T) {
+	m := NewStringMap[int](16)
+
+	if _, err := m.GetErr("a"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := m.Insert("a", 1); err != nil {
+		t.Fatalf("unexpected insert error: %v", err)
+	}
+	if err := m.Insert("a", 2); !errors.Is(err, ErrExists) {
+		t.Fatalf("expected ErrExists, got %v", err)
+	}
+
+	errNegative := errors.New("negative")
+	err := m.ComputeErr("a", func(old int, _ bool) (int, bool, error) {
+		return old - 5, true, errNegative
+	})
+	if !errors.Is(err, errNegative) {
+		t.Fatalf("expected fn error to propagate, got %v", err)
+	}
+	if v, _ := m.GetErr("a"); v != 1 {
+		t.Fatalf("failed ComputeErr must not commit, got a=%d", v)
+	}
+
+	if err := m.DeleteErr("a"); err != nil {
+		t.Fatalf("unexpected delete error: %v", err)
+	}
+	var ke *KeyError
+	if err := m.DeleteErr("a"); !errors.As(err, &ke) || ke.Key != "a" {
+		t.Fatalf("expected KeyError for a, got %v", err)
+	}
+
+	vm := NewStringVersionedMap[int](4)
+	vm.Set("v", 1)
+	if _, err := vm.SetIfVersionErr("v", 2, 7); !errors.Is(err, ErrVersionMismatch) {
+		t.Fatalf("expected ErrVersionMismatch, got %v", err)
+	}
+}
+
+func TestContextOpsHonorDeadline(t *testing.T) {
+	m := NewStringMap[int](1)
+	ctx := context.Background()
+
+	if err := m.SetCtx(ctx, "a", 1); err != nil {
+		t.Fatalf("unexpected SetCtx error: %v", err)
+	}
+	if err := m.ComputeCtx(ctx, "a", func(old int, _ bool) (int, bool) { return old + 1, true }); err != nil {
+		t.Fatalf("unexpected ComputeCtx error: %v", err)
+	}
+	if v, err := m.GetCtx(ctx, "a"); err != nil || v != 2 {
+		t.Fatalf("expected a=2, got %d, %v", v, err)
+	}
+
+	// Hold the only shard's lock so every operation has to wait.
+	m.buckets[0].mu.Lock()
+	short, cancel := context.WithTimeout(ctx, 5*time.Millisecond)
+	defer cancel()
+
+	if _, err := m.GetCtx(short, "a"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected GetCtx deadline error, got %v", err)
+	}
+	if err := m.SetCtx(short, "a", 3); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected SetCtx deadline error, got %v", err)
+	}
+	m.buckets[0].mu.Unlock()
+
+	if v, _ := m.Get("a"); v != 2 {
+		t.Fatalf("timed-out SetCtx must not write, got a=%d", v)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	m := NewStringMap[int](16)
+	for i := 0; i < 1000; i++ {
+		m.Set("k"+strconv.Itoa(i), i)
+	}
+
+	// Writers keep going while snapshots are taken; keys k0..k999 are
+	// never touched, so every snapshot must contain them unchanged.
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			m.Set("w"+strconv.Itoa(i%50), i)
+		}
+	}()
+
+	for i := 0; i < 20; i++ {
+		seen := 0
+		for _, e := range m.Snapshot() {
+			if strings.HasPrefix(e.Key, "k") {
+				if e.Key != "k"+strconv.Itoa(e.Value) {
+					t.Fatalf("snapshot has %s=%d", e.Key, e.Value)
+				}
+				seen++
+			}
+		}
+		if seen != 1000 {
+			t.Fatalf("snapshot %d has %d of 1000 stable keys", i, seen)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
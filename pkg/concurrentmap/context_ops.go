@@ -0,0 +1,135 @@
+package concurrentmap
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	lockSpins      = 4
+	lockMinBackoff = time.Microsecond
+	lockMaxBackoff = time.Millisecond
+)
+
+// lockCtx acquires mu (for reading if read is set) unless ctx is done first.
+// sync.RWMutex cannot be abandoned once Lock is called, so the wait is a
+// TryLock loop: a few yields for short critical sections, then sleeps that
+// double up to lockMaxBackoff.
+func lockCtx(ctx context.Context, mu *sync.RWMutex, read bool) error {
+	try := mu.TryLock
+	if read {
+		try = mu.TryRLock
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if try() {
+		return nil
+	}
+
+	for i := 0; i < lockSpins; i++ {
+		runtime.Gosched()
+		if try() {
+			return nil
+		}
+	}
+
+	backoff := lockMinBackoff
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+		if try() {
+			return nil
+		}
+		backoff = min(backoff*2, lockMaxBackoff)
+		timer.Reset(backoff)
+	}
+}
+
+// ----------- Context-aware Operations -----------
+
+// GetCtx is GetErr bounded by ctx: if the key's shard stays locked until
+// ctx is done, it returns ctx.Err(). Loads from a configured Loader are
+// not interrupted.
+func (cm *ConcurrentMap[K, V]) GetCtx(ctx context.Context, k K) (V, error) {
+	idx := cm.bucketIndexForKey(k)
+	b := &cm.buckets[idx]
+
+	var (
+		v  V
+		ok bool
+	)
+	if cm.lockFreeReads {
+		v, ok = b.m.Get(k)
+	} else {
+		if err := lockCtx(ctx, &b.mu, true); err != nil {
+			return v, err
+		}
+		v, ok = b.m.Get(k)
+		b.mu.RUnlock()
+	}
+
+	if ok {
+		return v, nil
+	}
+	if cm.loader == nil {
+		return v, keyErr("get", k, ErrNotFound)
+	}
+
+	v, err := cm.load(k)
+	if err != nil {
+		return v, keyErr("load", k, err)
+	}
+	return v, nil
+}
+
+// SetCtx is TrySet bounded by ctx. The map is unchanged if it returns an error.
+func (cm *ConcurrentMap[K, V]) SetCtx(ctx context.Context, k K, v V) error {
+	idx := cm.bucketIndexForKey(k)
+	b := &cm.buckets[idx]
+
+	if err := lockCtx(ctx, &b.mu, false); err != nil {
+		return err
+	}
+	defer b.mu.Unlock()
+
+	if err := cm.writeThrough(k, v); err != nil {
+		return err
+	}
+
+	old, existed := b.m.Get(k)
+	b.m.Set(k, v)
+
+	if existed {
+		cm.emit(EventUpdate, k, old, v)
+	} else {
+		cm.emit(EventInsert, k, old, v)
+	}
+	return nil
+}
+
+// ComputeCtx is Compute bounded by ctx. fn is not called if the shard
+// lock could not be acquired before ctx was done; a Writer error is
+// returned and leaves the map unchanged.
+func (cm *ConcurrentMap[K, V]) ComputeCtx(ctx context.Context, k K, fn func(old V, exists bool) (newV V, keep bool)) error {
+	idx := cm.bucketIndexForKey(k)
+	b := &cm.buckets[idx]
+
+	if err := lockCtx(ctx, &b.mu, false); err != nil {
+		return err
+	}
+	defer b.mu.Unlock()
+
+	return cm.computeLocked(b, k, func(old V, exists bool) (V, bool, error) {
+		newV, keep := fn(old, exists)
+		return newV, keep, nil
+	})
+}
@@ -3,14 +3,24 @@ package concurrentmap
 // CounterMap is a specialized atomic integer counter map.
 // Useful for metrics, request counters, rate limits, etc.
 type CounterMap[K comparable] struct {
-	m *ConcurrentMap[K, int64]
+	m Map[K, int64]
+
+	// cm is m's concrete type when it is a *ConcurrentMap. Inc then skips
+	// the interface and its per-call closure allocation.
+	cm *ConcurrentMap[K, int64]
 }
 
 // NewCounterMap creates a new CounterMap.
 func NewCounterMap[K comparable](numBuckets int, hasher Hasher[K]) *CounterMap[K] {
-	return &CounterMap[K]{
-		m: New[K, int64](numBuckets, hasher),
-	}
+	return NewCounterMapWith[K](New[K, int64](numBuckets, hasher))
+}
+
+// NewCounterMapWith creates a CounterMap on top of an existing Map,
+// e.g. a ConcurrentMap built with custom options.
+func NewCounterMapWith[K comparable](m Map[K, int64]) *CounterMap[K] {
+	c := &CounterMap[K]{m: m}
+	c.cm, _ = m.(*ConcurrentMap[K, int64])
+	return c
 }
 
 // NewStringCounterMap creates a counter map with string keys.
@@ -21,21 +31,47 @@ func NewStringCounterMap(numBuckets int) *CounterMap[string] {
 // Inc atomically increments a key by delta.
 // Returns the new value.
 func (cm *CounterMap[K]) Inc(k K, delta int64) int64 {
-	var result int64
+	if cm.cm != nil {
+		v, _ := computeWith(cm.cm, k, delta, addDelta)
+		return v
+	}
 
+	var result int64
 	cm.m.Compute(k, func(old int64, exists bool) (int64, bool) {
-		if !exists {
-			result = delta
-			return delta, true
-		}
-		result = old + delta
+		result = addOrInit(old, exists, delta)
 		return result, true
 	})
-
 	return result
 }
 
+func addDelta(old int64, exists bool, delta int64) (int64, bool) {
+	return addOrInit(old, exists, delta), true
+}
+
+func addOrInit(old int64, exists bool, delta int64) int64 {
+	if !exists {
+		return delta
+	}
+	return old + delta
+}
+
 // Get returns the counter value.
 func (cm *CounterMap[K]) Get(k K) (int64, bool) {
 	return cm.m.Get(k)
 }
+
+// Delete removes a counter.
+func (cm *CounterMap[K]) Delete(k K) {
+	cm.m.Delete(k)
+}
+
+// Range calls f for every counter. A shard's read lock is held while f
+// runs, so f must not modify the map; collect keys and delete afterwards.
+func (cm *CounterMap[K]) Range(f func(k K, v int64) bool) {
+	cm.m.Range(f)
+}
+
+// Len returns the number of counters.
+func (cm *CounterMap[K]) Len() int {
+	return cm.m.Len()
+}
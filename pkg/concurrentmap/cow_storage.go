@@ -0,0 +1,68 @@
+package concurrentmap
+
+import (
+	"maps"
+	"sync/atomic"
+)
+
+// WithCopyOnWrite switches every bucket to copy-on-write mode: each bucket
+// publishes an immutable map through an atomic pointer, Get reads it
+// without touching the bucket lock, and writers clone the whole bucket
+// map before swapping the pointer.
+//
+// Reads become lock-free and contention-free, but every write costs
+// O(entries per bucket), so this only pays off for read-mostly data such
+// as configuration or feature flags. It replaces any WithStorage option
+// given before it.
+func WithCopyOnWrite[K comparable, V any]() Option[K, V] {
+	return func(cm *ConcurrentMap[K, V]) {
+		cm.newStorage = newCOWStorage[K, V]
+		cm.lockFreeReads = true
+	}
+}
+
+// cowStorage is a Storage whose Get may run concurrently with Set/Delete.
+// Writers are still serialized by the bucket's write lock.
+type cowStorage[K comparable, V any] struct {
+	p atomic.Pointer[map[K]V]
+}
+
+func newCOWStorage[K comparable, V any]() Storage[K, V] {
+	s := &cowStorage[K, V]{}
+	m := make(map[K]V)
+	s.p.Store(&m)
+	return s
+}
+
+func (s *cowStorage[K, V]) Get(k K) (V, bool) {
+	v, ok := (*s.p.Load())[k]
+	return v, ok
+}
+
+func (s *cowStorage[K, V]) Set(k K, v V) {
+	next := maps.Clone(*s.p.Load())
+	next[k] = v
+	s.p.Store(&next)
+}
+
+func (s *cowStorage[K, V]) Delete(k K) {
+	cur := *s.p.Load()
+	if _, ok := cur[k]; !ok {
+		return
+	}
+	next := maps.Clone(cur)
+	delete(next, k)
+	s.p.Store(&next)
+}
+
+func (s *cowStorage[K, V]) Len() int {
+	return len(*s.p.Load())
+}
+
+func (s *cowStorage[K, V]) Range(f func(k K, v V) bool) {
+	for k, v := range *s.p.Load() {
+		if !f(k, v) {
+			return
+		}
+	}
+}
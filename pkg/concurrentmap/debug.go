@@ -0,0 +1,119 @@
+package concurrentmap
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// InvariantError describes a broken internal invariant detected in
+// paranoid mode, together with a dump of every shard at that moment.
+type InvariantError struct {
+	Op     string // operation that detected the problem
+	Key    any
+	Bucket int
+	Detail string
+	Dump   string
+}
+
+func (e *InvariantError) Error() string {
+	return fmt.Sprintf("concurrentmap: invariant violated in %s (key %v, bucket %d): %s",
+		e.Op, e.Key, e.Bucket, e.Detail)
+}
+
+type debugConfig struct {
+	onViolation func(*InvariantError)
+}
+
+// WithParanoidChecks enables debug mode. Every keyed operation re-hashes
+// the key to verify the hasher is deterministic and that its bucket
+// exists, and Range verifies that each key lives in the bucket its hash
+// selects. Violations are passed to onViolation with a shard dump; a nil
+// onViolation panics with the *InvariantError instead.
+//
+// This doubles hashing cost and is meant for tests and for chasing bugs
+// in custom hashers or storages, not for production.
+func WithParanoidChecks[K comparable, V any](onViolation func(*InvariantError)) Option[K, V] {
+	return func(cm *ConcurrentMap[K, V]) {
+		cm.debug = &debugConfig{onViolation: onViolation}
+	}
+}
+
+func (cm *ConcurrentMap[K, V]) indexOf(k K) int {
+	return int(cm.hasher(k) % uint64(len(cm.buckets)))
+}
+
+// checkKey runs before the bucket lock is taken.
+func (cm *ConcurrentMap[K, V]) checkKey(k K, idx int) {
+	if again := cm.indexOf(k); again != idx {
+		cm.violation("hash", k, idx, fmt.Sprintf("hasher is not deterministic: bucket %d then %d", idx, again))
+	}
+	if cm.buckets[idx].m == nil {
+		cm.violation("hash", k, idx, "bucket has no storage")
+	}
+}
+
+func (cm *ConcurrentMap[K, V]) violation(op string, k K, idx int, detail string) {
+	var dump strings.Builder
+	cm.DumpShards(&dump, 10)
+
+	err := &InvariantError{Op: op, Key: k, Bucket: idx, Detail: detail, Dump: dump.String()}
+	if cm.debug.onViolation == nil {
+		panic(err)
+	}
+	cm.debug.onViolation(err)
+}
+
+// CheckInvariants scans every bucket and returns an error listing keys
+// stored in the wrong bucket or buckets without storage. It works whether
+// or not paranoid mode is enabled.
+func (cm *ConcurrentMap[K, V]) CheckInvariants() error {
+	var errs []error
+	for i := range cm.buckets {
+		b := &cm.buckets[i]
+
+		b.mu.RLock()
+		if b.m == nil {
+			errs = append(errs, fmt.Errorf("bucket %d has no storage", i))
+			b.mu.RUnlock()
+			continue
+		}
+		b.m.Range(func(k K, _ V) bool {
+			if want := cm.indexOf(k); want != i {
+				errs = append(errs, fmt.Errorf("key %v is in bucket %d, hashes to %d", k, i, want))
+			}
+			return true
+		})
+		b.mu.RUnlock()
+	}
+	return errors.Join(errs...)
+}
+
+// DumpShards writes a human-readable summary of every bucket to w:
+// its size and up to maxKeys keys with the bucket each one hashes to.
+func (cm *ConcurrentMap[K, V]) DumpShards(w io.Writer, maxKeys int) {
+	fmt.Fprintf(w, "%d buckets\n", len(cm.buckets))
+	for i := range cm.buckets {
+		b := &cm.buckets[i]
+
+		b.mu.RLock()
+		if b.m == nil {
+			fmt.Fprintf(w, "bucket %d: <nil storage>\n", i)
+			b.mu.RUnlock()
+			continue
+		}
+		fmt.Fprintf(w, "bucket %d: %d keys\n", i, b.m.Len())
+		n := 0
+		b.m.Range(func(k K, _ V) bool {
+			if n >= maxKeys {
+				fmt.Fprintf(w, "  ...\n")
+				return false
+			}
+			fmt.Fprintf(w, "  %v -> bucket %d\n", k, cm.indexOf(k))
+			n++
+			return true
+		})
+		b.mu.RUnlock()
+	}
+}
@@ -0,0 +1,155 @@
+package concurrentmap
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Sentinel errors returned (wrapped in a *KeyError) by the error-returning
+// API variants. Compare with errors.Is.
+var (
+	ErrNotFound        = errors.New("concurrentmap: key not found")
+	ErrExists          = errors.New("concurrentmap: key already exists")
+	ErrVersionMismatch = errors.New("concurrentmap: version mismatch")
+	ErrNotLocked       = errors.New("concurrentmap: key not among those locked")
+)
+
+// KeyError records the key an operation failed on.
+type KeyError struct {
+	Op  string
+	Key any
+	Err error
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("%s %v: %v", e.Op, e.Key, e.Err)
+}
+
+func (e *KeyError) Unwrap() error { return e.Err }
+
+func keyErr(op string, k any, err error) error {
+	return &KeyError{Op: op, Key: k, Err: err}
+}
+
+// ----------- Error-returning Variants -----------
+
+// GetErr is Get returning ErrNotFound on a miss. Unlike Get, an error
+// from a configured Loader is returned instead of being folded into a miss.
+func (cm *ConcurrentMap[K, V]) GetErr(k K) (V, error) {
+	idx := cm.bucketIndexForKey(k)
+	b := &cm.buckets[idx]
+
+	b.mu.RLock()
+	v, ok := b.m.Get(k)
+	b.mu.RUnlock()
+
+	if ok {
+		return v, nil
+	}
+	if cm.loader == nil {
+		return v, keyErr("get", k, ErrNotFound)
+	}
+
+	v, err := cm.load(k)
+	if err != nil {
+		return v, keyErr("load", k, err)
+	}
+	return v, nil
+}
+
+// Insert stores v only if k is absent, returning ErrExists otherwise.
+// An error from a configured Writer is returned and nothing is stored.
+func (cm *ConcurrentMap[K, V]) Insert(k K, v V) error {
+	idx := cm.bucketIndexForKey(k)
+	b := &cm.buckets[idx]
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	old, exists := b.m.Get(k)
+	if exists {
+		return keyErr("insert", k, ErrExists)
+	}
+	if err := cm.writeThrough(k, v); err != nil {
+		return err
+	}
+	b.m.Set(k, v)
+	cm.emit(EventInsert, k, old, v)
+	return nil
+}
+
+// DeleteErr removes k, returning ErrNotFound if it was absent.
+func (cm *ConcurrentMap[K, V]) DeleteErr(k K) error {
+	idx := cm.bucketIndexForKey(k)
+	b := &cm.buckets[idx]
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	old, existed := b.m.Get(k)
+	if !existed {
+		return keyErr("delete", k, ErrNotFound)
+	}
+	if err := cm.deleteThrough(k); err != nil {
+		return err
+	}
+	b.m.Delete(k)
+	cm.emit(EventDelete, k, old, old)
+	return nil
+}
+
+// ComputeErr is Compute with a fallible fn. If fn (or a configured Writer
+// or Deleter) returns an error, nothing is committed and the error is
+// returned to the caller unchanged.
+func (cm *ConcurrentMap[K, V]) ComputeErr(k K, fn func(old V, exists bool) (newV V, keep bool, err error)) error {
+	idx := cm.bucketIndexForKey(k)
+	b := &cm.buckets[idx]
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return cm.computeLocked(b, k, fn)
+}
+
+// computeLocked runs fn on k's entry and commits the result unless fn,
+// the Writer or the Deleter fails. The caller holds b's write lock.
+func (cm *ConcurrentMap[K, V]) computeLocked(b *bucket[K, V], k K, fn func(old V, exists bool) (V, bool, error)) error {
+	old, exists := b.m.Get(k)
+	newVal, keep, err := fn(old, exists)
+	if err != nil {
+		return err
+	}
+
+	if !keep {
+		if exists {
+			if err := cm.deleteThrough(k); err != nil {
+				return err
+			}
+			b.m.Delete(k)
+			cm.emit(EventDelete, k, old, old)
+		}
+		return nil
+	}
+
+	if err := cm.writeThrough(k, newVal); err != nil {
+		return err
+	}
+
+	b.m.Set(k, newVal)
+	if exists {
+		cm.emit(EventUpdate, k, old, newVal)
+	} else {
+		cm.emit(EventInsert, k, old, newVal)
+	}
+	return nil
+}
+
+// SetIfVersionErr is SetIfVersion returning ErrVersionMismatch (wrapped
+// with the key) when expected is stale.
+func (vm *VersionedMap[K, V]) SetIfVersionErr(k K, v V, expected uint64) (uint64, error) {
+	version, ok := vm.SetIfVersion(k, v, expected)
+	if !ok {
+		return version, keyErr("set", k, ErrVersionMismatch)
+	}
+	return version, nil
+}
@@ -0,0 +1,19 @@
+package concurrentmap
+
+// Subscribe registers fn to receive every mutation of the map
+// (inserts, updates, deletes and expirations) and returns a function
+// that removes the subscription.
+//
+// Unlike Watch, fn is called synchronously while the mutated key's bucket
+// lock is held. Events for the same key therefore arrive in commit order,
+// which is what replication, audit logs and write-behind need, but fn must
+// be fast, must not panic, and must not call back into the map or it
+// will deadlock.
+func (cm *ConcurrentMap[K, V]) Subscribe(fn func(Event[K, V])) (unsubscribe func()) {
+	s := &subscriber[K, V]{fn: fn}
+	cm.watchers.subscribe(s)
+
+	return func() {
+		cm.watchers.unsubscribe(s)
+	}
+}
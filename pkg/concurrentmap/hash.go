@@ -0,0 +1,98 @@
+package concurrentmap
+
+import "math/bits"
+
+// FNV64a is the FNV-1a string hasher used by the NewString* constructors.
+// It is cheap for the short keys typical of caches and counters.
+func FNV64a(s string) uint64 {
+	return fnv64a(s)
+}
+
+// ----------- xxHash64 String Hasher -----------
+
+const (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+// XXHash64 is an unsafe-free xxHash64 (seed 0) of s. It consumes 8 bytes
+// per step instead of FNV's one, so it wins for long keys (paths, URLs,
+// composite IDs); pass it to New or use it as a Hasher[string].
+// It never allocates.
+func XXHash64(s string) uint64 {
+	n := len(s)
+	i := 0
+	var h uint64
+
+	if n >= 32 {
+		p1, p2 := xxPrime1, xxPrime2 // variables so the seeds wrap like at runtime
+		v1 := p1 + p2
+		v2 := p2
+		v3 := uint64(0)
+		v4 := -p1
+		for ; i+32 <= n; i += 32 {
+			v1 = xxRound(v1, le64(s, i))
+			v2 = xxRound(v2, le64(s, i+8))
+			v3 = xxRound(v3, le64(s, i+16))
+			v4 = xxRound(v4, le64(s, i+24))
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) +
+			bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxMergeRound(h, v1)
+		h = xxMergeRound(h, v2)
+		h = xxMergeRound(h, v3)
+		h = xxMergeRound(h, v4)
+	} else {
+		h = xxPrime5
+	}
+
+	h += uint64(n)
+
+	for ; i+8 <= n; i += 8 {
+		h ^= xxRound(0, le64(s, i))
+		h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+	}
+	if i+4 <= n {
+		h ^= uint64(le32(s, i)) * xxPrime1
+		h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+		i += 4
+	}
+	for ; i < n; i++ {
+		h ^= uint64(s[i]) * xxPrime5
+		h = bits.RotateLeft64(h, 11) * xxPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxPrime2
+	h ^= h >> 29
+	h *= xxPrime3
+	h ^= h >> 32
+	return h
+}
+
+func xxRound(acc, lane uint64) uint64 {
+	acc += lane * xxPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxPrime1
+}
+
+func xxMergeRound(acc, val uint64) uint64 {
+	acc ^= xxRound(0, val)
+	return acc*xxPrime1 + xxPrime4
+}
+
+// le64/le32 read little-endian words straight from the string; the
+// compiler merges the byte loads, so no []byte conversion is needed.
+func le64(s string, i int) uint64 {
+	_ = s[i+7]
+	return uint64(s[i]) | uint64(s[i+1])<<8 | uint64(s[i+2])<<16 | uint64(s[i+3])<<24 |
+		uint64(s[i+4])<<32 | uint64(s[i+5])<<40 | uint64(s[i+6])<<48 | uint64(s[i+7])<<56
+}
+
+func le32(s string, i int) uint32 {
+	_ = s[i+3]
+	return uint32(s[i]) | uint32(s[i+1])<<8 | uint32(s[i+2])<<16 | uint32(s[i+3])<<24
+}
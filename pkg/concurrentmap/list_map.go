@@ -0,0 +1,264 @@
+package concurrentmap
+
+// ListMap maps each key to a double-ended list of values.
+// Push/Pop on a key run under that key's bucket lock, so concurrent
+// producers and consumers of the same list never interleave partially.
+type ListMap[K comparable, V any] struct {
+	m *ConcurrentMap[K, *deque[V]]
+}
+
+// NewListMap creates a new ListMap.
+func NewListMap[K comparable, V any](numBuckets int, hasher Hasher[K]) *ListMap[K, V] {
+	return &ListMap[K, V]{
+		m: New[K, *deque[V]](numBuckets, hasher),
+	}
+}
+
+// NewStringListMap creates a list map with string keys.
+func NewStringListMap[V any](numBuckets int) *ListMap[string, V] {
+	return NewListMap[string, V](numBuckets, fnv64a)
+}
+
+// LPush prepends values to the list at k, creating it if needed.
+// Values are pushed one by one, so the last value ends up at the head.
+// Returns the new length of the list.
+func (lm *ListMap[K, V]) LPush(k K, values ...V) int {
+	return lm.push(k, values, true)
+}
+
+// RPush appends values to the list at k, creating it if needed.
+// Returns the new length of the list.
+func (lm *ListMap[K, V]) RPush(k K, values ...V) int {
+	return lm.push(k, values, false)
+}
+
+func (lm *ListMap[K, V]) push(k K, values []V, front bool) int {
+	n := 0
+
+	lm.m.Compute(k, func(d *deque[V], exists bool) (*deque[V], bool) {
+		if !exists {
+			d = &deque[V]{}
+		}
+		for _, v := range values {
+			if front {
+				d.pushFront(v)
+			} else {
+				d.pushBack(v)
+			}
+		}
+		n = d.len()
+		return d, n > 0
+	})
+
+	return n
+}
+
+// LPop removes and returns the head of the list at k.
+// The key is removed once its list becomes empty.
+func (lm *ListMap[K, V]) LPop(k K) (V, bool) {
+	return lm.pop(k, true)
+}
+
+// RPop removes and returns the tail of the list at k.
+// The key is removed once its list becomes empty.
+func (lm *ListMap[K, V]) RPop(k K) (V, bool) {
+	return lm.pop(k, false)
+}
+
+func (lm *ListMap[K, V]) pop(k K, front bool) (V, bool) {
+	var (
+		out V
+		ok  bool
+	)
+
+	lm.m.Compute(k, func(d *deque[V], exists bool) (*deque[V], bool) {
+		if !exists {
+			return nil, false
+		}
+		if front {
+			out, ok = d.popFront()
+		} else {
+			out, ok = d.popBack()
+		}
+		return d, d.len() > 0
+	})
+
+	return out, ok
+}
+
+// Len returns the length of the list at k (0 if absent).
+func (lm *ListMap[K, V]) Len(k K) int {
+	n := 0
+	lm.m.view(k, func(d *deque[V], exists bool) {
+		if exists {
+			n = d.len()
+		}
+	})
+	return n
+}
+
+// RangeList returns a copy of the elements between start and stop
+// (both inclusive) of the list at k. Negative indexes count from the
+// tail, so RangeList(k, 0, -1) returns the whole list.
+func (lm *ListMap[K, V]) RangeList(k K, start, stop int) []V {
+	var out []V
+
+	lm.m.view(k, func(d *deque[V], exists bool) {
+		if !exists {
+			return
+		}
+
+		n := d.len()
+		if start < 0 {
+			start += n
+		}
+		if stop < 0 {
+			stop += n
+		}
+		if start < 0 {
+			start = 0
+		}
+		if stop >= n {
+			stop = n - 1
+		}
+		if start > stop {
+			return
+		}
+
+		out = make([]V, 0, stop-start+1)
+		for i := start; i <= stop; i++ {
+			out = append(out, d.at(i))
+		}
+	})
+
+	return out
+}
+
+// RemoveFunc removes the elements of the list at k for which match
+// returns true and returns how many it removed. The key is removed once
+// its list becomes empty.
+func (lm *ListMap[K, V]) RemoveFunc(k K, match func(V) bool) int {
+	n := 0
+
+	lm.m.Compute(k, func(d *deque[V], exists bool) (*deque[V], bool) {
+		if !exists {
+			return nil, false
+		}
+		n = d.removeFunc(match)
+		return d, d.len() > 0
+	})
+
+	return n
+}
+
+// Replace makes values the list stored at k, in one step, removing the
+// key if there are none.
+func (lm *ListMap[K, V]) Replace(k K, values ...V) {
+	lm.m.Compute(k, func(*deque[V], bool) (*deque[V], bool) {
+		d := &deque[V]{}
+		for _, v := range values {
+			d.pushBack(v)
+		}
+		return d, d.len() > 0
+	})
+}
+
+// Delete removes the whole list stored at k.
+func (lm *ListMap[K, V]) Delete(k K) {
+	lm.m.Delete(k)
+}
+
+// Keys returns the number of keys that hold a non-empty list.
+func (lm *ListMap[K, V]) Keys() int {
+	return lm.m.Len()
+}
+
+// ----------- Ring-buffer Deque -----------
+
+// deque is a growable ring buffer giving O(1) push/pop at both ends.
+// It is not safe for concurrent use; ListMap guards it with bucket locks.
+type deque[V any] struct {
+	buf  []V
+	head int
+	n    int
+}
+
+func (d *deque[V]) len() int { return d.n }
+
+func (d *deque[V]) at(i int) V {
+	return d.buf[(d.head+i)%len(d.buf)]
+}
+
+func (d *deque[V]) grow() {
+	if d.n < len(d.buf) {
+		return
+	}
+	size := len(d.buf) * 2
+	if size == 0 {
+		size = 4
+	}
+	buf := make([]V, size)
+	for i := 0; i < d.n; i++ {
+		buf[i] = d.at(i)
+	}
+	d.buf = buf
+	d.head = 0
+}
+
+func (d *deque[V]) pushBack(v V) {
+	d.grow()
+	d.buf[(d.head+d.n)%len(d.buf)] = v
+	d.n++
+}
+
+func (d *deque[V]) pushFront(v V) {
+	d.grow()
+	d.head = (d.head - 1 + len(d.buf)) % len(d.buf)
+	d.buf[d.head] = v
+	d.n++
+}
+
+func (d *deque[V]) popFront() (V, bool) {
+	var zero V
+	if d.n == 0 {
+		return zero, false
+	}
+	v := d.buf[d.head]
+	d.buf[d.head] = zero
+	d.head = (d.head + 1) % len(d.buf)
+	d.n--
+	return v, true
+}
+
+func (d *deque[V]) popBack() (V, bool) {
+	var zero V
+	if d.n == 0 {
+		return zero, false
+	}
+	idx := (d.head + d.n - 1) % len(d.buf)
+	v := d.buf[idx]
+	d.buf[idx] = zero
+	d.n--
+	return v, true
+}
+
+// removeFunc drops the elements match selects, keeping the others in
+// order, and returns how many it dropped.
+func (d *deque[V]) removeFunc(match func(V) bool) int {
+	var zero V
+	kept := 0
+	for i := 0; i < d.n; i++ {
+		v := d.at(i)
+		if match(v) {
+			continue
+		}
+		d.buf[(d.head+kept)%len(d.buf)] = v
+		kept++
+	}
+	for i := kept; i < d.n; i++ {
+		d.buf[(d.head+i)%len(d.buf)] = zero
+	}
+	removed := d.n - kept
+	d.n = kept
+	return removed
+}
@@ -0,0 +1,119 @@
+package concurrentmap
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestListMapPushPop(t *testing.T) {
+	lm := NewStringListMap[int](16)
+
+	lm.RPush("q", 1, 2, 3)
+	if n := lm.LPush("q", 0, -1); n != 5 {
+		t.Fatalf("expected length 5, got %d", n)
+	}
+
+	if got := lm.RangeList("q", 0, -1); !reflect.DeepEqual(got, []int{-1, 0, 1, 2, 3}) {
+		t.Fatalf("unexpected list %v", got)
+	}
+	if got := lm.RangeList("q", -2, 10); !reflect.DeepEqual(got, []int{2, 3}) {
+		t.Fatalf("unexpected tail range %v", got)
+	}
+
+	if v, ok := lm.LPop("q"); !ok || v != -1 {
+		t.Fatalf("expected LPop=-1, got %v, ok=%v", v, ok)
+	}
+	if v, ok := lm.RPop("q"); !ok || v != 3 {
+		t.Fatalf("expected RPop=3, got %v, ok=%v", v, ok)
+	}
+	if lm.Len("q") != 3 {
+		t.Fatalf("expected Len=3, got %d", lm.Len("q"))
+	}
+
+	for i := 0; i < 3; i++ {
+		lm.LPop("q")
+	}
+	if _, ok := lm.LPop("q"); ok {
+		t.Fatalf("expected pop on empty list to fail")
+	}
+	if lm.Keys() != 0 {
+		t.Fatalf("expected empty list to be dropped")
+	}
+}
+
+func TestListMapConcurrentQueue(t *testing.T) {
+	lm := NewStringListMap[int](4)
+
+	var wg sync.WaitGroup
+	wg.Add(10)
+	for g := 0; g < 10; g++ {
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				lm.RPush("jobs", i)
+			}
+		}()
+	}
+	wg.Wait()
+
+	popped := 0
+	for {
+		if _, ok := lm.LPop("jobs"); !ok {
+			break
+		}
+		popped++
+	}
+	if popped != 1000 {
+		t.Fatalf("expected 1000 pops, got %d", popped)
+	}
+}
+
+func TestListMapRemoveFunc(t *testing.T) {
+	lm := NewStringListMap[int](16)
+
+	// Wrap the ring buffer so removal has to handle the seam.
+	lm.RPush("q", 3, 4, 5)
+	lm.LPush("q", 2, 1)
+	odd := func(v int) bool { return v%2 == 1 }
+
+	if n := lm.RemoveFunc("q", odd); n != 3 {
+		t.Fatalf("expected 3 removed, got %d", n)
+	}
+	if got := lm.RangeList("q", 0, -1); !reflect.DeepEqual(got, []int{2, 4}) {
+		t.Fatalf("unexpected list %v", got)
+	}
+	lm.RPush("q", 6)
+	if got := lm.RangeList("q", 0, -1); !reflect.DeepEqual(got, []int{2, 4, 6}) {
+		t.Fatalf("unexpected list after push %v", got)
+	}
+
+	if n := lm.RemoveFunc("q", func(int) bool { return true }); n != 3 {
+		t.Fatalf("expected 3 removed, got %d", n)
+	}
+	if lm.Keys() != 0 {
+		t.Fatalf("expected emptied list to be dropped")
+	}
+	if n := lm.RemoveFunc("missing", odd); n != 0 {
+		t.Fatalf("expected 0 removed from a missing list, got %d", n)
+	}
+}
+
+func TestListMapReplace(t *testing.T) {
+	lm := NewStringListMap[int](16)
+
+	lm.RPush("q", 1, 2, 3)
+	lm.Replace("q", 4, 5)
+	if got := lm.RangeList("q", 0, -1); !reflect.DeepEqual(got, []int{4, 5}) {
+		t.Fatalf("unexpected list %v", got)
+	}
+	lm.LPush("q", 3)
+	if got := lm.RangeList("q", 0, -1); !reflect.DeepEqual(got, []int{3, 4, 5}) {
+		t.Fatalf("unexpected list after push %v", got)
+	}
+
+	lm.Replace("q")
+	if lm.Keys() != 0 {
+		t.Fatalf("expected replacing with no values to drop the list")
+	}
+}
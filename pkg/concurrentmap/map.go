@@ -0,0 +1,18 @@
+package concurrentmap
+
+// Map is the common interface for thread-safe key/value maps.
+// ConcurrentMap implements it; depending on Map instead of the concrete
+// type lets applications swap in another implementation (ordered,
+// expiring, a mock in tests) and lets wrappers such as CounterMap run on
+// top of any of them.
+type Map[K comparable, V any] interface {
+	Get(k K) (V, bool)
+	Set(k K, v V)
+	Delete(k K)
+	Len() int
+	Range(f func(key K, value V) bool)
+	Compute(k K, fn func(old V, exists bool) (newV V, keep bool))
+	LoadOrStore(k K, v V) (actual V, loaded bool)
+}
+
+var _ Map[string, int] = (*ConcurrentMap[string, int])(nil)
@@ -0,0 +1,82 @@
+package concurrentmap
+
+// MultiMap associates each key with an ordered list of values.
+// Set appends rather than overwrites, which makes it a good fit for
+// inverted indexes and subscription registries.
+type MultiMap[K, V comparable] struct {
+	m *ConcurrentMap[K, []V]
+}
+
+// NewMultiMap creates a new MultiMap.
+func NewMultiMap[K, V comparable](numBuckets int, hasher Hasher[K]) *MultiMap[K, V] {
+	return &MultiMap[K, V]{
+		m: New[K, []V](numBuckets, hasher),
+	}
+}
+
+// NewStringMultiMap creates a multi map with string keys.
+func NewStringMultiMap[V comparable](numBuckets int) *MultiMap[string, V] {
+	return NewMultiMap[string, V](numBuckets, fnv64a)
+}
+
+// Set appends v to the values stored at k.
+func (mm *MultiMap[K, V]) Set(k K, v V) {
+	mm.m.Compute(k, func(vals []V, exists bool) ([]V, bool) {
+		return append(vals, v), true
+	})
+}
+
+// Get returns a copy of all values stored at k, in insertion order.
+func (mm *MultiMap[K, V]) Get(k K) ([]V, bool) {
+	var out []V
+	mm.m.view(k, func(vals []V, exists bool) {
+		if exists {
+			out = append([]V(nil), vals...)
+		}
+	})
+	return out, out != nil
+}
+
+// RemoveValue removes every occurrence of v from the values stored at k.
+// The key is removed once no values remain.
+// Returns the number of values removed.
+func (mm *MultiMap[K, V]) RemoveValue(k K, v V) int {
+	removed := 0
+
+	mm.m.Compute(k, func(vals []V, exists bool) ([]V, bool) {
+		if !exists {
+			return nil, false
+		}
+		kept := vals[:0]
+		for _, x := range vals {
+			if x == v {
+				removed++
+				continue
+			}
+			kept = append(kept, x)
+		}
+		clear(vals[len(kept):])
+		return kept, len(kept) > 0
+	})
+
+	return removed
+}
+
+// CountValues returns the number of values stored at k.
+func (mm *MultiMap[K, V]) CountValues(k K) int {
+	n := 0
+	mm.m.view(k, func(vals []V, exists bool) {
+		n = len(vals)
+	})
+	return n
+}
+
+// Delete removes all values stored at k.
+func (mm *MultiMap[K, V]) Delete(k K) {
+	mm.m.Delete(k)
+}
+
+// Len returns the number of keys holding at least one value.
+func (mm *MultiMap[K, V]) Len() int {
+	return mm.m.Len()
+}
@@ -0,0 +1,33 @@
+package concurrentmap
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMultiMap(t *testing.T) {
+	mm := NewStringMultiMap[string](16)
+
+	mm.Set("topic", "a")
+	mm.Set("topic", "b")
+	mm.Set("topic", "a")
+
+	if got, ok := mm.Get("topic"); !ok || !reflect.DeepEqual(got, []string{"a", "b", "a"}) {
+		t.Fatalf("unexpected values %v, ok=%v", got, ok)
+	}
+	if mm.CountValues("topic") != 3 {
+		t.Fatalf("expected 3 values, got %d", mm.CountValues("topic"))
+	}
+
+	if n := mm.RemoveValue("topic", "a"); n != 2 {
+		t.Fatalf("expected 2 removals, got %d", n)
+	}
+	if got, _ := mm.Get("topic"); !reflect.DeepEqual(got, []string{"b"}) {
+		t.Fatalf("unexpected values after removal %v", got)
+	}
+
+	mm.RemoveValue("topic", "b")
+	if _, ok := mm.Get("topic"); ok {
+		t.Fatalf("expected key to be dropped once empty")
+	}
+}
@@ -0,0 +1,4 @@
+package concurrentmap
+
+// Option configures optional ConcurrentMap behaviour at construction time.
+type Option[K comparable, V any] func(*ConcurrentMap[K, V])
@@ -1,18 +1,123 @@
 package concurrentmap
 
+import (
+	"cmp"
+	"slices"
+	"sort"
+)
+
 // Range calls f sequentially for each key and value present in the map.
 // If f returns false, Range stops the iteration early.
 func (cm *ConcurrentMap[K, V]) Range(f func(key K, value V) bool) {
 	for i := range cm.buckets {
 		b := &cm.buckets[i]
 
+		var misplaced []K
+
 		b.mu.RLock()
-		for k, v := range b.m {
+		stopped := false
+		b.m.Range(func(k K, v V) bool {
+			if cm.debug != nil && cm.indexOf(k) != i {
+				misplaced = append(misplaced, k)
+			}
 			if !f(k, v) {
-				b.mu.RUnlock()
-				return
+				stopped = true
+				return false
 			}
+			return true
+		})
+		b.mu.RUnlock()
+
+		// Reported after unlocking, since the shard dump takes read locks.
+		for _, k := range misplaced {
+			cm.violation("Range", k, i, "key is stored in a bucket its hash does not select")
+		}
+
+		if stopped {
+			return
+		}
+	}
+}
+
+// Entry is a key/value pair copied out of the map.
+type Entry[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+// RangeSorted is Range with a stable order: it snapshots every entry,
+// sorts the snapshot with less, then calls f without holding any lock.
+// It costs O(n log n) and a copy of the map, so it is meant for tests,
+// golden files and debug output rather than hot paths.
+func (cm *ConcurrentMap[K, V]) RangeSorted(less func(a, b K) bool, f func(key K, value V) bool) {
+	entries := make([]Entry[K, V], 0, cm.Len())
+	cm.Range(func(k K, v V) bool {
+		entries = append(entries, Entry[K, V]{Key: k, Value: v})
+		return true
+	})
+
+	sort.Slice(entries, func(i, j int) bool {
+		return less(entries[i].Key, entries[j].Key)
+	})
+
+	for _, e := range entries {
+		if !f(e.Key, e.Value) {
+			return
 		}
+	}
+}
+
+// RangeOrdered calls f for every entry in ascending key order.
+func RangeOrdered[K cmp.Ordered, V any](cm *ConcurrentMap[K, V], f func(key K, value V) bool) {
+	cm.RangeSorted(cmp.Less[K], f)
+}
+
+// Cursor is a position in a Scan: the bucket to continue in and, once
+// part of it has been returned, the last key returned from it. As in
+// Redis, the zero Cursor both starts a scan and marks its end.
+type Cursor[K cmp.Ordered] struct {
+	Bucket  int
+	Last    K
+	HasLast bool
+}
+
+// Scan returns up to count entries for which match reports true (nil
+// matches everything), starting at cur, and the cursor to continue from,
+// which is the zero Cursor once the scan is complete. Buckets are
+// visited in index order under one read lock at a time, never all at
+// once, and each bucket's keys in ascending order. Resuming by key rather
+// than by offset means writes between calls cannot make a scan skip or
+// repeat a key that is present throughout; keys added or removed during
+// the scan may or may not be returned. match runs under the bucket lock
+// and must not call back into the map.
+func Scan[K cmp.Ordered, V any](cm *ConcurrentMap[K, V], cur Cursor[K], count int, match func(K, V) bool) ([]Entry[K, V], Cursor[K]) {
+	var out []Entry[K, V]
+	for ; cur.Bucket < len(cm.buckets); cur = (Cursor[K]{Bucket: cur.Bucket + 1}) {
+		want := count - len(out)
+		if want <= 0 {
+			break
+		}
+
+		var found []Entry[K, V]
+		b := &cm.buckets[cur.Bucket]
+		b.mu.RLock()
+		b.m.Range(func(k K, v V) bool {
+			if (!cur.HasLast || k > cur.Last) && (match == nil || match(k, v)) {
+				found = append(found, Entry[K, V]{Key: k, Value: v})
+			}
+			return true
+		})
 		b.mu.RUnlock()
+
+		slices.SortFunc(found, func(a, b Entry[K, V]) int { return cmp.Compare(a.Key, b.Key) })
+		if len(found) > want {
+			out = append(out, found[:want]...)
+			return out, Cursor[K]{Bucket: cur.Bucket, Last: found[want-1].Key, HasLast: true}
+		}
+		out = append(out, found...)
+	}
+	if cur.Bucket >= len(cm.buckets) {
+		return out, Cursor[K]{}
 	}
+	return out, cur
 }
@@ -0,0 +1,93 @@
+package concurrentmap
+
+type refCounted[V any] struct {
+	value V
+	refs  int
+}
+
+// RefCountMap holds shared values (connections, sessions, ...) that are
+// removed automatically once the last holder releases them.
+type RefCountMap[K comparable, V any] struct {
+	m        *ConcurrentMap[K, refCounted[V]]
+	finalize func(K, V)
+}
+
+// NewRefCountMap creates a new RefCountMap. finalize, if not nil, is
+// called with each entry after its reference count drops to zero and it
+// has been removed; it runs outside any lock.
+func NewRefCountMap[K comparable, V any](numBuckets int, hasher Hasher[K], finalize func(K, V)) *RefCountMap[K, V] {
+	return &RefCountMap[K, V]{
+		m:        New[K, refCounted[V]](numBuckets, hasher),
+		finalize: finalize,
+	}
+}
+
+// NewStringRefCountMap creates a ref-count map with string keys.
+func NewStringRefCountMap[V any](numBuckets int, finalize func(string, V)) *RefCountMap[string, V] {
+	return NewRefCountMap[string, V](numBuckets, fnv64a, finalize)
+}
+
+// Acquire takes a reference to the value at k, calling create to build
+// it if k is absent. create runs under the bucket lock, so two callers
+// racing on the same key never both create a value.
+func (rm *RefCountMap[K, V]) Acquire(k K, create func() V) V {
+	var out V
+
+	rm.m.Compute(k, func(e refCounted[V], exists bool) (refCounted[V], bool) {
+		if !exists {
+			e.value = create()
+		}
+		e.refs++
+		out = e.value
+		return e, true
+	})
+
+	return out
+}
+
+// Release drops one reference to k and returns the remaining count.
+// At zero the entry is removed and the finalizer runs. Releasing an
+// absent key returns (0, false).
+func (rm *RefCountMap[K, V]) Release(k K) (int, bool) {
+	var (
+		remaining int
+		found     bool
+		released  V
+	)
+
+	rm.m.Compute(k, func(e refCounted[V], exists bool) (refCounted[V], bool) {
+		if !exists {
+			return e, false
+		}
+		found = true
+		e.refs--
+		remaining = e.refs
+		if e.refs > 0 {
+			return e, true
+		}
+		released = e.value
+		return e, false
+	})
+
+	if found && remaining == 0 && rm.finalize != nil {
+		rm.finalize(k, released)
+	}
+	return remaining, found
+}
+
+// Get returns the value at k without taking a reference.
+func (rm *RefCountMap[K, V]) Get(k K) (V, bool) {
+	e, ok := rm.m.Get(k)
+	return e.value, ok
+}
+
+// Refs returns the current reference count of k (0 if absent).
+func (rm *RefCountMap[K, V]) Refs(k K) int {
+	e, _ := rm.m.Get(k)
+	return e.refs
+}
+
+// Len returns the number of live entries.
+func (rm *RefCountMap[K, V]) Len() int {
+	return rm.m.Len()
+}
@@ -0,0 +1,35 @@
+package concurrentmap
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRefCountMap(t *testing.T) {
+	var finalized []string
+	rm := NewStringRefCountMap(16, func(k string, v int) {
+		finalized = append(finalized, k)
+	})
+
+	created := 0
+	create := func() int { created++; return 42 }
+
+	rm.Acquire("conn", create)
+	if v := rm.Acquire("conn", create); v != 42 || created != 1 {
+		t.Fatalf("expected shared value 42 created once, got %d (created %d)", v, created)
+	}
+	if rm.Refs("conn") != 2 {
+		t.Fatalf("expected 2 refs, got %d", rm.Refs("conn"))
+	}
+
+	if n, ok := rm.Release("conn"); !ok || n != 1 || len(finalized) != 0 {
+		t.Fatalf("expected 1 remaining ref and no finalizer, got %d, %v", n, finalized)
+	}
+	rm.Release("conn")
+	if rm.Len() != 0 || !reflect.DeepEqual(finalized, []string{"conn"}) {
+		t.Fatalf("expected entry removed and finalized once, got Len=%d, %v", rm.Len(), finalized)
+	}
+	if _, ok := rm.Release("conn"); ok {
+		t.Fatalf("expected release of absent key to report false")
+	}
+}
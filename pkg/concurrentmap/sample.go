@@ -0,0 +1,122 @@
+package concurrentmap
+
+import "math/rand/v2"
+
+// Sample returns up to n distinct entries chosen at random.
+// It starts at a random bucket and takes a small random subset from each
+// bucket it visits (reservoir sampling), stopping as soon as n entries
+// are collected, so it only touches a fraction of the map when n is small.
+func (cm *ConcurrentMap[K, V]) Sample(n int) []Entry[K, V] {
+	if n <= 0 {
+		return nil
+	}
+
+	numBuckets := len(cm.buckets)
+	quota := n/numBuckets + 1
+	start := rand.IntN(numBuckets)
+	out := make([]Entry[K, V], 0, n)
+
+	for i := 0; i < numBuckets && len(out) < n; i++ {
+		b := &cm.buckets[(start+i)%numBuckets]
+		want := min(quota, n-len(out))
+
+		reservoir := make([]Entry[K, V], 0, want)
+		seen := 0
+
+		b.mu.RLock()
+		b.m.Range(func(k K, v V) bool {
+			seen++
+			if len(reservoir) < want {
+				reservoir = append(reservoir, Entry[K, V]{Key: k, Value: v})
+			} else if j := rand.IntN(seen); j < want {
+				reservoir[j] = Entry[K, V]{Key: k, Value: v}
+			}
+			return true
+		})
+		b.mu.RUnlock()
+
+		out = append(out, reservoir...)
+	}
+
+	return out
+}
+
+// sampleVisitFactor bounds the entries SampleBucket looks at, so a bucket
+// with few matches is not walked end to end.
+const sampleVisitFactor = 20
+
+// SampleBucket returns up to n entries of bucket i for which match
+// reports true (nil matches all). It holds only that bucket's read lock
+// and looks at no more than 20*n entries, in the order the Storage
+// ranges over them: for the default Go map that order is randomized on
+// every call, which makes the result a cheap random sample.
+func (cm *ConcurrentMap[K, V]) SampleBucket(i, n int, match func(K, V) bool) []Entry[K, V] {
+	if n <= 0 {
+		return nil
+	}
+	b := &cm.buckets[i]
+	out := make([]Entry[K, V], 0, n)
+	visits := n * sampleVisitFactor
+
+	b.mu.RLock()
+	b.m.Range(func(k K, v V) bool {
+		if match == nil || match(k, v) {
+			out = append(out, Entry[K, V]{Key: k, Value: v})
+		}
+		visits--
+		return len(out) < n && visits > 0
+	})
+	b.mu.RUnlock()
+
+	return out
+}
+
+// PopRandom removes and returns a random entry.
+// It picks a random non-empty bucket and a random entry within it, so
+// only one bucket is locked and scanned. If a configured Deleter fails,
+// the entry is kept and nothing is returned.
+func (cm *ConcurrentMap[K, V]) PopRandom() (K, V, bool) {
+	numBuckets := len(cm.buckets)
+	start := rand.IntN(numBuckets)
+
+	for i := 0; i < numBuckets; i++ {
+		b := &cm.buckets[(start+i)%numBuckets]
+
+		b.mu.Lock()
+		size := b.m.Len()
+		if size == 0 {
+			b.mu.Unlock()
+			continue
+		}
+
+		var (
+			key K
+			val V
+		)
+		skip := rand.IntN(size)
+		b.m.Range(func(k K, v V) bool {
+			if skip > 0 {
+				skip--
+				return true
+			}
+			key, val = k, v
+			return false
+		})
+
+		if cm.deleteThrough(key) != nil {
+			b.mu.Unlock()
+			break
+		}
+		b.m.Delete(key)
+		cm.emit(EventDelete, key, val, val)
+		b.mu.Unlock()
+
+		return key, val, true
+	}
+
+	var (
+		zeroK K
+		zeroV V
+	)
+	return zeroK, zeroV, false
+}
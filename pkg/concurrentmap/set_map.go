@@ -0,0 +1,177 @@
+package concurrentmap
+
+// SetMap maps each key to a set of members.
+// All mutations of a key's set happen under that key's bucket lock,
+// so Add/Remove/Contains never observe a half-updated set.
+type SetMap[K, E comparable] struct {
+	m *ConcurrentMap[K, map[E]struct{}]
+}
+
+// NewSetMap creates a new SetMap.
+func NewSetMap[K, E comparable](numBuckets int, hasher Hasher[K]) *SetMap[K, E] {
+	return &SetMap[K, E]{
+		m: New[K, map[E]struct{}](numBuckets, hasher),
+	}
+}
+
+// NewStringSetMap creates a set map with string keys.
+func NewStringSetMap[E comparable](numBuckets int) *SetMap[string, E] {
+	return NewSetMap[string, E](numBuckets, fnv64a)
+}
+
+// Add inserts members into the set stored at k, creating it if needed.
+// Returns the number of members that were not already present.
+func (sm *SetMap[K, E]) Add(k K, members ...E) int {
+	added := 0
+
+	sm.m.Compute(k, func(set map[E]struct{}, exists bool) (map[E]struct{}, bool) {
+		if !exists {
+			set = make(map[E]struct{}, len(members))
+		}
+		for _, e := range members {
+			if _, ok := set[e]; !ok {
+				set[e] = struct{}{}
+				added++
+			}
+		}
+		return set, len(set) > 0
+	})
+
+	return added
+}
+
+// Remove deletes members from the set stored at k.
+// The key is removed once its set becomes empty.
+// Returns the number of members that were actually removed.
+func (sm *SetMap[K, E]) Remove(k K, members ...E) int {
+	removed := 0
+
+	sm.m.Compute(k, func(set map[E]struct{}, exists bool) (map[E]struct{}, bool) {
+		if !exists {
+			return nil, false
+		}
+		for _, e := range members {
+			if _, ok := set[e]; ok {
+				delete(set, e)
+				removed++
+			}
+		}
+		return set, len(set) > 0
+	})
+
+	return removed
+}
+
+// Replace makes members the set stored at k, in one step, removing the
+// key if there are none.
+func (sm *SetMap[K, E]) Replace(k K, members ...E) {
+	sm.m.Compute(k, func(map[E]struct{}, bool) (map[E]struct{}, bool) {
+		set := make(map[E]struct{}, len(members))
+		for _, e := range members {
+			set[e] = struct{}{}
+		}
+		return set, len(set) > 0
+	})
+}
+
+// Contains reports whether member is in the set stored at k.
+func (sm *SetMap[K, E]) Contains(k K, member E) bool {
+	found := false
+	sm.m.view(k, func(set map[E]struct{}, exists bool) {
+		if exists {
+			_, found = set[member]
+		}
+	})
+	return found
+}
+
+// Members returns a copy of the set stored at k, in no particular order.
+func (sm *SetMap[K, E]) Members(k K) []E {
+	var out []E
+	sm.m.view(k, func(set map[E]struct{}, exists bool) {
+		if !exists {
+			return
+		}
+		out = make([]E, 0, len(set))
+		for e := range set {
+			out = append(out, e)
+		}
+	})
+	return out
+}
+
+// Cardinality returns the number of members in the set stored at k.
+func (sm *SetMap[K, E]) Cardinality(k K) int {
+	n := 0
+	sm.m.view(k, func(set map[E]struct{}, exists bool) {
+		n = len(set)
+	})
+	return n
+}
+
+// Inter returns the members common to the sets stored at keys, in no
+// particular order. Each set is read atomically, but not all of them
+// together.
+func (sm *SetMap[K, E]) Inter(keys ...K) []E {
+	return sm.filter(keys, true)
+}
+
+// Union returns the members of any of the sets stored at keys, in no
+// particular order. Each set is read atomically, but not all of them
+// together.
+func (sm *SetMap[K, E]) Union(keys ...K) []E {
+	seen := make(map[E]struct{})
+	var out []E
+	for _, k := range keys {
+		sm.m.view(k, func(set map[E]struct{}, exists bool) {
+			for e := range set {
+				if _, ok := seen[e]; !ok {
+					seen[e] = struct{}{}
+					out = append(out, e)
+				}
+			}
+		})
+	}
+	return out
+}
+
+// Diff returns the members of the set stored at keys[0] that are in none
+// of the sets stored at the other keys, in no particular order. Each set
+// is read atomically, but not all of them together.
+func (sm *SetMap[K, E]) Diff(keys ...K) []E {
+	return sm.filter(keys, false)
+}
+
+// filter returns the members of the set at keys[0] that are (in) or are
+// not (!in) in every set at the other keys.
+func (sm *SetMap[K, E]) filter(keys []K, in bool) []E {
+	if len(keys) == 0 {
+		return nil
+	}
+	out := sm.Members(keys[0])
+	for _, k := range keys[1:] {
+		if len(out) == 0 {
+			break
+		}
+		sm.m.view(k, func(set map[E]struct{}, exists bool) {
+			kept := out[:0]
+			for _, e := range out {
+				if _, ok := set[e]; ok == in {
+					kept = append(kept, e)
+				}
+			}
+			out = kept
+		})
+	}
+	return out
+}
+
+// Delete removes the whole set stored at k.
+func (sm *SetMap[K, E]) Delete(k K) {
+	sm.m.Delete(k)
+}
+
+// Len returns the number of keys that hold a non-empty set.
+func (sm *SetMap[K, E]) Len() int {
+	return sm.m.Len()
+}
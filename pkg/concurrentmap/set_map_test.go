@@ -0,0 +1,105 @@
+package concurrentmap
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestSetMapBasic(t *testing.T) {
+	sm := NewStringSetMap[string](16)
+
+	if n := sm.Add("fruits", "apple", "pear", "apple"); n != 2 {
+		t.Fatalf("expected 2 new members, got %d", n)
+	}
+	if !sm.Contains("fruits", "pear") {
+		t.Fatalf("expected pear to be a member")
+	}
+	if sm.Contains("fruits", "plum") {
+		t.Fatalf("did not expect plum to be a member")
+	}
+	if c := sm.Cardinality("fruits"); c != 2 {
+		t.Fatalf("expected cardinality 2, got %d", c)
+	}
+
+	members := sm.Members("fruits")
+	sort.Strings(members)
+	if len(members) != 2 || members[0] != "apple" || members[1] != "pear" {
+		t.Fatalf("unexpected members %v", members)
+	}
+
+	if n := sm.Remove("fruits", "apple", "plum"); n != 1 {
+		t.Fatalf("expected 1 removed member, got %d", n)
+	}
+	sm.Remove("fruits", "pear")
+	if sm.Len() != 0 {
+		t.Fatalf("expected empty set to be dropped, Len=%d", sm.Len())
+	}
+}
+
+func TestSetMapConcurrentAdd(t *testing.T) {
+	sm := NewStringSetMap[int](8)
+
+	var wg sync.WaitGroup
+	wg.Add(20)
+
+	for g := 0; g < 20; g++ {
+		go func(id int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				sm.Add("k"+strconv.Itoa(i%5), id*100+i)
+			}
+		}(g)
+	}
+
+	wg.Wait()
+
+	total := 0
+	for i := 0; i < 5; i++ {
+		total += sm.Cardinality("k" + strconv.Itoa(i))
+	}
+	if total != 2000 {
+		t.Fatalf("expected 2000 members overall, got %d", total)
+	}
+}
+
+func TestSetMapAlgebra(t *testing.T) {
+	sm := NewStringSetMap[string](16)
+	sm.Add("a", "1", "2", "3")
+	sm.Add("b", "2", "3", "4")
+	sm.Add("c", "3", "5")
+
+	sorted := func(members []string) []string {
+		sort.Strings(members)
+		return members
+	}
+	for _, tc := range []struct {
+		name string
+		got  []string
+		want []string
+	}{
+		{"inter", sm.Inter("a", "b"), []string{"2", "3"}},
+		{"inter3", sm.Inter("a", "b", "c"), []string{"3"}},
+		{"inter missing", sm.Inter("a", "missing"), []string{}},
+		{"union", sm.Union("a", "b", "c"), []string{"1", "2", "3", "4", "5"}},
+		{"union missing", sm.Union("missing", "c"), []string{"3", "5"}},
+		{"diff", sm.Diff("a", "b"), []string{"1"}},
+		{"diff3", sm.Diff("b", "a", "c"), []string{"4"}},
+		{"diff missing", sm.Diff("missing", "a"), []string{}},
+	} {
+		if got := sorted(tc.got); len(got) != len(tc.want) || (len(got) > 0 && !reflect.DeepEqual(got, tc.want)) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	sm.Replace("a", "9", "9", "8")
+	if got := sorted(sm.Members("a")); !reflect.DeepEqual(got, []string{"8", "9"}) {
+		t.Fatalf("unexpected members after Replace %v", got)
+	}
+	sm.Replace("a")
+	if sm.Cardinality("a") != 0 || sm.Len() != 2 {
+		t.Fatalf("expected Replace with no members to drop the key")
+	}
+}
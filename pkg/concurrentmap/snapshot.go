@@ -0,0 +1,30 @@
+package concurrentmap
+
+// Snapshot returns a point-in-time copy of every entry. All buckets are
+// read-locked together (in index order, so concurrent snapshots cannot
+// deadlock) while the entries are copied, which makes the result
+// consistent across shards: no write is half-visible. Writers wait only
+// for the in-memory copy, not for whatever the caller does with it, such
+// as serializing it to disk.
+func (cm *ConcurrentMap[K, V]) Snapshot() []Entry[K, V] {
+	for i := range cm.buckets {
+		cm.buckets[i].mu.RLock()
+	}
+
+	n := 0
+	for i := range cm.buckets {
+		n += cm.buckets[i].m.Len()
+	}
+	entries := make([]Entry[K, V], 0, n)
+	for i := range cm.buckets {
+		cm.buckets[i].m.Range(func(k K, v V) bool {
+			entries = append(entries, Entry[K, V]{Key: k, Value: v})
+			return true
+		})
+	}
+
+	for i := range cm.buckets {
+		cm.buckets[i].mu.RUnlock()
+	}
+	return entries
+}
@@ -0,0 +1,451 @@
+package concurrentmap
+
+import (
+	"errors"
+	"math"
+	"math/rand/v2"
+)
+
+// ErrInvalidScore is returned when a score is NaN, which does not order
+// against other scores and would corrupt the skiplist.
+var ErrInvalidScore = errors.New("concurrentmap: score is NaN")
+
+// ScoredMember is a sorted-set member together with its score.
+type ScoredMember struct {
+	Member string
+	Score  float64
+}
+
+// SortedSetMap maps each key to a set of members ordered by score.
+// Every key owns a skiplist, so rank and range queries are O(log n).
+// Like CounterMap it is built on ConcurrentMap, and each operation on a
+// key runs atomically under that key's bucket lock.
+type SortedSetMap[K comparable] struct {
+	m *ConcurrentMap[K, *skiplist]
+}
+
+// NewSortedSetMap creates a new SortedSetMap.
+func NewSortedSetMap[K comparable](numBuckets int, hasher Hasher[K]) *SortedSetMap[K] {
+	return &SortedSetMap[K]{
+		m: New[K, *skiplist](numBuckets, hasher),
+	}
+}
+
+// NewStringSortedSetMap creates a sorted set map with string keys.
+func NewStringSortedSetMap(numBuckets int) *SortedSetMap[string] {
+	return NewSortedSetMap[string](numBuckets, fnv64a)
+}
+
+// ZAdd sets the score of member in the sorted set at k.
+// Returns true if member was newly added, false if its score was updated.
+// A NaN score is rejected with ErrInvalidScore.
+func (sm *SortedSetMap[K]) ZAdd(k K, member string, score float64) (bool, error) {
+	if math.IsNaN(score) {
+		return false, ErrInvalidScore
+	}
+
+	added := false
+
+	sm.m.Compute(k, func(zs *skiplist, exists bool) (*skiplist, bool) {
+		if !exists {
+			zs = newSkiplist()
+		}
+		added = zs.set(member, score)
+		return zs, true
+	})
+
+	return added, nil
+}
+
+// ZIncrBy atomically adds delta to the score of member (starting from 0
+// if absent) and returns the new score. If the new score would be NaN
+// (say +Inf plus -Inf), nothing changes and ErrInvalidScore is returned.
+func (sm *SortedSetMap[K]) ZIncrBy(k K, member string, delta float64) (float64, error) {
+	var result float64
+
+	sm.m.Compute(k, func(zs *skiplist, exists bool) (*skiplist, bool) {
+		if !exists {
+			zs = newSkiplist()
+		}
+		if n, ok := zs.index[member]; ok {
+			result = n.score + delta
+		} else {
+			result = delta
+		}
+		if math.IsNaN(result) {
+			return zs, zs.length > 0
+		}
+		zs.set(member, result)
+		return zs, true
+	})
+
+	if math.IsNaN(result) {
+		return 0, ErrInvalidScore
+	}
+	return result, nil
+}
+
+// ZRem removes member from the sorted set at k.
+// The key is removed once its set becomes empty.
+func (sm *SortedSetMap[K]) ZRem(k K, member string) bool {
+	removed := false
+
+	sm.m.Compute(k, func(zs *skiplist, exists bool) (*skiplist, bool) {
+		if !exists {
+			return nil, false
+		}
+		removed = zs.remove(member)
+		return zs, zs.length > 0
+	})
+
+	return removed
+}
+
+// ZScore returns the score of member in the sorted set at k.
+func (sm *SortedSetMap[K]) ZScore(k K, member string) (float64, bool) {
+	var (
+		score float64
+		found bool
+	)
+	sm.m.view(k, func(zs *skiplist, exists bool) {
+		if !exists {
+			return
+		}
+		if n, ok := zs.index[member]; ok {
+			score, found = n.score, true
+		}
+	})
+	return score, found
+}
+
+// ZCard returns the number of members in the sorted set at k.
+func (sm *SortedSetMap[K]) ZCard(k K) int {
+	n := 0
+	sm.m.view(k, func(zs *skiplist, exists bool) {
+		if exists {
+			n = zs.length
+		}
+	})
+	return n
+}
+
+// ZRank returns the 0-based position of member in ascending score order.
+func (sm *SortedSetMap[K]) ZRank(k K, member string) (int, bool) {
+	rank, found := 0, false
+	sm.m.view(k, func(zs *skiplist, exists bool) {
+		if !exists {
+			return
+		}
+		rank, found = zs.rank(member)
+	})
+	return rank, found
+}
+
+// ZRevRank returns the 0-based position of member in descending score
+// order.
+func (sm *SortedSetMap[K]) ZRevRank(k K, member string) (int, bool) {
+	rank, found := 0, false
+	sm.m.view(k, func(zs *skiplist, exists bool) {
+		if !exists {
+			return
+		}
+		if rank, found = zs.rank(member); found {
+			rank = zs.length - 1 - rank
+		}
+	})
+	return rank, found
+}
+
+// ZRange returns members between ranks start and stop (both inclusive)
+// in ascending score order. Negative ranks count from the highest score.
+func (sm *SortedSetMap[K]) ZRange(k K, start, stop int) []ScoredMember {
+	return sm.rangeByRank(k, start, stop, false)
+}
+
+// ZRevRange returns members between ranks start and stop (both
+// inclusive) in descending score order, so rank 0 has the highest score.
+// Negative ranks count from the lowest score.
+func (sm *SortedSetMap[K]) ZRevRange(k K, start, stop int) []ScoredMember {
+	return sm.rangeByRank(k, start, stop, true)
+}
+
+func (sm *SortedSetMap[K]) rangeByRank(k K, start, stop int, rev bool) []ScoredMember {
+	var out []ScoredMember
+
+	sm.m.view(k, func(zs *skiplist, exists bool) {
+		if !exists {
+			return
+		}
+
+		n := zs.length
+		if start < 0 {
+			start += n
+		}
+		if stop < 0 {
+			stop += n
+		}
+		if start < 0 {
+			start = 0
+		}
+		if stop >= n {
+			stop = n - 1
+		}
+		if start > stop {
+			return
+		}
+		if rev {
+			start, stop = n-1-stop, n-1-start
+		}
+
+		out = make([]ScoredMember, 0, stop-start+1)
+		for x := zs.byRank(start + 1); x != nil && len(out) < stop-start+1; x = x.next[0].node {
+			out = append(out, ScoredMember{Member: x.member, Score: x.score})
+		}
+		if rev {
+			for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+				out[i], out[j] = out[j], out[i]
+			}
+		}
+	})
+
+	return out
+}
+
+// ZRangeByScore returns members with min <= score <= max in ascending order.
+func (sm *SortedSetMap[K]) ZRangeByScore(k K, min, max float64) []ScoredMember {
+	var out []ScoredMember
+
+	sm.m.view(k, func(zs *skiplist, exists bool) {
+		if !exists {
+			return
+		}
+		for x := zs.firstAtLeast(min); x != nil && x.score <= max; x = x.next[0].node {
+			out = append(out, ScoredMember{Member: x.member, Score: x.score})
+		}
+	})
+
+	return out
+}
+
+// Replace makes members the sorted set stored at k, in one step, removing
+// the key if there are none. A member listed twice keeps its last score.
+// If any score is NaN, the set is left as it was and ErrInvalidScore is
+// returned.
+func (sm *SortedSetMap[K]) Replace(k K, members ...ScoredMember) error {
+	for _, m := range members {
+		if math.IsNaN(m.Score) {
+			return ErrInvalidScore
+		}
+	}
+
+	sm.m.Compute(k, func(*skiplist, bool) (*skiplist, bool) {
+		zs := newSkiplist()
+		for _, m := range members {
+			zs.set(m.Member, m.Score)
+		}
+		return zs, zs.length > 0
+	})
+	return nil
+}
+
+// Delete removes the whole sorted set stored at k.
+func (sm *SortedSetMap[K]) Delete(k K) {
+	sm.m.Delete(k)
+}
+
+// Len returns the number of keys that hold a non-empty sorted set.
+func (sm *SortedSetMap[K]) Len() int {
+	return sm.m.Len()
+}
+
+// ----------- Indexable Skiplist -----------
+
+const skiplistMaxLevel = 32
+
+type skipLink struct {
+	node *skipNode
+	span int // number of level-0 hops this link skips
+}
+
+type skipNode struct {
+	member string
+	score  float64
+	next   []skipLink
+}
+
+// skiplist keeps members ordered by (score, member) and tracks link
+// spans so rank lookups don't need a linear walk.
+// It is not safe for concurrent use; SortedSetMap guards it with bucket locks.
+type skiplist struct {
+	head   *skipNode
+	level  int
+	length int
+	index  map[string]*skipNode
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		head:  &skipNode{next: make([]skipLink, skiplistMaxLevel)},
+		level: 1,
+		index: make(map[string]*skipNode),
+	}
+}
+
+func randomLevel() int {
+	lvl := 1
+	for lvl < skiplistMaxLevel && rand.IntN(4) == 0 {
+		lvl++
+	}
+	return lvl
+}
+
+// before reports whether n sorts strictly before (score, member).
+func (n *skipNode) before(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+// set inserts or re-scores member. Returns true if member was new.
+func (zs *skiplist) set(member string, score float64) bool {
+	if n, ok := zs.index[member]; ok {
+		if n.score == score {
+			return false
+		}
+		zs.delete(n.member, n.score)
+		zs.insert(member, score)
+		return false
+	}
+	zs.insert(member, score)
+	return true
+}
+
+func (zs *skiplist) remove(member string) bool {
+	n, ok := zs.index[member]
+	if !ok {
+		return false
+	}
+	zs.delete(n.member, n.score)
+	return true
+}
+
+func (zs *skiplist) insert(member string, score float64) {
+	var (
+		update [skiplistMaxLevel]*skipNode
+		rank   [skiplistMaxLevel]int
+	)
+
+	x := zs.head
+	for i := zs.level - 1; i >= 0; i-- {
+		if i < zs.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.next[i].node != nil && x.next[i].node.before(score, member) {
+			rank[i] += x.next[i].span
+			x = x.next[i].node
+		}
+		update[i] = x
+	}
+
+	lvl := randomLevel()
+	if lvl > zs.level {
+		for i := zs.level; i < lvl; i++ {
+			update[i] = zs.head
+			zs.head.next[i].span = zs.length
+		}
+		zs.level = lvl
+	}
+
+	n := &skipNode{member: member, score: score, next: make([]skipLink, lvl)}
+	for i := 0; i < lvl; i++ {
+		n.next[i].node = update[i].next[i].node
+		update[i].next[i].node = n
+
+		n.next[i].span = update[i].next[i].span - (rank[0] - rank[i])
+		update[i].next[i].span = rank[0] - rank[i] + 1
+	}
+	for i := lvl; i < zs.level; i++ {
+		update[i].next[i].span++
+	}
+
+	zs.index[member] = n
+	zs.length++
+}
+
+func (zs *skiplist) delete(member string, score float64) {
+	var update [skiplistMaxLevel]*skipNode
+
+	x := zs.head
+	for i := zs.level - 1; i >= 0; i-- {
+		for x.next[i].node != nil && x.next[i].node.before(score, member) {
+			x = x.next[i].node
+		}
+		update[i] = x
+	}
+
+	x = x.next[0].node
+	if x == nil || x.member != member {
+		return
+	}
+
+	for i := 0; i < zs.level; i++ {
+		if update[i].next[i].node == x {
+			update[i].next[i].span += x.next[i].span - 1
+			update[i].next[i].node = x.next[i].node
+		} else {
+			update[i].next[i].span--
+		}
+	}
+	for zs.level > 1 && zs.head.next[zs.level-1].node == nil {
+		zs.level--
+	}
+
+	delete(zs.index, member)
+	zs.length--
+}
+
+// rank returns the 0-based rank of member.
+func (zs *skiplist) rank(member string) (int, bool) {
+	target, ok := zs.index[member]
+	if !ok {
+		return 0, false
+	}
+
+	r := 0
+	x := zs.head
+	for i := zs.level - 1; i >= 0; i-- {
+		for x.next[i].node != nil && !target.before(x.next[i].node.score, x.next[i].node.member) {
+			r += x.next[i].span
+			x = x.next[i].node
+		}
+		if x == target {
+			return r - 1, true
+		}
+	}
+	return 0, false
+}
+
+// byRank returns the node at 1-based rank r, or nil.
+func (zs *skiplist) byRank(r int) *skipNode {
+	traversed := 0
+	x := zs.head
+	for i := zs.level - 1; i >= 0; i-- {
+		for x.next[i].node != nil && traversed+x.next[i].span <= r {
+			traversed += x.next[i].span
+			x = x.next[i].node
+		}
+		if traversed == r {
+			return x
+		}
+	}
+	return nil
+}
+
+// firstAtLeast returns the first node whose score is >= min, or nil.
+func (zs *skiplist) firstAtLeast(min float64) *skipNode {
+	x := zs.head
+	for i := zs.level - 1; i >= 0; i-- {
+		for x.next[i].node != nil && x.next[i].node.score < min {
+			x = x.next[i].node
+		}
+	}
+	return x.next[0].node
+}
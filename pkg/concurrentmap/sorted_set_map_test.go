@@ -0,0 +1,164 @@
+package concurrentmap
+
+import (
+	"errors"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestSortedSetMapBasic(t *testing.T) {
+	zm := NewStringSortedSetMap(16)
+
+	zm.ZAdd("board", "alice", 30)
+	zm.ZAdd("board", "bob", 10)
+	if added, _ := zm.ZAdd("board", "carol", 20); !added {
+		t.Fatalf("expected carol to be newly added")
+	}
+	if added, _ := zm.ZAdd("board", "bob", 40); added {
+		t.Fatalf("expected bob to be updated, not added")
+	}
+
+	if r, ok := zm.ZRank("board", "bob"); !ok || r != 2 {
+		t.Fatalf("expected bob rank 2, got %d, ok=%v", r, ok)
+	}
+	if s, _ := zm.ZIncrBy("board", "carol", 25); s != 45 {
+		t.Fatalf("expected carol score 45, got %v", s)
+	}
+
+	got := zm.ZRangeByScore("board", 30, 45)
+	if len(got) != 3 || got[0].Member != "alice" || got[1].Member != "bob" || got[2].Member != "carol" {
+		t.Fatalf("unexpected range %v", got)
+	}
+
+	if top := zm.ZRange("board", -1, -1); len(top) != 1 || top[0].Member != "carol" {
+		t.Fatalf("expected carol on top, got %v", top)
+	}
+
+	zm.ZRem("board", "alice")
+	if zm.ZCard("board") != 2 {
+		t.Fatalf("expected 2 members, got %d", zm.ZCard("board"))
+	}
+}
+
+func TestSortedSetMapRejectsNaN(t *testing.T) {
+	zm := NewStringSortedSetMap(16)
+
+	if _, err := zm.ZAdd("k", "nan", math.NaN()); !errors.Is(err, ErrInvalidScore) {
+		t.Fatalf("expected ErrInvalidScore for a NaN score, got %v", err)
+	}
+	if zm.Len() != 0 {
+		t.Fatalf("expected a rejected ZAdd to leave no key behind")
+	}
+	if _, err := zm.ZIncrBy("k", "nan", math.NaN()); !errors.Is(err, ErrInvalidScore) {
+		t.Fatalf("expected ErrInvalidScore for a NaN delta, got %v", err)
+	}
+	if zm.Len() != 0 {
+		t.Fatalf("expected a rejected ZIncrBy to leave no key behind")
+	}
+
+	zm.ZAdd("k", "low", -1)
+	zm.ZAdd("k", "inf", math.Inf(1))
+	zm.ZAdd("k", "high", 1)
+	if _, err := zm.ZIncrBy("k", "inf", math.Inf(-1)); !errors.Is(err, ErrInvalidScore) {
+		t.Fatalf("expected ErrInvalidScore for +Inf plus -Inf, got %v", err)
+	}
+	if s, ok := zm.ZScore("k", "inf"); !ok || !math.IsInf(s, 1) {
+		t.Fatalf("expected inf to keep its score, got %v, ok=%v", s, ok)
+	}
+
+	got := zm.ZRange("k", 0, -1)
+	if len(got) != 3 || got[0].Member != "low" || got[1].Member != "high" || got[2].Member != "inf" {
+		t.Fatalf("unexpected order %v", got)
+	}
+	for i, m := range got {
+		if r, ok := zm.ZRank("k", m.Member); !ok || r != i {
+			t.Fatalf("expected %s at rank %d, got %d", m.Member, i, r)
+		}
+	}
+}
+
+func TestSortedSetMapRanksMatchSort(t *testing.T) {
+	zm := NewStringSortedSetMap(4)
+	scores := make(map[string]float64)
+
+	for i := 0; i < 2000; i++ {
+		member := "m" + strconv.Itoa(rand.IntN(300))
+		if rand.IntN(5) == 0 {
+			zm.ZRem("k", member)
+			delete(scores, member)
+			continue
+		}
+		score := float64(rand.IntN(50))
+		zm.ZAdd("k", member, score)
+		scores[member] = score
+	}
+
+	want := make([]ScoredMember, 0, len(scores))
+	for m, s := range scores {
+		want = append(want, ScoredMember{Member: m, Score: s})
+	}
+	sort.Slice(want, func(i, j int) bool {
+		if want[i].Score != want[j].Score {
+			return want[i].Score < want[j].Score
+		}
+		return want[i].Member < want[j].Member
+	})
+
+	got := zm.ZRange("k", 0, -1)
+	if len(got) != len(want) {
+		t.Fatalf("expected %d members, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("position %d: expected %v, got %v", i, want[i], got[i])
+		}
+		if r, ok := zm.ZRank("k", want[i].Member); !ok || r != i {
+			t.Fatalf("expected %s at rank %d, got %d", want[i].Member, i, r)
+		}
+	}
+}
+
+func TestSortedSetMapReverse(t *testing.T) {
+	zm := NewStringSortedSetMap(16)
+	if err := zm.Replace("board", []ScoredMember{{"alice", 30}, {"bob", 10}, {"carol", 20}, {"bob", 40}}...); err != nil {
+		t.Fatalf("Replace: %v", err)
+	}
+
+	if zm.ZCard("board") != 3 {
+		t.Fatalf("expected 3 members after Replace, got %d", zm.ZCard("board"))
+	}
+	if r, ok := zm.ZRevRank("board", "bob"); !ok || r != 0 {
+		t.Fatalf("expected bob first in reverse, got %d, ok=%v", r, ok)
+	}
+	if _, ok := zm.ZRevRank("board", "dave"); ok {
+		t.Fatalf("did not expect a rank for a missing member")
+	}
+
+	got := zm.ZRevRange("board", 0, 1)
+	if len(got) != 2 || got[0].Member != "bob" || got[1].Member != "alice" {
+		t.Fatalf("unexpected top two %v", got)
+	}
+	if last := zm.ZRevRange("board", -1, -1); len(last) != 1 || last[0].Member != "carol" {
+		t.Fatalf("expected carol last, got %v", last)
+	}
+	if none := zm.ZRevRange("board", 5, 9); len(none) != 0 {
+		t.Fatalf("expected an empty range, got %v", none)
+	}
+
+	if err := zm.Replace("board", ScoredMember{"dave", math.NaN()}); !errors.Is(err, ErrInvalidScore) {
+		t.Fatalf("expected ErrInvalidScore for a NaN score, got %v", err)
+	}
+	if zm.ZCard("board") != 3 {
+		t.Fatalf("expected a rejected Replace to keep the set, got %d members", zm.ZCard("board"))
+	}
+
+	if err := zm.Replace("board"); err != nil {
+		t.Fatalf("Replace: %v", err)
+	}
+	if zm.Len() != 0 {
+		t.Fatalf("expected Replace with no members to drop the key")
+	}
+}
@@ -0,0 +1,52 @@
+package concurrentmap
+
+// Storage holds the entries of a single bucket.
+// ConcurrentMap serializes access with the bucket's RWMutex, so
+// implementations need no locking of their own: Get, Len and Range are
+// only called under a read lock, Set and Delete under the write lock.
+// This is the extension point for alternative layouts (open addressing,
+// off-heap arenas, disk-backed shards) that keeps the public API unchanged.
+type Storage[K comparable, V any] interface {
+	Get(k K) (V, bool)
+	Set(k K, v V)
+	Delete(k K)
+	Len() int
+	// Range calls f for each entry until f returns false.
+	// f must not modify the storage.
+	Range(f func(k K, v V) bool)
+}
+
+// WithStorage makes every bucket use the Storage returned by newStorage
+// instead of a built-in Go map. newStorage is called once per bucket.
+func WithStorage[K comparable, V any](newStorage func() Storage[K, V]) Option[K, V] {
+	return func(cm *ConcurrentMap[K, V]) {
+		cm.newStorage = newStorage
+	}
+}
+
+// ----------- Default Go map Storage -----------
+
+type mapStorage[K comparable, V any] map[K]V
+
+func newMapStorage[K comparable, V any]() Storage[K, V] {
+	return make(mapStorage[K, V])
+}
+
+func (s mapStorage[K, V]) Get(k K) (V, bool) {
+	v, ok := s[k]
+	return v, ok
+}
+
+func (s mapStorage[K, V]) Set(k K, v V) { s[k] = v }
+
+func (s mapStorage[K, V]) Delete(k K) { delete(s, k) }
+
+func (s mapStorage[K, V]) Len() int { return len(s) }
+
+func (s mapStorage[K, V]) Range(f func(k K, v V) bool) {
+	for k, v := range s {
+		if !f(k, v) {
+			return
+		}
+	}
+}
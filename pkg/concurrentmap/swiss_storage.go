@@ -0,0 +1,211 @@
+package concurrentmap
+
+import "math/bits"
+
+// WithSwissTable makes every bucket use an open-addressing swiss table
+// instead of a built-in Go map. Entries live in flat key/value arrays
+// indexed by one control byte per slot, which avoids the per-entry
+// overflow-bucket overhead of Go maps and keeps probes cache-friendly;
+// the gain is largest for maps with millions of small entries. Since Go
+// 1.24 the built-in map is itself a swiss table, so the difference is
+// smaller there; BenchmarkStorage* measures both on the current toolchain.
+//
+// The table reuses the map's hasher (re-mixed, since the low bits were
+// already spent picking the bucket). It replaces any WithStorage option
+// given before it.
+func WithSwissTable[K comparable, V any]() Option[K, V] {
+	return func(cm *ConcurrentMap[K, V]) {
+		cm.newStorage = func() Storage[K, V] {
+			return newSwissStorage[K, V](cm.hasher)
+		}
+	}
+}
+
+// ----------- Swiss Table Storage -----------
+
+const (
+	swissGroupSize = 8
+	swissEmpty     = 0x80 // 1000_0000
+	swissDeleted   = 0xFE // 1111_1110
+	swissLSBs      = 0x0101010101010101
+	swissMSBs      = 0x8080808080808080
+)
+
+// swissStorage is a portable (SWAR, no assembly) swiss table.
+// Slots are grouped by eight; each group's control bytes are packed into
+// one uint64 so a single word comparison checks all eight slots.
+// A control byte is either empty, deleted, or the low 7 bits (h2) of the
+// key's hash; the remaining bits (h1) choose the starting group.
+type swissStorage[K comparable, V any] struct {
+	hash    Hasher[K]
+	ctrl    []uint64
+	keys    []K
+	vals    []V
+	used    int // full slots
+	deleted int // tombstones
+}
+
+func newSwissStorage[K comparable, V any](hash Hasher[K]) *swissStorage[K, V] {
+	s := &swissStorage[K, V]{hash: hash}
+	s.resize(1)
+	return s
+}
+
+func (s *swissStorage[K, V]) resize(groups int) {
+	oldCtrl, oldKeys, oldVals := s.ctrl, s.keys, s.vals
+
+	s.ctrl = make([]uint64, groups)
+	for i := range s.ctrl {
+		s.ctrl[i] = swissLSBs * swissEmpty
+	}
+	s.keys = make([]K, groups*swissGroupSize)
+	s.vals = make([]V, groups*swissGroupSize)
+	s.used, s.deleted = 0, 0
+
+	for g, w := range oldCtrl {
+		for j := 0; j < swissGroupSize; j++ {
+			if ctrlByte(w, j)&0x80 == 0 {
+				slot := g*swissGroupSize + j
+				s.insertNew(oldKeys[slot], oldVals[slot], s.hashOf(oldKeys[slot]))
+			}
+		}
+	}
+}
+
+func (s *swissStorage[K, V]) hashOf(k K) uint64 {
+	return mix64(s.hash(k))
+}
+
+func ctrlByte(w uint64, j int) uint8 {
+	return uint8(w >> (8 * j))
+}
+
+func (s *swissStorage[K, V]) setCtrl(slot int, c uint8) {
+	g, j := slot/swissGroupSize, slot%swissGroupSize
+	s.ctrl[g] = s.ctrl[g]&^(0xFF<<(8*j)) | uint64(c)<<(8*j)
+}
+
+// matchH2 returns a mask with the high bit set in every byte equal to h2.
+// It can report rare false positives, which the key comparison filters out.
+func matchH2(w uint64, h2 uint8) uint64 {
+	x := w ^ (swissLSBs * uint64(h2))
+	return (x - swissLSBs) &^ x & swissMSBs
+}
+
+func matchEmpty(w uint64) uint64 {
+	return w & (^w << 6) & swissMSBs
+}
+
+func matchEmptyOrDeleted(w uint64) uint64 {
+	return w & swissMSBs
+}
+
+// find returns the slot holding k, or -1.
+func (s *swissStorage[K, V]) find(k K, h uint64) int {
+	mask := len(s.ctrl) - 1
+	h2 := uint8(h & 0x7F)
+	g := int(h>>7) & mask
+
+	for i := 1; ; i++ {
+		w := s.ctrl[g]
+		for m := matchH2(w, h2); m != 0; m &= m - 1 {
+			slot := g*swissGroupSize + bits.TrailingZeros64(m)/8
+			if s.keys[slot] == k {
+				return slot
+			}
+		}
+		if matchEmpty(w) != 0 {
+			return -1
+		}
+		g = (g + i) & mask // triangular probing visits every group
+	}
+}
+
+// insertNew places a key known to be absent. The caller guarantees a free slot.
+func (s *swissStorage[K, V]) insertNew(k K, v V, h uint64) {
+	mask := len(s.ctrl) - 1
+	g := int(h>>7) & mask
+
+	for i := 1; ; i++ {
+		if m := matchEmptyOrDeleted(s.ctrl[g]); m != 0 {
+			slot := g*swissGroupSize + bits.TrailingZeros64(m)/8
+			if ctrlByte(s.ctrl[g], slot%swissGroupSize) == swissDeleted {
+				s.deleted--
+			}
+			s.setCtrl(slot, uint8(h&0x7F))
+			s.keys[slot] = k
+			s.vals[slot] = v
+			s.used++
+			return
+		}
+		g = (g + i) & mask
+	}
+}
+
+func (s *swissStorage[K, V]) Get(k K) (V, bool) {
+	if slot := s.find(k, s.hashOf(k)); slot >= 0 {
+		return s.vals[slot], true
+	}
+	var zero V
+	return zero, false
+}
+
+func (s *swissStorage[K, V]) Set(k K, v V) {
+	h := s.hashOf(k)
+	if slot := s.find(k, h); slot >= 0 {
+		s.vals[slot] = v
+		return
+	}
+
+	// Keep the load factor (including tombstones) at or below 7/8.
+	capacity := len(s.ctrl) * swissGroupSize
+	if (s.used+s.deleted+1)*8 > capacity*7 {
+		groups := len(s.ctrl)
+		if (s.used+1)*2 > capacity {
+			groups *= 2 // genuinely full: grow
+		} // otherwise mostly tombstones: rehash in place
+		s.resize(groups)
+	}
+	s.insertNew(k, v, h)
+}
+
+func (s *swissStorage[K, V]) Delete(k K) {
+	slot := s.find(k, s.hashOf(k))
+	if slot < 0 {
+		return
+	}
+
+	var (
+		zeroK K
+		zeroV V
+	)
+	s.keys[slot], s.vals[slot] = zeroK, zeroV
+	s.used--
+
+	// A group that still has an empty slot never caused a probe to
+	// continue past it, so the slot can go straight back to empty.
+	if matchEmpty(s.ctrl[slot/swissGroupSize]) != 0 {
+		s.setCtrl(slot, swissEmpty)
+	} else {
+		s.setCtrl(slot, swissDeleted)
+		s.deleted++
+	}
+}
+
+func (s *swissStorage[K, V]) Len() int {
+	return s.used
+}
+
+func (s *swissStorage[K, V]) Range(f func(k K, v V) bool) {
+	for g, w := range s.ctrl {
+		for j := 0; j < swissGroupSize; j++ {
+			if ctrlByte(w, j)&0x80 != 0 {
+				continue
+			}
+			slot := g*swissGroupSize + j
+			if !f(s.keys[slot], s.vals[slot]) {
+				return
+			}
+		}
+	}
+}
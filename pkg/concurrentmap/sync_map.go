@@ -0,0 +1,178 @@
+package concurrentmap
+
+import (
+	"hash/maphash"
+	"sync"
+)
+
+const defaultSyncMapBuckets = 32
+
+// SyncMap is a drop-in replacement for sync.Map backed by a sharded
+// ConcurrentMap. Method signatures match sync.Map exactly and the zero
+// value is ready to use, so migrating is a one-line type change:
+//
+//	var m sync.Map              // before
+//	var m concurrentmap.SyncMap // after
+type SyncMap struct {
+	once sync.Once
+	m    *ConcurrentMap[any, any]
+}
+
+// NewSyncMap creates a SyncMap with a custom number of shards.
+// The zero value uses 32 shards.
+func NewSyncMap(numBuckets int) *SyncMap {
+	sm := &SyncMap{}
+	sm.once.Do(func() {
+		sm.m = New[any, any](numBuckets, hashAny)
+	})
+	return sm
+}
+
+func (sm *SyncMap) inner() *ConcurrentMap[any, any] {
+	sm.once.Do(func() {
+		sm.m = New[any, any](defaultSyncMapBuckets, hashAny)
+	})
+	return sm.m
+}
+
+// Load returns the value stored in the map for a key, or nil if no value is present.
+func (sm *SyncMap) Load(key any) (value any, ok bool) {
+	return sm.inner().Get(key)
+}
+
+// Store sets the value for a key.
+func (sm *SyncMap) Store(key, value any) {
+	sm.inner().Set(key, value)
+}
+
+// LoadOrStore returns the existing value for the key if present.
+// Otherwise, it stores and returns the given value.
+func (sm *SyncMap) LoadOrStore(key, value any) (actual any, loaded bool) {
+	return sm.inner().LoadOrStore(key, value)
+}
+
+// LoadAndDelete deletes the value for a key, returning the previous value if any.
+func (sm *SyncMap) LoadAndDelete(key any) (value any, loaded bool) {
+	sm.inner().Compute(key, func(old any, exists bool) (any, bool) {
+		value, loaded = old, exists
+		return nil, false
+	})
+	return value, loaded
+}
+
+// Delete deletes the value for a key.
+func (sm *SyncMap) Delete(key any) {
+	sm.inner().Delete(key)
+}
+
+// Swap swaps the value for a key and returns the previous value if any.
+func (sm *SyncMap) Swap(key, value any) (previous any, loaded bool) {
+	sm.inner().Compute(key, func(old any, exists bool) (any, bool) {
+		previous, loaded = old, exists
+		return value, true
+	})
+	return previous, loaded
+}
+
+// CompareAndSwap swaps the old and new values for key
+// if the value stored in the map is equal to old.
+func (sm *SyncMap) CompareAndSwap(key, old, new any) (swapped bool) {
+	sm.inner().Compute(key, func(cur any, exists bool) (any, bool) {
+		if !exists || cur != old {
+			return cur, exists
+		}
+		swapped = true
+		return new, true
+	})
+	return swapped
+}
+
+// CompareAndDelete deletes the entry for key if its value is equal to old.
+func (sm *SyncMap) CompareAndDelete(key, old any) (deleted bool) {
+	sm.inner().Compute(key, func(cur any, exists bool) (any, bool) {
+		if !exists || cur != old {
+			return cur, exists
+		}
+		deleted = true
+		return nil, false
+	})
+	return deleted
+}
+
+// Range calls f sequentially for each key and value present in the map.
+// If f returns false, range stops the iteration.
+//
+// As with sync.Map, f may call any method on the map: each shard is copied
+// before f runs on its entries, so no lock is held during the callback.
+func (sm *SyncMap) Range(f func(key, value any) bool) {
+	m := sm.inner()
+	for i := range m.buckets {
+		b := &m.buckets[i]
+
+		b.mu.RLock()
+		entries := make([][2]any, 0, b.m.Len())
+		b.m.Range(func(k, v any) bool {
+			entries = append(entries, [2]any{k, v})
+			return true
+		})
+		b.mu.RUnlock()
+
+		for _, e := range entries {
+			if !f(e[0], e[1]) {
+				return
+			}
+		}
+	}
+}
+
+// Clear deletes all the entries.
+func (sm *SyncMap) Clear() {
+	m := sm.inner()
+	var keys []any
+	m.Range(func(k, _ any) bool {
+		keys = append(keys, k)
+		return true
+	})
+	for _, k := range keys {
+		m.Delete(k)
+	}
+}
+
+// anySeed seeds hashAny's maphash, for the life of the process.
+var anySeed = maphash.MakeSeed()
+
+// hashAny hashes arbitrary comparable keys. Common key types take a
+// fast path; anything else goes through maphash.Comparable, which hashes
+// keys the way == compares them: +0 and -0 alike, pointers by address,
+// interfaces by dynamic type and value, without allocating.
+func hashAny(k any) uint64 {
+	switch v := k.(type) {
+	case string:
+		return fnv64a(v)
+	case int:
+		return mix64(uint64(v))
+	case int64:
+		return mix64(uint64(v))
+	case int32:
+		return mix64(uint64(v))
+	case uint:
+		return mix64(uint64(v))
+	case uint64:
+		return mix64(v)
+	case uint32:
+		return mix64(uint64(v))
+	default:
+		return maphash.Comparable(anySeed, k)
+	}
+}
+
+// mix64 is the splitmix64 finalizer; it spreads sequential integers
+// across buckets.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,93 @@
+package concurrentmap
+
+// Versioned pairs a value with its per-key version number.
+// Versions start at 1 on insert and grow by one on every write;
+// 0 is never a valid version and stands for "key absent".
+type Versioned[V any] struct {
+	Value   V
+	Version uint64
+}
+
+// VersionedMap is a ConcurrentMap whose entries carry a version that is
+// bumped on every write, enabling optimistic concurrency via SetIfVersion.
+type VersionedMap[K comparable, V any] struct {
+	m *ConcurrentMap[K, Versioned[V]]
+}
+
+// NewVersionedMap creates a new VersionedMap.
+func NewVersionedMap[K comparable, V any](numBuckets int, hasher Hasher[K]) *VersionedMap[K, V] {
+	return &VersionedMap[K, V]{
+		m: New[K, Versioned[V]](numBuckets, hasher),
+	}
+}
+
+// NewStringVersionedMap creates a versioned map with string keys.
+func NewStringVersionedMap[V any](numBuckets int) *VersionedMap[string, V] {
+	return NewVersionedMap[string, V](numBuckets, fnv64a)
+}
+
+// Get returns the value stored at k and its current version.
+func (vm *VersionedMap[K, V]) Get(k K) (V, uint64, bool) {
+	e, ok := vm.m.Get(k)
+	return e.Value, e.Version, ok
+}
+
+// Set stores v at k unconditionally and returns the new version.
+func (vm *VersionedMap[K, V]) Set(k K, v V) uint64 {
+	var version uint64
+
+	vm.m.Compute(k, func(old Versioned[V], exists bool) (Versioned[V], bool) {
+		version = old.Version + 1
+		return Versioned[V]{Value: v, Version: version}, true
+	})
+
+	return version
+}
+
+// SetIfVersion stores v at k only if the current version equals expected.
+// Pass expected = 0 to create the key only if it does not exist yet.
+// Returns the resulting version and whether the write happened; on a
+// mismatch the current version is returned so callers can retry.
+func (vm *VersionedMap[K, V]) SetIfVersion(k K, v V, expected uint64) (uint64, bool) {
+	var (
+		version uint64
+		swapped bool
+	)
+
+	vm.m.Compute(k, func(old Versioned[V], exists bool) (Versioned[V], bool) {
+		if old.Version != expected {
+			version = old.Version
+			return old, exists
+		}
+		version = old.Version + 1
+		swapped = true
+		return Versioned[V]{Value: v, Version: version}, true
+	})
+
+	return version, swapped
+}
+
+// Delete removes k regardless of its version.
+func (vm *VersionedMap[K, V]) Delete(k K) {
+	vm.m.Delete(k)
+}
+
+// DeleteIfVersion removes k only if its current version equals expected.
+func (vm *VersionedMap[K, V]) DeleteIfVersion(k K, expected uint64) bool {
+	deleted := false
+
+	vm.m.Compute(k, func(old Versioned[V], exists bool) (Versioned[V], bool) {
+		if !exists || old.Version != expected {
+			return old, exists
+		}
+		deleted = true
+		return old, false
+	})
+
+	return deleted
+}
+
+// Len returns the number of entries.
+func (vm *VersionedMap[K, V]) Len() int {
+	return vm.m.Len()
+}
@@ -0,0 +1,248 @@
+package concurrentmap
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// EventType identifies the kind of mutation an Event describes.
+type EventType int
+
+const (
+	EventInsert EventType = iota + 1 // key did not exist and was created
+	EventUpdate                      // existing key was overwritten
+	EventDelete                      // key was removed explicitly
+	EventExpire                      // key was removed because it expired
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventInsert:
+		return "insert"
+	case EventUpdate:
+		return "update"
+	case EventDelete:
+		return "delete"
+	case EventExpire:
+		return "expire"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes a single mutation of a key.
+// OldValue is the zero value for inserts, NewValue for deletes/expires.
+type Event[K comparable, V any] struct {
+	Type     EventType
+	Key      K
+	OldValue V
+	NewValue V
+}
+
+// SlowConsumerPolicy decides what happens when a watcher's buffer is full.
+// Events are published while the key's bucket lock is held, so the map
+// never blocks on a slow reader; one of these policies applies instead.
+type SlowConsumerPolicy int
+
+const (
+	DropNewest SlowConsumerPolicy = iota // discard the event that didn't fit
+	DropOldest                           // discard the oldest buffered event to make room
+	CloseSlow                            // close the channel; the consumer must re-watch
+)
+
+// WatchOption configures a watcher.
+type WatchOption func(*watchConfig)
+
+type watchConfig struct {
+	buffer int
+	policy SlowConsumerPolicy
+}
+
+// WithBuffer sets the watcher's channel capacity (default 64).
+func WithBuffer(n int) WatchOption {
+	return func(c *watchConfig) {
+		if n > 0 {
+			c.buffer = n
+		}
+	}
+}
+
+// WithSlowConsumerPolicy sets what to do when the buffer is full (default DropNewest).
+func WithSlowConsumerPolicy(p SlowConsumerPolicy) WatchOption {
+	return func(c *watchConfig) {
+		c.policy = p
+	}
+}
+
+// ----------- Watch API -----------
+
+// Watch delivers events for key k until ctx is done, then closes the channel.
+func (cm *ConcurrentMap[K, V]) Watch(ctx context.Context, k K, opts ...WatchOption) <-chan Event[K, V] {
+	return cm.WatchFunc(ctx, func(key K) bool { return key == k }, opts...)
+}
+
+// WatchFunc delivers events for every key accepted by match until ctx is
+// done, then closes the channel. match runs under a bucket lock and must
+// not call back into the map.
+func (cm *ConcurrentMap[K, V]) WatchFunc(ctx context.Context, match func(K) bool, opts ...WatchOption) <-chan Event[K, V] {
+	cfg := watchConfig{buffer: 64, policy: DropNewest}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	w := &watcher[K, V]{
+		match:  match,
+		ch:     make(chan Event[K, V], cfg.buffer),
+		policy: cfg.policy,
+	}
+	cm.watchers.add(w)
+
+	go func() {
+		<-ctx.Done()
+		cm.watchers.remove(w)
+	}()
+
+	return w.ch
+}
+
+// WatchPrefix delivers events for every key starting with prefix.
+func WatchPrefix[V any](ctx context.Context, cm *ConcurrentMap[string, V], prefix string, opts ...WatchOption) <-chan Event[string, V] {
+	return cm.WatchFunc(ctx, func(key string) bool {
+		return strings.HasPrefix(key, prefix)
+	}, opts...)
+}
+
+// ExpireIf removes k if expired reports true for its current value and
+// publishes an EventExpire instead of EventDelete. Checking and removing
+// under one lock means a concurrent Set of a fresh value is never lost.
+func (cm *ConcurrentMap[K, V]) ExpireIf(k K, expired func(v V) bool) bool {
+	idx := cm.bucketIndexForKey(k)
+	b := &cm.buckets[idx]
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	old, ok := b.m.Get(k)
+	if !ok || !expired(old) || cm.deleteThrough(k) != nil {
+		return false
+	}
+
+	b.m.Delete(k)
+	cm.emit(EventExpire, k, old, old)
+	return true
+}
+
+// emit publishes a mutation to subscribers and watchers. Callers hold
+// k's bucket lock, which keeps events for a single key in commit order.
+func (cm *ConcurrentMap[K, V]) emit(typ EventType, k K, oldV, newV V) {
+	if cm.watchers.active.Load() == 0 {
+		return
+	}
+	cm.watchers.publish(Event[K, V]{Type: typ, Key: k, OldValue: oldV, NewValue: newV})
+}
+
+// ----------- Watcher Registry -----------
+
+type watcher[K comparable, V any] struct {
+	match  func(K) bool
+	ch     chan Event[K, V]
+	policy SlowConsumerPolicy
+	closed atomic.Bool
+}
+
+type subscriber[K comparable, V any] struct {
+	fn func(Event[K, V])
+}
+
+type watchHub[K comparable, V any] struct {
+	mu       sync.RWMutex
+	active   atomic.Int32 // watchers + subscribers; lets quiet maps skip the lock
+	watchers map[*watcher[K, V]]struct{}
+	subs     map[*subscriber[K, V]]struct{}
+}
+
+func (h *watchHub[K, V]) add(w *watcher[K, V]) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.watchers == nil {
+		h.watchers = make(map[*watcher[K, V]]struct{})
+	}
+	h.watchers[w] = struct{}{}
+	h.active.Add(1)
+}
+
+func (h *watchHub[K, V]) remove(w *watcher[K, V]) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.watchers[w]; !ok {
+		return
+	}
+	delete(h.watchers, w)
+	h.active.Add(-1)
+	w.closed.Store(true)
+	close(w.ch)
+}
+
+func (h *watchHub[K, V]) subscribe(s *subscriber[K, V]) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.subs == nil {
+		h.subs = make(map[*subscriber[K, V]]struct{})
+	}
+	h.subs[s] = struct{}{}
+	h.active.Add(1)
+}
+
+func (h *watchHub[K, V]) unsubscribe(s *subscriber[K, V]) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subs[s]; !ok {
+		return
+	}
+	delete(h.subs, s)
+	h.active.Add(-1)
+}
+
+func (h *watchHub[K, V]) publish(ev Event[K, V]) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for s := range h.subs {
+		s.fn(ev)
+	}
+
+	for w := range h.watchers {
+		if w.closed.Load() || !w.match(ev.Key) {
+			continue
+		}
+
+		select {
+		case w.ch <- ev:
+			continue
+		default:
+		}
+
+		switch w.policy {
+		case DropOldest:
+			select {
+			case <-w.ch:
+			default:
+			}
+			select {
+			case w.ch <- ev:
+			default:
+			}
+		case CloseSlow:
+			// Can't close under the read lock; stop delivering now and
+			// let remove() close the channel.
+			w.closed.Store(true)
+			go h.remove(w)
+		}
+	}
+}
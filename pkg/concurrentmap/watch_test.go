@@ -0,0 +1,107 @@
+package concurrentmap
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func nextEvent[K comparable, V any](t *testing.T, ch <-chan Event[K, V]) Event[K, V] {
+	t.Helper()
+	select {
+	case ev, ok := <-ch:
+		if !ok {
+			t.Fatalf("watch channel closed unexpectedly")
+		}
+		return ev
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for event")
+	}
+	panic("unreachable")
+}
+
+func TestWatchKey(t *testing.T) {
+	m := NewStringMap[int](16)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch := m.Watch(ctx, "a")
+
+	m.Set("b", 0) // not watched
+	m.Set("a", 1)
+	m.Set("a", 2)
+	m.Delete("a")
+	m.Set("a", 3)
+	m.ExpireIf("a", func(v int) bool { return v == 3 })
+
+	want := []EventType{EventInsert, EventUpdate, EventDelete, EventInsert, EventExpire}
+	for _, typ := range want {
+		if ev := nextEvent(t, ch); ev.Type != typ || ev.Key != "a" {
+			t.Fatalf("expected %v on a, got %v on %s", typ, ev.Type, ev.Key)
+		}
+	}
+
+	cancel()
+	if _, ok := <-ch; ok {
+		t.Fatalf("expected channel to close after cancel")
+	}
+}
+
+func TestWatchPrefixAndSlowConsumer(t *testing.T) {
+	m := NewStringMap[int](16)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	jobs := WatchPrefix(ctx, m, "job:", WithBuffer(2), WithSlowConsumerPolicy(DropOldest))
+	slow := WatchPrefix(ctx, m, "job:", WithBuffer(1), WithSlowConsumerPolicy(CloseSlow))
+
+	for i := 1; i <= 3; i++ {
+		m.Set("job:x", i)
+	}
+	m.Set("other", 1)
+
+	if ev := nextEvent(t, jobs); ev.NewValue != 2 {
+		t.Fatalf("expected oldest event to be dropped, got value %d", ev.NewValue)
+	}
+	if ev := nextEvent(t, jobs); ev.NewValue != 3 {
+		t.Fatalf("expected latest value 3, got %d", ev.NewValue)
+	}
+
+	<-slow // the one buffered event
+	select {
+	case _, ok := <-slow:
+		if ok {
+			t.Fatalf("expected slow watcher to be closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("slow watcher was not closed")
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	m := NewStringMap[int](16)
+
+	var events []Event[string, int]
+	unsubscribe := m.Subscribe(func(ev Event[string, int]) {
+		events = append(events, ev)
+	})
+
+	m.Set("a", 1)
+	m.Compute("a", func(old int, _ bool) (int, bool) { return old + 1, true })
+	m.LoadOrStore("b", 5)
+	m.Delete("b")
+	m.Delete("missing") // no event for absent keys
+
+	unsubscribe()
+	m.Set("a", 100)
+
+	if len(events) != 4 {
+		t.Fatalf("expected 4 events, got %d: %v", len(events), events)
+	}
+	if ev := events[1]; ev.Type != EventUpdate || ev.OldValue != 1 || ev.NewValue != 2 {
+		t.Fatalf("unexpected compute event %+v", ev)
+	}
+	if ev := events[3]; ev.Type != EventDelete || ev.Key != "b" || ev.OldValue != 5 {
+		t.Fatalf("unexpected delete event %+v", ev)
+	}
+}
@@ -0,0 +1,217 @@
+package concurrentmap
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Change is the latest state of a dirty key handed to a write-behind sink.
+// Deleted is true when the key was removed (deleted or expired).
+type Change[K comparable, V any] struct {
+	Key     K
+	Value   V
+	Deleted bool
+}
+
+// WriteBehindConfig controls when and how dirty entries are flushed.
+type WriteBehindConfig struct {
+	FlushInterval time.Duration // flush at least this often (default 1s)
+	MaxBatch      int           // flush early once this many keys are dirty (default 1000)
+	MaxRetries    int           // extra attempts per batch (default 3, negative = none)
+	RetryBackoff  time.Duration // wait before the first retry, doubled each time (default 100ms)
+}
+
+// WriteBehind batches mutations of a ConcurrentMap and flushes them to a
+// sink asynchronously. Repeated writes to a key between flushes are
+// coalesced so the sink only sees the latest state.
+type WriteBehind[K comparable, V any] struct {
+	sink func([]Change[K, V]) error
+	cfg  WriteBehindConfig
+
+	mu    sync.Mutex
+	dirty map[K]Change[K, V]
+
+	// flushMu serializes flushes, so batches reach the sink one at a
+	// time, in the order they were taken, and a failed batch is put back
+	// before the next is taken.
+	flushMu sync.Mutex
+
+	kick        chan struct{}
+	stop        chan struct{}
+	done        chan struct{}
+	unsubscribe func()
+
+	flushed atomic.Int64
+	failed  atomic.Int64
+}
+
+// NewWriteBehind subscribes to cm and starts flushing its changes to sink.
+// Call Drain on shutdown to flush whatever is still pending.
+func NewWriteBehind[K comparable, V any](cm *ConcurrentMap[K, V], sink func([]Change[K, V]) error, cfg WriteBehindConfig) *WriteBehind[K, V] {
+	if sink == nil {
+		panic("sink must not be nil")
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+	if cfg.MaxBatch <= 0 {
+		cfg.MaxBatch = 1000
+	}
+	if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	} else if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = 3
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = 100 * time.Millisecond
+	}
+
+	wb := &WriteBehind[K, V]{
+		sink:  sink,
+		cfg:   cfg,
+		dirty: make(map[K]Change[K, V]),
+		kick:  make(chan struct{}, 1),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	wb.unsubscribe = cm.Subscribe(wb.record)
+
+	go wb.run()
+	return wb
+}
+
+// record is the map subscriber; it runs under a bucket lock so it only
+// marks the key dirty and nudges the flusher.
+func (wb *WriteBehind[K, V]) record(ev Event[K, V]) {
+	c := Change[K, V]{Key: ev.Key, Value: ev.NewValue}
+	if ev.Type == EventDelete || ev.Type == EventExpire {
+		var zero V
+		c.Value, c.Deleted = zero, true
+	}
+
+	wb.mu.Lock()
+	wb.dirty[ev.Key] = c
+	full := len(wb.dirty) >= wb.cfg.MaxBatch
+	wb.mu.Unlock()
+
+	if full {
+		select {
+		case wb.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (wb *WriteBehind[K, V]) run() {
+	defer close(wb.done)
+
+	ticker := time.NewTicker(wb.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			wb.Flush()
+		case <-wb.kick:
+			wb.Flush()
+		case <-wb.stop:
+			return
+		}
+	}
+}
+
+// Flush writes all currently dirty entries to the sink, retrying with
+// exponential backoff. Entries from a batch that still fails are put
+// back (unless overwritten meanwhile) and the error is returned. Flushes
+// run one at a time, whether called directly or from the background
+// flusher, so the sink never sees a key's older state after a newer one.
+func (wb *WriteBehind[K, V]) Flush() error {
+	wb.flushMu.Lock()
+	defer wb.flushMu.Unlock()
+
+	wb.mu.Lock()
+	if len(wb.dirty) == 0 {
+		wb.mu.Unlock()
+		return nil
+	}
+	pending := wb.dirty
+	wb.dirty = make(map[K]Change[K, V], len(pending))
+	wb.mu.Unlock()
+
+	batch := make([]Change[K, V], 0, len(pending))
+	for _, c := range pending {
+		batch = append(batch, c)
+	}
+
+	var err error
+	backoff := wb.cfg.RetryBackoff
+	for attempt := 0; attempt <= wb.cfg.MaxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if err = wb.sink(batch); err == nil {
+			wb.flushed.Add(int64(len(batch)))
+			return nil
+		}
+	}
+
+	wb.failed.Add(1)
+
+	wb.mu.Lock()
+	for k, c := range pending {
+		if _, newer := wb.dirty[k]; !newer {
+			wb.dirty[k] = c
+		}
+	}
+	wb.mu.Unlock()
+
+	return err
+}
+
+// Pending returns the number of dirty keys waiting to be flushed.
+func (wb *WriteBehind[K, V]) Pending() int {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	return len(wb.dirty)
+}
+
+// Flushed returns the total number of changes delivered to the sink.
+func (wb *WriteBehind[K, V]) Flushed() int64 {
+	return wb.flushed.Load()
+}
+
+// FailedBatches returns how many batches were given up on after retries.
+func (wb *WriteBehind[K, V]) FailedBatches() int64 {
+	return wb.failed.Load()
+}
+
+// Drain stops tracking new mutations, stops the background flusher and
+// flushes everything still pending. It returns early if ctx is done.
+func (wb *WriteBehind[K, V]) Drain(ctx context.Context) error {
+	wb.unsubscribe()
+
+	select {
+	case <-wb.stop:
+	default:
+		close(wb.stop)
+	}
+
+	select {
+	case <-wb.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	for wb.Pending() > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := wb.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
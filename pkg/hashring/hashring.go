@@ -0,0 +1,128 @@
+// Package hashring implements a consistent hash ring with virtual nodes,
+// for spreading keys across several kv-server instances client-side.
+//
+// Each node is placed on the ring at Replicas points; a key belongs to the
+// first point at or after its hash. Adding or removing a node only moves
+// the keys adjacent to that node's points, roughly 1/N of the total.
+package hashring
+
+import (
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/shubhamc1947/safemap/pkg/concurrentmap"
+)
+
+// DefaultReplicas is the number of virtual nodes per node used when New
+// is given a non-positive count.
+const DefaultReplicas = 160
+
+// Ring is a consistent hash ring. It is safe for concurrent use.
+type Ring struct {
+	hash     func(string) uint64
+	replicas int
+
+	mu     sync.RWMutex
+	points []uint64          // sorted virtual node hashes
+	owners map[uint64]string // virtual node hash → node
+	nodes  map[string]struct{}
+}
+
+// New creates an empty ring. A nil hash defaults to
+// concurrentmap.XXHash64; clients and servers must agree on both the hash
+// and the replica count for keys to map to the same node.
+func New(replicas int, hash func(string) uint64) *Ring {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	if hash == nil {
+		hash = concurrentmap.XXHash64
+	}
+	return &Ring{
+		hash:     hash,
+		replicas: replicas,
+		owners:   make(map[uint64]string),
+		nodes:    make(map[string]struct{}),
+	}
+}
+
+// Add places nodes on the ring. Nodes already present are ignored.
+func (r *Ring) Add(nodes ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, node := range nodes {
+		if _, ok := r.nodes[node]; ok {
+			continue
+		}
+		r.nodes[node] = struct{}{}
+
+		for i := 0; i < r.replicas; i++ {
+			h := r.hash(strconv.Itoa(i) + "#" + node)
+			if _, taken := r.owners[h]; taken {
+				continue // hash collision: the earlier node keeps the point
+			}
+			r.owners[h] = node
+			r.points = append(r.points, h)
+		}
+	}
+	slices.Sort(r.points)
+}
+
+// Remove takes node off the ring and reports whether it was present.
+func (r *Ring) Remove(node string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.nodes[node]; !ok {
+		return false
+	}
+	delete(r.nodes, node)
+
+	r.points = slices.DeleteFunc(r.points, func(h uint64) bool {
+		if r.owners[h] != node {
+			return false
+		}
+		delete(r.owners, h)
+		return true
+	})
+	return true
+}
+
+// NodeFor returns the node owning key, or false if the ring is empty.
+func (r *Ring) NodeFor(key string) (string, bool) {
+	h := r.hash(key)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.points) == 0 {
+		return "", false
+	}
+	i, _ := slices.BinarySearch(r.points, h)
+	if i == len(r.points) {
+		i = 0 // wrap around
+	}
+	return r.owners[r.points[i]], true
+}
+
+// Nodes returns the nodes on the ring in sorted order.
+func (r *Ring) Nodes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	nodes := make([]string, 0, len(r.nodes))
+	for n := range r.nodes {
+		nodes = append(nodes, n)
+	}
+	slices.Sort(nodes)
+	return nodes
+}
+
+// Len returns the number of nodes on the ring.
+func (r *Ring) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.nodes)
+}
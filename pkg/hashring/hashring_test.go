@@ -0,0 +1,59 @@
+package hashring
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/shubhamc1947/safemap/pkg/concurrentmap"
+)
+
+func TestRingDistributionAndStability(t *testing.T) {
+	r := New(0, nil)
+	if _, ok := r.NodeFor("k"); ok {
+		t.Fatalf("expected empty ring to have no owner")
+	}
+	r.Add("a", "b", "c", "d")
+
+	const keys = 20000
+	before := make(map[string]string, keys)
+	counts := make(map[string]int)
+	for i := 0; i < keys; i++ {
+		k := "key-" + strconv.Itoa(i)
+		n, _ := r.NodeFor(k)
+		before[k] = n
+		counts[n]++
+	}
+	for node, c := range counts {
+		if c < keys/4/2 || c > keys/4*2 {
+			t.Fatalf("node %s owns %d keys, expected roughly %d", node, c, keys/4)
+		}
+	}
+
+	// Removing a node may only move the keys it owned.
+	r.Remove("c")
+	for k, old := range before {
+		n, _ := r.NodeFor(k)
+		if old != "c" && n != old {
+			t.Fatalf("key %s moved from %s to %s although %s stayed", k, old, n, old)
+		}
+		if n == "c" {
+			t.Fatalf("key %s still maps to removed node", k)
+		}
+	}
+}
+
+func TestRingCustomHasherIsDeterministic(t *testing.T) {
+	r1 := New(50, concurrentmap.FNV64a)
+	r2 := New(50, concurrentmap.FNV64a)
+	r1.Add("x", "y", "z")
+	r2.Add("z", "x", "y")
+
+	for i := 0; i < 1000; i++ {
+		k := strconv.Itoa(i)
+		n1, _ := r1.NodeFor(k)
+		n2, _ := r2.NodeFor(k)
+		if n1 != n2 {
+			t.Fatalf("insertion order changed owner of %s: %s vs %s", k, n1, n2)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+// Package jsondoc reads and edits JSON documents decoded by encoding/json
+// into an any: map[string]any, []any, string, float64 or json.Number,
+// bool and nil.
+//
+// Paths are the subset of JSONPath (RFC 9535) that names a single node:
+// $ followed by .name, ['name'] or ["name"] and [index] steps, with
+// negative indexes counting from the end of an array. Patches follow JSON
+// Merge Patch (RFC 7396).
+package jsondoc
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidPath reports a path that does not parse.
+	ErrInvalidPath = errors.New("jsondoc: invalid path")
+	// ErrNotFound reports a path naming no node of the document.
+	ErrNotFound = errors.New("jsondoc: path not found")
+	// ErrConflict reports a Set through a value of the wrong type, or at
+	// an array index past the end.
+	ErrConflict = errors.New("jsondoc: path conflicts with the document")
+)
+
+// A Step selects a member of an object by Name or, if IsIndex is set, an
+// element of an array by Index.
+type Step struct {
+	Name    string
+	Index   int
+	IsIndex bool
+}
+
+// A Path is a sequence of steps from the root of a document; the empty
+// path is the root itself.
+type Path []Step
+
+// ParsePath parses a path such as $.user.name or $['a b'][0]. An empty
+// string is the root, like "$".
+func ParsePath(s string) (Path, error) {
+	if s == "" || s == "$" {
+		return nil, nil
+	}
+	if s[0] != '$' {
+		return nil, fmt.Errorf("%w %q: must start with $", ErrInvalidPath, s)
+	}
+	var p Path
+	for i := 1; i < len(s); {
+		switch s[i] {
+		case '.':
+			j := i + 1
+			for j < len(s) && s[j] != '.' && s[j] != '[' {
+				j++
+			}
+			name := s[i+1 : j]
+			if name == "" || name == "*" {
+				return nil, fmt.Errorf("%w %q: expected a member name at offset %d", ErrInvalidPath, s, i+1)
+			}
+			p = append(p, Step{Name: name})
+			i = j
+		case '[':
+			step, n, err := parseBracket(s[i:])
+			if err != nil {
+				return nil, fmt.Errorf("%w %q: %v at offset %d", ErrInvalidPath, s, err, i)
+			}
+			p = append(p, step)
+			i += n
+		default:
+			return nil, fmt.Errorf("%w %q: unexpected %q at offset %d", ErrInvalidPath, s, s[i], i)
+		}
+	}
+	return p, nil
+}
+
+// parseBracket parses a [...] step at the start of s and returns its
+// length.
+func parseBracket(s string) (Step, int, error) {
+	if len(s) > 1 && (s[1] == '\'' || s[1] == '"') {
+		quote := s[1]
+		var name strings.Builder
+		for i := 2; i < len(s); i++ {
+			switch c := s[i]; {
+			case c == '\\' && i+1 < len(s):
+				i++
+				name.WriteByte(s[i])
+			case c == quote:
+				if i+1 >= len(s) || s[i+1] != ']' {
+					return Step{}, 0, errors.New("expected ] after the name")
+				}
+				return Step{Name: name.String()}, i + 2, nil
+			default:
+				name.WriteByte(c)
+			}
+		}
+		return Step{}, 0, errors.New("unterminated name")
+	}
+	end := strings.IndexByte(s, ']')
+	if end < 0 {
+		return Step{}, 0, errors.New("unterminated [")
+	}
+	index, err := strconv.Atoi(s[1:end])
+	if err != nil {
+		return Step{}, 0, fmt.Errorf("%q is not an array index", s[1:end])
+	}
+	return Step{Index: index, IsIndex: true}, end + 1, nil
+}
+
+// String returns p in the form ParsePath reads, with names bracketed
+// where they would not parse after a dot.
+func (p Path) String() string {
+	var b strings.Builder
+	b.WriteByte('$')
+	for _, step := range p {
+		switch {
+		case step.IsIndex:
+			b.WriteString("[" + strconv.Itoa(step.Index) + "]")
+		case step.Name == "" || step.Name == "*" || strings.ContainsAny(step.Name, ".[]'\"\\"):
+			b.WriteString("['" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(step.Name) + "']")
+		default:
+			b.WriteString("." + step.Name)
+		}
+	}
+	return b.String()
+}
+
+// Get returns the node of doc at p.
+func Get(doc any, p Path) (any, error) {
+	node := doc
+	for i, step := range p {
+		var ok bool
+		if node, ok = child(node, step); !ok {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, p[:i+1])
+		}
+	}
+	return node, nil
+}
+
+func child(node any, step Step) (any, bool) {
+	if step.IsIndex {
+		arr, ok := node.([]any)
+		if !ok {
+			return nil, false
+		}
+		i, ok := index(step.Index, len(arr))
+		if !ok || i == len(arr) {
+			return nil, false
+		}
+		return arr[i], true
+	}
+	obj, ok := node.(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	v, ok := obj[step.Name]
+	return v, ok
+}
+
+// index resolves a possibly negative index into an array of length n; n
+// itself is in range, as the position to append at.
+func index(i, n int) (int, bool) {
+	if i < 0 {
+		i += n
+	}
+	return i, i >= 0 && i <= n
+}
+
+// Set stores v at p in doc and returns the new document, which is v
+// itself for the root path. Objects are changed in place. Missing members
+// along the way are created as objects, or as arrays for an index step;
+// an index one past the end of an array appends to it.
+func Set(doc any, p Path, v any) (any, error) {
+	return set(doc, p, 0, v)
+}
+
+func set(node any, p Path, depth int, v any) (any, error) {
+	if depth == len(p) {
+		return v, nil
+	}
+	step := p[depth]
+	if step.IsIndex {
+		arr, ok := node.([]any)
+		if node == nil {
+			arr, ok = []any{}, true
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: %s is not an array", ErrConflict, p[:depth])
+		}
+		i, ok := index(step.Index, len(arr))
+		if !ok {
+			return nil, fmt.Errorf("%w: %s is past the end of the array", ErrConflict, p[:depth+1])
+		}
+		var cur any
+		if i < len(arr) {
+			cur = arr[i]
+		}
+		next, err := set(cur, p, depth+1, v)
+		if err != nil {
+			return nil, err
+		}
+		if i == len(arr) {
+			return append(arr, next), nil
+		}
+		arr[i] = next
+		return arr, nil
+	}
+
+	obj, ok := node.(map[string]any)
+	if node == nil {
+		obj, ok = map[string]any{}, true
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is not an object", ErrConflict, p[:depth])
+	}
+	next, err := set(obj[step.Name], p, depth+1, v)
+	if err != nil {
+		return nil, err
+	}
+	obj[step.Name] = next
+	return obj, nil
+}
+
+// MergePatch applies patch to target as RFC 7396 describes and returns
+// the result: object members of patch are merged in recursively, null
+// ones removing the member, and any other patch replaces target. Objects
+// of target are changed in place.
+func MergePatch(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = make(map[string]any, len(p))
+	}
+	for name, v := range p {
+		if v == nil {
+			delete(t, name)
+			continue
+		}
+		t[name] = MergePatch(t[name], v)
+	}
+	return t
+}
@@ -0,0 +1,154 @@
+package jsondoc
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func decode(t *testing.T, s string) any {
+	t.Helper()
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("decode %s: %v", s, err)
+	}
+	return v
+}
+
+func encode(t *testing.T, v any) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	return string(b)
+}
+
+func TestParsePath(t *testing.T) {
+	cases := []struct {
+		in   string
+		want Path
+	}{
+		{"", nil},
+		{"$", nil},
+		{"$.user.name", Path{{Name: "user"}, {Name: "name"}}},
+		{"$.items[0]", Path{{Name: "items"}, {Index: 0, IsIndex: true}}},
+		{"$[-1]", Path{{Index: -1, IsIndex: true}}},
+		{`$['a b']["c.d"]`, Path{{Name: "a b"}, {Name: "c.d"}}},
+		{`$['it\'s']`, Path{{Name: "it's"}}},
+	}
+	for _, tc := range cases {
+		got, err := ParsePath(tc.in)
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("ParsePath(%q) = %v, %v; want %v", tc.in, got, err, tc.want)
+		}
+	}
+
+	for _, in := range []string{"user", "$.", "$..a", "$.*", "$[", "$[x]", "$['a'", "$['a'x", "$a"} {
+		if _, err := ParsePath(in); !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("ParsePath(%q) error = %v, want ErrInvalidPath", in, err)
+		}
+	}
+}
+
+func TestPathStringRoundTrip(t *testing.T) {
+	for _, in := range []string{"$", "$.a[2].b", `$['a.b'][-1]['it\'s']`, "$['']"} {
+		p, err := ParsePath(in)
+		if err != nil {
+			t.Fatalf("ParsePath(%q): %v", in, err)
+		}
+		if got := p.String(); got != in {
+			t.Errorf("String() = %q, want %q", got, in)
+		}
+	}
+}
+
+func TestGet(t *testing.T) {
+	doc := decode(t, `{"user": {"name": "ann", "tags": ["a", "b", "c"]}, "n": null}`)
+	cases := []struct {
+		path, want string
+	}{
+		{"$", encode(t, doc)},
+		{"$.user.name", `"ann"`},
+		{"$.user.tags[1]", `"b"`},
+		{"$.user.tags[-1]", `"c"`},
+		{"$.n", `null`},
+	}
+	for _, tc := range cases {
+		p, _ := ParsePath(tc.path)
+		got, err := Get(doc, p)
+		if err != nil || encode(t, got) != tc.want {
+			t.Errorf("Get(%s) = %s, %v; want %s", tc.path, encode(t, got), err, tc.want)
+		}
+	}
+
+	for _, path := range []string{"$.missing", "$.user.tags[3]", "$.user.tags[-4]", "$.user.name.first", "$.user[0]"} {
+		p, _ := ParsePath(path)
+		if _, err := Get(doc, p); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(%s) error = %v, want ErrNotFound", path, err)
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	cases := []struct {
+		doc, path, value, want string
+	}{
+		{`{"a": 1}`, "$", `[1]`, `[1]`},
+		{`{"a": 1}`, "$.a", `2`, `{"a":2}`},
+		{`{"a": 1}`, "$.b.c", `true`, `{"a":1,"b":{"c":true}}`},
+		{`{"a": [1, 2]}`, "$.a[0]", `0`, `{"a":[0,2]}`},
+		{`{"a": [1, 2]}`, "$.a[-1]", `0`, `{"a":[1,0]}`},
+		{`{"a": [1, 2]}`, "$.a[2]", `3`, `{"a":[1,2,3]}`},
+		{`{}`, "$.a[0].b", `"x"`, `{"a":[{"b":"x"}]}`},
+		{`null`, "$.a", `1`, `{"a":1}`},
+	}
+	for _, tc := range cases {
+		p, _ := ParsePath(tc.path)
+		got, err := Set(decode(t, tc.doc), p, decode(t, tc.value))
+		if err != nil || encode(t, got) != tc.want {
+			t.Errorf("Set(%s, %s, %s) = %s, %v; want %s", tc.doc, tc.path, tc.value, encode(t, got), err, tc.want)
+		}
+	}
+
+	for _, tc := range []struct{ doc, path string }{
+		{`{"a": 1}`, "$.a.b"},
+		{`{"a": {}}`, "$.a[0]"},
+		{`{"a": [1]}`, "$.a.b"},
+		{`{"a": [1]}`, "$.a[2]"},
+		{`{"a": [1]}`, "$.a[-2]"},
+	} {
+		p, _ := ParsePath(tc.path)
+		if _, err := Set(decode(t, tc.doc), p, 1.0); !errors.Is(err, ErrConflict) {
+			t.Errorf("Set(%s, %s) error = %v, want ErrConflict", tc.doc, tc.path, err)
+		}
+	}
+}
+
+// The examples of RFC 7396, Appendix A.
+func TestMergePatch(t *testing.T) {
+	cases := []struct{ target, patch, want string }{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tc := range cases {
+		got := MergePatch(decode(t, tc.target), decode(t, tc.patch))
+		if encode(t, got) != tc.want {
+			t.Errorf("MergePatch(%s, %s) = %s, want %s", tc.target, tc.patch, encode(t, got), tc.want)
+		}
+	}
+}
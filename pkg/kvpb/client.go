@@ -0,0 +1,204 @@
+package kvpb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ----------- Client -----------
+
+// maxResponse caps a response message.
+const maxResponse = 64 << 20
+
+// Client calls the KV service. The zero value is not usable; set URL.
+type Client struct {
+	// URL is the server's --grpc-addr: http://host:port for HTTP/2
+	// without TLS (h2c), https://host:port over TLS.
+	URL string
+	// APIKey, if set, is sent as "authorization: Bearer <key>".
+	APIKey string
+	// HTTPClient must speak HTTP/2; nil uses a client that does, with or
+	// without TLS.
+	HTTPClient *http.Client
+}
+
+// NewClient returns a client of the server at url, authenticating with
+// apiKey when it is set.
+func NewClient(url, apiKey string) *Client {
+	return &Client{URL: url, APIKey: apiKey}
+}
+
+var defaultHTTPClient = func() *http.Client {
+	var protocols http.Protocols
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+	return &http.Client{Transport: &http.Transport{Protocols: &protocols, ForceAttemptHTTP2: true}}
+}()
+
+func (c *Client) Get(ctx context.Context, req *GetRequest) (*GetResponse, error) {
+	resp := new(GetResponse)
+	return resp, c.unary(ctx, MethodGet, req, resp)
+}
+
+func (c *Client) Put(ctx context.Context, req *PutRequest) (*PutResponse, error) {
+	resp := new(PutResponse)
+	return resp, c.unary(ctx, MethodPut, req, resp)
+}
+
+func (c *Client) Delete(ctx context.Context, req *DeleteRequest) (*DeleteResponse, error) {
+	resp := new(DeleteResponse)
+	return resp, c.unary(ctx, MethodDelete, req, resp)
+}
+
+func (c *Client) BatchOps(ctx context.Context, req *BatchRequest) (*BatchResponse, error) {
+	resp := new(BatchResponse)
+	return resp, c.unary(ctx, MethodBatchOps, req, resp)
+}
+
+func (c *Client) Txn(ctx context.Context, req *TxnRequest) (*TxnResponse, error) {
+	resp := new(TxnResponse)
+	return resp, c.unary(ctx, MethodTxn, req, resp)
+}
+
+// Watch starts a watch. Cancel ctx, or call Close, to end it.
+func (c *Client) Watch(ctx context.Context, req *WatchRequest) (*WatchStream, error) {
+	body, err := c.call(ctx, MethodWatch, req)
+	if err != nil {
+		return nil, err
+	}
+	return &WatchStream{body: body}, nil
+}
+
+// WatchStream receives the events of a watch.
+type WatchStream struct {
+	body *http.Response
+}
+
+// Recv returns the next event. When the server ends the watch, it
+// returns io.EOF, or the *Status the watch failed with.
+func (ws *WatchStream) Recv() (*WatchEvent, error) {
+	msg, err := ReadFrame(ws.body.Body, maxResponse)
+	if err == io.EOF {
+		if err := status(ws.body); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	ev := new(WatchEvent)
+	if err := ev.Unmarshal(msg); err != nil {
+		return nil, err
+	}
+	return ev, nil
+}
+
+// Close ends the watch.
+func (ws *WatchStream) Close() error {
+	return ws.body.Body.Close()
+}
+
+func (c *Client) unary(ctx context.Context, method string, req, resp Message) error {
+	hr, err := c.call(ctx, method, req)
+	if err != nil {
+		return err
+	}
+	defer hr.Body.Close()
+	msg, err := ReadFrame(hr.Body, maxResponse)
+	if err == io.EOF {
+		if err := status(hr); err != nil {
+			return err
+		}
+		return &Status{Code: Internal, Message: "server sent no response message"}
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(io.Discard, hr.Body); err != nil {
+		return err
+	}
+	if err := status(hr); err != nil {
+		return err
+	}
+	return resp.Unmarshal(msg)
+}
+
+// call sends req and returns the response, once its headers show it is a
+// gRPC one. A call that failed at once is answered with its status in
+// the headers, which is returned as the error.
+func (c *Client) call(ctx context.Context, method string, req Message) (*http.Response, error) {
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(c.URL, "/")+method,
+		bytes.NewReader(AppendFrame(nil, req.Marshal())))
+	if err != nil {
+		return nil, err
+	}
+	hreq.Header.Set("Content-Type", ContentType)
+	hreq.Header.Set("TE", "trailers")
+	if c.APIKey != "" {
+		hreq.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = defaultHTTPClient
+	}
+	hr, err := hc.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	if hr.StatusCode != http.StatusOK || !strings.HasPrefix(hr.Header.Get("Content-Type"), ContentType) {
+		hr.Body.Close()
+		return nil, &Status{Code: httpCode(hr.StatusCode), Message: "unexpected HTTP response: " + hr.Status}
+	}
+	if hr.Header.Get("Grpc-Status") != "" {
+		if err := status(hr); err != nil {
+			hr.Body.Close()
+			return nil, err
+		}
+	}
+	return hr, nil
+}
+
+// status reads the call's status from the trailers, or the headers of a
+// response that has no body. It returns nil for OK.
+func status(hr *http.Response) error {
+	h := hr.Trailer
+	if h.Get("Grpc-Status") == "" {
+		h = hr.Header
+	}
+	raw := h.Get("Grpc-Status")
+	if raw == "" {
+		return &Status{Code: Internal, Message: "server sent no grpc-status"}
+	}
+	code, err := strconv.ParseUint(raw, 10, 32)
+	if err != nil {
+		return &Status{Code: Internal, Message: fmt.Sprintf("invalid grpc-status %q", raw)}
+	}
+	if code == uint64(OK) {
+		return nil
+	}
+	return &Status{Code: Code(code), Message: DecodeMessage(h.Get("Grpc-Message")), ErrorCode: h.Get(ErrorCodeTrailer)}
+}
+
+// httpCode maps the HTTP status of a response that is not gRPC, as gRPC
+// clients do.
+func httpCode(status int) Code {
+	switch status {
+	case http.StatusBadRequest:
+		return Internal
+	case http.StatusUnauthorized:
+		return Unauthenticated
+	case http.StatusForbidden:
+		return PermissionDenied
+	case http.StatusNotFound:
+		return Unimplemented
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return Unavailable
+	}
+	return Unknown
+}
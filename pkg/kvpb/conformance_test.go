@@ -0,0 +1,107 @@
+package kvpb
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/shubhamc1947/safemap/pkg/kvpb/internal/kvv1"
+)
+
+// TestConformsToGeneratedCode checks every message against protoc-gen-go's
+// output for the same .proto: both encode a message to the same bytes,
+// and each decodes what the other encodes.
+func TestConformsToGeneratedCode(t *testing.T) {
+	version, zero := uint64(7), uint64(0)
+	value, empty := "x", ""
+	cases := []struct {
+		name   string
+		msg    Message
+		gen    proto.Message
+		decode Message // an empty message of the same type as msg
+	}{
+		{"value", &Value{Data: []byte{0, 1}, ContentType: "image/png", Version: 1 << 40, ExpiresAt: 1700000000000},
+			&kvv1.Value{Data: []byte{0, 1}, ContentType: "image/png", Version: 1 << 40, ExpiresAtUnixMs: 1700000000000}, new(Value)},
+		{"error", &Error{Code: "key_not_found", Message: "key not found"},
+			&kvv1.Error{Code: "key_not_found", Message: "key not found"}, new(Error)},
+		{"get request", &GetRequest{Namespace: "ns", Key: "k"},
+			&kvv1.GetRequest{Namespace: "ns", Key: "k"}, new(GetRequest)},
+		{"get response", &GetResponse{Value: &Value{Data: []byte("v"), Version: 3}},
+			&kvv1.GetResponse{Value: &kvv1.Value{Data: []byte("v"), Version: 3}}, new(GetResponse)},
+		{"get response, empty value", &GetResponse{Value: &Value{}},
+			&kvv1.GetResponse{Value: &kvv1.Value{}}, new(GetResponse)},
+		{"put request", &PutRequest{Namespace: "ns", Key: "k", Value: []byte("v"), ContentType: "text/plain",
+			TTLSeconds: 60, IfVersion: &version, CreateOnly: true},
+			&kvv1.PutRequest{Namespace: "ns", Key: "k", Value: []byte("v"), ContentType: "text/plain",
+				TtlSeconds: 60, IfVersion: &version, CreateOnly: true}, new(PutRequest)},
+		{"put request, optional zero", &PutRequest{Key: "k", IfVersion: &zero},
+			&kvv1.PutRequest{Key: "k", IfVersion: &zero}, new(PutRequest)},
+		{"put response", &PutResponse{Version: 9, ExpiresAt: -1},
+			&kvv1.PutResponse{Version: 9, ExpiresAtUnixMs: -1}, new(PutResponse)},
+		{"delete request", &DeleteRequest{Namespace: "ns", Key: "k", IfVersion: &version},
+			&kvv1.DeleteRequest{Namespace: "ns", Key: "k", IfVersion: &version}, new(DeleteRequest)},
+		{"delete response", &DeleteResponse{}, &kvv1.DeleteResponse{}, new(DeleteResponse)},
+		{"batch request", &BatchRequest{Namespace: "ns", Ops: []Op{
+			{Type: OpSet, Key: "a", Value: []byte("1"), TTLSeconds: 5},
+			{Type: OpGet, Key: "a"},
+			{Type: OpDelete, Key: "b"},
+		}}, &kvv1.BatchRequest{Namespace: "ns", Ops: []*kvv1.Op{
+			{Type: kvv1.OpType_OP_TYPE_SET, Key: "a", Value: []byte("1"), TtlSeconds: 5},
+			{Type: kvv1.OpType_OP_TYPE_GET, Key: "a"},
+			{Type: kvv1.OpType_OP_TYPE_DELETE, Key: "b"},
+		}}, new(BatchRequest)},
+		{"batch response", &BatchResponse{Results: []OpResult{
+			{Status: 200, Value: &Value{Data: []byte("1"), Version: 3}},
+			{Status: 404, Error: &Error{Code: "key_not_found", Message: "key not found"}},
+			{Status: 204},
+		}}, &kvv1.BatchResponse{Results: []*kvv1.OpResult{
+			{Status: 200, Value: &kvv1.Value{Data: []byte("1"), Version: 3}},
+			{Status: 404, Error: &kvv1.Error{Code: "key_not_found", Message: "key not found"}},
+			{Status: 204},
+		}}, new(BatchResponse)},
+		{"txn request", &TxnRequest{
+			Namespace: "ns",
+			Compare:   []Compare{{Key: "leader", Version: &zero}, {Key: "x", Value: &value}, {Key: "y", Value: &empty, Version: &version}},
+			Success:   []Op{{Type: OpSet, Key: "leader", Value: []byte("n1")}},
+			Failure:   []Op{{Type: OpGet, Key: "leader"}},
+		}, &kvv1.TxnRequest{
+			Namespace: "ns",
+			Compare:   []*kvv1.Compare{{Key: "leader", Version: &zero}, {Key: "x", Value: &value}, {Key: "y", Value: &empty, Version: &version}},
+			Success:   []*kvv1.Op{{Type: kvv1.OpType_OP_TYPE_SET, Key: "leader", Value: []byte("n1")}},
+			Failure:   []*kvv1.Op{{Type: kvv1.OpType_OP_TYPE_GET, Key: "leader"}},
+		}, new(TxnRequest)},
+		{"txn response", &TxnResponse{Succeeded: true, Results: []OpResult{{Status: 201, Value: &Value{Version: 9}}}},
+			&kvv1.TxnResponse{Succeeded: true, Results: []*kvv1.OpResult{{Status: 201, Value: &kvv1.Value{Version: 9}}}}, new(TxnResponse)},
+		{"watch request", &WatchRequest{Namespace: "ns", Key: "k", Prefix: "jobs:", AfterID: &zero},
+			&kvv1.WatchRequest{Namespace: "ns", Key: "k", Prefix: "jobs:", AfterId: &zero}, new(WatchRequest)},
+		{"watch event", &WatchEvent{ID: 1 << 50, Type: EventSet, Key: "k", Value: &Value{Data: []byte("v")}},
+			&kvv1.WatchEvent{Id: 1 << 50, Type: kvv1.EventType_EVENT_TYPE_SET, Key: "k", Value: &kvv1.Value{Data: []byte("v")}}, new(WatchEvent)},
+		{"expire event", &WatchEvent{ID: 2, Type: EventExpire, Key: "k"},
+			&kvv1.WatchEvent{Id: 2, Type: kvv1.EventType_EVENT_TYPE_EXPIRE, Key: "k"}, new(WatchEvent)},
+	}
+	for _, tc := range cases {
+		want, err := proto.MarshalOptions{Deterministic: true}.Marshal(tc.gen)
+		if err != nil {
+			t.Fatalf("%s: generated Marshal: %v", tc.name, err)
+		}
+		got := tc.msg.Marshal()
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: Marshal() = % x, generated code encodes % x", tc.name, got, want)
+		}
+
+		gen := tc.gen.ProtoReflect().New().Interface()
+		if err := proto.Unmarshal(got, gen); err != nil {
+			t.Errorf("%s: generated code cannot decode Marshal(): %v", tc.name, err)
+		} else if !proto.Equal(gen, tc.gen) {
+			t.Errorf("%s: generated code decodes Marshal() as %v, want %v", tc.name, gen, tc.gen)
+		}
+
+		if err := tc.decode.Unmarshal(want); err != nil {
+			t.Errorf("%s: Unmarshal of the generated encoding: %v", tc.name, err)
+		} else if !reflect.DeepEqual(tc.decode, tc.msg) {
+			t.Errorf("%s: Unmarshal of the generated encoding = %+v, want %+v", tc.name, tc.decode, tc.msg)
+		}
+	}
+}
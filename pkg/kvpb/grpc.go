@@ -0,0 +1,168 @@
+package kvpb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ----------- gRPC -----------
+
+// ContentType is the content type of gRPC requests and responses.
+const ContentType = "application/grpc"
+
+// Method paths of the service.
+const (
+	MethodGet      = "/safemap.kv.v1.KV/Get"
+	MethodPut      = "/safemap.kv.v1.KV/Put"
+	MethodDelete   = "/safemap.kv.v1.KV/Delete"
+	MethodBatchOps = "/safemap.kv.v1.KV/BatchOps"
+	MethodTxn      = "/safemap.kv.v1.KV/Txn"
+	MethodWatch    = "/safemap.kv.v1.KV/Watch"
+)
+
+// ErrorCodeTrailer carries the HTTP API's error code of a failed call.
+const ErrorCodeTrailer = "Safemap-Error-Code"
+
+// Code is a gRPC status code.
+type Code uint32
+
+const (
+	OK                 Code = 0
+	Canceled           Code = 1
+	Unknown            Code = 2
+	InvalidArgument    Code = 3
+	DeadlineExceeded   Code = 4
+	NotFound           Code = 5
+	AlreadyExists      Code = 6
+	PermissionDenied   Code = 7
+	ResourceExhausted  Code = 8
+	FailedPrecondition Code = 9
+	Aborted            Code = 10
+	OutOfRange         Code = 11
+	Unimplemented      Code = 12
+	Internal           Code = 13
+	Unavailable        Code = 14
+	DataLoss           Code = 15
+	Unauthenticated    Code = 16
+)
+
+var codeNames = [...]string{
+	"OK", "Canceled", "Unknown", "InvalidArgument", "DeadlineExceeded",
+	"NotFound", "AlreadyExists", "PermissionDenied", "ResourceExhausted",
+	"FailedPrecondition", "Aborted", "OutOfRange", "Unimplemented",
+	"Internal", "Unavailable", "DataLoss", "Unauthenticated",
+}
+
+func (c Code) String() string {
+	if int(c) < len(codeNames) {
+		return codeNames[c]
+	}
+	return "Code(" + strconv.FormatUint(uint64(c), 10) + ")"
+}
+
+// Status is the error of a failed call. ErrorCode is the HTTP API's error
+// code, when the server sent one.
+type Status struct {
+	Code      Code
+	Message   string
+	ErrorCode string
+}
+
+func (s *Status) Error() string {
+	return fmt.Sprintf("rpc error: code = %s desc = %s", s.Code, s.Message)
+}
+
+// CodeOf returns err's status code: OK for nil, Unknown for errors that
+// are not a *Status.
+func CodeOf(err error) Code {
+	if err == nil {
+		return OK
+	}
+	var st *Status
+	if errors.As(err, &st) {
+		return st.Code
+	}
+	return Unknown
+}
+
+// ----------- Framing -----------
+
+// A gRPC message is framed as a compressed flag byte and a 4-byte
+// big-endian length. Compression is never negotiated here, so a set flag
+// is an error.
+const frameHeader = 5
+
+// ErrCompressed reports a compressed message.
+var ErrCompressed = errors.New("kvpb: compressed messages are not supported")
+
+// AppendFrame appends msg, framed, to b.
+func AppendFrame(b, msg []byte) []byte {
+	b = append(b, 0)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(msg)))
+	return append(b, msg...)
+}
+
+// ReadFrame reads a framed message no longer than limit bytes. It returns
+// io.EOF at the end of the stream, io.ErrUnexpectedEOF within a frame.
+func ReadFrame(r io.Reader, limit int) ([]byte, error) {
+	var hdr [frameHeader]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[0] != 0 {
+		return nil, ErrCompressed
+	}
+	n := binary.BigEndian.Uint32(hdr[1:])
+	if int64(n) > int64(limit) {
+		return nil, fmt.Errorf("kvpb: message of %d bytes exceeds the %d byte limit", n, limit)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return msg, nil
+}
+
+// ----------- grpc-message -----------
+
+// EncodeMessage percent-encodes a status message for the grpc-message
+// trailer, which only carries printable ASCII.
+func EncodeMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c >= 0x20 && c <= 0x7e && c != '%' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// DecodeMessage reverses EncodeMessage, leaving invalid escapes as they
+// are.
+func DecodeMessage(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,6 @@
+// Package kvv1 is protoc-gen-go's output for proto/safemap/kv/v1/kv.proto.
+// It is only for kvpb's tests, which check the hand-written messages
+// against it; regenerate it with go generate after editing the .proto.
+package kvv1
+
+//go:generate protoc -I ../../../../proto --go_out=. --go_opt=module=github.com/shubhamc1947/safemap/pkg/kvpb/internal/kvv1 --go_opt=Msafemap/kv/v1/kv.proto=github.com/shubhamc1947/safemap/pkg/kvpb/internal/kvv1;kvv1 safemap/kv/v1/kv.proto
@@ -0,0 +1,216 @@
+package kvpb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestMarshalMatchesProtobuf(t *testing.T) {
+	zero := uint64(0)
+	cases := []struct {
+		name string
+		msg  Message
+		want []byte
+	}{
+		{"get", &GetRequest{Namespace: "a", Key: "k"}, []byte{0x0a, 1, 'a', 0x12, 1, 'k'}},
+		{"empty", &GetRequest{}, nil},
+		// An optional field is sent at zero; a plain one is not.
+		{"optional zero", &PutRequest{Key: "k", IfVersion: &zero, TTLSeconds: 0}, []byte{0x12, 1, 'k', 0x30, 0}},
+		{"negative int64", &PutResponse{ExpiresAt: -1},
+			[]byte{0x10, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{"nested", &GetResponse{Value: &Value{Data: []byte("v"), Version: 300}},
+			[]byte{0x0a, 6, 0x0a, 1, 'v', 0x18, 0xac, 0x02}},
+		{"empty nested", &GetResponse{Value: &Value{}}, []byte{0x0a, 0}},
+	}
+	for _, tc := range cases {
+		if got := tc.msg.Marshal(); !bytes.Equal(got, tc.want) {
+			t.Errorf("%s: Marshal() = % x, want % x", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	version, after := uint64(7), uint64(0)
+	value := "x"
+	msgs := []struct{ in, out Message }{
+		{&PutRequest{Namespace: "ns", Key: "k", Value: []byte{0, 1, 2}, ContentType: "application/octet-stream",
+			TTLSeconds: 60, IfVersion: &version, CreateOnly: true}, new(PutRequest)},
+		{&DeleteRequest{Key: "k", IfVersion: &version}, new(DeleteRequest)},
+		{&BatchRequest{Namespace: "ns", Ops: []Op{
+			{Type: OpSet, Key: "a", Value: []byte("1"), TTLSeconds: 5},
+			{Type: OpGet, Key: "a"},
+		}}, new(BatchRequest)},
+		{&BatchResponse{Results: []OpResult{
+			{Status: 200, Value: &Value{Data: []byte("1"), Version: 3, ExpiresAt: 1700000000000}},
+			{Status: 404, Error: &Error{Code: "key_not_found", Message: "key not found"}},
+		}}, new(BatchResponse)},
+		{&TxnRequest{
+			Compare: []Compare{{Key: "leader", Version: &after}, {Key: "x", Value: &value}},
+			Success: []Op{{Type: OpSet, Key: "leader", Value: []byte("n1")}},
+			Failure: []Op{{Type: OpGet, Key: "leader"}},
+		}, new(TxnRequest)},
+		{&TxnResponse{Succeeded: true, Results: []OpResult{{Status: 201, Value: &Value{Version: 9}}}}, new(TxnResponse)},
+		{&WatchRequest{Namespace: "ns", Prefix: "jobs:", AfterID: &after}, new(WatchRequest)},
+		{&WatchEvent{ID: 1 << 50, Type: EventSet, Key: "k", Value: &Value{Data: []byte("v")}}, new(WatchEvent)},
+	}
+	for _, m := range msgs {
+		if err := m.out.Unmarshal(m.in.Marshal()); err != nil {
+			t.Fatalf("%T: %v", m.in, err)
+		}
+		if !reflect.DeepEqual(m.in, m.out) {
+			t.Errorf("%T: round trip gave %+v, want %+v", m.in, m.out, m.in)
+		}
+	}
+}
+
+func TestUnmarshalSkipsUnknownFields(t *testing.T) {
+	b := (&GetRequest{Key: "k"}).Marshal()
+	b = append(b, 0x78, 0x05)                         // field 15, varint
+	b = append(b, 0x81, 0x01, 1, 2, 3, 4, 5, 6, 7, 8) // field 16, fixed64
+	b = append(b, 0x8a, 0x01, 2, 'h', 'i')            // field 17, bytes
+	var m GetRequest
+	if err := m.Unmarshal(b); err != nil || m.Key != "k" {
+		t.Fatalf("Unmarshal = %+v, %v", m, err)
+	}
+}
+
+func TestUnmarshalRejectsMalformed(t *testing.T) {
+	for _, b := range [][]byte{
+		{0x12, 5, 'k'}, // length past the end
+		{0x12},         // missing length
+		{0x10, 1},      // varint where a string belongs
+		{0x00, 1},      // field number 0
+	} {
+		var m GetRequest
+		if err := m.Unmarshal(b); err == nil {
+			t.Errorf("Unmarshal(% x) succeeded", b)
+		}
+	}
+}
+
+func TestFrames(t *testing.T) {
+	b := AppendFrame(nil, []byte("abc"))
+	b = AppendFrame(b, nil)
+	if want := []byte{0, 0, 0, 0, 3, 'a', 'b', 'c', 0, 0, 0, 0, 0}; !bytes.Equal(b, want) {
+		t.Fatalf("frames = % x, want % x", b, want)
+	}
+	r := bytes.NewReader(b)
+	if msg, err := ReadFrame(r, 10); err != nil || string(msg) != "abc" {
+		t.Fatalf("first frame = %q, %v", msg, err)
+	}
+	if msg, err := ReadFrame(r, 10); err != nil || len(msg) != 0 {
+		t.Fatalf("second frame = %q, %v", msg, err)
+	}
+	if _, err := ReadFrame(r, 10); err != io.EOF {
+		t.Fatalf("end of stream: %v, want io.EOF", err)
+	}
+
+	if _, err := ReadFrame(bytes.NewReader([]byte{1, 0, 0, 0, 0}), 10); !errors.Is(err, ErrCompressed) {
+		t.Errorf("compressed frame: %v", err)
+	}
+	if _, err := ReadFrame(bytes.NewReader(AppendFrame(nil, make([]byte, 11))), 10); err == nil {
+		t.Errorf("frame over the limit accepted")
+	}
+	if _, err := ReadFrame(bytes.NewReader([]byte{0, 0, 0, 0, 3, 'a'}), 10); err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated frame: %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestStatusMessageEncoding(t *testing.T) {
+	msg := "key \"ü\" is 100% gone\n"
+	enc := EncodeMessage(msg)
+	for _, c := range []byte(enc) {
+		if c < 0x20 || c > 0x7e {
+			t.Fatalf("EncodeMessage(%q) = %q has byte %#x", msg, enc, c)
+		}
+	}
+	if got := DecodeMessage(enc); got != msg {
+		t.Errorf("DecodeMessage(%q) = %q, want %q", enc, got, msg)
+	}
+	if got := DecodeMessage("50%"); got != "50%" {
+		t.Errorf("DecodeMessage kept %q, want the invalid escape as is", got)
+	}
+}
+
+// newH2CServer serves handler over HTTP/2 without TLS, as --grpc-addr
+// does.
+func newH2CServer(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	srv := httptest.NewUnstartedServer(handler)
+	srv.Config.Protocols = new(http.Protocols)
+	srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestClientUnary(t *testing.T) {
+	srv := newH2CServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || r.URL.Path != MethodGet || r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("request %s %s %s, auth %q", r.Proto, r.Method, r.URL.Path, r.Header.Get("Authorization"))
+		}
+		msg, err := ReadFrame(r.Body, 1<<20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var req GetRequest
+		if err := req.Unmarshal(msg); err != nil {
+			t.Fatal(err)
+		}
+		w.Header().Set("Content-Type", ContentType)
+		if req.Key == "missing" {
+			w.Header().Set(http.TrailerPrefix+"Grpc-Status", "5")
+			w.Header().Set(http.TrailerPrefix+"Grpc-Message", EncodeMessage("key not found"))
+			w.Header().Set(http.TrailerPrefix+ErrorCodeTrailer, "key_not_found")
+			return
+		}
+		_, _ = w.Write(AppendFrame(nil, (&GetResponse{Value: &Value{Data: []byte(req.Key)}}).Marshal()))
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+	})
+
+	c := NewClient(srv.URL, "secret")
+	resp, err := c.Get(context.Background(), &GetRequest{Key: "hello"})
+	if err != nil || string(resp.Value.Data) != "hello" {
+		t.Fatalf("Get = %+v, %v", resp, err)
+	}
+
+	_, err = c.Get(context.Background(), &GetRequest{Key: "missing"})
+	var st *Status
+	if !errors.As(err, &st) || st.Code != NotFound || st.Message != "key not found" || st.ErrorCode != "key_not_found" {
+		t.Fatalf("Get(missing) error = %v", err)
+	}
+	if CodeOf(err) != NotFound || CodeOf(nil) != OK {
+		t.Errorf("CodeOf mismatch")
+	}
+}
+
+func TestClientWatch(t *testing.T) {
+	srv := newH2CServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		for id := uint64(1); id <= 3; id++ {
+			_, _ = w.Write(AppendFrame(nil, (&WatchEvent{ID: id, Type: EventDelete, Key: "k"}).Marshal()))
+		}
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "14")
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", "server shutting down")
+	})
+
+	ws, err := NewClient(srv.URL, "").Watch(context.Background(), &WatchRequest{Prefix: "k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	for id := uint64(1); id <= 3; id++ {
+		ev, err := ws.Recv()
+		if err != nil || ev.ID != id || ev.Type != EventDelete {
+			t.Fatalf("event %d = %+v, %v", id, ev, err)
+		}
+	}
+	if _, err := ws.Recv(); CodeOf(err) != Unavailable {
+		t.Fatalf("end of watch: %v, want Unavailable", err)
+	}
+}
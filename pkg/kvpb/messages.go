@@ -0,0 +1,609 @@
+package kvpb
+
+// ----------- Messages -----------
+
+// Value is a stored value. ContentType is empty for values written
+// through the JSON envelope.
+type Value struct {
+	Data        []byte
+	ContentType string
+	Version     uint64
+	ExpiresAt   int64 // Unix milliseconds; 0 without a TTL
+}
+
+func (m *Value) Marshal() []byte {
+	var e encoder
+	e.bytes(1, m.Data)
+	e.string(2, m.ContentType)
+	e.uint64(3, m.Version)
+	e.int64(4, m.ExpiresAt)
+	return e.b
+}
+
+func (m *Value) Unmarshal(b []byte) error {
+	*m = Value{}
+	return decode(b, func(d *decoder, num, typ int) (err error) {
+		switch num {
+		case 1:
+			m.Data, err = d.copyBytes(typ)
+		case 2:
+			m.ContentType, err = d.string(typ)
+		case 3:
+			m.Version, err = d.uint64(typ)
+		case 4:
+			m.ExpiresAt, err = d.int64(typ)
+		default:
+			err = d.skip(typ)
+		}
+		return err
+	})
+}
+
+// Error is an operation's failure; Code is one of the HTTP API's error
+// codes.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (m *Error) Marshal() []byte {
+	var e encoder
+	e.string(1, m.Code)
+	e.string(2, m.Message)
+	return e.b
+}
+
+func (m *Error) Unmarshal(b []byte) error {
+	*m = Error{}
+	return decode(b, func(d *decoder, num, typ int) (err error) {
+		switch num {
+		case 1:
+			m.Code, err = d.string(typ)
+		case 2:
+			m.Message, err = d.string(typ)
+		default:
+			err = d.skip(typ)
+		}
+		return err
+	})
+}
+
+type GetRequest struct {
+	Namespace string // "" for the flat keyspace
+	Key       string
+}
+
+func (m *GetRequest) Marshal() []byte {
+	var e encoder
+	e.string(1, m.Namespace)
+	e.string(2, m.Key)
+	return e.b
+}
+
+func (m *GetRequest) Unmarshal(b []byte) error {
+	*m = GetRequest{}
+	return decode(b, func(d *decoder, num, typ int) (err error) {
+		switch num {
+		case 1:
+			m.Namespace, err = d.string(typ)
+		case 2:
+			m.Key, err = d.string(typ)
+		default:
+			err = d.skip(typ)
+		}
+		return err
+	})
+}
+
+type GetResponse struct {
+	Value *Value
+}
+
+func (m *GetResponse) Marshal() []byte {
+	var e encoder
+	if m.Value != nil {
+		e.message(1, m.Value)
+	}
+	return e.b
+}
+
+func (m *GetResponse) Unmarshal(b []byte) error {
+	*m = GetResponse{}
+	return decode(b, func(d *decoder, num, typ int) error {
+		if num == 1 {
+			m.Value = new(Value)
+			return d.message(typ, m.Value)
+		}
+		return d.skip(typ)
+	})
+}
+
+type PutRequest struct {
+	Namespace   string
+	Key         string
+	Value       []byte
+	ContentType string
+	TTLSeconds  int64
+	IfVersion   *uint64 // write only if the key is at this version
+	CreateOnly  bool    // write only if the key does not exist
+}
+
+func (m *PutRequest) Marshal() []byte {
+	var e encoder
+	e.string(1, m.Namespace)
+	e.string(2, m.Key)
+	e.bytes(3, m.Value)
+	e.string(4, m.ContentType)
+	e.int64(5, m.TTLSeconds)
+	e.optionalUint64(6, m.IfVersion)
+	e.bool(7, m.CreateOnly)
+	return e.b
+}
+
+func (m *PutRequest) Unmarshal(b []byte) error {
+	*m = PutRequest{}
+	return decode(b, func(d *decoder, num, typ int) (err error) {
+		switch num {
+		case 1:
+			m.Namespace, err = d.string(typ)
+		case 2:
+			m.Key, err = d.string(typ)
+		case 3:
+			m.Value, err = d.copyBytes(typ)
+		case 4:
+			m.ContentType, err = d.string(typ)
+		case 5:
+			m.TTLSeconds, err = d.int64(typ)
+		case 6:
+			var v uint64
+			v, err = d.uint64(typ)
+			m.IfVersion = &v
+		case 7:
+			m.CreateOnly, err = d.bool(typ)
+		default:
+			err = d.skip(typ)
+		}
+		return err
+	})
+}
+
+type PutResponse struct {
+	Version   uint64
+	ExpiresAt int64 // Unix milliseconds; 0 without a TTL
+}
+
+func (m *PutResponse) Marshal() []byte {
+	var e encoder
+	e.uint64(1, m.Version)
+	e.int64(2, m.ExpiresAt)
+	return e.b
+}
+
+func (m *PutResponse) Unmarshal(b []byte) error {
+	*m = PutResponse{}
+	return decode(b, func(d *decoder, num, typ int) (err error) {
+		switch num {
+		case 1:
+			m.Version, err = d.uint64(typ)
+		case 2:
+			m.ExpiresAt, err = d.int64(typ)
+		default:
+			err = d.skip(typ)
+		}
+		return err
+	})
+}
+
+type DeleteRequest struct {
+	Namespace string
+	Key       string
+	IfVersion *uint64
+}
+
+func (m *DeleteRequest) Marshal() []byte {
+	var e encoder
+	e.string(1, m.Namespace)
+	e.string(2, m.Key)
+	e.optionalUint64(3, m.IfVersion)
+	return e.b
+}
+
+func (m *DeleteRequest) Unmarshal(b []byte) error {
+	*m = DeleteRequest{}
+	return decode(b, func(d *decoder, num, typ int) (err error) {
+		switch num {
+		case 1:
+			m.Namespace, err = d.string(typ)
+		case 2:
+			m.Key, err = d.string(typ)
+		case 3:
+			var v uint64
+			v, err = d.uint64(typ)
+			m.IfVersion = &v
+		default:
+			err = d.skip(typ)
+		}
+		return err
+	})
+}
+
+type DeleteResponse struct{}
+
+func (m *DeleteResponse) Marshal() []byte { return nil }
+
+func (m *DeleteResponse) Unmarshal(b []byte) error {
+	return decode(b, func(d *decoder, num, typ int) error { return d.skip(typ) })
+}
+
+// OpType is the kind of a batch or transaction operation.
+type OpType int32
+
+const (
+	OpUnspecified OpType = iota
+	OpGet
+	OpSet
+	OpDelete
+)
+
+// String is the op's name in the HTTP API: "get", "set" or "delete".
+func (t OpType) String() string {
+	switch t {
+	case OpGet:
+		return "get"
+	case OpSet:
+		return "set"
+	case OpDelete:
+		return "delete"
+	}
+	return "unspecified"
+}
+
+type Op struct {
+	Type       OpType
+	Key        string
+	Value      []byte
+	TTLSeconds int64
+}
+
+func (m *Op) Marshal() []byte {
+	var e encoder
+	e.uint64(1, uint64(m.Type))
+	e.string(2, m.Key)
+	e.bytes(3, m.Value)
+	e.int64(4, m.TTLSeconds)
+	return e.b
+}
+
+func (m *Op) Unmarshal(b []byte) error {
+	*m = Op{}
+	return decode(b, func(d *decoder, num, typ int) (err error) {
+		switch num {
+		case 1:
+			var v int32
+			v, err = d.int32(typ)
+			m.Type = OpType(v)
+		case 2:
+			m.Key, err = d.string(typ)
+		case 3:
+			m.Value, err = d.copyBytes(typ)
+		case 4:
+			m.TTLSeconds, err = d.int64(typ)
+		default:
+			err = d.skip(typ)
+		}
+		return err
+	})
+}
+
+// OpResult is one operation's outcome. Status is what the HTTP API would
+// have answered.
+type OpResult struct {
+	Status int32
+	Value  *Value // the value of a get, the new version of a set
+	Error  *Error
+}
+
+func (m *OpResult) Marshal() []byte {
+	var e encoder
+	e.int64(1, int64(m.Status))
+	if m.Value != nil {
+		e.message(2, m.Value)
+	}
+	if m.Error != nil {
+		e.message(3, m.Error)
+	}
+	return e.b
+}
+
+func (m *OpResult) Unmarshal(b []byte) error {
+	*m = OpResult{}
+	return decode(b, func(d *decoder, num, typ int) (err error) {
+		switch num {
+		case 1:
+			m.Status, err = d.int32(typ)
+		case 2:
+			m.Value = new(Value)
+			err = d.message(typ, m.Value)
+		case 3:
+			m.Error = new(Error)
+			err = d.message(typ, m.Error)
+		default:
+			err = d.skip(typ)
+		}
+		return err
+	})
+}
+
+type BatchRequest struct {
+	Namespace string
+	Ops       []Op
+}
+
+func (m *BatchRequest) Marshal() []byte {
+	var e encoder
+	e.string(1, m.Namespace)
+	for i := range m.Ops {
+		e.message(2, &m.Ops[i])
+	}
+	return e.b
+}
+
+func (m *BatchRequest) Unmarshal(b []byte) error {
+	*m = BatchRequest{}
+	return decode(b, func(d *decoder, num, typ int) (err error) {
+		switch num {
+		case 1:
+			m.Namespace, err = d.string(typ)
+		case 2:
+			var op Op
+			err = d.message(typ, &op)
+			m.Ops = append(m.Ops, op)
+		default:
+			err = d.skip(typ)
+		}
+		return err
+	})
+}
+
+type BatchResponse struct {
+	Results []OpResult
+}
+
+func (m *BatchResponse) Marshal() []byte {
+	var e encoder
+	for i := range m.Results {
+		e.message(1, &m.Results[i])
+	}
+	return e.b
+}
+
+func (m *BatchResponse) Unmarshal(b []byte) error {
+	*m = BatchResponse{}
+	return decode(b, func(d *decoder, num, typ int) error {
+		if num == 1 {
+			var res OpResult
+			err := d.message(typ, &res)
+			m.Results = append(m.Results, res)
+			return err
+		}
+		return d.skip(typ)
+	})
+}
+
+// Compare is a condition of a transaction: the key's value equals Value,
+// its version equals Version (0 for a key that does not exist), or both.
+type Compare struct {
+	Key     string
+	Value   *string
+	Version *uint64
+}
+
+func (m *Compare) Marshal() []byte {
+	var e encoder
+	e.string(1, m.Key)
+	e.optionalString(2, m.Value)
+	e.optionalUint64(3, m.Version)
+	return e.b
+}
+
+func (m *Compare) Unmarshal(b []byte) error {
+	*m = Compare{}
+	return decode(b, func(d *decoder, num, typ int) (err error) {
+		switch num {
+		case 1:
+			m.Key, err = d.string(typ)
+		case 2:
+			var v string
+			v, err = d.string(typ)
+			m.Value = &v
+		case 3:
+			var v uint64
+			v, err = d.uint64(typ)
+			m.Version = &v
+		default:
+			err = d.skip(typ)
+		}
+		return err
+	})
+}
+
+type TxnRequest struct {
+	Namespace string
+	Compare   []Compare
+	Success   []Op
+	Failure   []Op
+}
+
+func (m *TxnRequest) Marshal() []byte {
+	var e encoder
+	e.string(1, m.Namespace)
+	for i := range m.Compare {
+		e.message(2, &m.Compare[i])
+	}
+	for i := range m.Success {
+		e.message(3, &m.Success[i])
+	}
+	for i := range m.Failure {
+		e.message(4, &m.Failure[i])
+	}
+	return e.b
+}
+
+func (m *TxnRequest) Unmarshal(b []byte) error {
+	*m = TxnRequest{}
+	return decode(b, func(d *decoder, num, typ int) (err error) {
+		switch num {
+		case 1:
+			m.Namespace, err = d.string(typ)
+		case 2:
+			var c Compare
+			err = d.message(typ, &c)
+			m.Compare = append(m.Compare, c)
+		case 3, 4:
+			var op Op
+			err = d.message(typ, &op)
+			if num == 3 {
+				m.Success = append(m.Success, op)
+			} else {
+				m.Failure = append(m.Failure, op)
+			}
+		default:
+			err = d.skip(typ)
+		}
+		return err
+	})
+}
+
+type TxnResponse struct {
+	Succeeded bool
+	Results   []OpResult
+}
+
+func (m *TxnResponse) Marshal() []byte {
+	var e encoder
+	e.bool(1, m.Succeeded)
+	for i := range m.Results {
+		e.message(2, &m.Results[i])
+	}
+	return e.b
+}
+
+func (m *TxnResponse) Unmarshal(b []byte) error {
+	*m = TxnResponse{}
+	return decode(b, func(d *decoder, num, typ int) (err error) {
+		switch num {
+		case 1:
+			m.Succeeded, err = d.bool(typ)
+		case 2:
+			var res OpResult
+			err = d.message(typ, &res)
+			m.Results = append(m.Results, res)
+		default:
+			err = d.skip(typ)
+		}
+		return err
+	})
+}
+
+// WatchRequest follows Key, or keys starting with Prefix (every key when
+// both are empty). With AfterID, the kept events after it come first.
+type WatchRequest struct {
+	Namespace string
+	Key       string
+	Prefix    string
+	AfterID   *uint64
+}
+
+func (m *WatchRequest) Marshal() []byte {
+	var e encoder
+	e.string(1, m.Namespace)
+	e.string(2, m.Key)
+	e.string(3, m.Prefix)
+	e.optionalUint64(4, m.AfterID)
+	return e.b
+}
+
+func (m *WatchRequest) Unmarshal(b []byte) error {
+	*m = WatchRequest{}
+	return decode(b, func(d *decoder, num, typ int) (err error) {
+		switch num {
+		case 1:
+			m.Namespace, err = d.string(typ)
+		case 2:
+			m.Key, err = d.string(typ)
+		case 3:
+			m.Prefix, err = d.string(typ)
+		case 4:
+			var v uint64
+			v, err = d.uint64(typ)
+			m.AfterID = &v
+		default:
+			err = d.skip(typ)
+		}
+		return err
+	})
+}
+
+// EventType is the kind of a watch event.
+type EventType int32
+
+const (
+	EventUnspecified EventType = iota
+	EventSet
+	EventDelete
+	EventExpire
+)
+
+// String is the event's name in the HTTP API: "set", "delete" or
+// "expire".
+func (t EventType) String() string {
+	switch t {
+	case EventSet:
+		return "set"
+	case EventDelete:
+		return "delete"
+	case EventExpire:
+		return "expire"
+	}
+	return "unspecified"
+}
+
+type WatchEvent struct {
+	ID    uint64
+	Type  EventType
+	Key   string
+	Value *Value // the new value of a set
+}
+
+func (m *WatchEvent) Marshal() []byte {
+	var e encoder
+	e.uint64(1, m.ID)
+	e.uint64(2, uint64(m.Type))
+	e.string(3, m.Key)
+	if m.Value != nil {
+		e.message(4, m.Value)
+	}
+	return e.b
+}
+
+func (m *WatchEvent) Unmarshal(b []byte) error {
+	*m = WatchEvent{}
+	return decode(b, func(d *decoder, num, typ int) (err error) {
+		switch num {
+		case 1:
+			m.ID, err = d.uint64(typ)
+		case 2:
+			var v int32
+			v, err = d.int32(typ)
+			m.Type = EventType(v)
+		case 3:
+			m.Key, err = d.string(typ)
+		case 4:
+			m.Value = new(Value)
+			err = d.message(typ, m.Value)
+		default:
+			err = d.skip(typ)
+		}
+		return err
+	})
+}
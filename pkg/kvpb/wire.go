@@ -0,0 +1,223 @@
+// Package kvpb holds the messages of the safemap.kv.v1.KV gRPC service
+// (proto/safemap/kv/v1/kv.proto), their protobuf encoding, the gRPC
+// message framing, and a Go client.
+//
+// Everything is written by hand against the .proto, covering just the
+// protobuf wire format the service uses, so neither the server nor Go
+// clients depend on the protobuf or gRPC modules. Messages encode
+// byte-for-byte as protoc-generated code would, and decode anything a
+// conforming encoder produces, skipping unknown fields.
+package kvpb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Message is a protobuf message of the service.
+type Message interface {
+	Marshal() []byte
+	Unmarshal(b []byte) error
+}
+
+// Wire types.
+const (
+	wireVarint = 0
+	wireI64    = 1
+	wireBytes  = 2
+	wireI32    = 5
+)
+
+var errTruncated = errors.New("kvpb: truncated message")
+
+// ----------- Encoding -----------
+
+// encoder appends fields. Scalar fields at their zero value are omitted,
+// as proto3 does.
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) tag(num, typ int) {
+	e.b = binary.AppendUvarint(e.b, uint64(num)<<3|uint64(typ))
+}
+
+func (e *encoder) uint64(num int, v uint64) {
+	if v != 0 {
+		e.tag(num, wireVarint)
+		e.b = binary.AppendUvarint(e.b, v)
+	}
+}
+
+// int64 encodes v as protobuf int64: negative values take ten bytes.
+func (e *encoder) int64(num int, v int64) {
+	e.uint64(num, uint64(v))
+}
+
+func (e *encoder) bool(num int, v bool) {
+	if v {
+		e.uint64(num, 1)
+	}
+}
+
+func (e *encoder) bytes(num int, v []byte) {
+	if len(v) > 0 {
+		e.tag(num, wireBytes)
+		e.b = binary.AppendUvarint(e.b, uint64(len(v)))
+		e.b = append(e.b, v...)
+	}
+}
+
+func (e *encoder) string(num int, v string) {
+	if v != "" {
+		e.tag(num, wireBytes)
+		e.b = binary.AppendUvarint(e.b, uint64(len(v)))
+		e.b = append(e.b, v...)
+	}
+}
+
+// optionalUint64 encodes an optional field, which is sent even at zero
+// when set.
+func (e *encoder) optionalUint64(num int, v *uint64) {
+	if v != nil {
+		e.tag(num, wireVarint)
+		e.b = binary.AppendUvarint(e.b, *v)
+	}
+}
+
+func (e *encoder) optionalString(num int, v *string) {
+	if v != nil {
+		e.tag(num, wireBytes)
+		e.b = binary.AppendUvarint(e.b, uint64(len(*v)))
+		e.b = append(e.b, *v...)
+	}
+}
+
+// message encodes a message field; a set field is sent even when empty.
+func (e *encoder) message(num int, m Message) {
+	data := m.Marshal()
+	e.tag(num, wireBytes)
+	e.b = binary.AppendUvarint(e.b, uint64(len(data)))
+	e.b = append(e.b, data...)
+}
+
+// ----------- Decoding -----------
+
+// decoder reads the fields of one message.
+type decoder struct {
+	b []byte
+}
+
+// decode calls field for each field of b, which must read or skip it.
+func decode(b []byte, field func(d *decoder, num, typ int) error) error {
+	d := &decoder{b: b}
+	for len(d.b) > 0 {
+		key, err := d.varint()
+		if err != nil {
+			return err
+		}
+		num, typ := int(key>>3), int(key&7)
+		if num <= 0 {
+			return fmt.Errorf("kvpb: invalid field number %d", num)
+		}
+		if err := field(d, num, typ); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *decoder) varint() (uint64, error) {
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	d.b = d.b[n:]
+	return v, nil
+}
+
+func (d *decoder) uint64(typ int) (uint64, error) {
+	if typ != wireVarint {
+		return 0, fmt.Errorf("kvpb: wire type %d for a varint field", typ)
+	}
+	return d.varint()
+}
+
+func (d *decoder) int64(typ int) (int64, error) {
+	v, err := d.uint64(typ)
+	return int64(v), err
+}
+
+func (d *decoder) int32(typ int) (int32, error) {
+	v, err := d.uint64(typ)
+	return int32(v), err
+}
+
+func (d *decoder) bool(typ int) (bool, error) {
+	v, err := d.uint64(typ)
+	return v != 0, err
+}
+
+// bytes reads a length-delimited field. The result aliases the input.
+func (d *decoder) bytes(typ int) ([]byte, error) {
+	if typ != wireBytes {
+		return nil, fmt.Errorf("kvpb: wire type %d for a length-delimited field", typ)
+	}
+	n, err := d.varint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(d.b)) {
+		return nil, errTruncated
+	}
+	v := d.b[:n:n]
+	d.b = d.b[n:]
+	return v, nil
+}
+
+func (d *decoder) string(typ int) (string, error) {
+	v, err := d.bytes(typ)
+	return string(v), err
+}
+
+// copyBytes is bytes, copied, for values kept beyond the input.
+func (d *decoder) copyBytes(typ int) ([]byte, error) {
+	v, err := d.bytes(typ)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{}, v...), nil
+}
+
+func (d *decoder) message(typ int, m Message) error {
+	v, err := d.bytes(typ)
+	if err != nil {
+		return err
+	}
+	return m.Unmarshal(v)
+}
+
+// skip passes over a field this package does not know.
+func (d *decoder) skip(typ int) error {
+	switch typ {
+	case wireVarint:
+		_, err := d.varint()
+		return err
+	case wireI64, wireI32:
+		n := 8
+		if typ == wireI32 {
+			n = 4
+		}
+		if len(d.b) < n {
+			return errTruncated
+		}
+		d.b = d.b[n:]
+		return nil
+	case wireBytes:
+		_, err := d.bytes(typ)
+		return err
+	default:
+		return fmt.Errorf("kvpb: unsupported wire type %d", typ)
+	}
+}
@@ -0,0 +1,282 @@
+// Package lincheck records histories of concurrent map operations and
+// checks them for linearizability.
+//
+// A Recorder timestamps the invocation and response of every operation
+// with a shared logical clock. Check then searches for a sequential order
+// that respects real-time precedence and the semantics of a map of ints
+// (the Wing & Gong / Lowe algorithm used by porcupine). Map keys are
+// independent, so the history is partitioned per key and each partition
+// is checked separately, which keeps the search small.
+package lincheck
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Kind is the type of a recorded operation.
+type Kind int
+
+const (
+	Get            Kind = iota // Value, OK = current value, present
+	Set                        // stores Arg
+	Delete                     // removes the key
+	LoadOrStore                // Value, OK = actual, loaded
+	Add                        // adds Arg (from 0 if absent); Value = new value
+	CompareAndSwap             // replaces Arg with Arg2; OK = swapped
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Get:
+		return "Get"
+	case Set:
+		return "Set"
+	case Delete:
+		return "Delete"
+	case LoadOrStore:
+		return "LoadOrStore"
+	case Add:
+		return "Add"
+	case CompareAndSwap:
+		return "CompareAndSwap"
+	default:
+		return "Unknown"
+	}
+}
+
+// Operation is one completed call: its inputs, outputs and the logical
+// times at which it was invoked and returned.
+type Operation struct {
+	Client int
+	Kind   Kind
+	Key    string
+	Arg    int
+	Arg2   int
+
+	Value int
+	OK    bool
+
+	Call   int64
+	Return int64
+}
+
+func (op Operation) String() string {
+	return fmt.Sprintf("client %d %s(%q, %d, %d) -> (%d, %v) [%d, %d]",
+		op.Client, op.Kind, op.Key, op.Arg, op.Arg2, op.Value, op.OK, op.Call, op.Return)
+}
+
+// ----------- Recorder -----------
+
+// Recorder collects operations from concurrent clients.
+type Recorder struct {
+	clock atomic.Int64
+
+	mu  sync.Mutex
+	ops []Operation
+}
+
+// Do stamps op's invocation time, runs it (run must fill in Value/OK),
+// stamps the response time and records the result.
+func (r *Recorder) Do(op Operation, run func(op *Operation)) {
+	op.Call = r.clock.Add(1)
+	run(&op)
+	op.Return = r.clock.Add(1)
+
+	r.mu.Lock()
+	r.ops = append(r.ops, op)
+	r.mu.Unlock()
+}
+
+// History returns a copy of all recorded operations.
+func (r *Recorder) History() []Operation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Operation(nil), r.ops...)
+}
+
+// ----------- Sequential Model -----------
+
+type state struct {
+	value   int
+	present bool
+}
+
+// step applies op to s and reports whether op's recorded outputs are
+// what a sequential map would have returned.
+func step(s state, op Operation) (bool, state) {
+	switch op.Kind {
+	case Get:
+		if op.OK != s.present || (s.present && op.Value != s.value) {
+			return false, s
+		}
+		return true, s
+	case Set:
+		return true, state{value: op.Arg, present: true}
+	case Delete:
+		return true, state{}
+	case LoadOrStore:
+		if s.present {
+			return op.OK && op.Value == s.value, s
+		}
+		return !op.OK && op.Value == op.Arg, state{value: op.Arg, present: true}
+	case Add:
+		next := s.value + op.Arg
+		if !s.present {
+			next = op.Arg
+		}
+		return op.Value == next, state{value: next, present: true}
+	case CompareAndSwap:
+		if s.present && s.value == op.Arg {
+			return op.OK, state{value: op.Arg2, present: true}
+		}
+		return !op.OK, s
+	default:
+		return false, s
+	}
+}
+
+// ----------- Checker -----------
+
+// Result reports the outcome of Check. When the history is not
+// linearizable, Key names the offending key and Ops its operations.
+type Result struct {
+	OK  bool
+	Key string
+	Ops []Operation
+}
+
+// Check reports whether history is linearizable with respect to a map
+// of ints where every key starts absent.
+func Check(history []Operation) Result {
+	byKey := make(map[string][]Operation)
+	for _, op := range history {
+		byKey[op.Key] = append(byKey[op.Key], op)
+	}
+
+	keys := make([]string, 0, len(byKey))
+	for k := range byKey {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if !checkSingle(byKey[k]) {
+			return Result{OK: false, Key: k, Ops: byKey[k]}
+		}
+	}
+	return Result{OK: true}
+}
+
+// entry is a call or return event in a doubly linked, time-ordered list.
+type entry struct {
+	id     int
+	isCall bool
+	time   int64
+	op     Operation
+	match  *entry // call → its return
+	prev   *entry
+	next   *entry
+}
+
+func buildList(ops []Operation) *entry {
+	events := make([]*entry, 0, 2*len(ops))
+	for i, op := range ops {
+		ret := &entry{id: i, time: op.Return, op: op}
+		call := &entry{id: i, isCall: true, time: op.Call, op: op, match: ret}
+		events = append(events, call, ret)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].time < events[j].time })
+
+	head := &entry{id: -1}
+	prev := head
+	for _, e := range events {
+		prev.next = e
+		e.prev = prev
+		prev = e
+	}
+	return head
+}
+
+// lift removes a call and its return from the list.
+func lift(call *entry) {
+	call.prev.next = call.next
+	if call.next != nil {
+		call.next.prev = call.prev
+	}
+	ret := call.match
+	ret.prev.next = ret.next
+	if ret.next != nil {
+		ret.next.prev = ret.prev
+	}
+}
+
+// unlift reinserts a call and its return, undoing lift.
+func unlift(call *entry) {
+	ret := call.match
+	ret.prev.next = ret
+	if ret.next != nil {
+		ret.next.prev = ret
+	}
+	call.prev.next = call
+	if call.next != nil {
+		call.next.prev = call
+	}
+}
+
+type bitset []uint64
+
+func newBitset(n int) bitset        { return make(bitset, (n+63)/64) }
+func (b bitset) set(i int)          { b[i/64] |= 1 << (i % 64) }
+func (b bitset) clear(i int)        { b[i/64] &^= 1 << (i % 64) }
+func (b bitset) key(s state) string { return fmt.Sprint([]uint64(b), s) }
+
+func checkSingle(ops []Operation) bool {
+	head := buildList(ops)
+	linearized := newBitset(len(ops))
+	seen := make(map[string]struct{})
+
+	type frame struct {
+		call  *entry
+		state state
+	}
+	var stack []frame
+
+	s := state{}
+	e := head.next
+	for head.next != nil {
+		if e.isCall {
+			ok, next := step(s, e.op)
+			if ok {
+				linearized.set(e.id)
+				k := linearized.key(next)
+				if _, dup := seen[k]; !dup {
+					seen[k] = struct{}{}
+					stack = append(stack, frame{call: e, state: s})
+					s = next
+					lift(e)
+					e = head.next
+					continue
+				}
+				linearized.clear(e.id)
+			}
+			e = e.next
+			continue
+		}
+
+		// Reached a return whose call could not be linearized yet:
+		// backtrack to the most recent choice.
+		if len(stack) == 0 {
+			return false
+		}
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		s = top.state
+		linearized.clear(top.call.id)
+		unlift(top.call)
+		e = top.call.next
+	}
+	return true
+}
@@ -0,0 +1,98 @@
+package lincheck
+
+import (
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/shubhamc1947/safemap/pkg/concurrentmap"
+)
+
+func TestCheckRejectsStaleRead(t *testing.T) {
+	history := []Operation{
+		{Client: 0, Kind: Set, Key: "a", Arg: 1, Call: 1, Return: 2},
+		// Starts after the Set returned, yet sees the key as absent.
+		{Client: 1, Kind: Get, Key: "a", OK: false, Call: 3, Return: 4},
+	}
+	if res := Check(history); res.OK || res.Key != "a" {
+		t.Fatalf("expected stale read on a to be rejected, got %+v", res)
+	}
+}
+
+func TestCheckAcceptsOverlappingReads(t *testing.T) {
+	history := []Operation{
+		{Client: 0, Kind: Set, Key: "a", Arg: 1, Call: 1, Return: 6},
+		// Both reads overlap the Set, so either outcome is legal, but the
+		// later read must not go back to "absent" once it saw 1.
+		{Client: 1, Kind: Get, Key: "a", Value: 1, OK: true, Call: 2, Return: 3},
+		{Client: 2, Kind: Get, Key: "a", OK: false, Call: 4, Return: 5},
+	}
+	if res := Check(history); res.OK {
+		t.Fatalf("expected read going backwards in time to be rejected")
+	}
+
+	history[2] = Operation{Client: 2, Kind: Get, Key: "a", Value: 1, OK: true, Call: 4, Return: 5}
+	if res := Check(history); !res.OK {
+		t.Fatalf("expected history to be linearizable, failed on %s", res.Key)
+	}
+}
+
+func TestConcurrentMapIsLinearizable(t *testing.T) {
+	m := concurrentmap.NewStringMap[int](4)
+	var rec Recorder
+
+	var wg sync.WaitGroup
+	for c := 0; c < 6; c++ {
+		wg.Add(1)
+		go func(client int) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewPCG(uint64(client), 7))
+
+			for i := 0; i < 150; i++ {
+				op := Operation{
+					Client: client,
+					Kind:   Kind(rnd.IntN(6)),
+					Key:    "k" + strconv.Itoa(rnd.IntN(3)),
+					Arg:    rnd.IntN(4),
+					Arg2:   rnd.IntN(4),
+				}
+				rec.Do(op, func(op *Operation) { apply(m, op) })
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	if res := Check(rec.History()); !res.OK {
+		for _, op := range res.Ops {
+			t.Log(op)
+		}
+		t.Fatalf("history for key %s is not linearizable", res.Key)
+	}
+}
+
+func apply(m *concurrentmap.ConcurrentMap[string, int], op *Operation) {
+	switch op.Kind {
+	case Get:
+		op.Value, op.OK = m.Get(op.Key)
+	case Set:
+		m.Set(op.Key, op.Arg)
+	case Delete:
+		m.Delete(op.Key)
+	case LoadOrStore:
+		op.Value, op.OK = m.LoadOrStore(op.Key, op.Arg)
+	case Add:
+		m.Compute(op.Key, func(old int, exists bool) (int, bool) {
+			op.Value = old + op.Arg
+			return op.Value, true
+		})
+	case CompareAndSwap:
+		m.Compute(op.Key, func(old int, exists bool) (int, bool) {
+			if !exists || old != op.Arg {
+				return old, exists
+			}
+			op.OK = true
+			return op.Arg2, true
+		})
+	}
+}
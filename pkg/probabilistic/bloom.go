@@ -0,0 +1,115 @@
+package probabilistic
+
+import (
+	"math"
+	"sync"
+)
+
+// BloomFilter is a sharded Bloom filter over string keys. MayContain never
+// returns false for an added key; it returns true for an absent key with
+// roughly the false-positive rate the filter was sized for.
+type BloomFilter struct {
+	shards []bloomShard
+	bits   uint64 // bits per shard
+	k      int    // probes per key
+}
+
+type bloomShard struct {
+	mu   sync.RWMutex
+	bits []uint64
+	n    uint64 // keys added
+}
+
+// NewBloomFilter sizes a filter for expectedItems keys at the false-positive
+// rate fpRate (e.g. 0.01).
+func NewBloomFilter(expectedItems int, fpRate float64) *BloomFilter {
+	if expectedItems < 1 {
+		expectedItems = 1
+	}
+	if fpRate <= 0 || fpRate >= 1 {
+		fpRate = 0.01
+	}
+
+	// m = -n ln p / (ln 2)^2, k = m/n ln 2
+	m := math.Ceil(-float64(expectedItems) * math.Log(fpRate) / (math.Ln2 * math.Ln2))
+	k := max(1, int(math.Round(m/float64(expectedItems)*math.Ln2)))
+
+	shards := defaultShards
+	if expectedItems < 1024 {
+		shards = 1 // tiny filters would lose accuracy to shard imbalance
+	}
+	perShard := (uint64(m)/uint64(shards) + 63) &^ 63
+
+	bf := &BloomFilter{
+		shards: make([]bloomShard, shards),
+		bits:   perShard,
+		k:      k,
+	}
+	for i := range bf.shards {
+		bf.shards[i].bits = make([]uint64, perShard/64)
+	}
+	return bf
+}
+
+// probe returns the i-th bit position for h using Kirsch–Mitzenmacher
+// double hashing (g_i = h1 + i*h2), which is as good as k independent
+// hashes for Bloom filters.
+func (bf *BloomFilter) probe(h uint64, i int) uint64 {
+	h1, h2 := h&0xFFFFFFFF, h>>32|1
+	return (h1 + uint64(i)*h2) % bf.bits
+}
+
+// Add records key.
+func (bf *BloomFilter) Add(key string) {
+	h := hashKey(key)
+	s := &bf.shards[shardFor(h, len(bf.shards))]
+
+	s.mu.Lock()
+	for i := 0; i < bf.k; i++ {
+		p := bf.probe(h, i)
+		s.bits[p/64] |= 1 << (p % 64)
+	}
+	s.n++
+	s.mu.Unlock()
+}
+
+// MayContain reports whether key may have been added. False means the key
+// was definitely never added.
+func (bf *BloomFilter) MayContain(key string) bool {
+	h := hashKey(key)
+	s := &bf.shards[shardFor(h, len(bf.shards))]
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for i := 0; i < bf.k; i++ {
+		p := bf.probe(h, i)
+		if s.bits[p/64]&(1<<(p%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Count returns the number of Add calls, including repeats.
+func (bf *BloomFilter) Count() uint64 {
+	var n uint64
+	for i := range bf.shards {
+		s := &bf.shards[i]
+		s.mu.RLock()
+		n += s.n
+		s.mu.RUnlock()
+	}
+	return n
+}
+
+// Reset clears the filter.
+func (bf *BloomFilter) Reset() {
+	for i := range bf.shards {
+		s := &bf.shards[i]
+		s.mu.Lock()
+		clear(s.bits)
+		s.n = 0
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,117 @@
+package probabilistic
+
+import (
+	"math"
+	"sync"
+)
+
+// CountMinSketch estimates per-key counts in fixed memory. Estimates never
+// undercount; with probability 1-delta they overcount by at most
+// epsilon times the total count of the key's shard.
+type CountMinSketch struct {
+	shards []cmsShard
+	width  uint64
+	depth  int
+}
+
+type cmsShard struct {
+	mu    sync.Mutex
+	rows  [][]uint64
+	total uint64
+}
+
+// NewCountMinSketch creates a sketch with error bound epsilon (e.g. 0.001)
+// and failure probability delta (e.g. 0.01).
+func NewCountMinSketch(epsilon, delta float64) *CountMinSketch {
+	if epsilon <= 0 || epsilon >= 1 {
+		epsilon = 0.001
+	}
+	if delta <= 0 || delta >= 1 {
+		delta = 0.01
+	}
+
+	// Each shard only sees its own keys, so the bound applies per shard
+	// with the shard's (smaller) total.
+	width := uint64(math.Ceil(math.E / epsilon))
+	depth := int(math.Ceil(math.Log(1 / delta)))
+
+	cms := &CountMinSketch{
+		shards: make([]cmsShard, defaultShards),
+		width:  width,
+		depth:  depth,
+	}
+	for i := range cms.shards {
+		rows := make([][]uint64, depth)
+		for r := range rows {
+			rows[r] = make([]uint64, width)
+		}
+		cms.shards[i].rows = rows
+	}
+	return cms
+}
+
+func (cms *CountMinSketch) column(h uint64, row int) uint64 {
+	h1, h2 := h&0xFFFFFFFF, h>>32|1
+	return (h1 + uint64(row)*h2) % cms.width
+}
+
+// Add increments key's count by n and returns the new estimate.
+func (cms *CountMinSketch) Add(key string, n uint64) uint64 {
+	h := hashKey(key)
+	s := &cms.shards[shardFor(h, len(cms.shards))]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	est := uint64(math.MaxUint64)
+	for r, row := range s.rows {
+		c := &row[cms.column(h, r)]
+		*c += n
+		est = min(est, *c)
+	}
+	s.total += n
+	return est
+}
+
+// Estimate returns key's approximate count.
+func (cms *CountMinSketch) Estimate(key string) uint64 {
+	h := hashKey(key)
+	s := &cms.shards[shardFor(h, len(cms.shards))]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	est := uint64(math.MaxUint64)
+	for r, row := range s.rows {
+		est = min(est, row[cms.column(h, r)])
+	}
+	return est
+}
+
+// Total returns the sum of all counts added.
+func (cms *CountMinSketch) Total() uint64 {
+	var n uint64
+	for i := range cms.shards {
+		s := &cms.shards[i]
+		s.mu.Lock()
+		n += s.total
+		s.mu.Unlock()
+	}
+	return n
+}
+
+// Halve divides every counter by two. TinyLFU calls it periodically so
+// old popularity fades and recent access patterns dominate.
+func (cms *CountMinSketch) Halve() {
+	for i := range cms.shards {
+		s := &cms.shards[i]
+		s.mu.Lock()
+		for _, row := range s.rows {
+			for j := range row {
+				row[j] >>= 1
+			}
+		}
+		s.total >>= 1
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,26 @@
+// Package probabilistic provides concurrent approximate data structures:
+// a Bloom filter for cheap "definitely absent" answers, a count-min
+// sketch for frequency estimates (TinyLFU admission, heavy hitters) and a
+// HyperLogLog for distinct counts.
+//
+// The Bloom filter and the sketch are sharded like
+// concurrentmap.ConcurrentMap: a key's hash picks a shard with its own
+// lock, so writers to different shards never contend. A HyperLogLog
+// update is a single byte write, so it takes one lock.
+package probabilistic
+
+import "github.com/shubhamc1947/safemap/pkg/concurrentmap"
+
+const defaultShards = 32
+
+// hashKey is the hash every structure in this package uses. The high bits
+// pick the shard; the full value seeds the per-shard probe sequence.
+func hashKey(key string) uint64 {
+	return concurrentmap.XXHash64(key)
+}
+
+// shardFor maps h onto n shards using the top 32 bits, leaving the low
+// bits independent for the in-shard positions.
+func shardFor(h uint64, n int) int {
+	return int((h >> 32) * uint64(n) >> 32)
+}
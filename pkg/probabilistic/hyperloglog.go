@@ -0,0 +1,234 @@
+package probabilistic
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+	"sync"
+)
+
+// HyperLogLog precisions: a sketch has 2^precision registers, one byte
+// each in memory, and a standard error of about 1.04/sqrt(2^precision)
+// (0.81% at the default).
+const (
+	MinHLLPrecision     = 4
+	MaxHLLPrecision     = 18
+	DefaultHLLPrecision = 14
+)
+
+var (
+	// ErrPrecisionMismatch reports a Merge of sketches of different
+	// precisions.
+	ErrPrecisionMismatch = errors.New("probabilistic: HyperLogLog precisions differ")
+	// ErrInvalidHLL reports data UnmarshalBinary cannot decode.
+	ErrInvalidHLL = errors.New("probabilistic: invalid HyperLogLog encoding")
+)
+
+// HyperLogLog estimates the number of distinct strings added to it in
+// fixed memory. Sketches of the same precision can be merged, giving the
+// sketch of the union. It is safe for concurrent use.
+type HyperLogLog struct {
+	mu   sync.RWMutex
+	p    uint8
+	regs []uint8
+}
+
+// NewHyperLogLog creates an empty sketch with 2^precision registers. A
+// precision outside MinHLLPrecision-MaxHLLPrecision gets the default.
+func NewHyperLogLog(precision int) *HyperLogLog {
+	if precision < MinHLLPrecision || precision > MaxHLLPrecision {
+		precision = DefaultHLLPrecision
+	}
+	return &HyperLogLog{p: uint8(precision), regs: make([]uint8, 1<<precision)}
+}
+
+// Precision returns the sketch's precision.
+func (h *HyperLogLog) Precision() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return int(h.p)
+}
+
+// Add records item and reports whether the sketch changed; a repeated
+// item never changes it.
+func (h *HyperLogLog) Add(item string) bool {
+	x := hashKey(item)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	i := x >> (64 - h.p)
+	// The rank is the position of the first set bit after the index bits,
+	// capped for the all-zero case.
+	rank := uint8(min(bits.LeadingZeros64(x<<h.p), 64-int(h.p)) + 1)
+	if h.regs[i] >= rank {
+		return false
+	}
+	h.regs[i] = rank
+	return true
+}
+
+// Merge folds other into h, which then estimates the union of both.
+func (h *HyperLogLog) Merge(other *HyperLogLog) error {
+	other.mu.RLock()
+	p, regs := other.p, append([]uint8(nil), other.regs...)
+	other.mu.RUnlock()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.p != p {
+		return ErrPrecisionMismatch
+	}
+	for i, r := range regs {
+		h.regs[i] = max(h.regs[i], r)
+	}
+	return nil
+}
+
+// Count returns the estimated number of distinct items added, using
+// Ertl's improved estimator ("New cardinality estimation algorithms for
+// HyperLogLog sketches", 2017), which needs no bias tables and is
+// accurate from zero up.
+func (h *HyperLogLog) Count() uint64 {
+	h.mu.RLock()
+	q := 64 - int(h.p)
+	hist := make([]int, q+2)
+	for _, r := range h.regs {
+		hist[r]++
+	}
+	m := float64(len(h.regs))
+	h.mu.RUnlock()
+
+	z := m * hllTau(1-float64(hist[q+1])/m)
+	for k := q; k >= 1; k-- {
+		z = 0.5 * (z + float64(hist[k]))
+	}
+	z += m * hllSigma(float64(hist[0])/m)
+	return uint64(math.Round(m * m / (2 * math.Ln2 * z)))
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		prev := z
+		z += x * y
+		y += y
+		if z == prev {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		prev := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if z == prev {
+			return z / 3
+		}
+	}
+}
+
+// Encoding: "HLL", the precision, then the registers, either dense (one
+// byte each) or sparse (a uvarint count of non-zero registers, then each
+// as the uvarint gap from the previous one's index and its byte),
+// whichever is shorter.
+const (
+	hllMagic  = "HLL"
+	hllDense  = 'd'
+	hllSparse = 's'
+)
+
+// MarshalBinary encodes the sketch.
+func (h *HyperLogLog) MarshalBinary() ([]byte, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	sparse := []byte{}
+	n, last := 0, 0
+	for i, r := range h.regs {
+		if r == 0 {
+			continue
+		}
+		sparse = binary.AppendUvarint(sparse, uint64(i-last))
+		sparse = append(sparse, r)
+		n, last = n+1, i
+		if len(sparse) >= len(h.regs) {
+			break
+		}
+	}
+
+	out := append([]byte(hllMagic), h.p)
+	if len(sparse) >= len(h.regs) {
+		out = append(out, hllDense)
+		return append(out, h.regs...), nil
+	}
+	out = append(out, hllSparse)
+	out = binary.AppendUvarint(out, uint64(n))
+	return append(out, sparse...), nil
+}
+
+// UnmarshalBinary replaces the sketch with one encoded by MarshalBinary,
+// taking on its precision.
+func (h *HyperLogLog) UnmarshalBinary(data []byte) error {
+	if len(data) < len(hllMagic)+2 || string(data[:len(hllMagic)]) != hllMagic {
+		return ErrInvalidHLL
+	}
+	p := data[len(hllMagic)]
+	if p < MinHLLPrecision || p > MaxHLLPrecision {
+		return ErrInvalidHLL
+	}
+	q := 64 - int(p)
+	regs := make([]uint8, 1<<p)
+	body := data[len(hllMagic)+2:]
+	switch data[len(hllMagic)+1] {
+	case hllDense:
+		if len(body) != len(regs) {
+			return ErrInvalidHLL
+		}
+		copy(regs, body)
+	case hllSparse:
+		n, k := binary.Uvarint(body)
+		if k <= 0 || n > uint64(len(regs)) {
+			return ErrInvalidHLL
+		}
+		body = body[k:]
+		i := uint64(0)
+		for range n {
+			gap, k := binary.Uvarint(body)
+			if k <= 0 || k >= len(body) {
+				return ErrInvalidHLL
+			}
+			i += gap
+			if i >= uint64(len(regs)) {
+				return ErrInvalidHLL
+			}
+			regs[i], body = body[k], body[k+1:]
+		}
+		if len(body) != 0 {
+			return ErrInvalidHLL
+		}
+	default:
+		return ErrInvalidHLL
+	}
+	for _, r := range regs {
+		if int(r) > q+1 {
+			return ErrInvalidHLL
+		}
+	}
+
+	h.mu.Lock()
+	h.p, h.regs = p, regs
+	h.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,132 @@
+package probabilistic
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestBloomFilter(t *testing.T) {
+	const n = 10000
+	bf := NewBloomFilter(n, 0.01)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < n; i += 4 {
+				bf.Add("in-" + strconv.Itoa(i))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for i := 0; i < n; i++ {
+		if !bf.MayContain("in-" + strconv.Itoa(i)) {
+			t.Fatalf("false negative for in-%d", i)
+		}
+	}
+
+	fp := 0
+	for i := 0; i < n; i++ {
+		if bf.MayContain("out-" + strconv.Itoa(i)) {
+			fp++
+		}
+	}
+	if rate := float64(fp) / n; rate > 0.03 {
+		t.Fatalf("false-positive rate %.3f, expected about 0.01", rate)
+	}
+	if bf.Count() != n {
+		t.Fatalf("expected count %d, got %d", n, bf.Count())
+	}
+}
+
+func TestCountMinSketch(t *testing.T) {
+	cms := NewCountMinSketch(0.001, 0.01)
+
+	for i := 0; i < 1000; i++ {
+		cms.Add("hot", 1)
+		cms.Add("cold-"+strconv.Itoa(i), 1)
+	}
+
+	if est := cms.Estimate("hot"); est < 1000 || est > 1010 {
+		t.Fatalf("expected hot ≈ 1000, got %d", est)
+	}
+	if est := cms.Estimate("never"); est > 10 {
+		t.Fatalf("expected small estimate for unseen key, got %d", est)
+	}
+	if cms.Total() != 2000 {
+		t.Fatalf("expected total 2000, got %d", cms.Total())
+	}
+
+	cms.Halve()
+	if est := cms.Estimate("hot"); est < 500 || est > 505 {
+		t.Fatalf("expected hot ≈ 500 after halving, got %d", est)
+	}
+}
+
+func TestHyperLogLog(t *testing.T) {
+	for _, n := range []int{0, 1, 100, 5000, 200000} {
+		hll := NewHyperLogLog(DefaultHLLPrecision)
+		for i := 0; i < n; i++ {
+			hll.Add("user-" + strconv.Itoa(i))
+			hll.Add("user-" + strconv.Itoa(i)) // repeats do not count
+		}
+		est := float64(hll.Count())
+		if diff := est - float64(n); diff < -0.03*float64(n)-1 || diff > 0.03*float64(n)+1 {
+			t.Errorf("n=%d: estimate %.0f, expected within 3%%", n, est)
+		}
+	}
+
+	hll := NewHyperLogLog(DefaultHLLPrecision)
+	if !hll.Add("a") || hll.Add("a") {
+		t.Fatal("expected Add to report a change only the first time")
+	}
+}
+
+func TestHyperLogLogMerge(t *testing.T) {
+	a, b := NewHyperLogLog(12), NewHyperLogLog(12)
+	for i := 0; i < 3000; i++ {
+		a.Add("k" + strconv.Itoa(i))
+		b.Add("k" + strconv.Itoa(i+2000)) // 1000 shared
+	}
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if est := a.Count(); est < 4800 || est > 5200 {
+		t.Fatalf("expected union ≈ 5000, got %d", est)
+	}
+	if err := a.Merge(NewHyperLogLog(10)); err != ErrPrecisionMismatch {
+		t.Fatalf("expected ErrPrecisionMismatch, got %v", err)
+	}
+}
+
+func TestHyperLogLogEncoding(t *testing.T) {
+	for _, n := range []int{0, 10, 100000} { // sparse and dense
+		hll := NewHyperLogLog(DefaultHLLPrecision)
+		for i := 0; i < n; i++ {
+			hll.Add(strconv.Itoa(i))
+		}
+		data, err := hll.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 10 && len(data) > 64 {
+			t.Fatalf("expected a small sketch to encode sparsely, got %d bytes", len(data))
+		}
+		var got HyperLogLog
+		if err := got.UnmarshalBinary(data); err != nil {
+			t.Fatalf("n=%d: %v", n, err)
+		}
+		if got.Count() != hll.Count() || got.Precision() != DefaultHLLPrecision {
+			t.Fatalf("n=%d: round trip changed the sketch", n)
+		}
+	}
+
+	for _, bad := range [][]byte{nil, []byte("HLL"), []byte("XYZ\x0ed"), []byte("HLL\x0ed\x00"), []byte("HLL\x02d"), []byte("HLL\x0es\x02\x00")} {
+		if err := new(HyperLogLog).UnmarshalBinary(bad); err != ErrInvalidHLL {
+			t.Errorf("UnmarshalBinary(%q) = %v, want ErrInvalidHLL", bad, err)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+
+	"github.com/shubhamc1947/safemap/pkg/concurrentmap"
+)
+
+// ----------- Token Bucket -----------
+
+type tokenState struct {
+	tokens float64
+	last   int64 // unix nanos of the last refill
+}
+
+// TokenBucket refills limit tokens per period, holding at most burst.
+// Each request takes one token.
+type TokenBucket struct {
+	limit   int
+	burst   float64
+	perNano float64 // tokens added per nanosecond
+
+	buckets *concurrentmap.ConcurrentMap[string, tokenState]
+	sweep   sweeper
+	now     func() time.Time
+}
+
+// NewTokenBucket creates a token-bucket limiter. A non-positive burst
+// defaults to limit.
+func NewTokenBucket(limit int, period time.Duration, burst int) *TokenBucket {
+	if burst <= 0 {
+		burst = limit
+	}
+	tb := &TokenBucket{
+		limit:   limit,
+		burst:   float64(burst),
+		perNano: float64(limit) / float64(period),
+		buckets: concurrentmap.NewStringMap[tokenState](numBuckets),
+		now:     time.Now,
+	}
+	tb.sweep.interval = period
+	return tb
+}
+
+// Allow takes a token for key if one is available.
+func (tb *TokenBucket) Allow(key string) Result {
+	now := tb.now()
+	tb.sweep.maybe(now, tb.prune)
+	nowNano := now.UnixNano()
+
+	var tokens float64
+	allowed := false
+	tb.buckets.Compute(key, func(st tokenState, exists bool) (tokenState, bool) {
+		if !exists {
+			st = tokenState{tokens: tb.burst, last: nowNano}
+		}
+		st.tokens = math.Min(tb.burst, st.tokens+float64(nowNano-st.last)*tb.perNano)
+		st.last = nowNano
+		if st.tokens >= 1 {
+			st.tokens--
+			allowed = true
+		}
+		tokens = st.tokens
+		return st, true
+	})
+
+	res := Result{
+		Allowed:    allowed,
+		Limit:      tb.limit,
+		Remaining:  int(tokens),
+		ResetAfter: time.Duration((tb.burst - tokens) / tb.perNano),
+	}
+	if !allowed {
+		res.RetryAfter = time.Duration((1 - tokens) / tb.perNano)
+	}
+	return res
+}
+
+// prune drops buckets that have refilled completely; a missing bucket
+// behaves exactly like a full one.
+func (tb *TokenBucket) prune(now time.Time) {
+	nowNano := now.UnixNano()
+	var full []string
+	tb.buckets.Range(func(k string, st tokenState) bool {
+		if st.tokens+float64(nowNano-st.last)*tb.perNano >= tb.burst {
+			full = append(full, k)
+		}
+		return true
+	})
+	for _, k := range full {
+		tb.buckets.Compute(k, func(st tokenState, exists bool) (tokenState, bool) {
+			return st, exists && st.tokens+float64(nowNano-st.last)*tb.perNano < tb.burst
+		})
+	}
+}
+
+// ----------- GCRA -----------
+
+// GCRA implements the generic cell rate algorithm. Each key stores only
+// its theoretical arrival time (TAT): the time at which it would be back
+// to a full allowance if no further requests arrived.
+type GCRA struct {
+	limit     int
+	interval  int64 // nanos between requests at the sustained rate
+	tolerance int64 // nanos of burst allowed ahead of the sustained rate
+
+	tats  *concurrentmap.ConcurrentMap[string, int64]
+	sweep sweeper
+	now   func() time.Time
+}
+
+// NewGCRA creates a GCRA limiter sustaining limit requests per period
+// with bursts of up to burst requests. A non-positive burst defaults to
+// limit.
+func NewGCRA(limit int, period time.Duration, burst int) *GCRA {
+	if burst <= 0 {
+		burst = limit
+	}
+	interval := int64(period) / int64(limit)
+	g := &GCRA{
+		limit:     limit,
+		interval:  interval,
+		tolerance: interval * int64(burst),
+		tats:      concurrentmap.NewStringMap[int64](numBuckets),
+		now:       time.Now,
+	}
+	g.sweep.interval = period
+	return g
+}
+
+// Allow admits a request for key if it conforms to the rate.
+func (g *GCRA) Allow(key string) Result {
+	now := g.now()
+	g.sweep.maybe(now, g.prune)
+	t := now.UnixNano()
+
+	var newTAT int64
+	allowed := false
+	g.tats.Compute(key, func(tat int64, exists bool) (int64, bool) {
+		if !exists || tat < t {
+			tat = t
+		}
+		newTAT = tat + g.interval
+		if newTAT-t > g.tolerance {
+			newTAT = tat
+			return tat, exists
+		}
+		allowed = true
+		return newTAT, true
+	})
+
+	res := Result{
+		Allowed:    allowed,
+		Limit:      g.limit,
+		Remaining:  int((g.tolerance - (newTAT - t)) / g.interval),
+		ResetAfter: time.Duration(max(0, newTAT-t)),
+	}
+	if !allowed {
+		res.Remaining = 0
+		res.RetryAfter = time.Duration(newTAT + g.interval - g.tolerance - t)
+	}
+	return res
+}
+
+// prune drops keys whose TAT has passed; they are at full allowance.
+func (g *GCRA) prune(now time.Time) {
+	t := now.UnixNano()
+	var idle []string
+	g.tats.Range(func(k string, tat int64) bool {
+		if tat <= t {
+			idle = append(idle, k)
+		}
+		return true
+	})
+	for _, k := range idle {
+		g.tats.Compute(k, func(tat int64, exists bool) (int64, bool) {
+			return tat, exists && tat > t
+		})
+	}
+}
@@ -0,0 +1,107 @@
+// Package ratelimit provides per-key rate limiters that scale across
+// cores: all state lives in sharded concurrentmap structures, so clients
+// hashing to different shards never contend on a lock.
+//
+// Four algorithms are available behind the Limiter interface:
+//
+//   - FixedWindow: at most limit requests per aligned window. Cheapest,
+//     but allows up to 2×limit across a window boundary.
+//   - SlidingWindow: weights the previous window's count by how much of it
+//     still overlaps, smoothing the boundary burst.
+//   - TokenBucket: refills limit tokens per period up to burst, allowing
+//     short bursts while enforcing the long-run rate.
+//   - GCRA: the generic cell rate algorithm; token-bucket semantics with a
+//     single timestamp of state per key.
+package ratelimit
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Result describes the outcome of one Allow call, with enough detail to
+// fill X-RateLimit-* and Retry-After headers.
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	ResetAfter time.Duration // until the key is back to its full allowance
+	RetryAfter time.Duration // until the next request would be allowed; 0 if Allowed
+}
+
+// Limiter decides whether the request identified by key may proceed.
+type Limiter interface {
+	Allow(key string) Result
+}
+
+// Algorithm names a limiter implementation for New.
+type Algorithm string
+
+const (
+	AlgFixedWindow   Algorithm = "fixed"
+	AlgSlidingWindow Algorithm = "sliding"
+	AlgTokenBucket   Algorithm = "token-bucket"
+	AlgGCRA          Algorithm = "gcra"
+)
+
+// New creates a limiter allowing limit requests per period with the given
+// algorithm. The bucket-based algorithms use limit as their burst.
+func New(alg Algorithm, limit int, period time.Duration) (Limiter, error) {
+	if limit <= 0 || period <= 0 {
+		return nil, fmt.Errorf("ratelimit: limit and period must be positive, got %d per %s", limit, period)
+	}
+	switch alg {
+	case AlgFixedWindow, "":
+		return NewFixedWindow(limit, period), nil
+	case AlgSlidingWindow:
+		return NewSlidingWindow(limit, period), nil
+	case AlgTokenBucket:
+		return NewTokenBucket(limit, period, limit), nil
+	case AlgGCRA:
+		return NewGCRA(limit, period, limit), nil
+	default:
+		return nil, fmt.Errorf("ratelimit: unknown algorithm %q", alg)
+	}
+}
+
+// NewWithStore is New for limiters whose state lives in store, so that
+// several processes share it. Only the window algorithms are supported:
+// their state is a plain counter, which any store can increment
+// atomically.
+func NewWithStore(alg Algorithm, store CounterStore, limit int, period time.Duration) (Limiter, error) {
+	if limit <= 0 || period <= 0 {
+		return nil, fmt.Errorf("ratelimit: limit and period must be positive, got %d per %s", limit, period)
+	}
+	switch alg {
+	case AlgFixedWindow, "":
+		return NewFixedWindowStore(store, limit, period), nil
+	case AlgSlidingWindow:
+		return NewSlidingWindowStore(store, limit, period), nil
+	default:
+		return nil, fmt.Errorf("ratelimit: algorithm %q cannot use a counter store", alg)
+	}
+}
+
+const numBuckets = 64
+
+// sweeper runs a cleanup at most once per interval, from whichever caller
+// first notices it is due, so idle keys don't accumulate forever without
+// a background goroutine.
+type sweeper struct {
+	interval time.Duration
+	next     atomic.Int64 // unix nanos
+}
+
+func (s *sweeper) maybe(now time.Time, sweep func(now time.Time)) {
+	next := s.next.Load()
+	if now.UnixNano() < next {
+		return
+	}
+	if !s.next.CompareAndSwap(next, now.Add(s.interval).UnixNano()) {
+		return // another caller is sweeping
+	}
+	if next != 0 {
+		go sweep(now)
+	}
+}
@@ -0,0 +1,187 @@
+package ratelimit
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newClock() *fakeClock {
+	return &fakeClock{t: time.Unix(1_700_000_000, 0)}
+}
+
+func TestFixedWindow(t *testing.T) {
+	clk := newClock()
+	fw := NewFixedWindow(3, time.Second)
+	fw.wc.now = clk.now
+
+	for i := 0; i < 3; i++ {
+		if res := fw.Allow("a"); !res.Allowed || res.Remaining != 2-i {
+			t.Fatalf("request %d: unexpected %+v", i, res)
+		}
+	}
+	res := fw.Allow("a")
+	if res.Allowed || res.RetryAfter <= 0 || res.RetryAfter > time.Second {
+		t.Fatalf("expected 4th request to be denied with a retry hint, got %+v", res)
+	}
+	if !fw.Allow("b").Allowed {
+		t.Fatalf("keys must be limited independently")
+	}
+
+	clk.advance(time.Second)
+	if !fw.Allow("a").Allowed {
+		t.Fatalf("expected a fresh window to admit requests")
+	}
+}
+
+func TestSlidingWindowSmoothsBoundary(t *testing.T) {
+	clk := newClock()
+	sw := NewSlidingWindow(10, time.Second)
+	sw.wc.now = clk.now
+
+	for i := 0; i < 10; i++ {
+		sw.Allow("a")
+	}
+	// Just after the boundary almost all of the previous window still
+	// overlaps, so a fixed window's fresh burst must not be allowed.
+	clk.advance(time.Second + 100*time.Millisecond)
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if sw.Allow("a").Allowed {
+			allowed++
+		}
+	}
+	if allowed != 1 {
+		t.Fatalf("expected 1 request admitted right after the boundary, got %d", allowed)
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	clk := newClock()
+	tb := NewTokenBucket(10, time.Second, 5)
+	tb.now = clk.now
+
+	for i := 0; i < 5; i++ {
+		if !tb.Allow("a").Allowed {
+			t.Fatalf("burst request %d denied", i)
+		}
+	}
+	res := tb.Allow("a")
+	if res.Allowed || res.RetryAfter != 100*time.Millisecond {
+		t.Fatalf("expected denial with 100ms retry, got %+v", res)
+	}
+
+	clk.advance(100 * time.Millisecond)
+	if !tb.Allow("a").Allowed {
+		t.Fatalf("expected one token after 100ms")
+	}
+}
+
+func TestGCRA(t *testing.T) {
+	clk := newClock()
+	g := NewGCRA(10, time.Second, 3)
+	g.now = clk.now
+
+	for i := 0; i < 3; i++ {
+		if res := g.Allow("a"); !res.Allowed || res.Remaining != 2-i {
+			t.Fatalf("burst request %d: unexpected %+v", i, res)
+		}
+	}
+	res := g.Allow("a")
+	if res.Allowed || res.RetryAfter != 100*time.Millisecond {
+		t.Fatalf("expected denial with 100ms retry, got %+v", res)
+	}
+
+	clk.advance(100 * time.Millisecond)
+	if !g.Allow("a").Allowed {
+		t.Fatalf("expected request admitted once the interval elapsed")
+	}
+}
+
+func TestLimitersAreConcurrencySafe(t *testing.T) {
+	for _, alg := range []Algorithm{AlgFixedWindow, AlgSlidingWindow, AlgTokenBucket, AlgGCRA} {
+		l, err := New(alg, 100, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var (
+			wg      sync.WaitGroup
+			mu      sync.Mutex
+			allowed int
+		)
+		for w := 0; w < 8; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 50; i++ {
+					if l.Allow("shared").Allowed {
+						mu.Lock()
+						allowed++
+						mu.Unlock()
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		if allowed != 100 {
+			t.Fatalf("%s: expected exactly 100 admitted, got %d", alg, allowed)
+		}
+	}
+
+	if _, err := New("leaky", 1, time.Second); err == nil {
+		t.Fatalf("expected unknown algorithm to be rejected")
+	}
+}
+
+// mapCounterStore is a CounterStore shared by several limiters, standing
+// in for a replicated key-value store.
+type mapCounterStore struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+func (s *mapCounterStore) Add(key string, delta int64, _ time.Time) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counts[key] += delta
+	return s.counts[key]
+}
+
+func (s *mapCounterStore) Load(key string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts[key]
+}
+
+func TestStoreBackedLimitersShareCounters(t *testing.T) {
+	store := &mapCounterStore{counts: make(map[string]int64)}
+	a, err := NewWithStore(AlgSlidingWindow, store, 4, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := NewWithStore(AlgSlidingWindow, store, 4, time.Hour)
+
+	allowed := 0
+	for i := 0; i < 4; i++ {
+		if a.Allow("client").Allowed {
+			allowed++
+		}
+		if b.Allow("client").Allowed {
+			allowed++
+		}
+	}
+	if allowed != 4 {
+		t.Fatalf("expected both instances to share one limit of 4, admitted %d", allowed)
+	}
+
+	if _, err := NewWithStore(AlgGCRA, store, 4, time.Hour); err == nil {
+		t.Fatalf("expected GCRA to be rejected for counter stores")
+	}
+}
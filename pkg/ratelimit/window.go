@@ -0,0 +1,211 @@
+package ratelimit
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/shubhamc1947/safemap/pkg/concurrentmap"
+)
+
+// windowKey identifies one client's counter in one window.
+type windowKey struct {
+	key    string
+	window int64
+}
+
+func hashWindowKey(k windowKey) uint64 {
+	return concurrentmap.FNV64a(k.key) ^ uint64(k.window)*0x9E3779B97F4A7C15
+}
+
+// CounterStore keeps window counters outside the limiter, e.g. in a
+// key-value store shared or replicated between server instances, so that
+// they enforce one limit together. Implementations must make Add atomic.
+type CounterStore interface {
+	// Add adds delta to key's counter, starting from zero if it is missing
+	// or expired, and returns the new value. The counter may be discarded
+	// after expiresAt.
+	Add(key string, delta int64, expiresAt time.Time) int64
+	// Load returns key's counter, or 0 if it is missing or expired.
+	Load(key string) int64
+}
+
+// windowCounts holds per-window request counts, in a sharded CounterMap
+// or in an external CounterStore.
+type windowCounts struct {
+	counts *concurrentmap.CounterMap[windowKey]
+	ext    CounterStore
+	period time.Duration
+	sweep  sweeper
+	now    func() time.Time
+}
+
+func (wc *windowCounts) init(period time.Duration, ext CounterStore) {
+	if ext == nil {
+		wc.counts = concurrentmap.NewCounterMap[windowKey](numBuckets, hashWindowKey)
+	}
+	wc.ext = ext
+	wc.period = period
+	wc.now = time.Now
+	wc.sweep.interval = period
+}
+
+// storeKey names k's counter in an external store.
+func storeKey(k windowKey) string {
+	return k.key + "/" + strconv.FormatInt(k.window, 10)
+}
+
+func (wc *windowCounts) inc(k windowKey, delta int64) int64 {
+	if wc.ext != nil {
+		// Keep each counter for one extra period so SlidingWindow can
+		// still weight it as the previous window.
+		expires := time.Unix(0, (k.window+2)*int64(wc.period))
+		return wc.ext.Add(storeKey(k), delta, expires)
+	}
+	return wc.counts.Inc(k, delta)
+}
+
+func (wc *windowCounts) get(k windowKey) int64 {
+	if wc.ext != nil {
+		return wc.ext.Load(storeKey(k))
+	}
+	n, _ := wc.counts.Get(k)
+	return n
+}
+
+// current returns the index of the window containing now and how far into
+// it now is.
+func (wc *windowCounts) current(now time.Time) (int64, time.Duration) {
+	n := now.UnixNano()
+	p := int64(wc.period)
+	return n / p, time.Duration(n % p)
+}
+
+// prune drops counters for windows that can no longer be consulted.
+// External stores expire them on their own.
+func (wc *windowCounts) prune(now time.Time) {
+	if wc.ext != nil {
+		return
+	}
+	cur, _ := wc.current(now)
+	var stale []windowKey
+	wc.counts.Range(func(k windowKey, _ int64) bool {
+		if k.window < cur-1 {
+			stale = append(stale, k)
+		}
+		return true
+	})
+	for _, k := range stale {
+		wc.counts.Delete(k)
+	}
+}
+
+// ----------- Fixed Window -----------
+
+// FixedWindow allows limit requests per key in each period-aligned window.
+type FixedWindow struct {
+	limit int
+	wc    windowCounts
+}
+
+// NewFixedWindow creates a fixed-window limiter.
+func NewFixedWindow(limit int, period time.Duration) *FixedWindow {
+	return NewFixedWindowStore(nil, limit, period)
+}
+
+// NewFixedWindowStore creates a fixed-window limiter keeping its counters
+// in store. A nil store keeps them in memory.
+func NewFixedWindowStore(store CounterStore, limit int, period time.Duration) *FixedWindow {
+	fw := &FixedWindow{limit: limit}
+	fw.wc.init(period, store)
+	return fw
+}
+
+// Allow counts a request for key.
+func (fw *FixedWindow) Allow(key string) Result {
+	now := fw.wc.now()
+	fw.wc.sweep.maybe(now, fw.wc.prune)
+
+	cur, elapsed := fw.wc.current(now)
+	reset := fw.wc.period - elapsed
+	res := Result{Limit: fw.limit, ResetAfter: reset}
+
+	k := windowKey{key: key, window: cur}
+	n := fw.wc.inc(k, 1)
+	if n > int64(fw.limit) {
+		fw.wc.inc(k, -1) // rejected requests don't use up the window
+		res.RetryAfter = reset
+		return res
+	}
+
+	res.Allowed = true
+	res.Remaining = fw.limit - int(n)
+	return res
+}
+
+// ----------- Sliding Window -----------
+
+// SlidingWindow approximates a true sliding window by weighting the
+// previous fixed window's count by the fraction of it still inside the
+// last period. It needs two counters per key instead of a log of
+// timestamps.
+type SlidingWindow struct {
+	limit int
+	wc    windowCounts
+}
+
+// NewSlidingWindow creates a sliding-window limiter.
+func NewSlidingWindow(limit int, period time.Duration) *SlidingWindow {
+	return NewSlidingWindowStore(nil, limit, period)
+}
+
+// NewSlidingWindowStore creates a sliding-window limiter keeping its
+// counters in store. A nil store keeps them in memory.
+func NewSlidingWindowStore(store CounterStore, limit int, period time.Duration) *SlidingWindow {
+	sw := &SlidingWindow{limit: limit}
+	sw.wc.init(period, store)
+	return sw
+}
+
+// Allow counts a request for key.
+func (sw *SlidingWindow) Allow(key string) Result {
+	now := sw.wc.now()
+	sw.wc.sweep.maybe(now, sw.wc.prune)
+
+	cur, elapsed := sw.wc.current(now)
+	period := sw.wc.period
+	overlap := float64(period-elapsed) / float64(period)
+
+	prev := sw.wc.get(windowKey{key: key, window: cur - 1})
+	k := windowKey{key: key, window: cur}
+	n := sw.wc.inc(k, 1)
+
+	used := float64(prev)*overlap + float64(n)
+	res := Result{Limit: sw.limit, ResetAfter: 2*period - elapsed}
+
+	if used > float64(sw.limit) {
+		sw.wc.inc(k, -1)
+		res.RetryAfter = sw.retryAfter(prev, n-1, elapsed)
+		return res
+	}
+
+	res.Allowed = true
+	res.Remaining = max(0, int(float64(sw.limit)-used))
+	return res
+}
+
+// retryAfter estimates when the previous window's weight will have decayed
+// enough to admit one more request, or else the start of the next window.
+func (sw *SlidingWindow) retryAfter(prev, cur int64, elapsed time.Duration) time.Duration {
+	period := sw.wc.period
+	if prev > 0 {
+		// Solve prev*(period-t)/period + cur + 1 <= limit for t.
+		room := float64(int64(sw.limit) - cur - 1)
+		if room >= 0 {
+			t := time.Duration((1 - room/float64(prev)) * float64(period))
+			if t > elapsed {
+				return t - elapsed
+			}
+		}
+	}
+	return period - elapsed
+}
@@ -0,0 +1,284 @@
+// Package snappy implements the Snappy block format, the compression
+// kv-server applies to large stored values. It favours speed over ratio:
+// encoding is a single greedy pass with a hash table of recent positions.
+//
+// Only the block format is implemented, not the framing format used for
+// streams; output can be read by any Snappy implementation and vice versa.
+// See https://github.com/google/snappy/blob/main/format_description.txt.
+package snappy
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	// ErrCorrupt reports input that is not valid Snappy data.
+	ErrCorrupt = errors.New("snappy: corrupt input")
+	// ErrTooLarge reports a decoded length over MaxDecodedLen.
+	ErrTooLarge = errors.New("snappy: decoded block is too large")
+)
+
+// MaxDecodedLen is the longest block Decode accepts.
+const MaxDecodedLen = 1<<32 - 1
+
+const (
+	tagLiteral = 0x00
+	tagCopy1   = 0x01
+	tagCopy2   = 0x02
+	tagCopy4   = 0x03
+)
+
+const (
+	maxBlockSize = 1 << 16 // input is encoded in blocks with 2-byte offsets
+	inputMargin  = 16 - 1  // encodeBlock may read this far past a match
+	minBlockSize = 1 + 1 + inputMargin
+
+	tableBits = 14
+	tableSize = 1 << tableBits
+)
+
+// MaxEncodedLen returns the longest encoding of n bytes, or -1 if n is
+// too large to encode.
+func MaxEncodedLen(n int) int {
+	if uint64(n) > MaxDecodedLen {
+		return -1
+	}
+	// A varint header, then in the worst case every 6 bytes of input
+	// cost a 1-byte literal tag as well.
+	return 32 + n + n/6
+}
+
+// Encode returns the encoding of src, reusing dst if it is large enough.
+func Encode(dst, src []byte) []byte {
+	n := MaxEncodedLen(len(src))
+	if n < 0 {
+		panic(ErrTooLarge)
+	}
+	if cap(dst) < n {
+		dst = make([]byte, n)
+	}
+	dst = dst[:n]
+
+	d := binary.PutUvarint(dst, uint64(len(src)))
+	for len(src) > 0 {
+		p := src
+		if len(p) > maxBlockSize {
+			p = p[:maxBlockSize]
+		}
+		if len(p) < minBlockSize {
+			d += emitLiteral(dst[d:], p)
+		} else {
+			d += encodeBlock(dst[d:], p)
+		}
+		src = src[len(p):]
+	}
+	return dst[:d]
+}
+
+// DecodedLen returns the length of the decoding of src.
+func DecodedLen(src []byte) (int, error) {
+	n, _, err := decodedLen(src)
+	return n, err
+}
+
+func decodedLen(src []byte) (n, header int, err error) {
+	v, header := binary.Uvarint(src)
+	if header <= 0 {
+		return 0, 0, ErrCorrupt
+	}
+	if v > MaxDecodedLen || uint64(int(v)) != v {
+		return 0, 0, ErrTooLarge
+	}
+	return int(v), header, nil
+}
+
+// Decode returns the decoding of src, reusing dst if it is large enough.
+func Decode(dst, src []byte) ([]byte, error) {
+	n, s, err := decodedLen(src)
+	if err != nil {
+		return nil, err
+	}
+	if cap(dst) < n {
+		dst = make([]byte, n)
+	}
+	dst = dst[:n]
+
+	d := 0
+	for s < len(src) {
+		var length, offset int
+		switch src[s] & 0x03 {
+		case tagLiteral:
+			x := uint32(src[s] >> 2)
+			switch {
+			case x < 60:
+				s++
+			case x <= 63:
+				extra := int(x - 59) // bytes of length that follow
+				if s+1+extra > len(src) {
+					return nil, ErrCorrupt
+				}
+				x = 0
+				for i := extra; i > 0; i-- {
+					x = x<<8 | uint32(src[s+i])
+				}
+				s += 1 + extra
+			}
+			length = int(x) + 1
+			if length <= 0 || length > len(dst)-d || length > len(src)-s {
+				return nil, ErrCorrupt
+			}
+			copy(dst[d:], src[s:s+length])
+			d += length
+			s += length
+			continue
+		case tagCopy1:
+			if s+2 > len(src) {
+				return nil, ErrCorrupt
+			}
+			length = 4 + int(src[s]>>2&0x07)
+			offset = int(src[s]&0xe0)<<3 | int(src[s+1])
+			s += 2
+		case tagCopy2:
+			if s+3 > len(src) {
+				return nil, ErrCorrupt
+			}
+			length = 1 + int(src[s]>>2)
+			offset = int(binary.LittleEndian.Uint16(src[s+1:]))
+			s += 3
+		case tagCopy4:
+			if s+5 > len(src) {
+				return nil, ErrCorrupt
+			}
+			length = 1 + int(src[s]>>2)
+			offset = int(binary.LittleEndian.Uint32(src[s+1:]))
+			s += 5
+		}
+		if offset <= 0 || d < offset || length > len(dst)-d {
+			return nil, ErrCorrupt
+		}
+		// The source may overlap what is being written, repeating a run.
+		for end := d + length; d < end; d++ {
+			dst[d] = dst[d-offset]
+		}
+	}
+	if d != len(dst) {
+		return nil, ErrCorrupt
+	}
+	return dst, nil
+}
+
+func emitLiteral(dst, lit []byte) int {
+	i, n := 0, uint(len(lit)-1)
+	switch {
+	case n < 60:
+		dst[0] = uint8(n)<<2 | tagLiteral
+		i = 1
+	case n < 1<<8:
+		dst[0] = 60<<2 | tagLiteral
+		dst[1] = uint8(n)
+		i = 2
+	default: // blocks are at most 1<<16 long
+		dst[0] = 61<<2 | tagLiteral
+		dst[1] = uint8(n)
+		dst[2] = uint8(n >> 8)
+		i = 3
+	}
+	return i + copy(dst[i:], lit)
+}
+
+// emitCopy writes a copy of length bytes from offset back, 1 <= offset
+// < 1<<16 and length >= 4.
+func emitCopy(dst []byte, offset, length int) int {
+	i := 0
+	// Long copies are split so that none left is shorter than 4.
+	for length >= 68 {
+		dst[i] = 63<<2 | tagCopy2
+		binary.LittleEndian.PutUint16(dst[i+1:], uint16(offset))
+		i += 3
+		length -= 64
+	}
+	if length > 64 {
+		dst[i] = 59<<2 | tagCopy2
+		binary.LittleEndian.PutUint16(dst[i+1:], uint16(offset))
+		i += 3
+		length -= 60
+	}
+	if length >= 12 || offset >= 2048 {
+		dst[i] = uint8(length-1)<<2 | tagCopy2
+		binary.LittleEndian.PutUint16(dst[i+1:], uint16(offset))
+		return i + 3
+	}
+	dst[i] = uint8(offset>>8)<<5 | uint8(length-4)<<2 | tagCopy1
+	dst[i+1] = uint8(offset)
+	return i + 2
+}
+
+func hash(u uint32) uint32 {
+	return u * 0x1e35a7bd >> (32 - tableBits)
+}
+
+// encodeBlock encodes src, minBlockSize <= len(src) <= maxBlockSize.
+func encodeBlock(dst, src []byte) int {
+	var table [tableSize]uint16 // last position of each hashed 4 bytes
+	load32 := func(i int) uint32 { return binary.LittleEndian.Uint32(src[i:]) }
+
+	d := 0
+	sLimit := len(src) - inputMargin
+	nextEmit := 0
+	s := 1
+	nextHash := hash(load32(s))
+	for {
+		// Look for a match, stepping faster the longer none is found,
+		// so incompressible input is skipped over quickly.
+		skip := 32
+		nextS := s
+		candidate := 0
+		for {
+			s = nextS
+			step := skip >> 5
+			nextS = s + step
+			skip += step
+			if nextS > sLimit {
+				goto remainder
+			}
+			candidate = int(table[nextHash])
+			table[nextHash] = uint16(s)
+			nextHash = hash(load32(nextS))
+			if load32(s) == load32(candidate) {
+				break
+			}
+		}
+
+		d += emitLiteral(dst[d:], src[nextEmit:s])
+
+		// Emit copies for as long as the next bytes match again.
+		for {
+			base := s
+			s += 4
+			for i := candidate + 4; s < len(src) && src[i] == src[s]; i, s = i+1, s+1 {
+			}
+			d += emitCopy(dst[d:], base-candidate, s-base)
+			nextEmit = s
+			if s >= sLimit {
+				goto remainder
+			}
+			x := binary.LittleEndian.Uint64(src[s-1:])
+			table[hash(uint32(x))] = uint16(s - 1)
+			h := hash(uint32(x >> 8))
+			candidate = int(table[h])
+			table[h] = uint16(s)
+			if uint32(x>>8) != load32(candidate) {
+				nextHash = hash(uint32(x >> 16))
+				s++
+				break
+			}
+		}
+	}
+
+remainder:
+	if nextEmit < len(src) {
+		d += emitLiteral(dst[d:], src[nextEmit:])
+	}
+	return d
+}
@@ -0,0 +1,93 @@
+package snappy
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	random := make([]byte, 100000)
+	r.Read(random)
+	text := []byte(strings.Repeat("the quick brown fox jumps over the lazy dog. ", 3000))
+
+	for _, in := range [][]byte{
+		nil,
+		[]byte("a"),
+		[]byte("abcdefghijklmnopq"), // shortest input encoded as a block
+		bytes.Repeat([]byte{'x'}, 1000),
+		random,
+		text,
+		append(append([]byte{}, random[:maxBlockSize+7]...), text...), // across blocks
+	} {
+		enc := Encode(nil, in)
+		if len(enc) > MaxEncodedLen(len(in)) {
+			t.Fatalf("len %d: encoded to %d bytes, over MaxEncodedLen", len(in), len(enc))
+		}
+		if n, err := DecodedLen(enc); err != nil || n != len(in) {
+			t.Fatalf("len %d: DecodedLen = %d, %v", len(in), n, err)
+		}
+		out, err := Decode(nil, enc)
+		if err != nil || !bytes.Equal(out, in) {
+			t.Fatalf("len %d: round trip failed: %v", len(in), err)
+		}
+	}
+
+	if enc := Encode(nil, text); len(enc) > len(text)/10 {
+		t.Errorf("repetitive text compressed only to %d of %d bytes", len(enc), len(text))
+	}
+}
+
+func TestDecodeKnown(t *testing.T) {
+	cases := []struct {
+		enc  []byte
+		want string
+	}{
+		{[]byte{0x00}, ""},
+		{[]byte{0x03, 0x08, 'a', 'b', 'c'}, "abc"},
+		// "ab", then a 1-byte-offset copy of 4 from 2 back: the copy
+		// overlaps what it writes.
+		{[]byte{0x06, 0x04, 'a', 'b', 0x01, 0x02}, "ababab"},
+		// 2-byte-offset copy of 3 from 3 back.
+		{[]byte{0x06, 0x08, 'x', 'y', 'z', 0x0a, 0x03, 0x00}, "xyzxyz"},
+		// Literal with its length in one extra byte.
+		{append([]byte{0x40, 0xf0, 0x3f}, bytes.Repeat([]byte{'q'}, 64)...), strings.Repeat("q", 64)},
+	}
+	for _, tc := range cases {
+		out, err := Decode(nil, tc.enc)
+		if err != nil || string(out) != tc.want {
+			t.Errorf("Decode(% x) = %q, %v; want %q", tc.enc, out, err, tc.want)
+		}
+	}
+}
+
+func TestDecodeCorrupt(t *testing.T) {
+	for _, enc := range [][]byte{
+		{},                                 // no header
+		{0x80},                             // truncated header
+		{0x03, 0x08, 'a'},                  // literal past the end
+		{0x02, 0x04, 'a'},                  // shorter than the header says
+		{0x02, 0x04, 'a', 'b', 'c'},        // longer than the header says
+		{0x04, 0x01, 0x01},                 // copy before any output
+		{0x06, 0x04, 'a', 'b', 0x01},       // truncated copy
+		{0x05, 0x04, 'a', 'b', 0x01, 0x02}, // copy past the end of the output
+	} {
+		if _, err := Decode(nil, enc); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("Decode(% x) error = %v, want ErrCorrupt", enc, err)
+		}
+	}
+	if _, err := Decode(nil, []byte{0xff, 0xff, 0xff, 0xff, 0x7f}); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("oversized header: error = %v, want ErrTooLarge", err)
+	}
+}
+
+func TestEncodeReusesDst(t *testing.T) {
+	in := []byte(strings.Repeat("reuse ", 100))
+	dst := make([]byte, MaxEncodedLen(len(in)))
+	if enc := Encode(dst, in); &enc[0] != &dst[0] {
+		t.Errorf("Encode allocated despite a large enough dst")
+	}
+}